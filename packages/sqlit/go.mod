@@ -14,6 +14,7 @@ require (
 	github.com/dghubble/sling v1.4.0
 	github.com/ethereum/go-ethereum v1.8.27
 	github.com/fortytw2/leaktest v1.3.0
+	github.com/fsnotify/fsnotify v1.7.0
 	github.com/gin-contrib/cors v1.3.0
 	github.com/gin-gonic/gin v1.4.0
 	github.com/go-gorp/gorp v2.0.1-0.20180226155812-4df78490a9aa+incompatible
@@ -28,6 +29,7 @@ require (
 	github.com/jordwest/mock-conn v0.0.0-20180617021051-4896c6bd1641
 	github.com/lufia/iostat v0.0.0-20170605150913-9f7362b77ad3
 	github.com/mattn/go-sqlite3 v1.14.24
+	github.com/miekg/pkcs11 v1.1.1
 	github.com/minio/blake2b-simd v0.0.0-20160723061019-3f5f724cb5b1
 	github.com/mohae/deepcopy v0.0.0-20170929034955-c48cc78d4826
 	github.com/pkg/errors v0.9.1
@@ -43,13 +45,16 @@ require (
 	github.com/sourcegraph/jsonrpc2 v0.0.0-20190106185902-35a74f039c6a
 	github.com/syndtr/goleveldb v1.0.0
 	github.com/tchap/go-patricia v2.3.0+incompatible
+	github.com/tyler-smith/go-bip39 v1.1.0
 	github.com/ugorji/go v1.1.4
 	github.com/xo/dburl v0.0.0-20190203050942-98997a05b24f
 	github.com/xo/usql v0.7.4
 	github.com/xtaci/smux v1.3.4-0.20190522035559-79b3c96b84d1
 	github.com/xwb1989/sqlparser v0.0.0-20180606152119-120387863bf2
 	github.com/zserge/metric v0.1.1-0.20190429132510-b0b64cb7bfea
+	go.etcd.io/bbolt v1.3.9
 	golang.org/x/crypto v0.31.0
+	golang.org/x/net v0.33.0
 	golang.org/x/oauth2 v0.24.0
 	golang.org/x/sys v0.28.0
 	golang.org/x/term v0.27.0
@@ -113,7 +118,6 @@ require (
 	go.uber.org/multierr v1.11.0 // indirect
 	go.uber.org/zap v1.26.0 // indirect
 	golang.org/x/exp v0.0.0-20240205201215-2c58cdc269a3 // indirect
-	golang.org/x/net v0.33.0 // indirect
 	golang.org/x/text v0.21.0 // indirect
 	google.golang.org/api v0.6.0 // indirect
 	google.golang.org/appengine v1.5.0 // indirect
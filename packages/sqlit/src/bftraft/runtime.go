@@ -52,6 +52,8 @@ type Runtime struct {
 	role proto.ServerRole
 	// cached followers in peers, calculated from peers info.
 	followers []proto.NodeID
+	// cached observers in peers, they receive logs but never count in thresholds.
+	observers []proto.NodeID
 	// peers lock for peers update logic.
 	peersLock sync.RWMutex
 	// calculated min follower nodes for prepare.
@@ -182,6 +184,20 @@ func NewRuntime(cfg *kt.RuntimeConfig) (rt *Runtime, err error) {
 		}
 	}
 
+	// observers follow the leader log commits without voting
+	observers := make([]proto.NodeID, 0, len(peers.Observers))
+	for _, v := range peers.Observers {
+		if _, found := peers.Find(v); found {
+			continue
+		}
+		observers = append(observers, v)
+
+		if !exists && v.IsEqual(&cfg.NodeID) {
+			exists = true
+			role = proto.Observer
+		}
+	}
+
 	if !exists {
 		err = errors.Wrapf(kt.ErrNotInPeer, "node %v not in peers %v", cfg.NodeID, peers)
 		return
@@ -204,6 +220,7 @@ func NewRuntime(cfg *kt.RuntimeConfig) (rt *Runtime, err error) {
 		peers:                cfg.Peers,
 		nodeID:               cfg.NodeID,
 		followers:            followers,
+		observers:            observers,
 		role:                 role,
 		minPreparedFollowers: minPreparedFollowers,
 		minCommitFollowers:   minCommitFollowers,
@@ -17,8 +17,10 @@ import (
 type rpcTracker struct {
 	// related runtime
 	r *Runtime
-	// target nodes, a copy of current followers
+	// target nodes, a copy of current followers followed by observers
 	nodes []proto.NodeID
+	// count of followers in nodes, only responses of followers are tracked
+	voters int
 	// rpc method
 	method string
 	// rpc request
@@ -39,10 +41,13 @@ type rpcTracker struct {
 
 func newTracker(r *Runtime, req interface{}, minCount int) (t *rpcTracker) {
 	// copy nodes
-	nodes := append([]proto.NodeID(nil), r.followers...)
+	nodes := make([]proto.NodeID, 0, len(r.followers)+len(r.observers))
+	nodes = append(nodes, r.followers...)
+	nodes = append(nodes, r.observers...)
+	voters := len(r.followers)
 
-	if minCount > len(nodes) {
-		minCount = len(nodes)
+	if minCount > voters {
+		minCount = voters
 	}
 	if minCount < 0 {
 		minCount = 0
@@ -51,10 +56,11 @@ func newTracker(r *Runtime, req interface{}, minCount int) (t *rpcTracker) {
 	t = &rpcTracker{
 		r:        r,
 		nodes:    nodes,
+		voters:   voters,
 		method:   r.applyRPCMethod,
 		req:      req,
 		minCount: minCount,
-		errors:   make(map[proto.NodeID]error, len(nodes)),
+		errors:   make(map[proto.NodeID]error, voters),
 		doneCh:   make(chan struct{}),
 	}
 
@@ -83,6 +89,10 @@ func (t *rpcTracker) callSingle(idx int) {
 	}
 	err := caller.Call(t.method, t.req, nil)
 	defer t.wg.Done()
+	if idx >= t.voters {
+		// observer responses never count
+		return
+	}
 	t.errLock.Lock()
 	defer t.errLock.Unlock()
 	t.errors[t.nodes[idx]] = err
@@ -142,7 +152,7 @@ func (t *rpcTracker) get(ctx context.Context) (errors map[proto.NodeID]error, me
 		meets = true
 	}
 
-	if len(errors) == len(t.nodes) {
+	if len(errors) == t.voters {
 		finished = true
 	}
 
@@ -3,15 +3,25 @@ package bftraft
 
 import (
 	"context"
+	"fmt"
 	"testing"
 	"time"
 
 	"github.com/pkg/errors"
 	. "github.com/smartystreets/goconvey/convey"
 
+	kt "sqlit/src/bftraft/types"
+	kl "sqlit/src/bftraft/wal"
+	"sqlit/src/crypto/asymmetric"
 	"sqlit/src/proto"
 )
 
+type failingTrackerCaller struct{}
+
+func (c *failingTrackerCaller) Call(method string, req interface{}, resp interface{}) (err error) {
+	return errors.New("observer unreachable")
+}
+
 type fakeTrackerCaller struct {
 	c C
 }
@@ -94,3 +104,76 @@ func TestTracker(t *testing.T) {
 		So(t5.closed, ShouldEqual, 1)
 	})
 }
+
+func TestObserverQuorum(t *testing.T) {
+	Convey("observer heavy cluster", t, func(c C) {
+		var (
+			leader    = proto.NodeID("000005aa62048f85da4ae9698ed59c14ec0d48a88a07c15a32265634e7e64ade")
+			follower1 = proto.NodeID("000005f4f22c06f76c43c4f48d5a7ec1309cc94030cbf9ebae814172884ac8b5")
+			follower2 = proto.NodeID("000003f49592f83d0473bddb70d543f1096b4ffed5e5f942a3117e256b7052b8")
+			observers []proto.NodeID
+		)
+		for i := 0; i < 7; i++ {
+			observers = append(observers, proto.NodeID(fmt.Sprintf("%064x", i+1)))
+		}
+		peers := &proto.Peers{
+			PeersHeader: proto.PeersHeader{
+				Leader:  leader,
+				Servers: []proto.NodeID{leader, follower1, follower2},
+			},
+			// a voter listed as observer still votes
+			Observers: append(observers, follower1),
+		}
+		privKey, _, err := asymmetric.GenSecp256k1KeyPair()
+		So(err, ShouldBeNil)
+		So(peers.Sign(privKey), ShouldBeNil)
+
+		newRuntime := func(nodeID proto.NodeID) (*Runtime, error) {
+			return NewRuntime(&kt.RuntimeConfig{
+				PrepareThreshold: 1.0,
+				CommitThreshold:  0.5,
+				Peers:            peers,
+				Wal:              kl.NewMemWal(),
+				NodeID:           nodeID,
+				ApplyMethodName:  "apply",
+			})
+		}
+
+		r, err := newRuntime(leader)
+		So(err, ShouldBeNil)
+		So(r.role, ShouldEqual, proto.Leader)
+		So(r.followers, ShouldResemble, []proto.NodeID{follower1, follower2})
+		So(r.observers, ShouldResemble, observers)
+		// quorum is of the 3 servers, not of the 10 nodes
+		So(r.minPreparedFollowers, ShouldEqual, 2)
+		So(r.minCommitFollowers, ShouldEqual, 1)
+
+		o, err := newRuntime(observers[3])
+		So(err, ShouldBeNil)
+		So(o.role, ShouldEqual, proto.Observer)
+		f, err := newRuntime(follower1)
+		So(err, ShouldBeNil)
+		So(f.role, ShouldEqual, proto.Follower)
+		_, err = newRuntime(proto.NodeID(fmt.Sprintf("%064x", 99)))
+		So(errors.Cause(err), ShouldEqual, kt.ErrNotInPeer)
+
+		// failing observers neither block nor fail the leader
+		r.applyRPCMethod = "test"
+		r.TrackerNewCallerFunc = func(target proto.NodeID) Caller {
+			if peers.IsObserver(target) && target != follower1 {
+				return &failingTrackerCaller{}
+			}
+			return &fakeTrackerCaller{c: c}
+		}
+		tracker := newTracker(r, 1, r.minPreparedFollowers+100)
+		So(tracker.nodes, ShouldHaveLength, 9)
+		So(tracker.minCount, ShouldEqual, 2)
+		tracker.send()
+		errs, meets, finished := tracker.get(context.Background())
+		So(meets, ShouldBeTrue)
+		So(finished, ShouldBeTrue)
+		So(errs, ShouldHaveLength, 2)
+		So(r.errorSummary(errs), ShouldBeNil)
+		tracker.close()
+	})
+}
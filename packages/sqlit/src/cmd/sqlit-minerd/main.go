@@ -65,6 +65,7 @@ var (
 	noLogo      bool
 	showVersion bool
 	logLevel    string
+	logFormat   string
 )
 
 const name = `sqlit-minerd`
@@ -88,6 +89,7 @@ func init() {
 
 	flag.StringVar(&traceFile, "trace-file", "", "Trace profile")
 	flag.StringVar(&logLevel, "log-level", "", "Service log level")
+	flag.StringVar(&logFormat, "log-format", log.TextFormat, "Service log format, text or json")
 
 	flag.Usage = func() {
 		_, _ = fmt.Fprintf(os.Stderr, "\n%s\n\n", desc)
@@ -105,6 +107,9 @@ func initLogs() {
 func main() {
 	flag.Parse()
 	log.SetStringLevel(logLevel, log.InfoLevel)
+	if err := log.SetFormat(logFormat); err != nil {
+		log.WithError(err).Fatal("set log format failed")
+	}
 
 	if showVersion {
 		fmt.Printf("%v %v %v %v %v\n",
@@ -123,6 +128,23 @@ func main() {
 	if err != nil {
 		log.WithField("config", configFile).WithError(err).Fatal("load config failed")
 	}
+	if conf.GConf.Log != nil {
+		if conf.GConf.Log.File != "" {
+			logWriter := log.NewRotatingWriter(conf.GConf.Log.RotateConfig())
+			defer func() { _ = logWriter.Close() }()
+			log.SetOutput(logWriter)
+		}
+		if err = log.SetModuleLevels(conf.GConf.Log.Levels); err != nil {
+			log.WithError(err).Fatal("set module log levels failed")
+		}
+		if conf.GConf.Log.Syslog != nil {
+			syslogHook, syslogErr := log.EnableSyslog(conf.GConf.Log.Syslog.SyslogConfig())
+			if syslogErr != nil {
+				log.WithError(syslogErr).Fatal("enable syslog failed")
+			}
+			defer func() { _ = syslogHook.Close() }()
+		}
+	}
 
 	if conf.GConf.Miner == nil {
 		log.Fatal("miner config does not exists")
@@ -25,6 +25,7 @@ var (
 	mysqlPassword string
 	showVersion   bool
 	logLevel      string
+	logFormat     string
 )
 
 func init() {
@@ -38,11 +39,15 @@ func init() {
 	flag.StringVar(&mysqlUser, "mysql-user", "root", "MySQL user for adapter server")
 	flag.StringVar(&mysqlPassword, "mysql-password", "calvin", "MySQL password for adapter server")
 	flag.StringVar(&logLevel, "log-level", "", "Service log level")
+	flag.StringVar(&logFormat, "log-format", log.TextFormat, "Service log format, text or json")
 }
 
 func main() {
 	flag.Parse()
 	log.SetStringLevel(logLevel, log.InfoLevel)
+	if err := log.SetFormat(logFormat); err != nil {
+		log.WithError(err).Fatal("set log format failed")
+	}
 	if showVersion {
 		fmt.Printf("%v %v %v %v %v\n",
 			name, version, runtime.GOOS, runtime.GOARCH, runtime.Version())
@@ -254,7 +254,12 @@ func runGenerate(cmd *Command, args []string) {
 
 	fmt.Println("Generating nonce...")
 	nonce := nonceGen(publicKey)
-	cliNodeID := proto.NodeID(nonce.Hash.String())
+	cliNodeID, err := proto.DeriveNodeID(publicKey, nonce.Nonce)
+	if err != nil {
+		ConsoleLog.WithError(err).Error("derive node id failed")
+		SetExitStatus(1)
+		return
+	}
 	fmt.Println("Generated nonce.")
 
 	fmt.Println("Generating config file...")
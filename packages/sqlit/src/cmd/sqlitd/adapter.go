@@ -61,7 +61,7 @@ func (s *LocalStorage) SetNode(node *proto.Node) (err error) {
 		return
 	}
 
-	err = route.SetNodeAddrCache(node.ID.ToRawNodeID(), node.Addr)
+	err = route.SetNodeAddrCache(node.ID.ToRawNodeID(), node.Addr, node.Addrs...)
 	if err != nil {
 		log.WithFields(log.Fields{
 			"id":   node.ID,
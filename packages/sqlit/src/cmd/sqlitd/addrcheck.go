@@ -0,0 +1,107 @@
+
+package main
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"sqlit/src/conf"
+	"sqlit/src/proto"
+	"sqlit/src/utils/log"
+)
+
+const (
+	// defaultAddrCheckTimeout is used when conf.AddrCheckInfo.Timeout is not set.
+	defaultAddrCheckTimeout = 2 * time.Second
+	// addrCheckWorkers bounds the concurrent dials of the startup check
+	addrCheckWorkers = 32
+)
+
+// errUnreachableNodeAddr indicates the startup check found unreachable addresses.
+var errUnreachableNodeAddr = errors.New("unreachable known node address")
+
+// dialAddr dials addr for the startup check, it is replaced by tests.
+var dialAddr = func(ctx context.Context, addr string) (err error) {
+	var (
+		dialer net.Dialer
+		c      net.Conn
+	)
+	if c, err = dialer.DialContext(ctx, "tcp", addr); err != nil {
+		return
+	}
+	return c.Close()
+}
+
+// addrCheckResult is the summary of checkNodeAddrs.
+type addrCheckResult struct {
+	Reachable   int
+	Unreachable int
+}
+
+// checkNodeAddrs dials the Addr, Addrs and DirectAddr of the nodes except local
+// concurrently, each within the timeout of info. The unreachable addresses are
+// logged, the error is caused by errUnreachableNodeAddr if any of them is and
+// info.FailOnUnreachable is set.
+func checkNodeAddrs(
+	ctx context.Context, info conf.AddrCheckInfo, nodes []proto.Node, local proto.NodeID,
+) (result addrCheckResult, err error) {
+	timeout := info.Timeout
+	if timeout <= 0 {
+		timeout = defaultAddrCheckTimeout
+	}
+	type nodeAddr struct {
+		node proto.NodeID
+		addr string
+	}
+	var addrs []nodeAddr
+	for i := range nodes {
+		if nodes[i].ID == local {
+			continue
+		}
+		candidates := append(nodes[i].AddrCandidates(), nodes[i].DirectAddr)
+		for _, addr := range proto.MergeAddrs("", candidates...) {
+			addrs = append(addrs, nodeAddr{node: nodes[i].ID, addr: addr})
+		}
+	}
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		sema = make(chan struct{}, addrCheckWorkers)
+	)
+	for _, na := range addrs {
+		wg.Add(1)
+		sema <- struct{}{}
+		go func(na nodeAddr) {
+			defer func() { <-sema; wg.Done() }()
+			dialCtx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			dialErr := dialAddr(dialCtx, na.addr)
+			mu.Lock()
+			defer mu.Unlock()
+			if dialErr != nil {
+				result.Unreachable++
+				log.WithFields(log.Fields{
+					"node": na.node,
+					"addr": na.addr,
+				}).WithError(dialErr).Warning("known node address is unreachable")
+				return
+			}
+			result.Reachable++
+		}(na)
+	}
+	wg.Wait()
+
+	log.WithFields(log.Fields{
+		"reachable":   result.Reachable,
+		"unreachable": result.Unreachable,
+	}).Info("check known node addresses")
+	if result.Unreachable > 0 && info.FailOnUnreachable {
+		err = errors.Wrapf(errUnreachableNodeAddr, "%d of %d addresses", result.Unreachable, len(addrs))
+	}
+	return
+}
@@ -0,0 +1,70 @@
+// +build !testbinary
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	. "github.com/smartystreets/goconvey/convey"
+
+	"sqlit/src/conf"
+	"sqlit/src/proto"
+)
+
+func TestCheckNodeAddrs(t *testing.T) {
+	Convey("the known node addresses are dialed except the local node", t, func() {
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		So(err, ShouldBeNil)
+		defer listener.Close()
+		closed, err := net.Listen("tcp", "127.0.0.1:0")
+		So(err, ShouldBeNil)
+		closedAddr := closed.Addr().String()
+		So(closed.Close(), ShouldBeNil)
+
+		var (
+			local = proto.NodeID("0000000000000000000000000000000000000000000000000000000000000001")
+			good  = proto.NodeID("0000000000000000000000000000000000000000000000000000000000000002")
+			typo  = proto.NodeID("0000000000000000000000000000000000000000000000000000000000000003")
+			nodes = []proto.Node{
+				{ID: local, Addr: closedAddr},
+				{ID: good, Addr: listener.Addr().String(), DirectAddr: listener.Addr().String()},
+				{ID: typo, Addr: listener.Addr().String(), DirectAddr: closedAddr},
+			}
+		)
+		info := conf.AddrCheckInfo{Timeout: time.Second}
+		result, err := checkNodeAddrs(context.Background(), info, nodes, local)
+		So(err, ShouldBeNil)
+		So(result, ShouldResemble, addrCheckResult{Reachable: 2, Unreachable: 1})
+
+		info.FailOnUnreachable = true
+		result, err = checkNodeAddrs(context.Background(), info, nodes, local)
+		So(errors.Cause(err), ShouldEqual, errUnreachableNodeAddr)
+		So(result.Unreachable, ShouldEqual, 1)
+		_, err = checkNodeAddrs(context.Background(), info, nodes[:2], local)
+		So(err, ShouldBeNil)
+	})
+	Convey("the dead hosts are dialed concurrently", t, func() {
+		defer func(saved func(context.Context, string) error) { dialAddr = saved }(dialAddr)
+		dialAddr = func(ctx context.Context, addr string) error {
+			<-ctx.Done()
+			return ctx.Err()
+		}
+		var nodes []proto.Node
+		for i := 0; i < 10; i++ {
+			nodes = append(nodes, proto.Node{
+				ID:   proto.NodeID(fmt.Sprintf("%064x", i+1)),
+				Addr: fmt.Sprintf("10.0.0.%d:4661", i+1),
+			})
+		}
+		start := time.Now()
+		result, err := checkNodeAddrs(context.Background(), conf.AddrCheckInfo{Timeout: 200 * time.Millisecond}, nodes, "")
+		So(err, ShouldBeNil)
+		So(result, ShouldResemble, addrCheckResult{Unreachable: 10})
+		So(time.Since(start), ShouldBeLessThan, time.Second)
+	})
+}
@@ -0,0 +1,140 @@
+
+package main
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"sqlit/src/conf"
+	"sqlit/src/crypto/kms"
+	"sqlit/src/proto"
+	"sqlit/src/route"
+	"sqlit/src/utils/log"
+)
+
+// adminRequestWindow bounds the clock skew of a signed admin request, an older or
+// newer request is rejected to limit replays.
+const adminRequestWindow = 5 * time.Minute
+
+var (
+	// ErrAdminNotPermitted indicates the caller is not in conf.GConf.AdminNodes or
+	// the request is not signed by it.
+	ErrAdminNotPermitted = errors.New("admin rpc not permitted")
+	// ErrAdminRequestExpired indicates the signed admin request is out of adminRequestWindow.
+	ErrAdminRequestExpired = errors.New("admin request expired")
+)
+
+// ReloadConfigReq defines the admin request to reload the config, it is signed by
+// the calling node.
+type ReloadConfigReq struct {
+	proto.Envelope
+	Timestamp time.Time
+	Signature *kms.Signature
+}
+
+// ReloadConfigResp defines the admin response of a config reload.
+type ReloadConfigResp struct {
+	// Changed is the yaml keys applied live
+	Changed []string
+	// Ignored is the yaml keys changed which need a restart to apply
+	Ignored []string
+}
+
+// NewReloadConfigReq returns a reload request signed by the local node.
+func NewReloadConfigReq() (req *ReloadConfigReq, err error) {
+	var nodeID proto.NodeID
+	if nodeID, err = kms.GetLocalNodeID(); err != nil {
+		return
+	}
+	req = &ReloadConfigReq{Timestamp: time.Now()}
+	if req.Signature, err = kms.SignNodeData(reloadConfigReqData(nodeID, req.Timestamp)); err != nil {
+		return nil, errors.Wrap(err, "sign reload config request failed")
+	}
+	return
+}
+
+// reloadConfigReqData is the data of a reload request signed by nodeID.
+func reloadConfigReqData(nodeID proto.NodeID, ts time.Time) []byte {
+	return []byte(route.AdminRPCName + ".ReloadConfig " + string(nodeID) + " " +
+		strconv.FormatInt(ts.UnixNano(), 10))
+}
+
+// AdminService defines the admin rpc of sqlitd, only the nodes in
+// conf.GConf.AdminNodes are permitted.
+type AdminService struct {
+	configPaths []string
+}
+
+// NewAdminService returns the admin service reloading the config merged from
+// configPaths.
+func NewAdminService(configPaths ...string) *AdminService {
+	return &AdminService{configPaths: configPaths}
+}
+
+// ReloadConfig reloads the config as SIGHUP does and reports the changed and the
+// ignored fields.
+func (s *AdminService) ReloadConfig(req *ReloadConfigReq, resp *ReloadConfigResp) (err error) {
+	var caller proto.NodeID
+	if caller, err = verifyAdminCaller(&req.Envelope, req.Timestamp, req.Signature,
+		reloadConfigReqData, time.Now()); err != nil {
+		log.WithFields(log.Fields{
+			"caller": caller,
+			"method": route.AdminRPCName + ".ReloadConfig",
+		}).WithError(err).Warning("reject admin rpc")
+		return
+	}
+
+	log.WithFields(log.Fields{
+		"caller": caller,
+		"config": s.configPaths,
+	}).Info("reload config by admin rpc")
+	var delta conf.ConfigDelta
+	if delta, err = reloadConfig(s.configPaths...); err != nil {
+		log.WithField("config", s.configPaths).WithError(err).Error(
+			"reload config failed, keep the old config")
+		return
+	}
+	resp.Changed = delta.Changed()
+	resp.Ignored = delta.Ignored
+	return
+}
+
+// verifyAdminCaller returns the caller node of an admin request signed at ts, the
+// error is caused by ErrAdminNotPermitted or ErrAdminRequestExpired if it is rejected.
+func verifyAdminCaller(
+	env *proto.Envelope, ts time.Time, sig *kms.Signature,
+	data func(proto.NodeID, time.Time) []byte, now time.Time,
+) (caller proto.NodeID, err error) {
+	rawID := env.GetNodeID()
+	if rawID == nil || rawID.IsEqual(&kms.AnonymousRawNodeID.Hash) {
+		return "", errors.Wrap(ErrAdminNotPermitted, "anonymous caller")
+	}
+	caller = rawID.ToNodeID()
+
+	var permitted bool
+	if conf.GConf != nil {
+		for _, id := range conf.GConf.AdminNodes {
+			if id == caller {
+				permitted = true
+				break
+			}
+		}
+	}
+	if !permitted {
+		return caller, errors.Wrap(ErrAdminNotPermitted, "caller is not an admin node")
+	}
+	if skew := now.Sub(ts); skew > adminRequestWindow || skew < -adminRequestWindow {
+		return caller, errors.Wrapf(ErrAdminRequestExpired, "signed at %s", ts.Format(time.RFC3339))
+	}
+
+	var valid bool
+	if valid, err = kms.VerifyNodeSignature(caller, data(caller, ts), sig); err != nil {
+		return caller, errors.Wrapf(ErrAdminNotPermitted, "verify signature failed: %v", err)
+	}
+	if !valid {
+		return caller, errors.Wrap(ErrAdminNotPermitted, "invalid signature")
+	}
+	return
+}
@@ -0,0 +1,108 @@
+// +build !testbinary
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	. "github.com/smartystreets/goconvey/convey"
+	yaml "gopkg.in/yaml.v2"
+
+	"sqlit/src/conf"
+	"sqlit/src/crypto/asymmetric"
+	"sqlit/src/crypto/kms"
+	"sqlit/src/proto"
+)
+
+func TestAdminReloadConfig(t *testing.T) {
+	Convey("only the admin nodes reload the config", t, func() {
+		var (
+			dir        = t.TempDir()
+			configPath = filepath.Join(dir, "config.yaml")
+			self       = proto.NodeID("0000000000000000000000000000000000000000000000000000000000000021")
+			joined     = proto.NodeID("0000000000000000000000000000000000000000000000000000000000000022")
+			write      = func(config *conf.Config) {
+				out, err := yaml.Marshal(config)
+				So(err, ShouldBeNil)
+				So(os.WriteFile(configPath, out, 0600), ShouldBeNil)
+			}
+		)
+		defer func(saved *conf.Config) { conf.GConf = saved }(conf.GConf)
+		kms.ResetLocalKeyStore()
+		defer kms.ResetLocalKeyStore()
+		defer kms.ClosePublicKeyStore()
+
+		// the admin and an other node are known, the admin is the local node to sign
+		newNode := func() (*asymmetric.PrivateKey, proto.Node) {
+			private, public, err := asymmetric.GenSecp256k1KeyPair()
+			So(err, ShouldBeNil)
+			mined := kms.MineNodeNonce(public, 0)
+			return private, proto.Node{
+				ID: proto.NodeID(mined.Hash.String()), Role: proto.Client, PublicKey: public, Nonce: mined.Nonce,
+			}
+		}
+		adminKey, admin := newNode()
+		_, other := newNode()
+		So(kms.InitPublicKeyStore(filepath.Join(dir, "public.keystore"), []proto.Node{admin, other}), ShouldBeNil)
+		kms.SetLocalKeyPair(adminKey, admin.PublicKey)
+		kms.SetLocalNodeIDNonce(admin.ID.ToRawNodeID().CloneBytes(), &admin.Nonce)
+
+		write(&conf.Config{
+			ThisNodeID: self,
+			AdminNodes: []proto.NodeID{admin.ID},
+			KnownNodes: []proto.Node{{ID: self, Addr: "a:1"}},
+		})
+		var err error
+		conf.GConf, err = conf.LoadConfig(configPath)
+		So(err, ShouldBeNil)
+		write(&conf.Config{
+			ThisNodeID: self,
+			ListenAddr: "b:0",
+			AdminNodes: []proto.NodeID{admin.ID},
+			KnownNodes: []proto.Node{{ID: self, Addr: "a:1"}, {ID: joined, Addr: "a:2"}},
+		})
+
+		service := NewAdminService(configPath)
+		call := func(caller proto.NodeID, req *ReloadConfigReq) (resp *ReloadConfigResp, err error) {
+			if caller != "" {
+				req.SetNodeID(caller.ToRawNodeID())
+			}
+			resp = new(ReloadConfigResp)
+			err = service.ReloadConfig(req, resp)
+			return
+		}
+
+		req, err := NewReloadConfigReq()
+		So(err, ShouldBeNil)
+		// the anonymous, the other nodes and the forged requests are rejected
+		_, err = call("", &ReloadConfigReq{Timestamp: req.Timestamp, Signature: req.Signature})
+		So(errors.Cause(err), ShouldEqual, ErrAdminNotPermitted)
+		_, err = call(kms.AnonymousRawNodeID.ToNodeID(), &ReloadConfigReq{Timestamp: req.Timestamp, Signature: req.Signature})
+		So(errors.Cause(err), ShouldEqual, ErrAdminNotPermitted)
+		_, err = call(other.ID, &ReloadConfigReq{Timestamp: req.Timestamp, Signature: req.Signature})
+		So(errors.Cause(err), ShouldEqual, ErrAdminNotPermitted)
+		_, err = call(admin.ID, &ReloadConfigReq{Timestamp: req.Timestamp.Add(time.Second), Signature: req.Signature})
+		So(errors.Cause(err), ShouldEqual, ErrAdminNotPermitted)
+		_, err = call(admin.ID, &ReloadConfigReq{Timestamp: req.Timestamp})
+		So(errors.Cause(err), ShouldEqual, ErrAdminNotPermitted)
+		So(conf.GConf.KnownNodes, ShouldHaveLength, 1)
+
+		// a stale request is rejected even if signed
+		stale := &ReloadConfigReq{Timestamp: time.Now().Add(-2 * adminRequestWindow)}
+		stale.Signature, err = kms.SignNodeData(reloadConfigReqData(admin.ID, stale.Timestamp))
+		So(err, ShouldBeNil)
+		_, err = call(admin.ID, stale)
+		So(errors.Cause(err), ShouldEqual, ErrAdminRequestExpired)
+
+		resp, err := call(admin.ID, req)
+		So(err, ShouldBeNil)
+		So(resp.Changed, ShouldResemble, []string{"KnownNodes[" + string(joined) + "]"})
+		So(resp.Ignored, ShouldResemble, []string{"ListenAddr"})
+		So(conf.GConf.KnownNodes, ShouldHaveLength, 2)
+		So(conf.GConf.ListenAddr, ShouldBeBlank)
+	})
+}
@@ -0,0 +1,66 @@
+
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"sqlit/src/crypto/asymmetric"
+	"sqlit/src/crypto/kms"
+	"sqlit/src/proto"
+	"sqlit/src/utils/log"
+)
+
+// startupSummary is the node identity loaded by initNodePeers, it is printed and
+// logged at startup to confirm the expected key and config are in use.
+type startupSummary struct {
+	NodeID      proto.NodeID
+	Fingerprint string
+	Role        proto.ServerRole
+	Term        uint64
+	Leader      proto.NodeID
+	Servers     int
+	Observers   int
+}
+
+// newStartupSummary returns the summary of the local node and the signed peers.
+func newStartupSummary(
+	nodeID proto.NodeID, localPublic asymmetric.TypedPublicKey, thisNode *proto.Node, peers *proto.Peers,
+) (s *startupSummary) {
+	s = &startupSummary{
+		NodeID:      nodeID,
+		Fingerprint: kms.KeyFingerprint(localPublic),
+	}
+	if thisNode != nil {
+		s.Role = thisNode.Role
+	}
+	if peers != nil {
+		s.Term = peers.Term
+		s.Leader = peers.Leader
+		s.Servers = len(peers.Servers)
+		s.Observers = len(peers.Observers)
+	}
+	return
+}
+
+// Fields returns the summary as log fields.
+func (s *startupSummary) Fields() log.Fields {
+	return log.Fields{
+		"node":        s.NodeID,
+		"fingerprint": s.Fingerprint,
+		"role":        s.Role,
+		"term":        s.Term,
+		"leader":      s.Leader,
+		"servers":     s.Servers,
+		"observers":   s.Observers,
+	}
+}
+
+// Print writes the summary to w in a human readable form.
+func (s *startupSummary) Print(w io.Writer) {
+	_, _ = fmt.Fprintf(w, "node:        %s\n", s.NodeID)
+	_, _ = fmt.Fprintf(w, "fingerprint: %s\n", s.Fingerprint)
+	_, _ = fmt.Fprintf(w, "role:        %s\n", s.Role)
+	_, _ = fmt.Fprintf(w, "peers:       term %d leader %s\n", s.Term, s.Leader)
+	_, _ = fmt.Fprintf(w, "             %d servers %d observers\n", s.Servers, s.Observers)
+}
@@ -0,0 +1,51 @@
+// +build !testbinary
+
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"sqlit/src/crypto/asymmetric"
+	"sqlit/src/crypto/kms"
+	"sqlit/src/proto"
+)
+
+func TestStartupSummary(t *testing.T) {
+	Convey("the summary is drawn from the local node and the signed peers", t, func() {
+		var (
+			local    = proto.NodeID("0000000000000000000000000000000000000000000000000000000000000071")
+			observer = proto.NodeID("0000000000000000000000000000000000000000000000000000000000000072")
+		)
+		_, public, err := asymmetric.GenSecp256k1KeyPair()
+		So(err, ShouldBeNil)
+		peers := &proto.Peers{
+			PeersHeader: proto.PeersHeader{
+				Term:    3,
+				Leader:  local,
+				Servers: []proto.NodeID{local},
+			},
+			Observers: []proto.NodeID{observer},
+		}
+		s := newStartupSummary(local, public, &proto.Node{ID: local, Role: proto.Leader}, peers)
+		So(s.Fingerprint, ShouldEqual, kms.KeyFingerprint(public))
+		So(s.Fields(), ShouldContainKey, "fingerprint")
+		So(s.Fields()["servers"], ShouldEqual, 1)
+		So(s.Fields()["observers"], ShouldEqual, 1)
+
+		var buf bytes.Buffer
+		s.Print(&buf)
+		So(buf.String(), ShouldContainSubstring, string(local))
+		So(buf.String(), ShouldContainSubstring, s.Fingerprint)
+		So(buf.String(), ShouldContainSubstring, "role:        Leader")
+		So(buf.String(), ShouldContainSubstring, "term 3 leader "+string(local))
+		So(buf.String(), ShouldContainSubstring, "1 servers 1 observers")
+
+		// nothing is dereferenced before the peers are loaded
+		s = newStartupSummary(local, nil, nil, nil)
+		So(s.Fingerprint, ShouldBeEmpty)
+		So(s.Role, ShouldEqual, proto.Unknown)
+	})
+}
@@ -265,7 +265,7 @@ func BenchmarkKVServer_GetAllNodeInfo(b *testing.B) {
 
 	// init nodes
 	log.Info("init peers")
-	_, _, _, err = initNodePeers(nodeID, pubKeyStorePath)
+	_, _, _, _, err = initNodePeers(context.Background(), nodeID, pubKeyStorePath, liveNodeMutator{})
 	if err != nil {
 		return
 	}
@@ -3,6 +3,7 @@ package main
 
 import (
 	"fmt"
+	"os"
 	"syscall"
 	"time"
 
@@ -25,37 +26,134 @@ const (
 	dhtGossipTimeout = time.Second * 20
 )
 
-func runNode(nodeID proto.NodeID, listenAddr string) (err error) {
-	genesis, err := loadGenesis()
-	if err != nil {
+// readMasterKey reads the master key of the private key from env, passphrase file or
+// prompt, it is empty with UseTestMasterKey.
+func readMasterKey() (masterKey []byte, err error) {
+	if conf.GConf.UseTestMasterKey {
 		return
 	}
-
-	var masterKey []byte
-	if !conf.GConf.UseTestMasterKey {
-		// read master key
+	return kms.ReadPassphrase(func() (key []byte, err error) {
 		fmt.Print("Type in Master key to continue: ")
-		masterKey, err = term.ReadPassword(int(syscall.Stdin))
+		key, err = term.ReadPassword(int(syscall.Stdin))
 		if err != nil {
 			fmt.Printf("Failed to read Master Key: %v", err)
 		}
 		fmt.Println("")
+		return
+	})
+}
+
+func runNode(nodeID proto.NodeID, listenAddr string) (err error) {
+	sd := newShutdown(conf.GConf.ShutdownTimeout)
+	defer sd.Run()
+
+	if conf.GConf.HealthAddr != "" {
+		healthServer, healthErr := startHealthServer(sd.Context(), conf.GConf.HealthAddr)
+		if healthErr != nil {
+			err = errors.Wrap(healthErr, "start health server failed")
+			log.WithError(err).Error("start health server failed")
+			return
+		}
+		sd.Add("stop health server", func() error {
+			return stopHTTPServer(healthServer)
+		})
+	}
+	if conf.GConf.MetricsAddr != "" {
+		metricsServer, metricsErr := startMetricsServer(conf.GConf.MetricsAddr)
+		if metricsErr != nil {
+			err = errors.Wrap(metricsErr, "start metrics server failed")
+			log.WithError(err).Error("start metrics server failed")
+			return
+		}
+		sd.Add("stop metrics server", func() error {
+			return stopHTTPServer(metricsServer)
+		})
+	}
+
+	genesis, err := loadGenesis()
+	if err != nil {
+		return
 	}
 
-	err = kms.InitLocalKeyPair(conf.GConf.PrivateKeyFile, masterKey)
+	masterKey, err := readMasterKey()
+	if err != nil {
+		log.WithError(err).Error("read master key failed")
+		return
+	}
+
+	err = kms.InitLocalKeyProvider(conf.GConf.PrivateKeyFile, masterKey)
 	if err != nil {
 		log.WithError(err).Error("init local key pair failed")
 		return
 	}
+	// registered before the steps which may still sign, so it runs after them
+	sd.Add("scrub local keys", func() error {
+		kms.Shutdown()
+		return nil
+	})
 
-	// init nodes
-	log.WithField("node", nodeID).Info("init peers")
-	_, peers, thisNode, err := initNodePeers(nodeID, conf.GConf.PubKeyStoreFile)
+	// refuse to start if the persisted nonce is rolled back
+	err = kms.InitLocalNonceFile(conf.GConf.LocalNonceFile, conf.GConf.LocalNonce)
 	if err != nil {
-		log.WithError(err).Error("init nodes and peers failed")
+		log.WithError(err).Error("init local nonce failed")
 		return
 	}
 
+	// restore the node addresses learned before restart, config entries take precedence
+	if loaded, loadErr := route.LoadCache(conf.GConf.RouteCacheFile); loadErr != nil {
+		log.WithError(loadErr).Warning("load route cache failed")
+	} else {
+		log.WithField("entries", loaded).Info("load route cache")
+	}
+	sd.Add("save route cache", func() error {
+		return route.SaveCache(conf.GConf.RouteCacheFile)
+	})
+	if conf.GConf.RouteProbe != nil {
+		route.ProbeNodeAddrCache(sd.Context(), *conf.GConf.RouteProbe)
+	}
+	var hostCacheInfo conf.HostCacheInfo
+	if conf.GConf.HostCache != nil {
+		hostCacheInfo = *conf.GConf.HostCache
+	}
+	route.RefreshHostCache(sd.Context(), hostCacheInfo)
+
+	if reinit {
+		if err = resetInitState(conf.GConf); err != nil {
+			log.WithError(err).Error("reinit node state failed")
+			return
+		}
+	}
+
+	// init nodes, the setup entries share a request id
+	initCtx, _ := log.WithRequestID(sd.Context())
+	log.FromContext(initCtx).WithField("node", nodeID).Info("init peers")
+	_, peers, thisNode, summary, err := initNodePeers(initCtx, nodeID, conf.GConf.PubKeyStoreFile, liveNodeMutator{})
+	if err != nil {
+		log.FromContext(initCtx).WithError(err).Error("init nodes and peers failed")
+		return
+	}
+	summary.Print(os.Stdout)
+	log.FromContext(initCtx).WithFields(summary.Fields()).Info("node identity")
+	sd.Add("close public keystore", func() error {
+		kms.ClosePublicKeyStore()
+		return nil
+	})
+	sd.Add("save local peers", func() error {
+		return kms.SaveLocalPeers(conf.GConf.PeersFile)
+	})
+	if conf.GConf.AddrCheck != nil {
+		if _, err = checkNodeAddrs(
+			initCtx, *conf.GConf.AddrCheck, conf.GConf.KnownNodes, conf.GConf.ThisNodeID); err != nil {
+			log.FromContext(initCtx).WithError(err).Error("check known node addresses failed")
+			return
+		}
+	}
+	var warmupInfo conf.WarmupInfo
+	if conf.GConf.Warmup != nil {
+		warmupInfo = *conf.GConf.Warmup
+	}
+	go warmupPeers(sd.Context(), warmupInfo, peers.Clone(), nodeID)
+
 	// Always run in BP mode - BP nodes can serve HTTP API alongside consensus
 	mode := bp.BPMode
 
@@ -73,10 +171,11 @@ func runNode(nodeID proto.NodeID, listenAddr string) (err error) {
 	go func() {
 		server.Serve()
 	}()
-	defer func() {
+	sd.Add("stop server", func() error {
 		server.Listener.Close()
 		server.Stop()
-	}()
+		return nil
+	})
 
 	if mode == bp.BPMode {
 		// init storage
@@ -95,7 +194,10 @@ func runNode(nodeID proto.NodeID, listenAddr string) (err error) {
 			log.WithError(err).Error("init consistent hash failed")
 			return err
 		}
-		defer kvServer.Stop()
+		sd.Add("stop kv server", func() error {
+			kvServer.Stop()
+			return nil
+		})
 
 		// set consistent handler to local storage
 		kvServer.storage.consistent = dht.Consistent
@@ -118,6 +220,14 @@ func runNode(nodeID proto.NodeID, listenAddr string) (err error) {
 		}
 	}
 
+	if len(conf.GConf.AdminNodes) > 0 {
+		log.WithField("admins", conf.GConf.AdminNodes).Info("register admin service rpc")
+		if err = server.RegisterService(route.AdminRPCName, NewAdminService(configFiles...)); err != nil {
+			log.WithError(err).Error("register admin service failed")
+			return err
+		}
+	}
+
 	// init main chain service
 	log.Info("register main chain service rpc")
 	chainConfig := &bp.Config{
@@ -137,11 +247,7 @@ func runNode(nodeID proto.NodeID, listenAddr string) (err error) {
 		return err
 	}
 	chain.Start()
-	defer func() {
-		if stopErr := chain.Stop(); stopErr != nil {
-			log.WithError(stopErr).Error("stop chain failed")
-		}
-	}()
+	sd.Add("stop chain", chain.Stop)
 
 	log.Info(conf.StartSucceedMessage)
 
@@ -154,9 +260,21 @@ func runNode(nodeID proto.NodeID, listenAddr string) (err error) {
 				log.WithError(err).Error("wsapi: start service")
 			}
 		}()
+		sd.Add("stop wsapi", func() error {
+			api.StopService()
+			return nil
+		})
 	}
 
-	<-utils.WaitForExit()
+	exitCh := utils.WaitForExit()
+	watchConfigReload(sd.Context(), configFiles...)
+	watchRemoteConfig(sd.Context(), configFile)
+	var signalModule string
+	if conf.GConf.Log != nil {
+		signalModule = conf.GConf.Log.SignalModule
+	}
+	watchLogLevelSignal(sd.Context(), signalModule)
+	sd.Wait(exitCh)
 	return
 }
 
@@ -0,0 +1,131 @@
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/pkg/errors"
+
+	"sqlit/src/conf"
+	"sqlit/src/crypto/asymmetric"
+	"sqlit/src/proto"
+)
+
+// configCheckReport is the result of checking a config file offline.
+type configCheckReport struct {
+	Roles  map[proto.ServerRole]int
+	Errors []error
+}
+
+// OK returns if the config passed all checks.
+func (r *configCheckReport) OK() bool {
+	return len(r.Errors) == 0
+}
+
+// configPeers returns the peers of the voting and observing known nodes of config
+// with the block producer as leader, it is not signed.
+func configPeers(config *conf.Config) (peers *proto.Peers) {
+	peers = &proto.Peers{
+		PeersHeader: proto.PeersHeader{
+			Term: 1,
+		},
+	}
+	if config.BP != nil {
+		peers.Leader = config.BP.NodeID
+	}
+	for _, n := range config.KnownNodes {
+		if n.Role.IsVoter() {
+			peers.Servers = append(peers.Servers, n.ID)
+		} else if n.Role == proto.Observer {
+			peers.Observers = append(peers.Observers, n.ID)
+		}
+	}
+	return
+}
+
+// checkConfig loads the config at path into conf.GConf and runs the validations of
+// the node startup, the peers are signed with a throwaway key. Listeners, the key
+// files and the stores are never opened.
+func checkConfig(path string) (report configCheckReport) {
+	// the check is strict whatever the startup flags are
+	defer func(saved bool) { conf.KnownNodesWarnOnly = saved }(conf.KnownNodesWarnOnly)
+	conf.KnownNodesWarnOnly = false
+
+	config, err := conf.LoadConfig(path)
+	if err != nil {
+		report.Errors = append(report.Errors, errors.Wrap(err, "load config failed"))
+		return
+	}
+	conf.GConf = config
+
+	report.Roles = make(map[proto.ServerRole]int)
+	for _, n := range config.KnownNodes {
+		report.Roles[n.Role]++
+	}
+	if err = config.Validate(); err != nil {
+		report.Errors = append(report.Errors, err)
+	}
+
+	private, _, err := asymmetric.GenSecp256k1KeyPair()
+	if err != nil {
+		report.Errors = append(report.Errors, errors.Wrap(err, "generate dry run key failed"))
+		return
+	}
+	proto.SetMaxServers(config.MaxPeersServers)
+	peers := configPeers(config)
+	if err = peers.Sign(private); err == nil {
+		err = peers.Verify()
+	}
+	if err != nil {
+		report.Errors = append(report.Errors, errors.Wrap(err, "dry run sign peers failed"))
+	}
+	return
+}
+
+// writeConfigCheckReport prints the nodes by role and the errors of report.
+func writeConfigCheckReport(w io.Writer, path string, report configCheckReport) {
+	roles := make([]proto.ServerRole, 0, len(report.Roles))
+	for role := range report.Roles {
+		roles = append(roles, role)
+	}
+	sort.Slice(roles, func(i, j int) bool { return roles[i] < roles[j] })
+
+	_, _ = fmt.Fprintf(w, "config: %s\n", path)
+	for _, role := range roles {
+		_, _ = fmt.Fprintf(w, "  %s: %d\n", role, report.Roles[role])
+	}
+	for _, err := range report.Errors {
+		_, _ = fmt.Fprintf(w, "error: %v\n", err)
+	}
+	if report.OK() {
+		_, _ = fmt.Fprintln(w, "config ok")
+	} else {
+		_, _ = fmt.Fprintf(w, "config check failed with %d errors\n", len(report.Errors))
+	}
+}
+
+// runConfigCheck runs the config-check command with args after the command name and
+// returns the exit code.
+func runConfigCheck(args []string, w io.Writer) int {
+	flags := flag.NewFlagSet("config-check", flag.ContinueOnError)
+	flags.SetOutput(w)
+	path := flags.String("config", configFile, "Config file path, default is searched like "+name)
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	configPath, err := conf.FindConfigFile(*path, os.LookupEnv)
+	if err != nil {
+		_, _ = fmt.Fprintf(w, "error: %v\n", err)
+		return 1
+	}
+	report := checkConfig(configPath)
+	writeConfigCheckReport(w, configPath, report)
+	if !report.OK() {
+		return 1
+	}
+	return 0
+}
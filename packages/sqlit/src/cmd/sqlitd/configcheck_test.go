@@ -0,0 +1,86 @@
+// +build !testbinary
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	yaml "gopkg.in/yaml.v2"
+
+	"sqlit/src/conf"
+	"sqlit/src/proto"
+)
+
+func TestConfigCheck(t *testing.T) {
+	Convey("config-check validates the config offline", t, func() {
+		var (
+			dir        = t.TempDir()
+			configPath = filepath.Join(dir, "config.yaml")
+			bp         = proto.NodeID("0000000000000000000000000000000000000000000000000000000000000041")
+			miner      = proto.NodeID("0000000000000000000000000000000000000000000000000000000000000042")
+			observer   = proto.NodeID("0000000000000000000000000000000000000000000000000000000000000043")
+			write      = func(config *conf.Config) {
+				out, err := yaml.Marshal(config)
+				So(err, ShouldBeNil)
+				So(os.WriteFile(configPath, out, 0600), ShouldBeNil)
+			}
+			check = func() (code int, out string) {
+				var buf bytes.Buffer
+				code = runConfigCheck([]string{"-config", configPath}, &buf)
+				return code, buf.String()
+			}
+		)
+		defer func(saved *conf.Config) { conf.GConf = saved }(conf.GConf)
+		defer func(saved bool) { conf.KnownNodesWarnOnly = saved }(conf.KnownNodesWarnOnly)
+		valid := &conf.Config{
+			ThisNodeID: bp,
+			ListenAddr: "127.0.0.1:0",
+			BP:         &conf.BPInfo{NodeID: bp},
+			KnownNodes: []proto.Node{
+				{ID: bp, Role: proto.Leader, Addr: "127.0.0.1:1"},
+				{ID: miner, Role: proto.Miner, Addr: "127.0.0.1:2"},
+				{ID: observer, Role: proto.Observer, Addr: "127.0.0.1:3"},
+			},
+		}
+		write(valid)
+		code, out := check()
+		So(code, ShouldEqual, 0)
+		So(out, ShouldContainSubstring, "  Leader: 1\n  Miner: 1\n  Observer: 1\n")
+		So(out, ShouldContainSubstring, "config ok")
+		So(conf.GConf.ThisNodeID, ShouldEqual, bp)
+		// nothing is written besides the config
+		entries, err := os.ReadDir(dir)
+		So(err, ShouldBeNil)
+		So(entries, ShouldHaveLength, 1)
+
+		peers := configPeers(conf.GConf)
+		So(peers.Leader, ShouldEqual, bp)
+		So(peers.Servers, ShouldResemble, []proto.NodeID{bp})
+		So(peers.Observers, ShouldResemble, []proto.NodeID{observer})
+
+		// required fields are reported with the roles
+		valid.ListenAddr = ""
+		write(valid)
+		code, out = check()
+		So(code, ShouldEqual, 1)
+		So(out, ShouldContainSubstring, "  Miner: 1\n")
+		So(out, ShouldContainSubstring, "ListenAddr")
+		So(out, ShouldContainSubstring, "config check failed with 1 errors")
+
+		// duplicate nodes are rejected even if warned only on startup
+		conf.KnownNodesWarnOnly = true
+		valid.ListenAddr = "127.0.0.1:0"
+		valid.KnownNodes = append(valid.KnownNodes, proto.Node{ID: miner, Role: proto.Miner, Addr: "127.0.0.1:4"})
+		write(valid)
+		code, out = check()
+		So(code, ShouldEqual, 1)
+		So(out, ShouldContainSubstring, "load config failed")
+		So(conf.KnownNodesWarnOnly, ShouldBeTrue)
+
+		So(runConfigCheck([]string{"-nope"}, &bytes.Buffer{}), ShouldEqual, 2)
+	})
+}
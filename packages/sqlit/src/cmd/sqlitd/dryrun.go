@@ -0,0 +1,96 @@
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/pkg/errors"
+
+	"sqlit/src/conf"
+	"sqlit/src/crypto/hash"
+	"sqlit/src/crypto/kms"
+	mine "sqlit/src/pow/cpuminer"
+	"sqlit/src/proto"
+)
+
+// dryRunRecorder is the nodeMutator of -dry-run, it prints the mutations of
+// initNodePeers instead of applying them.
+type dryRunRecorder struct {
+	w         io.Writer
+	mutations int
+}
+
+func (r *dryRunRecorder) record(format string, args ...interface{}) {
+	r.mutations++
+	_, _ = fmt.Fprintf(r.w, "would "+format+"\n", args...)
+}
+
+func (r *dryRunRecorder) SetLocalPeers(peers *proto.Peers) {
+	r.record("set local peers of term %d", peers.Term)
+}
+
+func (r *dryRunRecorder) InitResolver() {
+	r.record("init resolver from DNS seeds")
+}
+
+func (r *dryRunRecorder) InitPublicKeyStore(path string) error {
+	r.record("open public keystore %s", path)
+	return nil
+}
+
+func (r *dryRunRecorder) SetNodeAddrCache(id *proto.RawNodeID, addr string, alternates ...string) error {
+	r.record("set route %s -> %s %v", id.ToNodeID(), addr, alternates)
+	return nil
+}
+
+func (r *dryRunRecorder) SetNodeAddrCacheBatch(entries map[*proto.RawNodeID]string) error {
+	lines := make([]string, 0, len(entries))
+	for id, addr := range entries {
+		lines = append(lines, string(id.ToNodeID())+" -> "+addr)
+	}
+	sort.Strings(lines)
+	for _, line := range lines {
+		r.record("set route %s", line)
+	}
+	return nil
+}
+
+func (r *dryRunRecorder) SetLocalNodeIDNonce(rawNodeID []byte, nonce *mine.Uint256) {
+	id, _ := hash.NewHash(rawNodeID)
+	r.record("set local node id %s nonce %v", id, *nonce)
+}
+
+func (r *dryRunRecorder) SetNodes(nodes []*proto.Node) error {
+	for _, n := range nodes {
+		r.record("set keystore node %s role %s addr %s", n.ID, n.Role, n.Addr)
+	}
+	return nil
+}
+
+// runDryRun runs initNodePeers for nodeID with the dryRunRecorder and reports the
+// peers and the local node, the local key is loaded but no state is written.
+func runDryRun(nodeID proto.NodeID, w io.Writer) (err error) {
+	masterKey, err := readMasterKey()
+	if err != nil {
+		return errors.Wrap(err, "read master key failed")
+	}
+	if err = kms.InitLocalKeyProvider(conf.GConf.PrivateKeyFile, masterKey); err != nil {
+		return errors.Wrap(err, "init local key pair failed")
+	}
+
+	recorder := &dryRunRecorder{w: w}
+	_, peers, thisNode, _, err := initNodePeers(context.Background(), nodeID, conf.GConf.PubKeyStoreFile, recorder)
+	if err != nil {
+		return errors.Wrap(err, "init nodes and peers failed")
+	}
+
+	_, _ = fmt.Fprintf(w, "\n%d mutations skipped\n", recorder.mutations)
+	_, _ = fmt.Fprintf(w, "peers: term %d leader %s\n", peers.Term, peers.Leader)
+	_, _ = fmt.Fprintf(w, "  servers:   %v\n", peers.Servers)
+	_, _ = fmt.Fprintf(w, "  observers: %v\n", peers.Observers)
+	_, _ = fmt.Fprintf(w, "local node: %s role %s addr %s\n", thisNode.ID, thisNode.Role, thisNode.Addr)
+	return
+}
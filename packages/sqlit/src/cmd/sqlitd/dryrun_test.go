@@ -0,0 +1,87 @@
+// +build !testbinary
+
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+	. "github.com/smartystreets/goconvey/convey"
+
+	"sqlit/src/conf"
+	"sqlit/src/crypto/asymmetric"
+	"sqlit/src/crypto/kms"
+	"sqlit/src/proto"
+	"sqlit/src/route"
+	"sqlit/src/utils"
+)
+
+func TestDryRun(t *testing.T) {
+	Convey("dry run reports the node initialization without side effects", t, func() {
+		var (
+			dir      = t.TempDir()
+			leader   = proto.NodeID("0000000000000000000000000000000000000000000000000000000000000051")
+			miner    = proto.NodeID("0000000000000000000000000000000000000000000000000000000000000052")
+			observer = proto.NodeID("0000000000000000000000000000000000000000000000000000000000000053")
+			out      bytes.Buffer
+		)
+		defer func(saved *conf.Config) { conf.GConf = saved }(conf.GConf)
+		defer func(saved *conf.BPInfo) { kms.BP = saved }(kms.BP)
+		kms.ResetLocalKeyStore()
+		defer kms.ResetLocalKeyStore()
+		kms.ClosePublicKeyStore()
+		kms.SetLocalPeers(nil)
+		defer kms.SetLocalPeers(nil)
+
+		privateKey, _, err := asymmetric.GenSecp256k1KeyPair()
+		So(err, ShouldBeNil)
+		keyFile := filepath.Join(dir, "private.key")
+		So(kms.SavePrivateKey(keyFile, privateKey, nil), ShouldBeNil)
+		conf.GConf = &conf.Config{
+			UseTestMasterKey: true,
+			PrivateKeyFile:   keyFile,
+			PubKeyStoreFile:  filepath.Join(dir, "public.keystore"),
+			ThisNodeID:       miner,
+			BP:               &conf.BPInfo{NodeID: leader},
+			KnownNodes: []proto.Node{
+				{ID: leader, Role: proto.Leader, Addr: "127.0.0.1:1"},
+				{ID: miner, Role: proto.Miner, Addr: "127.0.0.1:2", Addrs: []string{"10.0.0.1:2"}},
+				{ID: observer, Role: proto.Observer, Addr: "127.0.0.1:3"},
+			},
+		}
+		kms.InitBP()
+		// the resolver loads the known nodes once, drop them to see the dry run writes
+		route.InitResolver()
+		for _, n := range conf.GConf.KnownNodes {
+			_ = route.DelNodeAddrCache(n.ID.ToRawNodeID())
+		}
+
+		So(runDryRun(miner, &out), ShouldBeNil)
+		report := out.String()
+		So(report, ShouldContainSubstring, "would open public keystore "+conf.GConf.PubKeyStoreFile)
+		So(report, ShouldContainSubstring, "would set route "+string(leader)+" -> 127.0.0.1:1")
+		So(report, ShouldContainSubstring, "would set route "+string(miner)+" -> 127.0.0.1:2 [10.0.0.1:2]")
+		So(report, ShouldContainSubstring, "would set local node id "+string(miner))
+		So(report, ShouldContainSubstring, "would set keystore node "+string(observer)+" role Observer")
+		So(report, ShouldContainSubstring, "peers: term 1 leader "+string(leader))
+		So(report, ShouldContainSubstring, "servers:   ["+string(leader)+"]")
+		So(report, ShouldContainSubstring, "observers: ["+string(observer)+"]")
+		So(report, ShouldContainSubstring, "local node: "+string(miner)+" role Miner addr 127.0.0.1:2")
+
+		// nothing is applied
+		_, err = kms.GetLocalPeers()
+		So(err, ShouldNotBeNil)
+		_, err = kms.GetAllNodeID()
+		So(err, ShouldEqual, kms.ErrPKSNotInitialized)
+		_, err = route.GetNodeAddrCache(observer.ToRawNodeID())
+		So(err, ShouldNotBeNil)
+		So(utils.Exist(conf.GConf.PubKeyStoreFile), ShouldBeFalse)
+
+		out.Reset()
+		err = runDryRun("0000000000000000000000000000000000000000000000000000000000000054", &out)
+		So(errors.Cause(err), ShouldEqual, errLocalNodeNotKnown)
+		So(out.String(), ShouldBeBlank)
+	})
+}
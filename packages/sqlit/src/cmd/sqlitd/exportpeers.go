@@ -0,0 +1,319 @@
+package main
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/pkg/errors"
+
+	"sqlit/src/conf"
+	"sqlit/src/crypto/asymmetric"
+	"sqlit/src/crypto/kms"
+	"sqlit/src/proto"
+	"sqlit/src/utils"
+)
+
+// peersSidecarSuffix is appended to the export file for its JSON sidecar.
+const peersSidecarSuffix = ".json"
+
+var (
+	// errUnverifiedPeers indicates the peers to export or import fail the signature check.
+	errUnverifiedPeers = errors.New("peers signature not verified, use -force to keep it anyway")
+	// errNotLeaderSigned indicates the peers are not signed by the key of their leader.
+	errNotLeaderSigned = errors.New("peers not signed by the leader")
+)
+
+// exportPeersOptions is the options of exportPeers.
+type exportPeersOptions struct {
+	// Out is the export file, the JSON sidecar is written next to it
+	Out string
+	// Force exports peers failing the signature check
+	Force bool
+}
+
+// exportPeersResult is the outcome of exportPeers.
+type exportPeersResult struct {
+	Peers       *proto.Peers
+	SidecarFile string
+	// VerifyErr is the signature check failure of the peers exported by force
+	VerifyErr error
+}
+
+// exportedPeers is the human readable JSON sidecar of an exported peers file, it
+// is for reading and comparing only, the peers are imported from the msgpack file.
+type exportedPeers struct {
+	Version   uint64         `json:"version"`
+	Term      uint64         `json:"term"`
+	Leader    proto.NodeID   `json:"leader"`
+	Servers   []proto.NodeID `json:"servers"`
+	Observers []proto.NodeID `json:"observers,omitempty"`
+	// Hash is the signing hash, two nodes with the same one hold the same membership
+	Hash          string                `json:"hash"`
+	SigneeKeyType string                `json:"signeeKeyType"`
+	Signee        string                `json:"signee"`
+	Signature     string                `json:"signature"`
+	Signatures    []exportedPeersSigner `json:"signatures,omitempty"`
+	Approvals     []exportedPeersSigner `json:"approvals,omitempty"`
+	Verified      bool                  `json:"verified"`
+	VerifyError   string                `json:"verifyError,omitempty"`
+}
+
+// exportedPeersSigner is a proto.PeersSignature in the JSON sidecar.
+type exportedPeersSigner struct {
+	Node      proto.NodeID `json:"node"`
+	KeyType   string       `json:"keyType"`
+	Signature string       `json:"signature"`
+}
+
+func newExportedPeersSigners(sigs []proto.PeersSignature) (signers []exportedPeersSigner) {
+	for _, s := range sigs {
+		signers = append(signers, exportedPeersSigner{
+			Node:      s.Node,
+			KeyType:   s.KeyType.String(),
+			Signature: hex.EncodeToString(s.Signature),
+		})
+	}
+	return
+}
+
+// newExportedPeers returns the JSON sidecar of peers, verifyErr is the result of
+// their signature check.
+func newExportedPeers(peers *proto.Peers, verifyErr error) (e exportedPeers) {
+	e = exportedPeers{
+		Version:       peers.Version,
+		Term:          peers.Term,
+		Leader:        peers.Leader,
+		Servers:       peers.Servers,
+		Observers:     peers.Observers,
+		SigneeKeyType: peers.SigneeKeyType.String(),
+		Verified:      verifyErr == nil,
+	}
+	if h, err := peers.SigningHash(); err == nil {
+		e.Hash = h.String()
+	}
+	if peers.SigneeKeyType == asymmetric.Secp256k1 {
+		if peers.Signee != nil {
+			e.Signee = hex.EncodeToString(peers.Signee.Serialize())
+		}
+		if peers.Signature != nil {
+			e.Signature = hex.EncodeToString(peers.Signature.Serialize())
+		}
+	} else {
+		e.Signee = hex.EncodeToString(peers.TypedSignee)
+		e.Signature = hex.EncodeToString(peers.TypedSignature)
+	}
+	e.Signatures = newExportedPeersSigners(peers.Signatures)
+	e.Approvals = newExportedPeersSigners(peers.Approvals)
+	if verifyErr != nil {
+		e.VerifyError = verifyErr.Error()
+	}
+	return
+}
+
+// verifyLeaderSigned returns the error of peers.VerifyLeader, errNotLeaderSigned if
+// the peers are not signed by their leader.
+func verifyLeaderSigned(peers *proto.Peers) (err error) {
+	valid, err := peers.VerifyLeader()
+	if err == nil && !valid {
+		err = errors.Wrapf(errNotLeaderSigned, "leader %s", peers.Leader)
+	}
+	return
+}
+
+// exportPeers copies the local peers to opts.Out as they are signed, in the canonical
+// msgpack of the peers file, and writes the JSON sidecar next to it. The peers are
+// checked by proto.Peers.VerifyLeader, the ones failing it are errUnverifiedPeers
+// unless forced.
+func exportPeers(opts exportPeersOptions) (result exportPeersResult, err error) {
+	if result.Peers, err = kms.GetLocalPeers(); err != nil {
+		err = errors.Wrap(err, "get local peers failed")
+		return
+	}
+	if result.VerifyErr = verifyLeaderSigned(result.Peers); result.VerifyErr != nil && !opts.Force {
+		err = errors.Wrapf(errUnverifiedPeers, "%v", result.VerifyErr)
+		return
+	}
+	if err = kms.WritePeersFile(opts.Out, result.Peers); err != nil {
+		return
+	}
+
+	content, err := json.MarshalIndent(newExportedPeers(result.Peers, result.VerifyErr), "", "  ")
+	if err != nil {
+		err = errors.Wrap(err, "encode peers sidecar failed")
+		return
+	}
+	result.SidecarFile = opts.Out + peersSidecarSuffix
+	if err = os.WriteFile(result.SidecarFile, append(content, '\n'), 0600); err != nil {
+		err = errors.Wrap(err, "write peers sidecar failed")
+	}
+	return
+}
+
+// importPeers replaces the peers persisted at peersFile by the exported peers at in,
+// the node adopts them on the next start. The exported peers must verify, be signed
+// by their leader by proto.Peers.VerifyLeader unless forced, and pass
+// proto.Peers.AcceptInto over the persisted ones, so an older export never rolls the
+// membership back, it is a *proto.StaleTermError otherwise.
+func importPeers(in, peersFile string, force bool) (peers *proto.Peers, err error) {
+	if peers, err = kms.LoadPeersFile(in); err != nil {
+		err = errors.Wrapf(err, "load exported peers %s failed", in)
+		return
+	}
+	if verifyErr := verifyLeaderSigned(peers); verifyErr != nil && !force {
+		err = errors.Wrapf(errUnverifiedPeers, "%s: %v", in, verifyErr)
+		return
+	}
+	var current *proto.Peers
+	if utils.Exist(peersFile) {
+		if current, err = kms.LoadPeersFile(peersFile); err != nil {
+			err = errors.Wrapf(errPersistedPeers, "%s: %v", peersFile, err)
+			return
+		}
+	}
+	if err = peers.AcceptInto(current); err != nil {
+		return
+	}
+	err = kms.WritePeersFile(peersFile, peers)
+	return
+}
+
+// peersConfigOf returns the config at configPath if it is found, the config is
+// optional when the peers file is given.
+func peersConfigOf(configPath string) (config *conf.Config, err error) {
+	path, err := conf.FindConfigFile(configPath, os.LookupEnv)
+	if err != nil {
+		return
+	}
+	if config, err = conf.LoadConfig(path); err != nil {
+		config, err = nil, errors.Wrap(err, "load config failed")
+	}
+	return
+}
+
+// openPeersKeyStore opens the public keystore of config for proto.Peers.VerifyLeader,
+// nothing is opened for a nil config. The caller closes it by kms.ClosePublicKeyStore.
+func openPeersKeyStore(config *conf.Config) (err error) {
+	if config == nil {
+		return
+	}
+	conf.GConf = config
+	if err = kms.InitPublicKeyStore(config.PubKeyStoreFile, nil); err != nil {
+		err = errors.Wrap(err, "open public keystore failed")
+	}
+	return
+}
+
+// loadLocalPeers sets the peers persisted at peersFile as the local peers as they are
+// signed.
+func loadLocalPeers(peersFile string) (err error) {
+	peers, err := kms.ReadPeersFile(peersFile)
+	if err != nil {
+		return errors.Wrapf(err, "read peers file %s failed", peersFile)
+	}
+	kms.SetLocalPeers(peers)
+	return
+}
+
+// runExportPeers runs the export-peers command with args after the command name and
+// returns the exit code. It is an offline export of the peers snapshot the node saves
+// to the PeersFile of -config on shutdown, not of the peers a running node holds, so
+// the node is stopped first for its current peers. The peers are verified against the
+// public keystore of the config, without a config the leader key is unknown and the
+// peers are exported by -force only.
+func runExportPeers(args []string, w io.Writer) int {
+	var (
+		flags      = flag.NewFlagSet("export-peers", flag.ContinueOnError)
+		configPath = flags.String("config", configFile, "Config file to take PeersFile from, it is searched like "+name)
+		peersFile  = flags.String("peers", "", "Peers snapshot to export, default is PeersFile of the config saved on shutdown")
+		out        = flags.String("out", "", "Export file, the JSON sidecar is written to it with "+peersSidecarSuffix+" appended")
+		force      = flags.Bool("force", false, "Export the peers even if the signature check fails")
+	)
+	flags.SetOutput(w)
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *out == "" {
+		_, _ = fmt.Fprintln(w, "error: -out is required")
+		return 2
+	}
+	config, err := peersConfigOf(*configPath)
+	if err != nil && *peersFile == "" {
+		_, _ = fmt.Fprintf(w, "error: %v\n", err)
+		return 1
+	}
+	path := *peersFile
+	if path == "" {
+		path = config.PeersFile
+	}
+	defer kms.ClosePublicKeyStore()
+	if err = openPeersKeyStore(config); err == nil {
+		err = loadLocalPeers(path)
+	}
+	if err != nil {
+		_, _ = fmt.Fprintf(w, "error: %v\n", err)
+		return 1
+	}
+
+	opts := exportPeersOptions{Out: utils.HomeDirExpand(*out), Force: *force}
+	result, err := exportPeers(opts)
+	if err != nil {
+		_, _ = fmt.Fprintf(w, "error: %v\n", err)
+		return 1
+	}
+	if result.VerifyErr != nil {
+		_, _ = fmt.Fprintf(w, "warning: exported unverified peers: %v\n", result.VerifyErr)
+	}
+	_, _ = fmt.Fprintf(w, "term:    %d\n", result.Peers.Term)
+	_, _ = fmt.Fprintf(w, "leader:  %s\n", result.Peers.Leader)
+	_, _ = fmt.Fprintf(w, "servers: %d\n", len(result.Peers.Servers))
+	_, _ = fmt.Fprintf(w, "peers:   %s\n", opts.Out)
+	_, _ = fmt.Fprintf(w, "sidecar: %s\n", result.SidecarFile)
+	return 0
+}
+
+// runImportPeers runs the import-peers command with args after the command name and
+// returns the exit code. The node must be stopped, it adopts the peers on start. The
+// peers are verified against the public keystore of the config like export-peers.
+func runImportPeers(args []string, w io.Writer) int {
+	var (
+		flags      = flag.NewFlagSet("import-peers", flag.ContinueOnError)
+		configPath = flags.String("config", configFile, "Config file to take PeersFile from, it is searched like "+name)
+		peersFile  = flags.String("peers", "", "Peers file to replace, default is PeersFile of the config")
+		in         = flags.String("in", "", "Peers file written by export-peers")
+		force      = flags.Bool("force", false, "Import the peers even if they are not signed by their leader")
+	)
+	flags.SetOutput(w)
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *in == "" {
+		_, _ = fmt.Fprintln(w, "error: -in is required")
+		return 2
+	}
+	config, err := peersConfigOf(*configPath)
+	if err != nil && *peersFile == "" {
+		_, _ = fmt.Fprintf(w, "error: %v\n", err)
+		return 1
+	}
+	dest := *peersFile
+	if dest == "" {
+		dest = config.PeersFile
+	}
+	defer kms.ClosePublicKeyStore()
+	if err = openPeersKeyStore(config); err != nil {
+		_, _ = fmt.Fprintf(w, "error: %v\n", err)
+		return 1
+	}
+
+	peers, err := importPeers(utils.HomeDirExpand(*in), dest, *force)
+	if err != nil {
+		_, _ = fmt.Fprintf(w, "error: %v\n", err)
+		return 1
+	}
+	_, _ = fmt.Fprintf(w, "imported peers of term %d led by %s to %s\n", peers.Term, peers.Leader, dest)
+	return 0
+}
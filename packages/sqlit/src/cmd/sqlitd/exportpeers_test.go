@@ -0,0 +1,247 @@
+// +build !testbinary
+
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+	. "github.com/smartystreets/goconvey/convey"
+
+	"sqlit/src/conf"
+	"sqlit/src/crypto/asymmetric"
+	"sqlit/src/crypto/kms"
+	"sqlit/src/proto"
+)
+
+func TestExportPeers(t *testing.T) {
+	Convey("the signed peers are exported with a JSON sidecar and imported on another node", t, func() {
+		var (
+			dir      = t.TempDir()
+			leader   = proto.NodeID("00000000000000000000000000000000000000000000000000000000000000a1")
+			follower = proto.NodeID("00000000000000000000000000000000000000000000000000000000000000a2")
+			out      = filepath.Join(dir, "backup.peers")
+		)
+		leaderKey, leaderPublic, err := asymmetric.GenSecp256k1KeyPair()
+		So(err, ShouldBeNil)
+		proto.SetNodeKeyResolver(func(id proto.NodeID) (asymmetric.TypedPublicKey, error) {
+			return leaderPublic, nil
+		})
+		defer proto.SetNodeKeyResolver(kms.GetTypedPublicKey)
+		signWith := func(privateKey *asymmetric.PrivateKey, term uint64) *proto.Peers {
+			peers := &proto.Peers{PeersHeader: proto.PeersHeader{
+				Version: proto.PeersVersion,
+				Term:    term,
+				Leader:  leader,
+				Servers: []proto.NodeID{leader, follower},
+			}}
+			So(peers.Sign(privateKey), ShouldBeNil)
+			return peers
+		}
+		sign := func(term uint64) *proto.Peers {
+			privateKey, _, err := asymmetric.GenSecp256k1KeyPair()
+			So(err, ShouldBeNil)
+			return signWith(privateKey, term)
+		}
+		defer kms.SetLocalPeers(nil)
+		held := signWith(leaderKey, 6)
+		kms.SetLocalPeers(held)
+
+		result, err := exportPeers(exportPeersOptions{Out: out})
+		So(err, ShouldBeNil)
+		So(result.VerifyErr, ShouldBeNil)
+		So(result.SidecarFile, ShouldEqual, out+".json")
+		// the export is the peers held as they are signed
+		So(result.Peers, ShouldEqual, held)
+		exported, err := kms.ReadPeersFile(out)
+		So(err, ShouldBeNil)
+		So(exported.Signee.IsEqual(held.Signee), ShouldBeTrue)
+		So(exported.Signature.IsEqual(held.Signature), ShouldBeTrue)
+
+		content, err := os.ReadFile(result.SidecarFile)
+		So(err, ShouldBeNil)
+		var sidecar exportedPeers
+		So(json.Unmarshal(content, &sidecar), ShouldBeNil)
+		So(sidecar.Term, ShouldEqual, 6)
+		So(sidecar.Leader, ShouldEqual, leader)
+		So(sidecar.Servers, ShouldResemble, []proto.NodeID{leader, follower})
+		So(sidecar.Verified, ShouldBeTrue)
+		So(sidecar.SigneeKeyType, ShouldEqual, asymmetric.Secp256k1.String())
+		So(sidecar.Signee, ShouldEqual, hex.EncodeToString(held.Signee.Serialize()))
+		So(sidecar.Signature, ShouldEqual, hex.EncodeToString(held.Signature.Serialize()))
+		h, err := held.SigningHash()
+		So(err, ShouldBeNil)
+		So(sidecar.Hash, ShouldEqual, h.String())
+
+		// another node adopts the membership over an older term only
+		other := filepath.Join(dir, "other.peers")
+		So(kms.WritePeersFile(other, sign(4)), ShouldBeNil)
+		imported, err := importPeers(out, other, false)
+		So(err, ShouldBeNil)
+		So(imported.Term, ShouldEqual, 6)
+		adopted, err := kms.LoadPeersFile(other)
+		So(err, ShouldBeNil)
+		So(adopted.Servers, ShouldResemble, held.Servers)
+
+		newer := filepath.Join(dir, "newer.peers")
+		So(kms.WritePeersFile(newer, sign(9)), ShouldBeNil)
+		_, err = importPeers(out, newer, false)
+		So(errors.Cause(err), ShouldEqual, proto.ErrStaleTerm)
+		kept, err := kms.LoadPeersFile(newer)
+		So(err, ShouldBeNil)
+		So(kept.Term, ShouldEqual, 9)
+
+		// a node without peers takes the export as it is
+		fresh := filepath.Join(dir, "fresh.peers")
+		_, err = importPeers(out, fresh, false)
+		So(err, ShouldBeNil)
+
+		// the peers signed by another key than the one of the leader are imported by force only
+		forged := filepath.Join(dir, "forged-import.peers")
+		So(kms.WritePeersFile(forged, sign(10)), ShouldBeNil)
+		_, err = importPeers(forged, fresh, false)
+		So(errors.Cause(err), ShouldEqual, errUnverifiedPeers)
+		kept, err = kms.LoadPeersFile(fresh)
+		So(err, ShouldBeNil)
+		So(kept.Term, ShouldEqual, 6)
+		imported, err = importPeers(forged, fresh, true)
+		So(err, ShouldBeNil)
+		So(imported.Term, ShouldEqual, 10)
+
+		// the peers signed by another key than the one of the leader are not verified
+		kms.SetLocalPeers(sign(7))
+		_, err = exportPeers(exportPeersOptions{Out: filepath.Join(dir, "forged.peers")})
+		So(errors.Cause(err), ShouldEqual, errUnverifiedPeers)
+		So(err.Error(), ShouldContainSubstring, errNotLeaderSigned.Error())
+	})
+	Convey("the peers file is verified against the keystore of the config", t, func() {
+		defer func(saved *conf.Config) { conf.GConf = saved }(conf.GConf)
+		defer func(saved *conf.BPInfo) { kms.BP = saved }(kms.BP)
+		defer kms.SetLocalPeers(nil)
+		defer kms.ClosePublicKeyStore()
+		var (
+			dir        = t.TempDir()
+			configPath = filepath.Join(dir, "config.yaml")
+			out        = filepath.Join(dir, "backup.peers")
+		)
+		privateKey, publicKey, err := asymmetric.GenSecp256k1KeyPair()
+		So(err, ShouldBeNil)
+		nonce := kms.MineNodeNonce(publicKey, 0)
+		leader := proto.NodeID(nonce.Hash.String())
+		So(os.WriteFile(configPath, []byte(`
+PubKeyStoreFile: "public.keystore"
+PeersFile: "dht.db.peers"
+BlockProducer:
+  NodeID: "00000000000000000000000000000000000000000000000000000000000000b1"
+`), 0600), ShouldBeNil)
+		config, err := conf.LoadConfig(configPath)
+		So(err, ShouldBeNil)
+		conf.GConf = config
+		So(kms.InitPublicKeyStore(config.PubKeyStoreFile, nil), ShouldBeNil)
+		So(kms.SetNode(&proto.Node{ID: leader, PublicKey: publicKey, Nonce: nonce.Nonce}), ShouldBeNil)
+		kms.ClosePublicKeyStore()
+
+		peers := &proto.Peers{PeersHeader: proto.PeersHeader{
+			Version: proto.PeersVersion,
+			Term:    2,
+			Leader:  leader,
+			Servers: []proto.NodeID{leader},
+		}}
+		So(peers.Sign(privateKey), ShouldBeNil)
+		So(kms.WritePeersFile(config.PeersFile, peers), ShouldBeNil)
+
+		var w bytes.Buffer
+		So(runExportPeers([]string{"-config", configPath, "-out", out}, &w), ShouldEqual, 0)
+		So(w.String(), ShouldNotContainSubstring, "warning")
+		content, err := os.ReadFile(out + ".json")
+		So(err, ShouldBeNil)
+		var sidecar exportedPeers
+		So(json.Unmarshal(content, &sidecar), ShouldBeNil)
+		So(sidecar.Term, ShouldEqual, 2)
+		So(sidecar.Verified, ShouldBeTrue)
+
+		// a follower saves the peers of the leader as they are signed and exports them
+		kms.ResetLocalKeyStore()
+		defer kms.ResetLocalKeyStore()
+		followerKey, followerPublic, err := asymmetric.GenSecp256k1KeyPair()
+		So(err, ShouldBeNil)
+		kms.SetLocalKeyPair(followerKey, followerPublic)
+		kms.SetLocalPeers(peers)
+		So(kms.SaveLocalPeers(config.PeersFile), ShouldBeNil)
+		saved, err := kms.LoadPeersFile(config.PeersFile)
+		So(err, ShouldBeNil)
+		So(saved.Signee.IsEqual(publicKey), ShouldBeTrue)
+		w.Reset()
+		So(runExportPeers([]string{"-config", configPath, "-out", out}, &w), ShouldEqual, 0)
+		So(w.String(), ShouldNotContainSubstring, "warning")
+
+		// the export is imported on another node with the keystore of the config
+		other := filepath.Join(t.TempDir(), "other.peers")
+		So(runImportPeers([]string{"-config", configPath, "-in", out, "-peers", other}, &w), ShouldEqual, 0)
+
+		// the peers signed by the follower itself are not the ones of the leader
+		unsigned := peers.Clone()
+		unsigned.Term = 3
+		kms.SetLocalPeers(unsigned)
+		So(kms.SaveLocalPeers(config.PeersFile), ShouldBeNil)
+		saved, err = kms.LoadPeersFile(config.PeersFile)
+		So(err, ShouldBeNil)
+		So(saved.Signee.IsEqual(followerPublic), ShouldBeTrue)
+		w.Reset()
+		So(runExportPeers([]string{"-config", configPath, "-out", out + ".follower"}, &w), ShouldEqual, 1)
+		So(w.String(), ShouldContainSubstring, errNotLeaderSigned.Error())
+		So(runExportPeers([]string{"-config", configPath, "-out", out + ".follower", "-force"}, &w), ShouldEqual, 0)
+		w.Reset()
+		So(runImportPeers([]string{"-config", configPath, "-in", out + ".follower", "-peers", other}, &w), ShouldEqual, 1)
+		So(w.String(), ShouldContainSubstring, errUnverifiedPeers.Error())
+	})
+	Convey("the unverified peers are exported by force only", t, func() {
+		defer kms.SetLocalPeers(nil)
+		var (
+			dir        = t.TempDir()
+			peersFile  = filepath.Join(dir, "dht.db.peers")
+			out        = filepath.Join(dir, "backup.peers")
+			noConfig   = filepath.Join(dir, "missing.yaml")
+			exportArgs = []string{"-config", noConfig, "-peers", peersFile, "-out", out}
+		)
+		privateKey, _, err := asymmetric.GenSecp256k1KeyPair()
+		So(err, ShouldBeNil)
+		peers := &proto.Peers{PeersHeader: proto.PeersHeader{
+			Version: proto.PeersVersion,
+			Term:    3,
+			Leader:  proto.NodeID("00000000000000000000000000000000000000000000000000000000000000a1"),
+			Servers: []proto.NodeID{"00000000000000000000000000000000000000000000000000000000000000a1"},
+		}}
+		So(peers.Sign(privateKey), ShouldBeNil)
+		peers.Term = 30
+		So(kms.WritePeersFile(peersFile, peers), ShouldBeNil)
+
+		kms.SetLocalPeers(peers)
+		_, err = exportPeers(exportPeersOptions{Out: out})
+		So(errors.Cause(err), ShouldEqual, errUnverifiedPeers)
+		_, err = os.Stat(out)
+		So(os.IsNotExist(err), ShouldBeTrue)
+
+		var w bytes.Buffer
+		So(runExportPeers(exportArgs, &w), ShouldEqual, 1)
+		So(runExportPeers([]string{"-peers", peersFile}, &w), ShouldEqual, 2)
+		w.Reset()
+		So(runExportPeers(append(exportArgs, "-force"), &w), ShouldEqual, 0)
+		So(w.String(), ShouldContainSubstring, "warning: exported unverified peers")
+		content, err := os.ReadFile(out + ".json")
+		So(err, ShouldBeNil)
+		var sidecar exportedPeers
+		So(json.Unmarshal(content, &sidecar), ShouldBeNil)
+		So(sidecar.Term, ShouldEqual, 30)
+		So(sidecar.Verified, ShouldBeFalse)
+		So(sidecar.VerifyError, ShouldNotBeBlank)
+
+		// the forced export is still refused on import
+		So(runImportPeers([]string{"-in", out, "-peers", filepath.Join(dir, "other.peers")}, &w), ShouldEqual, 1)
+	})
+}
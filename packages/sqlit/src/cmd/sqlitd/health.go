@@ -0,0 +1,185 @@
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"sqlit/src/conf"
+	"sqlit/src/crypto/kms"
+	"sqlit/src/proto"
+	"sqlit/src/route"
+	"sqlit/src/utils/log"
+)
+
+var (
+	// errShuttingDown indicates the node is in the graceful shutdown
+	errShuttingDown = errors.New("node is shutting down")
+	// errConfigNotLoaded indicates conf.GConf is not set
+	errConfigNotLoaded = errors.New("config not loaded")
+)
+
+// healthCheck is the result of a readiness check.
+type healthCheck struct {
+	Name  string `json:"name"`
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// readiness is the body of /readyz.
+type readiness struct {
+	Ready  bool          `json:"ready"`
+	Checks []healthCheck `json:"checks"`
+	// Banned is the nodes not routed to by route.Ban
+	Banned []bannedNode `json:"banned,omitempty"`
+	// Servers is the liveness of the other peers servers, it does not fail the
+	// readiness as an idle node sees no one
+	Servers []serverLiveness `json:"servers,omitempty"`
+}
+
+// bannedNode is an active route ban.
+type bannedNode struct {
+	Node  proto.NodeID `json:"node"`
+	Until time.Time    `json:"until"`
+}
+
+// serverLiveness is the liveness of a peers server by its last successful rpc.
+type serverLiveness struct {
+	Node     proto.NodeID   `json:"node"`
+	Liveness route.Liveness `json:"liveness"`
+	LastSeen *time.Time     `json:"last_seen,omitempty"`
+}
+
+// newHealthHandler returns the handler of /healthz and /readyz, the node is not ready
+// once ctx is done.
+func newHealthHandler(ctx context.Context) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeHealthJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		result := checkReadiness(ctx)
+		status := http.StatusOK
+		if !result.Ready {
+			status = http.StatusServiceUnavailable
+		}
+		writeHealthJSON(w, status, result)
+	})
+	return mux
+}
+
+func writeHealthJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.WithError(err).Debug("write health response failed")
+	}
+}
+
+// checkReadiness runs all readiness checks, the node is ready if all of them pass.
+func checkReadiness(ctx context.Context) (result readiness) {
+	result.Checks = []healthCheck{
+		checkRunning(ctx),
+		checkConfigLoaded(),
+		checkLocalKey(),
+		checkPeersResolvable(),
+	}
+	result.Ready = true
+	for _, c := range result.Checks {
+		result.Ready = result.Ready && c.OK
+	}
+	for _, b := range route.Bans() {
+		result.Banned = append(result.Banned, bannedNode{Node: b.ID, Until: b.Until})
+	}
+	result.Servers = serversLiveness()
+	return
+}
+
+// serversLiveness returns the liveness of the peers servers except the local node by
+// the staleness thresholds of the route probe config.
+func serversLiveness() (servers []serverLiveness) {
+	peers, err := kms.GetLocalPeers()
+	if err != nil {
+		return
+	}
+	var info conf.RouteProbeInfo
+	if conf.GConf != nil && conf.GConf.RouteProbe != nil {
+		info = *conf.GConf.RouteProbe
+	}
+	for _, id := range peers.Servers {
+		if conf.GConf != nil && id == conf.GConf.ThisNodeID {
+			continue
+		}
+		liveness, seen := route.NodeLiveness(id, info)
+		server := serverLiveness{Node: id, Liveness: liveness}
+		if !seen.IsZero() {
+			server.LastSeen = &seen
+		}
+		servers = append(servers, server)
+	}
+	return
+}
+
+func newHealthCheck(name string, err error) (c healthCheck) {
+	c.Name = name
+	c.OK = err == nil
+	if err != nil {
+		c.Error = err.Error()
+	}
+	return
+}
+
+func checkRunning(ctx context.Context) healthCheck {
+	if ctx.Err() != nil {
+		return newHealthCheck("running", errShuttingDown)
+	}
+	return newHealthCheck("running", nil)
+}
+
+func checkConfigLoaded() healthCheck {
+	if conf.GConf == nil {
+		return newHealthCheck("config", errConfigNotLoaded)
+	}
+	return newHealthCheck("config", nil)
+}
+
+func checkLocalKey() healthCheck {
+	if _, err := kms.GetLocalPrivateKey(); err != nil {
+		// the keys of a hardware provider never leave the token
+		if _, pubErr := kms.GetLocalKeyProvider().PublicKey(); pubErr != nil {
+			return newHealthCheck("local_key", err)
+		}
+	}
+	return newHealthCheck("local_key", nil)
+}
+
+func checkPeersResolvable() healthCheck {
+	peers, err := kms.GetLocalPeers()
+	if err != nil {
+		return newHealthCheck("peers", err)
+	}
+	// a server is resolvable if cached, not banned and not found unreachable by the
+	// route probes
+	resolvable := make(map[proto.NodeID]bool, len(peers.Servers))
+	for _, id := range peers.Servers {
+		if _, cacheErr := route.GetNodeAddrCache(id.ToRawNodeID()); cacheErr == nil {
+			status, healthErr := route.NodeHealth(id.ToRawNodeID())
+			resolvable[id] = healthErr == nil && status.Reachable && !route.IsBanned(id)
+		}
+	}
+	if !peers.HasQuorum(resolvable) {
+		return newHealthCheck("peers", errors.Errorf(
+			"%d of %d servers resolvable, quorum is %d",
+			len(resolvable), len(peers.Servers), peers.Quorum()))
+	}
+	return newHealthCheck("peers", nil)
+}
+
+// startHealthServer serves the health endpoints on addr.
+func startHealthServer(ctx context.Context, addr string) (*http.Server, error) {
+	return startHTTPServer("health", addr, newHealthHandler(ctx))
+}
@@ -0,0 +1,127 @@
+// +build !testbinary
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"sqlit/src/conf"
+	"sqlit/src/crypto/asymmetric"
+	"sqlit/src/crypto/kms"
+	"sqlit/src/proto"
+	"sqlit/src/route"
+)
+
+func TestHealthHandler(t *testing.T) {
+	Convey("readiness reports the failed checks", t, func() {
+		var (
+			servers = []proto.NodeID{
+				"0000000000000000000000000000000000000000000000000000000000000021",
+				"0000000000000000000000000000000000000000000000000000000000000022",
+				"0000000000000000000000000000000000000000000000000000000000000023",
+			}
+			ctx, cancel = context.WithCancel(context.Background())
+			handler     = newHealthHandler(ctx)
+			probe       = func(path string) (status int, result readiness) {
+				rec := httptest.NewRecorder()
+				handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+				So(rec.Header().Get("Content-Type"), ShouldEqual, "application/json")
+				if path == "/readyz" {
+					So(json.Unmarshal(rec.Body.Bytes(), &result), ShouldBeNil)
+				}
+				return rec.Code, result
+			}
+			failed = func(result readiness) (names []string) {
+				for _, c := range result.Checks {
+					if !c.OK {
+						So(c.Error, ShouldNotBeBlank)
+						names = append(names, c.Name)
+					}
+				}
+				return
+			}
+		)
+		defer cancel()
+		defer func(saved *conf.Config) { conf.GConf = saved }(conf.GConf)
+		kms.ResetLocalKeyStore()
+		defer kms.ResetLocalKeyStore()
+		defer kms.SetLocalPeers(nil)
+		kms.SetLocalPeers(nil)
+		conf.GConf = nil
+
+		// liveness never depends on the node state
+		status, _ := probe("/healthz")
+		So(status, ShouldEqual, http.StatusOK)
+
+		status, result := probe("/readyz")
+		So(status, ShouldEqual, http.StatusServiceUnavailable)
+		So(result.Ready, ShouldBeFalse)
+		So(failed(result), ShouldResemble, []string{"config", "local_key", "peers"})
+
+		conf.GConf = &conf.Config{}
+		privKey, pubKey, err := asymmetric.GenSecp256k1KeyPair()
+		So(err, ShouldBeNil)
+		kms.SetLocalKeyPair(privKey, pubKey)
+		kms.SetLocalPeers(&proto.Peers{PeersHeader: proto.PeersHeader{Leader: servers[0], Servers: servers}})
+		for _, id := range servers[:2] {
+			So(route.SetNodeAddrCache(id.ToRawNodeID(), "a:1"), ShouldBeNil)
+			defer route.DelNodeAddrCache(id.ToRawNodeID())
+		}
+		status, result = probe("/readyz")
+		So(status, ShouldEqual, http.StatusOK)
+		So(result.Ready, ShouldBeTrue)
+		So(failed(result), ShouldBeEmpty)
+
+		// a minority of resolvable servers has no quorum
+		So(route.DelNodeAddrCache(servers[1].ToRawNodeID()), ShouldBeNil)
+		status, result = probe("/readyz")
+		So(status, ShouldEqual, http.StatusServiceUnavailable)
+		So(failed(result), ShouldResemble, []string{"peers"})
+
+		So(route.SetNodeAddrCache(servers[1].ToRawNodeID(), "a:2"), ShouldBeNil)
+		_, result = probe("/readyz")
+		So(result.Ready, ShouldBeTrue)
+		So(result.Banned, ShouldBeEmpty)
+
+		// the other servers report their liveness without failing the readiness
+		conf.GConf.ThisNodeID = servers[2]
+		route.MarkSeen(servers[0])
+		_, result = probe("/readyz")
+		So(result.Ready, ShouldBeTrue)
+		So(result.Servers, ShouldHaveLength, 2)
+		So(result.Servers[0].Node, ShouldEqual, servers[0])
+		So(result.Servers[0].Liveness, ShouldEqual, route.LivenessAlive)
+		So(result.Servers[0].LastSeen, ShouldNotBeNil)
+		So(result.Servers[1].Liveness, ShouldEqual, route.LivenessUnknown)
+		So(result.Servers[1].LastSeen, ShouldBeNil)
+		conf.GConf.ThisNodeID = ""
+
+		// a banned server is not resolvable
+		So(route.Ban(servers[1], time.Minute), ShouldBeNil)
+		status, result = probe("/readyz")
+		So(status, ShouldEqual, http.StatusServiceUnavailable)
+		So(failed(result), ShouldResemble, []string{"peers"})
+		So(result.Banned, ShouldHaveLength, 1)
+		So(result.Banned[0].Node, ShouldEqual, servers[1])
+		So(route.Unban(servers[1]), ShouldBeTrue)
+
+		cancel()
+		status, result = probe("/readyz")
+		So(status, ShouldEqual, http.StatusServiceUnavailable)
+		So(failed(result), ShouldResemble, []string{"running"})
+	})
+	Convey("health server is stopped on shutdown", t, func() {
+		server, err := startHealthServer(context.Background(), "127.0.0.1:0")
+		So(err, ShouldBeNil)
+		So(stopHTTPServer(server), ShouldBeNil)
+		_, err = startHealthServer(context.Background(), "127.0.0.1:-1")
+		So(err, ShouldNotBeNil)
+	})
+}
@@ -0,0 +1,45 @@
+
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+
+	"sqlit/src/utils/log"
+)
+
+const (
+	// httpReadHeaderTimeout bounds the reading of a request header.
+	httpReadHeaderTimeout = 5 * time.Second
+	// httpShutdownTimeout bounds the draining of the requests on shutdown.
+	httpShutdownTimeout = 5 * time.Second
+)
+
+// startHTTPServer serves handler on addr until the returned server is stopped by
+// stopHTTPServer, name is only used in logs.
+func startHTTPServer(name string, addr string, handler http.Handler) (server *http.Server, err error) {
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return
+	}
+	server = &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: httpReadHeaderTimeout,
+	}
+	go func() {
+		if serveErr := server.Serve(ln); serveErr != nil && serveErr != http.ErrServerClosed {
+			log.WithField("server", name).WithError(serveErr).Error("http server stopped")
+		}
+	}()
+	log.WithFields(log.Fields{"server": name, "addr": ln.Addr().String()}).Info("http server started")
+	return
+}
+
+// stopHTTPServer drains the requests of server.
+func stopHTTPServer(server *http.Server) error {
+	ctx, cancel := context.WithTimeout(context.Background(), httpShutdownTimeout)
+	defer cancel()
+	return server.Shutdown(ctx)
+}
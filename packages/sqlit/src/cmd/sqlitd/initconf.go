@@ -2,85 +2,310 @@
 package main
 
 import (
+	"context"
+	"runtime"
+	"sync"
+
+	"github.com/pkg/errors"
+
 	"sqlit/src/conf"
+	"sqlit/src/crypto/asymmetric"
 	"sqlit/src/crypto/hash"
 	"sqlit/src/crypto/kms"
+	mine "sqlit/src/pow/cpuminer"
 	"sqlit/src/proto"
 	"sqlit/src/route"
 	"sqlit/src/utils/log"
 )
 
-func initNodePeers(nodeID proto.NodeID, publicKeystorePath string) (nodes *[]proto.Node, peers *proto.Peers, thisNode *proto.Node, err error) {
-	privateKey, err := kms.GetLocalPrivateKey()
-	if err != nil {
-		log.WithError(err).Fatal("get local private key failed")
+// nodeMutator applies the node state built by initNodePeers to the route cache and
+// the keystores, dryRunRecorder only records it.
+type nodeMutator interface {
+	SetLocalPeers(peers *proto.Peers)
+	InitResolver()
+	InitPublicKeyStore(path string) error
+	SetNodeAddrCache(id *proto.RawNodeID, addr string, alternates ...string) error
+	SetNodeAddrCacheBatch(entries map[*proto.RawNodeID]string) error
+	SetLocalNodeIDNonce(rawNodeID []byte, nonce *mine.Uint256)
+	SetNodes(nodes []*proto.Node) error
+}
+
+// liveNodeMutator is the nodeMutator of a running node.
+type liveNodeMutator struct{}
+
+func (liveNodeMutator) SetLocalPeers(peers *proto.Peers) { kms.SetLocalPeers(peers) }
+func (liveNodeMutator) InitResolver()                    { route.InitResolver() }
+func (liveNodeMutator) InitPublicKeyStore(path string) error {
+	return kms.InitPublicKeyStore(path, nil)
+}
+func (liveNodeMutator) SetNodeAddrCache(id *proto.RawNodeID, addr string, alternates ...string) error {
+	return route.SetNodeAddrCache(id, addr, alternates...)
+}
+func (liveNodeMutator) SetNodeAddrCacheBatch(entries map[*proto.RawNodeID]string) error {
+	return route.SetNodeAddrCacheBatch(entries)
+}
+func (liveNodeMutator) SetLocalNodeIDNonce(rawNodeID []byte, nonce *mine.Uint256) {
+	kms.SetLocalNodeIDNonce(rawNodeID, nonce)
+}
+func (liveNodeMutator) SetNodes(nodes []*proto.Node) error {
+	return kms.SetNodes(nodes, kms.WithWorkers(initNodeWorkers), knownNodeVerifyOpt(conf.GConf))
+}
+
+// knownNodeVerifyOpt returns the kms option to skip verifying the known node ids if
+// cfg.SkipNodeIDVerify is set, the known nodes are verified by default.
+func knownNodeVerifyOpt(cfg *conf.Config) kms.SetNodesOpt {
+	if cfg != nil && cfg.SkipNodeIDVerify {
+		return kms.WithoutVerifyID()
 	}
+	return nil
+}
+
+var (
+	// errLocalNodeNotKnown indicates the local node is missing from KnownNodes
+	errLocalNodeNotKnown = errors.New("local node not present in KnownNodes")
+	// errLocalKeyMismatch indicates the configured public key of the local node is
+	// not the one of the local private key
+	errLocalKeyMismatch = errors.New("local node public key does not match the local private key")
+)
 
-	peers = &proto.Peers{
-		PeersHeader: proto.PeersHeader{
-			Term:   1,
-			Leader: conf.GConf.BP.NodeID,
-		},
+// checkLocalNode checks nodeID is in KnownNodes and its configured public key, or the
+// block producer key for the block producer entry, is localPublic.
+func checkLocalNode(nodeID proto.NodeID, localPublic asymmetric.TypedPublicKey) (err error) {
+	for i := range conf.GConf.KnownNodes {
+		n := &conf.GConf.KnownNodes[i]
+		if n.ID != nodeID {
+			continue
+		}
+		configured, keyErr := n.TypedPublicKey()
+		if keyErr != nil && kms.BP != nil && n.ID == kms.BP.NodeID && kms.BP.PublicKey != nil {
+			configured, keyErr = kms.BP.PublicKey, nil
+		}
+		if keyErr == nil && !asymmetric.TypedPublicKeyEqual(configured, localPublic) {
+			return errors.Wrapf(errLocalKeyMismatch, "local node %s", nodeID)
+		}
+		return
 	}
+	return errors.Wrapf(errLocalNodeNotKnown, "local node %s", nodeID)
+}
 
-	if conf.GConf.KnownNodes != nil {
-		for i, n := range conf.GConf.KnownNodes {
-			if n.Role == proto.Leader || n.Role == proto.Follower {
-				//FIXME all KnownNodes
-				conf.GConf.KnownNodes[i].PublicKey = kms.BP.PublicKey
-				peers.Servers = append(peers.Servers, n.ID)
-			}
+// initNodeWorkers bounds the goroutines preparing and verifying the known nodes.
+var initNodeWorkers = runtime.NumCPU()
+
+// preparedNode is a known node with its parsed ID and resolved public key.
+type preparedNode struct {
+	rawNodeID *proto.RawNodeID
+	node      *proto.Node
+}
+
+// prepareKnownNodes parses the IDs and resolves the public keys of knownNodes with up
+// to workers goroutines, the resolved keys are stored back to knownNodes. The result
+// is in the order of knownNodes, the failed nodes are all returned as kms.NodesError.
+func prepareKnownNodes(knownNodes []proto.Node, workers int) (prepared []preparedNode, err error) {
+	prepared = make([]preparedNode, len(knownNodes))
+	errs := make([]error, len(knownNodes))
+	prepare := func(i int) {
+		n := &knownNodes[i]
+		n.PublicKey = knownNodePublicKey(n)
+		rawNodeIDHash, hashErr := hash.NewHashFromStr(string(n.ID))
+		if hashErr != nil {
+			errs[i] = hashErr
+			return
+		}
+		prepared[i] = preparedNode{
+			rawNodeID: &proto.RawNodeID{Hash: *rawNodeIDHash},
+			node: &proto.Node{
+				ID:         n.ID,
+				Addr:       n.Addr,
+				Addrs:      n.Addrs,
+				DirectAddr: n.DirectAddr,
+				PublicKey:  n.PublicKey,
+				Nonce:      n.Nonce,
+				Role:       n.Role,
+				Region:     n.Region,
+				Zone:       n.Zone,
+				Tags:       n.Tags,
+
+				Capabilities:     n.Capabilities,
+				KeyType:          n.KeyType,
+				Ed25519PublicKey: n.Ed25519PublicKey,
+			},
 		}
 	}
 
-	log.Debugf("AllNodes:\n %#v\n", conf.GConf.KnownNodes)
+	if workers > len(knownNodes) {
+		workers = len(knownNodes)
+	}
+	if workers <= 1 {
+		for i := range knownNodes {
+			prepare(i)
+		}
+	} else {
+		var (
+			wg   sync.WaitGroup
+			next = make(chan int)
+		)
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for i := range next {
+					prepare(i)
+				}
+			}()
+		}
+		for i := range knownNodes {
+			next <- i
+		}
+		close(next)
+		wg.Wait()
+	}
 
-	err = peers.Sign(privateKey)
+	var failed kms.NodesError
+	for i, e := range errs {
+		if e != nil {
+			failed = append(failed, &kms.NodeError{Index: i, ID: knownNodes[i].ID, Err: e})
+		}
+	}
+	if len(failed) > 0 {
+		return nil, failed
+	}
+	return
+}
+
+// knownNodePublicKey returns the public key of a known node: the configured one, the
+// block producer key for the block producer entry or the one in the public keystore.
+func knownNodePublicKey(n *proto.Node) *asymmetric.PublicKey {
+	if n.PublicKey != nil {
+		return n.PublicKey
+	}
+	if kms.BP != nil && n.ID == kms.BP.NodeID && kms.BP.PublicKey != nil {
+		return kms.BP.PublicKey
+	}
+	if key, err := kms.GetPublicKey(n.ID); err == nil {
+		return key
+	}
+	return nil
+}
+
+// initNodePeers signs the local peers and applies the known nodes by mutator, the
+// entries are logged by the logger of ctx. The summary is the identity it loaded.
+// The peers persisted by a previous run are kept, or reconciled with the config at
+// the next term, see reconcilePeers.
+func initNodePeers(ctx context.Context, nodeID proto.NodeID, publicKeystorePath string, mutator nodeMutator) (nodes *[]proto.Node, peers *proto.Peers, thisNode *proto.Node, summary *startupSummary, err error) {
+	logger := log.FromContext(ctx)
+	keyProvider := kms.GetLocalKeyProvider()
+	localPublic, err := kms.ProviderPublicKey(keyProvider)
+	if err != nil {
+		logger.WithError(err).Error("get local private key failed")
+		return nil, nil, nil, nil, err
+	}
+	// refuse to start before any side effect if the node identity is misconfigured
+	if err = checkLocalNode(nodeID, localPublic); err != nil {
+		logger.WithError(err).Error("check local node failed")
+		return nil, nil, nil, nil, err
+	}
+
+	// a restart keeps the persisted term unless the config changed the membership
+	state, err := loadPersistedState(conf.GConf.PeersFile, publicKeystorePath)
 	if err != nil {
-		log.WithError(err).Error("sign peers failed")
-		return nil, nil, nil, err
+		logger.WithError(err).Error("load persisted node state failed")
+		return nil, nil, nil, nil, err
+	}
+	// the revoked keys are not trusted whatever the config says
+	if err = kms.LoadRevokedNodes(conf.GConf.RevokedFile); err != nil {
+		logger.WithError(err).Error("load revoked nodes failed")
+		return nil, nil, nil, nil, err
+	}
+	configured := configPeers(conf.GConf)
+	if err = dropRevokedServers(logger, configured); err != nil {
+		logger.WithError(err).Error("check revoked nodes failed")
+		return nil, nil, nil, nil, err
+	}
+	var action initAction
+	peers, action = reconcilePeers(configured, state.peers)
+	logInitAction(logger, action, state, peers)
+
+	// bound the membership before signing, a bloated peers list fails the start
+	proto.SetMaxServers(conf.GConf.MaxPeersServers)
+	if err = peers.CheckServers(); err != nil {
+		logger.WithError(err).Error("check peers failed")
+		return nil, nil, nil, nil, err
 	}
-	log.Debugf("peers:\n %#v\n", peers)
 
-	//route.initResolver()
-	if initErr := kms.InitPublicKeyStore(publicKeystorePath, nil); initErr != nil {
-		log.WithError(initErr).Error("init public key store failed")
+	for _, n := range conf.GConf.KnownNodes {
+		logger.WithModule("conf").WithFields(log.Fields{
+			"node":  n.ID,
+			"role":  n.Role,
+			"addr":  n.Addr,
+			"addrs": n.Addrs,
+		}).Debug("known node")
+	}
+
+	// the persisted peers are signed again only for a changed local key, the ones of
+	// the leader keep its signature for export-peers
+	if action != initUnchanged || !(signedBy(peers, localPublic) || signedByLeader(peers)) {
+		if err = kms.SignPeersWith(peers, keyProvider); err != nil {
+			logger.WithError(err).Error("sign peers failed")
+			return nil, nil, nil, nil, err
+		}
+	}
+	logger.WithModule("main").WithFields(log.Fields{
+		"term":      peers.Term,
+		"leader":    peers.Leader,
+		"servers":   peers.Servers,
+		"observers": peers.Observers,
+	}).Debug("local peers")
+	mutator.SetLocalPeers(peers)
+
+	// learn the nodes from DNS seeds before the static known nodes are applied
+	mutator.InitResolver()
+	if initErr := mutator.InitPublicKeyStore(publicKeystorePath); initErr != nil {
+		logger.WithError(initErr).Error("init public key store failed")
 	}
 
 	// set p route and public keystore
 	if conf.GConf.KnownNodes != nil {
-		for i, p := range conf.GConf.KnownNodes {
-			rawNodeIDHash, err := hash.NewHashFromStr(string(p.ID))
-			if err != nil {
-				log.WithError(err).Error("load hash from node id failed")
-				return nil, nil, nil, err
-			}
-			log.WithFields(log.Fields{
-				"node": rawNodeIDHash.String(),
-				"addr": p.Addr,
+		prepared, prepareErr := prepareKnownNodes(conf.GConf.KnownNodes, initNodeWorkers)
+		if prepareErr != nil {
+			logger.WithError(prepareErr).Error("load hash from node id failed")
+			return nil, nil, nil, nil, prepareErr
+		}
+		knownNodes := make([]*proto.Node, len(prepared))
+		addrBatch := make(map[*proto.RawNodeID]string, len(prepared))
+		for i, p := range prepared {
+			knownNodes[i] = p.node
+			logger.WithModule("route").Sampled("set node addr").WithFields(log.Fields{
+				"node": p.rawNodeID.String(),
+				"addr": p.node.Addr,
 			}).Debug("set node addr")
-			rawNodeID := &proto.RawNodeID{Hash: *rawNodeIDHash}
-			if cacheErr := route.SetNodeAddrCache(rawNodeID, p.Addr); cacheErr != nil {
-				log.WithError(cacheErr).Debug("set node addr cache failed")
+			if len(p.node.Addrs) > 0 {
+				// multi-homed nodes keep their alternates
+				if cacheErr := mutator.SetNodeAddrCache(p.rawNodeID, p.node.Addr, p.node.Addrs...); cacheErr != nil {
+					logger.WithModule("route").WithError(cacheErr).Debug("set node addr cache failed")
+				}
+			} else {
+				addrBatch[p.rawNodeID] = p.node.Addr
 			}
-			node := &proto.Node{
-				ID:         p.ID,
-				Addr:       p.Addr,
-				DirectAddr: p.DirectAddr,
-				PublicKey:  p.PublicKey,
-				Nonce:      p.Nonce,
-				Role:       p.Role,
+			// the first entry of the local node wins like checkLocalNode
+			if thisNode == nil && p.node.ID == nodeID {
+				mutator.SetLocalNodeIDNonce(p.rawNodeID.CloneBytes(), &p.node.Nonce)
+				thisNode = &conf.GConf.KnownNodes[i]
 			}
-			err = kms.SetNode(node)
-			if err != nil {
-				log.WithField("node", node).WithError(err).Error("set node failed")
+		}
+		if cacheErr := mutator.SetNodeAddrCacheBatch(addrBatch); cacheErr != nil {
+			logger.WithModule("route").WithError(cacheErr).Debug("set node addr cache failed")
+		}
+		if setErr := mutator.SetNodes(knownNodes); setErr != nil {
+			failed, ok := setErr.(kms.NodesError)
+			if !ok {
+				logger.WithError(setErr).Error("set nodes failed")
 			}
-			if p.ID == nodeID {
-				kms.SetLocalNodeIDNonce(rawNodeID.CloneBytes(), &p.Nonce)
-				thisNode = &conf.GConf.KnownNodes[i]
+			for _, ne := range failed {
+				logger.WithField("node", knownNodes[ne.Index]).WithError(ne.Err).Error("set node failed")
 			}
 		}
 	}
 
+	summary = newStartupSummary(nodeID, localPublic, thisNode, peers)
 	return
 }
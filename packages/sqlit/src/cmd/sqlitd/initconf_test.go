@@ -0,0 +1,273 @@
+// +build !testbinary
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/pkg/errors"
+	. "github.com/smartystreets/goconvey/convey"
+
+	"sqlit/src/conf"
+	"sqlit/src/crypto/asymmetric"
+	"sqlit/src/crypto/kms"
+	"sqlit/src/proto"
+	"sqlit/src/utils/log"
+)
+
+func TestInitNodePeersPublicKeys(t *testing.T) {
+	Convey("known nodes keep their own public keys", t, func() {
+		var (
+			dir          = t.TempDir()
+			keystorePath = filepath.Join(dir, "public.keystore")
+			bp           = proto.NodeID("0000000000000000000000000000000000000000000000000000000000000061")
+			leader       = proto.NodeID("0000000000000000000000000000000000000000000000000000000000000062")
+			follower     = proto.NodeID("0000000000000000000000000000000000000000000000000000000000000063")
+			unknown      = proto.NodeID("0000000000000000000000000000000000000000000000000000000000000064")
+			newKey       = func() *asymmetric.PublicKey {
+				_, public, err := asymmetric.GenSecp256k1KeyPair()
+				So(err, ShouldBeNil)
+				return public
+			}
+			bpKey, leaderKey, storedKey = newKey(), newKey(), newKey()
+		)
+		defer func(saved *conf.Config) { conf.GConf = saved }(conf.GConf)
+		defer func(saved *conf.BPInfo) { kms.BP = saved }(kms.BP)
+		kms.ResetLocalKeyStore()
+		defer kms.ResetLocalKeyStore()
+		defer kms.SetLocalPeers(nil)
+		defer kms.ClosePublicKeyStore()
+		// the local node is the follower
+		privateKey, followerKey, err := asymmetric.GenSecp256k1KeyPair()
+		So(err, ShouldBeNil)
+		kms.SetLocalKeyPair(privateKey, followerKey)
+
+		// a node without configured key is found in the public keystore
+		stored := kms.MineNodeNonce(storedKey, 0)
+		storedID := proto.NodeID(stored.Hash.String())
+		conf.GConf = &conf.Config{BP: &conf.BPInfo{NodeID: bp, PublicKey: bpKey}}
+		So(kms.InitPublicKeyStore(keystorePath, []proto.Node{
+			{ID: storedID, Role: proto.Follower, PublicKey: storedKey, Nonce: stored.Nonce},
+		}), ShouldBeNil)
+		kms.ClosePublicKeyStore()
+
+		conf.GConf.KnownNodes = []proto.Node{
+			{ID: bp, Role: proto.Leader, Addr: "127.0.0.1:1"},
+			{ID: leader, Role: proto.Leader, Addr: "127.0.0.1:2", PublicKey: leaderKey},
+			{ID: follower, Role: proto.Follower, Addr: "127.0.0.1:3", PublicKey: followerKey},
+			{ID: storedID, Role: proto.Follower, Addr: "127.0.0.1:4"},
+			{ID: unknown, Role: proto.Follower, Addr: "127.0.0.1:5"},
+		}
+		kms.InitBP()
+
+		_, peers, thisNode, summary, err := initNodePeers(context.Background(), follower, keystorePath, liveNodeMutator{})
+		So(err, ShouldBeNil)
+		So(peers.Servers, ShouldResemble, []proto.NodeID{bp, leader, follower, storedID, unknown})
+		So(thisNode.PublicKey, ShouldEqual, followerKey)
+		So(summary, ShouldResemble, &startupSummary{
+			NodeID:      follower,
+			Fingerprint: kms.KeyFingerprint(followerKey),
+			Role:        proto.Follower,
+			Term:        peers.Term,
+			Leader:      peers.Leader,
+			Servers:     5,
+		})
+		nodes := conf.GConf.KnownNodes
+		So(nodes[0].PublicKey, ShouldEqual, bpKey)
+		So(nodes[1].PublicKey, ShouldEqual, leaderKey)
+		So(nodes[2].PublicKey, ShouldEqual, followerKey)
+		So(nodes[3].PublicKey.IsEqual(storedKey), ShouldBeTrue)
+		// only the block producer entry defaults to the block producer key
+		So(nodes[4].PublicKey, ShouldBeNil)
+
+		// a node id not derived from the node key is rejected unless verification is skipped
+		_, err = kms.GetPublicKey(leader)
+		So(err, ShouldNotBeNil)
+		conf.GConf.SkipNodeIDVerify = true
+		_, _, _, _, err = initNodePeers(context.Background(), follower, keystorePath, liveNodeMutator{})
+		So(err, ShouldBeNil)
+		key, err := kms.GetPublicKey(leader)
+		So(err, ShouldBeNil)
+		So(key.IsEqual(leaderKey), ShouldBeTrue)
+	})
+}
+
+func TestInitNodePeersLocalNode(t *testing.T) {
+	Convey("the local node must be known with the local key", t, func() {
+		var (
+			bp    = proto.NodeID("0000000000000000000000000000000000000000000000000000000000000081")
+			local = proto.NodeID("0000000000000000000000000000000000000000000000000000000000000082")
+		)
+		defer func(saved *conf.Config) { conf.GConf = saved }(conf.GConf)
+		defer func(saved *conf.BPInfo) { kms.BP = saved }(kms.BP)
+		kms.ResetLocalKeyStore()
+		defer kms.ResetLocalKeyStore()
+		kms.SetLocalPeers(nil)
+		defer kms.SetLocalPeers(nil)
+		privateKey, publicKey, err := asymmetric.GenSecp256k1KeyPair()
+		So(err, ShouldBeNil)
+		kms.SetLocalKeyPair(privateKey, publicKey)
+		_, otherKey, err := asymmetric.GenSecp256k1KeyPair()
+		So(err, ShouldBeNil)
+		conf.GConf = &conf.Config{
+			BP:         &conf.BPInfo{NodeID: bp, PublicKey: otherKey},
+			KnownNodes: []proto.Node{{ID: bp, Role: proto.Leader, Addr: "127.0.0.1:1"}},
+		}
+		kms.InitBP()
+
+		_, peers, thisNode, _, err := initNodePeers(context.Background(), local, "", liveNodeMutator{})
+		So(errors.Cause(err), ShouldEqual, errLocalNodeNotKnown)
+		So(err.Error(), ShouldContainSubstring, string(local))
+		So(peers, ShouldBeNil)
+		So(thisNode, ShouldBeNil)
+
+		conf.GConf.KnownNodes = append(conf.GConf.KnownNodes,
+			proto.Node{ID: local, Role: proto.Miner, Addr: "127.0.0.1:2", PublicKey: otherKey})
+		_, _, _, _, err = initNodePeers(context.Background(), local, "", liveNodeMutator{})
+		So(errors.Cause(err), ShouldEqual, errLocalKeyMismatch)
+
+		// the block producer entry is checked against the block producer key
+		_, _, _, _, err = initNodePeers(context.Background(), bp, "", liveNodeMutator{})
+		So(errors.Cause(err), ShouldEqual, errLocalKeyMismatch)
+
+		// nothing is applied on failure
+		_, err = kms.GetLocalPeers()
+		So(err, ShouldNotBeNil)
+		So(checkLocalNode(bp, otherKey), ShouldBeNil)
+		conf.GConf.KnownNodes[1].PublicKey = publicKey
+		So(checkLocalNode(local, publicKey), ShouldBeNil)
+		// an ed25519 node is checked against its ed25519 key
+		_, edKey, err := asymmetric.GenEd25519KeyPair()
+		So(err, ShouldBeNil)
+		So(errors.Cause(checkLocalNode(local, edKey)), ShouldEqual, errLocalKeyMismatch)
+		conf.GConf.KnownNodes[1].KeyType = asymmetric.Ed25519
+		conf.GConf.KnownNodes[1].Ed25519PublicKey = edKey
+		So(checkLocalNode(local, edKey), ShouldBeNil)
+		So(errors.Cause(checkLocalNode(local, publicKey)), ShouldEqual, errLocalKeyMismatch)
+		conf.GConf.KnownNodes[1].KeyType = asymmetric.Secp256k1
+		conf.GConf.KnownNodes[1].Ed25519PublicKey = nil
+
+		// the servers are bounded by the config
+		defer proto.SetMaxServers(0)
+		conf.GConf.KnownNodes[1].Role = proto.Follower
+		conf.GConf.MaxPeersServers = 1
+		_, _, _, _, err = initNodePeers(context.Background(), local, "", liveNodeMutator{})
+		So(errors.Cause(err), ShouldEqual, proto.ErrTooManyServers)
+		_, err = kms.GetLocalPeers()
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestInitNodePeersWithoutKey(t *testing.T) {
+	Convey("a missing local key is returned instead of exiting", t, func() {
+		defer func(saved *conf.Config) { conf.GConf = saved }(conf.GConf)
+		kms.ResetLocalKeyStore()
+		defer kms.ResetLocalKeyStore()
+		kms.SetLocalPeers(nil)
+		defer kms.SetLocalPeers(nil)
+		conf.GConf = &conf.Config{BP: &conf.BPInfo{
+			NodeID: "0000000000000000000000000000000000000000000000000000000000000071",
+		}}
+
+		// the entries carry the request id of the context
+		var buf bytes.Buffer
+		log.SetOutput(&buf)
+		defer log.SetOutput(os.Stderr)
+		ctx, requestID := log.WithRequestID(context.Background())
+		nodes, peers, thisNode, _, err := initNodePeers(ctx, conf.GConf.BP.NodeID, "", liveNodeMutator{})
+		So(err, ShouldNotBeNil)
+		So(buf.String(), ShouldContainSubstring, log.RequestIDKey+"="+requestID)
+		So(nodes, ShouldBeNil)
+		So(peers, ShouldBeNil)
+		So(thisNode, ShouldBeNil)
+		_, err = kms.GetLocalPeers()
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestPrepareKnownNodes(t *testing.T) {
+	Convey("known nodes are prepared in order and the errors are aggregated", t, func() {
+		defer func(saved *conf.Config) { conf.GConf = saved }(conf.GConf)
+		conf.GConf = &conf.Config{}
+		nodes := make([]proto.Node, 64)
+		for i := range nodes {
+			nodes[i] = proto.Node{ID: proto.NodeID(fmt.Sprintf("%064x", i+1)), Addr: fmt.Sprintf("127.0.0.1:%d", i+1)}
+		}
+		prepared, err := prepareKnownNodes(nodes, 8)
+		So(err, ShouldBeNil)
+		So(prepared, ShouldHaveLength, len(nodes))
+		for i, p := range prepared {
+			So(p.node.ID, ShouldEqual, nodes[i].ID)
+			So(p.node.Addr, ShouldEqual, nodes[i].Addr)
+			So(p.rawNodeID.ToNodeID(), ShouldEqual, nodes[i].ID)
+		}
+
+		nodes[5].ID = "not a hash"
+		nodes[40].ID = "worse"
+		prepared, err = prepareKnownNodes(nodes, 8)
+		So(prepared, ShouldBeNil)
+		failed, ok := err.(kms.NodesError)
+		So(ok, ShouldBeTrue)
+		So(failed, ShouldHaveLength, 2)
+		So(failed[0].Index, ShouldEqual, 5)
+		So(failed[1].ID, ShouldEqual, proto.NodeID("worse"))
+	})
+}
+
+func BenchmarkInitNodePeers(b *testing.B) {
+	const nodeCount = 500
+	defer func(saved *conf.Config) { conf.GConf = saved }(conf.GConf)
+	defer func(saved *conf.BPInfo) { kms.BP = saved }(kms.BP)
+	defer func(saved int) { initNodeWorkers = saved }(initNodeWorkers)
+	kms.ResetLocalKeyStore()
+	defer kms.ResetLocalKeyStore()
+	defer kms.SetLocalPeers(nil)
+	defer kms.ClosePublicKeyStore()
+
+	nodes := make([]proto.Node, nodeCount)
+	for i := range nodes {
+		private, public, err := asymmetric.GenSecp256k1KeyPair()
+		if err != nil {
+			b.Fatal(err)
+		}
+		if i == 0 {
+			kms.SetLocalKeyPair(private, public)
+		}
+		nonce := kms.MineNodeNonce(public, 0)
+		nodes[i] = proto.Node{
+			ID:        proto.NodeID(nonce.Hash.String()),
+			Role:      proto.Miner,
+			Addr:      fmt.Sprintf("127.0.0.1:%d", 10000+i),
+			PublicKey: public,
+			Nonce:     nonce.Nonce,
+		}
+	}
+	nodes[0].Role = proto.Leader
+	conf.GConf = &conf.Config{BP: &conf.BPInfo{NodeID: nodes[0].ID, PublicKey: nodes[0].PublicKey}}
+	kms.InitBP()
+	keystorePath := filepath.Join(b.TempDir(), "public.keystore")
+
+	for _, bench := range []struct {
+		name    string
+		workers int
+	}{
+		{"serial", 1},
+		{"parallel", runtime.NumCPU()},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			initNodeWorkers = bench.workers
+			for i := 0; i < b.N; i++ {
+				conf.GConf.KnownNodes = append([]proto.Node(nil), nodes...)
+				if _, _, _, _, err := initNodePeers(context.Background(), nodes[0].ID, keystorePath, liveNodeMutator{}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,217 @@
+
+package main
+
+import (
+	"os"
+	"sort"
+
+	"github.com/pkg/errors"
+
+	"sqlit/src/conf"
+	"sqlit/src/crypto/asymmetric"
+	"sqlit/src/crypto/kms"
+	"sqlit/src/proto"
+	"sqlit/src/utils"
+	"sqlit/src/utils/log"
+)
+
+// initAction is how initNodePeers sets up the local peers from the node state
+// persisted by a previous run, see reconcilePeers.
+type initAction int
+
+const (
+	// initFresh signs the term 1 peers of the config, no peers are persisted
+	initFresh initAction = iota
+	// initUnchanged keeps the persisted peers, the config has the same membership
+	initUnchanged
+	// initReconciled signs the peers of the config at the term after the persisted one
+	initReconciled
+)
+
+// String returns the name of the action.
+func (a initAction) String() string {
+	switch a {
+	case initFresh:
+		return "fresh"
+	case initUnchanged:
+		return "unchanged"
+	case initReconciled:
+		return "reconciled"
+	}
+	return "unknown"
+}
+
+// errPersistedPeers indicates the persisted peers can not be loaded, the node can not
+// tell its last term so it refuses to start over them.
+var errPersistedPeers = errors.New("load persisted peers failed, start with -reinit to discard the node state")
+
+// errRevokedLeader indicates the leader of the peers is revoked, the node can not drop
+// it like the other servers.
+var errRevokedLeader = errors.New("peers leader is revoked")
+
+// persistedState is the node state left by a previous run.
+type persistedState struct {
+	// peers is the peers saved on the last shutdown, nil if none
+	peers *proto.Peers
+	// keystore is if the public keystore file exists
+	keystore bool
+}
+
+// loadPersistedState reads the node state left at peersFile and keystoreFile, an
+// empty path has no state. A peers file failing to load is errPersistedPeers.
+func loadPersistedState(peersFile, keystoreFile string) (state persistedState, err error) {
+	state.keystore = keystoreFile != "" && utils.Exist(keystoreFile)
+	if peersFile == "" || !utils.Exist(peersFile) {
+		return
+	}
+	if state.peers, err = kms.LoadPeersFile(peersFile); err != nil {
+		state.peers = nil
+		err = errors.Wrapf(errPersistedPeers, "%s: %v", peersFile, err)
+	}
+	return
+}
+
+// reconcilePeers returns the peers to set up from the term 1 peers of the config and
+// the persisted ones: configured without persisted peers, the persisted peers if the
+// membership is the same, else configured at the term after the persisted one. Only
+// the persisted peers are signed already.
+func reconcilePeers(configured, persisted *proto.Peers) (peers *proto.Peers, action initAction) {
+	switch {
+	case persisted == nil:
+		return configured, initFresh
+	case sameMembership(configured, persisted):
+		return persisted.Clone(), initUnchanged
+	}
+	peers = configured.Clone()
+	peers.Term = persisted.Term + 1
+	return peers, initReconciled
+}
+
+// sameMembership returns if a and b have the same leader, servers and observers, the
+// order of the lists is ignored.
+func sameMembership(a, b *proto.Peers) bool {
+	return a.Leader == b.Leader && sameNodeSet(a.Servers, b.Servers) && sameNodeSet(a.Observers, b.Observers)
+}
+
+func sameNodeSet(a, b []proto.NodeID) bool {
+	added, removed := membershipDiff(a, b)
+	return len(added) == 0 && len(removed) == 0
+}
+
+// membershipDiff returns the sorted nodes of after not in before and of before not
+// in after.
+func membershipDiff(before, after []proto.NodeID) (added, removed []proto.NodeID) {
+	in := func(ids []proto.NodeID) map[proto.NodeID]bool {
+		set := make(map[proto.NodeID]bool, len(ids))
+		for _, id := range ids {
+			set[id] = true
+		}
+		return set
+	}
+	beforeSet, afterSet := in(before), in(after)
+	for id := range afterSet {
+		if !beforeSet[id] {
+			added = append(added, id)
+		}
+	}
+	for id := range beforeSet {
+		if !afterSet[id] {
+			removed = append(removed, id)
+		}
+	}
+	sort.Slice(added, func(i, j int) bool { return added[i] < added[j] })
+	sort.Slice(removed, func(i, j int) bool { return removed[i] < removed[j] })
+	return
+}
+
+// dropRevokedServers removes the revoked nodes from the servers of peers with a
+// warning, a revoked leader is errRevokedLeader.
+func dropRevokedServers(logger *log.Entry, peers *proto.Peers) (err error) {
+	if kms.IsRevoked(peers.Leader) {
+		return errors.Wrapf(errRevokedLeader, "leader %s", peers.Leader)
+	}
+	servers := peers.Servers[:0]
+	for _, id := range peers.Servers {
+		if kms.IsRevoked(id) {
+			logger.WithModule("main").WithField("node", id).Warning("drop revoked node from peers servers")
+			continue
+		}
+		servers = append(servers, id)
+	}
+	peers.Servers = servers
+	return
+}
+
+// signedBy returns if peers carry a signature of the local public key, the persisted
+// peers are verified on load.
+func signedBy(peers *proto.Peers, localPublic asymmetric.TypedPublicKey) bool {
+	signee, err := peers.GetSignee()
+	return err == nil && asymmetric.TypedPublicKeyEqual(signee, localPublic)
+}
+
+// signedByLeader returns if peers carry a signature of the public key of their leader
+// in the known nodes, such as the peers a follower adopted from the leader.
+func signedByLeader(peers *proto.Peers) bool {
+	for i := range conf.GConf.KnownNodes {
+		if conf.GConf.KnownNodes[i].ID != peers.Leader {
+			continue
+		}
+		leader := conf.GConf.KnownNodes[i]
+		leader.PublicKey = knownNodePublicKey(&leader)
+		key, err := leader.TypedPublicKey()
+		return err == nil && signedBy(peers, key)
+	}
+	return false
+}
+
+// logInitAction logs how the peers were set up from state.
+func logInitAction(logger *log.Entry, action initAction, state persistedState, peers *proto.Peers) {
+	logger = logger.WithModule("main").WithField("init", action)
+	switch action {
+	case initFresh:
+		if state.keystore {
+			logger.Warning("public keystore present without persisted peers, sign the peers of term 1")
+			return
+		}
+		logger.Info("no persisted node state, initialize the node")
+	case initUnchanged:
+		logger.WithField("term", peers.Term).Info("node already initialized, keep the persisted peers")
+	case initReconciled:
+		addedServers, removedServers := membershipDiff(state.peers.Servers, peers.Servers)
+		addedObservers, removedObservers := membershipDiff(state.peers.Observers, peers.Observers)
+		logger.WithFields(log.Fields{
+			"persisted_term":    state.peers.Term,
+			"term":              peers.Term,
+			"persisted_leader":  state.peers.Leader,
+			"leader":            peers.Leader,
+			"added_servers":     addedServers,
+			"removed_servers":   removedServers,
+			"added_observers":   addedObservers,
+			"removed_observers": removedObservers,
+		}).Warning("config changed the membership, reconcile the persisted peers")
+	}
+}
+
+// resetInitState removes the persisted peers and public keystore of config for
+// -reinit, so the node starts from its config like in a fresh data directory.
+func resetInitState(config *conf.Config) (err error) {
+	files := []string{config.PeersFile}
+	if config.PubKeyStoreFile != "" {
+		// the keystore is SQLite in WAL mode
+		files = append(files, config.PubKeyStoreFile, config.PubKeyStoreFile+"-wal", config.PubKeyStoreFile+"-shm")
+	}
+	for _, file := range files {
+		if file == "" {
+			continue
+		}
+		if err = os.Remove(file); err != nil && !os.IsNotExist(err) {
+			return errors.Wrapf(err, "remove %s failed", file)
+		}
+		err = nil
+	}
+	log.WithFields(log.Fields{
+		"peers":    config.PeersFile,
+		"keystore": config.PubKeyStoreFile,
+	}).Warning("reinit, the persisted node state is discarded")
+	return
+}
@@ -0,0 +1,172 @@
+// +build !testbinary
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+	. "github.com/smartystreets/goconvey/convey"
+
+	"sqlit/src/conf"
+	"sqlit/src/crypto/asymmetric"
+	"sqlit/src/crypto/kms"
+	"sqlit/src/proto"
+)
+
+func TestInitNodePeersPersistedState(t *testing.T) {
+	Convey("a restart keeps or reconciles the persisted peers", t, func() {
+		var (
+			dir      = t.TempDir()
+			leader   = proto.NodeID("0000000000000000000000000000000000000000000000000000000000000091")
+			local    = proto.NodeID("0000000000000000000000000000000000000000000000000000000000000092")
+			observer = proto.NodeID("0000000000000000000000000000000000000000000000000000000000000093")
+			joined   = proto.NodeID("0000000000000000000000000000000000000000000000000000000000000094")
+			out      bytes.Buffer
+			newKey   = func() *asymmetric.PublicKey {
+				privateKey, publicKey, err := asymmetric.GenSecp256k1KeyPair()
+				So(err, ShouldBeNil)
+				kms.SetLocalKeyPair(privateKey, publicKey)
+				return publicKey
+			}
+			initPeers = func() (*proto.Peers, error) {
+				_, peers, _, _, err := initNodePeers(
+					context.Background(), local, conf.GConf.PubKeyStoreFile, &dryRunRecorder{w: &out})
+				return peers, err
+			}
+			persist = func(peers *proto.Peers) *proto.Peers {
+				kms.SetLocalPeers(peers)
+				So(kms.SaveLocalPeers(conf.GConf.PeersFile), ShouldBeNil)
+				persisted, err := kms.LoadPeersFile(conf.GConf.PeersFile)
+				So(err, ShouldBeNil)
+				return persisted
+			}
+		)
+		defer func(saved *conf.Config) { conf.GConf = saved }(conf.GConf)
+		defer func(saved *conf.BPInfo) { kms.BP = saved }(kms.BP)
+		kms.ResetLocalKeyStore()
+		defer kms.ResetLocalKeyStore()
+		kms.SetLocalPeers(nil)
+		defer kms.SetLocalPeers(nil)
+		defer func() { _ = kms.LoadRevokedNodes("") }()
+		localKey := newKey()
+		conf.GConf = &conf.Config{
+			PeersFile:       filepath.Join(dir, "dht.db.peers"),
+			PubKeyStoreFile: filepath.Join(dir, "public.keystore"),
+			RevokedFile:     filepath.Join(dir, "public.keystore.revoked"),
+			BP:              &conf.BPInfo{NodeID: leader},
+			KnownNodes: []proto.Node{
+				{ID: leader, Role: proto.Leader, Addr: "127.0.0.1:1"},
+				{ID: local, Role: proto.Follower, Addr: "127.0.0.1:2"},
+				{ID: observer, Role: proto.Observer, Addr: "127.0.0.1:3"},
+			},
+		}
+		kms.InitBP()
+
+		// fresh dir
+		peers, err := initPeers()
+		So(err, ShouldBeNil)
+		So(peers.Term, ShouldEqual, 1)
+		So(peers.Verify(), ShouldBeNil)
+		So(signedBy(peers, localKey), ShouldBeTrue)
+
+		// already initialized, unchanged: the term and signature are kept
+		advanced := peers.Clone()
+		advanced.Term = 5
+		persisted := persist(advanced)
+		So(os.WriteFile(conf.GConf.PubKeyStoreFile, nil, 0600), ShouldBeNil)
+		peers, err = initPeers()
+		So(err, ShouldBeNil)
+		So(peers.Term, ShouldEqual, 5)
+		So(peers.Signature, ShouldResemble, persisted.Signature)
+		So(peers.Verify(), ShouldBeNil)
+
+		// the order of the known nodes is not a change
+		nodes := conf.GConf.KnownNodes
+		conf.GConf.KnownNodes = []proto.Node{nodes[2], nodes[1], nodes[0]}
+		peers, err = initPeers()
+		So(err, ShouldBeNil)
+		So(peers.Term, ShouldEqual, 5)
+		conf.GConf.KnownNodes = nodes
+
+		// a new local key signs the same term again
+		kms.ResetLocalKeyStore()
+		localKey = newKey()
+		peers, err = initPeers()
+		So(err, ShouldBeNil)
+		So(peers.Term, ShouldEqual, 5)
+		So(signedBy(peers, localKey), ShouldBeTrue)
+		So(peers.Verify(), ShouldBeNil)
+
+		// config changed: the peers of the config at the next term
+		conf.GConf.KnownNodes = append(conf.GConf.KnownNodes,
+			proto.Node{ID: joined, Role: proto.Follower, Addr: "127.0.0.1:4"})
+		peers, err = initPeers()
+		So(err, ShouldBeNil)
+		So(peers.Term, ShouldEqual, 6)
+		So(peers.Servers, ShouldResemble, []proto.NodeID{leader, local, joined})
+		So(peers.Observers, ShouldResemble, []proto.NodeID{observer})
+		So(peers.Verify(), ShouldBeNil)
+
+		// a revoked server is dropped at the next term, the revoked leader fails
+		persist(peers)
+		So(kms.RevokeNode(joined), ShouldBeNil)
+		peers, err = initPeers()
+		So(err, ShouldBeNil)
+		So(peers.Term, ShouldEqual, 7)
+		So(peers.Servers, ShouldResemble, []proto.NodeID{leader, local})
+		So(kms.RevokeNode(leader), ShouldBeNil)
+		_, err = initPeers()
+		So(errors.Cause(err), ShouldEqual, errRevokedLeader)
+		So(kms.UnrevokeNode(leader), ShouldBeNil)
+
+		// unreadable persisted peers need -reinit
+		So(os.WriteFile(conf.GConf.PeersFile, []byte("garbage"), 0600), ShouldBeNil)
+		_, err = initPeers()
+		So(errors.Cause(err), ShouldEqual, errPersistedPeers)
+
+		// reinit discards the persisted state
+		So(resetInitState(conf.GConf), ShouldBeNil)
+		So(resetInitState(conf.GConf), ShouldBeNil)
+		_, statErr := os.Stat(conf.GConf.PubKeyStoreFile)
+		So(os.IsNotExist(statErr), ShouldBeTrue)
+		peers, err = initPeers()
+		So(err, ShouldBeNil)
+		So(peers.Term, ShouldEqual, 1)
+	})
+}
+
+func TestReconcilePeers(t *testing.T) {
+	Convey("reconcile the peers of the config with the persisted ones", t, func() {
+		configured := &proto.Peers{PeersHeader: proto.PeersHeader{
+			Term: 1, Leader: "a", Servers: []proto.NodeID{"a", "b"},
+		}}
+		peers, action := reconcilePeers(configured, nil)
+		So(action, ShouldEqual, initFresh)
+		So(peers, ShouldEqual, configured)
+
+		persisted := &proto.Peers{PeersHeader: proto.PeersHeader{
+			Term: 7, Leader: "a", Servers: []proto.NodeID{"b", "a"},
+		}}
+		peers, action = reconcilePeers(configured, persisted)
+		So(action, ShouldEqual, initUnchanged)
+		So(peers.Term, ShouldEqual, 7)
+
+		configured.Leader = "b"
+		peers, action = reconcilePeers(configured, persisted)
+		So(action, ShouldEqual, initReconciled)
+		So(action.String(), ShouldEqual, "reconciled")
+		So(peers.Term, ShouldEqual, 8)
+		So(peers.Leader, ShouldEqual, "b")
+		// the config peers are not modified
+		So(configured.Term, ShouldEqual, 1)
+
+		added, removed := membershipDiff([]proto.NodeID{"a", "b"}, []proto.NodeID{"c", "b", "d"})
+		So(added, ShouldResemble, []proto.NodeID{"c", "d"})
+		So(removed, ShouldResemble, []proto.NodeID{"a"})
+	})
+}
@@ -0,0 +1,228 @@
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"syscall"
+
+	"github.com/pkg/errors"
+	"golang.org/x/term"
+	yaml "gopkg.in/yaml.v2"
+
+	"sqlit/src/conf"
+	"sqlit/src/crypto/asymmetric"
+	"sqlit/src/crypto/kms"
+	"sqlit/src/proto"
+	"sqlit/src/utils"
+)
+
+const (
+	// keygenMnemonicBits is the entropy of the recovery words, 24 words.
+	keygenMnemonicBits = 256
+	// nodeSnippetSuffix is appended to the key file path for the KnownNodes snippet.
+	nodeSnippetSuffix = ".node.yaml"
+)
+
+var (
+	// errKeyFileExists indicates keygen would overwrite a key file without -force.
+	errKeyFileExists = errors.New("key file exists, use -force to overwrite")
+	// errMnemonicKeyType indicates the recovery words are asked for a key type they
+	// do not derive, only the secp256k1 keys are derived from them.
+	errMnemonicKeyType = errors.New("mnemonic is only supported for secp256k1 keys")
+)
+
+// keygenOptions are the options of generateNodeKey.
+type keygenOptions struct {
+	KeyFile    string
+	KeyType    asymmetric.KeyType
+	Passphrase []byte
+	Difficulty int
+	Mnemonic   bool
+	Force      bool
+	Role       proto.ServerRole
+	Addr       string
+}
+
+// keygenResult is a generated node identity.
+type keygenResult struct {
+	Node        proto.Node
+	Mnemonic    string
+	SnippetFile string
+	Snippet     []byte
+}
+
+// generateNodeKey creates a private key of opts.KeyType, mines the node ID and nonce
+// of it like a key rotation does, and writes the key file and the KnownNodes snippet
+// of the node.
+func generateNodeKey(opts keygenOptions) (result keygenResult, err error) {
+	if opts.Mnemonic && opts.KeyType != asymmetric.Secp256k1 {
+		err = errors.Wrap(errMnemonicKeyType, opts.KeyType.String())
+		return
+	}
+	if _, statErr := os.Stat(opts.KeyFile); statErr == nil && !opts.Force {
+		err = errors.Wrap(errKeyFileExists, opts.KeyFile)
+		return
+	}
+
+	var private asymmetric.TypedPrivateKey
+	switch {
+	case opts.Mnemonic:
+		if result.Mnemonic, err = kms.NewMnemonic(keygenMnemonicBits); err != nil {
+			err = errors.Wrap(err, "generate mnemonic failed")
+			return
+		}
+		private, err = kms.PrivateKeyFromMnemonic(result.Mnemonic, "")
+	case opts.KeyType == asymmetric.Ed25519:
+		private, _, err = asymmetric.GenEd25519KeyPair()
+	default:
+		private, _, err = asymmetric.GenSecp256k1KeyPair()
+	}
+	if err != nil {
+		err = errors.Wrap(err, "generate private key failed")
+		return
+	}
+
+	if len(opts.Passphrase) > 0 {
+		var keyBytes []byte
+		if keyBytes, err = kms.EncodeTypedPrivateKeyWithPassphrase(private, opts.Passphrase); err == nil {
+			err = os.WriteFile(opts.KeyFile, keyBytes, 0600)
+		}
+	} else {
+		err = kms.SaveTypedPrivateKey(opts.KeyFile, private, nil)
+	}
+	if err != nil {
+		err = errors.Wrap(err, "save private key failed")
+		return
+	}
+
+	public := private.TypedPubKey()
+	nonce := kms.MineNodeNonce(public, opts.Difficulty)
+	var nodeID proto.NodeID
+	if nodeID, err = proto.DeriveNodeID(public, nonce.Nonce); err != nil {
+		err = errors.Wrap(err, "derive node id failed")
+		return
+	}
+	result.Node = proto.Node{
+		ID:      nodeID,
+		Role:    opts.Role,
+		Addr:    opts.Addr,
+		KeyType: public.KeyType(),
+		Nonce:   nonce.Nonce,
+	}
+	switch k := public.(type) {
+	case *asymmetric.PublicKey:
+		result.Node.PublicKey = k
+	case asymmetric.Ed25519PublicKey:
+		result.Node.Ed25519PublicKey = k
+	}
+	if result.Snippet, err = yaml.Marshal([]proto.Node{result.Node}); err != nil {
+		err = errors.Wrap(err, "encode node snippet failed")
+		return
+	}
+	result.SnippetFile = opts.KeyFile + nodeSnippetSuffix
+	if err = os.WriteFile(result.SnippetFile, result.Snippet, 0644); err != nil {
+		err = errors.Wrap(err, "write node snippet failed")
+	}
+	return
+}
+
+// readNewPassphrase reads the passphrase of a new key from kms.PassphraseEnv or the
+// terminal, the prompted one is typed twice.
+func readNewPassphrase() (passphrase []byte, err error) {
+	return kms.ReadPassphrase(func() (key []byte, err error) {
+		fmt.Print("Type in passphrase of the new key: ")
+		if key, err = term.ReadPassword(int(syscall.Stdin)); err != nil {
+			fmt.Println("")
+			return
+		}
+		fmt.Print("\nRepeat the passphrase: ")
+		repeated, err := term.ReadPassword(int(syscall.Stdin))
+		fmt.Println("")
+		if err == nil && string(repeated) != string(key) {
+			err = errors.New("passphrases do not match")
+		}
+		return
+	})
+}
+
+// runKeygen runs the keygen command with args after the command name and returns the
+// exit code. The key path and ID difficulty default to the ones of -config if it
+// exists.
+func runKeygen(args []string, w io.Writer) int {
+	var (
+		flags          = flag.NewFlagSet("keygen", flag.ContinueOnError)
+		configPath     = flags.String("config", configFile, "Config file to take PrivateKeyFile and MinNodeIDDifficulty from, it is optional and searched like "+name)
+		keyFile        = flags.String("key", "", "Private key file path, default is PrivateKeyFile of the config")
+		keyType        = flags.String("key-type", asymmetric.Secp256k1.String(), "Key type of the private key, secp256k1 or ed25519")
+		difficulty     = flags.Int("difficulty", -1, "Node ID difficulty, default is MinNodeIDDifficulty of the config")
+		withPassphrase = flags.Bool("with-passphrase", false, "Encrypt the private key with a passphrase")
+		mnemonic       = flags.Bool("mnemonic", false, "Derive the key from new BIP39 recovery words and print them")
+		force          = flags.Bool("force", false, "Overwrite an existing key file")
+		role           = flags.String("role", proto.Miner.String(), "Role of the node in the KnownNodes snippet")
+		addr           = flags.String("addr", "", "Address of the node in the KnownNodes snippet")
+	)
+	flags.SetOutput(w)
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+
+	opts := keygenOptions{
+		KeyFile:    *keyFile,
+		Difficulty: *difficulty,
+		Mnemonic:   *mnemonic,
+		Force:      *force,
+		Addr:       *addr,
+	}
+	var err error
+	if opts.KeyType, err = asymmetric.ParseKeyType(*keyType); err != nil {
+		_, _ = fmt.Fprintf(w, "error: unknown key type %q\n", *keyType)
+		return 2
+	}
+	if opts.Role, err = proto.ParseServerRole(*role); err != nil || opts.Role == proto.Unknown {
+		_, _ = fmt.Fprintf(w, "error: unknown role %q\n", *role)
+		return 2
+	}
+	// the config is optional, keys can be generated before it is written
+	if path, findErr := conf.FindConfigFile(*configPath, os.LookupEnv); findErr == nil {
+		config, loadErr := conf.LoadConfig(path)
+		if loadErr != nil {
+			_, _ = fmt.Fprintf(w, "error: load config failed: %v\n", loadErr)
+			return 1
+		}
+		if opts.KeyFile == "" {
+			opts.KeyFile = config.PrivateKeyFile
+		}
+		if opts.Difficulty < 0 {
+			opts.Difficulty = config.MinNodeIDDifficulty
+		}
+	}
+	if opts.KeyFile == "" {
+		opts.KeyFile = "private.key"
+	}
+	opts.KeyFile = utils.HomeDirExpand(opts.KeyFile)
+	if opts.Difficulty < 0 {
+		opts.Difficulty = 0
+	}
+	if *withPassphrase {
+		if opts.Passphrase, err = readNewPassphrase(); err != nil {
+			_, _ = fmt.Fprintf(w, "error: read passphrase failed: %v\n", err)
+			return 1
+		}
+	}
+
+	result, err := generateNodeKey(opts)
+	if err != nil {
+		_, _ = fmt.Fprintf(w, "error: %v\n", err)
+		return 1
+	}
+	_, _ = fmt.Fprintf(w, "node id:          %s\n", result.Node.ID)
+	_, _ = fmt.Fprintf(w, "private key file: %s\n", opts.KeyFile)
+	_, _ = fmt.Fprintf(w, "node snippet:     %s\n\n%s", result.SnippetFile, result.Snippet)
+	if result.Mnemonic != "" {
+		_, _ = fmt.Fprintf(w, "\nrecovery words, keep them offline:\n%s\n", result.Mnemonic)
+	}
+	return 0
+}
@@ -0,0 +1,128 @@
+// +build !testbinary
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+	. "github.com/smartystreets/goconvey/convey"
+	yaml "gopkg.in/yaml.v2"
+
+	"sqlit/src/crypto/asymmetric"
+	"sqlit/src/crypto/kms"
+	"sqlit/src/proto"
+)
+
+func TestKeygen(t *testing.T) {
+	Convey("keygen writes the key and the KnownNodes snippet", t, func() {
+		dir := t.TempDir()
+		opts := keygenOptions{
+			KeyFile:    filepath.Join(dir, "private.key"),
+			Passphrase: []byte("secret"),
+			Difficulty: 1,
+			Role:       proto.Follower,
+			Addr:       "127.0.0.1:4661",
+		}
+		result, err := generateNodeKey(opts)
+		So(err, ShouldBeNil)
+		So(result.Mnemonic, ShouldBeBlank)
+		So(result.Node.ID.Difficulty(), ShouldBeGreaterThanOrEqualTo, 1)
+
+		private, err := kms.LoadPrivateKey(opts.KeyFile, opts.Passphrase)
+		So(err, ShouldBeNil)
+		So(private.PubKey().IsEqual(result.Node.PublicKey), ShouldBeTrue)
+		_, err = kms.LoadPrivateKey(opts.KeyFile, []byte("wrong"))
+		So(err, ShouldNotBeNil)
+
+		// the snippet is a KnownNodes entry with the ID derived from the key
+		content, err := os.ReadFile(result.SnippetFile)
+		So(err, ShouldBeNil)
+		var nodes []proto.Node
+		So(yaml.Unmarshal(content, &nodes), ShouldBeNil)
+		So(nodes, ShouldHaveLength, 1)
+		So(nodes[0].ID, ShouldEqual, result.Node.ID)
+		So(nodes[0].Role, ShouldEqual, proto.Follower)
+		So(nodes[0].Addr, ShouldEqual, opts.Addr)
+		key, err := nodes[0].TypedPublicKey()
+		So(err, ShouldBeNil)
+		derived, err := proto.DeriveNodeID(key, nodes[0].Nonce)
+		So(err, ShouldBeNil)
+		So(derived, ShouldEqual, result.Node.ID)
+
+		// an existing key is kept without force
+		_, err = generateNodeKey(opts)
+		So(err, ShouldNotBeNil)
+		kept, err := kms.LoadPrivateKey(opts.KeyFile, opts.Passphrase)
+		So(err, ShouldBeNil)
+		So(kept.PubKey().IsEqual(result.Node.PublicKey), ShouldBeTrue)
+
+		opts.Force = true
+		opts.Mnemonic = true
+		opts.Passphrase = nil
+		result, err = generateNodeKey(opts)
+		So(err, ShouldBeNil)
+		So(result.Mnemonic, ShouldNotBeBlank)
+		restored, err := kms.PrivateKeyFromMnemonic(result.Mnemonic, "")
+		So(err, ShouldBeNil)
+		So(restored.PubKey().IsEqual(result.Node.PublicKey), ShouldBeTrue)
+		private, err = kms.LoadPrivateKey(opts.KeyFile, nil)
+		So(err, ShouldBeNil)
+		So(private.PubKey().IsEqual(result.Node.PublicKey), ShouldBeTrue)
+	})
+	Convey("keygen writes an ed25519 key", t, func() {
+		dir := t.TempDir()
+		opts := keygenOptions{
+			KeyFile:    filepath.Join(dir, "private.key"),
+			KeyType:    asymmetric.Ed25519,
+			Passphrase: []byte("secret"),
+			Role:       proto.Follower,
+		}
+		result, err := generateNodeKey(opts)
+		So(err, ShouldBeNil)
+		So(result.Node.KeyType, ShouldEqual, asymmetric.Ed25519)
+		So(result.Node.PublicKey, ShouldBeNil)
+
+		private, err := kms.LoadTypedPrivateKey(opts.KeyFile, opts.Passphrase)
+		So(err, ShouldBeNil)
+		So(private.KeyType(), ShouldEqual, asymmetric.Ed25519)
+		var nodes []proto.Node
+		So(yaml.Unmarshal(result.Snippet, &nodes), ShouldBeNil)
+		So(nodes, ShouldHaveLength, 1)
+		key, err := nodes[0].TypedPublicKey()
+		So(err, ShouldBeNil)
+		So(asymmetric.TypedPublicKeyEqual(key, private.TypedPubKey()), ShouldBeTrue)
+		derived, err := proto.DeriveNodeID(key, nodes[0].Nonce)
+		So(err, ShouldBeNil)
+		So(derived, ShouldEqual, result.Node.ID)
+
+		// the recovery words derive secp256k1 keys only
+		opts.Force = true
+		opts.Mnemonic = true
+		_, err = generateNodeKey(opts)
+		So(errors.Cause(err), ShouldEqual, errMnemonicKeyType)
+
+		var out bytes.Buffer
+		So(runKeygen([]string{"-config", filepath.Join(dir, "missing.yaml"), "-key-type", "rsa"}, &out), ShouldEqual, 2)
+	})
+	Convey("keygen command refuses to overwrite the key", t, func() {
+		var (
+			dir     = t.TempDir()
+			keyFile = filepath.Join(dir, "node.key")
+			out     bytes.Buffer
+			args    = []string{"-config", filepath.Join(dir, "missing.yaml"), "-key", keyFile, "-addr", "a:1"}
+		)
+		So(runKeygen(args, &out), ShouldEqual, 0)
+		So(out.String(), ShouldContainSubstring, keyFile+nodeSnippetSuffix)
+		So(out.String(), ShouldContainSubstring, "Role: Miner")
+
+		out.Reset()
+		So(runKeygen(args, &out), ShouldEqual, 1)
+		So(out.String(), ShouldContainSubstring, "-force")
+		So(runKeygen(append(args, "-force"), &bytes.Buffer{}), ShouldEqual, 0)
+		So(runKeygen(append(args, "-role", "nope"), &bytes.Buffer{}), ShouldEqual, 2)
+	})
+}
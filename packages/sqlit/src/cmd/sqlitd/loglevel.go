@@ -0,0 +1,51 @@
+
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"sqlit/src/utils/log"
+)
+
+// watchLogLevelSignal raises the log level by a step on SIGUSR1 and lowers it on
+// SIGUSR2 until ctx is done. The level of module is stepped if it is not empty, e.g.
+// to debug a single noisy module, the global level otherwise.
+func watchLogLevelSignal(ctx context.Context, module string) {
+	signalCh := make(chan os.Signal, 1)
+	signal.Notify(signalCh, syscall.SIGUSR1, syscall.SIGUSR2)
+	go func() {
+		defer signal.Stop(signalCh)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case sig := <-signalCh:
+				steps := 1
+				if sig == syscall.SIGUSR2 {
+					steps = -1
+				}
+				stepLogLevel(module, steps)
+			}
+		}
+	}()
+}
+
+// stepLogLevel steps the level of module, or the global level if module is empty,
+// and logs the change. It is logged as a warning which no step silences.
+func stepLogLevel(module string, steps int) (level string) {
+	fields := log.Fields{}
+	if module == "" {
+		fields["from"] = log.GetLevel().String()
+		level = log.StepLevel(steps).String()
+	} else {
+		fields["module"] = module
+		fields["from"] = log.GetModuleLevel(module).String()
+		level = log.StepModuleLevel(module, steps).String()
+	}
+	fields["to"] = level
+	log.WithFields(fields).Warning("log level changed")
+	return
+}
@@ -0,0 +1,53 @@
+// +build !testbinary
+
+package main
+
+import (
+	"context"
+	"syscall"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"sqlit/src/utils/log"
+)
+
+func TestLogLevelSignal(t *testing.T) {
+	defer log.SetLevel(log.GetLevel())
+	defer func() { _ = log.SetModuleLevels(nil) }()
+
+	Convey("the signals step the global log level", t, func() {
+		log.SetLevel(log.InfoLevel)
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		watchLogLevelSignal(ctx, "")
+
+		So(syscall.Kill(syscall.Getpid(), syscall.SIGUSR1), ShouldBeNil)
+		So(waitLevel(func() bool { return log.GetLevel() == log.DebugLevel }), ShouldBeTrue)
+		// the signals pending at once are delivered as one
+		So(syscall.Kill(syscall.Getpid(), syscall.SIGUSR2), ShouldBeNil)
+		So(waitLevel(func() bool { return log.GetLevel() == log.InfoLevel }), ShouldBeTrue)
+		So(syscall.Kill(syscall.Getpid(), syscall.SIGUSR2), ShouldBeNil)
+		So(waitLevel(func() bool { return log.GetLevel() == log.WarnLevel }), ShouldBeTrue)
+	})
+	Convey("the signal module is stepped alone", t, func() {
+		log.SetLevel(log.InfoLevel)
+		So(stepLogLevel("route", 1), ShouldEqual, "debug")
+		So(log.GetModuleLevel("route"), ShouldEqual, log.DebugLevel)
+		So(log.GetModuleLevel("kms"), ShouldEqual, log.InfoLevel)
+		So(stepLogLevel("route", -2), ShouldEqual, "warning")
+		So(log.GetLevel(), ShouldEqual, log.InfoLevel)
+	})
+}
+
+// waitLevel waits a while for the log level matching cond.
+func waitLevel(cond func() bool) bool {
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); {
+		if cond() {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
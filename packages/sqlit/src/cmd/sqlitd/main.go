@@ -7,6 +7,7 @@ import (
 	_ "net/http/pprof"
 	"os"
 	"runtime"
+	"strings"
 
 	"sqlit/src/conf"
 	"sqlit/src/crypto/asymmetric"
@@ -26,10 +27,12 @@ const logo = `
 
 `
 
+// build info, set by -ldflags -X main.version=... in Makefile
 var (
-	version = "1"
-	commit  = "unknown"
-	branch  = "unknown"
+	version   = "1"
+	commit    = "unknown"
+	branch    = "unknown"
+	buildDate = "unknown"
 )
 
 var (
@@ -39,14 +42,20 @@ var (
 	metricWeb  string
 
 	// other
-	noLogo      bool
-	showVersion bool
-	configFile  string
-	testMode    bool
+	noLogo       bool
+	showVersion  bool
+	configFiles  configFilesFlag
+	configFile   string
+	remoteConfig string
+	testMode     bool
 
 	wsapiAddr string
+	dryRun    bool
+	reinit    bool
 
-	logLevel string
+	logLevel       string
+	logFormat      string
+	unsafeDebugLog bool
 )
 
 const name = `sqlitd`
@@ -59,40 +68,135 @@ func init() {
 		"Disable signature sign and verify, for testing")
 	flag.BoolVar(&testMode, "test-mode", false,
 		"Enable test mode to bypass node ID validation, for testing")
-	flag.StringVar(&configFile, "config", "~/.sqlit/config.yaml", "Config file path")
+	flag.Var(&configFiles, "config",
+		"Config file path, default is $"+conf.ConfigFileEnv+", then ./config.yaml, then ~/.sqlit/config.yaml, "+
+			"repeat it or give a directory to merge the files in order")
+	flag.StringVar(&remoteConfig, "remote-config", os.Getenv(conf.RemoteConfigEnv),
+		"URL of the config in Consul or etcd, e.g. consul://127.0.0.1:8500/sqlit/node1, default is $"+
+			conf.RemoteConfigEnv+", the config file is its cached copy")
+	flag.BoolVar(&conf.KnownNodesWarnOnly, "known-nodes-warn-only", false,
+		"Log duplicate or mismatched KnownNodes instead of failing, for migrating configs")
 
 	flag.StringVar(&cpuProfile, "cpu-profile", "", "Path to file for CPU profiling information")
 	flag.StringVar(&memProfile, "mem-profile", "", "Path to file for memory profiling information")
 	flag.StringVar(&metricWeb, "metric-web", "", "Address and port to get internal metrics")
 
+	flag.BoolVar(&dryRun, "dry-run", false,
+		"Print the peers and the node mutations of the node initialization and exit")
+	flag.BoolVar(&reinit, "reinit", false,
+		"Discard the persisted peers and public keystore and initialize the node from the config")
 	flag.StringVar(&wsapiAddr, "wsapi", "", "Address of the websocket JSON-RPC API, run as API Node")
 	flag.StringVar(&logLevel, "log-level", "", "Service log level")
+	flag.StringVar(&logFormat, "log-format", log.TextFormat, "Service log format, text or json")
+	flag.BoolVar(&unsafeDebugLog, "unsafe-debug-log", false,
+		"Log the private keys and the other secrets unredacted, for debugging only")
 
 	flag.Usage = func() {
 		_, _ = fmt.Fprintf(os.Stderr, "\n%s\n\n", desc)
 		_, _ = fmt.Fprintf(os.Stderr, "Usage: %s [arguments]\n", name)
+		_, _ = fmt.Fprintf(os.Stderr, "       %s version\n", name)
+		_, _ = fmt.Fprintf(os.Stderr, "       %s config-check [-config path]\n", name)
+		_, _ = fmt.Fprintf(os.Stderr, "       %s keygen [-key path] [-with-passphrase] [-mnemonic] [-force]\n", name)
+		_, _ = fmt.Fprintf(os.Stderr, "       %s export-peers -out path [-peers path] [-force]  (offline snapshot)\n", name)
+		_, _ = fmt.Fprintf(os.Stderr, "       %s import-peers -in path [-peers path] [-force]\n", name)
 		flag.PrintDefaults()
 	}
 }
 
+// configFilesFlag is the repeatable -config flag.
+type configFilesFlag []string
+
+// String implements flag.Value.String.
+func (f *configFilesFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+// Set implements flag.Value.Set.
+func (f *configFilesFlag) Set(value string) error {
+	*f = append(*f, value)
+	return nil
+}
+
+// last returns the last config file given, empty if none.
+func (f configFilesFlag) last() string {
+	if len(f) == 0 {
+		return ""
+	}
+	return f[len(f)-1]
+}
+
 func initLogs() {
-	log.Infof("%#v starting, version %#v, commit %#v, branch %#v", name, version, commit, branch)
-	log.Infof("%#v, target architecture is %#v, operating system target is %#v",
-		runtime.Version(), runtime.GOARCH, runtime.GOOS)
-	log.Infof("role: %#v", conf.RoleTag)
+	log.WithFields(log.Fields{
+		"version":   version,
+		"commit":    commit,
+		"branch":    branch,
+		"buildDate": buildDate,
+		"go":        runtime.Version(),
+		"os":        runtime.GOOS,
+		"arch":      runtime.GOARCH,
+		"role":      conf.RoleTag,
+	}).Info(name + " starting")
+}
+
+// versionInfo returns the build info printed by -version and the version command.
+func versionInfo() string {
+	return fmt.Sprintf("%s %s commit %s branch %s built %s %s %s/%s",
+		name, version, commit, branch, buildDate, runtime.Version(), runtime.GOOS, runtime.GOARCH)
 }
 
 func main() {
 	flag.Parse()
+	// the most specific file is the one of the subcommands and the remote cache
+	configFile = configFiles.last()
 	log.SetStringLevel(logLevel, log.InfoLevel)
+	if err := log.SetFormat(logFormat); err != nil {
+		log.WithError(err).Fatal("set log format failed")
+	}
+	if unsafeDebugLog {
+		log.SetUnsafeDebug(true)
+		log.Warning("log redaction disabled, the secrets are logged unredacted")
+	}
 
-	if showVersion {
-		fmt.Printf("%v %v %v %v %v\n",
-			name, version, runtime.GOOS, runtime.GOARCH, runtime.Version())
+	// print the build info without loading config
+	if showVersion || flag.Arg(0) == "version" {
+		fmt.Println(versionInfo())
 		os.Exit(0)
 	}
+	// validate a config offline for CI, nothing is listened or written
+	if flag.Arg(0) == "config-check" {
+		os.Exit(runConfigCheck(flag.Args()[1:], os.Stdout))
+	}
+	if flag.Arg(0) == "keygen" {
+		os.Exit(runKeygen(flag.Args()[1:], os.Stdout))
+	}
+	if flag.Arg(0) == "export-peers" {
+		os.Exit(runExportPeers(flag.Args()[1:], os.Stdout))
+	}
+	if flag.Arg(0) == "import-peers" {
+		os.Exit(runImportPeers(flag.Args()[1:], os.Stdout))
+	}
 
-	configFile = utils.HomeDirExpand(configFile)
+	var err error
+	if remoteConfig != "" {
+		if len(configFiles) > 1 {
+			log.Fatal("-remote-config takes a single -config as its cached copy")
+		}
+		configFile = remoteConfigCache(configFile, os.LookupEnv)
+		configFiles = configFilesFlag{configFile}
+	} else {
+		if len(configFiles) <= 1 {
+			if configFile, err = conf.FindConfigFile(configFile, os.LookupEnv); err != nil {
+				log.WithError(err).Fatal("find config file failed")
+			}
+			configFiles = configFilesFlag{configFile}
+		}
+		// the directories are kept in configFiles, so a reload picks up the new files
+		var files []string
+		if files, err = conf.ExpandConfigPaths(configFiles); err != nil {
+			log.WithError(err).Fatal("find config files failed")
+		}
+		configFile = files[len(files)-1]
+	}
 
 	flag.Visit(func(f *flag.Flag) {
 		log.Infof("args %#v : %s", f.Name, f.Value)
@@ -104,10 +208,41 @@ func main() {
 		log.Info("Test mode enabled - bypassing node ID validation")
 	}
 
-	var err error
-	conf.GConf, err = conf.LoadConfig(configFile)
+	if remoteConfig != "" {
+		conf.GConf, err = loadRemoteConfig(remoteConfig, configFile, os.LookupEnv)
+	} else {
+		conf.GConf, err = conf.LoadConfigs(configFiles...)
+	}
 	if err != nil {
-		log.WithField("config", configFile).WithError(err).Fatal("load config failed")
+		log.WithField("config", configFiles).WithError(err).Fatal("load config failed")
+	}
+	if err = conf.GConf.Validate(); err != nil {
+		log.WithField("config", configFiles).WithError(err).Fatal("invalid config")
+	}
+	if conf.GConf.Log != nil {
+		if conf.GConf.Log.File != "" {
+			logWriter := log.NewRotatingWriter(conf.GConf.Log.RotateConfig())
+			defer func() { _ = logWriter.Close() }()
+			log.SetOutput(logWriter)
+		}
+		log.SetRedactPublic(conf.GConf.Log.RedactPublicKeys)
+		if err = log.SetModuleLevels(conf.GConf.Log.Levels); err != nil {
+			log.WithError(err).Fatal("set module log levels failed")
+		}
+		if conf.GConf.Log.ErrorStackLevel != "" {
+			stackLevel, levelErr := log.ParseLevel(conf.GConf.Log.ErrorStackLevel)
+			if levelErr != nil {
+				log.WithError(levelErr).Fatal("parse error stack log level failed")
+			}
+			log.SetErrorStackLevel(stackLevel)
+		}
+		if conf.GConf.Log.Syslog != nil {
+			syslogHook, syslogErr := log.EnableSyslog(conf.GConf.Log.Syslog.SyslogConfig())
+			if syslogErr != nil {
+				log.WithError(syslogErr).Fatal("enable syslog failed")
+			}
+			defer func() { _ = syslogHook.Close() }()
+		}
 	}
 
 	kms.InitBP()
@@ -118,6 +253,13 @@ func main() {
 	// init log
 	initLogs()
 
+	if dryRun {
+		if err = runDryRun(conf.GConf.ThisNodeID, os.Stdout); err != nil {
+			log.WithError(err).Fatal("dry run failed")
+		}
+		os.Exit(0)
+	}
+
 	if !noLogo {
 		fmt.Print(logo)
 	}
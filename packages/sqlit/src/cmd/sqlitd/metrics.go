@@ -0,0 +1,19 @@
+
+package main
+
+import (
+	"net/http"
+
+	"sqlit/src/metric"
+)
+
+// startMetricsServer serves the prometheus /metrics endpoint on addr.
+func startMetricsServer(addr string) (server *http.Server, err error) {
+	registry, err := metric.NewNodeStateRegistry()
+	if err != nil {
+		return
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metric.NewMetricsHandler(registry))
+	return startHTTPServer("metrics", addr, mux)
+}
@@ -0,0 +1,101 @@
+
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"sqlit/src/conf"
+	"sqlit/src/crypto/kms"
+	"sqlit/src/proto"
+	"sqlit/src/route"
+	"sqlit/src/utils/log"
+)
+
+// reloadLock serializes the reloads of SIGHUP and the admin rpc.
+var reloadLock sync.Mutex
+
+// watchConfigReload reloads the config merged from configPaths on SIGHUP until ctx
+// is done. It must be called after utils.WaitForExit which ignores SIGHUP.
+func watchConfigReload(ctx context.Context, configPaths ...string) {
+	signalCh := make(chan os.Signal, 1)
+	signal.Notify(signalCh, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(signalCh)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-signalCh:
+				log.WithField("config", configPaths).Info("reload config")
+				if _, err := reloadConfig(configPaths...); err != nil {
+					log.WithField("config", configPaths).WithError(err).Error(
+						"reload config failed, keep the old config")
+				}
+			}
+		}
+	}()
+}
+
+// reloadConfig parses the config merged from configPaths, see conf.LoadConfigs, and
+// applies the known nodes delta to kms and route.
+// A parse error keeps conf.GConf intact, the changes of the local node and the
+// other fields needing a restart are logged as ignored.
+func reloadConfig(configPaths ...string) (delta conf.ConfigDelta, err error) {
+	reloadLock.Lock()
+	defer reloadLock.Unlock()
+
+	var reloaded *conf.Config
+	if reloaded, err = conf.LoadConfigs(configPaths...); err != nil {
+		return
+	}
+	old := conf.GConf
+	for i := range reloaded.KnownNodes {
+		// resolved like prepareKnownNodes, so the unchanged nodes keep their own key
+		reloaded.KnownNodes[i].PublicKey = knownNodePublicKey(&reloaded.KnownNodes[i])
+	}
+
+	delta = conf.DiffConfig(old, reloaded)
+	for _, nodes := range [][]proto.Node{delta.Added, delta.Updated} {
+		for _, n := range nodes {
+			node := n
+			if setErr := kms.SetNode(&node, knownNodeVerifyOpt(reloaded)); setErr != nil {
+				log.WithField("node", node.ID).WithError(setErr).Error("set reloaded node failed")
+			}
+			if cacheErr := route.SetNodeAddrCache(
+				node.ID.ToRawNodeID(), node.Addr, node.Addrs...); cacheErr != nil {
+				log.WithField("node", node.ID).WithError(cacheErr).Error("set reloaded node addr failed")
+			}
+		}
+	}
+	for _, n := range delta.Removed {
+		if delErr := kms.DelNode(n.ID); delErr != nil {
+			log.WithField("node", n.ID).WithError(delErr).Warning("delete removed node failed")
+		}
+		if cacheErr := route.DelNodeAddrCache(n.ID.ToRawNodeID()); cacheErr != nil &&
+			cacheErr != route.ErrUnknownNodeID {
+			log.WithField("node", n.ID).WithError(cacheErr).Warning("evict removed node addr failed")
+		}
+	}
+	conf.GConf = conf.ApplyKnownNodes(old, reloaded)
+
+	for _, key := range delta.Ignored {
+		log.WithField("field", key).Warning("config change ignored, restart to apply")
+	}
+	log.WithFields(log.Fields{
+		"added":   nodeIDs(delta.Added),
+		"updated": nodeIDs(delta.Updated),
+		"removed": nodeIDs(delta.Removed),
+	}).Info("config reloaded")
+	return
+}
+
+func nodeIDs(nodes []proto.Node) (ids []proto.NodeID) {
+	for _, n := range nodes {
+		ids = append(ids, n.ID)
+	}
+	return
+}
@@ -0,0 +1,131 @@
+// +build !testbinary
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	yaml "gopkg.in/yaml.v2"
+
+	"sqlit/src/conf"
+	"sqlit/src/crypto/asymmetric"
+	"sqlit/src/crypto/kms"
+	"sqlit/src/proto"
+	"sqlit/src/route"
+)
+
+func TestReloadConfig(t *testing.T) {
+	Convey("reload config applies known nodes atomically", t, func() {
+		var (
+			configPath = filepath.Join(t.TempDir(), "config.yaml")
+			self       = proto.NodeID("0000000000000000000000000000000000000000000000000000000000000011")
+			gone       = proto.NodeID("0000000000000000000000000000000000000000000000000000000000000012")
+			joined     = proto.NodeID("0000000000000000000000000000000000000000000000000000000000000013")
+			write      = func(config *conf.Config) {
+				out, err := yaml.Marshal(config)
+				So(err, ShouldBeNil)
+				So(os.WriteFile(configPath, out, 0600), ShouldBeNil)
+			}
+		)
+		write(&conf.Config{
+			ThisNodeID: self,
+			KnownNodes: []proto.Node{{ID: self, Addr: "a:1"}, {ID: gone, Addr: "a:2"}},
+		})
+		old, err := conf.LoadConfig(configPath)
+		So(err, ShouldBeNil)
+		defer func(saved *conf.Config) { conf.GConf = saved }(conf.GConf)
+		conf.GConf = old
+		So(route.SetNodeAddrCache(gone.ToRawNodeID(), "a:2"), ShouldBeNil)
+
+		// a corrupt file keeps the old config
+		So(os.WriteFile(configPath, []byte("KnownNodes: [\n"), 0600), ShouldBeNil)
+		_, err = reloadConfig(configPath)
+		So(err, ShouldNotBeNil)
+		So(conf.GConf, ShouldEqual, old)
+
+		write(&conf.Config{
+			ThisNodeID: self,
+			ListenAddr: "b:0",
+			KnownNodes: []proto.Node{{ID: self, Addr: "b:1"}, {ID: joined, Addr: "a:3", Addrs: []string{"b:3"}}},
+		})
+		delta, err := reloadConfig(configPath)
+		So(err, ShouldBeNil)
+		So(delta.Ignored, ShouldResemble, []string{"KnownNodes[" + string(self) + "]", "ListenAddr"})
+		So(conf.GConf, ShouldNotEqual, old)
+		So(conf.GConf.ListenAddr, ShouldBeBlank)
+		// the roles are filled by the schema defaults on load
+		So(conf.GConf.KnownNodes, ShouldResemble, []proto.Node{
+			{ID: self, Role: conf.DefaultNodeRole, Addr: "a:1"},
+			{ID: joined, Role: conf.DefaultNodeRole, Addr: "a:3", Addrs: []string{"b:3"}},
+		})
+		addrs, err := route.GetNodeAddrsCache(joined.ToRawNodeID())
+		So(err, ShouldBeNil)
+		So(addrs, ShouldResemble, []string{"a:3", "b:3"})
+		_, err = route.GetNodeAddrCache(gone.ToRawNodeID())
+		So(err, ShouldEqual, route.ErrUnknownNodeID)
+	})
+	Convey("reload config merges the repeated -config files", t, func() {
+		var (
+			dir    = t.TempDir()
+			self   = proto.NodeID("0000000000000000000000000000000000000000000000000000000000000011")
+			other  = proto.NodeID("0000000000000000000000000000000000000000000000000000000000000014")
+			base   = filepath.Join(dir, "base.yaml")
+			host   = filepath.Join(dir, "host.yaml")
+			config configFilesFlag
+		)
+		So(os.WriteFile(base, []byte("ThisNodeID: \""+string(self)+"\"\nKnownNodes:\n- {ID: \""+
+			string(self)+"\", Addr: \"a:1\"}\n"), 0600), ShouldBeNil)
+		So(os.WriteFile(host, []byte("KnownNodes:\n- {ID: \""+string(other)+"\", Addr: \"a:4\"}\n"), 0600), ShouldBeNil)
+		So(config.Set(base), ShouldBeNil)
+		So(config.Set(host), ShouldBeNil)
+		So(config.String(), ShouldEqual, base+","+host)
+		So(config.last(), ShouldEqual, host)
+
+		defer func(saved *conf.Config) { conf.GConf = saved }(conf.GConf)
+		conf.GConf = &conf.Config{ThisNodeID: self, KnownNodes: []proto.Node{{ID: self, Addr: "a:1"}}}
+		_, err := reloadConfig(config...)
+		So(err, ShouldBeNil)
+		So(conf.GConf.KnownNodes, ShouldHaveLength, 2)
+		So(conf.GConf.KnownNodes[1].ID, ShouldEqual, other)
+	})
+	Convey("reload config keeps the own public key of each voter", t, func() {
+		var (
+			configPath = filepath.Join(t.TempDir(), "config.yaml")
+			self       = proto.NodeID("0000000000000000000000000000000000000000000000000000000000000011")
+		)
+		_, leaderKey, err := asymmetric.GenSecp256k1KeyPair()
+		So(err, ShouldBeNil)
+		_, followerKey, err := asymmetric.GenSecp256k1KeyPair()
+		So(err, ShouldBeNil)
+		leaderNonce, followerNonce := kms.MineNodeNonce(leaderKey, 0), kms.MineNodeNonce(followerKey, 0)
+		leader, follower := proto.NodeID(leaderNonce.Hash.String()), proto.NodeID(followerNonce.Hash.String())
+		_, bpKey, err := asymmetric.GenSecp256k1KeyPair()
+		So(err, ShouldBeNil)
+		defer func(saved *conf.BPInfo) { kms.BP = saved }(kms.BP)
+		kms.BP = &conf.BPInfo{NodeID: leader, PublicKey: bpKey}
+
+		out, err := yaml.Marshal(&conf.Config{
+			ThisNodeID: self,
+			KnownNodes: []proto.Node{
+				{ID: self, Addr: "a:1"},
+				{ID: leader, Role: proto.Leader, Addr: "a:5", PublicKey: leaderKey, Nonce: leaderNonce.Nonce},
+				{ID: follower, Role: proto.Follower, Addr: "a:6", PublicKey: followerKey, Nonce: followerNonce.Nonce},
+			},
+		})
+		So(err, ShouldBeNil)
+		So(os.WriteFile(configPath, out, 0600), ShouldBeNil)
+		old, err := conf.LoadConfig(configPath)
+		So(err, ShouldBeNil)
+		defer func(saved *conf.Config) { conf.GConf = saved }(conf.GConf)
+		conf.GConf = old
+
+		delta, err := reloadConfig(configPath)
+		So(err, ShouldBeNil)
+		So(delta.Updated, ShouldBeEmpty)
+		So(conf.GConf.KnownNodes[1].PublicKey.IsEqual(leaderKey), ShouldBeTrue)
+		So(conf.GConf.KnownNodes[2].PublicKey.IsEqual(followerKey), ShouldBeTrue)
+	})
+}
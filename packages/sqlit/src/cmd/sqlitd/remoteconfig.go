@@ -0,0 +1,53 @@
+
+package main
+
+import (
+	"context"
+
+	"sqlit/src/conf"
+	"sqlit/src/utils"
+	"sqlit/src/utils/log"
+)
+
+var (
+	// remoteSource is the remote store of -remote-config, the config file is its
+	// cached copy, remoteIndex is the store state of the loaded config
+	remoteSource conf.RemoteSource
+	remoteIndex  uint64
+)
+
+// remoteConfigCache returns the cached copy path of the remote config, it need not
+// exist: explicit, then the conf.ConfigFileEnv variable, then the first of
+// conf.DefaultConfigFiles.
+func remoteConfigCache(explicit string, lookup func(key string) (string, bool)) string {
+	if explicit != "" {
+		return utils.HomeDirExpand(explicit)
+	}
+	if value, ok := lookup(conf.ConfigFileEnv); ok && value != "" {
+		return utils.HomeDirExpand(value)
+	}
+	return utils.HomeDirExpand(conf.DefaultConfigFiles[0])
+}
+
+// loadRemoteConfig loads the config of rawURL cached at configPath.
+func loadRemoteConfig(rawURL, configPath string, lookup func(key string) (string, bool)) (config *conf.Config, err error) {
+	if remoteSource, err = conf.NewRemoteSource(rawURL, lookup); err != nil {
+		return
+	}
+	config, remoteIndex, err = conf.LoadRemoteConfig(context.Background(), remoteSource, configPath)
+	return
+}
+
+// watchRemoteConfig reloads the config from configPath on every change of the remote
+// config until ctx is done, it is a no-op without -remote-config.
+func watchRemoteConfig(ctx context.Context, configPath string) {
+	if remoteSource == nil {
+		return
+	}
+	conf.WatchRemoteConfig(ctx, remoteSource, configPath, remoteIndex, func() {
+		if _, err := reloadConfig(configPath); err != nil {
+			log.WithField("config", configPath).WithError(err).Error(
+				"reload remote config failed, keep the old config")
+		}
+	})
+}
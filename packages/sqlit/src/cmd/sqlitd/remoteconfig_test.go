@@ -0,0 +1,31 @@
+// +build !testbinary
+
+package main
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"sqlit/src/conf"
+	"sqlit/src/utils"
+)
+
+func TestRemoteConfigCache(t *testing.T) {
+	Convey("the remote config cache path", t, func() {
+		env := map[string]string{}
+		lookup := func(key string) (value string, ok bool) {
+			value, ok = env[key]
+			return
+		}
+		So(remoteConfigCache("", lookup), ShouldEqual, utils.HomeDirExpand(conf.DefaultConfigFiles[0]))
+		env[conf.ConfigFileEnv] = "/etc/sqlit/remote.yaml"
+		So(remoteConfigCache("", lookup), ShouldEqual, "/etc/sqlit/remote.yaml")
+		So(remoteConfigCache("/tmp/cache.yaml", lookup), ShouldEqual, "/tmp/cache.yaml")
+	})
+	Convey("no watch without a remote config", t, func() {
+		So(remoteSource, ShouldBeNil)
+		So(func() { watchRemoteConfig(context.Background(), "") }, ShouldNotPanic)
+	})
+}
@@ -0,0 +1,111 @@
+
+package main
+
+import (
+	"context"
+	"os"
+	"sync"
+	"time"
+
+	"sqlit/src/utils/log"
+)
+
+const (
+	// defaultShutdownTimeout is used when conf.GConf.ShutdownTimeout is not set.
+	defaultShutdownTimeout = 30 * time.Second
+	// exitCodeShutdownTimeout is the exit code of a shutdown not finished in time.
+	exitCodeShutdownTimeout = 3
+)
+
+// forceExit exits the process when the shutdown times out, it is replaced by tests.
+var forceExit = os.Exit
+
+// shutdownStep is a named cleanup of the node.
+type shutdownStep struct {
+	name string
+	fn   func() error
+}
+
+// shutdown runs the cleanups of runNode once, in reverse order of registration like
+// deferred calls. The root context is canceled before the first cleanup.
+type shutdown struct {
+	ctx     context.Context
+	cancel  context.CancelFunc
+	timeout time.Duration
+
+	mu        sync.Mutex
+	steps     []shutdownStep
+	triggered chan struct{}
+	trigger   sync.Once
+	run       sync.Once
+}
+
+func newShutdown(timeout time.Duration) *shutdown {
+	if timeout <= 0 {
+		timeout = defaultShutdownTimeout
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &shutdown{
+		ctx:       ctx,
+		cancel:    cancel,
+		timeout:   timeout,
+		triggered: make(chan struct{}),
+	}
+}
+
+// Context returns the root context of the node, it is canceled on shutdown.
+func (s *shutdown) Context() context.Context {
+	return s.ctx
+}
+
+// Add registers a cleanup run on shutdown.
+func (s *shutdown) Add(name string, fn func() error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.steps = append(s.steps, shutdownStep{name: name, fn: fn})
+}
+
+// Trigger requests the shutdown programmatically, it is the same as a signal.
+func (s *shutdown) Trigger() {
+	s.trigger.Do(func() { close(s.triggered) })
+}
+
+// Wait blocks until a signal is received on exitCh or Trigger is called.
+func (s *shutdown) Wait(exitCh <-chan os.Signal) {
+	select {
+	case sig := <-exitCh:
+		log.WithField("signal", sig).Info("received exit signal")
+	case <-s.triggered:
+		log.Info("shutdown triggered")
+	}
+}
+
+// Run cancels the root context and runs the cleanups, the process is force exited
+// with exitCodeShutdownTimeout if they do not finish within the timeout. A failed
+// cleanup is logged and the next one still runs.
+func (s *shutdown) Run() {
+	s.run.Do(func() {
+		s.cancel()
+		s.mu.Lock()
+		steps := s.steps
+		s.mu.Unlock()
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for i := len(steps) - 1; i >= 0; i-- {
+				log.WithField("step", steps[i].name).Debug("shutdown step")
+				if err := steps[i].fn(); err != nil {
+					log.WithField("step", steps[i].name).WithError(err).Error("shutdown step failed")
+				}
+			}
+		}()
+		select {
+		case <-done:
+			log.Info("shutdown finished")
+		case <-time.After(s.timeout):
+			log.WithField("timeout", s.timeout).Error("shutdown timed out, force exit")
+			forceExit(exitCodeShutdownTimeout)
+		}
+	})
+}
@@ -0,0 +1,77 @@
+// +build !testbinary
+
+package main
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestShutdown(t *testing.T) {
+	Convey("shutdown runs the steps in reverse order once", t, func() {
+		sd := newShutdown(time.Second)
+		var (
+			order       []string
+			canceledErr error
+		)
+		sd.Add("first", func() error {
+			order = append(order, "first")
+			return nil
+		})
+		sd.Add("failed", func() error {
+			order = append(order, "failed")
+			return errors.New("step failed")
+		})
+		sd.Add("last", func() error {
+			canceledErr = sd.Context().Err()
+			order = append(order, "last")
+			return nil
+		})
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			sd.Wait(make(chan os.Signal))
+		}()
+		sd.Trigger()
+		sd.Trigger()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("trigger does not unblock wait")
+		}
+
+		sd.Run()
+		sd.Run()
+		So(order, ShouldResemble, []string{"last", "failed", "first"})
+		// the root context is canceled before the steps
+		So(canceledErr, ShouldNotBeNil)
+	})
+	Convey("a signal unblocks wait", t, func() {
+		sd := newShutdown(0)
+		So(sd.timeout, ShouldEqual, defaultShutdownTimeout)
+		exitCh := make(chan os.Signal, 1)
+		exitCh <- syscall.SIGTERM
+		sd.Wait(exitCh)
+	})
+	Convey("shutdown not finished in time is force exited", t, func() {
+		defer func(saved func(int)) { forceExit = saved }(forceExit)
+		exitCode := make(chan int, 1)
+		forceExit = func(code int) { exitCode <- code }
+
+		release := make(chan struct{})
+		defer close(release)
+		sd := newShutdown(50 * time.Millisecond)
+		sd.Add("stuck", func() error {
+			<-release
+			return nil
+		})
+		sd.Run()
+		So(<-exitCode, ShouldEqual, exitCodeShutdownTimeout)
+	})
+}
@@ -0,0 +1,109 @@
+
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"sqlit/src/conf"
+	"sqlit/src/proto"
+	rpc "sqlit/src/rpc/mux"
+	"sqlit/src/utils/log"
+)
+
+const (
+	// defaultWarmupTimeout is used when conf.WarmupInfo.Timeout is not set.
+	defaultWarmupTimeout = 5 * time.Second
+	// defaultWarmupConcurrency is used when conf.WarmupInfo.Concurrency is not set.
+	defaultWarmupConcurrency = 16
+)
+
+// warmupDial opens a pooled session to id, the route cache learns its address on the
+// way. It is replaced by tests.
+var warmupDial = func(ctx context.Context, id proto.NodeID) (err error) {
+	done := make(chan error, 1)
+	go func() {
+		// the pool dial has no context, a late session is still kept by the pool
+		client, dialErr := rpc.GetSessionPoolInstance().Get(id)
+		if dialErr == nil {
+			// only the stream is closed, the session stays in the pool
+			dialErr = client.Close()
+		}
+		done <- dialErr
+	}()
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	return
+}
+
+// warmupResult is the summary of warmupPeers.
+type warmupResult struct {
+	Dialed int
+	Failed int
+}
+
+// warmupPeers pre-dials the servers of peers except local concurrently, each within
+// the timeout of info, so the first requests to them find a connection. The failures
+// are logged only, the caller runs it in background not to delay the startup.
+func warmupPeers(
+	ctx context.Context, info conf.WarmupInfo, peers *proto.Peers, local proto.NodeID,
+) (result warmupResult) {
+	if info.Disabled || peers == nil {
+		return
+	}
+	timeout := info.Timeout
+	if timeout <= 0 {
+		timeout = defaultWarmupTimeout
+	}
+	concurrency := info.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultWarmupConcurrency
+	}
+
+	var (
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		sema  = make(chan struct{}, concurrency)
+		seen  = make(map[proto.NodeID]bool, len(peers.Servers))
+		start = time.Now()
+	)
+	for _, id := range peers.Servers {
+		if id == local || seen[id] {
+			continue
+		}
+		seen[id] = true
+		select {
+		case sema <- struct{}{}:
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		}
+		wg.Add(1)
+		go func(id proto.NodeID) {
+			defer func() { <-sema; wg.Done() }()
+			dialCtx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			dialErr := warmupDial(dialCtx, id)
+			mu.Lock()
+			defer mu.Unlock()
+			if dialErr != nil {
+				result.Failed++
+				log.WithField("node", id).WithError(dialErr).Warning("warm up connection failed")
+				return
+			}
+			result.Dialed++
+		}(id)
+	}
+	wg.Wait()
+
+	log.WithFields(log.Fields{
+		"dialed":  result.Dialed,
+		"failed":  result.Failed,
+		"elapsed": time.Since(start),
+	}).Info("warm up peers connections")
+	return
+}
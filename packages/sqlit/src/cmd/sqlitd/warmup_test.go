@@ -0,0 +1,82 @@
+// +build !testbinary
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	. "github.com/smartystreets/goconvey/convey"
+
+	"sqlit/src/conf"
+	"sqlit/src/proto"
+)
+
+func TestWarmupPeers(t *testing.T) {
+	defer func(saved func(context.Context, proto.NodeID) error) { warmupDial = saved }(warmupDial)
+	var (
+		local = proto.NodeID(fmt.Sprintf("%064x", 1))
+		peers = &proto.Peers{}
+	)
+	for i := 1; i <= 20; i++ {
+		peers.Servers = append(peers.Servers, proto.NodeID(fmt.Sprintf("%064x", i)))
+	}
+	// a duplicate server is dialed once
+	peers.Servers = append(peers.Servers, peers.Servers[2])
+
+	Convey("the servers except local are dialed within the concurrency", t, func() {
+		var (
+			mu       sync.Mutex
+			dialed   = make(map[proto.NodeID]int)
+			inFlight int32
+			maxSeen  int32
+		)
+		warmupDial = func(ctx context.Context, id proto.NodeID) error {
+			n := atomic.AddInt32(&inFlight, 1)
+			defer atomic.AddInt32(&inFlight, -1)
+			for {
+				seen := atomic.LoadInt32(&maxSeen)
+				if n <= seen || atomic.CompareAndSwapInt32(&maxSeen, seen, n) {
+					break
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+			mu.Lock()
+			defer mu.Unlock()
+			dialed[id]++
+			if id == peers.Servers[5] {
+				return errors.New("connection refused")
+			}
+			return nil
+		}
+		result := warmupPeers(context.Background(), conf.WarmupInfo{Concurrency: 4}, peers, local)
+		So(result, ShouldResemble, warmupResult{Dialed: 18, Failed: 1})
+		So(dialed, ShouldHaveLength, 19)
+		So(dialed[local], ShouldEqual, 0)
+		So(dialed[peers.Servers[2]], ShouldEqual, 1)
+		So(atomic.LoadInt32(&maxSeen), ShouldBeLessThanOrEqualTo, 4)
+	})
+	Convey("a dead server fails after the timeout", t, func() {
+		warmupDial = func(ctx context.Context, id proto.NodeID) error {
+			<-ctx.Done()
+			return ctx.Err()
+		}
+		start := time.Now()
+		result := warmupPeers(context.Background(), conf.WarmupInfo{Timeout: 50 * time.Millisecond}, peers, local)
+		So(result, ShouldResemble, warmupResult{Failed: 19})
+		// the default concurrency dials them all at once
+		So(time.Since(start), ShouldBeLessThan, 2*time.Second)
+	})
+	Convey("the warm-up can be disabled", t, func() {
+		warmupDial = func(ctx context.Context, id proto.NodeID) error {
+			panic("unexpected dial")
+		}
+		So(warmupPeers(context.Background(), conf.WarmupInfo{Disabled: true}, peers, local), ShouldResemble, warmupResult{})
+		So(warmupPeers(context.Background(), conf.WarmupInfo{}, nil, local), ShouldResemble, warmupResult{})
+	})
+}
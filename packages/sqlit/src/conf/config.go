@@ -6,6 +6,7 @@ import (
 	"path"
 	"time"
 
+	"github.com/pkg/errors"
 	yaml "gopkg.in/yaml.v2"
 
 	"sqlit/src/crypto"
@@ -27,6 +28,11 @@ const (
 // StartSucceedMessage is printed when SQLIT started successfully.
 const StartSucceedMessage = "SQLIT Started Successfully"
 
+// StdinPrivateKeyFile is the PrivateKeyFile to read the private key from stdin, it
+// is never written to disk. The default LocalNonceFile is then "private.key.nonce"
+// in the config dir.
+const StdinPrivateKeyFile = "-"
+
 // RoleTag indicate which role the daemon is playing.
 var RoleTag = UnknownBuildTag
 
@@ -87,6 +93,158 @@ type DNSSeed struct {
 	DNSServers     []string `yaml:"DNSServers"`
 	Domain         string   `yaml:"Domain"`
 	BPCount        int      `yaml:"BPCount"`
+	// Seeds are the "host:port" seeds to bootstrap nodes from, the A/AAAA records of
+	// host are the node address candidates
+	Seeds []string `yaml:"Seeds,omitempty"`
+}
+
+// PKCS11Info defines the PKCS#11 token holding the local private key.
+type PKCS11Info struct {
+	// ModulePath is the path of PKCS#11 module shared library
+	ModulePath string `yaml:"ModulePath"`
+	// Slot is the token slot id
+	Slot uint `yaml:"Slot"`
+	// PIN is the user pin of the token
+	PIN string `yaml:"PIN,omitempty" log:"redact"`
+	// KeyLabel is the CKA_LABEL of the key pair on the token
+	KeyLabel string `yaml:"KeyLabel"`
+}
+
+// KeyProviderInfo defines which provider holds the local private key.
+type KeyProviderInfo struct {
+	// Type is "file" (default) or "pkcs11"
+	Type   string      `yaml:"Type"`
+	PKCS11 *PKCS11Info `yaml:"PKCS11,omitempty"`
+}
+
+// LogInfo defines the log file, its rotation and the module log levels.
+type LogInfo struct {
+	// File is the log file path, the log goes to stderr if empty
+	File string `yaml:"File"`
+	// MaxSize is the file size in megabytes triggering a rotation, 0 for no limit
+	MaxSize int64 `yaml:"MaxSize,omitempty"`
+	// MaxAge is the time since the file is opened triggering a rotation, 0 for no limit
+	MaxAge time.Duration `yaml:"MaxAge,omitempty"`
+	// MaxBackups is the number of the rotated files kept, 0 keeps all of them
+	MaxBackups int `yaml:"MaxBackups,omitempty"`
+	// Compress gzips the rotated files
+	Compress bool `yaml:"Compress,omitempty"`
+	// Levels is the log levels of the modules, e.g. "route: debug", the other modules
+	// log at the global level
+	Levels map[string]string `yaml:"Levels,omitempty"`
+	// SignalModule is the module whose level is raised by SIGUSR1 and lowered by
+	// SIGUSR2, the signals step the global level if empty
+	SignalModule string `yaml:"SignalModule,omitempty"`
+	// ErrorStackLevel is the least verbose log level rendering the stack traces of
+	// the logged errors, default is "debug"
+	ErrorStackLevel string `yaml:"ErrorStackLevel,omitempty"`
+	// Syslog ships the log to a syslog endpoint
+	Syslog *SyslogInfo `yaml:"Syslog,omitempty"`
+	// RedactPublicKeys logs the public keys as fingerprints, the private keys and
+	// the other secrets are always redacted
+	RedactPublicKeys bool `yaml:"RedactPublicKeys,omitempty"`
+}
+
+// SyslogInfo defines the syslog endpoint of the log.
+type SyslogInfo struct {
+	// Network is "udp", "tcp", "unix" or "unixgram"
+	Network string `yaml:"Network"`
+	// Address is the endpoint address, e.g. "127.0.0.1:514"
+	Address string `yaml:"Address"`
+	// Facility is the syslog facility name, default is "daemon"
+	Facility string `yaml:"Facility,omitempty"`
+	// Tag is the APP-NAME of the records, default is the program name
+	Tag string `yaml:"Tag,omitempty"`
+	// Exclusive sends the log to syslog only, the local output is the fallback when
+	// syslog is unreachable
+	Exclusive bool `yaml:"Exclusive,omitempty"`
+}
+
+// SyslogConfig returns the log.SyslogConfig of the syslog endpoint.
+func (s *SyslogInfo) SyslogConfig() log.SyslogConfig {
+	return log.SyslogConfig{
+		Network:   s.Network,
+		Address:   s.Address,
+		Facility:  s.Facility,
+		Tag:       s.Tag,
+		Exclusive: s.Exclusive,
+	}
+}
+
+// RotateConfig returns the log.RotateConfig of the log file.
+func (l *LogInfo) RotateConfig() log.RotateConfig {
+	return log.RotateConfig{
+		Filename:   l.File,
+		MaxSize:    l.MaxSize << 20,
+		MaxAge:     l.MaxAge,
+		MaxBackups: l.MaxBackups,
+		Compress:   l.Compress,
+	}
+}
+
+// TLSInfo configures the TLS between nodes. The certificate is bound to the node
+// identity and verified against the public keystore, no CA is involved.
+type TLSInfo struct {
+	// CertFile and KeyFile are the PEM certificate and key of the node written by
+	// kms.WriteNodeCertificate
+	CertFile string `yaml:"CertFile,omitempty"`
+	KeyFile  string `yaml:"KeyFile,omitempty"`
+	// DeriveFromNodeKey issues the certificate with the node key on startup instead
+	// of loading CertFile and KeyFile
+	DeriveFromNodeKey bool `yaml:"DeriveFromNodeKey,omitempty"`
+}
+
+// RouteProbeInfo configures the liveness probing of the cached node addresses, the
+// zero fields take the route defaults.
+type RouteProbeInfo struct {
+	// Interval is the period of the probe rounds
+	Interval time.Duration `yaml:"Interval,omitempty"`
+	// Timeout bounds the probe of an address
+	Timeout time.Duration `yaml:"Timeout,omitempty"`
+	// FailureThreshold is the failed probes in a row marking an address unreachable
+	FailureThreshold int `yaml:"FailureThreshold,omitempty"`
+	// RecentUse is how long a node is probed after its addresses are last used
+	RecentUse time.Duration `yaml:"RecentUse,omitempty"`
+	// StaleAfter is how long after the last successful rpc a node is degraded
+	StaleAfter time.Duration `yaml:"StaleAfter,omitempty"`
+	// DeadAfter is how long after the last successful rpc a node is dead
+	DeadAfter time.Duration `yaml:"DeadAfter,omitempty"`
+}
+
+// HostCacheInfo configures the cache of the IPs resolved for the host names in the
+// node addresses, the zero fields take the route defaults.
+type HostCacheInfo struct {
+	// DefaultTTL is the TTL of the IPs if the DNS records report none
+	DefaultTTL time.Duration `yaml:"DefaultTTL,omitempty"`
+	// MinTTL and MaxTTL bound the TTL of the DNS records
+	MinTTL time.Duration `yaml:"MinTTL,omitempty"`
+	MaxTTL time.Duration `yaml:"MaxTTL,omitempty"`
+	// RefreshInterval is the period of refreshing the IPs close to expiry
+	RefreshInterval time.Duration `yaml:"RefreshInterval,omitempty"`
+	// Idle is how long a host is kept refreshed after it is last dialed
+	Idle time.Duration `yaml:"Idle,omitempty"`
+}
+
+// AddrCheckInfo configures the startup check dialing the addresses of the known
+// nodes, so a mistyped address is found before the requests fail.
+type AddrCheckInfo struct {
+	// Timeout bounds the dial of an address
+	Timeout time.Duration `yaml:"Timeout,omitempty"`
+	// FailOnUnreachable fails the startup on an unreachable address, instead of
+	// logging it only
+	FailOnUnreachable bool `yaml:"FailOnUnreachable,omitempty"`
+}
+
+// WarmupInfo configures the pre-dial of the peers servers after the node is
+// initialized, so the first requests do not pay the connection setup. The zero fields
+// take the defaults.
+type WarmupInfo struct {
+	// Disabled skips the warm-up, e.g. where the early dials are undesirable
+	Disabled bool `yaml:"Disabled,omitempty"`
+	// Timeout bounds the dial of a server
+	Timeout time.Duration `yaml:"Timeout,omitempty"`
+	// Concurrency bounds the concurrent dials
+	Concurrency int `yaml:"Concurrency,omitempty"`
 }
 
 // Config holds all the config read from yaml config file.
@@ -99,7 +257,9 @@ type Config struct {
 	//TODO(auxten): set yaml key for config
 	WorkingRoot        string            `yaml:"WorkingRoot"`
 	PubKeyStoreFile    string            `yaml:"PubKeyStoreFile"`
+	PubKeyStoreBackend string            `yaml:"PubKeyStoreBackend,omitempty"` // sqlite by default or bolt
 	PrivateKeyFile     string            `yaml:"PrivateKeyFile"`
+	KeyProvider        *KeyProviderInfo  `yaml:"KeyProvider,omitempty"`
 	WalletAddress      string            `yaml:"WalletAddress"`
 	DHTFileName        string            `yaml:"DHTFileName"`
 	ListenAddr         string            `yaml:"ListenAddr"`
@@ -107,8 +267,18 @@ type Config struct {
 	ExternalListenAddr string            `yaml:"-"` // for metric purpose
 	ThisNodeID         proto.NodeID      `yaml:"ThisNodeID"`
 	ValidDNSKeys       map[string]string `yaml:"ValidDNSKeys"` // map[DNSKEY]domain
+
+	// PrivateKeyPassphraseFile is the file holding the private key passphrase
+	PrivateKeyPassphraseFile string `yaml:"PrivateKeyPassphraseFile,omitempty"`
+
 	// Check By BP DHT.Ping
 	MinNodeIDDifficulty int `yaml:"MinNodeIDDifficulty"`
+	// SkipNodeIDVerify accepts known nodes whose id is not the hash of their public
+	// key and nonce, for legacy configs only
+	SkipNodeIDVerify bool `yaml:"SkipNodeIDVerify,omitempty"`
+	// AdminNodes are the nodes permitted to call the admin RPC of sqlitd, e.g. to
+	// reload the config, it is disabled if empty.
+	AdminNodes []proto.NodeID `yaml:"AdminNodes,omitempty"`
 
 	DNSSeed DNSSeed `yaml:"DNSSeed"`
 
@@ -117,6 +287,12 @@ type Config struct {
 
 	KnownNodes  []proto.Node `yaml:"KnownNodes"`
 	SeedBPNodes []proto.Node `yaml:"-"`
+	// DefaultKnownNodeRole is the role of the known nodes given without one, default
+	// is DefaultNodeRole. The block producer entry is a Leader without one.
+	DefaultKnownNodeRole proto.ServerRole `yaml:"DefaultKnownNodeRole,omitempty"`
+	// MaxPeersServers bounds the servers of the signed peers, default is
+	// proto.DefaultMaxServers.
+	MaxPeersServers int `yaml:"MaxPeersServers,omitempty"`
 
 	QPS                uint32        `yaml:"QPS"`
 	ChainBusPeriod     time.Duration `yaml:"ChainBusPeriod"`
@@ -127,18 +303,155 @@ type Config struct {
 	SQLChainTick       time.Duration `yaml:"SQLChainTick"`
 	SQLChainTTL        int32         `yaml:"SQLChainTTL"`
 	MinProviderDeposit uint64        `yaml:"MinProviderDeposit"`
+
+	// KeyRotationGracePeriod defines how long the previous local public key stays
+	// in the public keystore after a private key rotation.
+	KeyRotationGracePeriod time.Duration `yaml:"KeyRotationGracePeriod,omitempty"`
+
+	// RouteCacheFile persists the node address cache across restarts, default is
+	// DHTFileName with ".route" suffix.
+	RouteCacheFile string `yaml:"RouteCacheFile,omitempty"`
+	// TLS enables the TLS between nodes keyed to the node identities, it is disabled
+	// if nil.
+	TLS *TLSInfo `yaml:"TLS,omitempty"`
+	// RouteProbe enables probing the cached addresses of the recently used nodes, it
+	// is disabled if nil.
+	RouteProbe *RouteProbeInfo `yaml:"RouteProbe,omitempty"`
+	// HostCache configures the cache of the IPs resolved for the host names in the
+	// node addresses, the route defaults are used if nil.
+	HostCache *HostCacheInfo `yaml:"HostCache,omitempty"`
+	// AddrCheck enables dialing the known node addresses once at startup, it is
+	// disabled if nil.
+	AddrCheck *AddrCheckInfo `yaml:"AddrCheck,omitempty"`
+	// Warmup configures the pre-dial of the peers servers at startup, the defaults
+	// are used if nil.
+	Warmup *WarmupInfo `yaml:"Warmup,omitempty"`
+	// PeersFile persists the signed peers list of the last term on shutdown, default
+	// is DHTFileName with ".peers" suffix.
+	PeersFile string `yaml:"PeersFile,omitempty"`
+	// RevokedFile persists the node ids of the revoked keys, default is
+	// PubKeyStoreFile with ".revoked" suffix.
+	RevokedFile string `yaml:"RevokedFile,omitempty"`
+	// ShutdownTimeout bounds the graceful shutdown of the node, it is force exited if
+	// the shutdown takes longer.
+	ShutdownTimeout time.Duration `yaml:"ShutdownTimeout,omitempty"`
+	// HealthAddr is the listen address of the /healthz and /readyz HTTP endpoints,
+	// they are disabled if empty.
+	HealthAddr string `yaml:"HealthAddr,omitempty"`
+	// MetricsAddr is the listen address of the prometheus /metrics HTTP endpoint, it
+	// is disabled if empty.
+	MetricsAddr string `yaml:"MetricsAddr,omitempty"`
+
+	// LocalNonceFile persists the last message nonce of the local node, default is
+	// PrivateKeyFile with ".nonce" suffix.
+	LocalNonceFile string `yaml:"LocalNonceFile,omitempty"`
+	// LocalNonce is the last message nonce known to be used by the local node, the
+	// node refuses to start if LocalNonceFile holds a lower one.
+	LocalNonce proto.Nonce `yaml:"LocalNonce,omitempty"`
+
+	// Log is the log file of the node, the log goes to stderr if nil
+	Log *LogInfo `yaml:"Log,omitempty"`
 }
 
 // GConf is the global config pointer.
 var GConf *Config
 
-// LoadConfig loads config from configPath.
+// KnownNodesWarnOnly logs the invalid known nodes instead of failing LoadConfig, it is
+// for migrating the configs with duplicate or mismatched entries.
+var KnownNodesWarnOnly bool
+
+var (
+	// ErrDuplicateNodeID indicates a known node ID appears more than once
+	ErrDuplicateNodeID = errors.New("duplicate known node id")
+	// ErrDuplicateNodeAddr indicates a known node address is used by more than one node
+	ErrDuplicateNodeAddr = errors.New("duplicate known node addr")
+	// ErrNodeIDNotMatchKey indicates a known node ID is not derived from its public key
+	ErrNodeIDNotMatchKey = errors.New("known node id does not match public key")
+)
+
+// LoadConfig loads config from configPath, the fields listed in envOverrides are
+// overridden by the SQLIT_ environment variables. The file is YAML whatever the
+// extension is, anchors and aliases can be used for the repeated node blocks and a
+// JSON file is loaded the same as it is valid YAML. The files listed by `include:`
+// are merged first, see readConfigFile for the merge rules. The known nodes without Role or
+// address port get the default role of applySchemaDefaults and DefaultPort, the node
+// required fields are checked by Config.Validate.
 func LoadConfig(configPath string) (config *Config, err error) {
-	configBytes, err := os.ReadFile(configPath)
+	configBytes, err := readConfigFile(configPath)
 	if err != nil {
 		log.WithError(err).Error("read config file failed")
 		return
 	}
+	return loadConfigBytes(configBytes, configPath)
+}
+
+// configPath is a path field of Config, a relative one is relative to the config
+// file setting it.
+type configPath struct {
+	// keys are the config keys of the field from the top of the config tree
+	keys []string
+	// field returns the field in config, nil if its section is not set
+	field func(config *Config) *string
+	// orEmpty resolves an empty value to the config directory as well
+	orEmpty bool
+}
+
+// configPaths are the path fields resolved by loadConfigBytes and, per config file,
+// by resolveConfigPaths.
+var configPaths = []configPath{
+	{keys: []string{"WorkingRoot"}, field: func(c *Config) *string { return &c.WorkingRoot }},
+	{keys: []string{"PubKeyStoreFile"}, field: func(c *Config) *string { return &c.PubKeyStoreFile }},
+	{keys: []string{"PrivateKeyFile"}, field: func(c *Config) *string { return &c.PrivateKeyFile }},
+	{keys: []string{"PrivateKeyPassphraseFile"}, field: func(c *Config) *string { return &c.PrivateKeyPassphraseFile }},
+	{keys: []string{"LocalNonceFile"}, field: func(c *Config) *string { return &c.LocalNonceFile }},
+	{keys: []string{"DHTFileName"}, field: func(c *Config) *string { return &c.DHTFileName }},
+	{keys: []string{"RouteCacheFile"}, field: func(c *Config) *string { return &c.RouteCacheFile }},
+	{keys: []string{"PeersFile"}, field: func(c *Config) *string { return &c.PeersFile }},
+	{keys: []string{"RevokedFile"}, field: func(c *Config) *string { return &c.RevokedFile }},
+	{keys: []string{"Log", "File"}, field: func(c *Config) *string {
+		if c.Log == nil {
+			return nil
+		}
+		return &c.Log.File
+	}},
+	{keys: []string{"TLS", "CertFile"}, field: func(c *Config) *string {
+		if c.TLS == nil {
+			return nil
+		}
+		return &c.TLS.CertFile
+	}},
+	{keys: []string{"TLS", "KeyFile"}, field: func(c *Config) *string {
+		if c.TLS == nil {
+			return nil
+		}
+		return &c.TLS.KeyFile
+	}},
+	{keys: []string{"BlockProducer", "ChainFileName"}, orEmpty: true, field: func(c *Config) *string {
+		if c.BP == nil {
+			return nil
+		}
+		return &c.BP.ChainFileName
+	}},
+	{keys: []string{"Miner", "RootDir"}, orEmpty: true, field: func(c *Config) *string {
+		if c.Miner == nil {
+			return nil
+		}
+		return &c.Miner.RootDir
+	}},
+}
+
+// isRelative returns if value of p is resolved against the config directory,
+// StdinPrivateKeyFile is never a path.
+func (p *configPath) isRelative(value string) bool {
+	if value == "" {
+		return p.orEmpty
+	}
+	return value != StdinPrivateKeyFile && !path.IsAbs(value)
+}
+
+// loadConfigBytes loads the merged YAML of the config files, the relative paths are
+// relative to the directory of configPath.
+func loadConfigBytes(configBytes []byte, configPath string) (config *Config, err error) {
 	config = &Config{}
 	err = yaml.Unmarshal(configBytes, config)
 	if err != nil {
@@ -146,6 +459,20 @@ func LoadConfig(configPath string) (config *Config, err error) {
 		return
 	}
 
+	// environment variables take precedence over the file
+	if err = applyEnvOverrides(config, os.LookupEnv); err != nil {
+		log.WithError(err).Error("apply environment variables failed")
+		return
+	}
+
+	// schema defaults go first so the defaulted addresses are validated as well
+	applySchemaDefaults(config)
+
+	if err = validateNodeIDs(config); err != nil {
+		log.WithError(err).Error("validate config node ids failed")
+		return
+	}
+
 	if config.BPPeriod == time.Duration(0) {
 		config.BPPeriod = 10 * time.Second
 	}
@@ -166,28 +493,36 @@ func LoadConfig(configPath string) (config *Config, err error) {
 	}
 
 	configDir := path.Dir(configPath)
-	if !path.IsAbs(config.PubKeyStoreFile) {
-		config.PubKeyStoreFile = path.Join(configDir, config.PubKeyStoreFile)
+	for i := range configPaths {
+		if field := configPaths[i].field(config); field != nil && configPaths[i].isRelative(*field) {
+			*field = path.Join(configDir, *field)
+		}
 	}
 
-	if !path.IsAbs(config.PrivateKeyFile) {
-		config.PrivateKeyFile = path.Join(configDir, config.PrivateKeyFile)
+	// the defaults derived from the resolved paths
+	if config.LocalNonceFile == "" && config.PrivateKeyFile == StdinPrivateKeyFile {
+		config.LocalNonceFile = path.Join(configDir, "private.key.nonce")
+	} else if config.LocalNonceFile == "" {
+		config.LocalNonceFile = config.PrivateKeyFile + ".nonce"
 	}
-
-	if !path.IsAbs(config.DHTFileName) {
-		config.DHTFileName = path.Join(configDir, config.DHTFileName)
+	if config.RouteCacheFile == "" {
+		config.RouteCacheFile = config.DHTFileName + ".route"
 	}
-
-	if !path.IsAbs(config.WorkingRoot) {
-		config.WorkingRoot = path.Join(configDir, config.WorkingRoot)
+	if config.PeersFile == "" {
+		config.PeersFile = config.DHTFileName + ".peers"
 	}
-
-	if config.BP != nil && !path.IsAbs(config.BP.ChainFileName) {
-		config.BP.ChainFileName = path.Join(configDir, config.BP.ChainFileName)
+	if config.RevokedFile == "" {
+		config.RevokedFile = config.PubKeyStoreFile + ".revoked"
 	}
 
-	if config.Miner != nil && !path.IsAbs(config.Miner.RootDir) {
-		config.Miner.RootDir = path.Join(configDir, config.Miner.RootDir)
+	if config.Log != nil {
+		for module, level := range config.Log.Levels {
+			if _, err = log.ParseLevel(level); err != nil {
+				err = errors.Wrapf(err, "invalid log level of module %s", module)
+				log.WithError(err).Error("validate config log levels failed")
+				return
+			}
+		}
 	}
 
 	if len(config.KnownNodes) > 0 {
@@ -214,3 +549,68 @@ func LoadConfig(configPath string) (config *Config, err error) {
 
 	return
 }
+
+// validateNodeIDs rejects malformed node ids of config before they are used to route.
+func validateNodeIDs(config *Config) (err error) {
+	if config.ThisNodeID != "" {
+		if err = config.ThisNodeID.Validate(); err != nil {
+			return errors.Wrapf(err, "invalid ThisNodeID %q", config.ThisNodeID)
+		}
+	}
+	if config.BP != nil && config.BP.NodeID != "" {
+		if err = config.BP.NodeID.Validate(); err != nil {
+			return errors.Wrapf(err, "invalid BlockProducer NodeID %q", config.BP.NodeID)
+		}
+	}
+	for i, node := range config.KnownNodes {
+		if err = node.ID.Validate(); err != nil {
+			return errors.Wrapf(err, "invalid ID %q of KnownNodes[%d]", node.ID, i)
+		}
+	}
+	return validateKnownNodes(config)
+}
+
+// validateKnownNodes rejects the known nodes with duplicate ID, duplicate address or
+// ID not derived from its public key and nonce, nodes without public key are not
+// checked for the ID. With KnownNodesWarnOnly the problems are logged only.
+func validateKnownNodes(config *Config) (err error) {
+	var (
+		ids   = make(map[proto.NodeID]int, len(config.KnownNodes))
+		addrs = make(map[string]int, len(config.KnownNodes))
+		errs  []error
+	)
+	for i := range config.KnownNodes {
+		node := &config.KnownNodes[i]
+		if j, ok := ids[node.ID]; ok {
+			errs = append(errs, errors.Wrapf(ErrDuplicateNodeID,
+				"KnownNodes[%d] ID %s duplicates KnownNodes[%d]", i, node.ID, j))
+		} else {
+			ids[node.ID] = i
+		}
+		for _, addr := range node.AddrCandidates() {
+			if j, ok := addrs[addr]; ok {
+				errs = append(errs, errors.Wrapf(ErrDuplicateNodeAddr,
+					"KnownNodes[%d] addr %s duplicates KnownNodes[%d]", i, addr, j))
+			} else {
+				addrs[addr] = i
+			}
+		}
+		if key, keyErr := node.TypedPublicKey(); keyErr == nil {
+			derived, deriveErr := proto.DeriveNodeID(key, node.Nonce)
+			if deriveErr == nil && derived != node.ID {
+				errs = append(errs, errors.Wrapf(ErrNodeIDNotMatchKey,
+					"KnownNodes[%d] ID %s, derived %s", i, node.ID, derived))
+			}
+		}
+	}
+	if len(errs) == 0 {
+		return
+	}
+	if KnownNodesWarnOnly {
+		for _, e := range errs {
+			log.WithError(e).Warning("invalid known node")
+		}
+		return
+	}
+	return errs[0]
+}
@@ -2,11 +2,14 @@
 package conf
 
 import (
+	"bytes"
 	"encoding/hex"
 	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
+	"github.com/pkg/errors"
 	. "github.com/smartystreets/goconvey/convey"
 	yaml "gopkg.in/yaml.v2"
 
@@ -142,5 +145,355 @@ func TestConf(t *testing.T) {
 		os.WriteFile(testFile, []byte("xx:1"), 0600)
 		_, err = LoadConfig(testFile)
 		So(err, ShouldNotBeNil)
+
+		// malformed node ids are rejected early
+		for _, invalid := range []func(c *Config){
+			func(c *Config) { c.ThisNodeID = "not a node id" },
+			func(c *Config) { c.BP.NodeID = c.BP.NodeID[1:] },
+			func(c *Config) { c.KnownNodes[2].ID = "" },
+			func(c *Config) { c.KnownNodes[3].ID = "X" + c.KnownNodes[3].ID[1:] },
+		} {
+			copied := *config
+			copiedBP := *config.BP
+			copied.BP = &copiedBP
+			copied.KnownNodes = append([]proto.Node(nil), config.KnownNodes...)
+			invalid(&copied)
+			sConfig, err = yaml.Marshal(&copied)
+			So(err, ShouldBeNil)
+			So(os.WriteFile(testFile, sConfig, 0600), ShouldBeNil)
+			_, err = LoadConfig(testFile)
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "invalid")
+		}
+	})
+}
+
+func TestValidateKnownNodes(t *testing.T) {
+	Convey("known nodes are checked for duplicates and key mismatch", t, func() {
+		_, pub, err := asymmetric.GenSecp256k1KeyPair()
+		So(err, ShouldBeNil)
+		nonce := cpuminer.Uint256{A: 7}
+		derived, err := proto.DeriveNodeID(pub, nonce)
+		So(err, ShouldBeNil)
+		var (
+			n1 = proto.NodeID("0000000000000000000000000000000000000000000000000000000000000001")
+			n2 = proto.NodeID("0000000000000000000000000000000000000000000000000000000000000002")
+		)
+		valid := []proto.Node{
+			{ID: n1, Addr: "a:1", Addrs: []string{"a:1", "b:1"}},
+			{ID: derived, Addr: "a:2", PublicKey: pub, Nonce: nonce},
+			{ID: n2},
+		}
+		So(validateKnownNodes(&Config{KnownNodes: valid}), ShouldBeNil)
+
+		for _, c := range []struct {
+			nodes []proto.Node
+			cause error
+			index string
+		}{
+			{append(valid, proto.Node{ID: n1, Addr: "c:1"}), ErrDuplicateNodeID, "KnownNodes[3]"},
+			{append(valid, proto.Node{ID: n2, Addr: "c:2"}), ErrDuplicateNodeID, "KnownNodes[3]"},
+			{append(valid, proto.Node{ID: "00000000000000000000000000000000000000000000000000000000000000ff",
+				Addr: "c:1", Addrs: []string{"b:1"}}), ErrDuplicateNodeAddr, "KnownNodes[3]"},
+			{append(valid, proto.Node{ID: n1[:63] + "f", Addr: "c:1", PublicKey: pub}),
+				ErrNodeIDNotMatchKey, "KnownNodes[3]"},
+		} {
+			err = validateKnownNodes(&Config{KnownNodes: c.nodes})
+			So(errors.Cause(err), ShouldEqual, c.cause)
+			So(err.Error(), ShouldContainSubstring, c.index)
+
+			KnownNodesWarnOnly = true
+			So(validateKnownNodes(&Config{KnownNodes: c.nodes}), ShouldBeNil)
+			KnownNodesWarnOnly = false
+		}
+	})
+}
+
+func TestLoadConfigFormats(t *testing.T) {
+	Convey("yaml with anchors and json load the same config", t, func() {
+		const anchored = `
+ThisNodeID: "0000000000000000000000000000000000000000000000000000000000000001"
+ListenAddr: "127.0.0.1:4661"
+BlockProducer:
+  NodeID: "0000000000000000000000000000000000000000000000000000000000000001"
+  ChainFileName: "chain.db"
+bpNode: &bp
+  Role: Follower
+  Nonce: &nonce
+    a: 1
+    b: 2
+    c: 3
+    d: 4
+KnownNodes:
+- <<: *bp
+  ID: "0000000000000000000000000000000000000000000000000000000000000001"
+  Role: Leader
+  Addr: "127.0.0.1:4661"
+- <<: *bp
+  ID: "0000000000000000000000000000000000000000000000000000000000000002"
+  Addr: "127.0.0.1:4662"
+  Addrs: ["10.0.0.2:4662"]
+- ID: "0000000000000000000000000000000000000000000000000000000000000003"
+  Role: Miner
+  Nonce: *nonce
+  Addr: "127.0.0.1:4663"
+  Tags: {owner: ops}
+  Capabilities: [tls, peersv2, teleport]
+`
+		const plain = `{
+  "ThisNodeID": "0000000000000000000000000000000000000000000000000000000000000001",
+  "ListenAddr": "127.0.0.1:4661",
+  "BlockProducer": {
+    "NodeID": "0000000000000000000000000000000000000000000000000000000000000001",
+    "ChainFileName": "chain.db"
+  },
+  "KnownNodes": [
+    {"ID": "0000000000000000000000000000000000000000000000000000000000000001", "Role": "Leader",
+     "Nonce": {"a": 1, "b": 2, "c": 3, "d": 4}, "Addr": "127.0.0.1:4661"},
+    {"ID": "0000000000000000000000000000000000000000000000000000000000000002", "Role": "Follower",
+     "Nonce": {"a": 1, "b": 2, "c": 3, "d": 4}, "Addr": "127.0.0.1:4662", "Addrs": ["10.0.0.2:4662"]},
+    {"ID": "0000000000000000000000000000000000000000000000000000000000000003", "Role": "Miner",
+     "Nonce": {"a": 1, "b": 2, "c": 3, "d": 4}, "Addr": "127.0.0.1:4663", "Tags": {"owner": "ops"},
+     "Capabilities": ["peersv2", "tls"]}
+  ]
+}`
+		dir := t.TempDir()
+		yamlFile, jsonFile := filepath.Join(dir, "config.yaml"), filepath.Join(dir, "config.json")
+		So(os.WriteFile(yamlFile, []byte(anchored), 0600), ShouldBeNil)
+		So(os.WriteFile(jsonFile, []byte(plain), 0600), ShouldBeNil)
+
+		fromYAML, err := LoadConfig(yamlFile)
+		So(err, ShouldBeNil)
+		fromJSON, err := LoadConfig(jsonFile)
+		So(err, ShouldBeNil)
+		So(fromYAML, ShouldResemble, fromJSON)
+		So(fromYAML.KnownNodes, ShouldHaveLength, 3)
+		So(fromYAML.KnownNodes[0].Role, ShouldEqual, proto.Leader)
+		So(fromYAML.KnownNodes[1].Role, ShouldEqual, proto.Follower)
+		So(fromYAML.KnownNodes[2].Nonce, ShouldResemble, cpuminer.Uint256{A: 1, B: 2, C: 3, D: 4})
+		So(fromYAML.KnownNodes[2].Tags, ShouldResemble, map[string]string{"owner": "ops"})
+		// the unknown capabilities of newer configs are ignored
+		So(fromYAML.KnownNodes[2].Capabilities, ShouldEqual, proto.CapTLS|proto.CapPeersV2)
+
+		// the loaded config marshals back to an equal config
+		out, err := yaml.Marshal(fromYAML)
+		So(err, ShouldBeNil)
+		var again Config
+		So(yaml.Unmarshal(out, &again), ShouldBeNil)
+		So(again.KnownNodes, ShouldResemble, fromYAML.KnownNodes)
+	})
+}
+
+func TestConfigSchema(t *testing.T) {
+	Convey("schema defaults fill the node roles and address ports", t, func() {
+		config := &Config{KnownNodes: []proto.Node{
+			{ID: "a", Addr: "10.0.0.1", Addrs: []string{"node-a:4700", "node-a"}},
+			{ID: "b", Role: proto.Leader, Addr: "[::1]:4662"},
+			{ID: "c", Addr: "::1"},
+		}}
+		applySchemaDefaults(config)
+		So(config.KnownNodes[0].Role, ShouldEqual, DefaultNodeRole)
+		So(config.KnownNodes[0].Addr, ShouldEqual, "10.0.0.1:4661")
+		So(config.KnownNodes[0].Addrs, ShouldResemble, []string{"node-a:4700", "node-a:4661"})
+		So(config.KnownNodes[1].Role, ShouldEqual, proto.Leader)
+		So(config.KnownNodes[1].Addr, ShouldEqual, "[::1]:4662")
+		// ambiguous addresses are left to fail on dial
+		So(config.KnownNodes[2].Addr, ShouldEqual, "::1")
+
+		for _, c := range []struct {
+			addr     string
+			expected string
+		}{
+			{"", ""},
+			{"node-a", "node-a:4661"},
+			{"10.0.0.1:4700", "10.0.0.1:4700"},
+			{"[::1]", "[::1]:4661"},
+			{"[::1]:4662", "[::1]:4662"},
+			{"[fe80::1%eth0]", "[fe80::1%eth0]:4661"},
+			{"[fe80::1%eth0]:4662", "[fe80::1%eth0]:4662"},
+		} {
+			So(withDefaultPort(c.addr), ShouldEqual, c.expected)
+		}
+	})
+	Convey("the known nodes without role get the configured default role", t, func() {
+		var buf bytes.Buffer
+		log.SetOutput(&buf)
+		defer log.SetOutput(os.Stderr)
+		nodes := func() []proto.Node {
+			return []proto.Node{
+				{ID: "a", Addr: "10.0.0.1:4661"},
+				{ID: "b", Addr: "10.0.0.2:4661"},
+				{ID: "c", Role: proto.Observer, Addr: "10.0.0.3:4661"},
+			}
+		}
+		config := &Config{
+			BP:                   &BPInfo{NodeID: "a"},
+			DefaultKnownNodeRole: proto.Follower,
+			KnownNodes:           nodes(),
+		}
+		applySchemaDefaults(config)
+		So(config.KnownNodes[0].Role, ShouldEqual, proto.Leader)
+		So(config.KnownNodes[1].Role, ShouldEqual, proto.Follower)
+		So(config.KnownNodes[2].Role, ShouldEqual, proto.Observer)
+		So(buf.String(), ShouldBeBlank)
+
+		// the defaulted nodes left out of the peers and the observer block producer are warned
+		config = &Config{BP: &BPInfo{NodeID: "c"}, KnownNodes: nodes()}
+		applySchemaDefaults(config)
+		So(config.KnownNodes[1].Role, ShouldEqual, DefaultNodeRole)
+		So(buf.String(), ShouldContainSubstring, "known node has no usable peers role")
+		So(buf.String(), ShouldContainSubstring, "node=a")
+		So(buf.String(), ShouldContainSubstring, "node=b")
+		So(buf.String(), ShouldContainSubstring, "node=c")
+
+		configPath := filepath.Join(t.TempDir(), "config.yaml")
+		So(os.WriteFile(configPath, []byte(`
+DefaultKnownNodeRole: Follower
+KnownNodes:
+- ID: "0000000000000000000000000000000000000000000000000000000000000001"
+  Addr: "10.0.0.1:4661"
+`), 0600), ShouldBeNil)
+		loaded, err := LoadConfig(configPath)
+		So(err, ShouldBeNil)
+		So(loaded.KnownNodes[0].Role, ShouldEqual, proto.Follower)
+	})
+	Convey("validate lists every missing required field", t, func() {
+		config := &Config{
+			BP:         &BPInfo{},
+			KnownNodes: []proto.Node{{ID: "a", Addr: "10.0.0.1:4661"}, {ID: "b"}},
+		}
+		err := config.Validate()
+		So(err, ShouldNotBeNil)
+		verr, ok := err.(*ValidationError)
+		So(ok, ShouldBeTrue)
+		So(verr.Missing, ShouldResemble, []string{
+			"ThisNodeID", "ListenAddr", "BlockProducer.NodeID", "KnownNodes[1].Addr",
+		})
+		So(err.Error(), ShouldContainSubstring, "BlockProducer.NodeID")
+
+		config.ThisNodeID = "c"
+		config.ListenAddr = ":4661"
+		config.BP.NodeID = "d"
+		config.KnownNodes[1].Addr = "10.0.0.2:4661"
+		So(config.Validate().(*ValidationError).Missing, ShouldResemble, []string{
+			"KnownNodes entry of ThisNodeID", "KnownNodes entry of BlockProducer.NodeID",
+		})
+
+		config.ThisNodeID, config.BP.NodeID = "a", "b"
+		So(config.Validate(), ShouldBeNil)
+		So((&Config{}).Validate().(*ValidationError).Missing, ShouldContain, "BlockProducer")
+
+		// the TLS certificate is loaded from files unless derived from the node key
+		config.TLS = &TLSInfo{CertFile: "node.crt"}
+		So(config.Validate().(*ValidationError).Missing, ShouldResemble, []string{"TLS.KeyFile"})
+		config.TLS = &TLSInfo{DeriveFromNodeKey: true}
+		So(config.Validate(), ShouldBeNil)
+	})
+}
+
+func TestLoadConfigInclude(t *testing.T) {
+	Convey("included files are merged under the including file", t, func() {
+		dir := t.TempDir()
+		write := func(name, content string) string {
+			p := filepath.Join(dir, name)
+			So(os.MkdirAll(filepath.Dir(p), 0700), ShouldBeNil)
+			So(os.WriteFile(p, []byte(content), 0600), ShouldBeNil)
+			return p
+		}
+		write("base/nodes.yaml", `
+ListenAddr: "0.0.0.0:4661"
+QPS: 100
+BlockProducer:
+  ChainFileName: "chain.db"
+KnownNodes:
+- ID: "0000000000000000000000000000000000000000000000000000000000000001"
+  Role: Leader
+  Addr: "10.0.0.1:4661"
+`)
+		write("base/limits.yaml", `
+QPS: 200
+`)
+		overlay := write("prod/config.yaml", `
+include:
+- ../base/nodes.yaml
+- ../base/limits.yaml
+ListenAddr: "0.0.0.0:5661"
+BlockProducer:
+  NodeID: "0000000000000000000000000000000000000000000000000000000000000001"
+KnownNodes+:
+- ID: "0000000000000000000000000000000000000000000000000000000000000002"
+  Role: Follower
+  Addr: "10.0.0.2:4661"
+`)
+		config, err := LoadConfig(overlay)
+		So(err, ShouldBeNil)
+		So(config.ListenAddr, ShouldEqual, "0.0.0.0:5661")
+		// the later include overrides the earlier one
+		So(config.QPS, ShouldEqual, 200)
+		// maps are merged key by key
+		So(config.BP.NodeID, ShouldEqual, proto.NodeID("0000000000000000000000000000000000000000000000000000000000000001"))
+		// a relative path is relative to the included file setting it
+		So(config.BP.ChainFileName, ShouldEqual, filepath.Join(dir, "base", "chain.db"))
+		// the "+" list key appends
+		So(config.KnownNodes, ShouldHaveLength, 2)
+		So(config.KnownNodes[1].Role, ShouldEqual, proto.Follower)
+
+		// a plain list key replaces
+		replaced := write("prod/replace.yaml", `
+include: [../base/nodes.yaml]
+KnownNodes:
+- ID: "0000000000000000000000000000000000000000000000000000000000000003"
+  Addr: "10.0.0.3:4661"
+`)
+		config, err = LoadConfig(replaced)
+		So(err, ShouldBeNil)
+		So(config.KnownNodes, ShouldHaveLength, 1)
+		So(config.KnownNodes[0].Addr, ShouldEqual, "10.0.0.3:4661")
+
+		// the same file included twice is not a cycle
+		diamond := write("prod/diamond.yaml", `
+include: [../base/nodes.yaml, ../base/limits.yaml, ../base/nodes.yaml]
+`)
+		config, err = LoadConfig(diamond)
+		So(err, ShouldBeNil)
+		So(config.QPS, ShouldEqual, 100)
+	})
+	Convey("circular and malformed includes are rejected", t, func() {
+		dir := t.TempDir()
+		a, b := filepath.Join(dir, "a.yaml"), filepath.Join(dir, "b.yaml")
+		So(os.WriteFile(a, []byte("include: [b.yaml]\n"), 0600), ShouldBeNil)
+		So(os.WriteFile(b, []byte("include: [a.yaml]\n"), 0600), ShouldBeNil)
+		_, err := LoadConfig(a)
+		So(errors.Cause(err), ShouldEqual, ErrCircularInclude)
+		So(err.Error(), ShouldContainSubstring, "a.yaml -> ")
+
+		self := filepath.Join(dir, "self.yaml")
+		So(os.WriteFile(self, []byte("include: [self.yaml]\n"), 0600), ShouldBeNil)
+		_, err = LoadConfig(self)
+		So(errors.Cause(err), ShouldEqual, ErrCircularInclude)
+
+		bad := filepath.Join(dir, "bad.yaml")
+		So(os.WriteFile(bad, []byte("include: a.yaml\n"), 0600), ShouldBeNil)
+		_, err = LoadConfig(bad)
+		So(errors.Cause(err), ShouldEqual, ErrInvalidInclude)
+
+		missing := filepath.Join(dir, "missing.yaml")
+		So(os.WriteFile(missing, []byte("include: [nowhere.yaml]\n"), 0600), ShouldBeNil)
+		_, err = LoadConfig(missing)
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestLoadConfigStdinPrivateKey(t *testing.T) {
+	Convey("the stdin private key is not resolved against the config dir", t, func() {
+		dir := t.TempDir()
+		configPath := filepath.Join(dir, "config.yaml")
+		So(os.WriteFile(configPath, []byte(`
+PrivateKeyFile: "-"
+`), 0600), ShouldBeNil)
+		config, err := LoadConfig(configPath)
+		So(err, ShouldBeNil)
+		So(config.PrivateKeyFile, ShouldEqual, StdinPrivateKeyFile)
+		So(config.LocalNonceFile, ShouldEqual, filepath.Join(dir, "private.key.nonce"))
 	})
 }
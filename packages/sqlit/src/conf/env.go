@@ -0,0 +1,116 @@
+
+package conf
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"sqlit/src/proto"
+)
+
+// EnvPrefix is the prefix of the environment variables overriding config fields.
+const EnvPrefix = "SQLIT_"
+
+// envOverride maps an environment variable to the config field it overrides.
+type envOverride struct {
+	name  string
+	field func(c *Config) interface{}
+}
+
+// envOverrides is the fields which can be overridden by environment variables. The
+// precedence is file < env < flags: the variables are applied right after the file
+// is parsed, before defaults and path resolution, so relative paths are still
+// relative to the config file. Command line flags, if any, are applied by the
+// command after LoadConfig. Lists are comma separated.
+var envOverrides = []envOverride{
+	{"SQLIT_WORKING_ROOT", func(c *Config) interface{} { return &c.WorkingRoot }},
+	{"SQLIT_PUBKEY_STORE_FILE", func(c *Config) interface{} { return &c.PubKeyStoreFile }},
+	{"SQLIT_PRIVATE_KEY_FILE", func(c *Config) interface{} { return &c.PrivateKeyFile }},
+	{"SQLIT_PRIVATE_KEY_PASSPHRASE_FILE", func(c *Config) interface{} { return &c.PrivateKeyPassphraseFile }},
+	{"SQLIT_WALLET_ADDRESS", func(c *Config) interface{} { return &c.WalletAddress }},
+	{"SQLIT_DHT_FILE_NAME", func(c *Config) interface{} { return &c.DHTFileName }},
+	{"SQLIT_ROUTE_CACHE_FILE", func(c *Config) interface{} { return &c.RouteCacheFile }},
+	{"SQLIT_PEERS_FILE", func(c *Config) interface{} { return &c.PeersFile }},
+	{"SQLIT_REVOKED_FILE", func(c *Config) interface{} { return &c.RevokedFile }},
+	{"SQLIT_LOCAL_NONCE_FILE", func(c *Config) interface{} { return &c.LocalNonceFile }},
+	{"SQLIT_LISTEN_ADDR", func(c *Config) interface{} { return &c.ListenAddr }},
+	{"SQLIT_LISTEN_DIRECT_ADDR", func(c *Config) interface{} { return &c.ListenDirectAddr }},
+	{"SQLIT_HEALTH_ADDR", func(c *Config) interface{} { return &c.HealthAddr }},
+	{"SQLIT_METRICS_ADDR", func(c *Config) interface{} { return &c.MetricsAddr }},
+	{"SQLIT_THIS_NODE_ID", func(c *Config) interface{} { return &c.ThisNodeID }},
+	{"SQLIT_USE_TEST_MASTER_KEY", func(c *Config) interface{} { return &c.UseTestMasterKey }},
+	{"SQLIT_STARTUP_SYNC_HOLES", func(c *Config) interface{} { return &c.StartupSyncHoles }},
+	{"SQLIT_MIN_NODE_ID_DIFFICULTY", func(c *Config) interface{} { return &c.MinNodeIDDifficulty }},
+	{"SQLIT_DNS_SEED_DOMAIN", func(c *Config) interface{} { return &c.DNSSeed.Domain }},
+	{"SQLIT_DNS_SEED_BP_COUNT", func(c *Config) interface{} { return &c.DNSSeed.BPCount }},
+	{"SQLIT_DNS_SEED_SEEDS", func(c *Config) interface{} { return &c.DNSSeed.Seeds }},
+	{"SQLIT_BP_NODEID", func(c *Config) interface{} { return &bpInfo(c).NodeID }},
+	{"SQLIT_BP_CHAIN_FILE_NAME", func(c *Config) interface{} { return &bpInfo(c).ChainFileName }},
+	{"SQLIT_QPS", func(c *Config) interface{} { return &c.QPS }},
+	{"SQLIT_BP_PERIOD", func(c *Config) interface{} { return &c.BPPeriod }},
+	{"SQLIT_BP_TICK", func(c *Config) interface{} { return &c.BPTick }},
+	{"SQLIT_SQLCHAIN_PERIOD", func(c *Config) interface{} { return &c.SQLChainPeriod }},
+	{"SQLIT_SQLCHAIN_TICK", func(c *Config) interface{} { return &c.SQLChainTick }},
+	{"SQLIT_SQLCHAIN_TTL", func(c *Config) interface{} { return &c.SQLChainTTL }},
+	{"SQLIT_KEY_ROTATION_GRACE_PERIOD", func(c *Config) interface{} { return &c.KeyRotationGracePeriod }},
+	{"SQLIT_SHUTDOWN_TIMEOUT", func(c *Config) interface{} { return &c.ShutdownTimeout }},
+}
+
+// bpInfo returns the BlockProducer section of c, it is created if missing.
+func bpInfo(c *Config) *BPInfo {
+	if c.BP == nil {
+		c.BP = &BPInfo{}
+	}
+	return c.BP
+}
+
+// applyEnvOverrides overrides the fields of config by the variables found by lookup.
+// A value not convertible to the field type fails with the variable name.
+func applyEnvOverrides(config *Config, lookup func(key string) (string, bool)) (err error) {
+	for _, o := range envOverrides {
+		value, ok := lookup(o.name)
+		if !ok {
+			continue
+		}
+		if err = setEnvValue(o.field(config), value); err != nil {
+			return errors.Wrapf(err, "invalid environment variable %s=%q", o.name, value)
+		}
+	}
+	return
+}
+
+func setEnvValue(field interface{}, value string) (err error) {
+	switch f := field.(type) {
+	case *string:
+		*f = value
+	case *proto.NodeID:
+		*f = proto.NodeID(value)
+	case *bool:
+		*f, err = strconv.ParseBool(value)
+	case *int:
+		*f, err = strconv.Atoi(value)
+	case *int32:
+		var v int64
+		v, err = strconv.ParseInt(value, 10, 32)
+		*f = int32(v)
+	case *uint32:
+		var v uint64
+		v, err = strconv.ParseUint(value, 10, 32)
+		*f = uint32(v)
+	case *time.Duration:
+		*f, err = time.ParseDuration(value)
+	case *[]string:
+		*f = nil
+		for _, s := range strings.Split(value, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				*f = append(*f, s)
+			}
+		}
+	default:
+		err = errors.Errorf("unsupported field type %T", field)
+	}
+	return
+}
@@ -0,0 +1,82 @@
+
+package conf
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	yaml "gopkg.in/yaml.v2"
+
+	"sqlit/src/proto"
+)
+
+func TestEnvOverrides(t *testing.T) {
+	Convey("environment variables override config fields", t, func() {
+		env := map[string]string{
+			"SQLIT_LISTEN_ADDR":            "0.0.0.0:2120",
+			"SQLIT_BP_NODEID":              "0000000000000000000000000000000000000000000000000000000000000001",
+			"SQLIT_USE_TEST_MASTER_KEY":    "true",
+			"SQLIT_MIN_NODE_ID_DIFFICULTY": "4",
+			"SQLIT_QPS":                    "100",
+			"SQLIT_SQLCHAIN_TTL":           "-1",
+			"SQLIT_BP_PERIOD":              "3s",
+			"SQLIT_DNS_SEED_SEEDS":         "a:1, ,b:2",
+		}
+		lookup := func(key string) (value string, ok bool) {
+			value, ok = env[key]
+			return
+		}
+		config := &Config{ListenAddr: "127.0.0.1:1", WalletAddress: "kept"}
+		So(applyEnvOverrides(config, lookup), ShouldBeNil)
+		So(config.ListenAddr, ShouldEqual, "0.0.0.0:2120")
+		So(config.WalletAddress, ShouldEqual, "kept")
+		So(config.BP, ShouldNotBeNil)
+		So(config.BP.NodeID, ShouldEqual, proto.NodeID(env["SQLIT_BP_NODEID"]))
+		So(config.UseTestMasterKey, ShouldBeTrue)
+		So(config.MinNodeIDDifficulty, ShouldEqual, 4)
+		So(config.QPS, ShouldEqual, 100)
+		So(config.SQLChainTTL, ShouldEqual, -1)
+		So(config.BPPeriod, ShouldEqual, 3*time.Second)
+		So(config.DNSSeed.Seeds, ShouldResemble, []string{"a:1", "b:2"})
+
+		for key, value := range map[string]string{
+			"SQLIT_QPS":                 "-1",
+			"SQLIT_USE_TEST_MASTER_KEY": "maybe",
+			"SQLIT_BP_PERIOD":           "3",
+			"SQLIT_SQLCHAIN_TTL":        "4294967296",
+		} {
+			err := applyEnvOverrides(&Config{}, func(k string) (string, bool) {
+				return value, k == key
+			})
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, key)
+		}
+	})
+
+	Convey("LoadConfig applies env before validation and path resolution", t, func() {
+		dir := t.TempDir()
+		configPath := filepath.Join(dir, "config.yaml")
+		out, err := yaml.Marshal(&Config{ListenAddr: "127.0.0.1:1", DHTFileName: "file.db"})
+		So(err, ShouldBeNil)
+		So(os.WriteFile(configPath, out, 0600), ShouldBeNil)
+
+		t.Setenv("SQLIT_LISTEN_ADDR", "0.0.0.0:2120")
+		t.Setenv("SQLIT_DHT_FILE_NAME", "env.db")
+		config, err := LoadConfig(configPath)
+		So(err, ShouldBeNil)
+		So(config.ListenAddr, ShouldEqual, "0.0.0.0:2120")
+		So(config.DHTFileName, ShouldEqual, filepath.Join(dir, "env.db"))
+
+		t.Setenv("SQLIT_THIS_NODE_ID", "not-a-node-id")
+		_, err = LoadConfig(configPath)
+		So(err, ShouldNotBeNil)
+		t.Setenv("SQLIT_THIS_NODE_ID", "")
+		t.Setenv("SQLIT_BP_TICK", "soon")
+		_, err = LoadConfig(configPath)
+		So(err, ShouldNotBeNil)
+		So(err.Error(), ShouldContainSubstring, "SQLIT_BP_TICK")
+	})
+}
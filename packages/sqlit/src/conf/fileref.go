@@ -0,0 +1,104 @@
+
+package conf
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"sqlit/src/utils/log"
+)
+
+// FileRefSuffix marks a key whose value is read from a file, e.g. `PIN_file: pin.txt`
+// sets PIN to the content of pin.txt.
+const FileRefSuffix = "_file"
+
+var (
+	// ErrFileRefConflict indicates a field is set both inline and by a file reference
+	ErrFileRefConflict = errors.New("field set both inline and by file reference")
+	// ErrInvalidFileRef indicates a file reference is not a file path
+	ErrInvalidFileRef = errors.New("invalid file reference")
+)
+
+// resolveFileRefs replaces the `<key>_file: <path>` entries of the config tree node
+// by `<key>: <content of path>`, at any depth including the list entries. The
+// relative paths are relative to baseDir, the content is a string with the trailing
+// line break trimmed. A missing file fails with the field path, a file readable by
+// others is logged as a warning.
+func resolveFileRefs(node interface{}, baseDir, fieldPath string) (err error) {
+	switch n := node.(type) {
+	case map[interface{}]interface{}:
+		// resolve in key order for deterministic errors and warnings
+		keys := make([]string, 0, len(n))
+		for k := range n {
+			if key, ok := k.(string); ok {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			childPath := joinFieldPath(fieldPath, key)
+			if !strings.HasSuffix(key, FileRefSuffix) || key == FileRefSuffix {
+				if err = resolveFileRefs(n[key], baseDir, childPath); err != nil {
+					return
+				}
+				continue
+			}
+			field := strings.TrimSuffix(key, FileRefSuffix)
+			if _, ok := n[field]; ok {
+				return errors.Wrapf(ErrFileRefConflict, "%s and %s", joinFieldPath(fieldPath, field), childPath)
+			}
+			var content string
+			if content, err = readFileRef(n[key], baseDir, childPath); err != nil {
+				return
+			}
+			delete(n, key)
+			n[field] = content
+		}
+	case []interface{}:
+		for i, v := range n {
+			if err = resolveFileRefs(v, baseDir, fmt.Sprintf("%s[%d]", fieldPath, i)); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+// readFileRef reads the file referenced by value of the field fieldPath.
+func readFileRef(value interface{}, baseDir, fieldPath string) (content string, err error) {
+	refPath, ok := value.(string)
+	if !ok || refPath == "" {
+		return "", errors.Wrapf(ErrInvalidFileRef, "%s: %v is not a file path", fieldPath, value)
+	}
+	if !filepath.IsAbs(refPath) {
+		refPath = filepath.Join(baseDir, refPath)
+	}
+	info, err := os.Stat(refPath)
+	if err != nil {
+		return "", errors.Wrapf(err, "read %s of %s failed", refPath, fieldPath)
+	}
+	if info.Mode().Perm()&0004 != 0 {
+		log.WithFields(log.Fields{
+			"field": fieldPath,
+			"file":  refPath,
+			"mode":  info.Mode().Perm().String(),
+		}).Warning("referenced config file is world readable")
+	}
+	data, err := os.ReadFile(refPath)
+	if err != nil {
+		return "", errors.Wrapf(err, "read %s of %s failed", refPath, fieldPath)
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+func joinFieldPath(parent, key string) string {
+	if parent == "" {
+		return key
+	}
+	return parent + "." + key
+}
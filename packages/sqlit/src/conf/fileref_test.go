@@ -0,0 +1,79 @@
+
+package conf
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+	. "github.com/smartystreets/goconvey/convey"
+
+	"sqlit/src/utils/log"
+)
+
+func TestLoadConfigFileRefs(t *testing.T) {
+	Convey("the fields are read from the referenced files", t, func() {
+		dir := t.TempDir()
+		write := func(name, content string, perm os.FileMode) string {
+			p := filepath.Join(dir, name)
+			So(os.WriteFile(p, []byte(content), perm), ShouldBeNil)
+			So(os.Chmod(p, perm), ShouldBeNil)
+			return p
+		}
+		var buf bytes.Buffer
+		log.SetOutput(&buf)
+		defer log.SetOutput(os.Stderr)
+
+		write("pin.txt", "1234\n", 0600)
+		write("key.path", "/secrets/private.key\n", 0644)
+		write("addr.txt", "10.0.0.1:4661\r\n", 0600)
+		configPath := write("config.yaml", `
+PrivateKeyFile_file: key.path
+KeyProvider:
+  Type: pkcs11
+  PKCS11:
+    PIN_file: pin.txt
+KnownNodes:
+- ID: "0000000000000000000000000000000000000000000000000000000000000001"
+  Addr_file: `+filepath.Join(dir, "addr.txt")+`
+`, 0600)
+		config, err := LoadConfig(configPath)
+		So(err, ShouldBeNil)
+		So(config.PrivateKeyFile, ShouldEqual, "/secrets/private.key")
+		So(config.KeyProvider.PKCS11.PIN, ShouldEqual, "1234")
+		So(config.KnownNodes[0].Addr, ShouldEqual, "10.0.0.1:4661")
+		// only the world readable file is warned
+		So(buf.String(), ShouldContainSubstring, "field=PrivateKeyFile_file")
+		So(buf.String(), ShouldNotContainSubstring, "field=KeyProvider.PKCS11.PIN_file")
+
+		// a missing file fails with the field
+		missing := write("missing.yaml", `
+KeyProvider:
+  PKCS11:
+    PIN_file: nowhere.txt
+`, 0600)
+		_, err = LoadConfig(missing)
+		So(err, ShouldNotBeNil)
+		So(os.IsNotExist(errors.Cause(err)), ShouldBeTrue)
+		So(err.Error(), ShouldContainSubstring, "KeyProvider.PKCS11.PIN_file")
+
+		conflict := write("conflict.yaml", `
+KeyProvider:
+  PKCS11:
+    PIN: "1234"
+    PIN_file: pin.txt
+`, 0600)
+		_, err = LoadConfig(conflict)
+		So(errors.Cause(err), ShouldEqual, ErrFileRefConflict)
+
+		invalid := write("invalid.yaml", `
+KnownNodes:
+- Addr_file: [a, b]
+`, 0600)
+		_, err = LoadConfig(invalid)
+		So(errors.Cause(err), ShouldEqual, ErrInvalidFileRef)
+		So(err.Error(), ShouldContainSubstring, "KnownNodes[0].Addr_file")
+	})
+}
@@ -0,0 +1,154 @@
+
+package conf
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// IncludeKey is the top level key listing the config files merged under a config file.
+const IncludeKey = "include"
+
+// appendSuffix marks a list key to be appended to the included list instead of replacing it.
+const appendSuffix = "+"
+
+var (
+	// ErrCircularInclude indicates a config file includes itself directly or indirectly
+	ErrCircularInclude = errors.New("circular config include")
+	// ErrInvalidInclude indicates the include directive is not a list of file paths
+	ErrInvalidInclude = errors.New("invalid config include")
+)
+
+// readConfigFile reads configPath with its includes merged and returns it as YAML.
+//
+// The files listed by `include:` are relative to the including file and merged in
+// order, a later include overrides an earlier one and the including file overrides
+// all of them. Maps are merged key by key, scalars and lists are replaced, a list
+// key written with a trailing "+" (e.g. `KnownNodes+:`) is appended to the list of
+// the same key merged so far. A file may be included more than once, a file
+// including itself directly or indirectly fails with ErrCircularInclude.
+//
+// The file references, e.g. `PIN_file: pin.txt`, are read per file before the merge
+// and the relative ones are relative to the file setting them, see resolveFileRefs.
+// So are the relative paths of configPaths set by an included file, the ones of
+// configPath are resolved by loadConfigBytes.
+func readConfigFile(configPath string) (out []byte, err error) {
+	var merged map[interface{}]interface{}
+	if merged, err = loadIncludes(configPath, nil, nil); err != nil {
+		return
+	}
+	return yaml.Marshal(merged)
+}
+
+// loadIncludes reads configPath merged with its includes by the rules of
+// readConfigFile. If mergeNodes is not nil, the KnownNodes and `KnownNodes+:` lists of
+// configPath and its includes are passed to it with their file in the merge order
+// instead, e.g. to merge them by ID.
+func loadIncludes(configPath string, stack []string,
+	mergeNodes func(file string, nodes interface{}) error) (merged map[interface{}]interface{}, err error) {
+	absPath, err := filepath.Abs(configPath)
+	if err != nil {
+		return
+	}
+	for _, p := range stack {
+		if p == absPath {
+			err = errors.Wrapf(ErrCircularInclude, "%s",
+				strings.Join(append(stack, absPath), " -> "))
+			return
+		}
+	}
+	stack = append(stack, absPath)
+
+	content, err := os.ReadFile(configPath)
+	if err != nil {
+		return
+	}
+	var file map[interface{}]interface{}
+	if err = yaml.Unmarshal(content, &file); err != nil {
+		err = errors.Wrapf(err, "parse %s", configPath)
+		return
+	}
+	baseDir := filepath.Dir(configPath)
+	if err = resolveFileRefs(file, baseDir, ""); err != nil {
+		return
+	}
+	if len(stack) > 1 {
+		if err = resolveConfigPaths(file, baseDir); err != nil {
+			return
+		}
+	}
+
+	merged = make(map[interface{}]interface{})
+	if raw, ok := file[IncludeKey]; ok {
+		delete(file, IncludeKey)
+		includes, listOK := raw.([]interface{})
+		if !listOK {
+			err = errors.Wrapf(ErrInvalidInclude, "%s: %s must be a list", configPath, IncludeKey)
+			return
+		}
+		for _, inc := range includes {
+			incPath, pathOK := inc.(string)
+			if !pathOK || incPath == "" {
+				err = errors.Wrapf(ErrInvalidInclude, "%s: %v is not a file path", configPath, inc)
+				return
+			}
+			if !filepath.IsAbs(incPath) {
+				incPath = filepath.Join(baseDir, incPath)
+			}
+			var included map[interface{}]interface{}
+			if included, err = loadIncludes(incPath, stack, mergeNodes); err != nil {
+				return
+			}
+			mergeYAML(merged, included)
+		}
+	}
+	if mergeNodes != nil {
+		for _, key := range []string{knownNodesKey, knownNodesKey + appendSuffix} {
+			nodes, ok := file[key]
+			if !ok {
+				continue
+			}
+			delete(file, key)
+			if err = mergeNodes(configPath, nodes); err != nil {
+				return
+			}
+		}
+	}
+	mergeYAML(merged, file)
+	return
+}
+
+// mergeYAML merges src into dst by the rules of readConfigFile, the append keys of
+// src are applied after the other keys so `Key:` and `Key+:` in one file compose.
+func mergeYAML(dst, src map[interface{}]interface{}) {
+	var appends []string
+	for k, v := range src {
+		if key, ok := k.(string); ok && strings.HasSuffix(key, appendSuffix) {
+			if _, isList := v.([]interface{}); isList {
+				appends = append(appends, key)
+				continue
+			}
+		}
+		if sm, ok := v.(map[interface{}]interface{}); ok {
+			dm, ok := dst[k].(map[interface{}]interface{})
+			if !ok {
+				dm = make(map[interface{}]interface{})
+				dst[k] = dm
+			}
+			mergeYAML(dm, sm)
+			continue
+		}
+		dst[k] = v
+	}
+	sort.Strings(appends)
+	for _, key := range appends {
+		base := strings.TrimSuffix(key, appendSuffix)
+		prev, _ := dst[base].([]interface{})
+		dst[base] = append(append([]interface{}{}, prev...), src[key].([]interface{})...)
+	}
+}
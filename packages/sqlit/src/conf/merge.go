@@ -0,0 +1,218 @@
+
+package conf
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
+
+	"sqlit/src/utils/log"
+)
+
+// knownNodesKey is the config key of the known nodes merged by ID across files.
+const knownNodesKey = "KnownNodes"
+
+// knownNodeIdentityKeys are the known node fields deriving the node ID, two files
+// setting different values of one of them for the same ID conflict.
+var knownNodeIdentityKeys = []string{"PublicKey", "Nonce", "KeyType", "Ed25519PublicKey"}
+
+// configFileExts are the extensions of the config files read from a directory.
+var configFileExts = map[string]bool{".yaml": true, ".yml": true, ".json": true}
+
+var (
+	// ErrKnownNodeConflict indicates two config files set incompatible identities for
+	// the same known node ID
+	ErrKnownNodeConflict = errors.New("conflicting known node in config files")
+	// ErrEmptyConfigDir indicates a config directory holds no config file
+	ErrEmptyConfigDir = errors.New("no config file in directory")
+)
+
+// ExpandConfigPaths returns the config files of paths in order, a directory is
+// replaced by its .yaml, .yml and .json files sorted by name, the hidden files and
+// sub directories are skipped.
+func ExpandConfigPaths(paths []string) (files []string, err error) {
+	for _, p := range paths {
+		var info os.FileInfo
+		if info, err = os.Stat(p); err != nil {
+			err = errors.Wrap(err, "stat config file failed")
+			return
+		}
+		if !info.IsDir() {
+			files = append(files, p)
+			continue
+		}
+		var entries []os.DirEntry
+		if entries, err = os.ReadDir(p); err != nil {
+			err = errors.Wrap(err, "read config directory failed")
+			return
+		}
+		var found bool
+		for _, e := range entries {
+			name := e.Name()
+			if e.IsDir() || strings.HasPrefix(name, ".") || !configFileExts[filepath.Ext(name)] {
+				continue
+			}
+			files = append(files, filepath.Join(p, name))
+			found = true
+		}
+		if !found {
+			err = errors.Wrapf(ErrEmptyConfigDir, "%s", p)
+			return
+		}
+	}
+	return
+}
+
+// LoadConfigs loads the config merged from configPaths in order, such as a shared
+// base, a region file and a per-host file. A directory stands for its files, see
+// ExpandConfigPaths. Each file is read with its includes and file references like
+// LoadConfig, then the files are merged by the include rules of readConfigFile
+// except KnownNodes, which are merged by ID in the order of the files and their
+// includes, `KnownNodes+:` included: a later entry overrides the fields of the
+// earlier one and the new IDs are appended. A later entry with another public key or
+// nonce for the same ID fails with ErrKnownNodeConflict naming both files. The
+// merged config is validated as a whole. A relative path is relative to the file
+// setting it, an included one as well, the defaulted paths are relative to the last
+// file.
+func LoadConfigs(configPaths ...string) (config *Config, err error) {
+	files, err := ExpandConfigPaths(configPaths)
+	if err != nil {
+		log.WithError(err).Error("find config files failed")
+		return
+	}
+	if len(files) == 1 {
+		return LoadConfig(files[0])
+	}
+	configBytes, err := readConfigFiles(files)
+	if err != nil {
+		log.WithError(err).Error("read config files failed")
+		return
+	}
+	return loadConfigBytes(configBytes, files[len(files)-1])
+}
+
+// readConfigFiles reads files by the rules of LoadConfigs and returns the merged
+// config as YAML.
+func readConfigFiles(files []string) (out []byte, err error) {
+	var (
+		merged = make(map[interface{}]interface{})
+		// origins is the last file setting each known node ID
+		origins = make(map[string]string)
+	)
+	for _, file := range files {
+		var tree map[interface{}]interface{}
+		// the known nodes of the file and of its includes are merged by ID right away
+		if tree, err = loadIncludes(file, nil, func(source string, nodes interface{}) error {
+			return mergeKnownNodes(merged, nodes, source, origins)
+		}); err != nil {
+			return
+		}
+		if err = resolveConfigPaths(tree, filepath.Dir(file)); err != nil {
+			return
+		}
+		mergeYAML(merged, tree)
+	}
+	return yaml.Marshal(merged)
+}
+
+// resolveConfigPaths makes the relative paths of configPaths in tree absolute paths
+// in baseDir, so they keep pointing at the files next to their own config file after
+// the merge. The empty paths are left to the defaults of loadConfigBytes.
+func resolveConfigPaths(tree map[interface{}]interface{}, baseDir string) (err error) {
+	if baseDir, err = filepath.Abs(baseDir); err != nil {
+		return errors.Wrap(err, "resolve config directory failed")
+	}
+	for i := range configPaths {
+		keys := configPaths[i].keys
+		parent := tree
+		for _, key := range keys[:len(keys)-1] {
+			if parent, _ = parent[key].(map[interface{}]interface{}); parent == nil {
+				break
+			}
+		}
+		if parent == nil {
+			continue
+		}
+		key := keys[len(keys)-1]
+		value, ok := parent[key].(string)
+		if !ok || value == "" || !configPaths[i].isRelative(value) {
+			continue
+		}
+		parent[key] = filepath.Join(baseDir, value)
+	}
+	return
+}
+
+// mergeKnownNodes merges the known nodes list of file into merged by ID.
+func mergeKnownNodes(merged map[interface{}]interface{}, nodes interface{}, file string, origins map[string]string) (err error) {
+	list, ok := nodes.([]interface{})
+	if !ok {
+		if nodes != nil {
+			// not a list, left to the unmarshal to report
+			merged[knownNodesKey] = nodes
+		}
+		return
+	}
+	dst, _ := merged[knownNodesKey].([]interface{})
+	byID := make(map[string]map[interface{}]interface{}, len(dst))
+	for _, n := range dst {
+		if node, isMap := n.(map[interface{}]interface{}); isMap {
+			if id, isID := node["ID"].(string); isID && id != "" {
+				byID[id] = node
+			}
+		}
+	}
+	seen := make(map[string]bool, len(list))
+	for _, n := range list {
+		node, isMap := n.(map[interface{}]interface{})
+		id, _ := node["ID"].(string)
+		if !isMap || id == "" || seen[id] {
+			// no ID to merge by or a duplicate in file, appended for the validation
+			// to report
+			dst = append(dst, n)
+			continue
+		}
+		seen[id] = true
+		existing, found := byID[id]
+		if !found {
+			copied := make(map[interface{}]interface{}, len(node))
+			mergeYAML(copied, node)
+			dst = append(dst, copied)
+			origins[id] = file
+			continue
+		}
+		for _, key := range knownNodeIdentityKeys {
+			before, after := existing[key], node[key]
+			if before != nil && after != nil && !reflect.DeepEqual(before, after) {
+				return errors.Wrapf(ErrKnownNodeConflict, "ID %s: %s is %s in %s and %s in %s",
+					id, key, yamlValue(before), origins[id], yamlValue(after), file)
+			}
+		}
+		mergeYAML(existing, node)
+		origins[id] = file
+	}
+	merged[knownNodesKey] = dst
+	return
+}
+
+// yamlValue formats a config tree value as inline YAML for the error messages.
+func yamlValue(v interface{}) string {
+	out, err := yaml.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	s := strings.TrimSpace(string(out))
+	if _, isMap := v.(map[interface{}]interface{}); isMap {
+		// the maps such as Nonce are printed in flow style
+		lines := strings.Split(s, "\n")
+		sort.Strings(lines)
+		s = "{" + strings.Join(lines, ", ") + "}"
+	}
+	return s
+}
@@ -0,0 +1,197 @@
+
+package conf
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+	. "github.com/smartystreets/goconvey/convey"
+
+	"sqlit/src/proto"
+)
+
+func TestLoadConfigs(t *testing.T) {
+	const (
+		leader   = proto.NodeID("0000000000000000000000000000000000000000000000000000000000000001")
+		follower = proto.NodeID("0000000000000000000000000000000000000000000000000000000000000002")
+		miner    = proto.NodeID("0000000000000000000000000000000000000000000000000000000000000003")
+	)
+	Convey("the config files are merged in order", t, func() {
+		dir := t.TempDir()
+		write := func(name, content string) string {
+			p := filepath.Join(dir, name)
+			So(os.MkdirAll(filepath.Dir(p), 0700), ShouldBeNil)
+			So(os.WriteFile(p, []byte(content), 0600), ShouldBeNil)
+			return p
+		}
+		base := write("base.yaml", `
+ListenAddr: "0.0.0.0:4661"
+QPS: 100
+PrivateKeyFile: "keys/private.key"
+BlockProducer:
+  NodeID: "0000000000000000000000000000000000000000000000000000000000000001"
+  ChainFileName: "chain.db"
+KnownNodes:
+- ID: "0000000000000000000000000000000000000000000000000000000000000001"
+  Role: Leader
+  Addr: "10.0.0.1:4661"
+  Nonce: {a: 1}
+- ID: "0000000000000000000000000000000000000000000000000000000000000002"
+  Role: Follower
+  Addr: "10.0.0.2:4661"
+`)
+		region := write("region/eu.yaml", `
+QPS: 200
+PeersFile: "eu.peers"
+TLS:
+  CertFile: "/etc/sqlit/node.crt"
+KnownNodes:
+- ID: "0000000000000000000000000000000000000000000000000000000000000002"
+  Addr: "10.1.0.2:4661"
+  Region: eu-west
+- ID: "0000000000000000000000000000000000000000000000000000000000000003"
+  Role: Miner
+  Addr: "10.1.0.3:4661"
+`)
+		host := write("host/node2.yaml", `
+ListenAddr: "0.0.0.0:5661"
+ThisNodeID: "0000000000000000000000000000000000000000000000000000000000000002"
+BlockProducer:
+  ChainFileName: "node2.db"
+`)
+		config, err := LoadConfigs(base, region, host)
+		So(err, ShouldBeNil)
+		// the scalars of the later files win
+		So(config.ListenAddr, ShouldEqual, "0.0.0.0:5661")
+		So(config.QPS, ShouldEqual, 200)
+		So(config.ThisNodeID, ShouldEqual, follower)
+		// maps are merged key by key
+		So(config.BP.NodeID, ShouldEqual, leader)
+		So(config.BP.ChainFileName, ShouldEqual, filepath.Join(dir, "host", "node2.db"))
+		// each relative path is relative to the file setting it
+		So(config.PrivateKeyFile, ShouldEqual, filepath.Join(dir, "keys", "private.key"))
+		So(config.LocalNonceFile, ShouldEqual, filepath.Join(dir, "keys", "private.key.nonce"))
+		So(config.PeersFile, ShouldEqual, filepath.Join(dir, "region", "eu.peers"))
+		So(config.TLS.CertFile, ShouldEqual, "/etc/sqlit/node.crt")
+		So(config.PubKeyStoreFile, ShouldEqual, filepath.Join(dir, "host", "public.keystore"))
+		// the known nodes are merged by ID and the new ones appended
+		So(config.KnownNodes, ShouldHaveLength, 3)
+		So(config.KnownNodes[0].ID, ShouldEqual, leader)
+		So(config.KnownNodes[1].ID, ShouldEqual, follower)
+		So(config.KnownNodes[1].Addr, ShouldEqual, "10.1.0.2:4661")
+		So(config.KnownNodes[1].Role, ShouldEqual, proto.Follower)
+		So(config.KnownNodes[1].Region, ShouldEqual, "eu-west")
+		So(config.KnownNodes[2].ID, ShouldEqual, miner)
+
+		// a single file loads like LoadConfig
+		single, err := LoadConfigs(base)
+		So(err, ShouldBeNil)
+		loaded, err := LoadConfig(base)
+		So(err, ShouldBeNil)
+		So(single, ShouldResemble, loaded)
+
+		// a directory stands for its config files sorted by name
+		write("conf.d/10-base.yaml", "QPS: 100\nListenAddr: \"0.0.0.0:4661\"\n")
+		write("conf.d/20-host.yml", "QPS: 300\n")
+		write("conf.d/.hidden.yaml", "QPS: 400\n")
+		write("conf.d/notes.txt", "QPS: 500\n")
+		files, err := ExpandConfigPaths([]string{filepath.Join(dir, "conf.d"), host})
+		So(err, ShouldBeNil)
+		So(files, ShouldResemble, []string{
+			filepath.Join(dir, "conf.d", "10-base.yaml"),
+			filepath.Join(dir, "conf.d", "20-host.yml"),
+			host,
+		})
+		config, err = LoadConfigs(filepath.Join(dir, "conf.d"))
+		So(err, ShouldBeNil)
+		So(config.QPS, ShouldEqual, 300)
+		So(os.MkdirAll(filepath.Join(dir, "empty"), 0700), ShouldBeNil)
+		_, err = LoadConfigs(filepath.Join(dir, "empty"))
+		So(errors.Cause(err), ShouldEqual, ErrEmptyConfigDir)
+	})
+	Convey("the included files resolve against their own directory", t, func() {
+		dir := t.TempDir()
+		write := func(name, content string) string {
+			p := filepath.Join(dir, name)
+			So(os.MkdirAll(filepath.Dir(p), 0700), ShouldBeNil)
+			So(os.WriteFile(p, []byte(content), 0600), ShouldBeNil)
+			return p
+		}
+		write("shared/wallet.txt", "shared-wallet\n")
+		write("shared/tls.yaml", `
+WalletAddress_file: "wallet.txt"
+TLS:
+  CertFile: "node.crt"
+  KeyFile: "node.key"
+`)
+		write("shared/nodes.yaml", `
+KnownNodes:
+- ID: "0000000000000000000000000000000000000000000000000000000000000001"
+  Role: Leader
+  Addr: "10.0.0.1:4661"
+- ID: "0000000000000000000000000000000000000000000000000000000000000002"
+  Role: Follower
+  Addr: "10.0.0.2:4661"
+`)
+		host := write("host/config.yaml", `
+include:
+- ../shared/tls.yaml
+- ../shared/nodes.yaml
+ThisNodeID: "0000000000000000000000000000000000000000000000000000000000000002"
+KnownNodes+:
+- ID: "0000000000000000000000000000000000000000000000000000000000000002"
+  Addr: "10.1.0.2:4661"
+`)
+		extra := write("host/extra.yaml", "QPS: 300\n")
+		config, err := LoadConfigs(host, extra)
+		So(err, ShouldBeNil)
+		So(config.TLS.CertFile, ShouldEqual, filepath.Join(dir, "shared", "node.crt"))
+		So(config.TLS.KeyFile, ShouldEqual, filepath.Join(dir, "shared", "node.key"))
+		So(config.WalletAddress, ShouldEqual, "shared-wallet")
+		// the appended known node of an included one is merged by ID
+		So(config.KnownNodes, ShouldHaveLength, 2)
+		So(config.KnownNodes[1].ID, ShouldEqual, follower)
+		So(config.KnownNodes[1].Addr, ShouldEqual, "10.1.0.2:4661")
+		So(config.KnownNodes[1].Role, ShouldEqual, proto.Follower)
+
+		// a single file resolves its includes the same
+		config, err = LoadConfig(write("host/single.yaml", "include: [../shared/tls.yaml]\n"))
+		So(err, ShouldBeNil)
+		So(config.TLS.CertFile, ShouldEqual, filepath.Join(dir, "shared", "node.crt"))
+		So(config.WalletAddress, ShouldEqual, "shared-wallet")
+	})
+	Convey("the conflicting known nodes are reported with both files", t, func() {
+		dir := t.TempDir()
+		write := func(name, content string) string {
+			p := filepath.Join(dir, name)
+			So(os.WriteFile(p, []byte(content), 0600), ShouldBeNil)
+			return p
+		}
+		base := write("base.yaml", `
+KnownNodes:
+- ID: "0000000000000000000000000000000000000000000000000000000000000001"
+  Addr: "10.0.0.1:4661"
+  Nonce: {a: 1}
+`)
+		conflict := write("conflict.yaml", `
+KnownNodes:
+- ID: "0000000000000000000000000000000000000000000000000000000000000001"
+  Nonce: {a: 2}
+`)
+		_, err := LoadConfigs(base, conflict)
+		So(errors.Cause(err), ShouldEqual, ErrKnownNodeConflict)
+		So(err.Error(), ShouldContainSubstring, "Nonce is {a: 1} in "+base)
+		So(err.Error(), ShouldContainSubstring, "{a: 2} in "+conflict)
+
+		// the merged config is validated as a whole
+		duplicate := write("duplicate.yaml", `
+KnownNodes:
+- ID: "0000000000000000000000000000000000000000000000000000000000000002"
+  Addr: "10.0.0.1:4661"
+`)
+		_, err = LoadConfigs(base, duplicate)
+		So(errors.Cause(err), ShouldEqual, ErrDuplicateNodeAddr)
+	})
+}
@@ -0,0 +1,51 @@
+
+package conf
+
+import (
+	"os"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"sqlit/src/utils"
+)
+
+// ConfigFileEnv is the environment variable of the config file path.
+const ConfigFileEnv = EnvPrefix + "CONFIG"
+
+// DefaultConfigFiles is the search path of the config file if no path is given: the
+// working directory, then the ~/.sqlit config directory.
+var DefaultConfigFiles = []string{"config.yaml", "~/.sqlit/config.yaml"}
+
+// ErrConfigNotFound indicates none of DefaultConfigFiles exists.
+var ErrConfigNotFound = errors.New("config file not found")
+
+// FindConfigFile returns the config file to load. The precedence is explicit, such
+// as a command line flag, then the ConfigFileEnv variable found by lookup, then the
+// first existing file of DefaultConfigFiles. An explicit path or the variable must
+// exist, while a missing default falls through to the next one.
+func FindConfigFile(explicit string, lookup func(key string) (string, bool)) (path string, err error) {
+	if explicit != "" {
+		return existingConfigFile(explicit)
+	}
+	if value, ok := lookup(ConfigFileEnv); ok && value != "" {
+		if path, err = existingConfigFile(value); err != nil {
+			err = errors.Wrapf(err, "invalid environment variable %s", ConfigFileEnv)
+		}
+		return
+	}
+	for _, candidate := range DefaultConfigFiles {
+		if path = utils.HomeDirExpand(candidate); utils.Exist(path) {
+			return
+		}
+	}
+	return "", errors.Wrapf(ErrConfigNotFound, "searched %s", strings.Join(DefaultConfigFiles, ", "))
+}
+
+func existingConfigFile(file string) (path string, err error) {
+	path = utils.HomeDirExpand(file)
+	if _, err = os.Stat(path); err != nil {
+		return "", errors.Wrap(err, "stat config file failed")
+	}
+	return
+}
@@ -0,0 +1,63 @@
+
+package conf
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pkg/errors"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestFindConfigFile(t *testing.T) {
+	Convey("config file is found by flag, env, then the default search path", t, func() {
+		var (
+			dir      = t.TempDir()
+			flagFile = filepath.Join(dir, "flag.yaml")
+			envFile  = filepath.Join(dir, "env.yaml")
+			cwdFile  = filepath.Join(dir, "cwd.yaml")
+			homeFile = filepath.Join(dir, "home.yaml")
+			missing  = filepath.Join(dir, "missing.yaml")
+			env      = map[string]string{}
+			lookup   = func(key string) (value string, ok bool) {
+				value, ok = env[key]
+				return
+			}
+		)
+		for _, file := range []string{flagFile, envFile, cwdFile, homeFile} {
+			So(os.WriteFile(file, []byte("{}"), 0600), ShouldBeNil)
+		}
+		defer func(saved []string) { DefaultConfigFiles = saved }(DefaultConfigFiles)
+		DefaultConfigFiles = []string{cwdFile, homeFile}
+
+		path, err := FindConfigFile("", lookup)
+		So(err, ShouldBeNil)
+		So(path, ShouldEqual, cwdFile)
+		// a missing default falls through
+		DefaultConfigFiles = []string{missing, homeFile}
+		path, err = FindConfigFile("", lookup)
+		So(err, ShouldBeNil)
+		So(path, ShouldEqual, homeFile)
+		DefaultConfigFiles = []string{missing}
+		_, err = FindConfigFile("", lookup)
+		So(errors.Cause(err), ShouldEqual, ErrConfigNotFound)
+
+		env[ConfigFileEnv] = envFile
+		path, err = FindConfigFile("", lookup)
+		So(err, ShouldBeNil)
+		So(path, ShouldEqual, envFile)
+		path, err = FindConfigFile(flagFile, lookup)
+		So(err, ShouldBeNil)
+		So(path, ShouldEqual, flagFile)
+
+		// an explicit path must exist
+		DefaultConfigFiles = []string{homeFile}
+		_, err = FindConfigFile(missing, lookup)
+		So(os.IsNotExist(errors.Cause(err)), ShouldBeTrue)
+		env[ConfigFileEnv] = missing
+		_, err = FindConfigFile("", lookup)
+		So(os.IsNotExist(errors.Cause(err)), ShouldBeTrue)
+		So(err.Error(), ShouldContainSubstring, ConfigFileEnv)
+	})
+}
@@ -0,0 +1,122 @@
+
+package conf
+
+import (
+	"reflect"
+	"strings"
+
+	"sqlit/src/proto"
+)
+
+// ConfigDelta is the difference of a reloaded config, only the known nodes other
+// than the local node are applied live.
+type ConfigDelta struct {
+	// Added is the known nodes not in the old config
+	Added []proto.Node
+	// Updated is the known nodes changed in the reloaded config
+	Updated []proto.Node
+	// Removed is the known nodes not in the reloaded config
+	Removed []proto.Node
+	// Ignored is the yaml keys changed in the reloaded config which can not be applied live
+	Ignored []string
+}
+
+// IsEmpty returns if the reloaded config has no change.
+func (d ConfigDelta) IsEmpty() bool {
+	return len(d.Added) == 0 && len(d.Updated) == 0 && len(d.Removed) == 0 && len(d.Ignored) == 0
+}
+
+// Changed returns the yaml keys applied live, i.e. the known nodes added, updated
+// or removed.
+func (d ConfigDelta) Changed() (keys []string) {
+	for _, nodes := range [][]proto.Node{d.Added, d.Updated, d.Removed} {
+		for _, n := range nodes {
+			keys = append(keys, "KnownNodes["+string(n.ID)+"]")
+		}
+	}
+	return
+}
+
+// DiffConfig returns the delta from old to reloaded. The known node of
+// old.ThisNodeID is never changed, a change of it is reported as ignored like the
+// other fields.
+func DiffConfig(old, reloaded *Config) (delta ConfigDelta) {
+	var (
+		self     = old.ThisNodeID
+		oldNodes = make(map[proto.NodeID]proto.Node, len(old.KnownNodes))
+		newNodes = make(map[proto.NodeID]bool, len(reloaded.KnownNodes))
+	)
+	for _, n := range old.KnownNodes {
+		oldNodes[n.ID] = n
+	}
+	for _, n := range reloaded.KnownNodes {
+		newNodes[n.ID] = true
+		if o, ok := oldNodes[n.ID]; !ok {
+			if n.ID == self {
+				delta.Ignored = append(delta.Ignored, "KnownNodes["+string(self)+"]")
+			} else {
+				delta.Added = append(delta.Added, n)
+			}
+		} else if !reflect.DeepEqual(o, n) {
+			if n.ID == self {
+				delta.Ignored = append(delta.Ignored, "KnownNodes["+string(self)+"]")
+			} else {
+				delta.Updated = append(delta.Updated, n)
+			}
+		}
+	}
+	for _, n := range old.KnownNodes {
+		if !newNodes[n.ID] {
+			if n.ID == self {
+				delta.Ignored = append(delta.Ignored, "KnownNodes["+string(self)+"]")
+			} else {
+				delta.Removed = append(delta.Removed, n)
+			}
+		}
+	}
+
+	// any other changed yaml field needs a restart
+	var (
+		ov = reflect.ValueOf(old).Elem()
+		nv = reflect.ValueOf(reloaded).Elem()
+		t  = ov.Type()
+	)
+	for i := 0; i < t.NumField(); i++ {
+		key := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+		if key == "" || key == "-" || key == "KnownNodes" {
+			continue
+		}
+		if !reflect.DeepEqual(ov.Field(i).Interface(), nv.Field(i).Interface()) {
+			delta.Ignored = append(delta.Ignored, key)
+		}
+	}
+	return
+}
+
+// ApplyKnownNodes returns a copy of old with the known nodes of reloaded applied,
+// the local node of old is kept as is. The other fields of old are untouched.
+func ApplyKnownNodes(old, reloaded *Config) (config *Config) {
+	var (
+		copied = *old
+		self   *proto.Node
+	)
+	for i := range old.KnownNodes {
+		if old.KnownNodes[i].ID == old.ThisNodeID {
+			self = &old.KnownNodes[i]
+		}
+	}
+	config = &copied
+	config.KnownNodes = make([]proto.Node, 0, len(reloaded.KnownNodes)+1)
+	for _, n := range reloaded.KnownNodes {
+		if n.ID != old.ThisNodeID {
+			config.KnownNodes = append(config.KnownNodes, n)
+		} else if self != nil {
+			config.KnownNodes = append(config.KnownNodes, *self)
+			self = nil
+		}
+	}
+	if self != nil {
+		config.KnownNodes = append(config.KnownNodes, *self)
+	}
+	return
+}
@@ -0,0 +1,76 @@
+
+package conf
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"sqlit/src/proto"
+)
+
+func TestDiffConfig(t *testing.T) {
+	Convey("reload applies known nodes only", t, func() {
+		var (
+			self   = proto.NodeID("0000000000000000000000000000000000000000000000000000000000000001")
+			kept   = proto.NodeID("0000000000000000000000000000000000000000000000000000000000000002")
+			moved  = proto.NodeID("0000000000000000000000000000000000000000000000000000000000000003")
+			gone   = proto.NodeID("0000000000000000000000000000000000000000000000000000000000000004")
+			joined = proto.NodeID("0000000000000000000000000000000000000000000000000000000000000005")
+		)
+		old := &Config{
+			ThisNodeID: self,
+			ListenAddr: "a:0",
+			KnownNodes: []proto.Node{
+				{ID: self, Addr: "a:1"},
+				{ID: kept, Addr: "a:2"},
+				{ID: moved, Addr: "a:3"},
+				{ID: gone, Addr: "a:4"},
+			},
+			SeedBPNodes: []proto.Node{{ID: kept}},
+		}
+		reloaded := &Config{
+			ThisNodeID: self,
+			ListenAddr: "a:0",
+			KnownNodes: []proto.Node{
+				{ID: joined, Addr: "a:5"},
+				{ID: moved, Addr: "b:3", Addrs: []string{"c:3"}},
+				{ID: self, Addr: "a:1"},
+				{ID: kept, Addr: "a:2"},
+			},
+		}
+		delta := DiffConfig(old, reloaded)
+		So(delta.Added, ShouldResemble, []proto.Node{{ID: joined, Addr: "a:5"}})
+		So(delta.Updated, ShouldResemble, []proto.Node{{ID: moved, Addr: "b:3", Addrs: []string{"c:3"}}})
+		So(delta.Removed, ShouldResemble, []proto.Node{{ID: gone, Addr: "a:4"}})
+		So(delta.Ignored, ShouldBeEmpty)
+		So(delta.Changed(), ShouldResemble, []string{
+			"KnownNodes[" + string(joined) + "]",
+			"KnownNodes[" + string(moved) + "]",
+			"KnownNodes[" + string(gone) + "]",
+		})
+		So(delta.IsEmpty(), ShouldBeFalse)
+		So(DiffConfig(old, old).IsEmpty(), ShouldBeTrue)
+
+		applied := ApplyKnownNodes(old, reloaded)
+		So(applied.KnownNodes, ShouldResemble, reloaded.KnownNodes)
+		So(applied.SeedBPNodes, ShouldResemble, old.SeedBPNodes)
+		So(old.KnownNodes, ShouldHaveLength, 4)
+
+		// the local node and the other fields are never applied
+		reloaded.ThisNodeID = kept
+		reloaded.ListenAddr = "b:0"
+		reloaded.KnownNodes[2].Addr = "b:1"
+		delta = DiffConfig(old, reloaded)
+		So(delta.Ignored, ShouldResemble, []string{"KnownNodes[" + string(self) + "]", "ListenAddr", "ThisNodeID"})
+		applied = ApplyKnownNodes(old, reloaded)
+		So(applied.ThisNodeID, ShouldEqual, self)
+		So(applied.ListenAddr, ShouldEqual, "a:0")
+		So(applied.KnownNodes[2], ShouldResemble, proto.Node{ID: self, Addr: "a:1"})
+
+		reloaded.KnownNodes = reloaded.KnownNodes[:2]
+		applied = ApplyKnownNodes(old, reloaded)
+		So(applied.KnownNodes, ShouldHaveLength, 3)
+		So(applied.KnownNodes[2].ID, ShouldEqual, self)
+	})
+}
@@ -0,0 +1,301 @@
+
+package conf
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v2"
+
+	"sqlit/src/utils"
+	"sqlit/src/utils/log"
+)
+
+const (
+	// RemoteConfigEnv is the environment variable of the remote config URL.
+	RemoteConfigEnv = EnvPrefix + "REMOTE_CONFIG"
+	// RemoteTokenEnv is the environment variable of the remote store token, the ACL
+	// token of Consul or the auth token of etcd.
+	RemoteTokenEnv = EnvPrefix + "REMOTE_TOKEN"
+	// RemoteUserEnv is the environment variable of the etcd user as "name:password",
+	// a token is requested with it for every call.
+	RemoteUserEnv = EnvPrefix + "REMOTE_USER"
+)
+
+const (
+	// remoteFetchTimeout bounds a read of the remote store.
+	remoteFetchTimeout = 10 * time.Second
+	// remoteRetryMin and remoteRetryMax bound the backoff of the failed watches.
+	remoteRetryMin = time.Second
+	remoteRetryMax = time.Minute
+	// remoteCacheHeader starts the cached copy of the remote config.
+	remoteCacheHeader = "# cached copy of the remote config %s, it is replaced on change\n"
+)
+
+var (
+	// ErrUnsupportedRemote indicates the remote config URL is not of a supported store.
+	ErrUnsupportedRemote = errors.New("unsupported remote config store")
+	// ErrRemoteConfigEmpty indicates the remote store holds no key under the prefix.
+	ErrRemoteConfigEmpty = errors.New("no remote config under prefix")
+	// ErrInvalidRemoteKey indicates a remote key does not map to the config structure.
+	ErrInvalidRemoteKey = errors.New("invalid remote config key")
+)
+
+// RemoteSource is a KV store holding the config under a key prefix, see
+// remoteDocument for the mapping of the keys to the config structure.
+type RemoteSource interface {
+	// Fetch returns the values under the prefix by the key relative to the prefix,
+	// the prefix itself is the empty key, and the index of the store state.
+	Fetch(ctx context.Context) (values map[string][]byte, index uint64, err error)
+	// Wait blocks until the keys under the prefix change after index or ctx is done
+	// and returns the new index, an index of 0 returns at once.
+	Wait(ctx context.Context, index uint64) (next uint64, err error)
+	// String returns the URL of the source without credentials.
+	String() string
+}
+
+// NewRemoteSource returns the source of rawURL, e.g. "consul://127.0.0.1:8500/sqlit/c1"
+// or "etcd://127.0.0.1:2379/sqlit/c1", the path is the key prefix. The "+https"
+// schemes, e.g. "consul+https", use TLS. The credentials are read by lookup from
+// RemoteTokenEnv and RemoteUserEnv, never from the URL.
+func NewRemoteSource(rawURL string, lookup func(key string) (string, bool)) (src RemoteSource, err error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, errors.Wrap(err, "parse remote config url failed")
+	}
+	if u.User != nil {
+		return nil, errors.Errorf("remote config url must not hold credentials, set %s or %s",
+			RemoteTokenEnv, RemoteUserEnv)
+	}
+	store, scheme := u.Scheme, "http"
+	if base, ok := strings.CutSuffix(store, "+https"); ok {
+		store, scheme = base, "https"
+	}
+	if u.Host == "" || strings.Trim(u.Path, "/") == "" {
+		return nil, errors.Errorf("remote config url %s needs a host and a key prefix", rawURL)
+	}
+	token, _ := lookup(RemoteTokenEnv)
+	remote := remoteStore{
+		client:  &http.Client{},
+		base:    scheme + "://" + u.Host,
+		display: u.String(),
+		token:   token,
+	}
+	switch store {
+	case "consul":
+		remote.prefix = strings.Trim(u.Path, "/")
+		return &consulSource{remoteStore: remote}, nil
+	case "etcd":
+		remote.prefix = strings.TrimSuffix(u.Path, "/")
+		etcd := &etcdSource{remoteStore: remote}
+		if user, ok := lookup(RemoteUserEnv); ok && user != "" {
+			if etcd.user, etcd.password, ok = strings.Cut(user, ":"); !ok {
+				return nil, errors.Errorf("invalid environment variable %s, want name:password", RemoteUserEnv)
+			}
+		}
+		return etcd, nil
+	}
+	return nil, errors.Wrapf(ErrUnsupportedRemote, "%s", u.Scheme)
+}
+
+// relativeKey returns the key relative to prefix, ok is false for the keys out of
+// prefix, e.g. "sqlit/c10" of the prefix "sqlit/c1".
+func relativeKey(prefix, key string) (rel string, ok bool) {
+	if key == prefix {
+		return "", true
+	}
+	return strings.CutPrefix(key, prefix+"/")
+}
+
+// remoteDocument maps the remote values to the YAML document the config file would
+// hold. The values are YAML, the prefix itself holds a whole config document and the
+// key of the other values is their path in it, e.g. "BlockProducer/NodeID". They are
+// merged by key order over the prefix document by the rules of readConfigFile. A path
+// segment with a trailing "+" collects its child keys as a list appended to the key,
+// e.g. "KnownNodes+/node1" holds a node appended to the KnownNodes, so a fleet keeps
+// a key per node. The keys ending with "/", e.g. the Consul folders, are skipped.
+func remoteDocument(values map[string][]byte) (doc map[interface{}]interface{}, err error) {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		if !strings.HasSuffix(key, "/") {
+			keys = append(keys, key)
+		}
+	}
+	if len(keys) == 0 {
+		return nil, ErrRemoteConfigEmpty
+	}
+	sort.Strings(keys)
+
+	doc = make(map[interface{}]interface{})
+	overlay := make(map[interface{}]interface{})
+	for _, key := range keys {
+		var value interface{}
+		if err = yaml.Unmarshal(values[key], &value); err != nil {
+			return nil, errors.Wrapf(err, "parse remote config key %q", key)
+		}
+		if key == "" {
+			root, ok := value.(map[interface{}]interface{})
+			if !ok && value != nil {
+				return nil, errors.Wrapf(ErrInvalidRemoteKey, "the prefix must hold a config document")
+			}
+			mergeYAML(doc, root)
+			continue
+		}
+		if err = setRemoteValue(overlay, strings.Split(key, "/"), value); err != nil {
+			return nil, errors.Wrapf(err, "%q", key)
+		}
+	}
+	mergeYAML(doc, overlay)
+	return
+}
+
+// setRemoteValue sets value at path of node, a segment with the append suffix holds
+// exactly one child segment naming the list item.
+func setRemoteValue(node map[interface{}]interface{}, path []string, value interface{}) error {
+	for i, segment := range path {
+		if segment == "" || segment == appendSuffix {
+			return ErrInvalidRemoteKey
+		}
+		last := i == len(path)-1
+		if strings.HasSuffix(segment, appendSuffix) {
+			if i != len(path)-2 {
+				return errors.Wrapf(ErrInvalidRemoteKey, "%s needs one item key", segment)
+			}
+			list, _ := node[segment].([]interface{})
+			node[segment] = append(list, value)
+			return nil
+		}
+		if last {
+			if _, isMap := node[segment].(map[interface{}]interface{}); isMap {
+				return errors.Wrapf(ErrInvalidRemoteKey, "%s also has child keys", segment)
+			}
+			node[segment] = value
+			return nil
+		}
+		child, ok := node[segment].(map[interface{}]interface{})
+		if !ok {
+			if _, exists := node[segment]; exists {
+				return errors.Wrapf(ErrInvalidRemoteKey, "%s also has a value", segment)
+			}
+			child = make(map[interface{}]interface{})
+			node[segment] = child
+		}
+		node = child
+	}
+	return nil
+}
+
+// FetchRemoteConfig reads the config of src and writes it to cachePath as the YAML of
+// remoteDocument, so LoadConfig of cachePath loads it like any config file and the
+// relative paths are relative to cachePath. The file is replaced atomically and only
+// if the content changed, changed reports it.
+func FetchRemoteConfig(ctx context.Context, src RemoteSource, cachePath string) (index uint64, changed bool, err error) {
+	fetchCtx, cancel := context.WithTimeout(ctx, remoteFetchTimeout)
+	defer cancel()
+	values, index, err := src.Fetch(fetchCtx)
+	if err != nil {
+		err = errors.Wrapf(err, "fetch remote config %s failed", src)
+		return
+	}
+	doc, err := remoteDocument(values)
+	if err != nil {
+		err = errors.Wrapf(err, "map remote config %s failed", src)
+		return
+	}
+	out, err := yaml.Marshal(doc)
+	if err != nil {
+		return
+	}
+	content := append([]byte(fmt.Sprintf(remoteCacheHeader, src)), out...)
+	if cached, readErr := os.ReadFile(cachePath); readErr == nil && bytes.Equal(cached, content) {
+		return
+	}
+	if err = writeFileAtomic(cachePath, content); err != nil {
+		err = errors.Wrap(err, "write remote config cache failed")
+		return
+	}
+	changed = true
+	return
+}
+
+// LoadRemoteConfig loads the config of src like LoadConfig, the config is cached at
+// cachePath. If src can not be read the cached copy is loaded if present, index is
+// then 0 so the first watch reads src again.
+func LoadRemoteConfig(ctx context.Context, src RemoteSource, cachePath string) (config *Config, index uint64, err error) {
+	if index, _, err = FetchRemoteConfig(ctx, src, cachePath); err != nil {
+		if !utils.Exist(cachePath) {
+			log.WithField("remote", src.String()).WithError(err).Error("fetch remote config failed")
+			return
+		}
+		log.WithFields(log.Fields{
+			"remote": src.String(),
+			"cache":  cachePath,
+		}).WithError(err).Warning("fetch remote config failed, load the cached copy")
+		index, err = 0, nil
+	}
+	config, err = LoadConfig(cachePath)
+	return
+}
+
+// WatchRemoteConfig refreshes the cached config of src at cachePath on every change
+// after index until ctx is done, onChange is called after the cached copy is
+// replaced, e.g. to reload it. The failures are retried with backoff.
+func WatchRemoteConfig(ctx context.Context, src RemoteSource, cachePath string, index uint64, onChange func()) {
+	go func() {
+		retry := remoteRetryMin
+		for {
+			next, err := src.Wait(ctx, index)
+			if ctx.Err() != nil {
+				return
+			}
+			if err == nil {
+				var changed bool
+				if next, changed, err = FetchRemoteConfig(ctx, src, cachePath); err == nil {
+					index, retry = next, remoteRetryMin
+					if changed {
+						log.WithField("remote", src.String()).Info("remote config changed")
+						onChange()
+					}
+					continue
+				}
+			}
+			log.WithField("remote", src.String()).WithError(err).Warning("watch remote config failed, retry")
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(retry):
+			}
+			if retry *= 2; retry > remoteRetryMax {
+				retry = remoteRetryMax
+			}
+		}
+	}()
+}
+
+// writeFileAtomic replaces path with data, a crash never leaves a partial file.
+func writeFileAtomic(path string, data []byte) (err error) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(data); err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return
+	}
+	return os.Rename(tmp.Name(), path)
+}
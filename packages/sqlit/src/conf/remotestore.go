@@ -0,0 +1,279 @@
+
+package conf
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// consulWaitTime is the longest blocking query of Consul, the watch queries again
+// after it.
+const consulWaitTime = 5 * time.Minute
+
+// remoteStore is the HTTP endpoint shared by the remote sources.
+type remoteStore struct {
+	client  *http.Client
+	base    string
+	display string
+	prefix  string
+	token   string
+}
+
+// String implements RemoteSource.
+func (s *remoteStore) String() string {
+	return s.display
+}
+
+// do sends req and returns the response of a 2xx status, the body of the other
+// statuses is returned in the error.
+func (s *remoteStore) do(req *http.Request) (resp *http.Response, err error) {
+	if resp, err = s.client.Do(req); err != nil {
+		return
+	}
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		_ = resp.Body.Close()
+		err = errors.Errorf("%s %s: %s %s", req.Method, req.URL.Path, resp.Status, strings.TrimSpace(string(body)))
+		resp = nil
+	}
+	return
+}
+
+// consulSource reads the config of the Consul KV store.
+type consulSource struct {
+	remoteStore
+}
+
+// consulKV is a key of the Consul KV API, the value is base64 in JSON.
+type consulKV struct {
+	Key   string
+	Value []byte
+}
+
+// query reads the keys of the prefix, a blocking query for an index not zero.
+func (s *consulSource) query(ctx context.Context, index uint64) (kvs []consulKV, next uint64, err error) {
+	query := url.Values{"recurse": {"true"}}
+	if index > 0 {
+		query.Set("index", strconv.FormatUint(index, 10))
+		query.Set("wait", consulWaitTime.String())
+	}
+	u := s.base + (&url.URL{Path: "/v1/kv/" + s.prefix}).EscapedPath() + "?" + query.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return
+	}
+	if s.token != "" {
+		req.Header.Set("X-Consul-Token", s.token)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if next, err = strconv.ParseUint(resp.Header.Get("X-Consul-Index"), 10, 64); err != nil {
+		next, err = 0, nil
+	}
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		// no key under the prefix
+		return
+	case resp.StatusCode/100 != 2:
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		err = errors.Errorf("consul kv: %s %s", resp.Status, strings.TrimSpace(string(body)))
+		return
+	}
+	err = json.NewDecoder(resp.Body).Decode(&kvs)
+	return
+}
+
+// Fetch implements RemoteSource.
+func (s *consulSource) Fetch(ctx context.Context) (values map[string][]byte, index uint64, err error) {
+	var kvs []consulKV
+	if kvs, index, err = s.query(ctx, 0); err != nil {
+		return
+	}
+	values = make(map[string][]byte, len(kvs))
+	for _, kv := range kvs {
+		// the folders hold no value
+		if strings.HasSuffix(kv.Key, "/") {
+			continue
+		}
+		if rel, ok := relativeKey(s.prefix, kv.Key); ok {
+			values[rel] = kv.Value
+		}
+	}
+	return
+}
+
+// Wait implements RemoteSource, the index is the X-Consul-Index of the blocking
+// queries and a lower index resets the wait as Consul documents.
+func (s *consulSource) Wait(ctx context.Context, index uint64) (next uint64, err error) {
+	for index > 0 {
+		if _, next, err = s.query(ctx, index); err != nil || next != index {
+			return
+		}
+	}
+	return
+}
+
+// etcdSource reads the config of the etcd v3 KV store by its JSON gateway.
+type etcdSource struct {
+	remoteStore
+	user     string
+	password string
+}
+
+// etcdResponseHeader is the header of the etcd responses, the int64 are strings.
+type etcdResponseHeader struct {
+	Revision json.Number `json:"revision"`
+}
+
+// etcdRangeResponse is the response of /v3/kv/range, the bytes are base64.
+type etcdRangeResponse struct {
+	Header etcdResponseHeader `json:"header"`
+	KVs    []struct {
+		Key   []byte `json:"key"`
+		Value []byte `json:"value"`
+	} `json:"kvs"`
+}
+
+// etcdWatchResponse is a message of the /v3/watch stream.
+type etcdWatchResponse struct {
+	Result *struct {
+		Header          etcdResponseHeader `json:"header"`
+		Created         bool               `json:"created"`
+		Canceled        bool               `json:"canceled"`
+		CompactRevision json.Number        `json:"compact_revision"`
+		Events          []json.RawMessage  `json:"events"`
+	} `json:"result"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// rangeEnd returns the range end of the keys starting with prefix.
+func rangeEnd(prefix string) []byte {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return end[:i+1]
+		}
+	}
+	// all the keys
+	return []byte{0}
+}
+
+// post sends the JSON of body to path with the auth token, the user gets a new token
+// for every call as the tokens expire.
+func (s *etcdSource) post(ctx context.Context, path string, body interface{}) (resp *http.Response, err error) {
+	token := s.token
+	if s.user != "" {
+		if resp, err = s.request(ctx, "/v3/auth/authenticate", "", map[string]string{
+			"name": s.user, "password": s.password,
+		}); err != nil {
+			return nil, errors.Wrap(err, "etcd authenticate failed")
+		}
+		var auth struct {
+			Token string `json:"token"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&auth)
+		_ = resp.Body.Close()
+		if err != nil {
+			return nil, errors.Wrap(err, "etcd authenticate failed")
+		}
+		token = auth.Token
+	}
+	return s.request(ctx, path, token, body)
+}
+
+// request posts the JSON of body to path with token.
+func (s *etcdSource) request(ctx context.Context, path, token string, body interface{}) (resp *http.Response, err error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.base+path, bytes.NewReader(data))
+	if err != nil {
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", token)
+	}
+	return s.do(req)
+}
+
+// Fetch implements RemoteSource, the index is the store revision.
+func (s *etcdSource) Fetch(ctx context.Context) (values map[string][]byte, index uint64, err error) {
+	resp, err := s.post(ctx, "/v3/kv/range", map[string][]byte{
+		"key":       []byte(s.prefix),
+		"range_end": rangeEnd(s.prefix),
+	})
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	var r etcdRangeResponse
+	if err = json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return
+	}
+	if index, err = strconv.ParseUint(r.Header.Revision.String(), 10, 64); err != nil {
+		return nil, 0, errors.Wrap(err, "invalid etcd revision")
+	}
+	values = make(map[string][]byte, len(r.KVs))
+	for _, kv := range r.KVs {
+		if rel, ok := relativeKey(s.prefix, string(kv.Key)); ok {
+			values[rel] = kv.Value
+		}
+	}
+	return
+}
+
+// Wait implements RemoteSource, it watches the prefix from the revision after index
+// until an event, a compaction or the cancel of the watch.
+func (s *etcdSource) Wait(ctx context.Context, index uint64) (next uint64, err error) {
+	if index == 0 {
+		return
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	resp, err := s.post(ctx, "/v3/watch", map[string]interface{}{
+		"create_request": map[string]interface{}{
+			"key":            []byte(s.prefix),
+			"range_end":      rangeEnd(s.prefix),
+			"start_revision": strconv.FormatUint(index+1, 10),
+		},
+	})
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	dec := json.NewDecoder(resp.Body)
+	for {
+		var msg etcdWatchResponse
+		if err = dec.Decode(&msg); err != nil {
+			return
+		}
+		if msg.Error != nil {
+			return 0, errors.Errorf("etcd watch: %s", msg.Error.Message)
+		}
+		if msg.Result == nil {
+			continue
+		}
+		r := msg.Result
+		if len(r.Events) > 0 || r.Canceled || (r.CompactRevision != "" && r.CompactRevision != "0") {
+			next, _ = strconv.ParseUint(r.Header.Revision.String(), 10, 64)
+			return
+		}
+	}
+}
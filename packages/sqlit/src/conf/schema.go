@@ -0,0 +1,143 @@
+
+package conf
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"sqlit/src/proto"
+	"sqlit/src/utils/log"
+)
+
+// DefaultPort is the port of the node addresses given without one.
+const DefaultPort = 4661
+
+// DefaultNodeRole is the role of the known nodes given without one if
+// Config.DefaultKnownNodeRole is not set.
+const DefaultNodeRole = proto.Miner
+
+// ValidationError lists every required config field missing at once.
+type ValidationError struct {
+	// Missing is the yaml paths of the missing fields, e.g. BlockProducer.NodeID
+	Missing []string
+}
+
+// Error implements the error interface.
+func (e *ValidationError) Error() string {
+	return "missing required config fields: " + strings.Join(e.Missing, ", ")
+}
+
+// fieldRule is a required field of the node schema, set reports if it is present.
+type fieldRule struct {
+	path string
+	set  func(c *Config) bool
+}
+
+// nodeSchema is the fields required to run a node, see Config.Validate.
+var nodeSchema = []fieldRule{
+	{"ThisNodeID", func(c *Config) bool { return c.ThisNodeID != "" }},
+	{"ListenAddr", func(c *Config) bool { return c.ListenAddr != "" }},
+	{"BlockProducer", func(c *Config) bool { return c.BP != nil }},
+	{"BlockProducer.NodeID", func(c *Config) bool { return c.BP == nil || c.BP.NodeID != "" }},
+	{"KnownNodes", func(c *Config) bool { return len(c.KnownNodes) > 0 }},
+	{"KnownNodes entry of ThisNodeID", func(c *Config) bool {
+		return c.ThisNodeID == "" || hasKnownNode(c, c.ThisNodeID)
+	}},
+	{"KnownNodes entry of BlockProducer.NodeID", func(c *Config) bool {
+		return c.BP == nil || c.BP.NodeID == "" || hasKnownNode(c, c.BP.NodeID)
+	}},
+	{"TLS.CertFile", func(c *Config) bool {
+		return c.TLS == nil || c.TLS.DeriveFromNodeKey || c.TLS.CertFile != ""
+	}},
+	{"TLS.KeyFile", func(c *Config) bool {
+		return c.TLS == nil || c.TLS.DeriveFromNodeKey || c.TLS.KeyFile != ""
+	}},
+}
+
+func hasKnownNode(c *Config, id proto.NodeID) bool {
+	for _, n := range c.KnownNodes {
+		if n.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks the fields required to run a node are all set, the missing ones
+// are returned together in a *ValidationError. It is not called by LoadConfig as
+// clients load the same config without the node fields.
+func (c *Config) Validate() (err error) {
+	var missing []string
+	for _, rule := range nodeSchema {
+		if !rule.set(c) {
+			missing = append(missing, rule.path)
+		}
+	}
+	for i, n := range c.KnownNodes {
+		if n.Addr == "" {
+			missing = append(missing, fmt.Sprintf("KnownNodes[%d].Addr", i))
+		}
+	}
+	if len(missing) > 0 {
+		err = &ValidationError{Missing: missing}
+	}
+	return
+}
+
+// applySchemaDefaults sets the default role of the known nodes and the default port
+// of the node addresses given as host only. The nodes with a defaulted role left out
+// of the peers, neither a voter nor an observer, and the block producer not a voter
+// are warned.
+func applySchemaDefaults(config *Config) {
+	var bp proto.NodeID
+	if config.BP != nil {
+		bp = config.BP.NodeID
+	}
+	for i := range config.KnownNodes {
+		node := &config.KnownNodes[i]
+		defaulted := node.Role == proto.Unknown
+		if defaulted {
+			node.Role = knownNodeRole(config, node.ID)
+		}
+		isBP := bp != "" && node.ID == bp
+		if (defaulted && !node.Role.IsVoter() && node.Role != proto.Observer) || (isBP && !node.Role.IsVoter()) {
+			log.WithFields(log.Fields{
+				"index": i,
+				"node":  node.ID,
+				"role":  node.Role,
+			}).Warning("known node has no usable peers role")
+		}
+		node.Addr = withDefaultPort(node.Addr)
+		for j := range node.Addrs {
+			node.Addrs[j] = withDefaultPort(node.Addrs[j])
+		}
+	}
+}
+
+// knownNodeRole returns the role of the known node id given without one, the block
+// producer is a Leader.
+func knownNodeRole(config *Config, id proto.NodeID) proto.ServerRole {
+	if config.BP != nil && config.BP.NodeID != "" && id == config.BP.NodeID {
+		return proto.Leader
+	}
+	if config.DefaultKnownNodeRole != proto.Unknown {
+		return config.DefaultKnownNodeRole
+	}
+	return DefaultNodeRole
+}
+
+func withDefaultPort(addr string) string {
+	if addr == "" {
+		return addr
+	}
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		if addrErr, ok := err.(*net.AddrError); ok && addrErr.Err == "missing port in address" {
+			// a bracketed IPv6 host is bracketed again by JoinHostPort
+			host := strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+			return net.JoinHostPort(host, strconv.Itoa(DefaultPort))
+		}
+	}
+	return addr
+}
@@ -0,0 +1,132 @@
+
+package asymmetric
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// Ed25519PublicKeyFormatHeader is the header of Ed25519PublicKey.Serialize(), it never
+// collides with the secp256k1 compressed public key headers 0x2 and 0x3.
+const Ed25519PublicKeyFormatHeader byte = 0xed
+
+// Ed25519PublicKeyBytesLen defines the length in bytes of a serialized ed25519 public key.
+const Ed25519PublicKeyBytesLen = 1 + ed25519.PublicKeySize
+
+var (
+	// ErrInvalidEd25519PublicKey indicates the ed25519 public key bytes is malformed
+	ErrInvalidEd25519PublicKey = errors.New("invalid ed25519 public key")
+)
+
+// Ed25519PrivateKey wraps an ed25519.PrivateKey.
+type Ed25519PrivateKey ed25519.PrivateKey
+
+// Ed25519PublicKey wraps an ed25519.PublicKey.
+type Ed25519PublicKey ed25519.PublicKey
+
+// GenEd25519KeyPair generates ed25519 key pair.
+func GenEd25519KeyPair() (privateKey Ed25519PrivateKey, publicKey Ed25519PublicKey, err error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return
+	}
+	return Ed25519PrivateKey(priv), Ed25519PublicKey(pub), nil
+}
+
+// Ed25519PrivKeyFromSeed returns the ed25519 private key derived from seed.
+func Ed25519PrivKeyFromSeed(seed []byte) (Ed25519PrivateKey, error) {
+	if len(seed) != ed25519.SeedSize {
+		return nil, fmt.Errorf("ed25519 seed length %d != %d", len(seed), ed25519.SeedSize)
+	}
+	return Ed25519PrivateKey(ed25519.NewKeyFromSeed(seed)), nil
+}
+
+// ParseEd25519PubKey recovers the ed25519 public key from Ed25519PublicKey.Serialize() output.
+func ParseEd25519PubKey(keyBytes []byte) (Ed25519PublicKey, error) {
+	if len(keyBytes) != Ed25519PublicKeyBytesLen || keyBytes[0] != Ed25519PublicKeyFormatHeader {
+		return nil, ErrInvalidEd25519PublicKey
+	}
+	key := make([]byte, ed25519.PublicKeySize)
+	copy(key, keyBytes[1:])
+	return Ed25519PublicKey(key), nil
+}
+
+// KeyType implements TypedPrivateKey.KeyType.
+func (private Ed25519PrivateKey) KeyType() KeyType {
+	return Ed25519
+}
+
+// SignBytes implements TypedPrivateKey.SignBytes.
+func (private Ed25519PrivateKey) SignBytes(hash []byte) ([]byte, error) {
+	if len(hash) != 32 {
+		return nil, errors.New("only hash can be signed")
+	}
+	if len(private) != ed25519.PrivateKeySize {
+		return nil, errors.New("invalid ed25519 private key")
+	}
+	return ed25519.Sign(ed25519.PrivateKey(private), hash), nil
+}
+
+// TypedPubKey implements TypedPrivateKey.TypedPubKey.
+func (private Ed25519PrivateKey) TypedPubKey() TypedPublicKey {
+	return private.PubKey()
+}
+
+// PubKey returns the public key.
+func (private Ed25519PrivateKey) PubKey() Ed25519PublicKey {
+	return Ed25519PublicKey(ed25519.PrivateKey(private).Public().(ed25519.PublicKey))
+}
+
+// Seed returns the private key seed.
+func (private Ed25519PrivateKey) Seed() []byte {
+	return ed25519.PrivateKey(private).Seed()
+}
+
+// KeyType implements TypedPublicKey.KeyType.
+func (k Ed25519PublicKey) KeyType() KeyType {
+	return Ed25519
+}
+
+// Serialize implements TypedPublicKey.Serialize.
+func (k Ed25519PublicKey) Serialize() []byte {
+	return append([]byte{Ed25519PublicKeyFormatHeader}, k...)
+}
+
+// VerifyBytes implements TypedPublicKey.VerifyBytes.
+func (k Ed25519PublicKey) VerifyBytes(hash, sig []byte) bool {
+	if BypassSignature {
+		return true
+	}
+	if len(k) != ed25519.PublicKeySize {
+		return false
+	}
+	return ed25519.Verify(ed25519.PublicKey(k), hash, sig)
+}
+
+// IsEqual return true if two keys are equal.
+func (k Ed25519PublicKey) IsEqual(public Ed25519PublicKey) bool {
+	return bytes.Equal(k, public)
+}
+
+// MarshalYAML implements the yaml.Marshaler interface.
+func (k Ed25519PublicKey) MarshalYAML() (interface{}, error) {
+	return fmt.Sprintf("%x", k.Serialize()), nil
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (k *Ed25519PublicKey) UnmarshalYAML(unmarshal func(interface{}) error) (err error) {
+	var str string
+	if err = unmarshal(&str); err != nil {
+		return
+	}
+	keyBytes, err := hex.DecodeString(str)
+	if err != nil {
+		return
+	}
+	*k, err = ParseEd25519PubKey(keyBytes)
+	return
+}
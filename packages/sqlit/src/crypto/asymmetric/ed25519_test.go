@@ -0,0 +1,92 @@
+
+package asymmetric
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	yaml "gopkg.in/yaml.v2"
+
+	"sqlit/src/crypto/hash"
+)
+
+func TestEd25519KeyPair(t *testing.T) {
+	Convey("sign and verify", t, func() {
+		privateKey, publicKey, err := GenEd25519KeyPair()
+		So(err, ShouldBeNil)
+		So(privateKey.KeyType(), ShouldEqual, Ed25519)
+		So(privateKey.PubKey().IsEqual(publicKey), ShouldBeTrue)
+
+		h := hash.THashB([]byte("test"))
+		sig, err := privateKey.SignBytes(h)
+		So(err, ShouldBeNil)
+		So(publicKey.VerifyBytes(h, sig), ShouldBeTrue)
+		So(publicKey.VerifyBytes(hash.THashB([]byte("other")), sig), ShouldBeFalse)
+		_, err = privateKey.SignBytes([]byte("not a hash"))
+		So(err, ShouldNotBeNil)
+
+		seeded, err := Ed25519PrivKeyFromSeed(privateKey.Seed())
+		So(err, ShouldBeNil)
+		So(seeded.PubKey().IsEqual(publicKey), ShouldBeTrue)
+		_, err = Ed25519PrivKeyFromSeed([]byte("short"))
+		So(err, ShouldNotBeNil)
+	})
+	Convey("serialize and parse", t, func() {
+		_, publicKey, _ := GenEd25519KeyPair()
+		keyBytes := publicKey.Serialize()
+		So(keyBytes, ShouldHaveLength, Ed25519PublicKeyBytesLen)
+		So(keyBytes[0], ShouldEqual, Ed25519PublicKeyFormatHeader)
+
+		parsed, err := ParseTypedPublicKey(Ed25519, keyBytes)
+		So(err, ShouldBeNil)
+		So(parsed.KeyType(), ShouldEqual, Ed25519)
+		So(parsed.(Ed25519PublicKey).IsEqual(publicKey), ShouldBeTrue)
+
+		_, err = ParseEd25519PubKey(keyBytes[1:])
+		So(err, ShouldEqual, ErrInvalidEd25519PublicKey)
+		_, err = ParseTypedPublicKey(Secp256k1, keyBytes)
+		So(err, ShouldNotBeNil)
+		_, err = ParseTypedPublicKey(KeyType(99), keyBytes)
+		So(err, ShouldEqual, ErrUnknownKeyType)
+
+		out, err := yaml.Marshal(publicKey)
+		So(err, ShouldBeNil)
+		var unmarshaled Ed25519PublicKey
+		So(yaml.Unmarshal(out, &unmarshaled), ShouldBeNil)
+		So(unmarshaled.IsEqual(publicKey), ShouldBeTrue)
+	})
+	Convey("mixed key types", t, func() {
+		secpPrivate, secpPublic, _ := GenSecp256k1KeyPair()
+		edPrivate, edPublic, _ := GenEd25519KeyPair()
+		h := hash.THashB([]byte("test"))
+
+		signers := []TypedPrivateKey{secpPrivate, edPrivate}
+		verifiers := []TypedPublicKey{secpPublic, edPublic}
+		for i, signer := range signers {
+			sig, err := signer.SignBytes(h)
+			So(err, ShouldBeNil)
+			parsed, err := ParseTypedPublicKey(signer.KeyType(), signer.TypedPubKey().Serialize())
+			So(err, ShouldBeNil)
+			So(parsed.VerifyBytes(h, sig), ShouldBeTrue)
+			// signature of one key type never verifies with the other
+			So(verifiers[1-i].VerifyBytes(h, sig), ShouldBeFalse)
+		}
+
+		So(TypedPublicKeyEqual(edPublic, edPrivate.TypedPubKey()), ShouldBeTrue)
+		So(TypedPublicKeyEqual(secpPublic, edPublic), ShouldBeFalse)
+		So(TypedPublicKeyEqual((*PublicKey)(nil), nil), ShouldBeTrue)
+		So(TypedPublicKeyEqual(secpPublic, (*PublicKey)(nil)), ShouldBeFalse)
+	})
+	Convey("key type yaml", t, func() {
+		for _, kt := range []KeyType{Secp256k1, Ed25519} {
+			out, err := yaml.Marshal(kt)
+			So(err, ShouldBeNil)
+			var unmarshaled KeyType
+			So(yaml.Unmarshal(out, &unmarshaled), ShouldBeNil)
+			So(unmarshaled, ShouldEqual, kt)
+		}
+		_, err := ParseKeyType("rsa")
+		So(err, ShouldEqual, ErrUnknownKeyType)
+		So(KeyType(99).String(), ShouldEqual, "KeyType(99)")
+	})
+}
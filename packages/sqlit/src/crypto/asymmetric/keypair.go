@@ -176,6 +176,17 @@ func GetPubKeyNonce(
 	timeThreshold time.Duration,
 	quit chan struct{}) (nonce mine.NonceInfo) {
 
+	return GetTypedPubKeyNonce(publicKey, difficulty, timeThreshold, quit)
+}
+
+// GetTypedPubKeyNonce is GetPubKeyNonce for public key of any key type, the node
+// id is derived from the serialized public key in the same way for all key types.
+func GetTypedPubKeyNonce(
+	publicKey TypedPublicKey,
+	difficulty int,
+	timeThreshold time.Duration,
+	quit chan struct{}) (nonce mine.NonceInfo) {
+
 	miner := mine.NewCPUMiner(quit)
 	nonceCh := make(chan mine.NonceInfo)
 	// if miner finished his work before timeThreshold
@@ -0,0 +1,159 @@
+
+package asymmetric
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// KeyType is the signature algorithm of a key pair.
+type KeyType uint8
+
+const (
+	// Secp256k1 is the koblitz curve key type, it is the default key type.
+	Secp256k1 KeyType = iota
+	// Ed25519 is the edwards curve key type.
+	Ed25519
+)
+
+var (
+	// ErrUnknownKeyType indicates the key type is not supported
+	ErrUnknownKeyType = errors.New("unknown key type")
+)
+
+// TypedPublicKey is the interface implemented by public keys of all supported key types.
+type TypedPublicKey interface {
+	// KeyType returns the key type.
+	KeyType() KeyType
+	// Serialize returns the serialized public key, the node id is derived from it.
+	Serialize() []byte
+	// VerifyBytes returns if sig is a valid signature of hash in the key type format.
+	VerifyBytes(hash, sig []byte) bool
+}
+
+// TypedPrivateKey is the interface implemented by private keys of all supported key types.
+type TypedPrivateKey interface {
+	// KeyType returns the key type.
+	KeyType() KeyType
+	// SignBytes signs hash and returns the signature in the key type format.
+	SignBytes(hash []byte) ([]byte, error)
+	// TypedPubKey returns the public key.
+	TypedPubKey() TypedPublicKey
+}
+
+// String implements fmt.Stringer.
+func (t KeyType) String() string {
+	switch t {
+	case Secp256k1:
+		return "secp256k1"
+	case Ed25519:
+		return "ed25519"
+	default:
+		return fmt.Sprintf("KeyType(%d)", uint8(t))
+	}
+}
+
+// ParseKeyType parses the key type name.
+func ParseKeyType(s string) (t KeyType, err error) {
+	switch strings.ToLower(s) {
+	case "", "secp256k1":
+		t = Secp256k1
+	case "ed25519":
+		t = Ed25519
+	default:
+		err = ErrUnknownKeyType
+	}
+	return
+}
+
+// MarshalYAML implements the yaml.Marshaler interface.
+func (t KeyType) MarshalYAML() (interface{}, error) {
+	return t.String(), nil
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (t *KeyType) UnmarshalYAML(unmarshal func(interface{}) error) (err error) {
+	var str string
+	if err = unmarshal(&str); err != nil {
+		return
+	}
+	*t, err = ParseKeyType(str)
+	return
+}
+
+// ParseTypedPublicKey recovers the public key of keyType from keyBytes.
+func ParseTypedPublicKey(keyType KeyType, keyBytes []byte) (key TypedPublicKey, err error) {
+	switch keyType {
+	case Secp256k1:
+		var pub *PublicKey
+		if pub, err = ParsePubKey(keyBytes); err == nil {
+			key = pub
+		}
+	case Ed25519:
+		var pub Ed25519PublicKey
+		if pub, err = ParseEd25519PubKey(keyBytes); err == nil {
+			key = pub
+		}
+	default:
+		err = ErrUnknownKeyType
+	}
+	return
+}
+
+// KeyType implements TypedPublicKey.KeyType.
+func (k *PublicKey) KeyType() KeyType {
+	return Secp256k1
+}
+
+// VerifyBytes implements TypedPublicKey.VerifyBytes, sig is in DER format.
+func (k *PublicKey) VerifyBytes(hash, sig []byte) bool {
+	s, err := ParseSignature(sig)
+	if err != nil {
+		return false
+	}
+	return s.Verify(hash, k)
+}
+
+// KeyType implements TypedPrivateKey.KeyType.
+func (private *PrivateKey) KeyType() KeyType {
+	return Secp256k1
+}
+
+// SignBytes implements TypedPrivateKey.SignBytes, the signature is in DER format.
+func (private *PrivateKey) SignBytes(hash []byte) (sig []byte, err error) {
+	var s *Signature
+	if s, err = private.Sign(hash); err != nil {
+		return
+	}
+	sig = s.Serialize()
+	return
+}
+
+// TypedPubKey implements TypedPrivateKey.TypedPubKey.
+func (private *PrivateKey) TypedPubKey() TypedPublicKey {
+	return private.PubKey()
+}
+
+// IsNilTypedPublicKey returns if key is nil or wraps a nil key.
+func IsNilTypedPublicKey(key TypedPublicKey) bool {
+	switch k := key.(type) {
+	case nil:
+		return true
+	case *PublicKey:
+		return k == nil
+	case Ed25519PublicKey:
+		return len(k) == 0
+	}
+	return false
+}
+
+// TypedPublicKeyEqual returns if a and b are the same key of the same key type, two
+// nil keys are equal.
+func TypedPublicKeyEqual(a, b TypedPublicKey) bool {
+	if IsNilTypedPublicKey(a) || IsNilTypedPublicKey(b) {
+		return IsNilTypedPublicKey(a) && IsNilTypedPublicKey(b)
+	}
+	return a.KeyType() == b.KeyType() && bytes.Equal(a.Serialize(), b.Serialize())
+}
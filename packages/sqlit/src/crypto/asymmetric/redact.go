@@ -0,0 +1,26 @@
+
+package asymmetric
+
+import (
+	"sqlit/src/crypto/hash"
+	"sqlit/src/utils/log"
+)
+
+// the private keys are never logged, the public keys are logged as fingerprints if
+// log.SetRedactPublic is enabled
+func init() {
+	log.RegisterSensitive((*PrivateKey)(nil), nil)
+	log.RegisterSensitive(Ed25519PrivateKey(nil), nil)
+	log.RegisterPublic((*PublicKey)(nil), publicKeyFingerprint)
+	log.RegisterPublic(Ed25519PublicKey(nil), publicKeyFingerprint)
+}
+
+// publicKeyFingerprint renders a TypedPublicKey by the short hash of its serialized
+// form like kms does.
+func publicKeyFingerprint(v interface{}) string {
+	key, ok := v.(TypedPublicKey)
+	if !ok {
+		return log.Redacted
+	}
+	return "pubkey:" + hash.THashH(key.Serialize()).Short(8)
+}
@@ -0,0 +1,41 @@
+
+package asymmetric
+
+import (
+	"encoding/hex"
+	"fmt"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"sqlit/src/crypto/hash"
+	"sqlit/src/utils/log"
+)
+
+func TestRedactKeys(t *testing.T) {
+	Convey("the private keys are never logged and the public keys are fingerprinted", t, func() {
+		defer log.SetRedactPublic(false)
+		private, public, err := GenSecp256k1KeyPair()
+		So(err, ShouldBeNil)
+		edPrivate, edPublic, err := GenEd25519KeyPair()
+		So(err, ShouldBeNil)
+		keys := struct {
+			Private   *PrivateKey
+			Public    *PublicKey
+			EdPrivate Ed25519PrivateKey
+			EdPublic  Ed25519PublicKey
+		}{private, public, edPrivate, edPublic}
+
+		out := fmt.Sprintf("%#v", log.Redact(keys))
+		So(out, ShouldContainSubstring, "Private:"+log.Redacted)
+		So(out, ShouldContainSubstring, "EdPrivate:"+log.Redacted)
+		So(out, ShouldNotContainSubstring, fmt.Sprintf("%#v", private.D))
+		So(fmt.Sprintf("%v", log.Redact(edPrivate)), ShouldEqual, log.Redacted)
+
+		log.SetRedactPublic(true)
+		out = fmt.Sprintf("%+v", log.Redact(keys))
+		So(out, ShouldContainSubstring, "Public:pubkey:"+hash.THashH(public.Serialize()).Short(8))
+		So(out, ShouldContainSubstring, "EdPublic:pubkey:"+hash.THashH(edPublic.Serialize()).Short(8))
+		So(out, ShouldNotContainSubstring, hex.EncodeToString(edPublic[:8]))
+	})
+}
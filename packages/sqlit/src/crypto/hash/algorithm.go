@@ -0,0 +1,212 @@
+
+package hash
+
+import (
+	"crypto/sha256"
+	"errors"
+	gohash "hash"
+	"sort"
+	"strings"
+	"sync"
+
+	blake2b "github.com/minio/blake2b-simd"
+)
+
+// Algorithm identifies a digest algorithm, it is the prefix of the string form of
+// a Digest.
+type Algorithm string
+
+const (
+	// AlgorithmTHash is sha256(blake2b-512(b)), the THashH algorithm.
+	AlgorithmTHash Algorithm = "thash"
+	// AlgorithmSHA256 is sha256(b), the HashH algorithm.
+	AlgorithmSHA256 Algorithm = "sha256"
+	// AlgorithmDoubleSHA256 is sha256(sha256(b)), the DoubleHashH algorithm.
+	AlgorithmDoubleSHA256 Algorithm = "sha256d"
+	// AlgorithmBlake2b256 is blake2b-256(b).
+	AlgorithmBlake2b256 Algorithm = "blake2b256"
+
+	// DefaultAlgorithm is the algorithm of THashH and NewHasher.
+	DefaultAlgorithm = AlgorithmTHash
+)
+
+// digestSeparator separates the algorithm and the hex digest in the string form of a Digest.
+const digestSeparator = ":"
+
+var (
+	// ErrUnknownAlgorithm indicates the algorithm is not registered.
+	ErrUnknownAlgorithm = errors.New("unknown hash algorithm")
+	// ErrInvalidAlgorithm indicates the algorithm name or digest size is invalid.
+	ErrInvalidAlgorithm = errors.New("invalid hash algorithm")
+	// ErrAlgorithmExists indicates the algorithm is already registered.
+	ErrAlgorithmExists = errors.New("hash algorithm already registered")
+	// ErrInvalidDigest indicates the digest string is not "algorithm:hex" of HashSize bytes.
+	ErrInvalidDigest = errors.New("invalid digest string")
+)
+
+var (
+	algorithmsLock sync.RWMutex
+	algorithms     = map[Algorithm]func() gohash.Hash{
+		AlgorithmTHash:        newTHash,
+		AlgorithmSHA256:       sha256.New,
+		AlgorithmDoubleSHA256: newDoubleSHA256,
+		AlgorithmBlake2b256:   blake2b.New256,
+	}
+)
+
+// RegisterAlgorithm registers a digest algorithm of HashSize bytes, name must be
+// lowercase letters and digits.
+func RegisterAlgorithm(alg Algorithm, newFunc func() gohash.Hash) error {
+	if newFunc == nil || !isValidAlgorithmName(string(alg)) || newFunc().Size() != HashSize {
+		return ErrInvalidAlgorithm
+	}
+	algorithmsLock.Lock()
+	defer algorithmsLock.Unlock()
+	if _, ok := algorithms[alg]; ok {
+		return ErrAlgorithmExists
+	}
+	algorithms[alg] = newFunc
+	return nil
+}
+
+// Algorithms returns the registered algorithms sorted by name.
+func Algorithms() (algs []Algorithm) {
+	algorithmsLock.RLock()
+	defer algorithmsLock.RUnlock()
+	for alg := range algorithms {
+		algs = append(algs, alg)
+	}
+	sort.Slice(algs, func(i, j int) bool { return algs[i] < algs[j] })
+	return
+}
+
+// New returns a new hash.Hash of the algorithm.
+func (alg Algorithm) New() (h gohash.Hash, err error) {
+	var newFunc func() gohash.Hash
+	if newFunc, err = algorithmFunc(alg); err != nil {
+		return
+	}
+	return newFunc(), nil
+}
+
+// algorithmFunc returns the constructor of the registered algorithm.
+func algorithmFunc(alg Algorithm) (newFunc func() gohash.Hash, err error) {
+	algorithmsLock.RLock()
+	newFunc, ok := algorithms[alg]
+	algorithmsLock.RUnlock()
+	if !ok {
+		return nil, ErrUnknownAlgorithm
+	}
+	return
+}
+
+// Sum returns the digest of b computed by the algorithm.
+func (alg Algorithm) Sum(b []byte) (d Digest, err error) {
+	var h *Hasher
+	if h, err = NewHasherWithAlgorithm(alg); err != nil {
+		return
+	}
+	_, _ = h.Write(b)
+	return h.Digest(), nil
+}
+
+// Digest is a Hash with the algorithm computed it.
+type Digest struct {
+	Algorithm Algorithm
+	Hash      Hash
+}
+
+// String returns the self-describing "algorithm:hex" form of the digest, the hex
+// is the same as Hash.String.
+func (d Digest) String() string {
+	return string(d.Algorithm) + digestSeparator + d.Hash.String()
+}
+
+// Verify reports whether d is the digest of b, false is returned if the algorithm
+// is not registered.
+func (d Digest) Verify(b []byte) bool {
+	expected, err := d.Algorithm.Sum(b)
+	return err == nil && expected.Hash == d.Hash
+}
+
+// ParseDigest parses the string form of a Digest. A string without the algorithm
+// prefix is a DefaultAlgorithm digest. Unlike NewHashFromStr, the hex must be of
+// exactly HashSize bytes.
+func ParseDigest(s string) (d Digest, err error) {
+	d.Algorithm = DefaultAlgorithm
+	if i := strings.Index(s, digestSeparator); i >= 0 {
+		d.Algorithm, s = Algorithm(s[:i]), s[i+len(digestSeparator):]
+	}
+	if _, err = d.Algorithm.New(); err != nil {
+		return
+	}
+	if len(s) != MaxHashStringSize || Decode(&d.Hash, s) != nil {
+		return d, ErrInvalidDigest
+	}
+	return
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (d Digest) MarshalText() ([]byte, error) {
+	return []byte(d.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (d *Digest) UnmarshalText(text []byte) (err error) {
+	*d, err = ParseDigest(string(text))
+	return
+}
+
+func isValidAlgorithmName(name string) bool {
+	if len(name) == 0 {
+		return false
+	}
+	for i := 0; i < len(name); i++ {
+		if c := name[i]; (c < '0' || c > '9') && (c < 'a' || c > 'z') {
+			return false
+		}
+	}
+	return true
+}
+
+// sumBufPool holds the scratch buffers of the inner digests, the buffer passed to
+// an interface Sum escapes so it is reused instead of allocated by each call.
+var sumBufPool = sync.Pool{
+	New: func() interface{} { return new([blake2b.Size]byte) },
+}
+
+// tHash is the streaming sha256(blake2b-512(b)).
+type tHash struct {
+	gohash.Hash
+}
+
+func newTHash() gohash.Hash {
+	return tHash{blake2b.New512()}
+}
+
+func (h tHash) Size() int {
+	return sha256.Size
+}
+
+func (h tHash) Sum(b []byte) []byte {
+	buf := sumBufPool.Get().(*[blake2b.Size]byte)
+	defer sumBufPool.Put(buf)
+	second := sha256.Sum256(h.Hash.Sum(buf[:0]))
+	return append(b, second[:]...)
+}
+
+// doubleSHA256 is the streaming sha256(sha256(b)).
+type doubleSHA256 struct {
+	gohash.Hash
+}
+
+func newDoubleSHA256() gohash.Hash {
+	return doubleSHA256{sha256.New()}
+}
+
+func (h doubleSHA256) Sum(b []byte) []byte {
+	buf := sumBufPool.Get().(*[blake2b.Size]byte)
+	defer sumBufPool.Put(buf)
+	second := sha256.Sum256(h.Hash.Sum(buf[:0]))
+	return append(b, second[:]...)
+}
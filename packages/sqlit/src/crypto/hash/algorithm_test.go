@@ -0,0 +1,110 @@
+
+package hash
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/json"
+	gohash "hash"
+	"strings"
+	"testing"
+
+	blake2b "github.com/minio/blake2b-simd"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAlgorithm(t *testing.T) {
+	data := []byte("SEE YOU SPACE COWBOY")
+
+	Convey("builtin algorithms", t, func() {
+		So(Algorithms(), ShouldResemble, []Algorithm{
+			AlgorithmBlake2b256, AlgorithmSHA256, AlgorithmDoubleSHA256, AlgorithmTHash,
+		})
+		for alg, sum := range map[Algorithm]Hash{
+			AlgorithmTHash:        THashH(data),
+			AlgorithmSHA256:       HashH(data),
+			AlgorithmDoubleSHA256: DoubleHashH(data),
+			AlgorithmBlake2b256:   Hash(blake2b.Sum256(data)),
+		} {
+			d, err := alg.Sum(data)
+			So(err, ShouldBeNil)
+			So(d.Algorithm, ShouldEqual, alg)
+			So(d.Hash, ShouldResemble, sum)
+			So(d.Verify(data), ShouldBeTrue)
+			So(d.Verify(data[1:]), ShouldBeFalse)
+
+			// streaming
+			h, err := NewHasherWithAlgorithm(alg)
+			So(err, ShouldBeNil)
+			So(h.Algorithm(), ShouldEqual, alg)
+			writeChunks(h, data, 1)
+			So(h.Digest(), ShouldResemble, d)
+		}
+		So(NewHasher().Algorithm(), ShouldEqual, DefaultAlgorithm)
+	})
+	Convey("digest string form", t, func() {
+		d, err := AlgorithmSHA256.Sum(data)
+		So(err, ShouldBeNil)
+		s := d.String()
+		So(s, ShouldEqual, "sha256:"+d.Hash.String())
+
+		parsed, err := ParseDigest(s)
+		So(err, ShouldBeNil)
+		So(parsed, ShouldResemble, d)
+		So(parsed.Verify(data), ShouldBeTrue)
+
+		// hash without prefix is of the default algorithm
+		h := THashH(data)
+		parsed, err = ParseDigest(h.String())
+		So(err, ShouldBeNil)
+		So(parsed, ShouldResemble, Digest{Algorithm: DefaultAlgorithm, Hash: h})
+		legacy, err := NewHashFromStr(h.String())
+		So(err, ShouldBeNil)
+		So(*legacy, ShouldResemble, parsed.Hash)
+
+		out, err := json.Marshal(map[string]Digest{"d": d})
+		So(err, ShouldBeNil)
+		So(string(out), ShouldEqual, `{"d":"`+s+`"}`)
+		var decoded map[string]Digest
+		So(json.Unmarshal(out, &decoded), ShouldBeNil)
+		So(decoded["d"], ShouldResemble, d)
+
+		_, err = ParseDigest("sha3:" + d.Hash.String())
+		So(err, ShouldEqual, ErrUnknownAlgorithm)
+		_, err = ParseDigest(":" + d.Hash.String())
+		So(err, ShouldEqual, ErrUnknownAlgorithm)
+		for _, invalid := range []string{
+			"sha256:" + d.Hash.String()[:62],
+			"sha256:" + d.Hash.String() + "00",
+			"sha256:" + strings.Repeat("x", 64),
+			"sha256:sha256:" + d.Hash.String(),
+			"",
+		} {
+			_, err = ParseDigest(invalid)
+			So(err, ShouldEqual, ErrInvalidDigest)
+		}
+		So(Digest{Algorithm: "sha3"}.Verify(data), ShouldBeFalse)
+	})
+	Convey("register algorithm", t, func() {
+		defer func() {
+			algorithmsLock.Lock()
+			delete(algorithms, "sha512t256")
+			algorithmsLock.Unlock()
+		}()
+		So(RegisterAlgorithm(AlgorithmSHA256, sha256.New), ShouldEqual, ErrAlgorithmExists)
+		So(RegisterAlgorithm("SHA512", sha512.New512_256), ShouldEqual, ErrInvalidAlgorithm)
+		So(RegisterAlgorithm("sha512", sha512.New), ShouldEqual, ErrInvalidAlgorithm)
+		So(RegisterAlgorithm("sha512", nil), ShouldEqual, ErrInvalidAlgorithm)
+		So(RegisterAlgorithm("", sha512.New512_256), ShouldEqual, ErrInvalidAlgorithm)
+
+		_, err := NewHasherWithAlgorithm("sha512t256")
+		So(err, ShouldEqual, ErrUnknownAlgorithm)
+		So(RegisterAlgorithm("sha512t256", func() gohash.Hash { return sha512.New512_256() }), ShouldBeNil)
+		d, err := Algorithm("sha512t256").Sum(data)
+		So(err, ShouldBeNil)
+		So(d.Hash, ShouldResemble, Hash(sha512.Sum512_256(data)))
+		parsed, err := ParseDigest(d.String())
+		So(err, ShouldBeNil)
+		So(parsed.Verify(data), ShouldBeTrue)
+	})
+}
@@ -0,0 +1,115 @@
+
+package hash
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/btcsuite/btcutil/base58"
+	"github.com/btcsuite/btcutil/bech32"
+)
+
+// The base58 and bech32 encodings are for humans and external tools only, the
+// canonical form of a Hash is still its hexadecimal String. All of them encode the
+// bytes in the order of String, so a Hash with leading zero digits keeps them
+// leading in every encoding.
+
+var (
+	// ErrInvalidBase58Hash indicates the string is not the base58 encoding of a Hash.
+	ErrInvalidBase58Hash = errors.New("invalid base58 hash")
+	// ErrInvalidBech32Prefix indicates the human-readable prefix is not valid in bech32.
+	ErrInvalidBech32Prefix = errors.New("invalid bech32 prefix")
+	// ErrBech32PrefixMismatch indicates the bech32 string has another human-readable prefix.
+	ErrBech32PrefixMismatch = errors.New("bech32 prefix mismatch")
+	// ErrInvalidBech32Hash indicates the bech32 string does not hold a Hash.
+	ErrInvalidBech32Hash = errors.New("invalid bech32 hash")
+)
+
+// maxBech32PrefixSize is the longest prefix fitting a Hash in the 90 characters of a
+// bech32 string, with the separator, the 52 characters of data and the 6 of checksum.
+const maxBech32PrefixSize = 31
+
+// reversed returns the bytes of h in the order of String.
+func (h Hash) reversed() (b []byte) {
+	b = make([]byte, HashSize)
+	for i := range h {
+		b[i] = h[HashSize-1-i]
+	}
+	return
+}
+
+// setReversed sets h from b in the order of String, b must be of HashSize.
+func (h *Hash) setReversed(b []byte) {
+	for i := range h {
+		h[i] = b[HashSize-1-i]
+	}
+}
+
+// Base58 returns the Hash as the base58 string of the byte-reversed hash.
+func (h Hash) Base58() string {
+	return base58.Encode(h.reversed())
+}
+
+// NewHashFromBase58 creates a Hash from the string of Hash.Base58.
+func NewHashFromBase58(s string) (*Hash, error) {
+	// an invalid character is decoded as empty
+	b := base58.Decode(s)
+	if len(b) != HashSize {
+		return nil, ErrInvalidBase58Hash
+	}
+	ret := new(Hash)
+	ret.setReversed(b)
+	return ret, nil
+}
+
+// Bech32 returns the Hash as the bech32 string of the byte-reversed hash with the
+// human-readable prefix hrp, which must be lowercase printable ASCII.
+func (h Hash) Bech32(hrp string) (s string, err error) {
+	if err = validateBech32Prefix(hrp); err != nil {
+		return
+	}
+	var data []byte
+	if data, err = bech32.ConvertBits(h.reversed(), 8, 5, true); err != nil {
+		return
+	}
+	return bech32.Encode(hrp, data)
+}
+
+// NewHashFromBech32 creates a Hash from the string of Hash.Bech32 with the prefix
+// hrp, the string is either all lowercase or all uppercase. A string failing the
+// checksum is an error.
+func NewHashFromBech32(hrp, s string) (*Hash, error) {
+	if err := validateBech32Prefix(hrp); err != nil {
+		return nil, err
+	}
+	prefix, data, err := bech32.Decode(s)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidBech32Hash, err)
+	}
+	if prefix != hrp {
+		return nil, fmt.Errorf("%w: got %q, want %q", ErrBech32PrefixMismatch, prefix, hrp)
+	}
+	b, err := bech32.ConvertBits(data, 5, 8, false)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidBech32Hash, err)
+	}
+	if len(b) != HashSize {
+		return nil, ErrInvalidBech32Hash
+	}
+	ret := new(Hash)
+	ret.setReversed(b)
+	return ret, nil
+}
+
+func validateBech32Prefix(hrp string) error {
+	if len(hrp) == 0 || len(hrp) > maxBech32PrefixSize || hrp != strings.ToLower(hrp) {
+		return ErrInvalidBech32Prefix
+	}
+	for i := 0; i < len(hrp); i++ {
+		if hrp[i] < 33 || hrp[i] > 126 {
+			return ErrInvalidBech32Prefix
+		}
+	}
+	return nil
+}
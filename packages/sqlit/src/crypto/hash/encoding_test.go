@@ -0,0 +1,83 @@
+
+package hash
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btcutil/bech32"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestHashEncodings(t *testing.T) {
+	hashes := []Hash{{}, mainNetGenesisHash, THashH([]byte("encodings"))}
+	var full Hash
+	for i := range full {
+		full[i] = 0xff
+	}
+	hashes = append(hashes, full)
+
+	Convey("the base58 strings round trip to the same bytes", t, func() {
+		for _, h := range hashes {
+			s := h.Base58()
+			parsed, err := NewHashFromBase58(s)
+			So(err, ShouldBeNil)
+			So(*parsed, ShouldResemble, h)
+		}
+		// the leading zero digits of String stay leading
+		So(strings.HasPrefix(mainNetGenesisHash.Base58(), "11111"), ShouldBeTrue)
+		So(len(mainNetGenesisHash.Base58()), ShouldBeLessThan, len(mainNetGenesisHash.String()))
+
+		for _, s := range []string{"", "0OIl", mainNetGenesisHash.Base58()[1:], mainNetGenesisHash.Base58() + "2"} {
+			_, err := NewHashFromBase58(s)
+			So(err, ShouldEqual, ErrInvalidBase58Hash)
+		}
+	})
+	Convey("the bech32 strings round trip to the same bytes", t, func() {
+		for _, h := range hashes {
+			s, err := h.Bech32("test")
+			So(err, ShouldBeNil)
+			So(s, ShouldStartWith, "test1")
+			So(len(s), ShouldBeLessThanOrEqualTo, 90)
+			parsed, err := NewHashFromBech32("test", s)
+			So(err, ShouldBeNil)
+			So(*parsed, ShouldResemble, h)
+			parsed, err = NewHashFromBech32("test", strings.ToUpper(s))
+			So(err, ShouldBeNil)
+			So(*parsed, ShouldResemble, h)
+		}
+		long := strings.Repeat("a", maxBech32PrefixSize)
+		s, err := mainNetGenesisHash.Bech32(long)
+		So(err, ShouldBeNil)
+		So(len(s), ShouldEqual, 90)
+		for _, hrp := range []string{"", long + "a", "Test", "te st"} {
+			_, err = mainNetGenesisHash.Bech32(hrp)
+			So(err, ShouldEqual, ErrInvalidBech32Prefix)
+		}
+	})
+	Convey("the invalid bech32 strings are errors", t, func() {
+		s, err := mainNetGenesisHash.Bech32("test")
+		So(err, ShouldBeNil)
+
+		// a changed character fails the checksum
+		c := byte('q')
+		if s[10] == c {
+			c = 'p'
+		}
+		_, err = NewHashFromBech32("test", s[:10]+string(c)+s[11:])
+		So(errors.Is(err, ErrInvalidBech32Hash), ShouldBeTrue)
+		So(err.Error(), ShouldContainSubstring, "checksum")
+
+		_, err = NewHashFromBech32("other", s)
+		So(errors.Is(err, ErrBech32PrefixMismatch), ShouldBeTrue)
+
+		// the data is not of a hash
+		data, err := bech32.ConvertBits(mainNetGenesisHash[:HashSize/2], 8, 5, true)
+		So(err, ShouldBeNil)
+		short, err := bech32.Encode("test", data)
+		So(err, ShouldBeNil)
+		_, err = NewHashFromBech32("test", short)
+		So(err, ShouldEqual, ErrInvalidBech32Hash)
+	})
+}
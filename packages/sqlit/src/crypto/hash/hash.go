@@ -2,6 +2,7 @@
 package hash
 
 import (
+	"crypto/subtle"
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
@@ -29,22 +30,22 @@ type Hash [HashSize]byte
 // String returns the Hash as the hexadecimal string of the byte-reversed
 // hash.
 func (h Hash) String() string {
-	for i := 0; i < HashSize/2; i++ {
-		h[i], h[HashSize-1-i] = h[HashSize-1-i], h[i]
-	}
-	return hex.EncodeToString(h[:])
+	return h.Short(HashSize)
 }
 
 // Short returns the hexadecimal string of the first `n` reversed byte(s).
 func (h Hash) Short(n int) string {
-	for i := 0; i < HashSize/2; i++ {
-		h[i], h[HashSize-1-i] = h[HashSize-1-i], h[i]
-	}
 	var l = HashSize
 	if n < l {
 		l = n
 	}
-	return hex.EncodeToString(h[:l])
+	// encode on the stack, the string conversion is the only allocation
+	var buf [MaxHashStringSize]byte
+	for i := 0; i < l; i++ {
+		b := h[HashSize-1-i]
+		buf[2*i], buf[2*i+1] = hexDigits[b>>4], hexDigits[b&0x0f]
+	}
+	return string(buf[:2*l])
 }
 
 // AsBytes returns internal bytes of hash.
@@ -98,6 +99,12 @@ func (h *Hash) IsEqual(target *Hash) bool {
 	return *h == *target
 }
 
+// ConstantTimeEqual returns true if other is the same as hash, the time taken is
+// independent of the contents, so it is safe for authentication checks.
+func (h Hash) ConstantTimeEqual(other Hash) bool {
+	return subtle.ConstantTimeCompare(h[:], other[:]) == 1
+}
+
 // Difficulty returns the leading Zero **bit** count of Hash in binary.
 //  return -1 indicate the Hash pointer is nil.
 func (h *Hash) Difficulty() (difficulty int) {
@@ -185,22 +192,34 @@ func Decode(dst *Hash, src string) error {
 		return ErrHashStrSize
 	}
 
-	// Hex decoder expects the hash to be a multiple of two.  When not, pad
-	// with a leading zero.
-	var srcBytes []byte
-	if len(src)%2 == 0 {
-		srcBytes = []byte(src)
-	} else {
-		srcBytes = make([]byte, 1+len(src))
-		srcBytes[0] = '0'
-		copy(srcBytes[1:], src)
-	}
-
-	// Hex decode the source bytes to a temporary destination.
-	var reversedHash Hash
-	_, err := hex.Decode(reversedHash[HashSize-hex.DecodedLen(len(srcBytes)):], srcBytes)
-	if err != nil {
-		return err
+	// Hex decode the string in place to a temporary destination, an odd length
+	// string is decoded as if padded with a leading zero.  The errors are the
+	// hex.InvalidByteError of hex.Decode.
+	var (
+		reversedHash Hash
+		off          = HashSize - (len(src)+1)/2
+		i            int
+	)
+	if len(src)%2 == 1 {
+		lo, ok := fromHexChar(src[0])
+		if !ok {
+			return hex.InvalidByteError(src[0])
+		}
+		reversedHash[off] = lo
+		off++
+		i++
+	}
+	for ; i < len(src); i += 2 {
+		hi, ok := fromHexChar(src[i])
+		if !ok {
+			return hex.InvalidByteError(src[i])
+		}
+		lo, ok := fromHexChar(src[i+1])
+		if !ok {
+			return hex.InvalidByteError(src[i+1])
+		}
+		reversedHash[off] = hi<<4 | lo
+		off++
 	}
 
 	// Reverse copy from the temporary hash to destination.  Because the
@@ -211,3 +230,19 @@ func Decode(dst *Hash, src string) error {
 
 	return nil
 }
+
+const hexDigits = "0123456789abcdef"
+
+// fromHexChar converts a hex character into its value, the same characters are
+// accepted as by hex.Decode.
+func fromHexChar(c byte) (byte, bool) {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0', true
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10, true
+	case 'A' <= c && c <= 'F':
+		return c - 'A' + 10, true
+	}
+	return 0, false
+}
@@ -295,3 +295,131 @@ func TestHash_MarshalJSON(t *testing.T) {
 		So(unmarshalAndMarshalJSON(`"02c76216704d797c64c58bc11519fb68582e8e63de7e5b3b2dbbbe8733efe5fd"`), ShouldEqual, `"02c76216704d797c64c58bc11519fb68582e8e63de7e5b3b2dbbbe8733efe5fd"`)
 	})
 }
+
+func TestHash_ConstantTimeEqual(t *testing.T) {
+	Convey("constant time equal agrees with ==", t, func() {
+		var first, last Hash
+		first[0] = 0x01
+		last[HashSize-1] = 0x01
+		for _, pair := range [][2]Hash{
+			{mainNetGenesisHash, mainNetGenesisHash},
+			{mainNetGenesisHash, first},
+			{first, last},
+			{last, last},
+			{{}, {}},
+			{{}, first},
+		} {
+			So(pair[0].ConstantTimeEqual(pair[1]), ShouldEqual, pair[0] == pair[1])
+			So(pair[1].ConstantTimeEqual(pair[0]), ShouldEqual, pair[0] == pair[1])
+		}
+	})
+}
+
+// legacyDecode is the Decode before the allocation free rewrite, the reference of
+// FuzzDecode.
+func legacyDecode(dst *Hash, src string) error {
+	if len(src) > MaxHashStringSize {
+		return ErrHashStrSize
+	}
+	var srcBytes []byte
+	if len(src)%2 == 0 {
+		srcBytes = []byte(src)
+	} else {
+		srcBytes = make([]byte, 1+len(src))
+		srcBytes[0] = '0'
+		copy(srcBytes[1:], src)
+	}
+	var reversedHash Hash
+	_, err := hex.Decode(reversedHash[HashSize-hex.DecodedLen(len(srcBytes)):], srcBytes)
+	if err != nil {
+		return err
+	}
+	for i, b := range reversedHash[:HashSize/2] {
+		dst[i], dst[HashSize-1-i] = reversedHash[HashSize-1-i], b
+	}
+	return nil
+}
+
+// legacyShort is the Short before the allocation free rewrite.
+func legacyShort(h Hash, n int) string {
+	for i := 0; i < HashSize/2; i++ {
+		h[i], h[HashSize-1-i] = h[HashSize-1-i], h[i]
+	}
+	var l = HashSize
+	if n < l {
+		l = n
+	}
+	return hex.EncodeToString(h[:l])
+}
+
+func FuzzDecode(f *testing.F) {
+	f.Add("000000000019d6689c085ae165831e934ff763ae46a2a6c172b3f1b60a8ce26f")
+	f.Add("19d6689c085ae165831e934ff763ae46a2a6c172b3f1b60a8ce26")
+	f.Add("")
+	f.Add("1")
+	f.Add("ABCdef")
+	f.Add("abcdefg")
+	f.Add("g1")
+	f.Add(strings.Repeat("f", MaxHashStringSize+1))
+	f.Fuzz(func(t *testing.T, src string) {
+		var got, want Hash
+		got[0], want[0] = 0xaa, 0xaa
+		gotErr, wantErr := Decode(&got, src), legacyDecode(&want, src)
+		if gotErr != wantErr || got != want {
+			t.Fatalf("decode %q: got %v %v, want %v %v", src, got, gotErr, want, wantErr)
+		}
+		for _, n := range []int{0, 1, 4, HashSize, HashSize + 1} {
+			if s, legacy := got.Short(n), legacyShort(got, n); s != legacy {
+				t.Fatalf("short %d of %q: got %q, want %q", n, src, s, legacy)
+			}
+		}
+		if wantErr == nil {
+			if _, err := NewHashFromStr(got.String()); err != nil {
+				t.Fatalf("round trip of %q failed: %v", src, err)
+			}
+		}
+	})
+}
+
+func TestHashAllocs(t *testing.T) {
+	if raceEnabled {
+		t.Skip("allocations are not stable with the race detector")
+	}
+	const s = "000000000019d6689c085ae165831e934ff763ae46a2a6c172b3f1b60a8ce26f"
+	var (
+		h      Hash
+		hasher = NewHasher()
+		data   = []byte("SEE YOU SPACE COWBOY")
+	)
+	for name, f := range map[string]func(){
+		"Decode":       func() { _ = Decode(&h, s) },
+		"THashH":       func() { h = THashH(data) },
+		"Hasher.Sum":   func() { h = hasher.Sum() },
+		"merkle nodes": func() { h = mergeMerkleNodes(&h, &h) },
+	} {
+		if allocs := testing.AllocsPerRun(100, f); allocs != 0 {
+			t.Errorf("%s allocates %v times per call", name, allocs)
+		}
+	}
+	// the string itself
+	if allocs := testing.AllocsPerRun(100, func() { _ = h.String() }); allocs != 1 {
+		t.Errorf("String allocates %v times per call", allocs)
+	}
+}
+
+func BenchmarkNewHashFromStr(b *testing.B) {
+	const s = "000000000019d6689c085ae165831e934ff763ae46a2a6c172b3f1b60a8ce26f"
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := NewHashFromStr(s); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkHashString(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = mainNetGenesisHash.String()
+	}
+}
@@ -0,0 +1,58 @@
+
+package hash
+
+import (
+	gohash "hash"
+
+	blake2b "github.com/minio/blake2b-simd"
+)
+
+// Hasher computes the digest of the content written to it, so large payloads can
+// be hashed without being buffered in memory. A Hasher is not safe for concurrent use.
+type Hasher struct {
+	alg Algorithm
+	h   gohash.Hash
+}
+
+// NewHasher returns a new Hasher of DefaultAlgorithm, i.e. THashH.
+func NewHasher() *Hasher {
+	return &Hasher{alg: DefaultAlgorithm, h: newTHash()}
+}
+
+// NewHasherWithAlgorithm returns a new Hasher of the registered algorithm.
+func NewHasherWithAlgorithm(alg Algorithm) (h *Hasher, err error) {
+	var inner gohash.Hash
+	if inner, err = alg.New(); err != nil {
+		return
+	}
+	return &Hasher{alg: alg, h: inner}, nil
+}
+
+// Write implements io.Writer, it never returns an error.
+func (h *Hasher) Write(p []byte) (n int, err error) {
+	return h.h.Write(p)
+}
+
+// Sum returns the digest of the content written so far, it does not change the
+// state of the Hasher.
+func (h *Hasher) Sum() (sum Hash) {
+	buf := sumBufPool.Get().(*[blake2b.Size]byte)
+	defer sumBufPool.Put(buf)
+	copy(sum[:], h.h.Sum(buf[:0]))
+	return
+}
+
+// Digest returns Sum with the algorithm of the Hasher.
+func (h *Hasher) Digest() Digest {
+	return Digest{Algorithm: h.alg, Hash: h.Sum()}
+}
+
+// Algorithm returns the algorithm of the Hasher.
+func (h *Hasher) Algorithm() Algorithm {
+	return h.alg
+}
+
+// Reset resets the Hasher to its initial state.
+func (h *Hasher) Reset() {
+	h.h.Reset()
+}
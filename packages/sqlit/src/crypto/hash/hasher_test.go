@@ -0,0 +1,89 @@
+
+package hash
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// writeChunks writes data to h in random sized chunks derived from seed.
+func writeChunks(h *Hasher, data []byte, seed int64) {
+	r := rand.New(rand.NewSource(seed))
+	for len(data) > 0 {
+		n := r.Intn(len(data) + 1)
+		_, _ = h.Write(data[:n])
+		data = data[n:]
+	}
+}
+
+func TestHasher(t *testing.T) {
+	Convey("streaming hasher equals THashH", t, func() {
+		h := NewHasher()
+		So(h.Sum(), ShouldResemble, THashH(nil))
+
+		for _, size := range []int{1, 127, 128, 129, 4096, 3 << 20} {
+			data := make([]byte, size)
+			rand.Read(data)
+			h.Reset()
+			writeChunks(h, data, int64(size))
+			So(h.Sum(), ShouldResemble, THashH(data))
+			// Sum does not change the state
+			So(h.Sum(), ShouldResemble, THashH(data))
+			_, _ = h.Write([]byte("more"))
+			So(h.Sum(), ShouldResemble, THashH(append(data, "more"...)))
+		}
+
+		h.Reset()
+		n, err := io.Copy(h, bytes.NewReader([]byte("SEE YOU SPACE COWBOY")))
+		So(err, ShouldBeNil)
+		So(n, ShouldEqual, 20)
+		So(h.Sum(), ShouldResemble, THashH([]byte("SEE YOU SPACE COWBOY")))
+	})
+}
+
+func FuzzHasher(f *testing.F) {
+	f.Add([]byte{}, int64(0))
+	f.Add([]byte("abc"), int64(1))
+	f.Add(bytes.Repeat([]byte{0xff}, 1000), int64(2))
+	f.Fuzz(func(t *testing.T, data []byte, seed int64) {
+		h := NewHasher()
+		writeChunks(h, data, seed)
+		if h.Sum() != THashH(data) {
+			t.Fatalf("streaming hash mismatch for %d bytes with seed %d", len(data), seed)
+		}
+	})
+}
+
+func BenchmarkHasherSum(b *testing.B) {
+	h := NewHasher()
+	_, _ = h.Write([]byte("SEE YOU SPACE COWBOY"))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		h.Sum()
+	}
+}
+
+func BenchmarkHasher(b *testing.B) {
+	data := make([]byte, 4<<20)
+	rand.Read(data)
+	b.SetBytes(int64(len(data)))
+	b.Run("THashH", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			THashH(data)
+		}
+	})
+	b.Run("Hasher", func(b *testing.B) {
+		h := NewHasher()
+		for i := 0; i < b.N; i++ {
+			h.Reset()
+			for off := 0; off < len(data); off += 64 << 10 {
+				_, _ = h.Write(data[off : off+64<<10])
+			}
+			h.Sum()
+		}
+	})
+}
@@ -0,0 +1,42 @@
+
+package hash
+
+import (
+	"crypto/hmac"
+	"crypto/subtle"
+	gohash "hash"
+)
+
+// hmacPrefix prefixes the algorithm of a keyed Hasher, e.g. "hmacthash". The HMAC
+// algorithms are not registered, a Digest of one is verified by VerifyHMAC only.
+const hmacPrefix = "hmac"
+
+// NewHMAC returns a keyed Hasher of HMAC over DefaultAlgorithm, the nodes sharing
+// key compute the same digests. The key is not copied.
+func NewHMAC(key []byte) *Hasher {
+	return &Hasher{alg: hmacPrefix + DefaultAlgorithm, h: hmac.New(newTHash, key)}
+}
+
+// NewHMACWithAlgorithm returns a keyed Hasher of HMAC over the registered algorithm.
+func NewHMACWithAlgorithm(alg Algorithm, key []byte) (h *Hasher, err error) {
+	var newFunc func() gohash.Hash
+	if newFunc, err = algorithmFunc(alg); err != nil {
+		return
+	}
+	return &Hasher{alg: hmacPrefix + alg, h: hmac.New(newFunc, key)}, nil
+}
+
+// VerifyHMAC reports whether mac is the NewHMAC digest of data with key, the
+// comparison takes constant time.
+func VerifyHMAC(key, data, mac []byte) bool {
+	h := NewHMAC(key)
+	_, _ = h.Write(data)
+	return h.Equal(mac)
+}
+
+// Equal reports whether mac is the digest of the content written so far, the
+// comparison takes constant time. It verifies a streamed keyed Hasher.
+func (h *Hasher) Equal(mac []byte) bool {
+	sum := h.Sum()
+	return subtle.ConstantTimeCompare(sum[:], mac) == 1
+}
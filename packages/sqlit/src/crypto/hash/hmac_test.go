@@ -0,0 +1,70 @@
+
+package hash
+
+import (
+	"encoding/hex"
+	"math/rand"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestHMAC(t *testing.T) {
+	Convey("keyed digests match the test vectors", t, func() {
+		// HMAC over THashH, fixed so the nodes of different versions agree
+		for _, v := range []struct{ key, data, mac string }{
+			{"key", "The quick brown fox jumps over the lazy dog",
+				"a581eab7bd1abb951bde22c5a923f546f00fb7e40ddb0833815d7b2aaef8e8f5"},
+			{"", "", "0cd73e29dc2412797bd4ab2cde105781e4888bb88e7c7e0010edf2728d7b4222"},
+			{"sqlit-shared-secret", "SEE YOU SPACE COWBOY",
+				"efa3de3f68f18d694deebf510121d6eef7f504043a4c6b6d989ad2768f08df3f"},
+		} {
+			h := NewHMAC([]byte(v.key))
+			_, _ = h.Write([]byte(v.data))
+			sum := h.Sum()
+			So(hex.EncodeToString(sum[:]), ShouldEqual, v.mac)
+			mac, _ := hex.DecodeString(v.mac)
+			So(VerifyHMAC([]byte(v.key), []byte(v.data), mac), ShouldBeTrue)
+		}
+		So(NewHMAC(nil).Algorithm(), ShouldEqual, Algorithm("hmacthash"))
+
+		// RFC 4231 test case 2 and the well known HMAC-SHA256 vector
+		for _, v := range []struct{ key, data, mac string }{
+			{"Jefe", "what do ya want for nothing?",
+				"5bdcc146bf60754e6a042426089575c75a003f089d2739839dec58b964ec3843"},
+			{"key", "The quick brown fox jumps over the lazy dog",
+				"f7bc83f430538424b13298e6aa6fb143ef4d59a14946175997479dbc2d1a3cd8"},
+		} {
+			h, err := NewHMACWithAlgorithm(AlgorithmSHA256, []byte(v.key))
+			So(err, ShouldBeNil)
+			So(h.Algorithm(), ShouldEqual, Algorithm("hmacsha256"))
+			_, _ = h.Write([]byte(v.data))
+			sum := h.Sum()
+			So(hex.EncodeToString(sum[:]), ShouldEqual, v.mac)
+		}
+		_, err := NewHMACWithAlgorithm("sha512t256", []byte("key"))
+		So(err, ShouldEqual, ErrUnknownAlgorithm)
+	})
+	Convey("a wrong key or content fails the verification", t, func() {
+		key, data := []byte("shared secret"), make([]byte, 1<<20)
+		rand.Read(data)
+		h := NewHMAC(key)
+		writeChunks(h, data, 1)
+		mac := h.Sum()
+		So(h.Equal(mac[:]), ShouldBeTrue)
+		So(VerifyHMAC(key, data, mac[:]), ShouldBeTrue)
+
+		So(VerifyHMAC([]byte("other secret"), data, mac[:]), ShouldBeFalse)
+		So(VerifyHMAC(key, data[1:], mac[:]), ShouldBeFalse)
+		So(VerifyHMAC(key, data, mac[:HashSize-1]), ShouldBeFalse)
+		So(VerifyHMAC(key, data, nil), ShouldBeFalse)
+		// a keyed digest is not the plain digest
+		plain := THashH(data)
+		So(VerifyHMAC(key, data, plain[:]), ShouldBeFalse)
+
+		// reset starts over with the same key
+		h.Reset()
+		writeChunks(h, data, 2)
+		So(h.Equal(mac[:]), ShouldBeTrue)
+	})
+}
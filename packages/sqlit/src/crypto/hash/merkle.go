@@ -0,0 +1,96 @@
+
+package hash
+
+import (
+	"errors"
+)
+
+// ErrMerkleIndexOutOfRange indicates the leaf index is not in the merkle tree.
+var ErrMerkleIndexOutOfRange = errors.New("merkle leaf index out of range")
+
+// MerkleTree is a binary merkle tree of THashH(left || right). When a level has
+// an odd number of nodes, the last node is paired with itself, the same rule as
+// src/merkle and bitcoin. The root of a single leaf tree is the leaf and the
+// root of an empty tree is the zero Hash.
+//
+// NOTE: as a consequence of the duplication rule, leaves [a, b, c] and
+// [a, b, c, c] have the same root, so the leaf count must be committed elsewhere.
+type MerkleTree struct {
+	// levels[0] holds the leaves, the last level holds the root
+	levels [][]Hash
+}
+
+// NewMerkleTree builds the merkle tree of leaves.
+func NewMerkleTree(leaves []Hash) *MerkleTree {
+	t := &MerkleTree{}
+	if len(leaves) == 0 {
+		return t
+	}
+	level := append([]Hash(nil), leaves...)
+	t.levels = append(t.levels, level)
+	for len(level) > 1 {
+		next := make([]Hash, (len(level)+1)/2)
+		for i := range next {
+			left := &level[2*i]
+			right := left
+			if 2*i+1 < len(level) {
+				right = &level[2*i+1]
+			}
+			next[i] = mergeMerkleNodes(left, right)
+		}
+		t.levels = append(t.levels, next)
+		level = next
+	}
+	return t
+}
+
+// Root returns the merkle root.
+func (t *MerkleTree) Root() (root Hash) {
+	if len(t.levels) == 0 {
+		return
+	}
+	return t.levels[len(t.levels)-1][0]
+}
+
+// Proof returns the sibling hashes from the leaf at index up to the root.
+func (t *MerkleTree) Proof(index int) (proof []Hash, err error) {
+	if len(t.levels) == 0 || index < 0 || index >= len(t.levels[0]) {
+		return nil, ErrMerkleIndexOutOfRange
+	}
+	proof = make([]Hash, 0, len(t.levels)-1)
+	for _, level := range t.levels[:len(t.levels)-1] {
+		sibling := index ^ 1
+		if sibling >= len(level) {
+			// odd node is paired with itself
+			sibling = index
+		}
+		proof = append(proof, level[sibling])
+		index /= 2
+	}
+	return
+}
+
+// VerifyMerkleProof reports whether proof proves leaf is at index of the merkle
+// tree with root.
+func VerifyMerkleProof(root, leaf Hash, proof []Hash, index int) bool {
+	if index < 0 || (len(proof) < 63 && index>>uint(len(proof)) != 0) {
+		return false
+	}
+	h := leaf
+	for i := range proof {
+		if index&1 == 0 {
+			h = mergeMerkleNodes(&h, &proof[i])
+		} else {
+			h = mergeMerkleNodes(&proof[i], &h)
+		}
+		index >>= 1
+	}
+	return h == root
+}
+
+func mergeMerkleNodes(left, right *Hash) Hash {
+	var buf [HashSize * 2]byte
+	copy(buf[:HashSize], left[:])
+	copy(buf[HashSize:], right[:])
+	return THashH(buf[:])
+}
@@ -0,0 +1,81 @@
+
+package hash
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+// merkleRoots are the roots of the trees of leaves THashH({0}), THashH({1}), ...
+var merkleRoots = []string{
+	"84abe04728122bb74525c5a9a187e24e07dd54f87d07ad015e64646af8c05a22",
+	"0d81527172c01bc51cc4deef5ebb6ae81b12cf2b5a8335b3536e2680c235ec63",
+	"9b00fdc199d5436d4218383d4c08906c351ab340ea841e57b85155350835c371",
+	"ea8d65181860361863e7ed74d83655521450e305b3949e3ec669894ada60020c",
+	"7327b78ed9011bc00b8e56f703f7d6ddabf06171c3c5d1ab253201e1714fc8cf",
+	"65d480f5c79bf4934396d6c81c3aa445671baacac3028a17c79c77baaf65982b",
+	"0637ff9a38eee4c5226707f632008904f0db137dcc3e489e80226a8cb075f27b",
+	"d65359617d428bf94b5d54535bc8fe32675f0e971322d76df6594e7691ff6664",
+	"d395be3093b84bce2ed0d2ebcf8c23cb3999ba4f38f62f81ca0973df0292c95f",
+}
+
+func merkleLeaves(n int) (leaves []Hash) {
+	for i := 0; i < n; i++ {
+		leaves = append(leaves, THashH([]byte{byte(i)}))
+	}
+	return
+}
+
+func TestMerkleTree(t *testing.T) {
+	Convey("empty tree has zero root", t, func() {
+		tree := NewMerkleTree(nil)
+		So(tree.Root(), ShouldResemble, Hash{})
+		_, err := tree.Proof(0)
+		So(err, ShouldEqual, ErrMerkleIndexOutOfRange)
+		So(VerifyMerkleProof(Hash{}, Hash{}, nil, 0), ShouldBeTrue)
+	})
+	Convey("merkle roots and proofs of test vectors", t, func() {
+		for i, want := range merkleRoots {
+			leaves := merkleLeaves(i + 1)
+			tree := NewMerkleTree(leaves)
+			root := tree.Root()
+			So(root.String(), ShouldEqual, want)
+
+			for index, leaf := range leaves {
+				proof, err := tree.Proof(index)
+				So(err, ShouldBeNil)
+				So(VerifyMerkleProof(root, leaf, proof, index), ShouldBeTrue)
+				// wrong leaf, index or root
+				So(VerifyMerkleProof(root, THashH([]byte("x")), proof, index), ShouldBeFalse)
+				if len(proof) > 0 && proof[0] != leaf {
+					// a duplicated odd node verifies on both sides
+					So(VerifyMerkleProof(root, leaf, proof, index^1), ShouldBeFalse)
+				}
+				So(VerifyMerkleProof(THashH(nil), leaf, proof, index), ShouldBeFalse)
+				So(VerifyMerkleProof(root, leaf, proof, -1), ShouldBeFalse)
+				So(VerifyMerkleProof(root, leaf, proof, index+1<<uint(len(proof))), ShouldBeFalse)
+			}
+			_, err := tree.Proof(len(leaves))
+			So(err, ShouldEqual, ErrMerkleIndexOutOfRange)
+			_, err = tree.Proof(-1)
+			So(err, ShouldEqual, ErrMerkleIndexOutOfRange)
+		}
+	})
+	Convey("odd node is paired with itself", t, func() {
+		leaves := merkleLeaves(3)
+		So(NewMerkleTree(leaves).Root(), ShouldResemble, NewMerkleTree(append(leaves, leaves[2])).Root())
+		l01 := mergeMerkleNodes(&leaves[0], &leaves[1])
+		l22 := mergeMerkleNodes(&leaves[2], &leaves[2])
+		So(NewMerkleTree(leaves).Root(), ShouldResemble, mergeMerkleNodes(&l01, &l22))
+		So(NewMerkleTree(leaves[:1]).Root(), ShouldResemble, leaves[0])
+	})
+	Convey("input leaves are not modified", t, func() {
+		leaves := merkleLeaves(5)
+		copied := append([]Hash(nil), leaves...)
+		tree := NewMerkleTree(leaves)
+		leaves[0] = Hash{}
+		So(tree.Root().String(), ShouldEqual, merkleRoots[4])
+		So(leaves[1:], ShouldResemble, copied[1:])
+	})
+}
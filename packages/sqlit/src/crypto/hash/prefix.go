@@ -0,0 +1,80 @@
+
+package hash
+
+import (
+	"errors"
+)
+
+var (
+	// ErrInvalidHashPrefix indicates the hash prefix is empty, too long or not lowercase hex.
+	ErrInvalidHashPrefix = errors.New("invalid hash prefix")
+	// ErrHashPrefixNotFound indicates no hash matches the prefix.
+	ErrHashPrefixNotFound = errors.New("no hash matches prefix")
+	// ErrAmbiguousHashPrefix indicates more than one hash matches the prefix.
+	ErrAmbiguousHashPrefix = errors.New("ambiguous hash prefix")
+)
+
+// MatchPrefix returns the distinct candidates whose String() starts with prefix,
+// in the order of candidates. The prefix must be 1 to MaxHashStringSize lowercase
+// hex characters, an odd length prefix matches the high half of the last byte.
+func MatchPrefix(prefix string, candidates []Hash) (matches []Hash, err error) {
+	if err = validatePrefix(prefix); err != nil {
+		return
+	}
+	seen := make(map[Hash]struct{})
+	for _, h := range candidates {
+		if _, ok := seen[h]; ok || !h.hasPrefix(prefix) {
+			continue
+		}
+		seen[h] = struct{}{}
+		matches = append(matches, h)
+	}
+	return
+}
+
+// MatchUniquePrefix returns the only candidate whose String() starts with prefix,
+// ErrAmbiguousHashPrefix is returned if more than one candidate matches.
+func MatchUniquePrefix(prefix string, candidates []Hash) (h Hash, err error) {
+	var matches []Hash
+	if matches, err = MatchPrefix(prefix, candidates); err != nil {
+		return
+	}
+	switch len(matches) {
+	case 0:
+		err = ErrHashPrefixNotFound
+	case 1:
+		h = matches[0]
+	default:
+		err = ErrAmbiguousHashPrefix
+	}
+	return
+}
+
+func validatePrefix(prefix string) error {
+	if len(prefix) == 0 || len(prefix) > MaxHashStringSize {
+		return ErrInvalidHashPrefix
+	}
+	for i := 0; i < len(prefix); i++ {
+		if c := prefix[i]; (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return ErrInvalidHashPrefix
+		}
+	}
+	return nil
+}
+
+// hasPrefix reports whether the byte-reversed hex string of h starts with the
+// validated prefix, without encoding the whole hash.
+func (h *Hash) hasPrefix(prefix string) bool {
+	for i := 0; i < len(prefix); i++ {
+		b := h[HashSize-1-i/2]
+		if i%2 == 0 {
+			b >>= 4
+		} else {
+			b &= 0x0f
+		}
+		if "0123456789abcdef"[b] != prefix[i] {
+			return false
+		}
+	}
+	return true
+}
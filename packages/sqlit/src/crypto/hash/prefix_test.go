@@ -0,0 +1,80 @@
+
+package hash
+
+import (
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestMatchPrefix(t *testing.T) {
+	mustHash := func(s string) Hash {
+		h, err := NewHashFromStr(s)
+		So(err, ShouldBeNil)
+		return *h
+	}
+
+	Convey("match hash prefix", t, func() {
+		h1 := mustHash("00000000000f5a12fb4ef2d6bb53706b43e7b4b5b17f6c87de3c0fe7e0fa2c4b")
+		h2 := mustHash("00000000000f5a9b1cb5f4139e0b3f1e0e4e7e2a9c3d2f1e0d9c8b7a69584736")
+		h3 := mustHash("abcdef0123456789abcdef0123456789abcdef0123456789abcdef0123456789")
+		candidates := []Hash{h1, h2, h3, h1}
+
+		matches, err := MatchPrefix("00000000000f5a", candidates)
+		So(err, ShouldBeNil)
+		So(matches, ShouldResemble, []Hash{h1, h2})
+
+		matches, err = MatchPrefix("00000000000f5a1", candidates)
+		So(err, ShouldBeNil)
+		So(matches, ShouldResemble, []Hash{h1})
+
+		// odd length prefix matches the high half of a byte only
+		matches, err = MatchPrefix("abcde", candidates)
+		So(err, ShouldBeNil)
+		So(matches, ShouldResemble, []Hash{h3})
+		matches, err = MatchPrefix("abcdf", candidates)
+		So(err, ShouldBeNil)
+		So(matches, ShouldBeEmpty)
+
+		matches, err = MatchPrefix(h2.String(), candidates)
+		So(err, ShouldBeNil)
+		So(matches, ShouldResemble, []Hash{h2})
+
+		for _, prefix := range candidates {
+			for n := 1; n <= MaxHashStringSize; n++ {
+				matches, err = MatchPrefix(prefix.String()[:n], candidates)
+				So(err, ShouldBeNil)
+				So(matches, ShouldContain, prefix)
+			}
+		}
+
+		Convey("unique match", func() {
+			h, err := MatchUniquePrefix("00000000000f5a9", candidates)
+			So(err, ShouldBeNil)
+			So(h, ShouldResemble, h2)
+			// duplicated candidates are not ambiguous
+			h, err = MatchUniquePrefix("000000000", []Hash{h1, h1})
+			So(err, ShouldBeNil)
+			So(h, ShouldResemble, h1)
+
+			_, err = MatchUniquePrefix("00000000000f5a", candidates)
+			So(err, ShouldEqual, ErrAmbiguousHashPrefix)
+			_, err = MatchUniquePrefix("ff", candidates)
+			So(err, ShouldEqual, ErrHashPrefixNotFound)
+			_, err = MatchUniquePrefix("00", nil)
+			So(err, ShouldEqual, ErrHashPrefixNotFound)
+		})
+		Convey("invalid prefix", func() {
+			for _, prefix := range []string{
+				"", "ABCDEF", "0x00", "abcdeg", "00 00", strings.Repeat("0", MaxHashStringSize+1),
+			} {
+				matches, err := MatchPrefix(prefix, candidates)
+				So(matches, ShouldBeNil)
+				So(err, ShouldEqual, ErrInvalidHashPrefix)
+				_, err = MatchUniquePrefix(prefix, candidates)
+				So(err, ShouldEqual, ErrInvalidHashPrefix)
+			}
+		})
+	})
+}
@@ -0,0 +1,8 @@
+//go:build race
+// +build race
+
+package hash
+
+// raceEnabled is set by the race detector builds, where sync.Pool drops items at
+// random so the allocations are not counted.
+const raceEnabled = true
@@ -0,0 +1,158 @@
+
+package kms
+
+import (
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"sqlit/src/proto"
+	"sqlit/src/utils/log"
+)
+
+// AuditOp is the key access operation of an AuditEvent.
+type AuditOp string
+
+const (
+	// AuditGetPrivateKey is the operation of getting the local private key to sign.
+	AuditGetPrivateKey AuditOp = "get_private_key"
+	// AuditSetNode is the operation of setting a node in public keystore.
+	AuditSetNode AuditOp = "set_node"
+	// AuditDelNode is the operation of removing a node from public keystore.
+	AuditDelNode AuditOp = "del_node"
+	// AuditSetLocalNodeIDNonce is the operation of setting the local node id and nonce.
+	AuditSetLocalNodeIDNonce AuditOp = "set_local_node_id_nonce"
+	// AuditRevokeNode is the operation of adding a node to the revocation list.
+	AuditRevokeNode AuditOp = "revoke_node"
+	// AuditUnrevokeNode is the operation of removing a node from the revocation list.
+	AuditUnrevokeNode AuditOp = "unrevoke_node"
+)
+
+// AuditQueueSize is the count of audit events queued for the sink, events are
+// dropped if the sink falls behind.
+const AuditQueueSize = 1024
+
+// AuditEvent is a key access event, Caller is the first function out of package
+// kms in the call stack as "function file:line".
+type AuditEvent struct {
+	Time   time.Time
+	Op     AuditOp
+	NodeID proto.NodeID
+	Caller string
+}
+
+// AuditSink receives the audit events, it is called by a single goroutine in the
+// order of the events, so a slow sink never blocks the key access.
+type AuditSink interface {
+	Audit(ev *AuditEvent)
+}
+
+// AuditSinkFunc adapts a func to AuditSink.
+type AuditSinkFunc func(ev *AuditEvent)
+
+// Audit implements AuditSink.Audit.
+func (f AuditSinkFunc) Audit(ev *AuditEvent) {
+	f(ev)
+}
+
+// LogAuditSink is the AuditSink writing events to utils/log.
+type LogAuditSink struct{}
+
+// Audit implements AuditSink.Audit.
+func (LogAuditSink) Audit(ev *AuditEvent) {
+	log.WithFields(log.Fields{
+		"at":     ev.Time.Format(time.RFC3339Nano),
+		"op":     ev.Op,
+		"node":   ev.NodeID,
+		"caller": ev.Caller,
+	}).WithModule("kms").Info("key access audit")
+}
+
+var (
+	auditSink     AuditSink = LogAuditSink{}
+	auditSinkLock sync.RWMutex
+	auditQueue    chan *AuditEvent
+	auditOnce     sync.Once
+	auditDropped  uint64
+)
+
+// SetAuditSink sets the sink of key access audit events, nil disables auditing.
+func SetAuditSink(sink AuditSink) {
+	auditSinkLock.Lock()
+	defer auditSinkLock.Unlock()
+	auditSink = sink
+}
+
+// GetAuditSink gets the sink of key access audit events, LogAuditSink by default.
+func GetAuditSink() AuditSink {
+	auditSinkLock.RLock()
+	defer auditSinkLock.RUnlock()
+	return auditSink
+}
+
+// AuditDropped returns the count of audit events dropped as the queue was full.
+func AuditDropped() uint64 {
+	return atomic.LoadUint64(&auditDropped)
+}
+
+// audit queues an event of op on id without blocking, caller should be the
+// function of package kms being audited.
+func audit(op AuditOp, id proto.NodeID) {
+	if GetAuditSink() == nil {
+		return
+	}
+	auditOnce.Do(func() {
+		auditQueue = make(chan *AuditEvent, AuditQueueSize)
+		go runAuditSink(auditQueue)
+	})
+	ev := &AuditEvent{
+		Time:   time.Now(),
+		Op:     op,
+		NodeID: id,
+		Caller: auditCaller(),
+	}
+	select {
+	case auditQueue <- ev:
+	default:
+		atomic.AddUint64(&auditDropped, 1)
+	}
+}
+
+func runAuditSink(queue <-chan *AuditEvent) {
+	for ev := range queue {
+		if sink := GetAuditSink(); sink != nil {
+			deliverAuditEvent(sink, ev)
+		}
+	}
+}
+
+// deliverAuditEvent calls sink, a panic of sink is logged instead of crashing.
+func deliverAuditEvent(sink AuditSink, ev *AuditEvent) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.WithField("op", ev.Op).WithModule("kms").Errorf("audit sink panic: %v", r)
+		}
+	}()
+	sink.Audit(ev)
+}
+
+// auditCaller returns the first caller out of package kms, the unit tests of kms
+// count as callers out of it.
+func auditCaller() string {
+	const kmsPrefix = "sqlit/src/crypto/kms."
+	var pcs [16]uintptr
+	// skip runtime.Callers, auditCaller and audit
+	frames := runtime.CallersFrames(pcs[:runtime.Callers(3, pcs[:])])
+	for {
+		frame, more := frames.Next()
+		if !strings.HasPrefix(frame.Function, kmsPrefix) || strings.HasSuffix(frame.File, "_test.go") {
+			return frame.Function + " " + frame.File + ":" + strconv.Itoa(frame.Line)
+		}
+		if !more {
+			return "unknown"
+		}
+	}
+}
@@ -0,0 +1,127 @@
+
+package kms
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"sqlit/src/crypto/asymmetric"
+	"sqlit/src/proto"
+)
+
+func TestAudit(t *testing.T) {
+	defer SetAuditSink(GetAuditSink())
+	Convey("key access is audited", t, func() {
+		ResetLocalKeyStore()
+		defer ResetLocalKeyStore()
+		ClosePublicKeyStore()
+		defer ClosePublicKeyStore()
+
+		events := make(chan *AuditEvent, 16)
+		SetAuditSink(AuditSinkFunc(func(ev *AuditEvent) { events <- ev }))
+		next := func() (ev *AuditEvent) {
+			select {
+			case ev = <-events:
+			case <-time.After(5 * time.Second):
+			}
+			return
+		}
+
+		private, public, _ := asymmetric.GenSecp256k1KeyPair()
+		nonce := asymmetric.GetPubKeyNonce(public, 1, 50*time.Millisecond, nil)
+		nodeID := proto.NodeID(nonce.Hash.String())
+		SetLocalKeyPair(private, public)
+		SetLocalNodeIDNonce(nonce.Hash.CloneBytes(), &nonce.Nonce)
+		ev := next()
+		So(ev, ShouldNotBeNil)
+		So(ev.Op, ShouldEqual, AuditSetLocalNodeIDNonce)
+		So(ev.NodeID, ShouldEqual, nodeID)
+		So(ev.Caller, ShouldContainSubstring, "audit_test.go")
+		So(time.Since(ev.Time), ShouldBeLessThan, time.Minute)
+
+		// the key type is read without the private key
+		keyType, err := GetLocalKeyType()
+		So(err, ShouldBeNil)
+		So(keyType, ShouldEqual, asymmetric.Secp256k1)
+
+		// the caller is out of kms even if the key is got by a kms signer
+		_, err = GetLocalPrivateKey()
+		So(err, ShouldBeNil)
+		_, err = (&FileKeyProvider{}).Sign(make([]byte, 32))
+		So(err, ShouldBeNil)
+		for i := 0; i < 2; i++ {
+			ev = next()
+			So(ev, ShouldNotBeNil)
+			So(ev.Op, ShouldEqual, AuditGetPrivateKey)
+			So(ev.NodeID, ShouldEqual, nodeID)
+			So(ev.Caller, ShouldContainSubstring, "TestAudit")
+		}
+
+		So(InitPublicKeyStore(filepath.Join(t.TempDir(), "audit.keystore"), nil), ShouldBeNil)
+		So(SetNode(&proto.Node{ID: nodeID, PublicKey: public, Nonce: nonce.Nonce}), ShouldBeNil)
+		So(DelNode(nodeID), ShouldBeNil)
+		for _, op := range []AuditOp{AuditSetNode, AuditDelNode} {
+			ev = next()
+			So(ev, ShouldNotBeNil)
+			So(ev.Op, ShouldEqual, op)
+			So(ev.NodeID, ShouldEqual, nodeID)
+		}
+	})
+	Convey("a failing sink never blocks or crashes the key access", t, func() {
+		ResetLocalKeyStore()
+		defer ResetLocalKeyStore()
+		private, public, _ := asymmetric.GenSecp256k1KeyPair()
+		SetLocalKeyPair(private, public)
+
+		SetAuditSink(AuditSinkFunc(func(ev *AuditEvent) { panic("sink failure") }))
+		_, err := GetLocalPrivateKey()
+		So(err, ShouldBeNil)
+		// the sink goroutine survives the panic
+		events := make(chan *AuditEvent, 2)
+		SetAuditSink(AuditSinkFunc(func(ev *AuditEvent) { events <- ev }))
+		_, err = GetLocalPrivateKey()
+		So(err, ShouldBeNil)
+		select {
+		case ev := <-events:
+			So(ev.Op, ShouldEqual, AuditGetPrivateKey)
+		case <-time.After(5 * time.Second):
+			So("no audit event after a sink panic", ShouldBeEmpty)
+		}
+
+		release := make(chan struct{})
+		SetAuditSink(AuditSinkFunc(func(ev *AuditEvent) { <-release }))
+		dropped := AuditDropped()
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for i := 0; i < 2*AuditQueueSize; i++ {
+				_, _ = GetLocalPrivateKey()
+			}
+		}()
+		select {
+		case <-done:
+		case <-time.After(10 * time.Second):
+		}
+		So(isClosed(done), ShouldBeTrue)
+		So(AuditDropped(), ShouldBeGreaterThan, dropped)
+
+		// drop the queued events
+		SetAuditSink(nil)
+		close(release)
+		for len(auditQueue) > 0 {
+			time.Sleep(time.Millisecond)
+		}
+	})
+}
+
+func isClosed(ch chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,98 @@
+
+package kms
+
+import (
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+
+	"sqlit/src/proto"
+)
+
+var boltBucket = []byte("kms")
+
+// BoltStore is a Store of public keystore backed by a bbolt file, every write
+// is committed in a fsync'ed transaction so a crash never leaves a half-written file.
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// OpenBoltStore opens the bbolt file at path, creates it if not exist.
+func OpenBoltStore(path string) (s *BoltStore, err error) {
+	var db *bolt.DB
+	if db, err = bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second}); err != nil {
+		return
+	}
+	if err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltBucket)
+		return err
+	}); err != nil {
+		_ = db.Close()
+		return
+	}
+	s = &BoltStore{db: db}
+	return
+}
+
+// Get implements Store.Get.
+func (s *BoltStore) Get(id proto.NodeID) (value []byte, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(boltBucket).Get([]byte(id))
+		if v == nil {
+			return ErrKeyNotFound
+		}
+		// value is only valid in the transaction
+		value = append([]byte(nil), v...)
+		return nil
+	})
+	return
+}
+
+// Put implements Store.Put.
+func (s *BoltStore) Put(id proto.NodeID, value []byte) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).Put([]byte(id), value)
+	})
+}
+
+// Delete implements Store.Delete.
+func (s *BoltStore) Delete(id proto.NodeID) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).Delete([]byte(id))
+	})
+}
+
+// Range implements Store.Range.
+func (s *BoltStore) Range(fn func(id proto.NodeID, value []byte) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).ForEach(func(k, v []byte) error {
+			return fn(proto.NodeID(k), append([]byte(nil), v...))
+		})
+	})
+}
+
+// PutAll implements BatchStore.PutAll.
+func (s *BoltStore) PutAll(entries map[proto.NodeID][]byte, replace bool) error {
+	return s.db.Update(func(tx *bolt.Tx) (err error) {
+		b := tx.Bucket(boltBucket)
+		if replace {
+			if err = tx.DeleteBucket(boltBucket); err != nil {
+				return
+			}
+			if b, err = tx.CreateBucket(boltBucket); err != nil {
+				return
+			}
+		}
+		for id, value := range entries {
+			if err = b.Put([]byte(id), value); err != nil {
+				return
+			}
+		}
+		return
+	})
+}
+
+// Close implements Store.Close.
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
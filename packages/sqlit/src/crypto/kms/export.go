@@ -0,0 +1,247 @@
+
+package kms
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"sort"
+
+	"github.com/pkg/errors"
+
+	"sqlit/src/crypto/asymmetric"
+	mine "sqlit/src/pow/cpuminer"
+	"sqlit/src/proto"
+)
+
+// exportedNode is the portable JSON format of proto.Node, keys and nonce are hex encoded.
+type exportedNode struct {
+	ID         proto.NodeID      `json:"id"`
+	Role       string            `json:"role"`
+	Addr       string            `json:"addr"`
+	Addrs      []string          `json:"addrs,omitempty"`
+	DirectAddr string            `json:"direct_addr,omitempty"`
+	Region     string            `json:"region,omitempty"`
+	Zone       string            `json:"zone,omitempty"`
+	Tags       map[string]string `json:"tags,omitempty"`
+	KeyType    string            `json:"key_type"`
+	PublicKey  string            `json:"public_key"`
+	Nonce      string            `json:"nonce"`
+
+	Capabilities proto.Capabilities `json:"capabilities,omitempty"`
+	Weight       int                `json:"weight,omitempty"`
+}
+
+// exportedStore is the JSON format of ExportPublicKeyStore WithRevoked, the nodes
+// are the list exported without it.
+type exportedStore struct {
+	Nodes   []*exportedNode `json:"nodes"`
+	Revoked []proto.NodeID  `json:"revoked"`
+}
+
+type exportOptions struct {
+	revoked bool
+}
+
+// ExportOpt represents extra options to apply in ExportPublicKeyStore.
+type ExportOpt func(*exportOptions)
+
+// WithRevoked makes ExportPublicKeyStore include the revocation list, the JSON is an
+// object of the nodes list and the revoked node ids.
+func WithRevoked() ExportOpt {
+	return func(o *exportOptions) {
+		o.revoked = true
+	}
+}
+
+// ExportPublicKeyStore writes all the nodes in public keystore to w as JSON sorted by node id,
+// the revoked nodes are left out.
+func ExportPublicKeyStore(w io.Writer, opts ...ExportOpt) (err error) {
+	var o exportOptions
+	for _, opt := range opts {
+		if opt != nil {
+			opt(&o)
+		}
+	}
+
+	pksLock.RLock()
+	if pks == nil || pks.store == nil {
+		pksLock.RUnlock()
+		return ErrPKSNotInitialized
+	}
+	nodes := make([]*proto.Node, 0, len(pks.cache))
+	for _, n := range pks.cache {
+		if !IsRevoked(n.ID) {
+			nodes = append(nodes, n)
+		}
+	}
+	pksLock.RUnlock()
+
+	exported := make([]*exportedNode, 0, len(nodes))
+	for _, n := range nodes {
+		var en *exportedNode
+		if en, err = exportNode(n); err != nil {
+			return
+		}
+		exported = append(exported, en)
+	}
+	sort.Slice(exported, func(i, j int) bool {
+		return exported[i].ID < exported[j].ID
+	})
+
+	var doc interface{} = exported
+	if o.revoked {
+		doc = &exportedStore{Nodes: exported, Revoked: RevokedNodes()}
+	}
+	var out []byte
+	if out, err = json.MarshalIndent(doc, "", "  "); err != nil {
+		err = errors.Wrap(err, "marshal public keystore failed")
+		return
+	}
+	if _, err = w.Write(append(out, '\n')); err != nil {
+		err = errors.Wrap(err, "write public keystore failed")
+	}
+	return
+}
+
+// ImportPublicKeyStore reads the JSON written by ExportPublicKeyStore from r and sets the
+// nodes into public keystore, the existing nodes are removed first unless merge is true.
+// Every node is validated first, the keystore is unchanged if any node is invalid.
+// The revoked nodes of an export WithRevoked are added to the revocation list, the
+// existing revocations are kept whatever merge is.
+func ImportPublicKeyStore(r io.Reader, merge bool) (err error) {
+	var raw json.RawMessage
+	if err = json.NewDecoder(r).Decode(&raw); err != nil {
+		err = errors.Wrap(err, "unmarshal public keystore failed")
+		return
+	}
+	var store exportedStore
+	if trimmed := bytes.TrimSpace(raw); len(trimmed) > 0 && trimmed[0] == '{' {
+		err = json.Unmarshal(raw, &store)
+	} else {
+		err = json.Unmarshal(raw, &store.Nodes)
+	}
+	if err != nil {
+		err = errors.Wrap(err, "unmarshal public keystore failed")
+		return
+	}
+	exported := store.Nodes
+	revoked := make(map[proto.NodeID]struct{}, len(store.Revoked))
+	for _, id := range store.Revoked {
+		if isLocalNode(id) {
+			return errors.Wrapf(ErrRevokeLocalNode, "import revoked node %s", id)
+		}
+		revoked[id] = struct{}{}
+	}
+
+	var (
+		failed NodesError
+		nodes  = make([]*proto.Node, 0, len(exported))
+	)
+	for i, en := range exported {
+		var n *proto.Node
+		if n, err = importNode(en); err == nil {
+			err = validateNode(n, true)
+		}
+		if err == nil {
+			if _, ok := revoked[n.ID]; ok {
+				err = ErrNodeRevoked
+			}
+		}
+		if err != nil {
+			ne := &NodeError{Index: i, Err: err}
+			if en != nil {
+				ne.ID = en.ID
+			}
+			failed = append(failed, ne)
+			continue
+		}
+		nodes = append(nodes, n)
+	}
+	if len(failed) > 0 {
+		return failed
+	}
+
+	if err = revokeNodes(store.Revoked); err != nil {
+		return
+	}
+	return setNodes(nodes, !merge)
+}
+
+func exportNode(n *proto.Node) (en *exportedNode, err error) {
+	var key asymmetric.TypedPublicKey
+	if key, err = n.TypedPublicKey(); err != nil {
+		err = errors.Wrapf(err, "export node %s failed", n.ID)
+		return
+	}
+	en = &exportedNode{
+		ID:         n.ID,
+		Role:       n.Role.String(),
+		Addr:       n.Addr,
+		Addrs:      n.Addrs,
+		DirectAddr: n.DirectAddr,
+		Region:     n.Region,
+		Zone:       n.Zone,
+		Tags:       n.Tags,
+		KeyType:    n.KeyType.String(),
+		PublicKey:  hex.EncodeToString(key.Serialize()),
+		Nonce:      hex.EncodeToString(n.Nonce.Bytes()),
+
+		Capabilities: n.Capabilities,
+		Weight:       n.Weight,
+	}
+	return
+}
+
+func importNode(en *exportedNode) (n *proto.Node, err error) {
+	if en == nil {
+		return nil, ErrNilNode
+	}
+	n = &proto.Node{
+		ID:         en.ID,
+		Addr:       en.Addr,
+		Addrs:      en.Addrs,
+		DirectAddr: en.DirectAddr,
+		Region:     en.Region,
+		Zone:       en.Zone,
+		Tags:       en.Tags,
+
+		Capabilities: en.Capabilities,
+		Weight:       en.Weight,
+	}
+	if n.Role, err = proto.ParseServerRole(en.Role); err != nil {
+		return
+	}
+	if n.KeyType, err = asymmetric.ParseKeyType(en.KeyType); err != nil {
+		return
+	}
+
+	var keyBytes, nonceBytes []byte
+	if keyBytes, err = hex.DecodeString(en.PublicKey); err != nil {
+		err = errors.Wrap(err, "decode public key failed")
+		return
+	}
+	if nonceBytes, err = hex.DecodeString(en.Nonce); err != nil {
+		err = errors.Wrap(err, "decode nonce failed")
+		return
+	}
+	var nonce *mine.Uint256
+	if nonce, err = mine.Uint256FromBytes(nonceBytes); err != nil {
+		return
+	}
+	n.Nonce = *nonce
+
+	var key asymmetric.TypedPublicKey
+	if key, err = asymmetric.ParseTypedPublicKey(n.KeyType, keyBytes); err != nil {
+		err = errors.Wrap(err, "parse public key failed")
+		return
+	}
+	switch k := key.(type) {
+	case *asymmetric.PublicKey:
+		n.PublicKey = k
+	case asymmetric.Ed25519PublicKey:
+		n.Ed25519PublicKey = k
+	}
+	return
+}
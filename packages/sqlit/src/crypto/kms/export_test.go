@@ -0,0 +1,117 @@
+
+package kms
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"sqlit/src/crypto/asymmetric"
+	"sqlit/src/proto"
+	"sqlit/src/utils"
+)
+
+func TestExportImportPublicKeyStore(t *testing.T) {
+	newNode := func(keyType asymmetric.KeyType, role proto.ServerRole, addr string) *proto.Node {
+		node := &proto.Node{Role: role, Addr: addr, KeyType: keyType}
+		if keyType == asymmetric.Ed25519 {
+			_, node.Ed25519PublicKey, _ = asymmetric.GenEd25519KeyPair()
+		} else {
+			_, node.PublicKey, _ = asymmetric.GenSecp256k1KeyPair()
+		}
+		key, _ := node.TypedPublicKey()
+		nonce := asymmetric.GetTypedPubKeyNonce(key, 1, 50*time.Millisecond, nil)
+		node.ID = proto.NodeID(nonce.Hash.String())
+		node.Nonce = nonce.Nonce
+		return node
+	}
+	allNodes := func() map[proto.NodeID]*proto.Node {
+		pksLock.Lock()
+		defer pksLock.Unlock()
+		nodes, err := loadStoreNodes(pks.store)
+		So(err, ShouldBeNil)
+		return nodes
+	}
+
+	Convey("export and import public keystore", t, func() {
+		ClosePublicKeyStore()
+		utils.RemoveAll(dbFile + "*")
+		defer utils.RemoveAll(dbFile + "*")
+		defer ClosePublicKeyStore()
+
+		var buf bytes.Buffer
+		So(ExportPublicKeyStore(&buf), ShouldEqual, ErrPKSNotInitialized)
+		So(InitPublicKeyStore(dbFile, nil), ShouldBeNil)
+		// the locality tags are kept by the store and the export
+		tagged := newNode(asymmetric.Ed25519, proto.Miner, "127.0.0.1:1002")
+		tagged.Region, tagged.Zone = "eu-west", "eu-west-1a"
+		tagged.Tags = map[string]string{"owner": "ops"}
+		tagged.Capabilities = proto.LocalCapabilities
+		tagged.Addrs = []string{"10.0.0.2:1002", "[::1]:1002"}
+		tagged.Weight = 5
+		So(SetNodes([]*proto.Node{
+			newNode(asymmetric.Secp256k1, proto.Leader, "127.0.0.1:1001"),
+			tagged,
+			newNode(asymmetric.Secp256k1, proto.Client, ""),
+		}), ShouldBeNil)
+		before := allNodes()
+		So(before[tagged.ID].Region, ShouldEqual, "eu-west")
+		So(before[tagged.ID].Zone, ShouldEqual, "eu-west-1a")
+		So(before[tagged.ID].Tags, ShouldResemble, tagged.Tags)
+		So(before[tagged.ID].Capabilities, ShouldEqual, proto.LocalCapabilities)
+		So(before[tagged.ID].Addrs, ShouldResemble, tagged.Addrs)
+		So(before[tagged.ID].Weight, ShouldEqual, 5)
+
+		So(ExportPublicKeyStore(&buf), ShouldBeNil)
+		exported := buf.String()
+		var decoded []*exportedNode
+		So(json.Unmarshal(buf.Bytes(), &decoded), ShouldBeNil)
+		So(decoded, ShouldHaveLength, 3)
+		for i := 1; i < len(decoded); i++ {
+			So(decoded[i-1].ID, ShouldBeLessThan, decoded[i].ID)
+		}
+		for _, en := range decoded {
+			if en.ID == tagged.ID {
+				So(en.Addrs, ShouldResemble, tagged.Addrs)
+				So(en.Weight, ShouldEqual, 5)
+			}
+		}
+
+		// replace with a different store then import back
+		So(SetNodes([]*proto.Node{newNode(asymmetric.Ed25519, proto.Miner, "")}), ShouldBeNil)
+		So(ImportPublicKeyStore(strings.NewReader(exported), false), ShouldBeNil)
+		So(allNodes(), ShouldResemble, before)
+		So(allNodes()[tagged.ID].Addrs, ShouldResemble, tagged.Addrs)
+		So(allNodes()[tagged.ID].Weight, ShouldEqual, 5)
+		buf.Reset()
+		So(ExportPublicKeyStore(&buf), ShouldBeNil)
+		So(buf.String(), ShouldEqual, exported)
+
+		Convey("merge keeps existing nodes", func() {
+			extra := newNode(asymmetric.Secp256k1, proto.Miner, "")
+			So(SetNode(extra), ShouldBeNil)
+			So(ImportPublicKeyStore(strings.NewReader(exported), true), ShouldBeNil)
+			nodes := allNodes()
+			So(nodes, ShouldHaveLength, 4)
+			So(nodes, ShouldContainKey, extra.ID)
+		})
+		Convey("invalid node leaves store unchanged", func() {
+			decoded[1].Nonce = strings.Repeat("ff", 32)
+			tampered, _ := json.Marshal(decoded)
+			err := ImportPublicKeyStore(bytes.NewReader(tampered), false)
+			failed, ok := err.(NodesError)
+			So(ok, ShouldBeTrue)
+			So(failed, ShouldHaveLength, 1)
+			So(failed[0].ID, ShouldEqual, decoded[1].ID)
+			So(failed[0].Err, ShouldEqual, ErrNodeIDKeyNonceNotMatch)
+			So(allNodes(), ShouldResemble, before)
+
+			So(ImportPublicKeyStore(strings.NewReader("not json"), false), ShouldNotBeNil)
+			So(allNodes(), ShouldResemble, before)
+		})
+	})
+}
@@ -0,0 +1,33 @@
+
+package kms
+
+import (
+	"time"
+
+	"sqlit/src/crypto/asymmetric"
+	"sqlit/src/proto"
+)
+
+// newTypedTestNode returns a node of private with a valid id, it is not in the keystore.
+func newTypedTestNode(private asymmetric.TypedPrivateKey) *proto.Node {
+	node := &proto.Node{KeyType: private.KeyType()}
+	switch key := private.TypedPubKey().(type) {
+	case *asymmetric.PublicKey:
+		node.PublicKey = key
+	case asymmetric.Ed25519PublicKey:
+		node.Ed25519PublicKey = key
+	}
+	key, _ := node.TypedPublicKey()
+	nonce := asymmetric.GetTypedPubKeyNonce(key, 1, 50*time.Millisecond, nil)
+	node.ID = proto.NodeID(nonce.Hash.String())
+	node.Nonce = nonce.Nonce
+	return node
+}
+
+// useLocalTestNode makes node of private the local node, the local key store is reset
+// as the local key is set only once.
+func useLocalTestNode(private asymmetric.TypedPrivateKey, node *proto.Node) {
+	ResetLocalKeyStore()
+	SetLocalTypedKeyPair(private)
+	SetLocalNodeIDNonce(node.ID.ToRawNodeID().CloneBytes(), &node.Nonce)
+}
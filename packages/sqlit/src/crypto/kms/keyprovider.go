@@ -0,0 +1,179 @@
+
+package kms
+
+import (
+	"errors"
+	"strings"
+	"sync"
+
+	"sqlit/src/conf"
+	"sqlit/src/crypto/asymmetric"
+	"sqlit/src/proto"
+	"sqlit/src/utils/log"
+)
+
+const (
+	// FileKeyProviderType is the key provider type of local private key file.
+	FileKeyProviderType = "file"
+	// PKCS11KeyProviderType is the key provider type of PKCS#11 token.
+	PKCS11KeyProviderType = "pkcs11"
+)
+
+var (
+	// ErrUnknownKeyProvider indicates the configured key provider type is not supported
+	ErrUnknownKeyProvider = errors.New("unknown key provider")
+
+	// localKeyProvider holds the key provider used to sign with the local key
+	localKeyProvider     KeyProvider = &FileKeyProvider{}
+	localKeyProviderLock sync.RWMutex
+)
+
+// KeyProvider is the interface implemented by a holder of the local private key,
+// it can sign without exposing the private key, e.g. proto.Peers.SignWith.
+type KeyProvider interface {
+	PublicKey() (*asymmetric.PublicKey, error)
+	Sign(hash []byte) (*asymmetric.Signature, error)
+	NodeID() (proto.NodeID, error)
+}
+
+// TypedKeyProvider is the KeyProvider of a local private key of any key type, the
+// secp256k1 only PublicKey and Sign fail for the other key types.
+type TypedKeyProvider interface {
+	KeyProvider
+	TypedPublicKey() (asymmetric.TypedPublicKey, error)
+	SignBytes(hash []byte) ([]byte, error)
+}
+
+// FileKeyProvider is the KeyProvider backed by the local key store loaded
+// from the private key file by InitLocalKeyPair.
+type FileKeyProvider struct{}
+
+var _ TypedKeyProvider = (*FileKeyProvider)(nil)
+
+// PublicKey implements KeyProvider.PublicKey.
+func (p *FileKeyProvider) PublicKey() (*asymmetric.PublicKey, error) {
+	return GetLocalPublicKey()
+}
+
+// Sign implements KeyProvider.Sign.
+func (p *FileKeyProvider) Sign(hash []byte) (signature *asymmetric.Signature, err error) {
+	var private *asymmetric.PrivateKey
+	if private, err = GetLocalPrivateKey(); err != nil {
+		return
+	}
+	return private.Sign(hash)
+}
+
+// TypedPublicKey implements TypedKeyProvider.TypedPublicKey.
+func (p *FileKeyProvider) TypedPublicKey() (asymmetric.TypedPublicKey, error) {
+	return GetLocalTypedPublicKey()
+}
+
+// SignBytes implements TypedKeyProvider.SignBytes.
+func (p *FileKeyProvider) SignBytes(hash []byte) (sig []byte, err error) {
+	var private asymmetric.TypedPrivateKey
+	if private, err = GetLocalTypedPrivateKey(); err != nil {
+		return
+	}
+	return private.SignBytes(hash)
+}
+
+// NodeID implements KeyProvider.NodeID.
+func (p *FileKeyProvider) NodeID() (proto.NodeID, error) {
+	return GetLocalNodeID()
+}
+
+// ProviderPublicKey returns the public key of provider of any key type, it is the
+// secp256k1 PublicKey unless provider is a TypedKeyProvider.
+func ProviderPublicKey(provider KeyProvider) (public asymmetric.TypedPublicKey, err error) {
+	if typed, ok := provider.(TypedKeyProvider); ok {
+		return typed.TypedPublicKey()
+	}
+	var secp *asymmetric.PublicKey
+	if secp, err = provider.PublicKey(); err != nil {
+		return
+	}
+	return secp, nil
+}
+
+// providerSigner is the asymmetric.TypedPrivateKey signing with a TypedKeyProvider
+// of a key type other than secp256k1, for proto.Peers.SignTyped.
+type providerSigner struct {
+	provider TypedKeyProvider
+	public   asymmetric.TypedPublicKey
+}
+
+func (s providerSigner) KeyType() asymmetric.KeyType            { return s.public.KeyType() }
+func (s providerSigner) TypedPubKey() asymmetric.TypedPublicKey { return s.public }
+func (s providerSigner) SignBytes(hash []byte) ([]byte, error)  { return s.provider.SignBytes(hash) }
+
+// SignPeersWith signs peers with provider in the key type of its public key, the
+// secp256k1 keys sign by proto.Peers.SignWith and the others by SignTyped.
+func SignPeersWith(peers *proto.Peers, provider KeyProvider) (err error) {
+	typed, ok := provider.(TypedKeyProvider)
+	if !ok {
+		return peers.SignWith(provider)
+	}
+	var public asymmetric.TypedPublicKey
+	if public, err = typed.TypedPublicKey(); err != nil {
+		return
+	}
+	if public.KeyType() == asymmetric.Secp256k1 {
+		return peers.SignWith(provider)
+	}
+	return peers.SignTyped(providerSigner{provider: typed, public: public})
+}
+
+// SetLocalKeyProvider sets the local key provider.
+func SetLocalKeyProvider(provider KeyProvider) {
+	localKeyProviderLock.Lock()
+	defer localKeyProviderLock.Unlock()
+	localKeyProvider = provider
+}
+
+// GetLocalKeyProvider gets the local key provider, FileKeyProvider by default.
+func GetLocalKeyProvider() KeyProvider {
+	localKeyProviderLock.RLock()
+	defer localKeyProviderLock.RUnlock()
+	return localKeyProvider
+}
+
+// InitLocalKeyProvider initializes the local key provider configured by conf.GConf.KeyProvider,
+// privateKeyPath and masterKey are used by the file key provider.
+func InitLocalKeyProvider(privateKeyPath string, masterKey []byte) (err error) {
+	var info *conf.KeyProviderInfo
+	if conf.GConf != nil {
+		info = conf.GConf.KeyProvider
+	}
+
+	providerType := FileKeyProviderType
+	if info != nil && info.Type != "" {
+		providerType = strings.ToLower(info.Type)
+	}
+
+	switch providerType {
+	case FileKeyProviderType:
+		if err = InitLocalKeyPair(privateKeyPath, masterKey); err != nil {
+			return
+		}
+		SetLocalKeyProvider(&FileKeyProvider{})
+	case PKCS11KeyProviderType:
+		var provider *PKCS11KeyProvider
+		if provider, err = NewPKCS11KeyProvider(info.PKCS11); err != nil {
+			log.WithError(err).Error("init pkcs11 key provider failed")
+			return
+		}
+		var public *asymmetric.PublicKey
+		if public, err = provider.PublicKey(); err != nil {
+			return
+		}
+		// only the public key is known to local key store
+		SetLocalKeyPair(nil, public)
+		SetLocalKeyProvider(provider)
+	default:
+		err = ErrUnknownKeyProvider
+		log.WithField("type", providerType).WithError(err).Error("init local key provider failed")
+	}
+
+	return
+}
@@ -0,0 +1,92 @@
+
+package kms
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"sqlit/src/conf"
+	"sqlit/src/crypto/asymmetric"
+	"sqlit/src/proto"
+)
+
+func TestFileKeyProvider(t *testing.T) {
+	Convey("file key provider", t, func() {
+		ResetLocalKeyStore()
+		defer ResetLocalKeyStore()
+		provider := GetLocalKeyProvider()
+		So(provider, ShouldHaveSameTypeAs, &FileKeyProvider{})
+
+		_, err := provider.PublicKey()
+		So(err, ShouldEqual, ErrNilField)
+		_, err = provider.Sign(make([]byte, 32))
+		So(err, ShouldEqual, ErrNilField)
+
+		privKey, pubKey, _ := asymmetric.GenSecp256k1KeyPair()
+		SetLocalKeyPair(privKey, pubKey)
+		rawNodeID := &proto.RawNodeID{}
+		SetLocalNodeIDNonce(rawNodeID.CloneBytes(), nil)
+
+		gotPublic, err := provider.PublicKey()
+		So(err, ShouldBeNil)
+		So(gotPublic.IsEqual(pubKey), ShouldBeTrue)
+		nodeID, err := provider.NodeID()
+		So(err, ShouldBeNil)
+		So(nodeID, ShouldEqual, rawNodeID.ToNodeID())
+
+		peers := &proto.Peers{
+			PeersHeader: proto.PeersHeader{
+				Term:    1,
+				Leader:  nodeID,
+				Servers: []proto.NodeID{nodeID},
+			},
+		}
+		So(peers.SignWith(provider), ShouldBeNil)
+		So(peers.Verify(), ShouldBeNil)
+		So(peers.Signee.IsEqual(pubKey), ShouldBeTrue)
+
+		// same signature as signing with the raw private key
+		peers2 := peers.Clone()
+		So(peers2.Sign(privKey), ShouldBeNil)
+		So(peers2.Signature.IsEqual(peers.Signature), ShouldBeTrue)
+	})
+	Convey("file key provider of ed25519 key", t, func() {
+		ResetLocalKeyStore()
+		defer ResetLocalKeyStore()
+		privKey, pubKey, _ := asymmetric.GenEd25519KeyPair()
+		SetLocalTypedKeyPair(privKey)
+		provider := GetLocalKeyProvider()
+
+		_, err := provider.PublicKey()
+		So(err, ShouldEqual, ErrNilField)
+		gotPublic, err := ProviderPublicKey(provider)
+		So(err, ShouldBeNil)
+		So(asymmetric.TypedPublicKeyEqual(gotPublic, pubKey), ShouldBeTrue)
+
+		peers := &proto.Peers{
+			PeersHeader: proto.PeersHeader{
+				Term:    1,
+				Leader:  proto.NodeID("00000000000000000000000000000000000000000000000000000000000000a1"),
+				Servers: []proto.NodeID{"00000000000000000000000000000000000000000000000000000000000000a1"},
+			},
+		}
+		So(SignPeersWith(peers, provider), ShouldBeNil)
+		So(peers.Verify(), ShouldBeNil)
+		So(peers.SigneeKeyType, ShouldEqual, asymmetric.Ed25519)
+		signee, err := peers.GetSignee()
+		So(err, ShouldBeNil)
+		So(asymmetric.TypedPublicKeyEqual(signee, pubKey), ShouldBeTrue)
+	})
+	Convey("unknown key provider", t, func() {
+		oldProvider := conf.GConf.KeyProvider
+		defer func() { conf.GConf.KeyProvider = oldProvider }()
+		conf.GConf.KeyProvider = &conf.KeyProviderInfo{Type: "unknown"}
+		err := InitLocalKeyProvider(privateKeyPath, []byte(password))
+		So(err, ShouldEqual, ErrUnknownKeyProvider)
+
+		conf.GConf.KeyProvider = &conf.KeyProviderInfo{Type: PKCS11KeyProviderType}
+		err = InitLocalKeyProvider(privateKeyPath, []byte(password))
+		So(err, ShouldEqual, ErrNilPKCS11Config)
+	})
+}
@@ -0,0 +1,64 @@
+
+package kms
+
+import (
+	"errors"
+	"io"
+	"os"
+
+	"sqlit/src/conf"
+)
+
+// ErrIncompleteKey indicates the private key stream ended before a complete key.
+var ErrIncompleteKey = errors.New("private key stream ended before a complete key")
+
+// keyStdin is the stdin read for conf.StdinPrivateKeyFile, it is replaced by tests.
+var keyStdin io.Reader = os.Stdin
+
+// isKeyStream returns if the private key at path can only be read once: stdin by
+// conf.StdinPrivateKeyFile, a named pipe or a character device like /dev/fd/N.
+func isKeyStream(path string) bool {
+	if path == conf.StdinPrivateKeyFile {
+		return true
+	}
+	info, err := os.Stat(path)
+	return err == nil && info.Mode()&(os.ModeNamedPipe|os.ModeCharDevice) != 0
+}
+
+// readPrivateKeyFile reads the private key at path until EOF, stdin is read for
+// conf.StdinPrivateKeyFile. The caller should zero the content after parsing.
+func readPrivateKeyFile(path string) (content []byte, err error) {
+	if path == conf.StdinPrivateKeyFile {
+		return readKeyStream(keyStdin)
+	}
+	var f *os.File
+	if f, err = os.Open(path); err != nil {
+		return
+	}
+	defer f.Close()
+	return readKeyStream(f)
+}
+
+// readKeyStream reads r until EOF, the buffers outgrown are zeroed so no partial
+// copy of the key is left behind.
+func readKeyStream(r io.Reader) (content []byte, err error) {
+	content = make([]byte, 0, 512)
+	for {
+		if len(content) == cap(content) {
+			grown := make([]byte, len(content), 2*cap(content))
+			copy(grown, content)
+			zeroBytes(content)
+			content = grown
+		}
+		var n int
+		n, err = r.Read(content[len(content):cap(content)])
+		content = content[:len(content)+n]
+		if err == io.EOF {
+			return content, nil
+		}
+		if err != nil {
+			zeroBytes(content)
+			return nil, err
+		}
+	}
+}
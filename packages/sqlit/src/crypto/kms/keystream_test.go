@@ -0,0 +1,99 @@
+
+package kms
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"sqlit/src/conf"
+	"sqlit/src/crypto/asymmetric"
+)
+
+// recordReader hands out data in chunks and records the buffer segments it wrote.
+type recordReader struct {
+	data     []byte
+	chunk    int
+	segments [][]byte
+}
+
+func (r *recordReader) Read(p []byte) (n int, err error) {
+	if len(r.data) == 0 {
+		return 0, io.EOF
+	}
+	if len(p) > r.chunk {
+		p = p[:r.chunk]
+	}
+	n = copy(p, r.data)
+	r.data = r.data[n:]
+	r.segments = append(r.segments, p[:n])
+	return
+}
+
+// failReader fails every read.
+type failReader struct{}
+
+func (failReader) Read(p []byte) (int, error) {
+	return 0, io.ErrUnexpectedEOF
+}
+
+func isZero(b []byte) bool {
+	return bytes.Count(b, []byte{0}) == len(b)
+}
+
+func TestLoadPrivateKeyStream(t *testing.T) {
+	defer func(saved io.Reader) { keyStdin = saved }(keyStdin)
+	private, _, err := asymmetric.GenSecp256k1KeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := EncodePrivateKey(private, []byte(password))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	Convey("the private key is read from stdin and the buffer is zeroed", t, func() {
+		r := &recordReader{data: append([]byte(nil), encoded...), chunk: 16}
+		keyStdin = r
+		key, err := LoadPrivateKey(conf.StdinPrivateKeyFile, []byte(password))
+		So(err, ShouldBeNil)
+		So(key.Serialize(), ShouldResemble, private.Serialize())
+		So(len(r.segments), ShouldBeGreaterThan, 1)
+		for _, segment := range r.segments {
+			So(isZero(segment), ShouldBeTrue)
+		}
+	})
+	Convey("the outgrown buffers are zeroed", t, func() {
+		data := bytes.Repeat([]byte{0xa5}, 2000)
+		r := &recordReader{data: append([]byte(nil), data...), chunk: 100}
+		content, err := readKeyStream(r)
+		So(err, ShouldBeNil)
+		So(content, ShouldResemble, data)
+		So(isZero(r.segments[0]), ShouldBeTrue)
+	})
+	Convey("a stream ended before a complete key is an error", t, func() {
+		keyStdin = bytes.NewReader(nil)
+		_, err := LoadPrivateKey(conf.StdinPrivateKeyFile, []byte(password))
+		So(err, ShouldEqual, ErrIncompleteKey)
+		keyStdin = bytes.NewReader(encoded[:len(encoded)-1])
+		_, err = LoadPrivateKey(conf.StdinPrivateKeyFile, []byte(password))
+		So(errors.Is(err, ErrIncompleteKey), ShouldBeTrue)
+		keyStdin = failReader{}
+		_, err = LoadPrivateKey(conf.StdinPrivateKeyFile, []byte(password))
+		So(err, ShouldEqual, io.ErrUnexpectedEOF)
+	})
+	Convey("the stream is read once by InitLocalKeyPair", t, func() {
+		ResetLocalKeyStore()
+		defer ResetLocalKeyStore()
+		keyStdin = bytes.NewReader(encoded)
+		So(InitLocalKeyPair(conf.StdinPrivateKeyFile, []byte(password)), ShouldBeNil)
+		// the stream is drained, reading it again would fail
+		So(InitLocalKeyPair(conf.StdinPrivateKeyFile, []byte(password)), ShouldBeNil)
+		key, err := GetLocalPrivateKey()
+		So(err, ShouldBeNil)
+		So(key.Serialize(), ShouldResemble, private.Serialize())
+	})
+}
@@ -0,0 +1,47 @@
+//go:build linux || darwin
+// +build linux darwin
+
+package kms
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"sqlit/src/crypto/asymmetric"
+)
+
+func TestLoadPrivateKeyNamedPipe(t *testing.T) {
+	Convey("the private key is read from a named pipe like a file", t, func() {
+		private, _, err := asymmetric.GenSecp256k1KeyPair()
+		So(err, ShouldBeNil)
+		encoded, err := EncodePrivateKey(private, []byte(password))
+		So(err, ShouldBeNil)
+		pipe := filepath.Join(t.TempDir(), "private.key")
+		So(syscall.Mkfifo(pipe, 0600), ShouldBeNil)
+		So(isKeyStream(pipe), ShouldBeTrue)
+
+		write := func(content []byte) {
+			go func() {
+				f, err := os.OpenFile(pipe, os.O_WRONLY, 0)
+				if err != nil {
+					return
+				}
+				defer f.Close()
+				_, _ = f.Write(content)
+			}()
+		}
+		write(encoded)
+		key, err := LoadPrivateKey(pipe, []byte(password))
+		So(err, ShouldBeNil)
+		So(key.Serialize(), ShouldResemble, private.Serialize())
+
+		write(nil)
+		_, err = LoadPrivateKey(pipe, []byte(password))
+		So(errors.Is(err, ErrIncompleteKey), ShouldBeTrue)
+	})
+}
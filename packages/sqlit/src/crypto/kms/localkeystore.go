@@ -4,6 +4,7 @@ package kms
 import (
 	"errors"
 	"sync"
+	"sync/atomic"
 
 	"sqlit/src/crypto/asymmetric"
 	"sqlit/src/crypto/hash"
@@ -18,9 +19,25 @@ type LocalKeyStore struct {
 	public    *asymmetric.PublicKey
 	nodeID    []byte
 	nodeNonce *mine.Uint256
+	peers     *proto.Peers
+	// typedPrivate holds the private key of key types other than secp256k1
+	typedPrivate asymmetric.TypedPrivateKey
+	// shutdown is set by Shutdown once the private key is scrubbed
+	shutdown bool
+	// keyPair caches the key pair for lock free reading
+	keyPair atomic.Pointer[localKeyPair]
 	sync.RWMutex
 }
 
+// localKeyPair is the immutable snapshot of local key pair.
+type localKeyPair struct {
+	private *asymmetric.PrivateKey
+	public  *asymmetric.PublicKey
+	// nodeID is the local node id in hash string format, kept for audit
+	nodeID   proto.NodeID
+	shutdown bool
+}
+
 var (
 	// localKey is global accessible local private & public key
 	localKey *LocalKeyStore
@@ -70,18 +87,71 @@ func SetLocalKeyPair(private *asymmetric.PrivateKey, public *asymmetric.PublicKe
 	localKey.isSet = true
 	localKey.private = private
 	localKey.public = public
+	invalidateLocalKeyCache()
+}
+
+// localKeyIsSet returns if the local key pair is set and can not be set again.
+func localKeyIsSet() bool {
+	localKey.RLock()
+	defer localKey.RUnlock()
+	return localKey.isSet
+}
+
+// InvalidateLocalKeyCache drops the cached local key pair, the next read
+// reloads it from the local keystore.
+func InvalidateLocalKeyCache() {
+	localKey.Lock()
+	defer localKey.Unlock()
+	invalidateLocalKeyCache()
+}
+
+// invalidateLocalKeyCache does the actual dropping, caller should hold the localKey lock.
+func invalidateLocalKeyCache() {
+	localKey.keyPair.Store(nil)
+}
+
+// loadLocalKeyPair returns the cached local key pair, loads it if not cached.
+func loadLocalKeyPair() (kp *localKeyPair) {
+	if kp = localKey.keyPair.Load(); kp != nil {
+		return
+	}
+	// store under read lock, so it never overwrites an invalidation by writers
+	localKey.RLock()
+	defer localKey.RUnlock()
+	kp = &localKeyPair{
+		private:  localKey.private,
+		public:   localKey.public,
+		shutdown: localKey.shutdown,
+	}
+	if h, err := hash.NewHash(localKey.nodeID); err == nil {
+		kp.nodeID = proto.NodeID(h.String())
+	}
+	localKey.keyPair.Store(kp)
+	return
 }
 
 // SetLocalNodeIDNonce sets private and public key, this is a one time thing.
 func SetLocalNodeIDNonce(rawNodeID []byte, nonce *mine.Uint256) {
 	localKey.Lock()
 	defer localKey.Unlock()
+	setLocalNodeIDNonce(rawNodeID, nonce)
+}
+
+// setLocalNodeIDNonce does the actual setting, caller should hold the localKey lock.
+func setLocalNodeIDNonce(rawNodeID []byte, nonce *mine.Uint256) {
 	localKey.nodeID = make([]byte, len(rawNodeID))
 	copy(localKey.nodeID, rawNodeID)
 	if nonce != nil {
 		localKey.nodeNonce = new(mine.Uint256)
 		*localKey.nodeNonce = *nonce
 	}
+	invalidateLocalKeyCache()
+
+	var id proto.NodeID
+	if h, err := hash.NewHash(rawNodeID); err == nil {
+		id = proto.NodeID(h.String())
+	}
+	audit(AuditSetLocalNodeIDNonce, id)
 }
 
 // GetLocalNodeID gets current node ID in hash string format.
@@ -127,28 +197,26 @@ func GetLocalNonce() (nonce *mine.Uint256, err error) {
 
 // GetLocalPublicKey gets local public key, if not set yet returns nil.
 func GetLocalPublicKey() (public *asymmetric.PublicKey, err error) {
-	localKey.RLock()
-	public = localKey.public
+	public = loadLocalKeyPair().public
 	if public == nil {
 		err = ErrNilField
 	}
-	localKey.RUnlock()
 	return
 }
 
-// GetLocalPrivateKey gets local private key, if not set yet returns nil
-//  all call to this func will be logged.
+// GetLocalPrivateKey gets local private key, if not set yet returns nil and
+// ErrKeyStoreShutdown after Shutdown.
+//
+//	all call to this func will be audited, see SetAuditSink.
 func GetLocalPrivateKey() (private *asymmetric.PrivateKey, err error) {
-	localKey.RLock()
-	private = localKey.private
-	if private == nil {
+	kp := loadLocalKeyPair()
+	if kp.shutdown {
+		return nil, ErrKeyStoreShutdown
+	}
+	if private = kp.private; private == nil {
 		err = ErrNilField
+		return
 	}
-	localKey.RUnlock()
-
-	// log the call stack
-	//buf := make([]byte, 4096)
-	//count := runtime.Stack(buf, false)
-	//log.Debugf("###getting private key from###\n%s\n###getting private  key end###\n", buf[:count])
+	audit(AuditGetPrivateKey, kp.nodeID)
 	return
 }
@@ -3,6 +3,7 @@ package kms
 
 import (
 	"bytes"
+	"sync"
 	"testing"
 
 	. "github.com/smartystreets/goconvey/convey"
@@ -60,3 +61,90 @@ func TestLocalKeyStore(t *testing.T) {
 		So(nodeID, ShouldResemble, nodeIDBefore)
 	})
 }
+
+func TestLocalKeyCache(t *testing.T) {
+	Convey("cached key pair is invalidated", t, func() {
+		ResetLocalKeyStore()
+		defer ResetLocalKeyStore()
+		_, err := GetLocalPrivateKey()
+		So(err, ShouldEqual, ErrNilField)
+
+		privKey1, pubKey1, _ := asymmetric.GenSecp256k1KeyPair()
+		SetLocalKeyPair(privKey1, pubKey1)
+		gotPrivate, err := GetLocalPrivateKey()
+		So(err, ShouldBeNil)
+		So(gotPrivate, ShouldEqual, privKey1)
+
+		localKey.Lock()
+		localKey.private = nil
+		localKey.Unlock()
+		gotPrivate, _ = GetLocalPrivateKey()
+		So(gotPrivate, ShouldEqual, privKey1)
+		InvalidateLocalKeyCache()
+		_, err = GetLocalPrivateKey()
+		So(err, ShouldEqual, ErrNilField)
+	})
+	Convey("readers never see stale key after rotation", t, func() {
+		ResetLocalKeyStore()
+		defer ResetLocalKeyStore()
+		ClosePublicKeyStore()
+		privKey1, pubKey1, _ := asymmetric.GenSecp256k1KeyPair()
+		SetLocalKeyPair(privKey1, pubKey1)
+
+		var (
+			wg   sync.WaitGroup
+			stop = make(chan struct{})
+		)
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					select {
+					case <-stop:
+						return
+					default:
+					}
+					_, _ = GetLocalPrivateKey()
+					_, _ = GetLocalPublicKey()
+				}
+			}()
+		}
+		for i := 0; i < 5; i++ {
+			privKey, _, _ := asymmetric.GenSecp256k1KeyPair()
+			So(RotateLocalPrivateKey(privKey), ShouldBeNil)
+			gotPrivate, err := GetLocalPrivateKey()
+			So(err, ShouldBeNil)
+			So(gotPrivate, ShouldEqual, privKey)
+			gotPublic, err := GetLocalPublicKey()
+			So(err, ShouldBeNil)
+			So(gotPublic.IsEqual(privKey.PubKey()), ShouldBeTrue)
+		}
+		close(stop)
+		wg.Wait()
+	})
+}
+
+func BenchmarkGetLocalPrivateKey(b *testing.B) {
+	ResetLocalKeyStore()
+	defer ResetLocalKeyStore()
+	privKey, pubKey, _ := asymmetric.GenSecp256k1KeyPair()
+	SetLocalKeyPair(privKey, pubKey)
+
+	b.Run("Cached", func(b *testing.B) {
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				_, _ = GetLocalPrivateKey()
+			}
+		})
+	})
+	b.Run("RWMutex", func(b *testing.B) {
+		b.RunParallel(func(pb *testing.PB) {
+			for pb.Next() {
+				localKey.RLock()
+				_ = localKey.private
+				localKey.RUnlock()
+			}
+		})
+	})
+}
@@ -0,0 +1,69 @@
+
+package kms
+
+import (
+	"crypto/hmac"
+	"crypto/sha512"
+	"math/big"
+	"strings"
+
+	ec "github.com/btcsuite/btcd/btcec"
+	"github.com/pkg/errors"
+	"github.com/tyler-smith/go-bip39"
+
+	"sqlit/src/crypto/asymmetric"
+)
+
+// bip32MasterKey is the HMAC key of BIP32 master key generation.
+var bip32MasterKey = []byte("Bitcoin seed")
+
+var (
+	// ErrInvalidMnemonic indicates the mnemonic has unknown words or a wrong word count
+	ErrInvalidMnemonic = errors.New("invalid mnemonic")
+	// ErrMnemonicChecksum indicates the mnemonic checksum does not match
+	ErrMnemonicChecksum = errors.New("mnemonic checksum incorrect")
+	// ErrInvalidEntropyBits indicates the mnemonic entropy is not in [128, 256] or not a multiple of 32
+	ErrInvalidEntropyBits = errors.New("entropy bits must be in [128, 256] and a multiple of 32")
+	// ErrInvalidDerivedKey indicates the seed derives an invalid secp256k1 private key
+	ErrInvalidDerivedKey = errors.New("invalid derived private key")
+)
+
+// NewMnemonic generates a BIP39 English mnemonic of bits entropy, 128 bits for
+// 12 words and 256 bits for 24 words.
+func NewMnemonic(bits int) (mnemonic string, err error) {
+	var entropy []byte
+	if entropy, err = bip39.NewEntropy(bits); err != nil {
+		if err == bip39.ErrEntropyLengthInvalid {
+			err = ErrInvalidEntropyBits
+		}
+		return
+	}
+	return bip39.NewMnemonic(entropy)
+}
+
+// PrivateKeyFromMnemonic derives the secp256k1 private key from a BIP39 mnemonic
+// and an optional passphrase. The key is the BIP32 master key of the BIP39 seed,
+// so the same mnemonic always restores the same node identity, the node id is
+// mined from the public key as usual.
+func PrivateKeyFromMnemonic(mnemonic, passphrase string) (key *asymmetric.PrivateKey, err error) {
+	mnemonic = strings.Join(strings.Fields(mnemonic), " ")
+
+	if _, err = bip39.EntropyFromMnemonic(mnemonic); err != nil {
+		if err == bip39.ErrChecksumIncorrect {
+			err = ErrMnemonicChecksum
+		} else {
+			err = errors.Wrap(ErrInvalidMnemonic, err.Error())
+		}
+		return
+	}
+
+	mac := hmac.New(sha512.New, bip32MasterKey)
+	mac.Write(bip39.NewSeed(mnemonic, passphrase))
+	keyBytes := mac.Sum(nil)[:32]
+	if d := new(big.Int).SetBytes(keyBytes); d.Sign() == 0 || d.Cmp(ec.S256().N) >= 0 {
+		err = ErrInvalidDerivedKey
+		return
+	}
+	key, _ = asymmetric.PrivKeyFromBytes(keyBytes)
+	return
+}
@@ -0,0 +1,119 @@
+
+package kms
+
+import (
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	. "github.com/smartystreets/goconvey/convey"
+
+	"sqlit/src/crypto/hash"
+	mine "sqlit/src/pow/cpuminer"
+	"sqlit/src/proto"
+)
+
+// BIP39 test vectors from trezor/python-mnemonic, the passphrase is "TREZOR".
+var mnemonicVectors = []struct {
+	mnemonic string
+	seed     string
+}{
+	{
+		"abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about",
+		"c55257c360c07c72029aebc1b53c05ed0362ada38ead3e3e9efa3708e53495531f09a6987599d18264c1e1c92f2cf141630c7a3c4ab7c81b2f001698e7463b04",
+	},
+	{
+		"legal winner thank year wave sausage worth useful legal winner thank yellow",
+		"2e8905819b8723fe2c1d161860e5ee1830318dbf49a83bd451cfb8440c28bd6fa457fe1296106559a3c80937a1c1069be3a3a5bd381ee6260e8d9739fce1f607",
+	},
+	{
+		"letter advice cage absurd amount doctor acoustic avoid letter advice cage above",
+		"d71de856f81a8acc65e6fc851a38d4d7ec216fd0796d0a6827a3ad6ed5511a30fa280f12eb2e47ed2ac03b5c462a0358d18d69fe4f985ec81778c1b370b652a8",
+	},
+	{
+		"zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo wrong",
+		"ac27495480225222079d7be181583751e86f571027b0497b5b5d11218e0a8a13332572917f0f8e5a589620c6f15b11c61dee327651a14c34e18231052e48c069",
+	},
+	{
+		"abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon " +
+			"abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon art",
+		"bda85446c68413707090a52022edd26a1c9462295029f2e60cd7c4f2bbd3097170af7a4d73245cafa9c3cca8d561a7c3de6f5d4a10be8ed2a5e608d68f92fcc8",
+	},
+}
+
+func TestPrivateKeyFromMnemonic(t *testing.T) {
+	Convey("derive private key from BIP39 test vectors", t, func() {
+		for _, v := range mnemonicVectors {
+			seed, err := hex.DecodeString(v.seed)
+			So(err, ShouldBeNil)
+			mac := hmac.New(sha512.New, []byte("Bitcoin seed"))
+			mac.Write(seed)
+
+			key, err := PrivateKeyFromMnemonic(v.mnemonic, "TREZOR")
+			So(err, ShouldBeNil)
+			So(hex.EncodeToString(key.Serialize()), ShouldEqual, hex.EncodeToString(mac.Sum(nil)[:32]))
+
+			// extra white spaces are ignored
+			again, err := PrivateKeyFromMnemonic("  "+strings.Replace(v.mnemonic, " ", "\n\t", 1)+" ", "TREZOR")
+			So(err, ShouldBeNil)
+			So(again.Serialize(), ShouldResemble, key.Serialize())
+
+			// passphrase derives a different key
+			other, err := PrivateKeyFromMnemonic(v.mnemonic, "")
+			So(err, ShouldBeNil)
+			So(other.Serialize(), ShouldNotResemble, key.Serialize())
+		}
+	})
+	Convey("derived key restores the same node id", t, func() {
+		mnemonic, err := NewMnemonic(128)
+		So(err, ShouldBeNil)
+		key1, err := PrivateKeyFromMnemonic(mnemonic, "pass")
+		So(err, ShouldBeNil)
+		key2, err := PrivateKeyFromMnemonic(mnemonic, "pass")
+		So(err, ShouldBeNil)
+		So(key2.Serialize(), ShouldResemble, key1.Serialize())
+
+		nonce := mine.Uint256{A: 1}
+		nodeID := mine.HashBlock(key1.PubKey().Serialize(), nonce)
+		rawNodeID := &proto.RawNodeID{Hash: hash.Hash(nodeID)}
+		So(IsIDPubNonceValid(rawNodeID, &nonce, key2.PubKey()), ShouldBeTrue)
+	})
+	Convey("invalid mnemonic returns error", t, func() {
+		key, err := PrivateKeyFromMnemonic(
+			"abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon", "")
+		So(key, ShouldBeNil)
+		So(err, ShouldEqual, ErrMnemonicChecksum)
+
+		key, err = PrivateKeyFromMnemonic("abandon abandon abandon", "")
+		So(key, ShouldBeNil)
+		So(errors.Cause(err), ShouldEqual, ErrInvalidMnemonic)
+
+		key, err = PrivateKeyFromMnemonic(
+			"abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon sqlit", "")
+		So(key, ShouldBeNil)
+		So(errors.Cause(err), ShouldEqual, ErrInvalidMnemonic)
+	})
+}
+
+func TestNewMnemonic(t *testing.T) {
+	Convey("generate mnemonic", t, func() {
+		for bits, words := range map[int]int{128: 12, 160: 15, 192: 18, 224: 21, 256: 24} {
+			mnemonic, err := NewMnemonic(bits)
+			So(err, ShouldBeNil)
+			So(strings.Fields(mnemonic), ShouldHaveLength, words)
+			_, err = PrivateKeyFromMnemonic(mnemonic, "")
+			So(err, ShouldBeNil)
+		}
+		m1, _ := NewMnemonic(256)
+		m2, _ := NewMnemonic(256)
+		So(m1, ShouldNotEqual, m2)
+
+		for _, bits := range []int{0, 96, 129, 288} {
+			_, err := NewMnemonic(bits)
+			So(err, ShouldEqual, ErrInvalidEntropyBits)
+		}
+	})
+}
@@ -0,0 +1,143 @@
+
+package kms
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"sqlit/src/proto"
+	"sqlit/src/utils/log"
+)
+
+var (
+	// ErrLocalNonceNotInitialized indicates InitLocalNonceFile is not called
+	ErrLocalNonceNotInitialized = errors.New("local nonce not initialized")
+	// ErrLocalNonceRollback indicates the persisted local nonce is lower than the configured one
+	ErrLocalNonceRollback = errors.New("persisted local nonce is lower than configured")
+	// ErrLocalNonceExhausted indicates the local nonce reached the max value
+	ErrLocalNonceExhausted = errors.New("local nonce exhausted")
+	// ErrInvalidNonceFile indicates the local nonce file is malformed
+	ErrInvalidNonceFile = errors.New("invalid local nonce file")
+)
+
+// localNonce holds the last message nonce used by the local node.
+var localNonce struct {
+	sync.Mutex
+	path  string
+	value proto.Nonce
+}
+
+// InitLocalNonceFile loads the last used message nonce of the local node from
+// path, the file is created with confNonce if not exist. ErrLocalNonceRollback is
+// returned if the nonce in path is lower than confNonce, which means the file is
+// restored from an old backup and the node must not start.
+func InitLocalNonceFile(path string, confNonce proto.Nonce) (err error) {
+	localNonce.Lock()
+	defer localNonce.Unlock()
+
+	var nonce proto.Nonce
+	if nonce, err = readNonceFile(path); os.IsNotExist(errors.Cause(err)) {
+		if err = writeNonceFile(path, confNonce); err != nil {
+			return
+		}
+		nonce = confNonce
+	} else if err != nil {
+		return
+	} else if nonce < confNonce {
+		log.WithFields(log.Fields{
+			"path":       path,
+			"nonce":      nonce,
+			"conf_nonce": confNonce,
+		}).Error("local nonce rollback detected")
+		return ErrLocalNonceRollback
+	}
+
+	localNonce.path = path
+	localNonce.value = nonce
+	return
+}
+
+// NextLocalNonce increments the local message nonce and returns it, the new
+// nonce is persisted before return so it is never reused after restart.
+func NextLocalNonce() (nonce proto.Nonce, err error) {
+	localNonce.Lock()
+	defer localNonce.Unlock()
+	if localNonce.path == "" {
+		return 0, ErrLocalNonceNotInitialized
+	}
+	if localNonce.value == math.MaxUint64 {
+		return 0, ErrLocalNonceExhausted
+	}
+
+	nonce = localNonce.value + 1
+	if err = writeNonceFile(localNonce.path, nonce); err != nil {
+		return 0, err
+	}
+	localNonce.value = nonce
+	return
+}
+
+// resetLocalNonce forgets the loaded local nonce, used by tests.
+func resetLocalNonce() {
+	localNonce.Lock()
+	defer localNonce.Unlock()
+	localNonce.path = ""
+	localNonce.value = 0
+}
+
+func readNonceFile(path string) (nonce proto.Nonce, err error) {
+	var content []byte
+	if content, err = os.ReadFile(path); err != nil {
+		err = errors.Wrap(err, "read local nonce file failed")
+		return
+	}
+	var value uint64
+	if value, err = strconv.ParseUint(strings.TrimSpace(string(content)), 10, 64); err != nil {
+		err = errors.Wrap(ErrInvalidNonceFile, err.Error())
+		return
+	}
+	nonce = proto.Nonce(value)
+	return
+}
+
+// writeNonceFile writes nonce to a synced temp file then renames it to path, so
+// path always holds a complete nonce even on crash.
+func writeNonceFile(path string, nonce proto.Nonce) (err error) {
+	tmpFile := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	var f *os.File
+	if f, err = os.OpenFile(tmpFile, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600); err != nil {
+		err = errors.Wrap(err, "create local nonce file failed")
+		return
+	}
+	defer func() {
+		if err != nil {
+			_ = os.Remove(tmpFile)
+		}
+	}()
+	if _, err = f.WriteString(strconv.FormatUint(uint64(nonce), 10) + "\n"); err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		err = errors.Wrap(err, "write local nonce file failed")
+		return
+	}
+	if err = os.Rename(tmpFile, path); err != nil {
+		err = errors.Wrap(err, "rename local nonce file failed")
+		return
+	}
+	// sync the directory to persist the rename
+	if dir, dirErr := os.Open(filepath.Dir(path)); dirErr == nil {
+		_ = dir.Sync()
+		_ = dir.Close()
+	}
+	return
+}
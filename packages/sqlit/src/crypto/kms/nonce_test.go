@@ -0,0 +1,99 @@
+
+package kms
+
+import (
+	"math"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/pkg/errors"
+	. "github.com/smartystreets/goconvey/convey"
+
+	"sqlit/src/proto"
+)
+
+func TestLocalNonce(t *testing.T) {
+	Convey("local nonce", t, func() {
+		resetLocalNonce()
+		defer resetLocalNonce()
+		dir, err := os.MkdirTemp("", "kms-nonce")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "private.key.nonce")
+
+		_, err = NextLocalNonce()
+		So(err, ShouldEqual, ErrLocalNonceNotInitialized)
+
+		So(InitLocalNonceFile(path, 10), ShouldBeNil)
+		nonce, err := readNonceFile(path)
+		So(err, ShouldBeNil)
+		So(nonce, ShouldEqual, 10)
+
+		nonce, err = NextLocalNonce()
+		So(err, ShouldBeNil)
+		So(nonce, ShouldEqual, 11)
+		nonce, err = NextLocalNonce()
+		So(err, ShouldBeNil)
+		So(nonce, ShouldEqual, 12)
+
+		Convey("nonce is not reused after restart", func() {
+			resetLocalNonce()
+			So(InitLocalNonceFile(path, 10), ShouldBeNil)
+			nonce, err := NextLocalNonce()
+			So(err, ShouldBeNil)
+			So(nonce, ShouldEqual, 13)
+			// no temp file left
+			entries, err := os.ReadDir(dir)
+			So(err, ShouldBeNil)
+			So(entries, ShouldHaveLength, 1)
+		})
+		Convey("refuse rolled back nonce", func() {
+			resetLocalNonce()
+			So(InitLocalNonceFile(path, 13), ShouldEqual, ErrLocalNonceRollback)
+			_, err := NextLocalNonce()
+			So(err, ShouldEqual, ErrLocalNonceNotInitialized)
+			So(InitLocalNonceFile(path, 12), ShouldBeNil)
+		})
+		Convey("refuse malformed nonce file", func() {
+			resetLocalNonce()
+			So(os.WriteFile(path, []byte("not a nonce"), 0600), ShouldBeNil)
+			So(errors.Cause(InitLocalNonceFile(path, 0)), ShouldEqual, ErrInvalidNonceFile)
+		})
+		Convey("nonce exhausted", func() {
+			resetLocalNonce()
+			So(InitLocalNonceFile(filepath.Join(dir, "max.nonce"), math.MaxUint64), ShouldBeNil)
+			_, err := NextLocalNonce()
+			So(err, ShouldEqual, ErrLocalNonceExhausted)
+		})
+		Convey("concurrent signers get distinct nonces", func() {
+			const signers, count = 8, 50
+			var (
+				wg     sync.WaitGroup
+				lock   sync.Mutex
+				nonces = make(map[proto.Nonce]struct{})
+			)
+			for i := 0; i < signers; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for j := 0; j < count; j++ {
+						nonce, err := NextLocalNonce()
+						if err != nil {
+							continue
+						}
+						lock.Lock()
+						nonces[nonce] = struct{}{}
+						lock.Unlock()
+					}
+				}()
+			}
+			wg.Wait()
+			So(nonces, ShouldHaveLength, signers*count)
+			nonce, err := readNonceFile(path)
+			So(err, ShouldBeNil)
+			So(nonce, ShouldEqual, 12+signers*count)
+		})
+	})
+}
@@ -0,0 +1,174 @@
+
+package kms
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/btcsuite/btcutil/base58"
+	"golang.org/x/crypto/scrypt"
+
+	"sqlit/src/conf"
+	"sqlit/src/crypto/asymmetric"
+	"sqlit/src/utils/log"
+)
+
+// PassphraseEnv is the environment variable holding the private key passphrase.
+const PassphraseEnv = "SQLIT_KEY_PASSPHRASE"
+
+const (
+	gcmSaltLen = 16
+	scryptN    = 1 << 15
+	scryptR    = 8
+	scryptP    = 1
+)
+
+var (
+	// ErrWrongPassphrase indicates the passphrase can not decrypt the private key
+	ErrWrongPassphrase = errors.New("wrong private key passphrase")
+	// ErrInvalidEncryptedKey indicates the encrypted private key is malformed
+	ErrInvalidEncryptedKey = errors.New("invalid encrypted private key")
+	// PrivateKeyStoreGCMVersion defines the version byte of AES-256-GCM encrypted private key.
+	PrivateKeyStoreGCMVersion byte = 0x24
+)
+
+// ReadPassphrase reads the private key passphrase from PassphraseEnv, then the file
+// conf.GConf.PrivateKeyPassphraseFile, and finally falls back to prompt.
+func ReadPassphrase(prompt func() ([]byte, error)) (passphrase []byte, err error) {
+	if env, ok := os.LookupEnv(PassphraseEnv); ok {
+		return []byte(env), nil
+	}
+
+	if conf.GConf != nil && conf.GConf.PrivateKeyPassphraseFile != "" {
+		var content []byte
+		if content, err = os.ReadFile(conf.GConf.PrivateKeyPassphraseFile); err != nil {
+			log.WithField("path", conf.GConf.PrivateKeyPassphraseFile).WithError(err).Error(
+				"read passphrase file failed")
+			return
+		}
+		return []byte(strings.TrimRight(string(content), "\r\n")), nil
+	}
+
+	if prompt == nil {
+		return
+	}
+	return prompt()
+}
+
+// EncodePrivateKeyWithPassphrase encrypts private key with AES-256-GCM, the key
+// encrypting key is derived from passphrase by scrypt.
+func EncodePrivateKeyWithPassphrase(key *asymmetric.PrivateKey, passphrase []byte) (keyBytes []byte, err error) {
+	serializedKey := key.Serialize()
+	defer zeroBytes(serializedKey)
+	return sealWithPassphrase(serializedKey, passphrase, PrivateKeyStoreGCMVersion)
+}
+
+// sealWithPassphrase encrypts plain with AES-256-GCM and encodes it in base58 with
+// version.
+func sealWithPassphrase(plain []byte, passphrase []byte, version byte) (keyBytes []byte, err error) {
+	salt := make([]byte, gcmSaltLen)
+	if _, err = io.ReadFull(rand.Reader, salt); err != nil {
+		return
+	}
+	var aead cipher.AEAD
+	if aead, err = newPassphraseAEAD(passphrase, salt); err != nil {
+		return
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		return
+	}
+
+	// salt + nonce + sealed key
+	encKey := append(append(salt, nonce...), aead.Seal(nil, nonce, plain, nil)...)
+	keyBytes = []byte(base58.CheckEncode(encKey, version))
+
+	return
+}
+
+// EncryptPrivateKey migrates the private key file saved with empty master key to
+// the AES-256-GCM passphrase encrypted format in place.
+func EncryptPrivateKey(keyFilePath string, passphrase []byte) (err error) {
+	var key *asymmetric.PrivateKey
+	if key, err = LoadPrivateKey(keyFilePath, []byte{}); err != nil {
+		return
+	}
+
+	var keyBytes []byte
+	if keyBytes, err = EncodePrivateKeyWithPassphrase(key, passphrase); err != nil {
+		return
+	}
+
+	// write to a temp file then rename, never leave a half written key file
+	tmpFile := filepath.Join(filepath.Dir(keyFilePath), "."+filepath.Base(keyFilePath)+".tmp")
+	if err = os.WriteFile(tmpFile, keyBytes, 0600); err != nil {
+		return
+	}
+	if err = os.Rename(tmpFile, keyFilePath); err != nil {
+		_ = os.Remove(tmpFile)
+	}
+	return
+}
+
+// decodePassphrasePrivateKey decrypts the AES-256-GCM encrypted private key.
+func decodePassphrasePrivateKey(encData []byte, passphrase []byte) (key *asymmetric.PrivateKey, err error) {
+	var decData []byte
+	if decData, err = openWithPassphrase(encData, passphrase); err != nil {
+		return
+	}
+	defer zeroBytes(decData)
+
+	if len(decData) != asymmetric.PrivateKeyBytesLen {
+		return nil, ErrNotKeyFile
+	}
+	key, _ = asymmetric.PrivKeyFromBytes(decData)
+	return
+}
+
+// openWithPassphrase decrypts the content sealed by sealWithPassphrase, the caller
+// zeroes the result.
+func openWithPassphrase(encData []byte, passphrase []byte) (decData []byte, err error) {
+	if len(encData) < gcmSaltLen {
+		return nil, ErrInvalidEncryptedKey
+	}
+	salt := encData[:gcmSaltLen]
+	var aead cipher.AEAD
+	if aead, err = newPassphraseAEAD(passphrase, salt); err != nil {
+		return
+	}
+	if len(encData) < gcmSaltLen+aead.NonceSize() {
+		return nil, ErrInvalidEncryptedKey
+	}
+	nonce := encData[gcmSaltLen : gcmSaltLen+aead.NonceSize()]
+
+	if decData, err = aead.Open(nil, nonce, encData[gcmSaltLen+aead.NonceSize():], nil); err != nil {
+		return nil, ErrWrongPassphrase
+	}
+	return
+}
+
+func newPassphraseAEAD(passphrase []byte, salt []byte) (aead cipher.AEAD, err error) {
+	kek, err := scrypt.Key(passphrase, salt, scryptN, scryptR, scryptP, 32)
+	if err != nil {
+		return
+	}
+	defer zeroBytes(kek)
+
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return
+	}
+	return cipher.NewGCM(block)
+}
+
+func zeroBytes(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
@@ -0,0 +1,82 @@
+
+package kms
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/btcsuite/btcutil/base58"
+	. "github.com/smartystreets/goconvey/convey"
+
+	"sqlit/src/conf"
+	"sqlit/src/crypto/asymmetric"
+)
+
+func TestPassphrasePrivateKey(t *testing.T) {
+	Convey("encode and decode with passphrase", t, func() {
+		privKey, _, _ := asymmetric.GenSecp256k1KeyPair()
+		keyBytes, err := EncodePrivateKeyWithPassphrase(privKey, []byte(password))
+		So(err, ShouldBeNil)
+		_, version, err := base58.CheckDecode(string(keyBytes))
+		So(err, ShouldBeNil)
+		So(version, ShouldEqual, PrivateKeyStoreGCMVersion)
+
+		decKey, err := DecodePrivateKey(keyBytes, []byte(password))
+		So(err, ShouldBeNil)
+		So(bytes.Equal(decKey.Serialize(), privKey.Serialize()), ShouldBeTrue)
+
+		decKey, err = DecodePrivateKey(keyBytes, []byte("wrong"))
+		So(err, ShouldEqual, ErrWrongPassphrase)
+		So(decKey, ShouldBeNil)
+
+		truncated := base58.CheckEncode([]byte("short"), PrivateKeyStoreGCMVersion)
+		_, err = DecodePrivateKey([]byte(truncated), []byte(password))
+		So(err, ShouldEqual, ErrInvalidEncryptedKey)
+	})
+	Convey("migrate key file", t, func() {
+		defer os.Remove(privateKeyPath)
+		privKey, _, _ := asymmetric.GenSecp256k1KeyPair()
+		So(SavePrivateKey(privateKeyPath, privKey, []byte{}), ShouldBeNil)
+		So(EncryptPrivateKey(privateKeyPath, []byte(password)), ShouldBeNil)
+
+		_, err := LoadPrivateKey(privateKeyPath, []byte{})
+		So(err, ShouldEqual, ErrWrongPassphrase)
+		loaded, err := LoadPrivateKey(privateKeyPath, []byte(password))
+		So(err, ShouldBeNil)
+		So(bytes.Equal(loaded.Serialize(), privKey.Serialize()), ShouldBeTrue)
+
+		info, err := os.Stat(privateKeyPath)
+		So(err, ShouldBeNil)
+		So(info.Mode().Perm(), ShouldEqual, os.FileMode(0600))
+
+		// wrong passphrase is an error, not fatal
+		ResetLocalKeyStore()
+		defer ResetLocalKeyStore()
+		err = InitLocalKeyPair(privateKeyPath, []byte("wrong"))
+		So(err, ShouldEqual, ErrWrongPassphrase)
+	})
+	Convey("read passphrase", t, func() {
+		prompt := func() ([]byte, error) { return []byte("prompt"), nil }
+
+		os.Unsetenv(PassphraseEnv)
+		p, err := ReadPassphrase(prompt)
+		So(err, ShouldBeNil)
+		So(string(p), ShouldEqual, "prompt")
+
+		passFile := "./.passphrase"
+		defer os.Remove(passFile)
+		So(os.WriteFile(passFile, []byte("file\n"), 0600), ShouldBeNil)
+		conf.GConf.PrivateKeyPassphraseFile = passFile
+		defer func() { conf.GConf.PrivateKeyPassphraseFile = "" }()
+		p, err = ReadPassphrase(prompt)
+		So(err, ShouldBeNil)
+		So(string(p), ShouldEqual, "file")
+
+		os.Setenv(PassphraseEnv, "env")
+		defer os.Unsetenv(PassphraseEnv)
+		p, err = ReadPassphrase(prompt)
+		So(err, ShouldBeNil)
+		So(string(p), ShouldEqual, "env")
+	})
+}
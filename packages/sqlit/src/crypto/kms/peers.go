@@ -0,0 +1,82 @@
+package kms
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"sqlit/src/proto"
+	"sqlit/src/utils"
+)
+
+// SaveLocalPeers writes the local peers set by SetLocalPeers to path by
+// WritePeersFile. The signed peers are written as they are, so the peers adopted from
+// the leader keep its signature, the unsigned or changed ones are signed with the
+// local key first.
+func SaveLocalPeers(path string) (err error) {
+	peers, err := GetLocalPeers()
+	if err != nil {
+		return
+	}
+	if peers.IsDirty() || peers.Verify() != nil {
+		peers = peers.Clone()
+		if err = SignPeersWith(peers, GetLocalKeyProvider()); err != nil {
+			err = errors.Wrap(err, "sign local peers failed")
+			return
+		}
+	}
+	return WritePeersFile(path, peers)
+}
+
+// peersFileLock serializes WritePeersFile, the writers of a path share its tmp file.
+var peersFileLock sync.Mutex
+
+// WritePeersFile writes peers to path in msgpack as they are, the file is replaced by
+// rename so it always holds a complete peers list.
+func WritePeersFile(path string, peers *proto.Peers) (err error) {
+	peersFileLock.Lock()
+	defer peersFileLock.Unlock()
+	buf, err := utils.EncodeMsgPack(peers)
+	if err != nil {
+		err = errors.Wrap(err, "encode peers failed")
+		return
+	}
+
+	tmpFile := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err = os.WriteFile(tmpFile, buf.Bytes(), 0600); err != nil {
+		err = errors.Wrap(err, "write peers file failed")
+		return
+	}
+	if err = os.Rename(tmpFile, path); err != nil {
+		_ = os.Remove(tmpFile)
+		err = errors.Wrap(err, "rename peers file failed")
+	}
+	return
+}
+
+// ReadPeersFile reads the peers written by WritePeersFile without verifying them.
+func ReadPeersFile(path string) (peers *proto.Peers, err error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+	peers = &proto.Peers{}
+	if err = utils.DecodeMsgPack(content, peers); err != nil {
+		peers = nil
+		err = errors.Wrap(err, "decode peers file failed")
+	}
+	return
+}
+
+// LoadPeersFile reads the peers written by SaveLocalPeers and verifies the signature.
+func LoadPeersFile(path string) (peers *proto.Peers, err error) {
+	if peers, err = ReadPeersFile(path); err != nil {
+		return
+	}
+	if err = peers.Verify(); err != nil {
+		err = errors.Wrap(err, "verify peers file failed")
+	}
+	return
+}
@@ -0,0 +1,188 @@
+
+package kms
+
+import (
+	"encoding/asn1"
+	"errors"
+	"math/big"
+	"sync"
+
+	"github.com/btcsuite/btcd/btcec"
+	"github.com/miekg/pkcs11"
+
+	"sqlit/src/conf"
+	"sqlit/src/crypto/asymmetric"
+	"sqlit/src/proto"
+)
+
+var (
+	// ErrNilPKCS11Config indicates the PKCS#11 config is missing
+	ErrNilPKCS11Config = errors.New("nil pkcs11 config")
+	// ErrPKCS11KeyNotFound indicates the key pair is not found on the token
+	ErrPKCS11KeyNotFound = errors.New("pkcs11 key not found")
+	// ErrInvalidPKCS11Signature indicates the token returned a malformed signature
+	ErrInvalidPKCS11Signature = errors.New("invalid pkcs11 signature")
+	// ErrInvalidECPoint indicates the token returned a malformed public key
+	ErrInvalidECPoint = errors.New("invalid pkcs11 ec point")
+)
+
+// PKCS11KeyProvider is the KeyProvider delegating signing to a PKCS#11 token,
+// the private key never leaves the token.
+type PKCS11KeyProvider struct {
+	ctx     *pkcs11.Ctx
+	session pkcs11.SessionHandle
+	private pkcs11.ObjectHandle
+	public  *asymmetric.PublicKey
+	sync.Mutex
+}
+
+// NewPKCS11KeyProvider opens a session on the configured token and finds the key pair by label.
+func NewPKCS11KeyProvider(info *conf.PKCS11Info) (p *PKCS11KeyProvider, err error) {
+	if info == nil {
+		return nil, ErrNilPKCS11Config
+	}
+
+	ctx := pkcs11.New(info.ModulePath)
+	if ctx == nil {
+		return nil, errors.New("load pkcs11 module failed: " + info.ModulePath)
+	}
+	if err = ctx.Initialize(); err != nil {
+		ctx.Destroy()
+		return
+	}
+
+	p = &PKCS11KeyProvider{ctx: ctx}
+	defer func() {
+		if err != nil {
+			p.Close()
+			p = nil
+		}
+	}()
+
+	if p.session, err = ctx.OpenSession(info.Slot, pkcs11.CKF_SERIAL_SESSION); err != nil {
+		return
+	}
+	if info.PIN != "" {
+		if err = ctx.Login(p.session, pkcs11.CKU_USER, info.PIN); err != nil {
+			return
+		}
+	}
+
+	if p.private, err = p.findObject(pkcs11.CKO_PRIVATE_KEY, info.KeyLabel); err != nil {
+		return
+	}
+
+	var publicHandle pkcs11.ObjectHandle
+	if publicHandle, err = p.findObject(pkcs11.CKO_PUBLIC_KEY, info.KeyLabel); err != nil {
+		return
+	}
+	var attrs []*pkcs11.Attribute
+	if attrs, err = ctx.GetAttributeValue(p.session, publicHandle, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_EC_POINT, nil),
+	}); err != nil {
+		return
+	}
+	if len(attrs) == 0 {
+		return nil, ErrInvalidECPoint
+	}
+	p.public, err = parsePKCS11ECPoint(attrs[0].Value)
+
+	return
+}
+
+func (p *PKCS11KeyProvider) findObject(class uint, label string) (handle pkcs11.ObjectHandle, err error) {
+	template := []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, class),
+		pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_EC),
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+	}
+	if err = p.ctx.FindObjectsInit(p.session, template); err != nil {
+		return
+	}
+	defer func() { _ = p.ctx.FindObjectsFinal(p.session) }()
+
+	var handles []pkcs11.ObjectHandle
+	if handles, _, err = p.ctx.FindObjects(p.session, 1); err != nil {
+		return
+	}
+	if len(handles) == 0 {
+		err = ErrPKCS11KeyNotFound
+		return
+	}
+	handle = handles[0]
+	return
+}
+
+// PublicKey implements KeyProvider.PublicKey.
+func (p *PKCS11KeyProvider) PublicKey() (*asymmetric.PublicKey, error) {
+	return p.public, nil
+}
+
+// Sign implements KeyProvider.Sign, the signature is made by the token with CKM_ECDSA.
+func (p *PKCS11KeyProvider) Sign(hash []byte) (signature *asymmetric.Signature, err error) {
+	if len(hash) != 32 {
+		return nil, errors.New("only hash can be signed")
+	}
+	p.Lock()
+	defer p.Unlock()
+	if err = p.ctx.SignInit(p.session,
+		[]*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_ECDSA, nil)}, p.private); err != nil {
+		return
+	}
+	var raw []byte
+	if raw, err = p.ctx.Sign(p.session, hash); err != nil {
+		return
+	}
+	return parsePKCS11Signature(raw)
+}
+
+// NodeID implements KeyProvider.NodeID, the node id is set by SetLocalNodeIDNonce.
+func (p *PKCS11KeyProvider) NodeID() (proto.NodeID, error) {
+	return GetLocalNodeID()
+}
+
+// Close logs out and closes the token session.
+func (p *PKCS11KeyProvider) Close() {
+	p.Lock()
+	defer p.Unlock()
+	if p.ctx == nil {
+		return
+	}
+	_ = p.ctx.Logout(p.session)
+	_ = p.ctx.CloseSession(p.session)
+	_ = p.ctx.Finalize()
+	p.ctx.Destroy()
+	p.ctx = nil
+}
+
+// parsePKCS11Signature converts the r||s signature returned by CKM_ECDSA to a canonical
+// low-S signature as produced by asymmetric.PrivateKey.Sign.
+func parsePKCS11Signature(raw []byte) (signature *asymmetric.Signature, err error) {
+	if len(raw) != 64 {
+		return nil, ErrInvalidPKCS11Signature
+	}
+	signature = &asymmetric.Signature{
+		R: new(big.Int).SetBytes(raw[:32]),
+		S: new(big.Int).SetBytes(raw[32:]),
+	}
+	n := btcec.S256().N
+	if signature.S.Cmp(new(big.Int).Rsh(n, 1)) > 0 {
+		signature.S.Sub(n, signature.S)
+	}
+	return
+}
+
+// parsePKCS11ECPoint parses the CKA_EC_POINT which is a DER encoded octet string of the point.
+func parsePKCS11ECPoint(ecPoint []byte) (public *asymmetric.PublicKey, err error) {
+	var point []byte
+	if rest, uErr := asn1.Unmarshal(ecPoint, &point); uErr == nil && len(rest) == 0 {
+		if public, err = asymmetric.ParsePubKey(point); err == nil {
+			return
+		}
+	}
+	// some tokens return the raw point, which may also look like a DER octet string
+	if public, err = asymmetric.ParsePubKey(ecPoint); err != nil {
+		return nil, ErrInvalidECPoint
+	}
+	return
+}
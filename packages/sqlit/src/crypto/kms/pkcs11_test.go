@@ -0,0 +1,66 @@
+
+package kms
+
+import (
+	"encoding/asn1"
+	"math/big"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec"
+	. "github.com/smartystreets/goconvey/convey"
+
+	"sqlit/src/crypto/asymmetric"
+	"sqlit/src/crypto/hash"
+	"sqlit/src/utils"
+)
+
+func TestParsePKCS11Signature(t *testing.T) {
+	Convey("parse pkcs11 signature", t, func() {
+		privKey, pubKey, _ := asymmetric.GenSecp256k1KeyPair()
+		h := hash.THashB([]byte("pkcs11"))
+		sig, err := privKey.Sign(h)
+		So(err, ShouldBeNil)
+
+		raw := append(utils.PaddedBigBytes(sig.R, 32), utils.PaddedBigBytes(sig.S, 32)...)
+		parsed, err := parsePKCS11Signature(raw)
+		So(err, ShouldBeNil)
+		So(parsed.Verify(h, pubKey), ShouldBeTrue)
+
+		// high-S signature from token is normalized
+		highS := new(big.Int).Sub(btcec.S256().N, sig.S)
+		raw = append(utils.PaddedBigBytes(sig.R, 32), utils.PaddedBigBytes(highS, 32)...)
+		parsed, err = parsePKCS11Signature(raw)
+		So(err, ShouldBeNil)
+		So(parsed.S.Cmp(sig.S), ShouldEqual, 0)
+		So(parsed.Verify(h, pubKey), ShouldBeTrue)
+
+		_, err = parsePKCS11Signature(raw[:63])
+		So(err, ShouldEqual, ErrInvalidPKCS11Signature)
+	})
+	Convey("parse pkcs11 ec point", t, func() {
+		_, pubKey, _ := asymmetric.GenSecp256k1KeyPair()
+		point := (*btcec.PublicKey)(pubKey).SerializeUncompressed()
+		der, err := asn1.Marshal(point)
+		So(err, ShouldBeNil)
+
+		parsed, err := parsePKCS11ECPoint(der)
+		So(err, ShouldBeNil)
+		So(parsed.IsEqual(pubKey), ShouldBeTrue)
+
+		parsed, err = parsePKCS11ECPoint(point)
+		So(err, ShouldBeNil)
+		So(parsed.IsEqual(pubKey), ShouldBeTrue)
+
+		// a raw point whose X starts with 0x3f is also a DER octet string of 63 bytes
+		for point[1] != 0x3f {
+			_, pubKey, _ = asymmetric.GenSecp256k1KeyPair()
+			point = (*btcec.PublicKey)(pubKey).SerializeUncompressed()
+		}
+		parsed, err = parsePKCS11ECPoint(point)
+		So(err, ShouldBeNil)
+		So(parsed.IsEqual(pubKey), ShouldBeTrue)
+
+		_, err = parsePKCS11ECPoint([]byte("not a point"))
+		So(err, ShouldEqual, ErrInvalidECPoint)
+	})
+}
@@ -2,8 +2,9 @@
 package kms
 
 import (
-	"bytes"
+	"crypto/subtle"
 	"errors"
+	"fmt"
 	"os"
 
 	"github.com/btcsuite/btcutil/base58"
@@ -53,6 +54,13 @@ func DecodePrivateKey(keyBytes []byte, masterKey []byte) (key *asymmetric.Privat
 		encData = keyBytes
 	}
 
+	if !isBinaryKey && version == PrivateKeyStoreGCMVersion {
+		return decodePassphrasePrivateKey(encData, masterKey)
+	}
+	if !isBinaryKey && isEd25519KeyVersion(version) {
+		return nil, ErrNotSecp256k1Key
+	}
+
 	if version != 0 && version != PrivateKeyStoreVersion {
 		return nil, ErrInvalidBase58Version
 	}
@@ -63,6 +71,7 @@ func DecodePrivateKey(keyBytes []byte, masterKey []byte) (key *asymmetric.Privat
 			log.Error("decrypt private key error")
 			return
 		}
+		defer zeroBytes(decData)
 
 		// sha256 + privateKey
 		if len(decData) != hash.HashBSize+asymmetric.PrivateKeyBytesLen {
@@ -74,7 +83,7 @@ func DecodePrivateKey(keyBytes []byte, masterKey []byte) (key *asymmetric.Privat
 		}
 
 		computedHash := hash.DoubleHashB(decData[hash.HashBSize:])
-		if !bytes.Equal(computedHash, decData[:hash.HashBSize]) {
+		if subtle.ConstantTimeCompare(computedHash, decData[:hash.HashBSize]) != 1 {
 			return nil, ErrHashNotMatch
 		}
 		key, _ = asymmetric.PrivKeyFromBytes(decData[hash.HashBSize:])
@@ -84,6 +93,7 @@ func DecodePrivateKey(keyBytes []byte, masterKey []byte) (key *asymmetric.Privat
 			log.Error("decrypt private key error")
 			return
 		}
+		defer zeroBytes(decData)
 
 		// privateKey
 		if len(decData) != asymmetric.PrivateKeyBytesLen {
@@ -99,20 +109,44 @@ func DecodePrivateKey(keyBytes []byte, masterKey []byte) (key *asymmetric.Privat
 	return
 }
 
-// LoadPrivateKey loads private key from keyFilePath, and verifies the hash head.
+// LoadPrivateKey loads private key from keyFilePath, and verifies the hash head. The
+// key is read from stdin for conf.StdinPrivateKeyFile, a named pipe or /dev/fd/N is
+// read like a file. The read buffer is zeroed after parsing, a stream ended before a
+// complete key returns an error caused by ErrIncompleteKey. The key files of other
+// key types are ErrNotSecp256k1Key, see LoadTypedPrivateKey.
 func LoadPrivateKey(keyFilePath string, masterKey []byte) (key *asymmetric.PrivateKey, err error) {
-	fileContent, err := os.ReadFile(keyFilePath)
+	err = loadPrivateKeyFile(keyFilePath, func(content []byte) (decodeErr error) {
+		key, decodeErr = DecodePrivateKey(content, masterKey)
+		return
+	})
+	return
+}
+
+// loadPrivateKeyFile reads the private key file at keyFilePath and decodes it by
+// decode, see LoadPrivateKey.
+func loadPrivateKeyFile(keyFilePath string, decode func(content []byte) error) (err error) {
+	fileContent, err := readPrivateKeyFile(keyFilePath)
 	if err != nil {
 		log.WithField("path", keyFilePath).WithError(err).Error("read key file failed")
 		return
 	}
+	defer zeroBytes(fileContent)
+	stream := isKeyStream(keyFilePath)
+	if stream && len(fileContent) == 0 {
+		return ErrIncompleteKey
+	}
 
-	return DecodePrivateKey(fileContent, masterKey)
+	err = decode(fileContent)
+	if stream && errors.Is(err, base58.ErrChecksum) {
+		err = fmt.Errorf("%w: %v", ErrIncompleteKey, err)
+	}
+	return
 }
 
 // EncodePrivateKey encode private to key to string format.
 func EncodePrivateKey(key *asymmetric.PrivateKey, masterKey []byte) (keyBytes []byte, err error) {
 	serializedKey := key.Serialize()
+	defer zeroBytes(serializedKey)
 	encKey, err := symmetric.EncryptWithPassword(serializedKey, masterKey, privateKDFSalt)
 	if err != nil {
 		return
@@ -135,12 +169,16 @@ func SavePrivateKey(keyFilePath string, key *asymmetric.PrivateKey, masterKey []
 	return os.WriteFile(keyFilePath, keyBytes, 0600)
 }
 
-// InitLocalKeyPair initializes local private key.
+// InitLocalKeyPair initializes local private key of any key type, see
+// LoadTypedPrivateKey. A private key stream is read once, it is not read again if
+// the local key is already set.
 func InitLocalKeyPair(privateKeyPath string, masterKey []byte) (err error) {
-	var privateKey *asymmetric.PrivateKey
-	var publicKey *asymmetric.PublicKey
+	var privateKey asymmetric.TypedPrivateKey
 	initLocalKeyStore()
-	privateKey, err = LoadPrivateKey(privateKeyPath, masterKey)
+	if isKeyStream(privateKeyPath) && localKeyIsSet() {
+		return
+	}
+	privateKey, err = LoadTypedPrivateKey(privateKeyPath, masterKey)
 	if err != nil {
 		log.WithError(err).Info("load private key failed")
 		if err == ErrNotKeyFile {
@@ -149,26 +187,25 @@ func InitLocalKeyPair(privateKeyPath string, masterKey []byte) (err error) {
 		}
 		if _, ok := err.(*os.PathError); (ok || err == os.ErrNotExist) && conf.GConf.GenerateKeyPair {
 			log.Info("private key file not exist, generating one")
-			privateKey, publicKey, err = asymmetric.GenSecp256k1KeyPair()
+			var secp *asymmetric.PrivateKey
+			secp, _, err = asymmetric.GenSecp256k1KeyPair()
 			if err != nil {
 				log.WithError(err).Error("generate private key failed")
 				return
 			}
 			log.WithField("path", privateKeyPath).Info("saving new private key file")
-			err = SavePrivateKey(privateKeyPath, privateKey, masterKey)
+			err = SavePrivateKey(privateKeyPath, secp, masterKey)
 			if err != nil {
 				log.WithError(err).Error("save private key failed")
 				return
 			}
+			privateKey = secp
 		} else {
 			log.WithField("path", privateKeyPath).WithError(err).Error("unexpected error while loading private key")
 			return
 		}
 	}
-	if publicKey == nil {
-		publicKey = privateKey.PubKey()
-	}
-	log.Debugf("\n### Public Key ###\n%#x\n### Public Key ###\n", publicKey.Serialize())
-	SetLocalKeyPair(privateKey, publicKey)
+	log.Debugf("\n### Public Key ###\n%#x\n### Public Key ###\n", privateKey.TypedPubKey().Serialize())
+	SetLocalTypedKeyPair(privateKey)
 	return
 }
@@ -2,7 +2,6 @@
 package kms
 
 import (
-	"database/sql"
 	"io"
 	"os"
 	"path/filepath"
@@ -19,18 +18,25 @@ import (
 	"sqlit/src/proto"
 	"sqlit/src/utils"
 	"sqlit/src/utils/log"
-	xs "sqlit/src/dpos/sqlite"
 )
 
-// PublicKeyStore holds db and bucket name.
+// PublicKeyStore holds the backend store and the node cache. The nodes of cache and
+// localNodes are private copies never modified, they are replaced as a whole.
 type PublicKeyStore struct {
-	db *xs.SQLite3
+	store Store
+	// cache holds all the nodes in store, reads are served from it
+	cache map[proto.NodeID]*proto.Node
+	// fileNodes holds the node ids found in the backing file on last load
+	fileNodes map[proto.NodeID]struct{}
+	// localNodes holds the nodes set by this process
+	localNodes map[proto.NodeID]*proto.Node
 }
 
 var (
-	// pks holds the singleton instance
+	// pks holds the singleton instance, the reads hold pksLock for reading and the
+	// writes, reloads and the store access hold it for writing
 	pks     *PublicKeyStore
-	pksLock sync.Mutex
+	pksLock sync.RWMutex
 	// Unittest is a test flag
 	Unittest bool
 )
@@ -42,19 +48,6 @@ var (
 	BP *conf.BPInfo
 )
 
-var (
-	initTableSQL = `CREATE TABLE IF NOT EXISTS "kms" (
-		"id"   TEXT,
-		"node" BLOB,
-		UNIQUE ("id")
-	)`
-	deleteAllSQL    = `DELETE FROM "kms"`
-	deleteRecordSQL = `DELETE FROM "kms" WHERE "id" = ?`
-	setRecordSQL    = `INSERT OR REPLACE INTO "kms" ("id", "node") VALUES(?, ?)`
-	getRecordSQL    = `SELECT "node" FROM "kms" WHERE "id" = ? LIMIT 1`
-	getAllNodeIDSQL = `SELECT "id" FROM "kms"`
-)
-
 func init() {
 	//HACK(auxten) if we were running go test
 	if strings.HasSuffix(os.Args[0], ".test") ||
@@ -107,45 +100,153 @@ var (
 	ErrKeyNotFound = errors.New("key not found")
 	// ErrNodeIDKeyNonceNotMatch indicates node id, key, nonce not match
 	ErrNodeIDKeyNonceNotMatch = errors.New("nodeID, key, nonce not match")
+	// ErrUnknownStoreBackend indicates the configured public keystore backend is not supported
+	ErrUnknownStoreBackend = errors.New("unknown public keystore backend")
+)
+
+const (
+	// SQLiteStoreBackend is the PubKeyStoreBackend of SQLiteStore, the default one.
+	SQLiteStoreBackend = "sqlite"
+	// BoltStoreBackend is the PubKeyStoreBackend of BoltStore.
+	BoltStoreBackend = "bolt"
 )
 
 // InitPublicKeyStore opens a db file, if not exist, creates it.
-// and creates a bucket if not exist.
+// and creates a bucket if not exist. A corrupt db file is moved aside and
+// the keystore is rebuilt from conf.GConf.KnownNodes, a db file of an older version
+// is migrated to StoreVersion and one of a newer version fails. The db file is a
+// BoltStore instead if conf.GConf.PubKeyStoreBackend is BoltStoreBackend.
 func InitPublicKeyStore(dbPath string, initNodes []proto.Node) (err error) {
-	//testFlag := flag.Lookup("test")
-	//log.Debugf("%#v %#v", testFlag, testFlag.Value)
-	// close already opened public key store
-	ClosePublicKeyStore()
+	switch backend := pubKeyStoreBackend(); backend {
+	case "", SQLiteStoreBackend:
+	case BoltStoreBackend:
+		return initPublicKeyStore(func() (store Store, err error) {
+			var s *BoltStore
+			if s, err = OpenBoltStore(dbPath); err != nil {
+				return
+			}
+			return s, nil
+		}, initNodes)
+	default:
+		log.WithField("backend", backend).WithError(ErrUnknownStoreBackend).Error("InitPublicKeyStore failed")
+		return ErrUnknownStoreBackend
+	}
+	return initPublicKeyStore(func() (store Store, err error) {
+		var s *SQLiteStore
+		if s, err = OpenSQLiteStore(dbPath); errors.Cause(err) == ErrCorruptStore {
+			log.WithError(err).WithField("path", dbPath).Error(
+				"public keystore file is corrupt, rebuild it from known nodes")
+			s, err = rebuildSQLiteStore(dbPath)
+		}
+		if err != nil {
+			return
+		}
+		if err = migrateSQLiteStore(s); err != nil {
+			_ = s.Close()
+			return
+		}
+		return s, nil
+	}, initNodes)
+}
 
-	pksLock.Lock()
-	InitBP()
+// pubKeyStoreBackend returns the lower cased conf.GConf.PubKeyStoreBackend, it is
+// SQLiteStoreBackend without conf.GConf.
+func pubKeyStoreBackend() string {
+	if conf.GConf == nil {
+		return SQLiteStoreBackend
+	}
+	return strings.ToLower(conf.GConf.PubKeyStoreBackend)
+}
 
-	var strg *xs.SQLite3
+// rebuildSQLiteStore moves the corrupt keystore file at path to path.corrupt and
+// replaces it by a file holding the valid nodes of conf.GConf.KnownNodes, the
+// caller should hold pksLock.
+func rebuildSQLiteStore(path string) (s *SQLiteStore, err error) {
+	corruptFile := path + ".corrupt"
+	utils.RemoveAll(corruptFile + "*")
+	if err = os.Rename(path, corruptFile); err != nil {
+		err = errors.Wrap(err, "move corrupt keystore file failed")
+		return
+	}
+	// the wal and shm belong to the corrupt file
+	_ = os.Rename(path+"-wal", corruptFile+"-wal")
+	_ = os.Remove(path + "-shm")
 
-	if strg, err = func() (strg *xs.SQLite3, err error) {
-		// test if the keystore is a valid sqlite database
-		// if so, truncate and upgrade to new version
+	entries := knownNodeEntries()
+	if err = writeSQLiteFile(path, entries); err != nil {
+		return
+	}
+	log.WithFields(log.Fields{
+		"path":    path,
+		"corrupt": corruptFile,
+		"nodes":   len(entries),
+	}).Warning("public keystore rebuilt from known nodes")
+	return OpenSQLiteStore(path)
+}
 
-		if err = removeFileIfIsNotSQLite(dbPath); err != nil {
-			return
+// knownNodeEntries encodes the valid nodes of conf.GConf.KnownNodes, the block
+// producer entry without public key takes the key of BP.
+func knownNodeEntries() (entries map[proto.NodeID][]byte) {
+	entries = make(map[proto.NodeID][]byte)
+	if conf.GConf == nil {
+		return
+	}
+	for _, n := range conf.GConf.KnownNodes {
+		if n.PublicKey == nil && BP != nil && n.ID == BP.NodeID {
+			n.PublicKey, n.Nonce = BP.PublicKey, BP.Nonce
 		}
-		if strg, err = xs.NewSqlite(dbPath); err != nil {
-			return
+		if err := validateNode(&n, true); err != nil {
+			log.WithField("node", n.ID).WithError(err).Warning("skip invalid known node")
+			continue
 		}
-		if _, err = strg.Writer().Exec(initTableSQL); err != nil {
-			return
+		nodeBuf, err := utils.EncodeMsgPack(&n)
+		if err != nil {
+			log.WithField("node", n.ID).WithError(err).Warning("encode known node failed")
+			continue
 		}
+		entries[n.ID] = nodeBuf.Bytes()
+	}
+	return
+}
 
-		return
-	}(); err != nil {
+// InitPublicKeyStoreWithStore initializes the public keystore on store, such as
+// a BoltStore. The store is owned and closed by the public keystore.
+func InitPublicKeyStoreWithStore(store Store, initNodes []proto.Node) (err error) {
+	if store == nil {
+		return ErrPKSNotInitialized
+	}
+	return initPublicKeyStore(func() (Store, error) {
+		return store, nil
+	}, initNodes)
+}
+
+func initPublicKeyStore(open func() (Store, error), initNodes []proto.Node) (err error) {
+	//testFlag := flag.Lookup("test")
+	//log.Debugf("%#v %#v", testFlag, testFlag.Value)
+	// close already opened public key store
+	ClosePublicKeyStore()
+
+	pksLock.Lock()
+	InitBP()
+
+	var store Store
+	if store, err = open(); err != nil {
 		pksLock.Unlock()
 		log.WithError(err).Error("InitPublicKeyStore failed")
 		return
 	}
 
+	nodes, loadErr := loadStoreNodes(store)
+	if loadErr != nil {
+		log.WithError(loadErr).Warning("load existing public keystore nodes failed")
+	}
+
 	// pks is the singleton instance
 	pks = &PublicKeyStore{
-		db: strg,
+		store:      store,
+		cache:      nodes,
+		fileNodes:  nodeIDSet(nodes),
+		localNodes: make(map[proto.NodeID]*proto.Node),
 	}
 	pksLock.Unlock()
 
@@ -161,8 +262,11 @@ func InitPublicKeyStore(dbPath string, initNodes []proto.Node) (err error) {
 }
 
 // GetPublicKey gets a PublicKey of given id
-// Returns an error if the id was not found.
+// Returns an error if the id was not found or its key is revoked.
 func GetPublicKey(id proto.NodeID) (publicKey *asymmetric.PublicKey, err error) {
+	if err = checkNotRevoked(id); err != nil {
+		return
+	}
 	node, err := GetNodeInfo(id)
 	if err == nil {
 		publicKey = node.PublicKey
@@ -173,59 +277,35 @@ func GetPublicKey(id proto.NodeID) (publicKey *asymmetric.PublicKey, err error)
 // GetNodeInfo gets node info of given id
 // Returns an error if the id was not found.
 func GetNodeInfo(id proto.NodeID) (nodeInfo *proto.Node, err error) {
-	pksLock.Lock()
-	defer pksLock.Unlock()
-	if pks == nil || pks.db == nil {
+	pksLock.RLock()
+	defer pksLock.RUnlock()
+	if pks == nil || pks.store == nil {
 		return nil, ErrPKSNotInitialized
 	}
 
-	if err = func() (err error) {
-		var rawNodeInfo []byte
-		if err = pks.db.Writer().QueryRow(getRecordSQL, string(id)).Scan(&rawNodeInfo); err != nil {
-			if errors.Cause(err) == sql.ErrNoRows {
-				err = ErrKeyNotFound
-			}
-			return
-		}
-		err = utils.DecodeMsgPack(rawNodeInfo, &nodeInfo)
-		log.Debugf("get node info: %#v", nodeInfo)
-
+	cached, ok := pks.cache[id]
+	if !ok {
+		err = errors.Wrap(ErrKeyNotFound, "get node info failed")
 		return
-	}(); err != nil {
-		err = errors.Wrap(err, "get node info failed")
 	}
+	// return a copy, so the cache is not modified by caller
+	nodeInfo = cloneNode(cached)
+	log.Debugf("get node info: %#v", nodeInfo)
 	return
 }
 
 // GetAllNodeID get all node ids exist in store.
 func GetAllNodeID() (nodeIDs []proto.NodeID, err error) {
-	if pks == nil || pks.db == nil {
+	pksLock.RLock()
+	defer pksLock.RUnlock()
+	if pks == nil || pks.store == nil {
 		return nil, ErrPKSNotInitialized
 	}
 
-	if err = func() (err error) {
-		var rows *sql.Rows
-		if rows, err = pks.db.Writer().Query(getAllNodeIDSQL); err != nil {
-			return
-		}
-
-		defer rows.Close()
-
-		for rows.Next() {
-			var rawNodeID string
-			if err = rows.Scan(&rawNodeID); err != nil {
-				return
-			}
-
-			nodeIDs = append(nodeIDs, proto.NodeID(rawNodeID))
-		}
-
-		return
-	}(); err != nil {
-		err = errors.Wrap(err, "get all node id failed")
+	for id := range pks.cache {
+		nodeIDs = append(nodeIDs, id)
 	}
 	return
-
 }
 
 // SetPublicKey verifies nonce and set Public Key.
@@ -239,34 +319,54 @@ func SetPublicKey(id proto.NodeID, nonce mine.Uint256, publicKey *asymmetric.Pub
 	return SetNode(nodeInfo)
 }
 
-// SetNode verifies nonce and sets {proto.Node.ID: proto.Node}.
-func SetNode(nodeInfo *proto.Node) (err error) {
+// SetNode verifies nonce and sets {proto.Node.ID: proto.Node}, a node failed the
+// verification is rejected by *InvalidNodeError caused by ErrNodeIDKeyNonceNotMatch,
+// a revoked node by one caused by ErrNodeRevoked. WithoutVerifyID skips the
+// verification for legacy entries.
+func SetNode(nodeInfo *proto.Node, opts ...SetNodesOpt) (err error) {
 	if nodeInfo == nil {
 		return ErrNilNode
 	}
-	if !Unittest {
-		if !IsIDPubNonceValid(nodeInfo.ID.ToRawNodeID(), &nodeInfo.Nonce, nodeInfo.PublicKey) {
-			return ErrNodeIDKeyNonceNotMatch
+	if IsRevoked(nodeInfo.ID) {
+		return &InvalidNodeError{ID: nodeInfo.ID, Err: ErrNodeRevoked}
+	}
+	if o := newSetNodesOptions(opts); !Unittest && !o.skipVerifyID {
+		key, err := nodeInfo.TypedPublicKey()
+		if err != nil || !IsIDTypedPubNonceValid(nodeInfo.ID.ToRawNodeID(), &nodeInfo.Nonce, key) {
+			return &InvalidNodeError{ID: nodeInfo.ID, Err: ErrNodeIDKeyNonceNotMatch}
 		}
 	}
 
 	return setNode(nodeInfo)
 }
 
-// IsIDPubNonceValid returns if `id == HashBlock(key, nonce)`.
+// IsIDPubNonceValid returns if id is proto.DeriveNodeID(key, nonce).
 func IsIDPubNonceValid(id *proto.RawNodeID, nonce *mine.Uint256, key *asymmetric.PublicKey) bool {
-	if key == nil || id == nil || nonce == nil {
+	if key == nil {
+		return false
+	}
+	return IsIDTypedPubNonceValid(id, nonce, key)
+}
+
+// IsIDTypedPubNonceValid returns if id is proto.DeriveNodeID(key, nonce) for key of any
+// key type.
+func IsIDTypedPubNonceValid(id *proto.RawNodeID, nonce *mine.Uint256, key asymmetric.TypedPublicKey) bool {
+	if id == nil || nonce == nil {
+		return false
+	}
+	derived, err := proto.DeriveNodeID(key, *nonce)
+	if err != nil {
 		return false
 	}
-	keyHash := mine.HashBlock(key.Serialize(), *nonce)
-	return keyHash.IsEqual(&id.Hash)
+	derivedID := derived.ToRawNodeID()
+	return derivedID != nil && derivedID.ConstantTimeEqual(id.Hash)
 }
 
 // setNode sets id and its publicKey.
 func setNode(nodeInfo *proto.Node) (err error) {
 	pksLock.Lock()
 	defer pksLock.Unlock()
-	if pks == nil || pks.db == nil {
+	if pks == nil || pks.store == nil {
 		return ErrPKSNotInitialized
 	}
 
@@ -277,10 +377,13 @@ func setNode(nodeInfo *proto.Node) (err error) {
 	}
 	log.Debugf("set node: %#v", nodeInfo)
 
-	_, err = pks.db.Writer().Exec(setRecordSQL, string(nodeInfo.ID), nodeBuf.Bytes())
+	err = pks.store.Put(nodeInfo.ID, nodeBuf.Bytes())
 	if err != nil {
 		err = errors.Wrap(err, "set node info failed")
+		return
 	}
+	pks.localNodes[nodeInfo.ID] = pks.cacheNode(nodeInfo)
+	audit(AuditSetNode, nodeInfo.ID)
 
 	return
 }
@@ -289,14 +392,18 @@ func setNode(nodeInfo *proto.Node) (err error) {
 func DelNode(id proto.NodeID) (err error) {
 	pksLock.Lock()
 	defer pksLock.Unlock()
-	if pks == nil || pks.db == nil {
+	if pks == nil || pks.store == nil {
 		return ErrPKSNotInitialized
 	}
 
-	_, err = pks.db.Writer().Exec(deleteRecordSQL, string(id))
+	err = pks.store.Delete(id)
 	if err != nil {
 		err = errors.Wrap(err, "del node failed")
+		return
 	}
+	delete(pks.cache, id)
+	delete(pks.localNodes, id)
+	audit(AuditDelNode, id)
 	return
 }
 
@@ -304,12 +411,13 @@ func DelNode(id proto.NodeID) (err error) {
 func removeBucket() (err error) {
 	pksLock.Lock()
 	defer pksLock.Unlock()
-	if pks != nil {
-		_, err = pks.db.Writer().Exec(deleteAllSQL)
+	if pks != nil && pks.store != nil {
+		err = putAll(pks.store, nil, true)
 		if err != nil {
 			err = errors.Wrap(err, "remove bucket failed")
 			return
 		}
+		pks.cache = make(map[proto.NodeID]*proto.Node)
 	}
 	return
 }
@@ -326,11 +434,41 @@ func ClosePublicKeyStore() {
 	pksLock.Lock()
 	defer pksLock.Unlock()
 	if pks != nil {
-		_ = pks.db.Close()
+		if pks.store != nil {
+			_ = pks.store.Close()
+		}
 		pks = nil
 	}
 }
 
+// cacheNode puts a copy of node into cache and returns it, so the cache is not
+// modified by caller. The caller should hold pksLock for writing.
+func (s *PublicKeyStore) cacheNode(node *proto.Node) (cached *proto.Node) {
+	cached = cloneNode(node)
+	s.cache[node.ID] = cached
+	return
+}
+
+// cloneNode makes a copy of node sharing no mutable memory with it, the public key
+// is shared as it is never modified.
+func cloneNode(node *proto.Node) (cloned *proto.Node) {
+	cloned = new(proto.Node)
+	*cloned = *node
+	if node.Addrs != nil {
+		cloned.Addrs = append([]string(nil), node.Addrs...)
+	}
+	if node.Ed25519PublicKey != nil {
+		cloned.Ed25519PublicKey = append(asymmetric.Ed25519PublicKey(nil), node.Ed25519PublicKey...)
+	}
+	if node.Tags != nil {
+		cloned.Tags = make(map[string]string, len(node.Tags))
+		for k, v := range node.Tags {
+			cloned.Tags[k] = v
+		}
+	}
+	return
+}
+
 func removeFileIfIsNotSQLite(filename string) (err error) {
 	var (
 		f          *os.File
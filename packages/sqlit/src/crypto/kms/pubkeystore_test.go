@@ -2,14 +2,17 @@
 package kms
 
 import (
+	"fmt"
 	"os"
 	"reflect"
+	"sync"
 	"testing"
 
 	"github.com/pkg/errors"
 	. "github.com/smartystreets/goconvey/convey"
 	yaml "gopkg.in/yaml.v2"
 
+	"sqlit/src/conf"
 	"sqlit/src/crypto/asymmetric"
 	"sqlit/src/pow/cpuminer"
 	"sqlit/src/proto"
@@ -71,10 +74,10 @@ func TestDB(t *testing.T) {
 		So(err, ShouldBeNil)
 
 		err = SetPublicKey(BP.NodeID, cpuminer.Uint256{}, BP.PublicKey)
-		So(err, ShouldEqual, ErrNodeIDKeyNonceNotMatch)
+		So(errors.Cause(err), ShouldEqual, ErrNodeIDKeyNonceNotMatch)
 
 		err = SetPublicKey(proto.NodeID("00"+BP.NodeID), BP.Nonce, BP.PublicKey)
-		So(err, ShouldEqual, ErrNodeIDKeyNonceNotMatch)
+		So(errors.Cause(err), ShouldEqual, ErrNodeIDKeyNonceNotMatch)
 
 		pubk, err = GetPublicKey(proto.NodeID("1111"))
 		So(pubk, ShouldNotBeNil)
@@ -220,6 +223,60 @@ func TestInvalidKeystoreFileRecover(t *testing.T) {
 	})
 }
 
+func TestCorruptKeystoreRecover(t *testing.T) {
+	Convey("truncated keystore is rebuilt from known nodes", t, func() {
+		ClosePublicKeyStore()
+		utils.RemoveAll(dbFile + "*")
+		defer utils.RemoveAll(dbFile + "*")
+		defer ClosePublicKeyStore()
+		defer func(saved []proto.Node) { conf.GConf.KnownNodes = saved }(conf.GConf.KnownNodes)
+
+		// fill the file with pages to truncate
+		So(InitPublicKeyStore(dbFile, nil), ShouldBeNil)
+		for i := 0; i < 256; i++ {
+			_, publicKey, err := asymmetric.GenSecp256k1KeyPair()
+			So(err, ShouldBeNil)
+			So(setNode(&proto.Node{ID: proto.NodeID(fmt.Sprintf("%064x", i)), PublicKey: publicKey}), ShouldBeNil)
+		}
+		ClosePublicKeyStore()
+		st, err := os.Stat(dbFile)
+		So(err, ShouldBeNil)
+		So(os.Truncate(dbFile, st.Size()/2), ShouldBeNil)
+
+		_, publicKey, err := asymmetric.GenSecp256k1KeyPair()
+		So(err, ShouldBeNil)
+		mined := MineNodeNonce(publicKey, 0)
+		minerID := proto.NodeID(mined.Hash.String())
+		conf.GConf.KnownNodes = []proto.Node{
+			{ID: BP.NodeID, Role: proto.Leader},
+			{ID: minerID, Role: proto.Miner, PublicKey: publicKey, Nonce: mined.Nonce},
+			// a known node without public key is skipped
+			{ID: proto.NodeID("2222"), Role: proto.Miner},
+		}
+		So(InitPublicKeyStore(dbFile, nil), ShouldBeNil)
+		ids, err := GetAllNodeID()
+		So(err, ShouldBeNil)
+		So(ids, ShouldHaveLength, 2)
+		So(ids, ShouldContain, BP.NodeID)
+		So(ids, ShouldContain, minerID)
+		node, err := GetNodeInfo(minerID)
+		So(err, ShouldBeNil)
+		So(node.PublicKey.IsEqual(publicKey), ShouldBeTrue)
+		So(node.Role, ShouldEqual, proto.Miner)
+		bpKey, err := GetPublicKey(BP.NodeID)
+		So(err, ShouldBeNil)
+		So(bpKey.IsEqual(BP.PublicKey), ShouldBeTrue)
+
+		// the corrupt file is kept aside and the rebuilt one survives reopen
+		So(utils.Exist(dbFile+".corrupt"), ShouldBeTrue)
+		ClosePublicKeyStore()
+		So(InitPublicKeyStore(dbFile, nil), ShouldBeNil)
+		ids, err = GetAllNodeID()
+		So(err, ShouldBeNil)
+		So(ids, ShouldHaveLength, 2)
+	})
+}
+
 func TestErrorPath(t *testing.T) {
 	Convey("can not init db", t, func() {
 		ClosePublicKeyStore()
@@ -262,3 +319,78 @@ func TestMarshalBPInfo(t *testing.T) {
 		log.Debugf("BP:\n%s", sBP)
 	})
 }
+
+func TestConcurrentSetNode(t *testing.T) {
+	Convey("the nodes are set and read concurrently", t, func() {
+		ClosePublicKeyStore()
+		utils.RemoveAll(dbFile + "*")
+		defer utils.RemoveAll(dbFile + "*")
+		defer ClosePublicKeyStore()
+		So(InitPublicKeyStore(dbFile, nil), ShouldBeNil)
+
+		_, pubKey, err := asymmetric.GenSecp256k1KeyPair()
+		So(err, ShouldBeNil)
+		const (
+			writers = 4
+			rounds  = 20
+		)
+		nodeID := func(w, i int) proto.NodeID {
+			return proto.NodeID(fmt.Sprintf("%062x%02x", w*rounds+i, 1))
+		}
+		// the reloads read a file holding a node, it is checkpointed by the reopen
+		So(SetNode(&proto.Node{ID: nodeID(0, 0)[:62] + "00", PublicKey: pubKey}, WithoutVerifyID()), ShouldBeNil)
+		ClosePublicKeyStore()
+		So(InitPublicKeyStore(dbFile, nil), ShouldBeNil)
+		var wg sync.WaitGroup
+		errs := make(chan error, 4*writers*rounds)
+		for w := 0; w < writers; w++ {
+			wg.Add(2)
+			go func(w int) {
+				defer wg.Done()
+				for i := 0; i < rounds; i++ {
+					node := &proto.Node{
+						ID: nodeID(w, i), PublicKey: pubKey, Addr: "10.0.0.1:4661",
+						Addrs: []string{"10.0.0.2:4661"}, Tags: map[string]string{"writer": fmt.Sprint(w)},
+					}
+					if i%2 == 0 {
+						errs <- SetNode(node, WithoutVerifyID())
+					} else {
+						errs <- SetNodes([]*proto.Node{node}, WithoutVerifyID())
+					}
+					// the caller owns its node after it is set
+					node.Addrs[0] = "10.0.0.3:4661"
+					node.Tags["writer"] = "changed"
+				}
+			}(w)
+			go func(w int) {
+				defer wg.Done()
+				for i := 0; i < rounds; i++ {
+					if node, err := GetNodeInfo(nodeID(w, i)); err == nil {
+						node.Tags["writer"] = "changed"
+					}
+					_, err := GetAllNodeID()
+					errs <- err
+					_, err = NodesByTag("writer", fmt.Sprint(w))
+					errs <- err
+					if i%5 == 0 {
+						errs <- ReloadPublicKeyStore()
+					}
+				}
+			}(w)
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			So(err, ShouldBeNil)
+		}
+
+		for w := 0; w < writers; w++ {
+			nodes, err := NodesByTag("writer", fmt.Sprint(w))
+			So(err, ShouldBeNil)
+			So(nodes, ShouldHaveLength, rounds)
+			for _, node := range nodes {
+				So(node.Addrs, ShouldResemble, []string{"10.0.0.2:4661"})
+			}
+		}
+	})
+}
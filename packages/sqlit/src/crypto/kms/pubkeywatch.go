@@ -0,0 +1,224 @@
+
+package kms
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/pkg/errors"
+
+	xs "sqlit/src/dpos/sqlite"
+	"sqlit/src/proto"
+	"sqlit/src/utils"
+	"sqlit/src/utils/log"
+)
+
+// ErrStoreNotWatchable indicates the public keystore is not backed by a watchable file.
+var ErrStoreNotWatchable = errors.New("public keystore store is not watchable")
+
+// PublicKeyStoreReloadDelay is the delay to coalesce file change events before reload.
+var PublicKeyStoreReloadDelay = 500 * time.Millisecond
+
+// WatchPublicKeyStore watches the backing file of public keystore and reloads
+// it on change until ctx is done. Nodes set by this process and absent in the
+// file are kept, nodes removed from the file are removed from the keystore.
+func WatchPublicKeyStore(ctx context.Context) (err error) {
+	pksLock.RLock()
+	if pks == nil || pks.store == nil {
+		pksLock.RUnlock()
+		return ErrPKSNotInitialized
+	}
+	store, ok := pks.store.(*SQLiteStore)
+	pksLock.RUnlock()
+	if !ok {
+		return ErrStoreNotWatchable
+	}
+	path := store.path
+
+	var watcher *fsnotify.Watcher
+	if watcher, err = fsnotify.NewWatcher(); err != nil {
+		err = errors.Wrap(err, "create keystore watcher failed")
+		return
+	}
+	// watch the directory, so replacing the file by rename is noticed
+	if err = watcher.Add(filepath.Dir(path)); err != nil {
+		_ = watcher.Close()
+		err = errors.Wrap(err, "watch keystore failed")
+		return
+	}
+
+	go func() {
+		defer watcher.Close()
+		var (
+			timer  = time.NewTimer(PublicKeyStoreReloadDelay)
+			reload <-chan time.Time
+		)
+		timer.Stop()
+		defer timer.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case ev, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(ev.Name) != filepath.Clean(path) ||
+					ev.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) == 0 {
+					continue
+				}
+				timer.Reset(PublicKeyStoreReloadDelay)
+				reload = timer.C
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.WithError(err).Warning("watch public keystore error")
+			case <-reload:
+				reload = nil
+				// the writes of this process notify too, they are in the keystore already
+				pksLock.RLock()
+				changed := store.changedByOthers()
+				pksLock.RUnlock()
+				if !changed {
+					continue
+				}
+				if err := ReloadPublicKeyStore(); err != nil {
+					log.WithError(err).WithField("path", path).Error(
+						"reload public keystore failed, keep current keystore")
+				}
+			}
+		}
+	}()
+	return
+}
+
+// ReloadPublicKeyStore reopens the backing file of public keystore. The current
+// keystore is kept untouched if the file is missing or malformed.
+func ReloadPublicKeyStore() (err error) {
+	pksLock.Lock()
+	defer pksLock.Unlock()
+	if pks == nil || pks.store == nil {
+		return ErrPKSNotInitialized
+	}
+	store, ok := pks.store.(*SQLiteStore)
+	if !ok {
+		return ErrStoreNotWatchable
+	}
+
+	if _, err = os.Stat(store.path); err != nil {
+		err = errors.Wrap(err, "stat keystore file failed")
+		return
+	}
+
+	var nodes map[proto.NodeID]*proto.Node
+	// validate a snapshot of the file first, the opened keystore shares
+	// cache and wal with the same file name
+	if nodes, err = loadSnapshotNodes(store.path); err != nil {
+		return
+	}
+	if err = store.reopen(); err != nil {
+		err = errors.Wrap(err, "open keystore file failed")
+		return
+	}
+
+	var added, removed int
+	for id := range pks.fileNodes {
+		if _, ok := nodes[id]; !ok {
+			delete(pks.localNodes, id)
+			removed++
+		}
+	}
+	for id := range nodes {
+		if _, ok := pks.fileNodes[id]; !ok {
+			added++
+		}
+	}
+	// keep nodes set by this process but not written to the file yet
+	for id, node := range pks.localNodes {
+		if _, ok := nodes[id]; ok {
+			continue
+		}
+		nodeBuf, err := utils.EncodeMsgPack(node)
+		if err != nil {
+			log.WithError(err).WithField("node", id).Warning("encode local node failed")
+			continue
+		}
+		if err = store.Put(id, nodeBuf.Bytes()); err != nil {
+			log.WithError(err).WithField("node", id).Warning("restore local node failed")
+			continue
+		}
+		nodes[id] = node
+	}
+	pks.cache = nodes
+	pks.fileNodes = nodeIDSet(nodes)
+
+	log.WithFields(log.Fields{
+		"path":    store.path,
+		"added":   added,
+		"removed": removed,
+		"total":   len(nodes),
+	}).Info("public keystore reloaded")
+	return
+}
+
+// loadSnapshotNodes copies the keystore file aside and reads all nodes in it.
+func loadSnapshotNodes(path string) (nodes map[proto.NodeID]*proto.Node, err error) {
+	snapshot := path + ".reload"
+	defer utils.RemoveAll(snapshot + "*")
+	if _, err = utils.CopyFile(path, snapshot); err != nil {
+		err = errors.Wrap(err, "snapshot keystore file failed")
+		return
+	}
+	var strg *xs.SQLite3
+	if strg, err = xs.NewSqlite(snapshot); err != nil {
+		err = errors.Wrap(err, "open keystore file failed")
+		return
+	}
+	defer strg.Close()
+	return loadAllNodes(strg.Reader())
+}
+
+// loadAllNodes reads and decodes all nodes in db.
+func loadAllNodes(db *sql.DB) (nodes map[proto.NodeID]*proto.Node, err error) {
+	var rows *sql.Rows
+	if rows, err = db.Query(getAllNodeSQL); err != nil {
+		err = errors.Wrap(err, "load keystore nodes failed")
+		return
+	}
+	defer rows.Close()
+
+	nodes = make(map[proto.NodeID]*proto.Node)
+	for rows.Next() {
+		var (
+			rawNodeID   string
+			rawNodeInfo []byte
+			nodeInfo    *proto.Node
+		)
+		if err = rows.Scan(&rawNodeID, &rawNodeInfo); err != nil {
+			err = errors.Wrap(err, "scan keystore node failed")
+			return
+		}
+		if err = utils.DecodeMsgPack(rawNodeInfo, &nodeInfo); err != nil {
+			err = errors.Wrapf(err, "decode keystore node %s failed", rawNodeID)
+			return
+		}
+		nodes[proto.NodeID(rawNodeID)] = nodeInfo
+	}
+	if err = rows.Err(); err != nil {
+		err = errors.Wrap(err, "load keystore nodes failed")
+	}
+	return
+}
+
+func nodeIDSet(nodes map[proto.NodeID]*proto.Node) (ids map[proto.NodeID]struct{}) {
+	ids = make(map[proto.NodeID]struct{}, len(nodes))
+	for id := range nodes {
+		ids[id] = struct{}{}
+	}
+	return
+}
@@ -0,0 +1,129 @@
+
+package kms
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	. "github.com/smartystreets/goconvey/convey"
+
+	"sqlit/src/crypto/asymmetric"
+	xs "sqlit/src/dpos/sqlite"
+	"sqlit/src/pow/cpuminer"
+	"sqlit/src/proto"
+	"sqlit/src/utils"
+)
+
+const watchDBFile = ".test.watch.keystore"
+
+func writeKeystoreFile(path string, nodes ...*proto.Node) (err error) {
+	strg, err := xs.NewSqlite(path)
+	if err != nil {
+		return
+	}
+	defer strg.Close()
+	if _, err = strg.Writer().Exec(initTableSQL); err != nil {
+		return
+	}
+	for _, n := range nodes {
+		nodeBuf, err := utils.EncodeMsgPack(n)
+		if err != nil {
+			return err
+		}
+		if _, err = strg.Writer().Exec(setRecordSQL, string(n.ID), nodeBuf.Bytes()); err != nil {
+			return err
+		}
+	}
+	return
+}
+
+func TestWatchPublicKeyStore(t *testing.T) {
+	newNode := func(id string) *proto.Node {
+		_, pub, _ := asymmetric.GenSecp256k1KeyPair()
+		return &proto.Node{ID: proto.NodeID(id), PublicKey: pub, Nonce: cpuminer.Uint256{}}
+	}
+	waitFor := func(cond func() bool) bool {
+		for i := 0; i < 100; i++ {
+			if cond() {
+				return true
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+		return false
+	}
+	hasNode := func(id proto.NodeID) func() bool {
+		return func() bool {
+			_, err := GetNodeInfo(id)
+			return err == nil
+		}
+	}
+
+	Convey("watch not initialized keystore", t, func() {
+		ClosePublicKeyStore()
+		So(WatchPublicKeyStore(context.Background()), ShouldEqual, ErrPKSNotInitialized)
+		So(ReloadPublicKeyStore(), ShouldEqual, ErrPKSNotInitialized)
+	})
+	Convey("reload changed keystore file", t, func() {
+		ClosePublicKeyStore()
+		utils.RemoveAll(watchDBFile + "*")
+		defer utils.RemoveAll(watchDBFile + "*")
+		defer ClosePublicKeyStore()
+
+		fileNode1, fileNode2, localNode := newNode("1111"), newNode("2222"), newNode("3333")
+		So(writeKeystoreFile(watchDBFile, fileNode1), ShouldBeNil)
+		So(InitPublicKeyStore(watchDBFile, nil), ShouldBeNil)
+		So(setNode(localNode), ShouldBeNil)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		savedDelay := PublicKeyStoreReloadDelay
+		Reset(func() { PublicKeyStoreReloadDelay = savedDelay })
+		PublicKeyStoreReloadDelay = 50 * time.Millisecond
+		So(WatchPublicKeyStore(ctx), ShouldBeNil)
+
+		// replace the file with a new one holding node2 only
+		tmpFile := watchDBFile + ".new"
+		So(writeKeystoreFile(tmpFile, fileNode2), ShouldBeNil)
+		So(os.Rename(tmpFile, watchDBFile), ShouldBeNil)
+		utils.RemoveAll(tmpFile + "*")
+
+		So(waitFor(hasNode(fileNode2.ID)), ShouldBeTrue)
+		So(waitFor(func() bool { return !hasNode(fileNode1.ID)() }), ShouldBeTrue)
+		info, err := GetNodeInfo(localNode.ID)
+		So(err, ShouldBeNil)
+		So(info.PublicKey.IsEqual(localNode.PublicKey), ShouldBeTrue)
+
+		// the writes of the keystore itself are not reloaded
+		pksLock.RLock()
+		store := pks.store.(*SQLiteStore)
+		pksLock.RUnlock()
+		changedByOthers := func() bool {
+			pksLock.RLock()
+			defer pksLock.RUnlock()
+			return store.changedByOthers()
+		}
+		So(waitFor(func() bool { return !changedByOthers() }), ShouldBeTrue)
+		So(setNode(newNode("4444")), ShouldBeNil)
+		So(changedByOthers(), ShouldBeFalse)
+		other, err := xs.NewSqlite(watchDBFile)
+		So(err, ShouldBeNil)
+		nodeBuf, err := utils.EncodeMsgPack(newNode("5555"))
+		So(err, ShouldBeNil)
+		_, err = other.Writer().Exec(setRecordSQL, "5555", nodeBuf.Bytes())
+		So(err, ShouldBeNil)
+		_, err = other.Writer().Exec(checkpointSQL)
+		So(err, ShouldBeNil)
+		So(other.Close(), ShouldBeNil)
+		So(waitFor(hasNode("5555")), ShouldBeTrue)
+
+		// malformed file is ignored
+		So(os.WriteFile(watchDBFile, []byte("not a keystore"), 0600), ShouldBeNil)
+		So(errors.Cause(ReloadPublicKeyStore()), ShouldNotBeNil)
+		time.Sleep(3 * PublicKeyStoreReloadDelay)
+		So(hasNode(fileNode2.ID)(), ShouldBeTrue)
+		So(hasNode(localNode.ID)(), ShouldBeTrue)
+	})
+}
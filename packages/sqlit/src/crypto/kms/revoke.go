@@ -0,0 +1,194 @@
+
+package kms
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"sqlit/src/conf"
+	"sqlit/src/proto"
+)
+
+var (
+	// ErrNodeRevoked indicates the node key is in the revocation list
+	ErrNodeRevoked = errors.New("node key revoked")
+	// ErrRevokeLocalNode indicates the node to revoke is the local node
+	ErrRevokeLocalNode = errors.New("can not revoke the local node")
+)
+
+// revocation holds the revoked node ids, changes are written to path if it is set.
+var revocation = struct {
+	sync.RWMutex
+	nodes map[proto.NodeID]struct{}
+	path  string
+}{
+	nodes: make(map[proto.NodeID]struct{}),
+}
+
+// LoadRevokedNodes replaces the revocation list by the one in the file at path and
+// persists the later changes to it, a missing file is an empty list. An empty path
+// keeps the list in memory only.
+func LoadRevokedNodes(path string) (err error) {
+	nodes := make(map[proto.NodeID]struct{})
+	if path != "" {
+		var ids []proto.NodeID
+		if ids, err = readRevokedFile(path); err != nil {
+			return
+		}
+		for _, id := range ids {
+			nodes[id] = struct{}{}
+		}
+	}
+	revocation.Lock()
+	defer revocation.Unlock()
+	revocation.nodes, revocation.path = nodes, path
+	return
+}
+
+// RevokeNode marks the key of id untrusted, VerifyNodeSignature and SetNode reject it
+// from now on even if the node remains in the public keystore or the config. The local
+// node can not be revoked.
+func RevokeNode(id proto.NodeID) (err error) {
+	return revokeNodes([]proto.NodeID{id})
+}
+
+// UnrevokeNode removes id from the revocation list, it is not an error if id is not
+// revoked. The node has to be set again to be used.
+func UnrevokeNode(id proto.NodeID) (err error) {
+	revocation.Lock()
+	defer revocation.Unlock()
+	if _, ok := revocation.nodes[id]; !ok {
+		return
+	}
+	nodes := copyRevokedSet(revocation.nodes)
+	delete(nodes, id)
+	if err = writeRevokedFile(revocation.path, nodes); err != nil {
+		return
+	}
+	revocation.nodes = nodes
+	audit(AuditUnrevokeNode, id)
+	return
+}
+
+// IsRevoked returns if the key of id is revoked.
+func IsRevoked(id proto.NodeID) bool {
+	revocation.RLock()
+	defer revocation.RUnlock()
+	_, ok := revocation.nodes[id]
+	return ok
+}
+
+// RevokedNodes returns the revoked node ids sorted.
+func RevokedNodes() (ids []proto.NodeID) {
+	revocation.RLock()
+	defer revocation.RUnlock()
+	return sortedRevokedIDs(revocation.nodes)
+}
+
+// revokeNodes adds ids to the revocation list at once, nothing is revoked if any one
+// is the local node or the list can not be persisted.
+func revokeNodes(ids []proto.NodeID) (err error) {
+	for _, id := range ids {
+		if isLocalNode(id) {
+			return errors.Wrapf(ErrRevokeLocalNode, "revoke node %s", id)
+		}
+	}
+
+	revocation.Lock()
+	defer revocation.Unlock()
+	var added []proto.NodeID
+	nodes := copyRevokedSet(revocation.nodes)
+	for _, id := range ids {
+		if _, ok := nodes[id]; !ok {
+			nodes[id] = struct{}{}
+			added = append(added, id)
+		}
+	}
+	if len(added) == 0 {
+		return
+	}
+	if err = writeRevokedFile(revocation.path, nodes); err != nil {
+		return
+	}
+	revocation.nodes = nodes
+	for _, id := range added {
+		audit(AuditRevokeNode, id)
+	}
+	return
+}
+
+// isLocalNode returns if id is the local node id or the ThisNodeID of config.
+func isLocalNode(id proto.NodeID) bool {
+	if localID, err := GetLocalNodeID(); err == nil && localID == id {
+		return true
+	}
+	return conf.GConf != nil && conf.GConf.ThisNodeID != "" && conf.GConf.ThisNodeID == id
+}
+
+// checkNotRevoked returns ErrNodeRevoked wrapped with id if id is revoked.
+func checkNotRevoked(id proto.NodeID) error {
+	if IsRevoked(id) {
+		return errors.Wrapf(ErrNodeRevoked, "node %s", id)
+	}
+	return nil
+}
+
+func copyRevokedSet(nodes map[proto.NodeID]struct{}) (copied map[proto.NodeID]struct{}) {
+	copied = make(map[proto.NodeID]struct{}, len(nodes)+1)
+	for id := range nodes {
+		copied[id] = struct{}{}
+	}
+	return
+}
+
+func sortedRevokedIDs(nodes map[proto.NodeID]struct{}) (ids []proto.NodeID) {
+	ids = make([]proto.NodeID, 0, len(nodes))
+	for id := range nodes {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return
+}
+
+// readRevokedFile reads the JSON list of node ids written by writeRevokedFile.
+func readRevokedFile(path string) (ids []proto.NodeID, err error) {
+	content, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		err = errors.Wrap(err, "read revoked nodes file failed")
+		return
+	}
+	if err = json.Unmarshal(content, &ids); err != nil {
+		err = errors.Wrap(err, "decode revoked nodes file failed")
+	}
+	return
+}
+
+// writeRevokedFile writes nodes to path as a sorted JSON list, the file is replaced by
+// rename like the peers file. Nothing is written for an empty path.
+func writeRevokedFile(path string, nodes map[proto.NodeID]struct{}) (err error) {
+	if path == "" {
+		return
+	}
+	out, err := json.MarshalIndent(sortedRevokedIDs(nodes), "", "  ")
+	if err != nil {
+		err = errors.Wrap(err, "encode revoked nodes failed")
+		return
+	}
+	tmpFile := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err = os.WriteFile(tmpFile, append(out, '\n'), 0600); err != nil {
+		err = errors.Wrap(err, "write revoked nodes file failed")
+		return
+	}
+	if err = os.Rename(tmpFile, path); err != nil {
+		_ = os.Remove(tmpFile)
+		err = errors.Wrap(err, "rename revoked nodes file failed")
+	}
+	return
+}
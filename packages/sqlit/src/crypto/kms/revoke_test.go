@@ -0,0 +1,150 @@
+
+package kms
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	. "github.com/smartystreets/goconvey/convey"
+
+	"sqlit/src/crypto/asymmetric"
+	"sqlit/src/proto"
+	"sqlit/src/utils"
+)
+
+func TestRevokeNode(t *testing.T) {
+	Convey("revoked nodes are not trusted", t, func() {
+		ClosePublicKeyStore()
+		utils.RemoveAll(dbFile + "*")
+		defer utils.RemoveAll(dbFile + "*")
+		defer ClosePublicKeyStore()
+		So(InitPublicKeyStore(dbFile, nil), ShouldBeNil)
+		ResetLocalKeyStore()
+		defer ResetLocalKeyStore()
+		revokedFile := filepath.Join(t.TempDir(), "public.keystore.revoked")
+		So(LoadRevokedNodes(revokedFile), ShouldBeNil)
+		defer func() { So(LoadRevokedNodes(""), ShouldBeNil) }()
+
+		private, public, _ := asymmetric.GenSecp256k1KeyPair()
+		node := &proto.Node{PublicKey: public}
+		nonce := asymmetric.GetTypedPubKeyNonce(public, 1, 50*time.Millisecond, nil)
+		node.ID = proto.NodeID(nonce.Hash.String())
+		node.Nonce = nonce.Nonce
+		So(SetNode(node), ShouldBeNil)
+
+		SetLocalKeyPair(private, public)
+		data := []byte("payload")
+		sig, err := SignNodeData(data)
+		So(err, ShouldBeNil)
+		ResetLocalKeyStore()
+
+		So(RevokeNode(node.ID), ShouldBeNil)
+		So(RevokeNode(node.ID), ShouldBeNil)
+		So(IsRevoked(node.ID), ShouldBeTrue)
+		So(RevokedNodes(), ShouldResemble, []proto.NodeID{node.ID})
+
+		_, err = VerifyNodeSignature(node.ID, data, sig)
+		So(errors.Cause(err), ShouldEqual, ErrNodeRevoked)
+		_, err = GetPublicKey(node.ID)
+		So(errors.Cause(err), ShouldEqual, ErrNodeRevoked)
+		err = SetNode(node)
+		So(err, ShouldHaveSameTypeAs, &InvalidNodeError{})
+		So(errors.Cause(err), ShouldEqual, ErrNodeRevoked)
+		err = SetNodes([]*proto.Node{node})
+		So(err, ShouldHaveSameTypeAs, NodesError{})
+		So(errors.Cause(err.(NodesError)[0]), ShouldEqual, ErrNodeRevoked)
+
+		// the revocation list survives a reload
+		So(LoadRevokedNodes(""), ShouldBeNil)
+		So(IsRevoked(node.ID), ShouldBeFalse)
+		So(LoadRevokedNodes(revokedFile), ShouldBeNil)
+		So(IsRevoked(node.ID), ShouldBeTrue)
+
+		So(UnrevokeNode(node.ID), ShouldBeNil)
+		So(UnrevokeNode(node.ID), ShouldBeNil)
+		valid, err := VerifyNodeSignature(node.ID, data, sig)
+		So(err, ShouldBeNil)
+		So(valid, ShouldBeTrue)
+		So(LoadRevokedNodes(revokedFile), ShouldBeNil)
+		So(RevokedNodes(), ShouldBeEmpty)
+
+		// the local node is refused
+		SetLocalKeyPair(private, public)
+		SetLocalNodeIDNonce(node.ID.ToRawNodeID().CloneBytes(), &node.Nonce)
+		So(errors.Cause(RevokeNode(node.ID)), ShouldEqual, ErrRevokeLocalNode)
+		So(IsRevoked(node.ID), ShouldBeFalse)
+
+		// an unreadable list fails to load and keeps the current one
+		So(os.WriteFile(revokedFile, []byte("garbage"), 0600), ShouldBeNil)
+		So(LoadRevokedNodes(revokedFile), ShouldNotBeNil)
+	})
+}
+
+func TestExportImportRevokedNodes(t *testing.T) {
+	Convey("export and import the revocation list", t, func() {
+		ClosePublicKeyStore()
+		utils.RemoveAll(dbFile + "*")
+		defer utils.RemoveAll(dbFile + "*")
+		defer ClosePublicKeyStore()
+		So(InitPublicKeyStore(dbFile, nil), ShouldBeNil)
+		ResetLocalKeyStore()
+		defer ResetLocalKeyStore()
+		So(LoadRevokedNodes(""), ShouldBeNil)
+		defer func() { So(LoadRevokedNodes(""), ShouldBeNil) }()
+
+		newNode := func() *proto.Node {
+			_, public, _ := asymmetric.GenSecp256k1KeyPair()
+			nonce := asymmetric.GetTypedPubKeyNonce(public, 1, 50*time.Millisecond, nil)
+			return &proto.Node{
+				ID:        proto.NodeID(nonce.Hash.String()),
+				PublicKey: public,
+				Nonce:     nonce.Nonce,
+			}
+		}
+		kept, revoked := newNode(), newNode()
+		So(SetNodes([]*proto.Node{kept, revoked}), ShouldBeNil)
+		So(RevokeNode(revoked.ID), ShouldBeNil)
+
+		// the revoked node is left out of the export
+		var buf bytes.Buffer
+		So(ExportPublicKeyStore(&buf), ShouldBeNil)
+		var nodes []*exportedNode
+		So(json.Unmarshal(buf.Bytes(), &nodes), ShouldBeNil)
+		So(nodes, ShouldHaveLength, 1)
+		So(nodes[0].ID, ShouldEqual, kept.ID)
+
+		buf.Reset()
+		So(ExportPublicKeyStore(&buf, WithRevoked()), ShouldBeNil)
+		exported := buf.String()
+		var store exportedStore
+		So(json.Unmarshal(buf.Bytes(), &store), ShouldBeNil)
+		So(store.Nodes, ShouldHaveLength, 1)
+		So(store.Revoked, ShouldResemble, []proto.NodeID{revoked.ID})
+
+		// a fresh node imports the revocation with the nodes
+		So(LoadRevokedNodes(""), ShouldBeNil)
+		So(ImportPublicKeyStore(strings.NewReader(exported), false), ShouldBeNil)
+		So(IsRevoked(revoked.ID), ShouldBeTrue)
+		ids, err := GetAllNodeID()
+		So(err, ShouldBeNil)
+		So(ids, ShouldResemble, []proto.NodeID{kept.ID})
+
+		// a node both exported and revoked is rejected
+		en, err := exportNode(revoked)
+		So(err, ShouldBeNil)
+		store.Nodes = append(store.Nodes, en)
+		conflicting, err := json.Marshal(&store)
+		So(err, ShouldBeNil)
+		So(LoadRevokedNodes(""), ShouldBeNil)
+		err = ImportPublicKeyStore(bytes.NewReader(conflicting), true)
+		So(err, ShouldHaveSameTypeAs, NodesError{})
+		So(errors.Cause(err.(NodesError)[0]), ShouldEqual, ErrNodeRevoked)
+		So(IsRevoked(revoked.ID), ShouldBeFalse)
+	})
+}
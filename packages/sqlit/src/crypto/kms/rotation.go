@@ -0,0 +1,261 @@
+
+package kms
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"sqlit/src/conf"
+	"sqlit/src/crypto/asymmetric"
+	"sqlit/src/crypto/hash"
+	mine "sqlit/src/pow/cpuminer"
+	"sqlit/src/proto"
+	"sqlit/src/utils/log"
+)
+
+// DefaultKeyRotationGracePeriod is used when conf.GConf.KeyRotationGracePeriod is not set.
+const DefaultKeyRotationGracePeriod = 10 * time.Minute
+
+var (
+	// ErrNilPrivateKey indicates the private key to rotate to is nil
+	ErrNilPrivateKey = errors.New("nil private key")
+	// ErrRotateTypedKey indicates the local private key to rotate is not a secp256k1 one
+	ErrRotateTypedKey = errors.New("only a secp256k1 local private key is rotated")
+	// ErrRotateProviderKey indicates the local private key is held by a key provider
+	// other than FileKeyProvider, which is not rotated
+	ErrRotateProviderKey = errors.New("local private key of the key provider is not rotated")
+)
+
+// LocalPeersHook is called by SetLocalPeers with the replaced and the new local peers
+// list, old is nil for the first peers list. It must not block.
+type LocalPeersHook func(old, peers *proto.Peers)
+
+var (
+	localPeersHook     LocalPeersHook
+	localPeersHookLock sync.RWMutex
+)
+
+// SetLocalPeersHook sets the hook called on every SetLocalPeers, e.g. to notify the
+// term changes, a nil hook removes it.
+func SetLocalPeersHook(hook LocalPeersHook) {
+	localPeersHookLock.Lock()
+	defer localPeersHookLock.Unlock()
+	localPeersHook = hook
+}
+
+// SetLocalPeers sets the peers list signed by local private key, the peers
+// will be re-signed on local private key rotation.
+func SetLocalPeers(peers *proto.Peers) {
+	localKey.Lock()
+	old := localKey.peers
+	localKey.peers = peers
+	localKey.Unlock()
+	callLocalPeersHook(old, peers)
+}
+
+// AdoptLocalPeers replaces the local peers by peers received from another node if
+// peers.AcceptInto accepts them over the local peers. The peers of a term not newer
+// are rejected by a *proto.StaleTermError and the local peers are kept, so the caller
+// can log and ignore it. The signature of peers is verified by the caller.
+func AdoptLocalPeers(peers *proto.Peers) (err error) {
+	for {
+		localKey.RLock()
+		old := localKey.peers
+		localKey.RUnlock()
+		// the signatures are checked without lock, the key lookups may be slow
+		if err = peers.AcceptInto(old); err != nil {
+			return
+		}
+		localKey.Lock()
+		if localKey.peers != old {
+			// replaced meanwhile, check again over the new local peers
+			localKey.Unlock()
+			continue
+		}
+		localKey.peers = peers
+		localKey.Unlock()
+		callLocalPeersHook(old, peers)
+		return
+	}
+}
+
+func callLocalPeersHook(old, peers *proto.Peers) {
+	localPeersHookLock.RLock()
+	hook := localPeersHook
+	localPeersHookLock.RUnlock()
+	if hook != nil {
+		hook(old, peers)
+	}
+}
+
+// GetLocalPeers gets the peers list set by SetLocalPeers.
+func GetLocalPeers() (peers *proto.Peers, err error) {
+	localKey.RLock()
+	peers = localKey.peers
+	if peers == nil {
+		err = ErrNilField
+	}
+	localKey.RUnlock()
+	return
+}
+
+// RotateLocalPrivateKey replaces the local private key with newKey without restart.
+// A new nonce is mined for the new public key and the local node id/nonce is
+// re-derived, the local peers list set by SetLocalPeers is re-signed.
+//
+// The previous node entry is kept in the public keystore for a grace period,
+// so that peers can still verify in-flight messages signed by the previous key.
+// Callers should persist newKey with SavePrivateKey to survive restarts.
+// Only secp256k1 keys of FileKeyProvider are rotated, it is ErrRotateTypedKey for
+// another local key type and ErrRotateProviderKey for another key provider.
+func RotateLocalPrivateKey(newKey *asymmetric.PrivateKey) (err error) {
+	if newKey == nil {
+		return ErrNilPrivateKey
+	}
+	if _, ok := GetLocalKeyProvider().(*FileKeyProvider); !ok {
+		return ErrRotateProviderKey
+	}
+	// checked before mining and again on the swap
+	localKey.RLock()
+	typed := localKey.typedPrivate != nil
+	localKey.RUnlock()
+	if typed {
+		return ErrRotateTypedKey
+	}
+
+	var difficulty int
+	if conf.GConf != nil {
+		difficulty = conf.GConf.MinNodeIDDifficulty
+	}
+	newPublic := newKey.PubKey()
+	nonce := MineNodeNonce(newPublic, difficulty)
+	derived, err := proto.DeriveNodeID(newPublic, nonce.Nonce)
+	if err != nil {
+		return
+	}
+	newNodeID := derived.ToRawNodeID()
+
+	// swap key pair and node id/nonce at once, so readers never see a mixed identity
+	localKey.Lock()
+	if localKey.typedPrivate != nil {
+		localKey.Unlock()
+		return ErrRotateTypedKey
+	}
+	oldPublic := localKey.public
+	oldNodeID := proto.NodeID("")
+	if localKey.nodeID != nil {
+		if h, hErr := hash.NewHash(localKey.nodeID); hErr == nil {
+			oldNodeID = proto.NodeID(h.String())
+		}
+	}
+	localKey.isSet = true
+	localKey.private = newKey
+	localKey.public = newPublic
+	invalidateLocalKeyCache()
+	setLocalNodeIDNonce(newNodeID.CloneBytes(), &nonce.Nonce)
+	localKey.Unlock()
+
+	log.WithFields(log.Fields{
+		"oldKey":    KeyFingerprint(oldPublic),
+		"newKey":    KeyFingerprint(newPublic),
+		"oldNodeID": oldNodeID,
+		"newNodeID": newNodeID.ToNodeID(),
+	}).Info("local private key rotated")
+
+	if err = registerRotatedNode(oldNodeID, newNodeID.ToNodeID(), newPublic, nonce.Nonce); err != nil {
+		log.WithError(err).Error("register rotated node in public keystore failed")
+		return
+	}
+
+	if err = resignLocalPeers(newKey); err != nil {
+		log.WithError(err).Error("re-sign local peers failed")
+	}
+	return
+}
+
+// resignLocalPeers replaces the local peers by a copy signed with key, the peers in
+// use are never mutated as the readers of GetLocalPeers share them.
+func resignLocalPeers(key *asymmetric.PrivateKey) (err error) {
+	for {
+		localKey.RLock()
+		old := localKey.peers
+		localKey.RUnlock()
+		if old == nil {
+			return
+		}
+		signed := old.Clone()
+		if err = signed.Sign(key); err != nil {
+			return
+		}
+		localKey.Lock()
+		if localKey.peers != old {
+			// replaced meanwhile, sign the new local peers instead
+			localKey.Unlock()
+			continue
+		}
+		localKey.peers = signed
+		localKey.Unlock()
+		callLocalPeersHook(old, signed)
+		return
+	}
+}
+
+// registerRotatedNode sets the new local node, a copy of the old one with the new
+// identity, into the public keystore and schedules the removal of the old one after
+// the grace period.
+func registerRotatedNode(oldID, newID proto.NodeID, public *asymmetric.PublicKey, nonce mine.Uint256) (err error) {
+	newNode := &proto.Node{}
+	if oldID != "" {
+		if oldNode, getErr := GetNodeInfo(oldID); getErr == nil && oldNode != nil {
+			// the new node inherits all the old node info but its identity
+			*newNode = *oldNode
+		}
+	}
+	newNode.ID = newID
+	newNode.PublicKey = public
+	newNode.Nonce = nonce
+
+	if err = setNode(newNode); err != nil {
+		if err == ErrPKSNotInitialized {
+			// nothing to keep consistent
+			err = nil
+		}
+		return
+	}
+
+	if oldID != "" && oldID != newID {
+		gracePeriod := DefaultKeyRotationGracePeriod
+		if conf.GConf != nil && conf.GConf.KeyRotationGracePeriod > 0 {
+			gracePeriod = conf.GConf.KeyRotationGracePeriod
+		}
+		time.AfterFunc(gracePeriod, func() {
+			if delErr := DelNode(oldID); delErr != nil {
+				log.WithField("node", oldID).WithError(delErr).Warning("remove rotated node failed")
+			}
+		})
+	}
+
+	return
+}
+
+// MineNodeNonce finds the first nonce for public key which satisfies difficulty, the
+// node ID of the key is the hash of the nonce.
+func MineNodeNonce(public asymmetric.TypedPublicKey, difficulty int) (nonce mine.NonceInfo) {
+	miner := mine.NewCPUMiner(nil)
+	block := mine.MiningBlock{
+		Data:      public.Serialize(),
+		NonceChan: make(chan mine.NonceInfo, 1),
+		Stop:      make(chan struct{}),
+	}
+	_ = miner.ComputeBlockNonce(block, mine.Uint256{}, difficulty)
+	return <-block.NonceChan
+}
+
+// KeyFingerprint returns a short fingerprint of public key for logging.
+func KeyFingerprint(public asymmetric.TypedPublicKey) string {
+	if asymmetric.IsNilTypedPublicKey(public) {
+		return ""
+	}
+	return hash.THashH(public.Serialize()).Short(8)
+}
@@ -0,0 +1,296 @@
+
+package kms
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	. "github.com/smartystreets/goconvey/convey"
+
+	"sqlit/src/conf"
+	"sqlit/src/crypto/asymmetric"
+	"sqlit/src/proto"
+	"sqlit/src/utils"
+)
+
+func TestRotateLocalPrivateKey(t *testing.T) {
+	Convey("rotate local private key", t, func() {
+		ResetLocalKeyStore()
+		defer ResetLocalKeyStore()
+		ClosePublicKeyStore()
+		utils.RemoveAll(dbFile + "*")
+		defer utils.RemoveAll(dbFile + "*")
+		So(InitPublicKeyStore(dbFile, nil), ShouldBeNil)
+
+		oldGrace := conf.GConf.KeyRotationGracePeriod
+		conf.GConf.KeyRotationGracePeriod = 100 * time.Millisecond
+		defer func() { conf.GConf.KeyRotationGracePeriod = oldGrace }()
+
+		privKey1, pubKey1, _ := asymmetric.GenSecp256k1KeyPair()
+		privKey2, pubKey2, _ := asymmetric.GenSecp256k1KeyPair()
+		SetLocalKeyPair(privKey1, pubKey1)
+		nonce1 := MineNodeNonce(pubKey1, 0)
+		oldNodeID := proto.RawNodeID{Hash: nonce1.Hash}
+		SetLocalNodeIDNonce(oldNodeID.CloneBytes(), &nonce1.Nonce)
+		So(setNode(&proto.Node{
+			ID:        oldNodeID.ToNodeID(),
+			Addr:      "127.0.0.1:1234",
+			Addrs:     []string{"10.0.0.1:1234"},
+			Weight:    3,
+			Role:      proto.Miner,
+			PublicKey: pubKey1,
+			Nonce:     nonce1.Nonce,
+		}), ShouldBeNil)
+
+		peers := &proto.Peers{
+			PeersHeader: proto.PeersHeader{
+				Term:    1,
+				Leader:  oldNodeID.ToNodeID(),
+				Servers: []proto.NodeID{oldNodeID.ToNodeID()},
+			},
+		}
+		So(peers.Sign(privKey1), ShouldBeNil)
+		SetLocalPeers(peers)
+
+		So(RotateLocalPrivateKey(nil), ShouldEqual, ErrNilPrivateKey)
+		So(RotateLocalPrivateKey(privKey2), ShouldBeNil)
+
+		gotPrivate, err := GetLocalPrivateKey()
+		So(err, ShouldBeNil)
+		So(gotPrivate.Serialize(), ShouldResemble, privKey2.Serialize())
+		gotPublic, err := GetLocalPublicKey()
+		So(err, ShouldBeNil)
+		So(gotPublic.IsEqual(pubKey2), ShouldBeTrue)
+
+		newNodeID, err := GetLocalNodeID()
+		So(err, ShouldBeNil)
+		So(newNodeID, ShouldNotEqual, oldNodeID.ToNodeID())
+		nonce, err := GetLocalNonce()
+		So(err, ShouldBeNil)
+		So(IsIDPubNonceValid(newNodeID.ToRawNodeID(), nonce, pubKey2), ShouldBeTrue)
+
+		// peers re-signed with the new key in a copy, the replaced ones are untouched
+		resigned, err := GetLocalPeers()
+		So(err, ShouldBeNil)
+		So(resigned, ShouldNotEqual, peers)
+		So(resigned.Verify(), ShouldBeNil)
+		So(resigned.Signee.IsEqual(pubKey2), ShouldBeTrue)
+		So(peers.Signee.IsEqual(pubKey1), ShouldBeTrue)
+
+		// new node inherits the old node info
+		newNode, err := GetNodeInfo(newNodeID)
+		So(err, ShouldBeNil)
+		So(newNode.Addr, ShouldEqual, "127.0.0.1:1234")
+		So(newNode.Role, ShouldEqual, proto.Miner)
+		So(newNode.Addrs, ShouldResemble, []string{"10.0.0.1:1234"})
+		So(newNode.Weight, ShouldEqual, 3)
+		So(newNode.PublicKey.IsEqual(pubKey2), ShouldBeTrue)
+		So(newNode.Nonce, ShouldResemble, *nonce)
+
+		// old key is kept during grace period
+		oldPublic, err := GetPublicKey(oldNodeID.ToNodeID())
+		So(err, ShouldBeNil)
+		So(oldPublic.IsEqual(pubKey1), ShouldBeTrue)
+
+		time.Sleep(500 * time.Millisecond)
+		_, err = GetPublicKey(oldNodeID.ToNodeID())
+		So(errors.Cause(err), ShouldEqual, ErrKeyNotFound)
+	})
+	Convey("rotate with concurrent readers", t, func() {
+		ResetLocalKeyStore()
+		defer ResetLocalKeyStore()
+		ClosePublicKeyStore()
+
+		privKey1, pubKey1, _ := asymmetric.GenSecp256k1KeyPair()
+		SetLocalKeyPair(privKey1, pubKey1)
+
+		var (
+			wg   sync.WaitGroup
+			stop = make(chan struct{})
+		)
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					select {
+					case <-stop:
+						return
+					default:
+					}
+					private, err := GetLocalPrivateKey()
+					if err != nil || private == nil {
+						t.Error("unexpected nil local private key")
+						return
+					}
+				}
+			}()
+		}
+		for i := 0; i < 5; i++ {
+			privKey, _, _ := asymmetric.GenSecp256k1KeyPair()
+			So(RotateLocalPrivateKey(privKey), ShouldBeNil)
+		}
+		close(stop)
+		wg.Wait()
+	})
+	Convey("only the secp256k1 key of the file key provider is rotated", t, func() {
+		ResetLocalKeyStore()
+		defer ResetLocalKeyStore()
+		newKey, _, _ := asymmetric.GenSecp256k1KeyPair()
+
+		edPrivate, _, err := asymmetric.GenEd25519KeyPair()
+		So(err, ShouldBeNil)
+		edNode := newTypedTestNode(edPrivate)
+		useLocalTestNode(edPrivate, edNode)
+		So(RotateLocalPrivateKey(newKey), ShouldEqual, ErrRotateTypedKey)
+		nodeID, err := GetLocalNodeID()
+		So(err, ShouldBeNil)
+		So(nodeID, ShouldEqual, edNode.ID)
+		public, err := (&FileKeyProvider{}).TypedPublicKey()
+		So(err, ShouldBeNil)
+		So(asymmetric.TypedPublicKeyEqual(public, edPrivate.TypedPubKey()), ShouldBeTrue)
+
+		ResetLocalKeyStore()
+		privKey, pubKey, _ := asymmetric.GenSecp256k1KeyPair()
+		SetLocalKeyPair(privKey, pubKey)
+		SetLocalKeyProvider(&PKCS11KeyProvider{})
+		defer SetLocalKeyProvider(&FileKeyProvider{})
+		So(RotateLocalPrivateKey(newKey), ShouldEqual, ErrRotateProviderKey)
+		gotPublic, err := GetLocalPublicKey()
+		So(err, ShouldBeNil)
+		So(gotPublic.IsEqual(pubKey), ShouldBeTrue)
+	})
+}
+
+func TestSaveLocalPeers(t *testing.T) {
+	Convey("save and load the local peers", t, func() {
+		ResetLocalKeyStore()
+		defer ResetLocalKeyStore()
+		defer SetLocalPeers(nil)
+		peersFile := filepath.Join(t.TempDir(), "dht.db.peers")
+
+		SetLocalPeers(nil)
+		So(SaveLocalPeers(peersFile), ShouldEqual, ErrNilField)
+
+		privKey, pubKey, _ := asymmetric.GenSecp256k1KeyPair()
+		SetLocalKeyPair(privKey, pubKey)
+		nonce := MineNodeNonce(pubKey, 0)
+		nodeID := proto.RawNodeID{Hash: nonce.Hash}
+		SetLocalPeers(&proto.Peers{
+			PeersHeader: proto.PeersHeader{
+				Term:    7,
+				Leader:  nodeID.ToNodeID(),
+				Servers: []proto.NodeID{nodeID.ToNodeID()},
+			},
+		})
+		So(SaveLocalPeers(peersFile), ShouldBeNil)
+
+		loaded, err := LoadPeersFile(peersFile)
+		So(err, ShouldBeNil)
+		So(loaded.Term, ShouldEqual, 7)
+		So(loaded.Leader, ShouldEqual, nodeID.ToNodeID())
+		So(loaded.Servers, ShouldResemble, []proto.NodeID{nodeID.ToNodeID()})
+		So(loaded.Signee.IsEqual(pubKey), ShouldBeTrue)
+
+		// the peers signed by another node are saved with their signature
+		otherKey, otherPublic, _ := asymmetric.GenSecp256k1KeyPair()
+		signed := loaded.Clone()
+		So(signed.Sign(otherKey), ShouldBeNil)
+		SetLocalPeers(signed)
+		So(SaveLocalPeers(peersFile), ShouldBeNil)
+		loaded, err = LoadPeersFile(peersFile)
+		So(err, ShouldBeNil)
+		So(loaded.Signee.IsEqual(otherPublic), ShouldBeTrue)
+
+		_, err = LoadPeersFile(peersFile + ".missing")
+		So(err, ShouldNotBeNil)
+
+		// a tampered peers list is read as it is but fails to load
+		loaded.Term++
+		So(WritePeersFile(peersFile, loaded), ShouldBeNil)
+		read, err := ReadPeersFile(peersFile)
+		So(err, ShouldBeNil)
+		So(read.Term, ShouldEqual, 8)
+		_, err = LoadPeersFile(peersFile)
+		So(err, ShouldNotBeNil)
+	})
+}
+
+func TestAdoptLocalPeers(t *testing.T) {
+	Convey("the local peers are only replaced by a newer term", t, func() {
+		ResetLocalKeyStore()
+		defer ResetLocalKeyStore()
+		defer SetLocalPeers(nil)
+		privKey, pubKey, err := asymmetric.GenSecp256k1KeyPair()
+		So(err, ShouldBeNil)
+		SetLocalKeyPair(privKey, pubKey)
+		nodeID := proto.RawNodeID{Hash: MineNodeNonce(pubKey, 0).Hash}
+		newPeers := func(term uint64) *proto.Peers {
+			p := &proto.Peers{PeersHeader: proto.PeersHeader{
+				Term:    term,
+				Leader:  nodeID.ToNodeID(),
+				Servers: []proto.NodeID{nodeID.ToNodeID()},
+			}}
+			So(p.Sign(privKey), ShouldBeNil)
+			return p
+		}
+		SetLocalPeers(nil)
+		first := newPeers(3)
+		So(AdoptLocalPeers(first), ShouldBeNil)
+
+		for _, term := range []uint64{2, 3} {
+			err = AdoptLocalPeers(newPeers(term))
+			So(errors.Cause(err), ShouldEqual, proto.ErrStaleTerm)
+			local, err := GetLocalPeers()
+			So(err, ShouldBeNil)
+			So(local, ShouldEqual, first)
+		}
+
+		// a lower term list signed by the quorum is only adopted as an override of first
+		ClosePublicKeyStore()
+		utils.RemoveAll(dbFile + "*")
+		defer utils.RemoveAll(dbFile + "*")
+		defer ClosePublicKeyStore()
+		So(InitPublicKeyStore(dbFile, nil), ShouldBeNil)
+		nonce := MineNodeNonce(pubKey, 0)
+		So(setNode(&proto.Node{ID: nodeID.ToNodeID(), PublicKey: pubKey, Nonce: nonce.Nonce}), ShouldBeNil)
+		override := newPeers(2)
+		h, err := override.SigningHash()
+		So(err, ShouldBeNil)
+		sig, err := privKey.SignBytes(h[:])
+		So(err, ShouldBeNil)
+		So(override.AddSignature(nodeID.ToNodeID(), asymmetric.Secp256k1, sig), ShouldBeNil)
+		So(errors.Cause(AdoptLocalPeers(override)), ShouldEqual, proto.ErrStaleTerm)
+		if h, err = override.OverrideHash(first); err == nil {
+			sig, err = privKey.SignBytes(h[:])
+		}
+		So(err, ShouldBeNil)
+		So(override.AddApproval(nodeID.ToNodeID(), asymmetric.Secp256k1, sig), ShouldBeNil)
+		So(AdoptLocalPeers(override), ShouldBeNil)
+		// replayed over the overridden peers it is stale
+		So(errors.Cause(AdoptLocalPeers(override)), ShouldEqual, proto.ErrStaleTerm)
+
+		newer := newPeers(4)
+		So(AdoptLocalPeers(newer), ShouldBeNil)
+		local, err := GetLocalPeers()
+		So(err, ShouldBeNil)
+		So(local, ShouldEqual, newer)
+
+		// the concurrent adoptions end at the greatest term
+		var wg sync.WaitGroup
+		for term := uint64(5); term < 15; term++ {
+			wg.Add(1)
+			go func(p *proto.Peers) {
+				defer wg.Done()
+				_ = AdoptLocalPeers(p)
+			}(newPeers(term))
+		}
+		wg.Wait()
+		local, err = GetLocalPeers()
+		So(err, ShouldBeNil)
+		So(local.Term, ShouldEqual, 14)
+	})
+}
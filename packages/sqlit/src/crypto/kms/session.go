@@ -0,0 +1,107 @@
+
+package kms
+
+import (
+	"crypto/sha256"
+	"io"
+	"sync"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/hkdf"
+
+	"sqlit/src/crypto/asymmetric"
+	"sqlit/src/proto"
+)
+
+// SessionKeySize is the size of the keys derived by DeriveSessionKey, e.g. for
+// AES-256-GCM or ChaCha20-Poly1305.
+const SessionKeySize = 32
+
+// ErrSessionKeyType indicates a key of the local node or the peer is not secp256k1,
+// the key type without ECDH can not derive session keys.
+var ErrSessionKeyType = errors.New("session key needs secp256k1 keys")
+
+var (
+	// sessionKeys holds the live keys of DeriveSessionKey for Shutdown to scrub
+	sessionKeys     = make(map[*byte][]byte)
+	sessionKeysLock sync.Mutex
+)
+
+// DeriveSessionKey derives a symmetric key of SessionKeySize bytes shared with peer
+// by HKDF-SHA256 of the ECDH secret of the local private key and the peer public key
+// in the public keystore. The peer derives the same key with the same salt, a fresh
+// salt of each session gives a fresh key. The error is caused by ErrKeyNotFound if
+// the peer is unknown. The key is zeroed by Shutdown, or by ReleaseSessionKey once
+// the session ends.
+func DeriveSessionKey(peer proto.NodeID, salt []byte) (key []byte, err error) {
+	var (
+		localID proto.NodeID
+		private *asymmetric.PrivateKey
+		public  asymmetric.TypedPublicKey
+	)
+	if localID, err = GetLocalNodeID(); err != nil {
+		return
+	}
+	if public, err = GetTypedPublicKey(peer); err != nil {
+		return
+	}
+	peerKey, ok := public.(*asymmetric.PublicKey)
+	if !ok {
+		return nil, errors.Wrapf(ErrSessionKeyType, "peer %s key is %s", peer, public.KeyType())
+	}
+	if private, err = GetLocalPrivateKey(); err != nil {
+		if typed, typedErr := GetLocalTypedPrivateKey(); typedErr == nil && typed.KeyType() != asymmetric.Secp256k1 {
+			err = errors.Wrapf(ErrSessionKeyType, "local key is %s", typed.KeyType())
+		}
+		return
+	}
+	if key, err = deriveSessionKey(private, peerKey, localID, peer, salt); err != nil {
+		return
+	}
+	sessionKeysLock.Lock()
+	defer sessionKeysLock.Unlock()
+	sessionKeys[&key[0]] = key
+	return
+}
+
+// ReleaseSessionKey zeroes key of DeriveSessionKey in place and stops tracking it.
+func ReleaseSessionKey(key []byte) {
+	zeroBytes(key)
+	if len(key) == 0 {
+		return
+	}
+	sessionKeysLock.Lock()
+	defer sessionKeysLock.Unlock()
+	delete(sessionKeys, &key[0])
+}
+
+// releaseSessionKeys zeroes all the session keys not released yet.
+func releaseSessionKeys() {
+	sessionKeysLock.Lock()
+	defer sessionKeysLock.Unlock()
+	for p, key := range sessionKeys {
+		zeroBytes(key)
+		delete(sessionKeys, p)
+	}
+}
+
+// deriveSessionKey derives the session key of the local node and peer, the info of
+// HKDF binds both node ids in an order independent of the side.
+func deriveSessionKey(
+	private *asymmetric.PrivateKey, peerKey *asymmetric.PublicKey,
+	local, peer proto.NodeID, salt []byte,
+) (key []byte, err error) {
+	secret := asymmetric.GenECDHSharedSecret(private, peerKey)
+	defer zeroBytes(secret)
+	first, second := local, peer
+	if second < first {
+		first, second = second, first
+	}
+	info := []byte("sqlit session key " + string(first) + " " + string(second))
+
+	key = make([]byte, SessionKeySize)
+	if _, err = io.ReadFull(hkdf.New(sha256.New, secret, salt, info), key); err != nil {
+		return nil, errors.Wrap(err, "derive session key failed")
+	}
+	return
+}
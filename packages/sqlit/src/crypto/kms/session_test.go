@@ -0,0 +1,59 @@
+
+package kms
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	. "github.com/smartystreets/goconvey/convey"
+
+	"sqlit/src/crypto/asymmetric"
+	"sqlit/src/proto"
+	"sqlit/src/utils"
+)
+
+func TestDeriveSessionKey(t *testing.T) {
+	Convey("two nodes derive the same session key", t, func() {
+		ClosePublicKeyStore()
+		utils.RemoveAll(dbFile + "*")
+		defer utils.RemoveAll(dbFile + "*")
+		defer ClosePublicKeyStore()
+		So(InitPublicKeyStore(dbFile, nil), ShouldBeNil)
+		ResetLocalKeyStore()
+		defer ResetLocalKeyStore()
+
+		var (
+			privateA, publicA, _ = asymmetric.GenSecp256k1KeyPair()
+			privateB, publicB, _ = asymmetric.GenSecp256k1KeyPair()
+			edPrivate, _, _      = asymmetric.GenEd25519KeyPair()
+			nodeA, nodeB, edNode = newTypedTestNode(privateA), newTypedTestNode(privateB), newTypedTestNode(edPrivate)
+			salt                 = []byte("session salt")
+		)
+		So(SetNode(nodeA), ShouldBeNil)
+		So(SetNode(nodeB), ShouldBeNil)
+		So(SetNode(edNode), ShouldBeNil)
+		So(nodeA.PublicKey, ShouldEqual, publicA)
+		So(nodeB.PublicKey, ShouldEqual, publicB)
+
+		useLocalTestNode(privateA, nodeA)
+		keyA, err := DeriveSessionKey(nodeB.ID, salt)
+		So(err, ShouldBeNil)
+		So(keyA, ShouldHaveLength, SessionKeySize)
+		otherSalt, err := DeriveSessionKey(nodeB.ID, []byte("other salt"))
+		So(err, ShouldBeNil)
+		So(otherSalt, ShouldNotResemble, keyA)
+
+		useLocalTestNode(privateB, nodeB)
+		keyB, err := DeriveSessionKey(nodeA.ID, salt)
+		So(err, ShouldBeNil)
+		So(keyB, ShouldResemble, keyA)
+
+		_, err = DeriveSessionKey(proto.NodeID("unknown"), salt)
+		So(errors.Cause(err), ShouldEqual, ErrKeyNotFound)
+		_, err = DeriveSessionKey(edNode.ID, salt)
+		So(errors.Cause(err), ShouldEqual, ErrSessionKeyType)
+		useLocalTestNode(edPrivate, edNode)
+		_, err = DeriveSessionKey(nodeA.ID, salt)
+		So(errors.Cause(err), ShouldEqual, ErrSessionKeyType)
+	})
+}
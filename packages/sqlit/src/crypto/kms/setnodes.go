@@ -0,0 +1,239 @@
+
+package kms
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"sqlit/src/proto"
+	"sqlit/src/utils"
+	"sqlit/src/utils/log"
+)
+
+var (
+	// ErrNilPublicKey indicates node public key is nil
+	ErrNilPublicKey = errors.New("nil node public key")
+	// ErrInvalidNodeID indicates node id is not a valid hash string
+	ErrInvalidNodeID = errors.New("invalid node id")
+)
+
+// NodeError describes a node failed in SetNodes.
+type NodeError struct {
+	Index int
+	ID    proto.NodeID
+	Err   error
+}
+
+// Error implements error.Error.
+func (e *NodeError) Error() string {
+	return fmt.Sprintf("node #%d %s: %v", e.Index, e.ID, e.Err)
+}
+
+// Cause returns the underlying error.
+func (e *NodeError) Cause() error {
+	return e.Err
+}
+
+// InvalidNodeError describes a node rejected by SetNode, e.g. its id is not the
+// hash derived from its public key and nonce. Other errors of SetNode come from
+// the public keystore.
+type InvalidNodeError struct {
+	ID  proto.NodeID
+	Err error
+}
+
+// Error implements error.Error.
+func (e *InvalidNodeError) Error() string {
+	return fmt.Sprintf("invalid node %s: %v", e.ID, e.Err)
+}
+
+// Cause returns the underlying error.
+func (e *InvalidNodeError) Cause() error {
+	return e.Err
+}
+
+// NodesError holds all the failed nodes of SetNodes.
+type NodesError []*NodeError
+
+// Error implements error.Error.
+func (e NodesError) Error() string {
+	msgs := make([]string, len(e))
+	for i, ne := range e {
+		msgs[i] = ne.Error()
+	}
+	return fmt.Sprintf("%d nodes failed: %s", len(e), strings.Join(msgs, "; "))
+}
+
+type setNodesOptions struct {
+	strict       bool
+	workers      int
+	skipVerifyID bool
+}
+
+func newSetNodesOptions(opts []SetNodesOpt) (o setNodesOptions) {
+	for _, opt := range opts {
+		if opt != nil {
+			opt(&o)
+		}
+	}
+	return
+}
+
+// SetNodesOpt represents extra options to apply in SetNodes and SetNode.
+type SetNodesOpt func(*setNodesOptions)
+
+// WithStrict makes SetNodes leave the keystore unchanged if any node is invalid.
+func WithStrict() SetNodesOpt {
+	return func(o *setNodesOptions) {
+		o.strict = true
+	}
+}
+
+// WithWorkers makes SetNodes verify the nodes with up to workers goroutines, the
+// nodes are still set in a single transaction.
+func WithWorkers(workers int) SetNodesOpt {
+	return func(o *setNodesOptions) {
+		o.workers = workers
+	}
+}
+
+// WithoutVerifyID makes SetNodes and SetNode skip checking the node id is the hash
+// of its public key and nonce, it is the escape hatch for legacy entries only.
+func WithoutVerifyID() SetNodesOpt {
+	return func(o *setNodesOptions) {
+		o.skipVerifyID = true
+	}
+}
+
+// SetNodes verifies all the nodes and sets them in a single transaction.
+// Invalid nodes are skipped and returned as NodesError, while in strict
+// mode none of the nodes is set if any one is invalid.
+func SetNodes(nodes []*proto.Node, opts ...SetNodesOpt) (err error) {
+	var (
+		o      = newSetNodesOptions(opts)
+		failed NodesError
+		valid  = make([]*proto.Node, 0, len(nodes))
+		errs   = validateNodes(nodes, o.workers, !o.skipVerifyID)
+	)
+	for i, n := range nodes {
+		if verr := errs[i]; verr != nil {
+			ne := &NodeError{Index: i, Err: verr}
+			if n != nil {
+				ne.ID = n.ID
+			}
+			failed = append(failed, ne)
+			continue
+		}
+		valid = append(valid, n)
+	}
+	if len(failed) > 0 && o.strict {
+		return failed
+	}
+
+	if err = setNodes(valid, false); err != nil {
+		return
+	}
+	if len(failed) > 0 {
+		err = failed
+	}
+	return
+}
+
+// validateNodes validates nodes with up to workers goroutines, the error of nodes[i]
+// is errs[i].
+func validateNodes(nodes []*proto.Node, workers int, verifyID bool) (errs []error) {
+	errs = make([]error, len(nodes))
+	if workers > len(nodes) {
+		workers = len(nodes)
+	}
+	if workers <= 1 {
+		for i, n := range nodes {
+			errs[i] = validateNode(n, verifyID)
+		}
+		return
+	}
+	var (
+		wg   sync.WaitGroup
+		next = make(chan int)
+	)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				errs[i] = validateNode(nodes[i], verifyID)
+			}
+		}()
+	}
+	for i := range nodes {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	return
+}
+
+// validateNode checks node is not revoked, its fields and id is
+// proto.DeriveNodeID(key, nonce) if verifyID.
+func validateNode(n *proto.Node, verifyID bool) error {
+	if n == nil {
+		return ErrNilNode
+	}
+	if IsRevoked(n.ID) {
+		return ErrNodeRevoked
+	}
+	key, err := n.TypedPublicKey()
+	if err == proto.ErrNilNodePublicKey {
+		return ErrNilPublicKey
+	} else if err != nil {
+		return err
+	}
+	if Unittest {
+		return nil
+	}
+	rawID := n.ID.ToRawNodeID()
+	if rawID == nil {
+		return ErrInvalidNodeID
+	}
+	if verifyID && !IsIDTypedPubNonceValid(rawID, &n.Nonce, key) {
+		return ErrNodeIDKeyNonceNotMatch
+	}
+	return nil
+}
+
+// setNodes sets nodes in a single transaction if the store is a BatchStore,
+// all the existing nodes are removed first if replace is true.
+func setNodes(nodes []*proto.Node, replace bool) (err error) {
+	pksLock.Lock()
+	defer pksLock.Unlock()
+	if pks == nil || pks.store == nil {
+		return ErrPKSNotInitialized
+	}
+
+	entries := make(map[proto.NodeID][]byte, len(nodes))
+	for _, n := range nodes {
+		nodeBuf, err := utils.EncodeMsgPack(n)
+		if err != nil {
+			return errors.Wrapf(err, "marshal node %s failed", n.ID)
+		}
+		entries[n.ID] = nodeBuf.Bytes()
+	}
+	if err = putAll(pks.store, entries, replace); err != nil {
+		err = errors.Wrap(err, "set nodes failed")
+		return
+	}
+
+	if replace {
+		pks.cache = make(map[proto.NodeID]*proto.Node, len(nodes))
+		pks.localNodes = make(map[proto.NodeID]*proto.Node, len(nodes))
+	}
+	for _, n := range nodes {
+		pks.localNodes[n.ID] = pks.cacheNode(n)
+		audit(AuditSetNode, n.ID)
+	}
+	log.WithField("count", len(nodes)).Debug("set nodes")
+	return
+}
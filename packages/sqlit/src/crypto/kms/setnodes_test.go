@@ -0,0 +1,116 @@
+
+package kms
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	. "github.com/smartystreets/goconvey/convey"
+
+	"sqlit/src/crypto/asymmetric"
+	"sqlit/src/proto"
+	"sqlit/src/utils"
+)
+
+func TestSetNodes(t *testing.T) {
+	newValidNode := func() *proto.Node {
+		_, pub, _ := asymmetric.GenSecp256k1KeyPair()
+		nonce := MineNodeNonce(pub, 1)
+		return &proto.Node{
+			ID:        proto.NodeID(nonce.Hash.String()),
+			PublicKey: pub,
+			Nonce:     nonce.Nonce,
+		}
+	}
+	countNodes := func() int {
+		ids, _ := GetAllNodeID()
+		return len(ids)
+	}
+
+	Convey("set nodes", t, func() {
+		ClosePublicKeyStore()
+		utils.RemoveAll(dbFile + "*")
+		defer utils.RemoveAll(dbFile + "*")
+		defer ClosePublicKeyStore()
+		So(SetNodes([]*proto.Node{newValidNode()}), ShouldEqual, ErrPKSNotInitialized)
+		So(InitPublicKeyStore(dbFile, nil), ShouldBeNil)
+
+		nodes := make([]*proto.Node, 0, 100)
+		for i := 0; i < 100; i++ {
+			nodes = append(nodes, newValidNode())
+		}
+		So(SetNodes(nodes), ShouldBeNil)
+		So(countNodes(), ShouldEqual, 100)
+		for _, n := range nodes {
+			info, err := GetNodeInfo(n.ID)
+			So(err, ShouldBeNil)
+			So(info.PublicKey.IsEqual(n.PublicKey), ShouldBeTrue)
+		}
+
+		noKey := newValidNode()
+		noKey.PublicKey = nil
+		badNonce := newValidNode()
+		badNonce.Nonce.A++
+		badID := newValidNode()
+		badID.ID = "not a hash"
+		batch := []*proto.Node{newValidNode(), nil, noKey, badNonce, badID, newValidNode()}
+
+		Convey("strict mode leaves store unchanged", func() {
+			err := SetNodes(batch, WithStrict())
+			So(err, ShouldNotBeNil)
+			So(countNodes(), ShouldEqual, 100)
+		})
+		Convey("invalid nodes are all reported", func() {
+			err := SetNodes(batch)
+			failed, ok := err.(NodesError)
+			So(ok, ShouldBeTrue)
+			So(failed, ShouldHaveLength, 4)
+			So(failed[0].Index, ShouldEqual, 1)
+			So(errors.Cause(failed[0]), ShouldEqual, ErrNilNode)
+			So(failed[1].ID, ShouldEqual, noKey.ID)
+			So(errors.Cause(failed[1]), ShouldEqual, ErrNilPublicKey)
+			So(errors.Cause(failed[2]), ShouldEqual, ErrNodeIDKeyNonceNotMatch)
+			So(errors.Cause(failed[3]), ShouldEqual, ErrInvalidNodeID)
+			So(err.Error(), ShouldContainSubstring, "4 nodes failed")
+			So(countNodes(), ShouldEqual, 102)
+		})
+		Convey("parallel verification reports in node order", func() {
+			err := SetNodes(batch, WithWorkers(4))
+			failed, ok := err.(NodesError)
+			So(ok, ShouldBeTrue)
+			So(failed, ShouldHaveLength, 4)
+			for i, index := range []int{1, 2, 3, 4} {
+				So(failed[i].Index, ShouldEqual, index)
+			}
+			So(errors.Cause(failed[2]), ShouldEqual, ErrNodeIDKeyNonceNotMatch)
+			So(countNodes(), ShouldEqual, 102)
+		})
+		Convey("legacy nodes are set without id verification", func() {
+			err := SetNodes(batch, WithoutVerifyID())
+			failed, ok := err.(NodesError)
+			So(ok, ShouldBeTrue)
+			So(failed, ShouldHaveLength, 3)
+			So(errors.Cause(failed[1]), ShouldEqual, ErrNilPublicKey)
+			So(failed[2].ID, ShouldEqual, badID.ID)
+			So(errors.Cause(failed[2]), ShouldEqual, ErrInvalidNodeID)
+			So(countNodes(), ShouldEqual, 103)
+		})
+		Convey("set node rejects a bad node by InvalidNodeError", func() {
+			err := SetNode(badNonce)
+			invalid, ok := err.(*InvalidNodeError)
+			So(ok, ShouldBeTrue)
+			So(invalid.ID, ShouldEqual, badNonce.ID)
+			So(errors.Cause(err), ShouldEqual, ErrNodeIDKeyNonceNotMatch)
+			So(countNodes(), ShouldEqual, 100)
+			So(SetNode(badNonce, WithoutVerifyID()), ShouldBeNil)
+			So(countNodes(), ShouldEqual, 101)
+
+			// an unavailable store is not a bad node
+			ClosePublicKeyStore()
+			err = SetNode(newValidNode())
+			_, ok = err.(*InvalidNodeError)
+			So(ok, ShouldBeFalse)
+			So(err, ShouldEqual, ErrPKSNotInitialized)
+		})
+	})
+}
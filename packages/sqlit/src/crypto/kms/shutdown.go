@@ -0,0 +1,50 @@
+
+package kms
+
+import (
+	"errors"
+
+	"sqlit/src/crypto/asymmetric"
+)
+
+// ErrKeyStoreShutdown indicates the local private key is scrubbed by Shutdown.
+var ErrKeyStoreShutdown = errors.New("local keystore is shut down")
+
+// Shutdown overwrites the local private key and the session keys of
+// DeriveSessionKey in place with zeros and drops them, GetLocalPrivateKey and
+// GetLocalTypedPrivateKey return ErrKeyStoreShutdown after it and the local key
+// can not be set again. The copies made by the go runtime, e.g. by a moving garbage
+// collection or a swapped page, are out of reach, so it only scrubs the obvious
+// copies held by kms and shared with the callers.
+func Shutdown() {
+	localKey.Lock()
+	defer localKey.Unlock()
+	if localKey.private != nil {
+		zeroPrivateKey(localKey.private)
+	}
+	if localKey.typedPrivate != nil {
+		zeroPrivateKey(localKey.typedPrivate)
+	}
+	localKey.private = nil
+	localKey.typedPrivate = nil
+	localKey.isSet = true
+	localKey.shutdown = true
+	invalidateLocalKeyCache()
+	releaseSessionKeys()
+}
+
+// zeroPrivateKey overwrites the secret of private in place, the key is unusable after.
+func zeroPrivateKey(private asymmetric.TypedPrivateKey) {
+	switch k := private.(type) {
+	case *asymmetric.PrivateKey:
+		if k.D != nil {
+			words := k.D.Bits()
+			for i := range words {
+				words[i] = 0
+			}
+			k.D.SetInt64(0)
+		}
+	case asymmetric.Ed25519PrivateKey:
+		zeroBytes(k)
+	}
+}
@@ -0,0 +1,81 @@
+
+package kms
+
+import (
+	"bytes"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"sqlit/src/crypto/asymmetric"
+	"sqlit/src/utils"
+)
+
+func TestShutdown(t *testing.T) {
+	Convey("shutdown scrubs the secp256k1 key and the session keys", t, func() {
+		ClosePublicKeyStore()
+		utils.RemoveAll(dbFile + "*")
+		defer utils.RemoveAll(dbFile + "*")
+		defer ClosePublicKeyStore()
+		So(InitPublicKeyStore(dbFile, nil), ShouldBeNil)
+		ResetLocalKeyStore()
+		defer ResetLocalKeyStore()
+
+		private, _, err := asymmetric.GenSecp256k1KeyPair()
+		So(err, ShouldBeNil)
+		peerPrivate, _, err := asymmetric.GenSecp256k1KeyPair()
+		So(err, ShouldBeNil)
+		node, peer := newTypedTestNode(private), newTypedTestNode(peerPrivate)
+		So(SetNode(node), ShouldBeNil)
+		So(SetNode(peer), ShouldBeNil)
+		useLocalTestNode(private, node)
+
+		// the buffers as held by kms and its callers
+		got, err := GetLocalPrivateKey()
+		So(err, ShouldBeNil)
+		words := got.D.Bits()
+		So(words, ShouldNotBeEmpty)
+		session, err := DeriveSessionKey(peer.ID, []byte("salt"))
+		So(err, ShouldBeNil)
+		released, err := DeriveSessionKey(peer.ID, []byte("other salt"))
+		So(err, ShouldBeNil)
+		ReleaseSessionKey(released)
+		So(released, ShouldResemble, make([]byte, SessionKeySize))
+
+		Shutdown()
+		for _, w := range words {
+			So(w, ShouldEqual, 0)
+		}
+		So(private.D.Sign(), ShouldEqual, 0)
+		So(session, ShouldResemble, make([]byte, SessionKeySize))
+		So(sessionKeys, ShouldBeEmpty)
+
+		_, err = GetLocalPrivateKey()
+		So(err, ShouldEqual, ErrKeyStoreShutdown)
+		_, err = GetLocalTypedPrivateKey()
+		So(err, ShouldEqual, ErrKeyStoreShutdown)
+		_, err = DeriveSessionKey(peer.ID, []byte("salt"))
+		So(err, ShouldEqual, ErrKeyStoreShutdown)
+		// no stale key can be set back
+		other, otherPublic, err := asymmetric.GenSecp256k1KeyPair()
+		So(err, ShouldBeNil)
+		SetLocalKeyPair(other, otherPublic)
+		_, err = GetLocalPrivateKey()
+		So(err, ShouldEqual, ErrKeyStoreShutdown)
+	})
+	Convey("shutdown scrubs the ed25519 key", t, func() {
+		ResetLocalKeyStore()
+		defer ResetLocalKeyStore()
+		private, _, err := asymmetric.GenEd25519KeyPair()
+		So(err, ShouldBeNil)
+		SetLocalTypedKeyPair(private)
+		got, err := GetLocalTypedPrivateKey()
+		So(err, ShouldBeNil)
+		So(bytes.Equal(got.(asymmetric.Ed25519PrivateKey), private), ShouldBeTrue)
+
+		Shutdown()
+		So([]byte(private), ShouldResemble, make([]byte, len(private)))
+		_, err = GetLocalTypedPrivateKey()
+		So(err, ShouldEqual, ErrKeyStoreShutdown)
+	})
+}
@@ -0,0 +1,91 @@
+
+package kms
+
+import (
+	"github.com/pkg/errors"
+
+	"sqlit/src/crypto/asymmetric"
+	"sqlit/src/crypto/hash"
+	"sqlit/src/proto"
+)
+
+var (
+	// ErrNilSignature indicates the signature to verify is nil
+	ErrNilSignature = errors.New("nil signature")
+)
+
+func init() {
+	// let proto.Peers.VerifyLeader look up the public keystore
+	proto.SetNodeKeyResolver(GetTypedPublicKey)
+}
+
+// Signature is a node signature of any key type, Bytes is in the key type format,
+// i.e. DER for secp256k1 and raw 64 bytes for ed25519.
+type Signature struct {
+	KeyType asymmetric.KeyType
+	Bytes   []byte
+}
+
+// NewSecp256k1Signature wraps a secp256k1 signature.
+func NewSecp256k1Signature(sig *asymmetric.Signature) *Signature {
+	if sig == nil {
+		return nil
+	}
+	return &Signature{
+		KeyType: asymmetric.Secp256k1,
+		Bytes:   sig.Serialize(),
+	}
+}
+
+// SignNodeData signs the THashH of data with local private key.
+func SignNodeData(data []byte) (sig *Signature, err error) {
+	var private asymmetric.TypedPrivateKey
+	if private, err = GetLocalTypedPrivateKey(); err != nil {
+		return
+	}
+	var sigBytes []byte
+	if sigBytes, err = private.SignBytes(hash.THashB(data)); err != nil {
+		return
+	}
+	sig = &Signature{
+		KeyType: private.KeyType(),
+		Bytes:   sigBytes,
+	}
+	return
+}
+
+// VerifyNodeSignature verifies sig of the THashH of data against the public key of
+// nodeID in public keystore. The error is caused by ErrKeyNotFound if the node is
+// unknown and by ErrNodeRevoked if its key is revoked, while an invalid signature
+// returns false with nil error.
+func VerifyNodeSignature(nodeID proto.NodeID, data []byte, sig *Signature) (valid bool, err error) {
+	if sig == nil {
+		return false, ErrNilSignature
+	}
+
+	var key asymmetric.TypedPublicKey
+	if key, err = GetTypedPublicKey(nodeID); err != nil {
+		return
+	}
+	if key.KeyType() != sig.KeyType {
+		return
+	}
+	valid = key.VerifyBytes(hash.THashB(data), sig.Bytes)
+	return
+}
+
+// GetTypedPublicKey gets the public key of any key type of given id in public keystore,
+// the key of a revoked node is not returned.
+func GetTypedPublicKey(id proto.NodeID) (key asymmetric.TypedPublicKey, err error) {
+	if err = checkNotRevoked(id); err != nil {
+		return
+	}
+	var node *proto.Node
+	if node, err = GetNodeInfo(id); err != nil {
+		return
+	}
+	if key, err = node.TypedPublicKey(); err != nil {
+		err = errors.Wrapf(err, "get public key of node %s failed", id)
+	}
+	return
+}
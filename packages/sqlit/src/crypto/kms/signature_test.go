@@ -0,0 +1,96 @@
+
+package kms
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	. "github.com/smartystreets/goconvey/convey"
+
+	"sqlit/src/crypto/asymmetric"
+	"sqlit/src/proto"
+	"sqlit/src/utils"
+)
+
+func TestVerifyNodeSignature(t *testing.T) {
+	Convey("verify node signature", t, func() {
+		ClosePublicKeyStore()
+		utils.RemoveAll(dbFile + "*")
+		defer utils.RemoveAll(dbFile + "*")
+		defer ClosePublicKeyStore()
+		So(InitPublicKeyStore(dbFile, nil), ShouldBeNil)
+		ResetLocalKeyStore()
+		defer ResetLocalKeyStore()
+
+		secpPrivate, secpPublic, _ := asymmetric.GenSecp256k1KeyPair()
+		edPrivate, edPublic, _ := asymmetric.GenEd25519KeyPair()
+		secpNode := &proto.Node{PublicKey: secpPublic}
+		edNode := &proto.Node{KeyType: asymmetric.Ed25519, Ed25519PublicKey: edPublic}
+		for _, node := range []*proto.Node{secpNode, edNode} {
+			key, _ := node.TypedPublicKey()
+			nonce := asymmetric.GetTypedPubKeyNonce(key, 1, 50*time.Millisecond, nil)
+			node.ID = proto.NodeID(nonce.Hash.String())
+			node.Nonce = nonce.Nonce
+			So(SetNode(node), ShouldBeNil)
+		}
+
+		data := []byte("payload")
+		SetLocalTypedKeyPair(edPrivate)
+		edSig, err := SignNodeData(data)
+		So(err, ShouldBeNil)
+		So(edSig.KeyType, ShouldEqual, asymmetric.Ed25519)
+
+		valid, err := VerifyNodeSignature(edNode.ID, data, edSig)
+		So(err, ShouldBeNil)
+		So(valid, ShouldBeTrue)
+		valid, err = VerifyNodeSignature(edNode.ID, []byte("other"), edSig)
+		So(err, ShouldBeNil)
+		So(valid, ShouldBeFalse)
+		// key type mismatch is an invalid signature
+		valid, err = VerifyNodeSignature(secpNode.ID, data, edSig)
+		So(err, ShouldBeNil)
+		So(valid, ShouldBeFalse)
+
+		_, err = VerifyNodeSignature(proto.NodeID("unknown"), data, edSig)
+		So(errors.Cause(err), ShouldEqual, ErrKeyNotFound)
+		_, err = VerifyNodeSignature(edNode.ID, data, nil)
+		So(err, ShouldEqual, ErrNilSignature)
+
+		// verify peers signed by secp256k1 node on the receiving side
+		peers := &proto.Peers{
+			PeersHeader: proto.PeersHeader{
+				Term:    1,
+				Leader:  secpNode.ID,
+				Servers: []proto.NodeID{secpNode.ID, edNode.ID},
+			},
+		}
+		So(peers.Sign(secpPrivate), ShouldBeNil)
+		header, err := peers.PeersHeader.MarshalHash()
+		So(err, ShouldBeNil)
+		valid, err = VerifyNodeSignature(peers.Leader, header, NewSecp256k1Signature(peers.Signature))
+		So(err, ShouldBeNil)
+		So(valid, ShouldBeTrue)
+		So(NewSecp256k1Signature(nil), ShouldBeNil)
+
+		// leader key is looked up in public keystore
+		valid, err = peers.VerifyLeader()
+		So(err, ShouldBeNil)
+		So(valid, ShouldBeTrue)
+		// the secp256k1 signature is rejected for the ed25519 leader
+		peers.Leader = edNode.ID
+		So(peers.Sign(secpPrivate), ShouldBeNil)
+		valid, err = peers.VerifyLeader()
+		So(errors.Cause(err), ShouldEqual, proto.ErrSignatureAlgorithm)
+		So(valid, ShouldBeFalse)
+		So(peers.SignTyped(edPrivate), ShouldBeNil)
+		valid, err = peers.VerifyLeader()
+		So(err, ShouldBeNil)
+		So(valid, ShouldBeTrue)
+		peers.Leader = proto.NodeID("unknown")
+		So(peers.SignTyped(edPrivate), ShouldBeNil)
+		valid, err = peers.VerifyLeader()
+		So(errors.Cause(err), ShouldEqual, ErrKeyNotFound)
+		So(valid, ShouldBeFalse)
+	})
+}
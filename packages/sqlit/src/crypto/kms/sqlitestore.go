@@ -0,0 +1,310 @@
+
+package kms
+
+import (
+	"database/sql"
+	"os"
+	"path/filepath"
+
+	"github.com/mattn/go-sqlite3"
+	"github.com/pkg/errors"
+
+	"sqlit/src/proto"
+	xs "sqlit/src/dpos/sqlite"
+	"sqlit/src/utils"
+)
+
+// ErrCorruptStore indicates the keystore file is a damaged SQLite database, such as
+// one truncated by a crash.
+var ErrCorruptStore = errors.New("corrupt public keystore file")
+
+var (
+	initTableSQL = `CREATE TABLE IF NOT EXISTS "kms" (
+		"id"   TEXT,
+		"node" BLOB,
+		UNIQUE ("id")
+	)`
+	deleteAllSQL    = `DELETE FROM "kms"`
+	deleteRecordSQL = `DELETE FROM "kms" WHERE "id" = ?`
+	setRecordSQL    = `INSERT OR REPLACE INTO "kms" ("id", "node") VALUES(?, ?)`
+	getRecordSQL    = `SELECT "node" FROM "kms" WHERE "id" = ? LIMIT 1`
+	getAllNodeSQL   = `SELECT "id", "node" FROM "kms"`
+	quickCheckSQL   = `PRAGMA quick_check`
+	checkpointSQL   = `PRAGMA wal_checkpoint(TRUNCATE)`
+)
+
+// SQLiteStore is the default Store of public keystore backed by a SQLite file.
+type SQLiteStore struct {
+	db       *xs.SQLite3
+	path     string
+	fileInfo os.FileInfo
+	// written is the state of the file after the last write of this store, the
+	// file changes not matching it are made by the others
+	written os.FileInfo
+	// version is the version header of the file, see StoreVersion
+	version int
+}
+
+// OpenSQLiteStore opens the SQLite file at path, creates it if not exist. A file
+// which is not a SQLite database is backed up and replaced, ErrCorruptStore is
+// returned for a damaged SQLite database and ErrUnknownStoreVersion for a file of a
+// newer version than StoreVersion. An older file is read as it is until it is
+// migrated by InitPublicKeyStore.
+func OpenSQLiteStore(path string) (s *SQLiteStore, err error) {
+	// test if the keystore is a valid sqlite database
+	// if so, truncate and upgrade to new version
+	if err = removeFileIfIsNotSQLite(path); err != nil {
+		return
+	}
+	var strg *xs.SQLite3
+	if strg, err = xs.NewSqlite(path); err != nil {
+		return
+	}
+	var (
+		version int
+		isNew   bool
+	)
+	if version, isNew, err = readStoreVersion(strg.Writer()); err == nil {
+		err = initSQLiteStore(strg.Writer())
+	}
+	if err == nil && isNew {
+		_, err = strg.Writer().Exec(setVersionSQL(StoreVersion))
+	}
+	if err != nil {
+		_ = strg.Close()
+		return
+	}
+	s = &SQLiteStore{db: strg, path: path, version: version}
+	s.fileInfo, _ = os.Stat(path)
+	s.markWritten()
+	return
+}
+
+// Get implements Store.Get.
+func (s *SQLiteStore) Get(id proto.NodeID) (value []byte, err error) {
+	if err = s.db.Writer().QueryRow(getRecordSQL, string(id)).Scan(&value); err != nil {
+		if errors.Cause(err) == sql.ErrNoRows {
+			err = ErrKeyNotFound
+		}
+	}
+	return
+}
+
+// Put implements Store.Put.
+func (s *SQLiteStore) Put(id proto.NodeID, value []byte) (err error) {
+	_, err = s.db.Writer().Exec(setRecordSQL, string(id), value)
+	s.markWritten()
+	return
+}
+
+// Delete implements Store.Delete.
+func (s *SQLiteStore) Delete(id proto.NodeID) (err error) {
+	_, err = s.db.Writer().Exec(deleteRecordSQL, string(id))
+	s.markWritten()
+	return
+}
+
+// Range implements Store.Range.
+func (s *SQLiteStore) Range(fn func(id proto.NodeID, value []byte) error) (err error) {
+	return rangeSQLite(s.db.Writer(), fn)
+}
+
+// PutAll implements BatchStore.PutAll. With replace the entries are written to a
+// new file which then replaces the keystore file by rename, so the file holds
+// either the old or the new entries after a crash.
+func (s *SQLiteStore) PutAll(entries map[proto.NodeID][]byte, replace bool) (err error) {
+	if replace {
+		return s.replaceFile(entries)
+	}
+	err = putAllSQLite(s.db.Writer(), entries, false)
+	s.markWritten()
+	return
+}
+
+// Close implements Store.Close.
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
+
+// markWritten records the state of the file after a write of this store.
+func (s *SQLiteStore) markWritten() {
+	s.written, _ = os.Stat(s.path)
+}
+
+// changedByOthers returns if the file is replaced or modified since the last write
+// of this store.
+func (s *SQLiteStore) changedByOthers() bool {
+	fileInfo, err := os.Stat(s.path)
+	if err != nil || s.written == nil {
+		return true
+	}
+	return !os.SameFile(s.written, fileInfo) || !s.written.ModTime().Equal(fileInfo.ModTime()) ||
+		s.written.Size() != fileInfo.Size()
+}
+
+// reopen reopens the file at s.path, the wal and shm files are removed if the
+// file is replaced since last open. The same file is opened again before the
+// current connection is closed, so the store keeps working if it fails.
+func (s *SQLiteStore) reopen() (err error) {
+	var fileInfo os.FileInfo
+	if fileInfo, err = os.Stat(s.path); err != nil {
+		return
+	}
+	if s.fileInfo != nil && !os.SameFile(s.fileInfo, fileInfo) {
+		// file is replaced, wal and shm of the old file are stale, the old connection
+		// is closed first as closing it removes the wal by name
+		_ = s.db.Close()
+		_ = os.Remove(s.path + "-wal")
+		_ = os.Remove(s.path + "-shm")
+	}
+	var strg *xs.SQLite3
+	if strg, err = xs.NewSqlite(s.path); err != nil {
+		return
+	}
+	// closing a closed connection is a no-op
+	_ = s.db.Close()
+	s.db = strg
+	s.fileInfo = fileInfo
+	s.markWritten()
+	return
+}
+
+// replaceFile replaces the keystore file by a new one holding entries and reopens it.
+func (s *SQLiteStore) replaceFile(entries map[proto.NodeID][]byte) (err error) {
+	// close first so the wal of the old file is not written after the rename
+	_ = s.db.Close()
+	err = writeSQLiteFile(s.path, entries)
+	if reopenErr := s.reopen(); err == nil && reopenErr != nil {
+		err = errors.Wrap(reopenErr, "reopen keystore file failed")
+	}
+	return
+}
+
+// initSQLiteStore creates the table if not exist and checks the database, the
+// damaged database errors are reported as ErrCorruptStore.
+func initSQLiteStore(db *sql.DB) (err error) {
+	if _, err = db.Exec(initTableSQL); err != nil {
+		return corruptStoreError(err)
+	}
+	var result string
+	if err = db.QueryRow(quickCheckSQL).Scan(&result); err != nil {
+		return corruptStoreError(err)
+	}
+	if result != "ok" {
+		return errors.Wrap(ErrCorruptStore, result)
+	}
+	return
+}
+
+// corruptStoreError wraps err with ErrCorruptStore if it is a SQLite corruption error.
+func corruptStoreError(err error) error {
+	if e, ok := errors.Cause(err).(sqlite3.Error); ok &&
+		(e.Code == sqlite3.ErrCorrupt || e.Code == sqlite3.ErrNotADB) {
+		return errors.Wrap(ErrCorruptStore, err.Error())
+	}
+	return err
+}
+
+// writeSQLiteFile writes entries to a temporary SQLite file aside path and renames it
+// to path, path always holds a complete keystore even on crash.
+func writeSQLiteFile(path string, entries map[proto.NodeID][]byte) (err error) {
+	tmpFile := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	utils.RemoveAll(tmpFile + "*")
+	defer func() {
+		if err != nil {
+			utils.RemoveAll(tmpFile + "*")
+		}
+	}()
+	var strg *xs.SQLite3
+	if strg, err = xs.NewSqlite(tmpFile); err != nil {
+		err = errors.Wrap(err, "create keystore file failed")
+		return
+	}
+	if _, err = strg.Writer().Exec(initTableSQL); err == nil {
+		_, err = strg.Writer().Exec(setVersionSQL(StoreVersion))
+	}
+	if err == nil {
+		if err = putAllSQLite(strg.Writer(), entries, false); err == nil {
+			// move the wal content into the file, only the file is renamed
+			_, err = strg.Writer().Exec(checkpointSQL)
+		}
+	}
+	if closeErr := strg.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = syncFile(tmpFile)
+	}
+	if err != nil {
+		err = errors.Wrap(err, "write keystore file failed")
+		return
+	}
+	utils.RemoveAll(tmpFile + "-*")
+	if err = os.Rename(tmpFile, path); err != nil {
+		err = errors.Wrap(err, "rename keystore file failed")
+		return
+	}
+	// sync the directory to persist the rename
+	if dir, dirErr := os.Open(filepath.Dir(path)); dirErr == nil {
+		_ = dir.Sync()
+		_ = dir.Close()
+	}
+	return
+}
+
+func syncFile(path string) (err error) {
+	var f *os.File
+	if f, err = os.OpenFile(path, os.O_RDWR, 0); err != nil {
+		return
+	}
+	err = f.Sync()
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return
+}
+
+func putAllSQLite(db *sql.DB, entries map[proto.NodeID][]byte, replace bool) (err error) {
+	var tx *sql.Tx
+	if tx, err = db.Begin(); err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+	if replace {
+		if _, err = tx.Exec(deleteAllSQL); err != nil {
+			return
+		}
+	}
+	for id, value := range entries {
+		if _, err = tx.Exec(setRecordSQL, string(id), value); err != nil {
+			return
+		}
+	}
+	return tx.Commit()
+}
+
+func rangeSQLite(db *sql.DB, fn func(id proto.NodeID, value []byte) error) (err error) {
+	var rows *sql.Rows
+	if rows, err = db.Query(getAllNodeSQL); err != nil {
+		return
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			rawNodeID string
+			value     []byte
+		)
+		if err = rows.Scan(&rawNodeID, &value); err != nil {
+			return
+		}
+		if err = fn(proto.NodeID(rawNodeID), value); err != nil {
+			return
+		}
+	}
+	return rows.Err()
+}
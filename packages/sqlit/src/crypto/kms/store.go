@@ -0,0 +1,86 @@
+
+package kms
+
+import (
+	"github.com/pkg/errors"
+
+	"sqlit/src/proto"
+	"sqlit/src/utils"
+	"sqlit/src/utils/log"
+)
+
+// Store is the key value backend of public keystore, the key is node id and
+// the value is msgpack encoded proto.Node. Put and Delete must be durable on return.
+type Store interface {
+	// Get returns the value of id, ErrKeyNotFound is returned if id does not exist.
+	Get(id proto.NodeID) (value []byte, err error)
+	// Put sets the value of id.
+	Put(id proto.NodeID, value []byte) error
+	// Delete removes id, it is not an error if id does not exist.
+	Delete(id proto.NodeID) error
+	// Range calls fn for every key value pair until fn returns an error.
+	Range(fn func(id proto.NodeID, value []byte) error) error
+	// Close closes the store.
+	Close() error
+}
+
+// BatchStore is the optional interface implemented by a Store which can put
+// multiple values atomically.
+type BatchStore interface {
+	Store
+	// PutAll sets all the entries in one transaction, all the existing
+	// entries are removed first if replace is true.
+	PutAll(entries map[proto.NodeID][]byte, replace bool) error
+}
+
+// putAll sets entries into store, atomically if store is a BatchStore.
+func putAll(store Store, entries map[proto.NodeID][]byte, replace bool) (err error) {
+	if bs, ok := store.(BatchStore); ok {
+		return bs.PutAll(entries, replace)
+	}
+	if replace {
+		var ids []proto.NodeID
+		if err = store.Range(func(id proto.NodeID, _ []byte) error {
+			ids = append(ids, id)
+			return nil
+		}); err != nil {
+			return
+		}
+		for _, id := range ids {
+			if _, ok := entries[id]; ok {
+				continue
+			}
+			if err = store.Delete(id); err != nil {
+				return
+			}
+		}
+	}
+	for id, value := range entries {
+		if err = store.Put(id, value); err != nil {
+			return
+		}
+	}
+	return
+}
+
+// loadStoreNodes decodes all the nodes in store, the undecodable nodes are
+// skipped and the first error is returned.
+func loadStoreNodes(store Store) (nodes map[proto.NodeID]*proto.Node, err error) {
+	nodes = make(map[proto.NodeID]*proto.Node)
+	rangeErr := store.Range(func(id proto.NodeID, value []byte) error {
+		var nodeInfo *proto.Node
+		if decErr := utils.DecodeMsgPack(value, &nodeInfo); decErr != nil || nodeInfo == nil {
+			log.WithField("node", id).WithError(decErr).Warning("decode keystore node failed")
+			if err == nil {
+				err = errors.Wrapf(decErr, "decode keystore node %s failed", id)
+			}
+			return nil
+		}
+		nodes[id] = nodeInfo
+		return nil
+	})
+	if rangeErr != nil {
+		err = errors.Wrap(rangeErr, "load keystore nodes failed")
+	}
+	return
+}
@@ -0,0 +1,192 @@
+
+package kms
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pkg/errors"
+	. "github.com/smartystreets/goconvey/convey"
+
+	"sqlit/src/conf"
+	"sqlit/src/crypto/asymmetric"
+	"sqlit/src/pow/cpuminer"
+	"sqlit/src/proto"
+	"sqlit/src/utils"
+)
+
+const boltFile = ".test.keystore.bolt"
+
+// plainStore hides the BatchStore implementation of the wrapped store.
+type plainStore struct {
+	Store
+}
+
+func testStore(store Store) {
+	_, err := store.Get("1111")
+	So(err, ShouldEqual, ErrKeyNotFound)
+	So(store.Delete("1111"), ShouldBeNil)
+
+	So(store.Put("1111", []byte("a")), ShouldBeNil)
+	So(store.Put("2222", []byte("b")), ShouldBeNil)
+	So(store.Put("1111", []byte("c")), ShouldBeNil)
+	value, err := store.Get("1111")
+	So(err, ShouldBeNil)
+	So(value, ShouldResemble, []byte("c"))
+
+	all := make(map[proto.NodeID]string)
+	So(store.Range(func(id proto.NodeID, value []byte) error {
+		all[id] = string(value)
+		return nil
+	}), ShouldBeNil)
+	So(all, ShouldResemble, map[proto.NodeID]string{"1111": "c", "2222": "b"})
+
+	stop := errors.New("stop")
+	So(store.Range(func(proto.NodeID, []byte) error {
+		return stop
+	}), ShouldEqual, stop)
+
+	So(store.Delete("2222"), ShouldBeNil)
+	_, err = store.Get("2222")
+	So(err, ShouldEqual, ErrKeyNotFound)
+
+	So(putAll(store, map[proto.NodeID][]byte{"3333": []byte("d")}, false), ShouldBeNil)
+	value, err = store.Get("1111")
+	So(err, ShouldBeNil)
+	So(value, ShouldResemble, []byte("c"))
+	So(putAll(store, map[proto.NodeID][]byte{"4444": []byte("e")}, true), ShouldBeNil)
+	all = make(map[proto.NodeID]string)
+	So(store.Range(func(id proto.NodeID, value []byte) error {
+		all[id] = string(value)
+		return nil
+	}), ShouldBeNil)
+	So(all, ShouldResemble, map[proto.NodeID]string{"4444": "e"})
+}
+
+func TestStore(t *testing.T) {
+	Convey("SQLite store", t, func() {
+		utils.RemoveAll(dbFile + "*")
+		defer utils.RemoveAll(dbFile + "*")
+		store, err := OpenSQLiteStore(dbFile)
+		So(err, ShouldBeNil)
+		defer store.Close()
+		testStore(store)
+
+		// replace rewrites the file by rename, no temporary file is left
+		So(utils.Exist("."+dbFile+".tmp"), ShouldBeFalse)
+		So(store.Close(), ShouldBeNil)
+		reopened, err := OpenSQLiteStore(dbFile)
+		So(err, ShouldBeNil)
+		defer reopened.Close()
+		value, err := reopened.Get("4444")
+		So(err, ShouldBeNil)
+		So(value, ShouldResemble, []byte("e"))
+		_, err = reopened.Get("1111")
+		So(err, ShouldEqual, ErrKeyNotFound)
+	})
+	Convey("bolt store", t, func() {
+		utils.RemoveAll(boltFile + "*")
+		defer utils.RemoveAll(boltFile + "*")
+		store, err := OpenBoltStore(boltFile)
+		So(err, ShouldBeNil)
+		defer store.Close()
+		testStore(store)
+	})
+	Convey("store without batch", t, func() {
+		utils.RemoveAll(boltFile + "*")
+		defer utils.RemoveAll(boltFile + "*")
+		store, err := OpenBoltStore(boltFile)
+		So(err, ShouldBeNil)
+		defer store.Close()
+		testStore(plainStore{store})
+	})
+}
+
+func TestPublicKeyStoreWithBolt(t *testing.T) {
+	_, pubKey, _ := asymmetric.GenSecp256k1KeyPair()
+	node := &proto.Node{
+		ID:        proto.NodeID("1111"),
+		PublicKey: pubKey,
+		Nonce:     cpuminer.Uint256{A: 1},
+	}
+	BPNode := proto.Node{
+		ID:        BP.NodeID,
+		PublicKey: BP.PublicKey,
+		Nonce:     BP.Nonce,
+	}
+
+	Convey("public keystore on bolt store", t, func() {
+		ClosePublicKeyStore()
+		utils.RemoveAll(boltFile + "*")
+		defer utils.RemoveAll(boltFile + "*")
+		defer ClosePublicKeyStore()
+
+		So(InitPublicKeyStoreWithStore(nil, nil), ShouldEqual, ErrPKSNotInitialized)
+		store, err := OpenBoltStore(boltFile)
+		So(err, ShouldBeNil)
+		So(InitPublicKeyStoreWithStore(store, []proto.Node{BPNode}), ShouldBeNil)
+		So(setNode(node), ShouldBeNil)
+
+		nodeInfo, err := GetNodeInfo(node.ID)
+		So(err, ShouldBeNil)
+		So(nodeInfo.PublicKey.IsEqual(pubKey), ShouldBeTrue)
+		// modifying the result does not change the keystore
+		nodeInfo.Addr = "127.0.0.1:1111"
+		nodeInfo, err = GetNodeInfo(node.ID)
+		So(err, ShouldBeNil)
+		So(nodeInfo.Addr, ShouldBeEmpty)
+
+		So(WatchPublicKeyStore(context.Background()), ShouldEqual, ErrStoreNotWatchable)
+		So(ReloadPublicKeyStore(), ShouldEqual, ErrStoreNotWatchable)
+
+		Convey("nodes persist across reopen", func() {
+			ClosePublicKeyStore()
+			store, err := OpenBoltStore(boltFile)
+			So(err, ShouldBeNil)
+			So(InitPublicKeyStoreWithStore(store, nil), ShouldBeNil)
+			ids, err := GetAllNodeID()
+			So(err, ShouldBeNil)
+			So(ids, ShouldHaveLength, 2)
+			So(ids, ShouldContain, node.ID)
+			So(ids, ShouldContain, BP.NodeID)
+
+			So(DelNode(node.ID), ShouldBeNil)
+			_, err = GetNodeInfo(node.ID)
+			So(errors.Cause(err), ShouldEqual, ErrKeyNotFound)
+			So(ResetBucket(), ShouldBeNil)
+			ids, err = GetAllNodeID()
+			So(err, ShouldBeNil)
+			So(ids, ShouldBeEmpty)
+		})
+	})
+	Convey("public keystore backend by config", t, func() {
+		ClosePublicKeyStore()
+		utils.RemoveAll(boltFile + "*")
+		defer utils.RemoveAll(boltFile + "*")
+		defer ClosePublicKeyStore()
+		defer func(backend string) { conf.GConf.PubKeyStoreBackend = backend }(conf.GConf.PubKeyStoreBackend)
+
+		conf.GConf.PubKeyStoreBackend = "unknown"
+		So(InitPublicKeyStore(boltFile, nil), ShouldEqual, ErrUnknownStoreBackend)
+
+		conf.GConf.PubKeyStoreBackend = BoltStoreBackend
+		So(InitPublicKeyStore(boltFile, []proto.Node{BPNode}), ShouldBeNil)
+		So(setNode(node), ShouldBeNil)
+		ClosePublicKeyStore()
+
+		// the file is a bolt one
+		store, err := OpenBoltStore(boltFile)
+		So(err, ShouldBeNil)
+		So(InitPublicKeyStoreWithStore(store, nil), ShouldBeNil)
+		ids, err := GetAllNodeID()
+		So(err, ShouldBeNil)
+		So(ids, ShouldContain, node.ID)
+		So(ids, ShouldContain, BP.NodeID)
+
+		// without config it is the default backend and nothing to rebuild from
+		defer func(saved *conf.Config) { conf.GConf = saved }(conf.GConf)
+		conf.GConf = nil
+		So(pubKeyStoreBackend(), ShouldEqual, SQLiteStoreBackend)
+		So(knownNodeEntries(), ShouldBeEmpty)
+	})
+}
@@ -0,0 +1,132 @@
+
+package kms
+
+import (
+	"database/sql"
+	"strconv"
+
+	"github.com/pkg/errors"
+
+	"sqlit/src/proto"
+	"sqlit/src/utils"
+	"sqlit/src/utils/log"
+)
+
+// ErrUnknownStoreVersion indicates the keystore file is written by a newer version
+// of the node, it is not read rather than misparsed.
+var ErrUnknownStoreVersion = errors.New("unknown public keystore version")
+
+// storeMigration upgrades the records of a keystore file by one version, dropped is
+// the ids of the records not upgradable.
+type storeMigration func(entries map[proto.NodeID][]byte) (dropped []proto.NodeID, err error)
+
+// storeMigrations upgrades a keystore file of version i to i+1 at index i, the
+// version 0 is the file without version header.
+var storeMigrations = []storeMigration{
+	migrateStoreV0,
+}
+
+// StoreVersion is the version of the keystore files written by this node, it is kept
+// in the user_version header of the SQLite file. It is len(storeMigrations).
+const StoreVersion = 1
+
+var (
+	getVersionSQL  = `PRAGMA user_version`
+	tableExistsSQL = `SELECT count(*) FROM "sqlite_master" WHERE "type" = 'table' AND "name" = 'kms'`
+)
+
+func setVersionSQL(version int) string {
+	return `PRAGMA user_version = ` + strconv.Itoa(version)
+}
+
+// readStoreVersion returns the version of the keystore file of db, a new file with
+// no table yet is of StoreVersion. A version newer than StoreVersion is an error
+// caused by ErrUnknownStoreVersion.
+func readStoreVersion(db *sql.DB) (version int, isNew bool, err error) {
+	if err = db.QueryRow(getVersionSQL).Scan(&version); err != nil {
+		err = corruptStoreError(err)
+		return
+	}
+	var tables int
+	if err = db.QueryRow(tableExistsSQL).Scan(&tables); err != nil {
+		err = corruptStoreError(err)
+		return
+	}
+	if tables == 0 && version == 0 {
+		return StoreVersion, true, nil
+	}
+	if version > StoreVersion {
+		err = errors.Wrapf(ErrUnknownStoreVersion,
+			"file version %d is newer than the supported version %d, upgrade the node",
+			version, StoreVersion)
+	}
+	return
+}
+
+// migrateSQLiteStore upgrades s to StoreVersion, the upgraded records are written to
+// a new file which then replaces the keystore file, so a failed migration leaves the
+// old file as it is.
+func migrateSQLiteStore(s *SQLiteStore) (err error) {
+	if s.version >= StoreVersion {
+		return
+	}
+	entries := make(map[proto.NodeID][]byte)
+	if err = s.Range(func(id proto.NodeID, value []byte) error {
+		entries[id] = value
+		return nil
+	}); err != nil {
+		return errors.Wrap(err, "read keystore for migration failed")
+	}
+	from := s.version
+	var dropped []proto.NodeID
+	for v := from; v < StoreVersion; v++ {
+		var d []proto.NodeID
+		if d, err = storeMigrations[v](entries); err != nil {
+			return errors.Wrapf(err, "migrate keystore from version %d failed", v)
+		}
+		dropped = append(dropped, d...)
+	}
+	if err = s.replaceFile(entries); err != nil {
+		return errors.Wrap(err, "write migrated keystore failed")
+	}
+	s.version = StoreVersion
+	log.WithFields(log.Fields{
+		"path":    s.path,
+		"from":    from,
+		"to":      StoreVersion,
+		"nodes":   len(entries),
+		"dropped": dropped,
+	}).Warning("public keystore migrated")
+	return
+}
+
+// migrateStoreV0 upgrades the records of the nodes before the multiple addresses,
+// key types and locality tags to the current node encoding: the records not decoded
+// are dropped, a node without ID takes the id of its record and the addresses are
+// deduplicated with Addr as the primary.
+func migrateStoreV0(entries map[proto.NodeID][]byte) (dropped []proto.NodeID, err error) {
+	for id, value := range entries {
+		var n *proto.Node
+		if decErr := utils.DecodeMsgPack(value, &n); decErr != nil || n == nil {
+			log.WithField("node", id).WithError(decErr).Warning("drop undecodable keystore node")
+			delete(entries, id)
+			dropped = append(dropped, id)
+			continue
+		}
+		if n.ID.IsEmpty() {
+			n.ID = id
+		}
+		if candidates := n.AddrCandidates(); len(candidates) > 0 {
+			n.Addr, n.Addrs = candidates[0], candidates[1:]
+			if len(n.Addrs) == 0 {
+				n.Addrs = nil
+			}
+		}
+		nodeBuf, encErr := utils.EncodeMsgPack(n)
+		if encErr != nil {
+			return nil, errors.Wrapf(encErr, "encode keystore node %s failed", id)
+		}
+		entries[id] = nodeBuf.Bytes()
+	}
+	return
+}
@@ -0,0 +1,137 @@
+
+package kms
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	. "github.com/smartystreets/goconvey/convey"
+
+	"sqlit/src/crypto/asymmetric"
+	xs "sqlit/src/dpos/sqlite"
+	"sqlit/src/pow/cpuminer"
+	"sqlit/src/proto"
+	"sqlit/src/utils"
+)
+
+// nodeV0 is the node encoded in the keystore files of version 0.
+type nodeV0 struct {
+	ID         proto.NodeID
+	Role       proto.ServerRole
+	Addr       string
+	DirectAddr string
+	PublicKey  *asymmetric.PublicKey
+	Nonce      cpuminer.Uint256
+}
+
+// writeStoreV0 writes the keystore file of version 0 holding records at path.
+func writeStoreV0(path string, records map[proto.NodeID]interface{}) {
+	strg, err := xs.NewSqlite(path)
+	So(err, ShouldBeNil)
+	defer strg.Close()
+	_, err = strg.Writer().Exec(`CREATE TABLE IF NOT EXISTS "kms" ("id" TEXT, "node" BLOB, UNIQUE ("id"))`)
+	So(err, ShouldBeNil)
+	for id, record := range records {
+		value, ok := record.([]byte)
+		if !ok {
+			buf, err := utils.EncodeMsgPack(record)
+			So(err, ShouldBeNil)
+			value = buf.Bytes()
+		}
+		_, err = strg.Writer().Exec(setRecordSQL, string(id), value)
+		So(err, ShouldBeNil)
+	}
+}
+
+// fileStoreVersion reads the version header of the keystore file at path.
+func fileStoreVersion(path string) (version int) {
+	strg, err := xs.NewSqlite(path)
+	So(err, ShouldBeNil)
+	defer strg.Close()
+	So(strg.Writer().QueryRow(getVersionSQL).Scan(&version), ShouldBeNil)
+	return
+}
+
+func TestStoreVersion(t *testing.T) {
+	Convey("the migrations lead to the store version", t, func() {
+		So(storeMigrations, ShouldHaveLength, StoreVersion)
+	})
+	Convey("a new keystore file is of the store version", t, func() {
+		utils.RemoveAll(dbFile + "*")
+		defer utils.RemoveAll(dbFile + "*")
+		store, err := OpenSQLiteStore(dbFile)
+		So(err, ShouldBeNil)
+		So(store.version, ShouldEqual, StoreVersion)
+		So(store.PutAll(map[proto.NodeID][]byte{"1111": []byte("a")}, true), ShouldBeNil)
+		So(store.Close(), ShouldBeNil)
+		So(fileStoreVersion(dbFile), ShouldEqual, StoreVersion)
+	})
+	Convey("a keystore file of version 0 is migrated in place", t, func() {
+		utils.RemoveAll(dbFile + "*")
+		defer utils.RemoveAll(dbFile + "*")
+		defer ClosePublicKeyStore()
+		_, pubKey, err := asymmetric.GenSecp256k1KeyPair()
+		So(err, ShouldBeNil)
+		var (
+			miner   = proto.NodeID("00000000000000000000000000000000000000000000000000000000000000a1")
+			leader  = proto.NodeID("00000000000000000000000000000000000000000000000000000000000000a2")
+			broken  = proto.NodeID("00000000000000000000000000000000000000000000000000000000000000a3")
+			minerV0 = nodeV0{
+				ID: miner, Role: proto.Miner, Addr: "10.0.0.1:4661", DirectAddr: "10.0.0.1:4662",
+				PublicKey: pubKey, Nonce: cpuminer.Uint256{A: 1, D: 4},
+			}
+		)
+		writeStoreV0(dbFile, map[proto.NodeID]interface{}{
+			miner: minerV0,
+			// the early records have no id in the node
+			leader: nodeV0{Role: proto.Leader, Addr: "10.0.0.2:4661", PublicKey: pubKey},
+			broken: []byte("not a msgpack node"),
+		})
+		So(fileStoreVersion(dbFile), ShouldEqual, 0)
+
+		So(InitPublicKeyStore(dbFile, nil), ShouldBeNil)
+		So(fileStoreVersion(dbFile), ShouldEqual, StoreVersion)
+		So(utils.Exist("."+dbFile+".tmp"), ShouldBeFalse)
+
+		node, err := GetNodeInfo(miner)
+		So(err, ShouldBeNil)
+		So(node.ID, ShouldEqual, miner)
+		So(node.Role, ShouldEqual, proto.Miner)
+		So(node.Addr, ShouldEqual, minerV0.Addr)
+		So(node.Addrs, ShouldBeNil)
+		So(node.DirectAddr, ShouldEqual, minerV0.DirectAddr)
+		So(node.PublicKey.IsEqual(pubKey), ShouldBeTrue)
+		So(node.Nonce, ShouldResemble, minerV0.Nonce)
+		So(node.KeyType, ShouldEqual, asymmetric.Secp256k1)
+		node, err = GetNodeInfo(leader)
+		So(err, ShouldBeNil)
+		So(node.ID, ShouldEqual, leader)
+		_, err = GetNodeInfo(broken)
+		So(errors.Cause(err), ShouldEqual, ErrKeyNotFound)
+
+		// the migrated file is opened as it is
+		ClosePublicKeyStore()
+		So(InitPublicKeyStore(dbFile, nil), ShouldBeNil)
+		ids, err := GetAllNodeID()
+		So(err, ShouldBeNil)
+		So(ids, ShouldHaveLength, 2)
+	})
+	Convey("a keystore file of a newer version is not read", t, func() {
+		utils.RemoveAll(dbFile + "*")
+		defer utils.RemoveAll(dbFile + "*")
+		defer ClosePublicKeyStore()
+		store, err := OpenSQLiteStore(dbFile)
+		So(err, ShouldBeNil)
+		So(store.Put("1111", []byte("a future record")), ShouldBeNil)
+		_, err = store.db.Writer().Exec(setVersionSQL(StoreVersion + 1))
+		So(err, ShouldBeNil)
+		So(store.Close(), ShouldBeNil)
+
+		err = InitPublicKeyStore(dbFile, nil)
+		So(errors.Cause(err), ShouldEqual, ErrUnknownStoreVersion)
+		So(err.Error(), ShouldContainSubstring, "newer than the supported version")
+		// the file is neither rebuilt nor migrated
+		So(utils.Exist(dbFile+".corrupt"), ShouldBeFalse)
+		So(fileStoreVersion(dbFile), ShouldEqual, StoreVersion+1)
+	})
+}
@@ -0,0 +1,28 @@
+
+package kms
+
+import (
+	"sort"
+
+	"sqlit/src/proto"
+)
+
+// NodesByTag returns a copy of the nodes in public keystore whose tag key is value,
+// sorted by node id.
+func NodesByTag(key, value string) (nodes []proto.Node, err error) {
+	pksLock.RLock()
+	defer pksLock.RUnlock()
+	if pks == nil || pks.store == nil {
+		return nil, ErrPKSNotInitialized
+	}
+
+	for _, n := range pks.cache {
+		if v, ok := n.Tags[key]; ok && v == value {
+			nodes = append(nodes, *cloneNode(n))
+		}
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].ID < nodes[j].ID
+	})
+	return
+}
@@ -0,0 +1,67 @@
+
+package kms
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"sqlit/src/crypto/asymmetric"
+	"sqlit/src/proto"
+	"sqlit/src/utils"
+)
+
+func TestNodesByTag(t *testing.T) {
+	Convey("the nodes are queried by their tags", t, func() {
+		ClosePublicKeyStore()
+		utils.RemoveAll(dbFile + "*")
+		defer utils.RemoveAll(dbFile + "*")
+		defer ClosePublicKeyStore()
+
+		_, err := NodesByTag("owner", "ops")
+		So(err, ShouldEqual, ErrPKSNotInitialized)
+		So(InitPublicKeyStore(dbFile, nil), ShouldBeNil)
+
+		_, pubKey, err := asymmetric.GenSecp256k1KeyPair()
+		So(err, ShouldBeNil)
+		var (
+			n1 = proto.NodeID("0000000000000000000000000000000000000000000000000000000000000001")
+			n2 = proto.NodeID("0000000000000000000000000000000000000000000000000000000000000002")
+			n3 = proto.NodeID("0000000000000000000000000000000000000000000000000000000000000003")
+		)
+		for id, tags := range map[proto.NodeID]map[string]string{
+			n2: {"owner": "ops", "hardware": "nvme"},
+			n1: {"owner": "ops"},
+			n3: {"owner": "dev", "empty": ""},
+		} {
+			So(SetNode(&proto.Node{ID: id, PublicKey: pubKey, Tags: tags}, WithoutVerifyID()), ShouldBeNil)
+		}
+
+		nodes, err := NodesByTag("owner", "ops")
+		So(err, ShouldBeNil)
+		So(nodes, ShouldHaveLength, 2)
+		So(nodes[0].ID, ShouldEqual, n1)
+		So(nodes[1].ID, ShouldEqual, n2)
+		nodes, err = NodesByTag("empty", "")
+		So(err, ShouldBeNil)
+		So(nodes, ShouldHaveLength, 1)
+		So(nodes[0].ID, ShouldEqual, n3)
+		nodes, err = NodesByTag("region", "")
+		So(err, ShouldBeNil)
+		So(nodes, ShouldBeEmpty)
+
+		// the tags survive the store and are not shared with callers
+		nodes, err = NodesByTag("hardware", "nvme")
+		So(err, ShouldBeNil)
+		nodes[0].Tags["hardware"] = "hdd"
+		node, err := GetNodeInfo(n2)
+		So(err, ShouldBeNil)
+		So(node.Tags["hardware"], ShouldEqual, "nvme")
+		ClosePublicKeyStore()
+		So(InitPublicKeyStore(dbFile, nil), ShouldBeNil)
+		nodes, err = NodesByTag("hardware", "nvme")
+		So(err, ShouldBeNil)
+		So(nodes, ShouldHaveLength, 1)
+		So(nodes[0].Tags, ShouldResemble, map[string]string{"owner": "ops", "hardware": "nvme"})
+	})
+}
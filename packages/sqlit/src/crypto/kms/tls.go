@@ -0,0 +1,273 @@
+
+package kms
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"sqlit/src/conf"
+	"sqlit/src/crypto/asymmetric"
+	"sqlit/src/crypto/hash"
+	"sqlit/src/proto"
+)
+
+var (
+	// ErrNoNodeIdentity indicates the certificate is not bound to a node identity
+	ErrNoNodeIdentity = errors.New("certificate not bound to a node identity")
+	// ErrNodeIdentityMismatch indicates the certificate is not bound to the known key of its node
+	ErrNodeIdentityMismatch = errors.New("certificate does not match the node key")
+	// ErrUnexpectedPeerNode indicates the TLS peer is another node than the expected one
+	ErrUnexpectedPeerNode = errors.New("unexpected tls peer node")
+	// ErrNoPeerCertificate indicates the TLS peer presented no certificate
+	ErrNoPeerCertificate = errors.New("no tls peer certificate")
+)
+
+// NodeCertificateValidity is the validity of the certificates issued by NewNodeCertificate.
+var NodeCertificateValidity = 365 * 24 * time.Hour
+
+// nodeIdentityOID is the certificate extension holding the node identity, it is in
+// the private arc of sqlit.
+var nodeIdentityOID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 58923, 1, 1}
+
+// nodeIdentity is the certificate extension binding the certificate public key to a
+// node, Signature is the node key signature of nodeIdentityData.
+type nodeIdentity struct {
+	KeyType   int
+	Signature []byte
+}
+
+// nodeIdentityData is the data signed by the key of nodeID to bind the certificate
+// public key spki to it.
+func nodeIdentityData(nodeID proto.NodeID, spki []byte) []byte {
+	return append([]byte("sqlit node tls identity "+string(nodeID)+" "), spki...)
+}
+
+// NewNodeCertificate issues a self signed certificate of the local node. The common
+// name is the local node id, and the ECDSA P-256 key of the certificate is bound to
+// the node by a signature of the local node key, as x509 does not support secp256k1.
+func NewNodeCertificate() (cert tls.Certificate, err error) {
+	var nodeID proto.NodeID
+	if nodeID, err = GetLocalNodeID(); err != nil {
+		return
+	}
+	var key *ecdsa.PrivateKey
+	if key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+		return
+	}
+	var spki []byte
+	if spki, err = x509.MarshalPKIXPublicKey(&key.PublicKey); err != nil {
+		return
+	}
+	var sig *Signature
+	if sig, err = signLocalNodeData(nodeIdentityData(nodeID, spki)); err != nil {
+		err = errors.Wrap(err, "sign node identity failed")
+		return
+	}
+	var identity []byte
+	if identity, err = asn1.Marshal(nodeIdentity{
+		KeyType:   int(sig.KeyType),
+		Signature: sig.Bytes,
+	}); err != nil {
+		return
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return
+	}
+	now := time.Now()
+	template := &x509.Certificate{
+		SerialNumber:    serial,
+		Subject:         pkix.Name{CommonName: string(nodeID)},
+		NotBefore:       now.Add(-time.Minute),
+		NotAfter:        now.Add(NodeCertificateValidity),
+		KeyUsage:        x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		ExtraExtensions: []pkix.Extension{{Id: nodeIdentityOID, Value: identity}},
+	}
+	var der []byte
+	if der, err = x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key); err != nil {
+		err = errors.Wrap(err, "create node certificate failed")
+		return
+	}
+	cert = tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+	cert.Leaf, err = x509.ParseCertificate(der)
+	return
+}
+
+// signLocalNodeData signs data like SignNodeData, the local key provider signs if the
+// private key is not in the local key store, e.g. it is in a PKCS#11 token.
+func signLocalNodeData(data []byte) (sig *Signature, err error) {
+	if sig, err = SignNodeData(data); err == nil {
+		return
+	}
+	secp, providerErr := GetLocalKeyProvider().Sign(hash.THashB(data))
+	if providerErr != nil {
+		return
+	}
+	return NewSecp256k1Signature(secp), nil
+}
+
+// WriteNodeCertificate issues a certificate by NewNodeCertificate and writes it and
+// its key in PEM to certFile and keyFile.
+func WriteNodeCertificate(certFile, keyFile string) (err error) {
+	var cert tls.Certificate
+	if cert, err = NewNodeCertificate(); err != nil {
+		return
+	}
+	var keyDER []byte
+	if keyDER, err = x509.MarshalECPrivateKey(cert.PrivateKey.(*ecdsa.PrivateKey)); err != nil {
+		return
+	}
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	if err = os.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		return errors.Wrap(err, "write node certificate key failed")
+	}
+	if err = os.WriteFile(certFile, certPEM, 0644); err != nil {
+		return errors.Wrap(err, "write node certificate failed")
+	}
+	return
+}
+
+// VerifyNodeCertificate verifies cert is bound to the public key of its node in the
+// public keystore and returns the node id, the error is caused by ErrKeyNotFound if
+// the node is unknown.
+func VerifyNodeCertificate(cert *x509.Certificate) (nodeID proto.NodeID, err error) {
+	nodeID = proto.NodeID(cert.Subject.CommonName)
+	if err = nodeID.Validate(); err != nil {
+		return "", errors.Wrap(ErrNoNodeIdentity, "invalid node id")
+	}
+	var key asymmetric.TypedPublicKey
+	if key, err = GetTypedPublicKey(nodeID); err != nil {
+		return
+	}
+	err = verifyNodeIdentity(cert, nodeID, key)
+	return
+}
+
+// verifyNodeIdentity verifies the node identity extension of cert is signed by key of nodeID.
+func verifyNodeIdentity(cert *x509.Certificate, nodeID proto.NodeID, key asymmetric.TypedPublicKey) (err error) {
+	for _, ext := range cert.Extensions {
+		if !ext.Id.Equal(nodeIdentityOID) {
+			continue
+		}
+		var identity nodeIdentity
+		if rest, uErr := asn1.Unmarshal(ext.Value, &identity); uErr != nil || len(rest) > 0 {
+			return errors.Wrap(ErrNoNodeIdentity, "malformed node identity")
+		}
+		data := nodeIdentityData(nodeID, cert.RawSubjectPublicKeyInfo)
+		if asymmetric.KeyType(identity.KeyType) != key.KeyType() ||
+			!key.VerifyBytes(hash.THashB(data), identity.Signature) {
+			return errors.Wrapf(ErrNodeIdentityMismatch, "node %s", nodeID)
+		}
+		return
+	}
+	return ErrNoNodeIdentity
+}
+
+// NewTLSConfig returns the TLS config of the local node by info. The peer must
+// present a certificate bound to the key of its node in the public keystore, the
+// handshake fails otherwise. A non empty peer is the node expected on the other side,
+// e.g. of a dial, otherwise any known node is accepted.
+func NewTLSConfig(info *conf.TLSInfo, peer proto.NodeID) (config *tls.Config, err error) {
+	if info == nil {
+		return nil, errors.New("nil tls config")
+	}
+	var cert tls.Certificate
+	if info.DeriveFromNodeKey {
+		cert, err = NewNodeCertificate()
+	} else {
+		cert, err = loadNodeCertificate(info.CertFile, info.KeyFile)
+	}
+	if err != nil {
+		return
+	}
+
+	config = &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS13,
+		ClientAuth:   tls.RequireAnyClientCert,
+		// there is no CA, the peer certificate is verified against the keystore
+		InsecureSkipVerify: true,
+		VerifyPeerCertificate: func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+			return verifyPeerCertificate(rawCerts, peer, time.Now())
+		},
+	}
+	return
+}
+
+// loadNodeCertificate loads the node certificate in PEM, it must be bound to the
+// local node key.
+func loadNodeCertificate(certFile, keyFile string) (cert tls.Certificate, err error) {
+	if cert, err = tls.LoadX509KeyPair(certFile, keyFile); err != nil {
+		err = errors.Wrap(err, "load node certificate failed")
+		return
+	}
+	if cert.Leaf == nil {
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return
+		}
+	}
+	var (
+		nodeID proto.NodeID
+		key    asymmetric.TypedPublicKey
+	)
+	if nodeID, err = GetLocalNodeID(); err != nil {
+		return
+	}
+	if key, err = GetLocalTypedPublicKey(); err != nil {
+		return
+	}
+	if cert.Leaf.Subject.CommonName != string(nodeID) {
+		err = errors.Wrapf(ErrNodeIdentityMismatch, "certificate of node %s is not the local node",
+			cert.Leaf.Subject.CommonName)
+		return
+	}
+	err = verifyNodeIdentity(cert.Leaf, nodeID, key)
+	return
+}
+
+// verifyPeerCertificate verifies the leaf of the raw peer certificates at now, and
+// that it is the node peer if peer is not empty.
+func verifyPeerCertificate(rawCerts [][]byte, peer proto.NodeID, now time.Time) (err error) {
+	if len(rawCerts) == 0 {
+		return ErrNoPeerCertificate
+	}
+	var cert *x509.Certificate
+	if cert, err = x509.ParseCertificate(rawCerts[0]); err != nil {
+		return errors.Wrap(err, "parse peer certificate failed")
+	}
+	if now.Before(cert.NotBefore) || now.After(cert.NotAfter) {
+		return errors.Errorf("peer certificate of %s is not valid at %s",
+			cert.Subject.CommonName, now.Format(time.RFC3339))
+	}
+	var nodeID proto.NodeID
+	if nodeID, err = VerifyNodeCertificate(cert); err != nil {
+		return
+	}
+	if peer != "" && nodeID != peer {
+		return errors.Wrapf(ErrUnexpectedPeerNode, "expect %s, got %s", peer, nodeID)
+	}
+	return
+}
+
+// PeerNodeID returns the node id of the peer of a TLS connection established with a
+// config of NewTLSConfig.
+func PeerNodeID(state tls.ConnectionState) (nodeID proto.NodeID, err error) {
+	if len(state.PeerCertificates) == 0 {
+		return "", ErrNoPeerCertificate
+	}
+	return VerifyNodeCertificate(state.PeerCertificates[0])
+}
@@ -0,0 +1,161 @@
+
+package kms
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	. "github.com/smartystreets/goconvey/convey"
+
+	"sqlit/src/conf"
+	"sqlit/src/crypto/asymmetric"
+	"sqlit/src/proto"
+	"sqlit/src/utils"
+)
+
+// newTLSTestNode returns a node of private with a valid id, it is not in the keystore.
+func newTLSTestNode(private asymmetric.TypedPrivateKey) *proto.Node {
+	node := &proto.Node{KeyType: private.KeyType()}
+	switch key := private.TypedPubKey().(type) {
+	case *asymmetric.PublicKey:
+		node.PublicKey = key
+	case asymmetric.Ed25519PublicKey:
+		node.Ed25519PublicKey = key
+	}
+	key, _ := node.TypedPublicKey()
+	nonce := asymmetric.GetTypedPubKeyNonce(key, 1, 50*time.Millisecond, nil)
+	node.ID = proto.NodeID(nonce.Hash.String())
+	node.Nonce = nonce.Nonce
+	return node
+}
+
+// useTLSTestNode makes node of private the local node, the local key store is reset
+// as the local key is set only once.
+func useTLSTestNode(private asymmetric.TypedPrivateKey, node *proto.Node) {
+	ResetLocalKeyStore()
+	SetLocalTypedKeyPair(private)
+	SetLocalNodeIDNonce(node.ID.ToRawNodeID().CloneBytes(), &node.Nonce)
+}
+
+// tlsHandshake runs a handshake of client and server over a pipe.
+func tlsHandshake(client, server *tls.Config) (
+	clientState, serverState tls.ConnectionState, clientErr, serverErr error,
+) {
+	c, s := net.Pipe()
+	defer c.Close()
+	defer s.Close()
+	_ = c.SetDeadline(time.Now().Add(5 * time.Second))
+	_ = s.SetDeadline(time.Now().Add(5 * time.Second))
+
+	clientConn, serverConn := tls.Client(c, client), tls.Server(s, server)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if serverErr = serverConn.Handshake(); serverErr != nil {
+			// unblock the client waiting for the server
+			_ = s.Close()
+		}
+		serverState = serverConn.ConnectionState()
+	}()
+	if clientErr = clientConn.Handshake(); clientErr != nil {
+		_ = c.Close()
+	} else {
+		// the tls 1.3 client is done before the server verifies it, read the
+		// server alert or tickets
+		go func() { _, _ = io.Copy(io.Discard, clientConn) }()
+	}
+	clientState = clientConn.ConnectionState()
+	<-done
+	return
+}
+
+func TestNodeTLS(t *testing.T) {
+	Convey("node tls", t, func() {
+		ClosePublicKeyStore()
+		utils.RemoveAll(dbFile + "*")
+		defer utils.RemoveAll(dbFile + "*")
+		defer ClosePublicKeyStore()
+		So(InitPublicKeyStore(dbFile, nil), ShouldBeNil)
+		ResetLocalKeyStore()
+		defer ResetLocalKeyStore()
+
+		serverPrivate, _, _ := asymmetric.GenSecp256k1KeyPair()
+		clientPrivate, _, _ := asymmetric.GenEd25519KeyPair()
+		serverNode := newTLSTestNode(serverPrivate)
+		clientNode := newTLSTestNode(clientPrivate)
+		So(SetNode(serverNode), ShouldBeNil)
+		So(SetNode(clientNode), ShouldBeNil)
+
+		derive := &conf.TLSInfo{DeriveFromNodeKey: true}
+		useTLSTestNode(serverPrivate, serverNode)
+		serverConfig, err := NewTLSConfig(derive, "")
+		So(err, ShouldBeNil)
+		useTLSTestNode(clientPrivate, clientNode)
+		clientConfig, err := NewTLSConfig(derive, serverNode.ID)
+		So(err, ShouldBeNil)
+
+		Convey("the known nodes are mutually verified", func() {
+			clientState, serverState, clientErr, serverErr := tlsHandshake(clientConfig, serverConfig)
+			So(clientErr, ShouldBeNil)
+			So(serverErr, ShouldBeNil)
+			So(clientState.Version, ShouldEqual, tls.VersionTLS13)
+			peer, err := PeerNodeID(clientState)
+			So(err, ShouldBeNil)
+			So(peer, ShouldEqual, serverNode.ID)
+			peer, err = PeerNodeID(serverState)
+			So(err, ShouldBeNil)
+			So(peer, ShouldEqual, clientNode.ID)
+		})
+		Convey("the peer must be the expected node", func() {
+			otherConfig, err := NewTLSConfig(derive, clientNode.ID)
+			So(err, ShouldBeNil)
+			_, _, clientErr, _ := tlsHandshake(otherConfig, serverConfig)
+			So(errors.Cause(clientErr), ShouldEqual, ErrUnexpectedPeerNode)
+		})
+		Convey("the peer must be bound to its known key", func() {
+			otherPrivate, _, _ := asymmetric.GenSecp256k1KeyPair()
+			replacement := newTLSTestNode(otherPrivate)
+			replacement.ID = serverNode.ID
+			So(SetNode(replacement, WithoutVerifyID()), ShouldBeNil)
+			_, _, clientErr, _ := tlsHandshake(clientConfig, serverConfig)
+			So(errors.Cause(clientErr), ShouldEqual, ErrNodeIdentityMismatch)
+		})
+		Convey("an unknown peer is rejected", func() {
+			So(DelNode(clientNode.ID), ShouldBeNil)
+			_, _, _, serverErr := tlsHandshake(clientConfig, serverConfig)
+			So(errors.Cause(serverErr), ShouldEqual, ErrKeyNotFound)
+		})
+		Convey("the certificate files must be bound to the local node", func() {
+			dir, err := os.MkdirTemp("", "sqlit-kms-tls")
+			So(err, ShouldBeNil)
+			defer os.RemoveAll(dir)
+			files := &conf.TLSInfo{
+				CertFile: filepath.Join(dir, "node.crt"),
+				KeyFile:  filepath.Join(dir, "node.key"),
+			}
+			So(WriteNodeCertificate(files.CertFile, files.KeyFile), ShouldBeNil)
+			stat, err := os.Stat(files.KeyFile)
+			So(err, ShouldBeNil)
+			So(stat.Mode().Perm(), ShouldEqual, os.FileMode(0600))
+
+			fileConfig, err := NewTLSConfig(files, serverNode.ID)
+			So(err, ShouldBeNil)
+			_, serverState, clientErr, serverErr := tlsHandshake(fileConfig, serverConfig)
+			So(clientErr, ShouldBeNil)
+			So(serverErr, ShouldBeNil)
+			peer, err := PeerNodeID(serverState)
+			So(err, ShouldBeNil)
+			So(peer, ShouldEqual, clientNode.ID)
+
+			useTLSTestNode(serverPrivate, serverNode)
+			_, err = NewTLSConfig(files, "")
+			So(errors.Cause(err), ShouldEqual, ErrNodeIdentityMismatch)
+		})
+	})
+}
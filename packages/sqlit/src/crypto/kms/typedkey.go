@@ -0,0 +1,179 @@
+
+package kms
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"os"
+
+	"github.com/btcsuite/btcutil/base58"
+
+	"sqlit/src/crypto/asymmetric"
+	"sqlit/src/crypto/symmetric"
+)
+
+var (
+	// PrivateKeyStoreEd25519Version defines the version byte of the ed25519 private key
+	// seed encrypted with the master key like PrivateKeyStoreVersion.
+	PrivateKeyStoreEd25519Version byte = 0x25
+	// PrivateKeyStoreEd25519GCMVersion defines the version byte of the ed25519 private
+	// key seed encrypted with a passphrase like PrivateKeyStoreGCMVersion.
+	PrivateKeyStoreEd25519GCMVersion byte = 0x26
+	// ErrNotSecp256k1Key indicates the private key is of another key type than the
+	// secp256k1 one required, e.g. by LoadPrivateKey
+	ErrNotSecp256k1Key = errors.New("private key is not secp256k1")
+)
+
+// isEd25519KeyVersion returns if version is the version byte of an ed25519 key file.
+func isEd25519KeyVersion(version byte) bool {
+	return version == PrivateKeyStoreEd25519Version || version == PrivateKeyStoreEd25519GCMVersion
+}
+
+// EncodeTypedPrivateKey encodes private key of any key type with masterKey, a
+// secp256k1 key is encoded by EncodePrivateKey.
+func EncodeTypedPrivateKey(key asymmetric.TypedPrivateKey, masterKey []byte) (keyBytes []byte, err error) {
+	switch k := key.(type) {
+	case *asymmetric.PrivateKey:
+		return EncodePrivateKey(k, masterKey)
+	case asymmetric.Ed25519PrivateKey:
+		seed := k.Seed()
+		defer zeroBytes(seed)
+		var encKey []byte
+		if encKey, err = symmetric.EncryptWithPassword(seed, masterKey, privateKDFSalt); err != nil {
+			return
+		}
+		return []byte(base58.CheckEncode(encKey, PrivateKeyStoreEd25519Version)), nil
+	}
+	return nil, asymmetric.ErrUnknownKeyType
+}
+
+// EncodeTypedPrivateKeyWithPassphrase encrypts private key of any key type with
+// AES-256-GCM like EncodePrivateKeyWithPassphrase.
+func EncodeTypedPrivateKeyWithPassphrase(
+	key asymmetric.TypedPrivateKey, passphrase []byte) (keyBytes []byte, err error) {
+	switch k := key.(type) {
+	case *asymmetric.PrivateKey:
+		return EncodePrivateKeyWithPassphrase(k, passphrase)
+	case asymmetric.Ed25519PrivateKey:
+		seed := k.Seed()
+		defer zeroBytes(seed)
+		return sealWithPassphrase(seed, passphrase, PrivateKeyStoreEd25519GCMVersion)
+	}
+	return nil, asymmetric.ErrUnknownKeyType
+}
+
+// DecodeTypedPrivateKey decodes private key of any key type encoded by
+// EncodeTypedPrivateKey or EncodeTypedPrivateKeyWithPassphrase, the secp256k1 keys
+// are decoded by DecodePrivateKey.
+func DecodeTypedPrivateKey(keyBytes []byte, masterKey []byte) (key asymmetric.TypedPrivateKey, err error) {
+	encData, version, decodeErr := base58.CheckDecode(string(keyBytes))
+	if decodeErr != nil || !isEd25519KeyVersion(version) {
+		var secp *asymmetric.PrivateKey
+		if secp, err = DecodePrivateKey(keyBytes, masterKey); err != nil {
+			return
+		}
+		return secp, nil
+	}
+
+	var seed []byte
+	if version == PrivateKeyStoreEd25519GCMVersion {
+		seed, err = openWithPassphrase(encData, masterKey)
+	} else {
+		seed, err = symmetric.DecryptWithPassword(encData, masterKey, privateKDFSalt)
+	}
+	if err != nil {
+		return
+	}
+	defer zeroBytes(seed)
+	if len(seed) != ed25519.SeedSize {
+		return nil, ErrNotKeyFile
+	}
+	return asymmetric.Ed25519PrivKeyFromSeed(seed)
+}
+
+// LoadTypedPrivateKey loads private key of any key type from keyFilePath like
+// LoadPrivateKey.
+func LoadTypedPrivateKey(keyFilePath string, masterKey []byte) (key asymmetric.TypedPrivateKey, err error) {
+	err = loadPrivateKeyFile(keyFilePath, func(content []byte) (decodeErr error) {
+		key, decodeErr = DecodeTypedPrivateKey(content, masterKey)
+		return
+	})
+	return
+}
+
+// SaveTypedPrivateKey saves private key of any key type to keyFilePath like
+// SavePrivateKey.
+func SaveTypedPrivateKey(keyFilePath string, key asymmetric.TypedPrivateKey, masterKey []byte) (err error) {
+	var keyBytes []byte
+	if keyBytes, err = EncodeTypedPrivateKey(key, masterKey); err != nil {
+		return
+	}
+	return os.WriteFile(keyFilePath, keyBytes, 0600)
+}
+
+// SetLocalTypedKeyPair sets local private key of any key type, this is a one time thing.
+// Secp256k1 keys are set as SetLocalKeyPair does, other key types are only
+// accessible by GetLocalTypedPrivateKey and GetLocalTypedPublicKey.
+func SetLocalTypedKeyPair(private asymmetric.TypedPrivateKey) {
+	if private == nil {
+		return
+	}
+	if secp, ok := private.(*asymmetric.PrivateKey); ok {
+		SetLocalKeyPair(secp, secp.PubKey())
+		return
+	}
+	localKey.Lock()
+	defer localKey.Unlock()
+	if localKey.isSet {
+		return
+	}
+	localKey.isSet = true
+	localKey.typedPrivate = private
+}
+
+// GetLocalTypedPrivateKey gets local private key of any key type.
+func GetLocalTypedPrivateKey() (private asymmetric.TypedPrivateKey, err error) {
+	localKey.RLock()
+	typed, shutdown := localKey.typedPrivate, localKey.shutdown
+	localKey.RUnlock()
+	if shutdown {
+		return nil, ErrKeyStoreShutdown
+	}
+	if typed != nil {
+		audit(AuditGetPrivateKey, loadLocalKeyPair().nodeID)
+		return typed, nil
+	}
+
+	var secp *asymmetric.PrivateKey
+	if secp, err = GetLocalPrivateKey(); err != nil {
+		return
+	}
+	return secp, nil
+}
+
+// GetLocalTypedPublicKey gets local public key of any key type.
+func GetLocalTypedPublicKey() (public asymmetric.TypedPublicKey, err error) {
+	localKey.RLock()
+	typed := localKey.typedPrivate
+	localKey.RUnlock()
+	if typed != nil {
+		return typed.TypedPubKey(), nil
+	}
+
+	var secp *asymmetric.PublicKey
+	if secp, err = GetLocalPublicKey(); err != nil {
+		return
+	}
+	return secp, nil
+}
+
+// GetLocalKeyType gets the key type of local private key, it is read from the
+// public key so it is not audited like GetLocalPrivateKey.
+func GetLocalKeyType() (keyType asymmetric.KeyType, err error) {
+	var public asymmetric.TypedPublicKey
+	if public, err = GetLocalTypedPublicKey(); err != nil {
+		return
+	}
+	keyType = public.KeyType()
+	return
+}
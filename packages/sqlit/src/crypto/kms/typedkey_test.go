@@ -0,0 +1,131 @@
+
+package kms
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	. "github.com/smartystreets/goconvey/convey"
+
+	"sqlit/src/crypto/asymmetric"
+	"sqlit/src/proto"
+	"sqlit/src/utils"
+)
+
+func TestTypedKey(t *testing.T) {
+	Convey("local ed25519 key", t, func() {
+		ResetLocalKeyStore()
+		defer ResetLocalKeyStore()
+		_, err := GetLocalTypedPrivateKey()
+		So(err, ShouldEqual, ErrNilField)
+
+		privKey, pubKey, _ := asymmetric.GenEd25519KeyPair()
+		SetLocalTypedKeyPair(privKey)
+		gotPrivate, err := GetLocalTypedPrivateKey()
+		So(err, ShouldBeNil)
+		So(gotPrivate.KeyType(), ShouldEqual, asymmetric.Ed25519)
+		gotPublic, err := GetLocalTypedPublicKey()
+		So(err, ShouldBeNil)
+		So(gotPublic.(asymmetric.Ed25519PublicKey).IsEqual(pubKey), ShouldBeTrue)
+		keyType, err := GetLocalKeyType()
+		So(err, ShouldBeNil)
+		So(keyType, ShouldEqual, asymmetric.Ed25519)
+		// secp256k1 accessors are not available for ed25519 identity
+		_, err = GetLocalPrivateKey()
+		So(err, ShouldEqual, ErrNilField)
+	})
+	Convey("local secp256k1 key", t, func() {
+		ResetLocalKeyStore()
+		defer ResetLocalKeyStore()
+		privKey, pubKey, _ := asymmetric.GenSecp256k1KeyPair()
+		SetLocalTypedKeyPair(privKey)
+		gotPrivate, err := GetLocalPrivateKey()
+		So(err, ShouldBeNil)
+		So(gotPrivate, ShouldEqual, privKey)
+		gotPublic, err := GetLocalTypedPublicKey()
+		So(err, ShouldBeNil)
+		So(gotPublic.(*asymmetric.PublicKey).IsEqual(pubKey), ShouldBeTrue)
+	})
+	Convey("ed25519 key file", t, func() {
+		ResetLocalKeyStore()
+		defer ResetLocalKeyStore()
+		var (
+			dir        = t.TempDir()
+			keyFile    = filepath.Join(dir, "ed25519.key")
+			masterKey  = []byte("master")
+			passphrase = []byte("secret")
+		)
+		privKey, pubKey, _ := asymmetric.GenEd25519KeyPair()
+		So(SaveTypedPrivateKey(keyFile, privKey, masterKey), ShouldBeNil)
+		loaded, err := LoadTypedPrivateKey(keyFile, masterKey)
+		So(err, ShouldBeNil)
+		So(loaded.KeyType(), ShouldEqual, asymmetric.Ed25519)
+		So(asymmetric.TypedPublicKeyEqual(loaded.TypedPubKey(), pubKey), ShouldBeTrue)
+		_, err = LoadTypedPrivateKey(keyFile, []byte("wrong"))
+		So(err, ShouldNotBeNil)
+		// the secp256k1 only loader refuses it
+		_, err = LoadPrivateKey(keyFile, masterKey)
+		So(err, ShouldEqual, ErrNotSecp256k1Key)
+
+		keyBytes, err := EncodeTypedPrivateKeyWithPassphrase(privKey, passphrase)
+		So(err, ShouldBeNil)
+		decoded, err := DecodeTypedPrivateKey(keyBytes, passphrase)
+		So(err, ShouldBeNil)
+		So(asymmetric.TypedPublicKeyEqual(decoded.TypedPubKey(), pubKey), ShouldBeTrue)
+		_, err = DecodeTypedPrivateKey(keyBytes, []byte("wrong"))
+		So(err, ShouldEqual, ErrWrongPassphrase)
+
+		// the local key is loaded in its key type
+		So(InitLocalKeyPair(keyFile, masterKey), ShouldBeNil)
+		keyType, err := GetLocalKeyType()
+		So(err, ShouldBeNil)
+		So(keyType, ShouldEqual, asymmetric.Ed25519)
+		gotPublic, err := GetLocalTypedPublicKey()
+		So(err, ShouldBeNil)
+		So(asymmetric.TypedPublicKeyEqual(gotPublic, pubKey), ShouldBeTrue)
+	})
+	Convey("secp256k1 key file loads typed", t, func() {
+		keyFile := filepath.Join(t.TempDir(), "secp.key")
+		privKey, pubKey, _ := asymmetric.GenSecp256k1KeyPair()
+		So(SavePrivateKey(keyFile, privKey, nil), ShouldBeNil)
+		loaded, err := LoadTypedPrivateKey(keyFile, nil)
+		So(err, ShouldBeNil)
+		So(loaded.KeyType(), ShouldEqual, asymmetric.Secp256k1)
+		So(asymmetric.TypedPublicKeyEqual(loaded.TypedPubKey(), pubKey), ShouldBeTrue)
+		_, err = LoadTypedPrivateKey(keyFile+".missing", nil)
+		So(err, ShouldNotBeNil)
+	})
+	Convey("mixed key type nodes in keystore", t, func() {
+		ClosePublicKeyStore()
+		utils.RemoveAll(dbFile + "*")
+		defer utils.RemoveAll(dbFile + "*")
+		defer ClosePublicKeyStore()
+		So(InitPublicKeyStore(dbFile, nil), ShouldBeNil)
+
+		_, secpPublic, _ := asymmetric.GenSecp256k1KeyPair()
+		_, edPublic, _ := asymmetric.GenEd25519KeyPair()
+		for _, node := range []*proto.Node{
+			{PublicKey: secpPublic},
+			{KeyType: asymmetric.Ed25519, Ed25519PublicKey: edPublic},
+		} {
+			key, err := node.TypedPublicKey()
+			So(err, ShouldBeNil)
+			nonce := asymmetric.GetTypedPubKeyNonce(key, 1, 50*time.Millisecond, nil)
+			node.ID = proto.NodeID(nonce.Hash.String())
+			node.Nonce = nonce.Nonce
+			So(SetNode(node), ShouldBeNil)
+
+			info, err := GetNodeInfo(node.ID)
+			So(err, ShouldBeNil)
+			So(info.KeyType, ShouldEqual, node.KeyType)
+			infoKey, err := info.TypedPublicKey()
+			So(err, ShouldBeNil)
+			So(infoKey.Serialize(), ShouldResemble, key.Serialize())
+
+			node.Nonce.A++
+			So(errors.Cause(SetNode(node)), ShouldEqual, ErrNodeIDKeyNonceNotMatch)
+		}
+	})
+}
@@ -15,6 +15,13 @@ type MarshalHasher interface {
 	MarshalHash() ([]byte, error)
 }
 
+// Signer is the interface implemented by an object that can sign a hash without exposing the
+// private key, e.g. a key held by an HSM.
+type Signer interface {
+	PublicKey() (*ca.PublicKey, error)
+	Sign(hash []byte) (*ca.Signature, error)
+}
+
 // HashSignVerifier is the interface implemented by an object that contains a hash value of an
 // MarshalHasher, can be signed by a private key and verified later.
 type HashSignVerifier interface {
@@ -58,6 +65,28 @@ func (i *DefaultHashSignVerifierImpl) SignHash(signer *ca.PrivateKey) (err error
 	return
 }
 
+// SignHashWith signs the hash with signer.
+func (i *DefaultHashSignVerifierImpl) SignHashWith(signer Signer) (err error) {
+	var signee *ca.PublicKey
+	if signee, err = signer.PublicKey(); err != nil {
+		return
+	}
+	if i.Signature, err = signer.Sign(i.DataHash[:]); err != nil {
+		return
+	}
+	i.Signee = signee
+	return
+}
+
+// SignWith sets hash of mh and signs it with signer.
+func (i *DefaultHashSignVerifierImpl) SignWith(mh MarshalHasher, signer Signer) (err error) {
+	if err = i.SetHash(mh); err != nil {
+		return
+	}
+	err = i.SignHashWith(signer)
+	return
+}
+
 // Sign implements HashSignVerifier.Sign.
 func (i *DefaultHashSignVerifierImpl) Sign(mh MarshalHasher, signer *ca.PrivateKey) (err error) {
 	// Set hash
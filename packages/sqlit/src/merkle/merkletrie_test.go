@@ -106,3 +106,17 @@ func buildMerkleTreeStore(hashes []*hash.Hash) []*hash.Hash {
 
 	return merkles
 }
+
+func TestHashMerkleTree(t *testing.T) {
+	Convey("hash.MerkleTree root should be the same", t, func() {
+		for n := 0; n <= 9; n++ {
+			leaves := make([]hash.Hash, n)
+			items := make([]*hash.Hash, n)
+			for i := range leaves {
+				rand.Read(leaves[i][:])
+				items[i] = &leaves[i]
+			}
+			So(hash.NewMerkleTree(leaves).Root(), ShouldResemble, *NewMerkle(items).GetRoot())
+		}
+	})
+}
@@ -0,0 +1,29 @@
+
+package metric
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+
+	"sqlit/src/utils/log"
+)
+
+// logCollectors returns the collectors exporting the counts of the error and warning
+// records logged by the node.
+func logCollectors() []prometheus.Collector {
+	newCounter := func(name, help string, count func(c log.LevelCounts) uint64) prometheus.Collector {
+		return prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "log",
+			Name:      name,
+			Help:      help,
+		}, func() float64 {
+			return float64(count(log.Counts()))
+		})
+	}
+	return []prometheus.Collector{
+		newCounter("errors_total", "Records logged at the error level or above.",
+			func(c log.LevelCounts) uint64 { return c.Errors }),
+		newCounter("warnings_total", "Records logged at the warning level.",
+			func(c log.LevelCounts) uint64 { return c.Warnings }),
+	}
+}
@@ -0,0 +1,36 @@
+
+package metric
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	. "github.com/smartystreets/goconvey/convey"
+
+	"sqlit/src/utils/log"
+)
+
+func TestLogCollectors(t *testing.T) {
+	Convey("log record counts are exported", t, func() {
+		log.ResetCounts()
+		defer log.ResetCounts()
+		log.Error("counted error")
+		log.Warning("counted warning")
+		log.Warning("counted warning")
+
+		reg := prometheus.NewRegistry()
+		for _, c := range logCollectors() {
+			So(reg.Register(c), ShouldBeNil)
+		}
+		mfs, err := reg.Gather()
+		So(err, ShouldBeNil)
+		values := make(map[string]float64)
+		for _, mf := range mfs {
+			values[mf.GetName()] = mf.GetMetric()[0].GetCounter().GetValue()
+		}
+		So(values, ShouldResemble, map[string]float64{
+			"node_log_errors_total":   1,
+			"node_log_warnings_total": 2,
+		})
+	})
+}
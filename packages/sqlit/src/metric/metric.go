@@ -46,6 +46,13 @@ func StartMetricCollector() (registry *prometheus.Registry) {
 		return nil
 	}
 
+	for _, c := range routeCollectors() {
+		if err = registry.Register(c); err != nil {
+			log.WithError(err).Error("couldn't register route collector")
+			return nil
+		}
+	}
+
 	log.Info("enabled collectors:")
 	var collectors []string
 	for n := range nc.Collectors {
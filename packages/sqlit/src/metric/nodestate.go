@@ -0,0 +1,82 @@
+
+package metric
+
+import (
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"sqlit/src/conf"
+	"sqlit/src/crypto/kms"
+)
+
+var (
+	knownNodesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "known_nodes"),
+		"Nodes in the KnownNodes of the config.",
+		nil, nil,
+	)
+	peersTermDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "peers", "term"),
+		"Term of the local peers.",
+		nil, nil,
+	)
+	peersLeaderDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "peers", "leader"),
+		"Constant 1 labeled by the leader of the local peers.",
+		[]string{"leader"}, nil,
+	)
+	keystoreNodesDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "keystore", "nodes"),
+		"Nodes in the public keystore.",
+		nil, nil,
+	)
+)
+
+// nodeStateCollector exports the state of the local node read on each scrape, a
+// state not initialized yet is left out.
+type nodeStateCollector struct{}
+
+// Describe implements the prometheus.Collector interface.
+func (nodeStateCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- knownNodesDesc
+	ch <- peersTermDesc
+	ch <- peersLeaderDesc
+	ch <- keystoreNodesDesc
+}
+
+// Collect implements the prometheus.Collector interface.
+func (nodeStateCollector) Collect(ch chan<- prometheus.Metric) {
+	if c := conf.GConf; c != nil {
+		ch <- prometheus.MustNewConstMetric(knownNodesDesc, prometheus.GaugeValue, float64(len(c.KnownNodes)))
+	}
+	if peers, err := kms.GetLocalPeers(); err == nil {
+		ch <- prometheus.MustNewConstMetric(peersTermDesc, prometheus.GaugeValue, float64(peers.Term))
+		ch <- prometheus.MustNewConstMetric(peersLeaderDesc, prometheus.GaugeValue, 1, string(peers.Leader))
+	}
+	if nodeIDs, err := kms.GetAllNodeID(); err == nil {
+		ch <- prometheus.MustNewConstMetric(keystoreNodesDesc, prometheus.GaugeValue, float64(len(nodeIDs)))
+	}
+}
+
+// NewNodeStateRegistry returns a registry of the node state, the route cache
+// counters, the node latencies and the log record counts.
+func NewNodeStateRegistry() (registry *prometheus.Registry, err error) {
+	registry = prometheus.NewRegistry()
+	if err = registry.Register(nodeStateCollector{}); err != nil {
+		return nil, err
+	}
+	for _, c := range append(routeCollectors(), logCollectors()...) {
+		if err = registry.Register(c); err != nil {
+			return nil, err
+		}
+	}
+	return
+}
+
+// NewMetricsHandler returns the /metrics handler exporting the registry in the
+// prometheus text format.
+func NewMetricsHandler(registry *prometheus.Registry) http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,81 @@
+
+package metric
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"sqlit/src/conf"
+	"sqlit/src/crypto/kms"
+	"sqlit/src/proto"
+)
+
+func TestNodeStateRegistry(t *testing.T) {
+	Convey("node state is exported on scrape", t, func() {
+		defer func(saved *conf.Config) { conf.GConf = saved }(conf.GConf)
+		defer kms.SetLocalPeers(nil)
+		defer kms.ClosePublicKeyStore()
+		defer func(saved *conf.BPInfo) { kms.BP = saved }(kms.BP)
+		conf.GConf = nil
+		kms.SetLocalPeers(nil)
+		kms.ClosePublicKeyStore()
+
+		registry, err := NewNodeStateRegistry()
+		So(err, ShouldBeNil)
+		gather := func() (values map[string]float64, leader string) {
+			mfs, err := registry.Gather()
+			So(err, ShouldBeNil)
+			values = make(map[string]float64)
+			for _, mf := range mfs {
+				for _, m := range mf.GetMetric() {
+					if g := m.GetGauge(); g != nil {
+						values[mf.GetName()] = g.GetValue()
+					}
+					for _, l := range m.GetLabel() {
+						if l.GetName() == "leader" {
+							leader = l.GetValue()
+						}
+					}
+				}
+			}
+			return
+		}
+
+		// a state not initialized is left out
+		values, _ := gather()
+		So(values, ShouldBeEmpty)
+
+		leader := proto.NodeID("0000000000000000000000000000000000000000000000000000000000000031")
+		conf.GConf = &conf.Config{
+			BP:         &conf.BPInfo{NodeID: leader},
+			KnownNodes: []proto.Node{{ID: leader}, {ID: "32"}},
+		}
+		kms.SetLocalPeers(&proto.Peers{PeersHeader: proto.PeersHeader{
+			Term: 5, Leader: leader, Servers: []proto.NodeID{leader},
+		}})
+		So(kms.InitPublicKeyStore(filepath.Join(t.TempDir(), "public.keystore"), nil), ShouldBeNil)
+		nodeIDs, err := kms.GetAllNodeID()
+		So(err, ShouldBeNil)
+
+		values, gotLeader := gather()
+		So(values, ShouldResemble, map[string]float64{
+			"node_known_nodes":    2,
+			"node_peers_term":     5,
+			"node_peers_leader":   1,
+			"node_keystore_nodes": float64(len(nodeIDs)),
+		})
+		So(gotLeader, ShouldEqual, string(leader))
+
+		rec := httptest.NewRecorder()
+		NewMetricsHandler(registry).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+		So(rec.Code, ShouldEqual, http.StatusOK)
+		body := rec.Body.String()
+		So(body, ShouldContainSubstring, `node_peers_leader{leader="`+string(leader)+`"} 1`)
+		So(strings.Contains(body, "node_route_cache_hits_total"), ShouldBeTrue)
+	})
+}
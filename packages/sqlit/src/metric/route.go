@@ -0,0 +1,75 @@
+
+package metric
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"sqlit/src/route"
+)
+
+var nodeLatencyDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(namespace, "route", "latency_seconds"),
+	"Moving average round-trip latency of the calls to the node.",
+	[]string{"node"}, nil,
+)
+
+// nodeLatencyCollector exports the route latency estimates read on each scrape.
+type nodeLatencyCollector struct{}
+
+// Describe implements the prometheus.Collector interface.
+func (nodeLatencyCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- nodeLatencyDesc
+}
+
+// Collect implements the prometheus.Collector interface.
+func (nodeLatencyCollector) Collect(ch chan<- prometheus.Metric) {
+	for id, d := range route.LatencyEstimates() {
+		ch <- prometheus.MustNewConstMetric(nodeLatencyDesc, prometheus.GaugeValue, d.Seconds(), string(id))
+	}
+}
+
+var nodeBanDesc = prometheus.NewDesc(
+	prometheus.BuildFQName(namespace, "route", "ban_remaining_seconds"),
+	"Time left of the route ban of the node.",
+	[]string{"node"}, nil,
+)
+
+// nodeBanCollector exports the active route bans read on each scrape.
+type nodeBanCollector struct{}
+
+// Describe implements the prometheus.Collector interface.
+func (nodeBanCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- nodeBanDesc
+}
+
+// Collect implements the prometheus.Collector interface.
+func (nodeBanCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, b := range route.Bans() {
+		ch <- prometheus.MustNewConstMetric(nodeBanDesc, prometheus.GaugeValue,
+			time.Until(b.Until).Seconds(), string(b.ID))
+	}
+}
+
+// routeCollectors returns the collectors exporting the route address cache counters,
+// the node latency estimates and the node bans.
+func routeCollectors() []prometheus.Collector {
+	newCounter := func(name, help string, c route.Counter) prometheus.Collector {
+		return prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "route_cache",
+			Name:      name,
+			Help:      help,
+		}, func() float64 {
+			return float64(c.Value())
+		})
+	}
+	return []prometheus.Collector{
+		newCounter("hits_total", "Node address cache reads of fresh entries.", route.CacheHits),
+		newCounter("misses_total", "Node address cache reads of unknown or stale entries.", route.CacheMisses),
+		newCounter("evictions_total", "Node address cache entries swept or replaced.", route.Evictions),
+		nodeLatencyCollector{},
+		nodeBanCollector{},
+	}
+}
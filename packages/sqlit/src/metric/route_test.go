@@ -0,0 +1,51 @@
+
+package metric
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	. "github.com/smartystreets/goconvey/convey"
+
+	"sqlit/src/proto"
+	"sqlit/src/route"
+)
+
+func TestRouteCollectors(t *testing.T) {
+	Convey("route cache counters and latencies are exported", t, func() {
+		node := proto.NodeID("00000000000000000000000000000000000000000000000000000000000000f1")
+		route.RecordLatency(node, 20*time.Millisecond)
+		So(route.Ban(node, time.Hour), ShouldBeNil)
+		defer route.Unban(node)
+		reg := prometheus.NewRegistry()
+		for _, c := range routeCollectors() {
+			So(reg.Register(c), ShouldBeNil)
+		}
+		mfs, err := reg.Gather()
+		So(err, ShouldBeNil)
+		var names []string
+		for _, mf := range mfs {
+			names = append(names, mf.GetName())
+		}
+		So(names, ShouldResemble, []string{
+			"node_route_ban_remaining_seconds",
+			"node_route_cache_evictions_total",
+			"node_route_cache_hits_total",
+			"node_route_cache_misses_total",
+			"node_route_latency_seconds",
+		})
+		for _, mf := range mfs {
+			if mf.GetName() == "node_route_latency_seconds" {
+				So(mf.GetMetric(), ShouldHaveLength, 1)
+				So(mf.GetMetric()[0].GetLabel()[0].GetValue(), ShouldEqual, string(node))
+				So(mf.GetMetric()[0].GetGauge().GetValue(), ShouldEqual, 0.02)
+			}
+			if mf.GetName() == "node_route_ban_remaining_seconds" {
+				So(mf.GetMetric(), ShouldHaveLength, 1)
+				So(mf.GetMetric()[0].GetLabel()[0].GetValue(), ShouldEqual, string(node))
+				So(mf.GetMetric()[0].GetGauge().GetValue(), ShouldBeBetween, 3500, 3600)
+			}
+		}
+	})
+}
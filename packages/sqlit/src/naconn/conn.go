@@ -3,6 +3,7 @@ package naconn
 
 import (
 	"bytes"
+	"context"
 	"net"
 
 	"github.com/pkg/errors"
@@ -13,6 +14,8 @@ import (
 	"sqlit/src/crypto/kms"
 	"sqlit/src/pow/cpuminer"
 	"sqlit/src/proto"
+	"sqlit/src/route"
+	"sqlit/src/utils/log"
 )
 
 const (
@@ -146,6 +149,23 @@ func Accept(conn net.Conn) (*NAConn, error) {
 	return naconn, nil
 }
 
+// dialNodeAddr connects to addr, a host name is dialed by the IPs cached by route in
+// order until one is connected.
+func dialNodeAddr(addr string) (conn net.Conn, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), conf.TCPDialTimeout)
+	ipAddrs, err := route.ResolveHostAddrs(ctx, addr)
+	cancel()
+	if err != nil {
+		return
+	}
+	for _, ipAddr := range ipAddrs {
+		if conn, err = net.DialTimeout("tcp", ipAddr, conf.TCPDialTimeout); err == nil {
+			return
+		}
+	}
+	return
+}
+
 // Dial connects to the node with remote node id.
 func Dial(remote proto.NodeID) (conn net.Conn, err error) {
 	return DialEx(remote, false)
@@ -174,14 +194,27 @@ func DialEx(remote proto.NodeID, isAnonymous bool) (conn net.Conn, err error) {
 		return
 	}
 
-	nodeAddr, err := defaultResolver.Resolve(rawNodeID)
+	nodeAddrs, err := resolveAll(defaultResolver, rawNodeID)
+	if err == nil && len(nodeAddrs) == 0 {
+		err = ErrNoNodeAddr
+	}
 	if err != nil {
 		err = errors.Wrapf(err, "resolve %s failed", rawNodeID.String())
 		return
 	}
 
-	cipher := etls.NewCipher(symmetricKey)
-	iconn, err := net.DialTimeout("tcp", nodeAddr, conf.TCPDialTimeout)
+	// try the address candidates in order until one is connected
+	var (
+		cipher   = etls.NewCipher(symmetricKey)
+		iconn    net.Conn
+		nodeAddr string
+	)
+	for _, nodeAddr = range nodeAddrs {
+		if iconn, err = dialNodeAddr(nodeAddr); err == nil {
+			break
+		}
+		log.WithField("addr", nodeAddr).WithError(err).Debug("connect to node address failed")
+	}
 	if err != nil {
 		err = errors.Wrapf(err, "connect to node %s failed", nodeAddr)
 		return
@@ -54,6 +54,18 @@ func (r *simpleResolver) ResolveEx(id *proto.RawNodeID) (*proto.Node, error) {
 	return nil, fmt.Errorf("not found")
 }
 
+type multiAddrResolver struct {
+	simpleResolver
+}
+
+func (r *multiAddrResolver) ResolveAll(id *proto.RawNodeID) (addrs []string, err error) {
+	var node *proto.Node
+	if node, err = r.ResolveEx(id); err != nil {
+		return
+	}
+	return node.AddrCandidates(), nil
+}
+
 func TestNAConn(t *testing.T) {
 	Convey("Test dial timeout", t, func(c C) {
 		// Register node with invalid IP address
@@ -75,6 +87,50 @@ func TestNAConn(t *testing.T) {
 		So(ok, ShouldBeTrue)
 		So(nerr.Timeout(), ShouldBeTrue)
 	})
+	Convey("Test dial alternate address", t, func(c C) {
+		l, err := net.Listen("tcp", "localhost:0")
+		So(err, ShouldBeNil)
+		defer func() { _ = l.Close() }()
+		// closed port as the primary address
+		closed, err := net.Listen("tcp", "localhost:0")
+		So(err, ShouldBeNil)
+		closedAddr := closed.Addr().String()
+		So(closed.Close(), ShouldBeNil)
+
+		resolver := &multiAddrResolver{}
+		nodeinfo := thisNode()
+		So(nodeinfo, ShouldNotBeNil)
+		resolver.registerNode(&proto.Node{
+			Addr:      closedAddr,
+			Addrs:     []string{l.Addr().String()},
+			ID:        nodeinfo.ID,
+			PublicKey: nodeinfo.PublicKey,
+			Nonce:     nodeinfo.Nonce,
+		})
+		RegisterResolver(resolver)
+		defer RegisterResolver(&resolver.simpleResolver)
+
+		wg := &sync.WaitGroup{}
+		wg.Add(1)
+		go func(c C) {
+			defer wg.Done()
+			conn, err := l.Accept()
+			c.So(err, ShouldBeNil)
+			naconn, err := Accept(conn)
+			c.So(err, ShouldBeNil)
+			_ = naconn.Close()
+		}(c)
+		conn, err := Dial(nodeinfo.ID)
+		So(err, ShouldBeNil)
+		So(conn.RemoteAddr().String(), ShouldEqual, l.Addr().String())
+		_ = conn.Close()
+		wg.Wait()
+
+		// no address to dial
+		resolver.registerNode(&proto.Node{ID: nodeinfo.ID})
+		_, err = Dial(nodeinfo.ID)
+		So(errors.Cause(err), ShouldEqual, ErrNoNodeAddr)
+	})
 	Convey("Test simple NAConn", t, func(c C) {
 		l, err := net.Listen("tcp", "localhost:0")
 		So(err, ShouldBeNil)
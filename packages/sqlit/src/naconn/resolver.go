@@ -1,7 +1,11 @@
 
 package naconn
 
-import "sqlit/src/proto"
+import (
+	"github.com/pkg/errors"
+
+	"sqlit/src/proto"
+)
 
 // Resolver defines the node ID resolver interface for node-oriented connection.
 type Resolver interface {
@@ -9,8 +13,29 @@ type Resolver interface {
 	ResolveEx(id *proto.RawNodeID) (*proto.Node, error)
 }
 
+// MultiAddrResolver is the optional interface of Resolver for multi-homed nodes,
+// ResolveAll returns the address candidates in priority order.
+type MultiAddrResolver interface {
+	ResolveAll(id *proto.RawNodeID) ([]string, error)
+}
+
+// resolveAll returns the address candidates of id by resolver.
+func resolveAll(resolver Resolver, id *proto.RawNodeID) ([]string, error) {
+	if r, ok := resolver.(MultiAddrResolver); ok {
+		return r.ResolveAll(id)
+	}
+	addr, err := resolver.Resolve(id)
+	if err != nil {
+		return nil, err
+	}
+	return []string{addr}, nil
+}
+
 var (
 	defaultResolver Resolver
+
+	// ErrNoNodeAddr indicates the resolver returns no address of the node.
+	ErrNoNodeAddr = errors.New("no node address")
 )
 
 // RegisterResolver registers the default resolver.
@@ -0,0 +1,115 @@
+
+package proto
+
+import (
+	"encoding/json"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+// Capabilities is the set of protocol features a node supports, the peers check it
+// before using a feature with the node. The bits unknown to this version are kept
+// as they are but never reported as supported.
+type Capabilities uint64
+
+const (
+	// CapTLS is the support of the encrypted node connections.
+	CapTLS Capabilities = 1 << iota
+	// CapEd25519 is the support of the ed25519 node identities and signatures.
+	CapEd25519
+	// CapMultiAddr is the support of the alternate addresses of Node.Addrs.
+	CapMultiAddr
+	// CapPeersV2 is the support of the peers lists with the key types of signatures.
+	CapPeersV2
+)
+
+// LocalCapabilities is the capabilities of this version of node, a node advertises
+// them when it registers to the block producers.
+const LocalCapabilities = CapTLS | CapEd25519 | CapMultiAddr | CapPeersV2
+
+// capabilityNames is the names of the known capabilities in the order of bits.
+var capabilityNames = []string{"tls", "ed25519", "multiaddr", "peersv2"}
+
+// unknownCapabilityPrefix is the name prefix of the unknown bits, e.g. "bit9".
+const unknownCapabilityPrefix = "bit"
+
+// Names returns the names of the capabilities in the order of bits, an unknown bit
+// is named by its index, e.g. "bit9".
+func (c Capabilities) Names() (names []string) {
+	for v := uint64(c); v != 0; v &= v - 1 {
+		i := bits.TrailingZeros64(v)
+		if i < len(capabilityNames) {
+			names = append(names, capabilityNames[i])
+		} else {
+			names = append(names, unknownCapabilityPrefix+strconv.Itoa(i))
+		}
+	}
+	return
+}
+
+// String returns the comma separated names of the capabilities.
+func (c Capabilities) String() string {
+	return strings.Join(c.Names(), ",")
+}
+
+// ParseCapabilities parses the names of Capabilities.Names, the names unknown to this
+// version are ignored, so the configs of newer nodes are still read.
+func ParseCapabilities(names []string) (c Capabilities) {
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if index, ok := strings.CutPrefix(name, unknownCapabilityPrefix); ok {
+			if i, err := strconv.Atoi(index); err == nil && i >= 0 && i < 64 {
+				c |= 1 << uint(i)
+			}
+			continue
+		}
+		for i, known := range capabilityNames {
+			if name == known {
+				c |= 1 << uint(i)
+				break
+			}
+		}
+	}
+	return
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (c Capabilities) MarshalJSON() ([]byte, error) {
+	names := c.Names()
+	if names == nil {
+		names = []string{}
+	}
+	return json.Marshal(names)
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+func (c *Capabilities) UnmarshalJSON(data []byte) (err error) {
+	var names []string
+	if err = json.Unmarshal(data, &names); err != nil {
+		return
+	}
+	*c = ParseCapabilities(names)
+	return
+}
+
+// MarshalYAML implements the yaml.Marshaler interface.
+func (c Capabilities) MarshalYAML() (interface{}, error) {
+	return c.Names(), nil
+}
+
+// UnmarshalYAML implements the yaml.Unmarshaler interface.
+func (c *Capabilities) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var names []string
+	if err := unmarshal(&names); err != nil {
+		return err
+	}
+	*c = ParseCapabilities(names)
+	return nil
+}
+
+// Supports returns if the node advertises all the capabilities of c, a node without
+// capabilities, e.g. of an older version, supports none of them.
+func (node *Node) Supports(c Capabilities) bool {
+	return node != nil && c != 0 && c&LocalCapabilities == c && node.Capabilities&c == c
+}
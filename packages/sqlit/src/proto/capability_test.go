@@ -0,0 +1,70 @@
+
+package proto
+
+import (
+	"encoding/json"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	yaml "gopkg.in/yaml.v2"
+
+	"sqlit/src/utils"
+)
+
+func TestCapabilities(t *testing.T) {
+	Convey("the capabilities are named", t, func() {
+		So(capabilityNames, ShouldHaveLength, bitsOf(LocalCapabilities))
+		So(Capabilities(0).Names(), ShouldBeNil)
+		So((CapTLS | CapPeersV2).String(), ShouldEqual, "tls,peersv2")
+		So((CapEd25519 | 1<<9).Names(), ShouldResemble, []string{"ed25519", "bit9"})
+		So(ParseCapabilities([]string{" TLS", "multiaddr", "bit9"}), ShouldEqual, CapTLS|CapMultiAddr|1<<9)
+		// the names of newer versions are ignored
+		So(ParseCapabilities([]string{"teleport", "bit64", "bitx", "ed25519"}), ShouldEqual, CapEd25519)
+		So(ParseCapabilities(LocalCapabilities.Names()), ShouldEqual, LocalCapabilities)
+	})
+	Convey("the nodes support the advertised known capabilities", t, func() {
+		var nilNode *Node
+		So(nilNode.Supports(CapTLS), ShouldBeFalse)
+		old := &Node{}
+		So(old.Supports(CapPeersV2), ShouldBeFalse)
+		node := &Node{Capabilities: CapTLS | CapPeersV2 | 1<<50}
+		So(node.Supports(CapTLS), ShouldBeTrue)
+		So(node.Supports(CapTLS|CapPeersV2), ShouldBeTrue)
+		So(node.Supports(CapTLS|CapEd25519), ShouldBeFalse)
+		So(node.Supports(0), ShouldBeFalse)
+		So(node.Supports(1<<50), ShouldBeFalse)
+	})
+	Convey("the unknown capabilities are kept by the encodings", t, func() {
+		node := Node{ID: "0000000000000000000000000000000000000000000000000000000000000001",
+			Capabilities: CapMultiAddr | 1<<63}
+
+		out, err := json.Marshal(node.Capabilities)
+		So(err, ShouldBeNil)
+		So(string(out), ShouldEqual, `["multiaddr","bit63"]`)
+		var fromJSON Capabilities
+		So(json.Unmarshal(out, &fromJSON), ShouldBeNil)
+		So(fromJSON, ShouldEqual, node.Capabilities)
+		out, err = json.Marshal(Capabilities(0))
+		So(err, ShouldBeNil)
+		So(string(out), ShouldEqual, `[]`)
+
+		out, err = yaml.Marshal(&node)
+		So(err, ShouldBeNil)
+		var fromYAML Node
+		So(yaml.Unmarshal(out, &fromYAML), ShouldBeNil)
+		So(fromYAML.Capabilities, ShouldEqual, node.Capabilities)
+
+		buf, err := utils.EncodeMsgPack(&node)
+		So(err, ShouldBeNil)
+		var fromMsgPack Node
+		So(utils.DecodeMsgPack(buf.Bytes(), &fromMsgPack), ShouldBeNil)
+		So(fromMsgPack.Capabilities, ShouldEqual, node.Capabilities)
+	})
+}
+
+func bitsOf(c Capabilities) (n int) {
+	for ; c != 0; c &= c - 1 {
+		n++
+	}
+	return
+}
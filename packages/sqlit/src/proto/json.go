@@ -0,0 +1,267 @@
+package proto
+
+import (
+	"encoding/hex"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+
+	"sqlit/src/crypto/asymmetric"
+	"sqlit/src/crypto/hash"
+	"sqlit/src/crypto/verifier"
+	mine "sqlit/src/pow/cpuminer"
+)
+
+// The JSON form of Node, PeersHeader and Peers is canonical: fields are sorted by
+// name, binary members are hex encoded and empty members are omitted, so the same
+// value always marshals to the same bytes. It is for storing and diffing only,
+// signatures are always computed over the binary MarshalHash form.
+
+// hexBytes is []byte marshaled as a hex string.
+type hexBytes []byte
+
+// MarshalText implements encoding.TextMarshaler.
+func (b hexBytes) MarshalText() ([]byte, error) {
+	return []byte(hex.EncodeToString(b)), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (b *hexBytes) UnmarshalText(text []byte) (err error) {
+	var decoded []byte
+	if decoded, err = hex.DecodeString(string(text)); err != nil {
+		return
+	}
+	if len(decoded) == 0 {
+		decoded = nil
+	}
+	*b = decoded
+	return
+}
+
+// nodeJSON is the JSON form of Node, the fields must be kept sorted by name.
+type nodeJSON struct {
+	Addr             string            `json:"Addr,omitempty"`
+	Addrs            []string          `json:"Addrs,omitempty"`
+	Capabilities     Capabilities      `json:"Capabilities,omitempty"`
+	DirectAddr       string            `json:"DirectAddr,omitempty"`
+	Ed25519PublicKey hexBytes          `json:"Ed25519PublicKey,omitempty"`
+	ID               NodeID            `json:"ID"`
+	KeyType          string            `json:"KeyType"`
+	Nonce            hexBytes          `json:"Nonce"`
+	PublicKey        hexBytes          `json:"PublicKey,omitempty"`
+	Region           string            `json:"Region,omitempty"`
+	Role             string            `json:"Role"`
+	Tags             map[string]string `json:"Tags,omitempty"`
+	Weight           int               `json:"Weight,omitempty"`
+	Zone             string            `json:"Zone,omitempty"`
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (node Node) MarshalJSON() ([]byte, error) {
+	j := nodeJSON{
+		Addr:       node.Addr,
+		Addrs:      node.Addrs,
+		DirectAddr: node.DirectAddr,
+		ID:         node.ID,
+		KeyType:    node.KeyType.String(),
+		Nonce:      node.Nonce.Bytes(),
+		Region:     node.Region,
+		Role:       node.Role.String(),
+		Tags:       node.Tags,
+		Weight:     node.Weight,
+		Zone:       node.Zone,
+
+		Capabilities: node.Capabilities,
+	}
+	if node.PublicKey != nil {
+		j.PublicKey = node.PublicKey.Serialize()
+	}
+	if len(node.Ed25519PublicKey) != 0 {
+		j.Ed25519PublicKey = node.Ed25519PublicKey.Serialize()
+	}
+	return json.Marshal(&j)
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+func (node *Node) UnmarshalJSON(data []byte) (err error) {
+	var j nodeJSON
+	if err = json.Unmarshal(data, &j); err != nil {
+		return
+	}
+	var decoded = Node{
+		ID:         j.ID,
+		Addr:       j.Addr,
+		Addrs:      j.Addrs,
+		DirectAddr: j.DirectAddr,
+		Weight:     j.Weight,
+		Region:     j.Region,
+		Zone:       j.Zone,
+		Tags:       j.Tags,
+
+		Capabilities: j.Capabilities,
+	}
+	if decoded.Role, err = ParseServerRole(j.Role); err != nil {
+		return
+	}
+	var nonce *mine.Uint256
+	if nonce, err = mine.Uint256FromBytes(j.Nonce); err != nil {
+		return errors.Wrap(err, "decode node nonce failed")
+	}
+	decoded.Nonce = *nonce
+	if decoded.KeyType, err = asymmetric.ParseKeyType(j.KeyType); err != nil {
+		return
+	}
+	if j.PublicKey != nil {
+		if decoded.PublicKey, err = asymmetric.ParsePubKey(j.PublicKey); err != nil {
+			return errors.Wrap(err, "decode node public key failed")
+		}
+	}
+	if j.Ed25519PublicKey != nil {
+		if decoded.Ed25519PublicKey, err = asymmetric.ParseEd25519PubKey(j.Ed25519PublicKey); err != nil {
+			return errors.Wrap(err, "decode node ed25519 public key failed")
+		}
+	}
+	*node = decoded
+	return
+}
+
+// peersHeaderJSON is the JSON form of PeersHeader, the fields must be kept sorted by name.
+type peersHeaderJSON struct {
+	Leader  NodeID   `json:"Leader"`
+	Servers []NodeID `json:"Servers,omitempty"`
+	Term    uint64   `json:"Term"`
+	Version uint64   `json:"Version"`
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (ph PeersHeader) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&peersHeaderJSON{
+		Leader:  ph.Leader,
+		Servers: ph.Servers,
+		Term:    ph.Term,
+		Version: ph.Version,
+	})
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface.
+func (ph *PeersHeader) UnmarshalJSON(data []byte) (err error) {
+	var j peersHeaderJSON
+	if err = json.Unmarshal(data, &j); err != nil {
+		return
+	}
+	*ph = PeersHeader{
+		Version: j.Version,
+		Term:    j.Term,
+		Leader:  j.Leader,
+		Servers: j.Servers,
+	}
+	return
+}
+
+// peersJSON is the JSON form of Peers, the fields must be kept sorted by name.
+type peersJSON struct {
+	Approvals      []peersSignatureJSON `json:"Approvals,omitempty"`
+	DataHash       hash.Hash            `json:"DataHash"`
+	Header         PeersHeader          `json:"Header"`
+	Observers      []NodeID             `json:"Observers,omitempty"`
+	Signature      hexBytes             `json:"Signature,omitempty"`
+	Signatures     []peersSignatureJSON `json:"Signatures,omitempty"`
+	Signee         hexBytes             `json:"Signee,omitempty"`
+	SigneeKeyType  string               `json:"SigneeKeyType"`
+	TypedSignature hexBytes             `json:"TypedSignature,omitempty"`
+	TypedSignee    hexBytes             `json:"TypedSignee,omitempty"`
+}
+
+// peersSignatureJSON is the JSON form of PeersSignature, the fields must be kept
+// sorted by name.
+type peersSignatureJSON struct {
+	KeyType   string   `json:"KeyType"`
+	Node      NodeID   `json:"Node"`
+	Signature hexBytes `json:"Signature"`
+}
+
+// MarshalJSON implements the json.Marshaler interface.
+func (p Peers) MarshalJSON() ([]byte, error) {
+	j := peersJSON{
+		DataHash:       p.DataHash,
+		Header:         p.PeersHeader,
+		Observers:      p.Observers,
+		SigneeKeyType:  p.SigneeKeyType.String(),
+		TypedSignature: p.TypedSignature,
+		TypedSignee:    p.TypedSignee,
+	}
+	if p.Signature != nil {
+		j.Signature = p.Signature.Serialize()
+	}
+	if p.Signee != nil {
+		j.Signee = p.Signee.Serialize()
+	}
+	j.Signatures = peersSignaturesJSON(p.Signatures)
+	j.Approvals = peersSignaturesJSON(p.Approvals)
+	return json.Marshal(&j)
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface, the dirty state is not
+// encoded so the decoded peers is always verified by its signature.
+func (p *Peers) UnmarshalJSON(data []byte) (err error) {
+	var j peersJSON
+	if err = json.Unmarshal(data, &j); err != nil {
+		return
+	}
+	var decoded = Peers{
+		PeersHeader: j.Header,
+		DefaultHashSignVerifierImpl: verifier.DefaultHashSignVerifierImpl{
+			DataHash: j.DataHash,
+		},
+		TypedSignee:    j.TypedSignee,
+		TypedSignature: j.TypedSignature,
+		Observers:      j.Observers,
+	}
+	if decoded.SigneeKeyType, err = asymmetric.ParseKeyType(j.SigneeKeyType); err != nil {
+		return
+	}
+	if j.Signature != nil {
+		if decoded.Signature, err = asymmetric.ParseSignature(j.Signature); err != nil {
+			return errors.Wrap(err, "decode peers signature failed")
+		}
+	}
+	if j.Signee != nil {
+		if decoded.Signee, err = asymmetric.ParsePubKey(j.Signee); err != nil {
+			return errors.Wrap(err, "decode peers signee failed")
+		}
+	}
+	if decoded.Signatures, err = decodePeersSignaturesJSON(j.Signatures); err != nil {
+		return
+	}
+	if decoded.Approvals, err = decodePeersSignaturesJSON(j.Approvals); err != nil {
+		return
+	}
+	*p = decoded
+	return
+}
+
+func peersSignaturesJSON(sigs []PeersSignature) (j []peersSignatureJSON) {
+	for _, s := range sigs {
+		j = append(j, peersSignatureJSON{
+			KeyType:   s.KeyType.String(),
+			Node:      s.Node,
+			Signature: s.Signature,
+		})
+	}
+	return
+}
+
+func decodePeersSignaturesJSON(j []peersSignatureJSON) (sigs []PeersSignature, err error) {
+	for _, s := range j {
+		var keyType asymmetric.KeyType
+		if keyType, err = asymmetric.ParseKeyType(s.KeyType); err != nil {
+			return nil, errors.Wrapf(err, "decode peers signature of %s failed", s.Node)
+		}
+		sigs = append(sigs, PeersSignature{
+			Node:      s.Node,
+			KeyType:   keyType,
+			Signature: s.Signature,
+		})
+	}
+	return
+}
@@ -0,0 +1,183 @@
+
+package proto
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"sqlit/src/crypto/asymmetric"
+	mine "sqlit/src/pow/cpuminer"
+)
+
+// jsonKeys returns the top-level keys of a JSON object in encoded order.
+func jsonKeys(data []byte) (keys []string) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	_, _ = dec.Token()
+	for dec.More() {
+		key, _ := dec.Token()
+		keys = append(keys, key.(string))
+		var skipped json.RawMessage
+		_ = dec.Decode(&skipped)
+	}
+	return
+}
+
+func TestNodeJSON(t *testing.T) {
+	Convey("node json is canonical and round trips", t, func() {
+		_, secpPublic, err := asymmetric.GenSecp256k1KeyPair()
+		So(err, ShouldBeNil)
+		_, edPublic, err := asymmetric.GenEd25519KeyPair()
+		So(err, ShouldBeNil)
+		nodes := []*Node{
+			{},
+			{
+				ID:         "00000bef611d346c0cbe1beaa76e7f0ed705a194fdf9ac3a248ec70e9c198bf9",
+				Role:       Miner,
+				Addr:       "a:1",
+				Addrs:      []string{"b:1", "c:1"},
+				DirectAddr: "d:1",
+				PublicKey:  secpPublic,
+				Nonce:      mine.Uint256{A: 1, B: 2, C: 3, D: 4},
+				Weight:     10,
+				Region:     "eu-west",
+				Zone:       "eu-west-1a",
+				Tags:       map[string]string{"owner": "ops", "hardware": "nvme"},
+
+				Capabilities: CapTLS | CapMultiAddr | 1<<40,
+			},
+			{
+				ID:               "00000381d46fd6cf7742d7fb94e2422033af989c0e348b5781b3219599a3af35",
+				Role:             Observer,
+				Addr:             "a:1",
+				KeyType:          asymmetric.Ed25519,
+				Ed25519PublicKey: edPublic,
+			},
+		}
+		for _, node := range nodes {
+			out, err := json.Marshal(node)
+			So(err, ShouldBeNil)
+			keys := jsonKeys(out)
+			So(sort.StringsAreSorted(keys), ShouldBeTrue)
+
+			var decoded Node
+			So(json.Unmarshal(out, &decoded), ShouldBeNil)
+			So(decoded.ID, ShouldEqual, node.ID)
+			So(decoded.Role, ShouldEqual, node.Role)
+			So(decoded.Addrs, ShouldResemble, node.Addrs)
+			So(decoded.Weight, ShouldEqual, node.Weight)
+			So(decoded.Region, ShouldEqual, node.Region)
+			So(decoded.Zone, ShouldEqual, node.Zone)
+			So(decoded.Tags, ShouldResemble, node.Tags)
+			So(decoded.Capabilities, ShouldEqual, node.Capabilities)
+			So(decoded.Nonce, ShouldResemble, node.Nonce)
+			So(decoded.KeyType, ShouldEqual, node.KeyType)
+			if node.PublicKey != nil {
+				So(decoded.PublicKey.IsEqual(node.PublicKey), ShouldBeTrue)
+			} else {
+				So(decoded.PublicKey, ShouldBeNil)
+			}
+			So(decoded.Ed25519PublicKey.IsEqual(node.Ed25519PublicKey), ShouldBeTrue)
+
+			// value and pointer marshal the same, re-marshaling is byte identical
+			again, err := json.Marshal(decoded)
+			So(err, ShouldBeNil)
+			So(string(again), ShouldEqual, string(out))
+		}
+
+		out, err := json.Marshal(nodes[1])
+		So(err, ShouldBeNil)
+		So(string(out), ShouldContainSubstring, `"PublicKey":"`+hex.EncodeToString(secpPublic.Serialize())+`"`)
+		So(string(out), ShouldContainSubstring,
+			`"Nonce":"0000000000000001000000000000000200000000000000030000000000000004"`)
+		So(string(out), ShouldContainSubstring, `"Role":"Miner"`)
+
+		var decoded Node
+		So(json.Unmarshal([]byte(`{"Nonce":"00"}`), &decoded), ShouldNotBeNil)
+		So(json.Unmarshal([]byte(`{"PublicKey":"zz"}`), &decoded), ShouldNotBeNil)
+		So(json.Unmarshal([]byte(`{"KeyType":"rsa"}`), &decoded), ShouldNotBeNil)
+	})
+}
+
+func TestPeersJSON(t *testing.T) {
+	Convey("peers json is canonical, round trips and keeps the signature", t, func() {
+		privKey, _, err := asymmetric.GenSecp256k1KeyPair()
+		So(err, ShouldBeNil)
+		edPrivate, _, err := asymmetric.GenEd25519KeyPair()
+		So(err, ShouldBeNil)
+		var (
+			n1 = NodeID("00000bef611d346c0cbe1beaa76e7f0ed705a194fdf9ac3a248ec70e9c198bf9")
+			n2 = NodeID("00000381d46fd6cf7742d7fb94e2422033af989c0e348b5781b3219599a3af35")
+		)
+		secpPeers := &Peers{
+			PeersHeader: PeersHeader{
+				Term:    3,
+				Leader:  n1,
+				Servers: []NodeID{n1, n2},
+			},
+			Observers: []NodeID{"0000000000000000000000000000000000000000000000000000000000000001"},
+		}
+		So(secpPeers.Sign(privKey), ShouldBeNil)
+		edPeers := secpPeers.Clone()
+		So(edPeers.SignTyped(edPrivate), ShouldBeNil)
+		So(edPeers.AddSignature(n2, asymmetric.Ed25519, []byte{1, 2, 3}), ShouldBeNil)
+		approved := secpPeers.Clone()
+		So(approved.AddApproval(n2, asymmetric.Ed25519, []byte{1, 2, 3}), ShouldBeNil)
+		// the same signature as an approval or a threshold one never shares a hash
+		signed := secpPeers.Clone()
+		So(signed.AddSignature(n2, asymmetric.Ed25519, []byte{1, 2, 3}), ShouldBeNil)
+		approvedHash, err := approved.MarshalHash()
+		So(err, ShouldBeNil)
+		signedHash, err := signed.MarshalHash()
+		So(err, ShouldBeNil)
+		So(approvedHash, ShouldNotResemble, signedHash)
+
+		for _, p := range []*Peers{secpPeers, edPeers, approved, {}} {
+			hashBefore, err := p.MarshalHash()
+			So(err, ShouldBeNil)
+			out, err := json.Marshal(p)
+			So(err, ShouldBeNil)
+			So(sort.StringsAreSorted(jsonKeys(out)), ShouldBeTrue)
+
+			var header struct{ Header json.RawMessage }
+			So(json.Unmarshal(out, &header), ShouldBeNil)
+			So(sort.StringsAreSorted(jsonKeys(header.Header)), ShouldBeTrue)
+
+			var decoded *Peers
+			So(json.Unmarshal(out, &decoded), ShouldBeNil)
+			again, err := json.Marshal(decoded)
+			So(err, ShouldBeNil)
+			So(string(again), ShouldEqual, string(out))
+
+			// the signing form is untouched by json
+			hashAfter, err := decoded.MarshalHash()
+			So(err, ShouldBeNil)
+			So(hashAfter, ShouldResemble, hashBefore)
+			if p.Signee != nil || p.TypedSignee != nil {
+				So(decoded.Verify(), ShouldBeNil)
+			}
+		}
+
+		// header alone
+		out, err := json.Marshal(secpPeers.PeersHeader)
+		So(err, ShouldBeNil)
+		So(string(out), ShouldEqual, `{"Leader":"`+string(n1)+`","Servers":["`+string(n1)+`","`+string(n2)+
+			`"],"Term":3,"Version":0}`)
+		var header PeersHeader
+		So(json.Unmarshal(out, &header), ShouldBeNil)
+		So(header, ShouldResemble, secpPeers.PeersHeader)
+
+		// tampered json fails verification
+		out, err = json.Marshal(secpPeers)
+		So(err, ShouldBeNil)
+		var tampered Peers
+		So(json.Unmarshal(out, &tampered), ShouldBeNil)
+		tampered.Term++
+		So(tampered.Verify(), ShouldNotBeNil)
+		So(json.Unmarshal([]byte(`{"Signature":"00"}`), &tampered), ShouldNotBeNil)
+	})
+}
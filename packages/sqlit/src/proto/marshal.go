@@ -1,6 +1,7 @@
 package proto
 
 import (
+	"sqlit/src/crypto/asymmetric"
 	"sqlit/src/marshalhash"
 )
 
@@ -22,10 +23,50 @@ func (ph *PeersHeader) MarshalHash() ([]byte, error) {
 // Msgsize returns the estimated size for msgpack encoding
 func (ph *PeersHeader) Msgsize() int { return 256 }
 
+// peersV1Data is the content signed in PeersVersion1 layout.
+type peersV1Data struct {
+	*Peers
+}
+
+// MarshalHash marshals the PeersHeader and the observers for hash computation.
+func (d peersV1Data) MarshalHash() ([]byte, error) {
+	b := make([]byte, 0, 512)
+	b = marshalhash.AppendArrayHeader(b, 2)
+	// PeersHeader leads with the version, so the layouts never share a hash
+	hdrBytes, err := d.PeersHeader.MarshalHash()
+	if err != nil {
+		return nil, err
+	}
+	b = append(b, hdrBytes...)
+	// Observers array
+	b = marshalhash.AppendArrayHeader(b, uint32(len(d.Observers)))
+	for _, o := range d.Observers {
+		b = marshalhash.AppendString(b, string(o))
+	}
+	return b, nil
+}
+
 // MarshalHash marshals Peers for hash computation
 func (p *Peers) MarshalHash() ([]byte, error) {
 	b := make([]byte, 0, 512)
-	b = marshalhash.AppendArrayHeader(b, 2)
+	// typed signature fields are appended only for non default key types and the
+	// threshold signatures only if any, so the hash of secp256k1 signed peers is
+	// unchanged. The approvals follow the threshold signatures, which are then
+	// appended even if none, so the two never share a hash.
+	typed := p.SigneeKeyType != asymmetric.Secp256k1
+	approved := len(p.Approvals) > 0
+	signed := len(p.Signatures) > 0 || approved
+	fields := uint32(2)
+	if typed {
+		fields += 3
+	}
+	if signed {
+		fields++
+	}
+	if approved {
+		fields++
+	}
+	b = marshalhash.AppendArrayHeader(b, fields)
 	// PeersHeader
 	hdrBytes, err := p.PeersHeader.MarshalHash()
 	if err != nil {
@@ -38,8 +79,36 @@ func (p *Peers) MarshalHash() ([]byte, error) {
 		return nil, err
 	}
 	b = append(b, hsvBytes...)
+	if typed {
+		b = marshalhash.AppendByte(b, byte(p.SigneeKeyType))
+		b = marshalhash.AppendBytes(b, p.TypedSignee)
+		b = marshalhash.AppendBytes(b, p.TypedSignature)
+	}
+	if signed {
+		b = appendPeersSignatures(b, p.Signatures)
+	}
+	if approved {
+		b = appendPeersSignatures(b, p.Approvals)
+	}
 	return b, nil
 }
 
+// appendPeersSignatures appends sigs for hash computation.
+func appendPeersSignatures(b []byte, sigs []PeersSignature) []byte {
+	b = marshalhash.AppendArrayHeader(b, uint32(len(sigs)))
+	for _, s := range sigs {
+		// the key type is appended only for non default key types as above
+		if s.KeyType != asymmetric.Secp256k1 {
+			b = marshalhash.AppendArrayHeader(b, 3)
+			b = marshalhash.AppendByte(b, byte(s.KeyType))
+		} else {
+			b = marshalhash.AppendArrayHeader(b, 2)
+		}
+		b = marshalhash.AppendString(b, string(s.Node))
+		b = marshalhash.AppendBytes(b, s.Signature)
+	}
+	return b
+}
+
 // Msgsize returns the estimated size for msgpack encoding
 func (p *Peers) Msgsize() int { return 512 }
@@ -2,6 +2,7 @@
 package proto
 
 import (
+	"errors"
 	"strings"
 	"time"
 
@@ -25,6 +26,19 @@ var (
 const (
 	// NodeIDLen is the NodeID length.
 	NodeIDLen = 2 * hash.HashSize
+	// NodeIDBech32Prefix is the human-readable prefix of the bech32 node ids.
+	NodeIDBech32Prefix = "sqlit"
+)
+
+var (
+	// ErrNilNodePublicKey indicates the public key of node key type is not set
+	ErrNilNodePublicKey = errors.New("nil node public key")
+	// ErrEmptyNodeID indicates the node id is empty
+	ErrEmptyNodeID = errors.New("empty node id")
+	// ErrInvalidNodeIDLength indicates the node id is not of NodeIDLen hex characters
+	ErrInvalidNodeIDLength = errors.New("invalid node id length")
+	// ErrInvalidNodeIDHex indicates the node id is not lowercase hex
+	ErrInvalidNodeIDHex = errors.New("node id is not lowercase hex")
 )
 
 // RawNodeID is node name, will be generated from Hash(nodePublicKey)
@@ -102,6 +116,73 @@ type Node struct {
 	DirectAddr string                `yaml:"DirectAddr,omitempty"`
 	PublicKey  *asymmetric.PublicKey `yaml:"PublicKey"`
 	Nonce      mine.Uint256          `yaml:"Nonce"`
+
+	// KeyType is the key type of node identity, PublicKey is used for secp256k1
+	// and Ed25519PublicKey for ed25519.
+	KeyType          asymmetric.KeyType          `yaml:"KeyType,omitempty"`
+	Ed25519PublicKey asymmetric.Ed25519PublicKey `yaml:"Ed25519PublicKey,omitempty"`
+
+	// Addrs is the ordered alternate addresses of a multi-homed node, Addr is
+	// still the primary address for nodes not knowing Addrs.
+	Addrs []string `yaml:"Addrs,omitempty"`
+
+	// Weight is the operator assigned preference of the node, e.g. higher for the
+	// nodes in the same datacenter, route.SelectNode picks the highest first.
+	Weight int `yaml:"Weight,omitempty"`
+
+	// Region and Zone locate the node for locality aware routing, e.g. "eu-west"
+	// and "eu-west-1a", route.SelectLocalNode prefers the nodes of the local node
+	// region. An empty tag matches any region or zone.
+	Region string `yaml:"Region,omitempty"`
+	Zone   string `yaml:"Zone,omitempty"`
+
+	// Tags is the free-form labels of the node for tooling and routing policy, e.g.
+	// "owner: ops". They are not signed nor part of the node id, the unknown tags
+	// are ignored.
+	Tags map[string]string `yaml:"Tags,omitempty"`
+
+	// Capabilities is the protocol features supported by the node, check them with
+	// Supports before using a feature with the node.
+	Capabilities Capabilities `yaml:"Capabilities,omitempty"`
+}
+
+// AddrCandidates returns the addresses of node in priority order: Addr first then
+// Addrs, empty and duplicate addresses are skipped.
+func (node *Node) AddrCandidates() []string {
+	return MergeAddrs(node.Addr, node.Addrs...)
+}
+
+// MergeAddrs returns primary followed by alternates, empty and duplicate
+// addresses are skipped.
+func MergeAddrs(primary string, alternates ...string) (addrs []string) {
+	seen := make(map[string]bool, len(alternates)+1)
+	for _, addr := range append([]string{primary}, alternates...) {
+		if addr == "" || seen[addr] {
+			continue
+		}
+		seen[addr] = true
+		addrs = append(addrs, addr)
+	}
+	return
+}
+
+// TypedPublicKey returns the public key of node key type.
+func (node *Node) TypedPublicKey() (key asymmetric.TypedPublicKey, err error) {
+	switch node.KeyType {
+	case asymmetric.Secp256k1:
+		if node.PublicKey == nil {
+			return nil, ErrNilNodePublicKey
+		}
+		key = node.PublicKey
+	case asymmetric.Ed25519:
+		if len(node.Ed25519PublicKey) == 0 {
+			return nil, ErrNilNodePublicKey
+		}
+		key = node.Ed25519PublicKey
+	default:
+		err = asymmetric.ErrUnknownKeyType
+	}
+	return
 }
 
 // NewNode just return a new node struct.
@@ -124,6 +205,90 @@ func (id *NodeID) Difficulty() (difficulty int) {
 	return idHash.Difficulty()
 }
 
+// Validate checks the node id is a hash string of NodeIDLen lowercase hex
+// characters, which is the form of RawNodeID.String.
+func (id NodeID) Validate() error {
+	if id == "" {
+		return ErrEmptyNodeID
+	}
+	if len(id) != NodeIDLen {
+		return ErrInvalidNodeIDLength
+	}
+	for i := 0; i < len(id); i++ {
+		if c := id[i]; (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return ErrInvalidNodeIDHex
+		}
+	}
+	return nil
+}
+
+// Base58 returns the base58 form of the node id for display, NodeID stays the
+// canonical form.
+func (id NodeID) Base58() (s string, err error) {
+	var h *hash.Hash
+	if h, err = id.hash(); err != nil {
+		return
+	}
+	return h.Base58(), nil
+}
+
+// Bech32 returns the bech32 form of the node id with NodeIDBech32Prefix for display,
+// NodeID stays the canonical form.
+func (id NodeID) Bech32() (s string, err error) {
+	var h *hash.Hash
+	if h, err = id.hash(); err != nil {
+		return
+	}
+	return h.Bech32(NodeIDBech32Prefix)
+}
+
+// NodeIDFromBase58 parses the node id of NodeID.Base58.
+func NodeIDFromBase58(s string) (id NodeID, err error) {
+	var h *hash.Hash
+	if h, err = hash.NewHashFromBase58(s); err != nil {
+		return
+	}
+	return NodeID(h.String()), nil
+}
+
+// NodeIDFromBech32 parses the node id of NodeID.Bech32, a string failing the
+// checksum or of another prefix is an error.
+func NodeIDFromBech32(s string) (id NodeID, err error) {
+	var h *hash.Hash
+	if h, err = hash.NewHashFromBech32(NodeIDBech32Prefix, s); err != nil {
+		return
+	}
+	return NodeID(h.String()), nil
+}
+
+// hash returns the hash of a valid node id.
+func (id NodeID) hash() (h *hash.Hash, err error) {
+	if err = id.Validate(); err != nil {
+		return
+	}
+	return hash.NewHashFromStr(string(id))
+}
+
+// DeriveNodeID derives the node id of pub and nonce, it is the hex string of
+// `HashBlock(pub, nonce)` as parsed back by hash.NewHashFromStr, so every node
+// identity check and every generated id agree on it.
+func DeriveNodeID(pub asymmetric.TypedPublicKey, nonce mine.Uint256) (id NodeID, err error) {
+	switch k := pub.(type) {
+	case nil:
+		return "", ErrNilNodePublicKey
+	case *asymmetric.PublicKey:
+		if k == nil {
+			return "", ErrNilNodePublicKey
+		}
+	case asymmetric.Ed25519PublicKey:
+		if len(k) == 0 {
+			return "", ErrNilNodePublicKey
+		}
+	}
+	rawID := RawNodeID{Hash: mine.HashBlock(pub.Serialize(), nonce)}
+	return rawID.ToNodeID(), nil
+}
+
 // ToRawNodeID converts NodeID to RawNodeID.
 func (id *NodeID) ToRawNodeID() *RawNodeID {
 	idHash, err := hash.NewHashFromStr(string(*id))
@@ -213,6 +378,8 @@ const (
 	Miner
 	// Client is a client that send sql query to database>
 	Client
+	// Observer is a server that receives the leader log commits without voting.
+	Observer
 )
 
 // String is a string variable of ServerRole.
@@ -226,6 +393,8 @@ func (s ServerRole) String() string {
 		return "Miner"
 	case Client:
 		return "Client"
+	case Observer:
+		return "Observer"
 	}
 	return "Unknown"
 }
@@ -241,7 +410,7 @@ func (s *ServerRole) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	if err := unmarshal(&str); err != nil {
 		return err
 	}
-	dur, err := parseServerRole(str)
+	dur, err := ParseServerRole(str)
 	if err != nil {
 		return err
 	}
@@ -250,7 +419,8 @@ func (s *ServerRole) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	return nil
 }
 
-func parseServerRole(roleStr string) (role ServerRole, err error) {
+// ParseServerRole parses the role name, unknown names are parsed as Unknown.
+func ParseServerRole(roleStr string) (role ServerRole, err error) {
 	switch strings.ToLower(roleStr) {
 	case "leader":
 		role = Leader
@@ -264,11 +434,19 @@ func parseServerRole(roleStr string) (role ServerRole, err error) {
 	case "client":
 		role = Client
 		return
+	case "observer":
+		role = Observer
+		return
 	}
 
 	return Unknown, nil
 }
 
+// IsVoter returns if the role participates in quorum, i.e. Leader or Follower.
+func (s ServerRole) IsVoter() bool {
+	return s == Leader || s == Follower
+}
+
 // ServerRoles is []ServerRole.
 type ServerRoles []ServerRole
 
@@ -2,6 +2,8 @@
 package proto
 
 import (
+	"encoding/hex"
+	"errors"
 	"strings"
 	"testing"
 	"time"
@@ -9,7 +11,10 @@ import (
 	. "github.com/smartystreets/goconvey/convey"
 	yaml "gopkg.in/yaml.v2"
 
+	"sqlit/src/crypto/asymmetric"
 	"sqlit/src/crypto/hash"
+	mine "sqlit/src/pow/cpuminer"
+	"sqlit/src/utils"
 )
 
 func TestAccountAddress_DatabaseID(t *testing.T) {
@@ -92,6 +97,13 @@ func TestServerRoles_Contains(t *testing.T) {
 		ss = append(ss, Follower)
 		So(ss.Contains(Leader), ShouldBeTrue)
 	})
+	Convey("only leader and follower vote", t, func() {
+		for role, voter := range map[ServerRole]bool{
+			Unknown: false, Leader: true, Follower: true, Miner: false, Client: false, Observer: false,
+		} {
+			So(role.IsVoter(), ShouldEqual, voter)
+		}
+	})
 }
 
 func unmarshalAndMarshalServerRole(str string) string {
@@ -127,6 +139,7 @@ func TestServerRole_MarshalYAML(t *testing.T) {
 		So(unmarshalAndMarshalServerRole("follower"), ShouldEqual, "Follower")
 		So(unmarshalAndMarshalServerRole("miner"), ShouldEqual, "Miner")
 		So(unmarshalAndMarshalServerRole("client"), ShouldEqual, "Client")
+		So(unmarshalAndMarshalServerRole("observer"), ShouldEqual, "Observer")
 	})
 }
 
@@ -185,3 +198,207 @@ func TestNodeID_MarshalBinary(t *testing.T) {
 		So(nodeID3, ShouldResemble, nodeID)
 	})
 }
+
+func TestNode_TypedPublicKey(t *testing.T) {
+	Convey("node id derivation for each key type", t, func() {
+		_, secpPublic, _ := asymmetric.GenSecp256k1KeyPair()
+		_, edPublic, _ := asymmetric.GenEd25519KeyPair()
+		nodes := []*Node{
+			{PublicKey: secpPublic},
+			{KeyType: asymmetric.Ed25519, Ed25519PublicKey: edPublic},
+		}
+		for _, node := range nodes {
+			key, err := node.TypedPublicKey()
+			So(err, ShouldBeNil)
+			So(key.KeyType(), ShouldEqual, node.KeyType)
+
+			nonce := asymmetric.GetTypedPubKeyNonce(key, 1, 50*time.Millisecond, nil)
+			node.ID = NodeID(nonce.Hash.String())
+			node.Nonce = nonce.Nonce
+			h, err := hash.NewHashFromStr(string(node.ID))
+			So(err, ShouldBeNil)
+			So(h.IsEqual(&nonce.Hash), ShouldBeTrue)
+			keyHash := mine.HashBlock(key.Serialize(), node.Nonce)
+			So(keyHash.IsEqual(&node.ID.ToRawNodeID().Hash), ShouldBeTrue)
+		}
+
+		_, err := (&Node{KeyType: asymmetric.Ed25519}).TypedPublicKey()
+		So(err, ShouldEqual, ErrNilNodePublicKey)
+		_, err = (&Node{}).TypedPublicKey()
+		So(err, ShouldEqual, ErrNilNodePublicKey)
+		_, err = (&Node{KeyType: 99}).TypedPublicKey()
+		So(err, ShouldEqual, asymmetric.ErrUnknownKeyType)
+
+		out, err := yaml.Marshal(nodes[1])
+		So(err, ShouldBeNil)
+		var node Node
+		So(yaml.Unmarshal(out, &node), ShouldBeNil)
+		So(node.KeyType, ShouldEqual, asymmetric.Ed25519)
+		So(node.Ed25519PublicKey.IsEqual(edPublic), ShouldBeTrue)
+	})
+}
+
+func TestNode_AddrCandidates(t *testing.T) {
+	Convey("address candidates in priority order", t, func() {
+		So((&Node{}).AddrCandidates(), ShouldBeEmpty)
+		So((&Node{Addr: "a:1"}).AddrCandidates(), ShouldResemble, []string{"a:1"})
+		node := &Node{Addr: "a:1", Addrs: []string{"b:1", "", "a:1", "c:1", "b:1"}}
+		So(node.AddrCandidates(), ShouldResemble, []string{"a:1", "b:1", "c:1"})
+		So((&Node{Addrs: []string{"b:1", "c:1"}}).AddrCandidates(), ShouldResemble, []string{"b:1", "c:1"})
+	})
+	Convey("node with addrs is compatible with node without it", t, func() {
+		type legacyNode struct {
+			ID         NodeID
+			Role       ServerRole
+			Addr       string
+			DirectAddr string
+			Nonce      mine.Uint256
+		}
+		node := &Node{
+			ID:    "0000000000000000000000000000000000000000000000000000000000000001",
+			Role:  Miner,
+			Addr:  "a:1",
+			Addrs: []string{"b:1", "c:1"},
+			Nonce: mine.Uint256{A: 1},
+		}
+		buf, err := utils.EncodeMsgPack(node)
+		So(err, ShouldBeNil)
+		var legacy legacyNode
+		So(utils.DecodeMsgPack(buf.Bytes(), &legacy), ShouldBeNil)
+		So(legacy.ID, ShouldEqual, node.ID)
+		So(legacy.Addr, ShouldEqual, node.Addr)
+		So(legacy.Nonce, ShouldResemble, node.Nonce)
+
+		buf, err = utils.EncodeMsgPack(&legacy)
+		So(err, ShouldBeNil)
+		var decoded Node
+		So(utils.DecodeMsgPack(buf.Bytes(), &decoded), ShouldBeNil)
+		So(decoded.Addr, ShouldEqual, node.Addr)
+		So(decoded.Addrs, ShouldBeEmpty)
+		So(decoded.AddrCandidates(), ShouldResemble, []string{"a:1"})
+
+		out, err := yaml.Marshal(&Node{Addr: "a:1"})
+		So(err, ShouldBeNil)
+		So(string(out), ShouldNotContainSubstring, "Addrs")
+		out, err = yaml.Marshal(node)
+		So(err, ShouldBeNil)
+		So(yaml.Unmarshal(out, &decoded), ShouldBeNil)
+		So(decoded.Addrs, ShouldResemble, node.Addrs)
+	})
+}
+
+func TestNodeID_Validate(t *testing.T) {
+	Convey("validate node id", t, func() {
+		valid := NodeID("00000bef611d346c0cbe1beaa76e7f0ed705a194fdf9ac3a248ec70e9c198bf9")
+		So(valid.Validate(), ShouldBeNil)
+		So(NodeID(strings.Repeat("0", NodeIDLen)).Validate(), ShouldBeNil)
+		So(NodeID("").Validate(), ShouldEqual, ErrEmptyNodeID)
+		for _, id := range []NodeID{valid[1:], valid + "0", "0", valid[:32]} {
+			So(id.Validate(), ShouldEqual, ErrInvalidNodeIDLength)
+		}
+		for _, id := range []NodeID{
+			"x" + valid[1:],
+			valid[:63] + "g",
+			NodeID(strings.ToUpper(string(valid))),
+			valid[:62] + " 0",
+		} {
+			So(id.Validate(), ShouldEqual, ErrInvalidNodeIDHex)
+		}
+	})
+	Convey("derive node id from public key", t, func() {
+		_, secpPublic, err := asymmetric.GenSecp256k1KeyPair()
+		So(err, ShouldBeNil)
+		_, edPublic, err := asymmetric.GenEd25519KeyPair()
+		So(err, ShouldBeNil)
+		for _, key := range []asymmetric.TypedPublicKey{secpPublic, edPublic} {
+			nonce := asymmetric.GetTypedPubKeyNonce(key, 1, 50*time.Millisecond, nil)
+			id, err := DeriveNodeID(key, nonce.Nonce)
+			So(err, ShouldBeNil)
+			So(id.Validate(), ShouldBeNil)
+			So(string(id), ShouldEqual, nonce.Hash.String())
+			So(id.ToRawNodeID().IsEqual(&nonce.Hash), ShouldBeTrue)
+
+			other, err := DeriveNodeID(key, *nonce.Nonce.Inc())
+			So(err, ShouldBeNil)
+			So(other, ShouldNotEqual, id)
+		}
+		_, err = DeriveNodeID(nil, mine.Uint256{})
+		So(err, ShouldEqual, ErrNilNodePublicKey)
+		_, err = DeriveNodeID((*asymmetric.PublicKey)(nil), mine.Uint256{})
+		So(err, ShouldEqual, ErrNilNodePublicKey)
+		_, err = DeriveNodeID(asymmetric.Ed25519PublicKey(nil), mine.Uint256{})
+		So(err, ShouldEqual, ErrNilNodePublicKey)
+	})
+	Convey("the node id derivation is pinned", t, func() {
+		// the block producer of the test configs
+		secpBytes, err := hex.DecodeString("02c76216704d797c64c58bc11519fb68582e8e63de7e5b3b2dbbbe8733efe5fd24")
+		So(err, ShouldBeNil)
+		secpPublic, err := asymmetric.ParsePubKey(secpBytes)
+		So(err, ShouldBeNil)
+		seed := make([]byte, 32)
+		for i := range seed {
+			seed[i] = byte(i)
+		}
+		edPrivate, err := asymmetric.Ed25519PrivKeyFromSeed(seed)
+		So(err, ShouldBeNil)
+		So(hex.EncodeToString(edPrivate.PubKey()), ShouldEqual,
+			"03a107bff3ce10be1d70dd18e74bc09967e4d6309ba50d5f1ddc8664125531b8")
+
+		for _, v := range []struct {
+			key   asymmetric.TypedPublicKey
+			nonce mine.Uint256
+			id    NodeID
+		}{
+			{secpPublic, mine.Uint256{A: 313283}, "00000bef611d346c0cbe1beaa76e7f0ed705a194fdf9ac3a248ec70e9c198bf9"},
+			{secpPublic, mine.Uint256{}, "6171fdb4dc6a1f480f84cb45a28d0fd128264746fb1b1b369c42466edbfd7d98"},
+			{edPrivate.PubKey(), mine.Uint256{A: 1, B: 2, C: 3, D: 4},
+				"15d2f8289f329ddd36874bbe989a4b0dda99c7ac73f74ee338101f507efd457d"},
+		} {
+			id, err := DeriveNodeID(v.key, v.nonce)
+			So(err, ShouldBeNil)
+			So(id, ShouldEqual, v.id)
+			// the id is parsed back to the hash the node identity checks compare
+			parsed, err := hash.NewHashFromStr(string(id))
+			So(err, ShouldBeNil)
+			So(parsed.IsEqual(&id.ToRawNodeID().Hash), ShouldBeTrue)
+			blockHash := mine.HashBlock(v.key.Serialize(), v.nonce)
+			So(parsed.IsEqual(&blockHash), ShouldBeTrue)
+		}
+	})
+}
+
+func TestNodeID_Encodings(t *testing.T) {
+	Convey("the node id encodings round trip", t, func() {
+		id := NodeID("00000bef611d346c0cbe1beaa76e7f0ed705a194fdf9ac3a248ec70e9c198bf9")
+		b58, err := id.Base58()
+		So(err, ShouldBeNil)
+		So(len(b58), ShouldBeLessThan, NodeIDLen)
+		parsed, err := NodeIDFromBase58(b58)
+		So(err, ShouldBeNil)
+		So(parsed, ShouldEqual, id)
+
+		b32, err := id.Bech32()
+		So(err, ShouldBeNil)
+		So(b32, ShouldStartWith, NodeIDBech32Prefix+"1")
+		parsed, err = NodeIDFromBech32(b32)
+		So(err, ShouldBeNil)
+		So(parsed, ShouldEqual, id)
+
+		// the id is the same bytes in every form
+		raw, err := id.MarshalBinary()
+		So(err, ShouldBeNil)
+		h, err := hash.NewHashFromBase58(b58)
+		So(err, ShouldBeNil)
+		So(h[:], ShouldResemble, raw)
+	})
+	Convey("the invalid node ids are not encoded", t, func() {
+		_, err := NodeID("").Base58()
+		So(err, ShouldEqual, ErrEmptyNodeID)
+		_, err = NodeID("abc").Bech32()
+		So(err, ShouldEqual, ErrInvalidNodeIDLength)
+		_, err = NodeIDFromBech32("sqlit1qqqqqqqq")
+		So(errors.Is(err, hash.ErrInvalidBech32Hash), ShouldBeTrue)
+		_, err = NodeIDFromBase58("not base58 0")
+		So(err, ShouldEqual, hash.ErrInvalidBase58Hash)
+	})
+}
@@ -167,3 +167,7 @@ func FromAccountAndNonce(accountAddress AccountAddress, nonce uint32) DatabaseID
 	rawID := hash.THashH([]byte(addrAndNonce))
 	return DatabaseID(rawID.String())
 }
+
+// Nonce is the sequence number of the messages signed by a node, a node never
+// signs two messages with the same nonce.
+type Nonce uint64
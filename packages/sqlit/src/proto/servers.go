@@ -2,14 +2,122 @@
 package proto
 
 import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/big"
+	"sync"
+
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/pkg/errors"
+
 	"sqlit/src/crypto/asymmetric"
+	"sqlit/src/crypto/hash"
 	"sqlit/src/crypto/verifier"
+	"sqlit/src/marshalhash"
 )
 
 //go:generate hsp
 
+const (
+	// PeersVersion0 is the first signed layout, only the PeersHeader is signed and the
+	// observers are not covered.
+	PeersVersion0 uint64 = 0
+	// PeersVersion1 signs the observers along with the PeersHeader.
+	PeersVersion1 uint64 = 1
+	// PeersVersion is the latest layout known by this node, peers of any version up
+	// to it are signed and verified in the layout of their own version. The zero value
+	// PeersVersion0 is kept by default as older nodes can not verify the newer ones.
+	PeersVersion = PeersVersion1
+)
+
+// DefaultMaxServers is the max servers of peers if SetMaxServers is not called.
+const DefaultMaxServers = 256
+
+var (
+	// ErrNoNodeKeyResolver indicates no resolver is set to look up node public keys
+	ErrNoNodeKeyResolver = errors.New("no node key resolver")
+	// ErrUnsupportedPeersVersion indicates the peers header version is unknown
+	ErrUnsupportedPeersVersion = errors.New("unsupported peers version")
+	// ErrPeersNotSigned indicates the peers is modified after signing
+	ErrPeersNotSigned = errors.New("peers modified and not signed")
+	// ErrServerExists indicates the server to add is already in peers
+	ErrServerExists = errors.New("server already exists")
+	// ErrServerNotFound indicates the server is not in peers
+	ErrServerNotFound = errors.New("server not found")
+	// ErrRemoveLeader indicates the server to remove is the leader
+	ErrRemoveLeader = errors.New("can not remove leader")
+	// ErrStaleTerm indicates the new term is not strictly greater than the current term
+	ErrStaleTerm = errors.New("term is not strictly greater")
+	// ErrTooManyServers indicates the peers servers exceed MaxServers
+	ErrTooManyServers = errors.New("too many servers")
+	// ErrEmptySignature indicates the signature to add is empty
+	ErrEmptySignature = errors.New("empty signature")
+	// ErrInvalidThreshold indicates the threshold is not in 1 to the voting servers
+	ErrInvalidThreshold = errors.New("invalid signature threshold")
+	// ErrSignatureAlgorithm indicates the declared algorithm of a signature is not the
+	// key type of its signer
+	ErrSignatureAlgorithm = errors.New("signature algorithm does not match signer key")
+)
+
+// NodeKeyResolver looks up the public key of a node.
+type NodeKeyResolver func(id NodeID) (asymmetric.TypedPublicKey, error)
+
+var (
+	nodeKeyResolver     NodeKeyResolver
+	nodeKeyResolverLock sync.RWMutex
+)
+
+// SetNodeKeyResolver sets the resolver used by Peers.VerifyLeader, kms sets it
+// to look up the public keystore.
+func SetNodeKeyResolver(resolver NodeKeyResolver) {
+	nodeKeyResolverLock.Lock()
+	defer nodeKeyResolverLock.Unlock()
+	nodeKeyResolver = resolver
+}
+
+func getNodeKeyResolver() NodeKeyResolver {
+	nodeKeyResolverLock.RLock()
+	defer nodeKeyResolverLock.RUnlock()
+	return nodeKeyResolver
+}
+
+var (
+	maxServers     = DefaultMaxServers
+	maxServersLock sync.RWMutex
+)
+
+// peersVerifyCacheSize bounds the verified peers kept by the verify cache.
+const peersVerifyCacheSize = 256
+
+// peersVerifyCache keeps the verified peers by verifyCacheKey, so the repeated
+// verification of the same peers skips the signature check.
+var peersVerifyCache, _ = lru.New(peersVerifyCacheSize)
+
+// SetMaxServers sets the max servers of peers enforced by Peers.AddServer and the
+// signing, a non positive max resets it to DefaultMaxServers. sqlitd sets it by
+// conf.GConf.MaxPeersServers.
+func SetMaxServers(max int) {
+	if max <= 0 {
+		max = DefaultMaxServers
+	}
+	maxServersLock.Lock()
+	defer maxServersLock.Unlock()
+	maxServers = max
+}
+
+// MaxServers returns the max servers of peers.
+func MaxServers() int {
+	maxServersLock.RLock()
+	defer maxServersLock.RUnlock()
+	return maxServers
+}
+
 // PeersHeader defines the header for miner peers.
 type PeersHeader struct {
+	// Version is the signed layout version, it is signed too so a signature is only
+	// valid in the layout it is made for
 	Version uint64
 	Term    uint64
 	Leader  NodeID
@@ -20,26 +128,408 @@ type PeersHeader struct {
 type Peers struct {
 	PeersHeader
 	verifier.DefaultHashSignVerifierImpl
+
+	// SigneeKeyType is the key type of signee, Signee and Signature are used for
+	// secp256k1 and TypedSignee and TypedSignature for the others.
+	SigneeKeyType  asymmetric.KeyType
+	TypedSignee    []byte
+	TypedSignature []byte
+
+	// Observers receive the replicated state without voting, they are not
+	// counted in quorum and covered by the signature since PeersVersion1.
+	Observers []NodeID
+
+	// Signatures are the signatures of the servers over SigningHash, accumulated by
+	// AddSignature for VerifyThreshold. They are cleared by membership changes.
+	Signatures []PeersSignature
+	// Approvals are the signatures of the current servers over OverrideHash,
+	// accumulated by AddApproval for AcceptInto. They are cleared by membership
+	// changes.
+	Approvals []PeersSignature
+
+	// isDirty is set by membership changes and cleared by signing
+	isDirty bool
+}
+
+// PeersSignature is the signature of a node over Peers.SigningHash, made by the
+// SignBytes of the node private key of KeyType.
+type PeersSignature struct {
+	Node      NodeID
+	KeyType   asymmetric.KeyType
+	Signature []byte
 }
 
-// Clone makes a deep copy of Peers.
-func (p *Peers) Clone() (copy Peers) {
+// Clone makes a deep copy of Peers, the copy shares no memory with p so both can
+// be mutated concurrently. Clone of nil is nil.
+func (p *Peers) Clone() (copy *Peers) {
+	if p == nil {
+		return
+	}
+	copy = &Peers{}
 	copy.Version = p.Version
 	copy.Term = p.Term
 	copy.Leader = p.Leader
 	copy.Servers = append(copy.Servers, p.Servers...)
-	copy.DefaultHashSignVerifierImpl = p.DefaultHashSignVerifierImpl
+	copy.Observers = append(copy.Observers, p.Observers...)
+	copy.DataHash = p.DataHash
+	if p.Signee != nil {
+		signee := *p.Signee
+		signee.X, signee.Y = cloneBigInt(p.Signee.X), cloneBigInt(p.Signee.Y)
+		copy.Signee = &signee
+	}
+	if p.Signature != nil {
+		copy.Signature = &asymmetric.Signature{
+			R: cloneBigInt(p.Signature.R),
+			S: cloneBigInt(p.Signature.S),
+		}
+	}
+	copy.SigneeKeyType = p.SigneeKeyType
+	copy.TypedSignee = append(copy.TypedSignee, p.TypedSignee...)
+	copy.TypedSignature = append(copy.TypedSignature, p.TypedSignature...)
+	copy.Signatures = clonePeersSignatures(p.Signatures)
+	copy.Approvals = clonePeersSignatures(p.Approvals)
+	copy.isDirty = p.isDirty
+	return
+}
+
+func clonePeersSignatures(sigs []PeersSignature) (copy []PeersSignature) {
+	for _, s := range sigs {
+		copy = append(copy, PeersSignature{
+			Node:      s.Node,
+			KeyType:   s.KeyType,
+			Signature: append([]byte(nil), s.Signature...),
+		})
+	}
+	return
+}
+
+func cloneBigInt(i *big.Int) *big.Int {
+	if i == nil {
+		return nil
+	}
+	return new(big.Int).Set(i)
+}
+
+// signedData returns the content signed in the layout of p.Version,
+// ErrUnsupportedPeersVersion is returned for an unknown version.
+func (p *Peers) signedData() (data verifier.MarshalHasher, err error) {
+	switch p.Version {
+	case PeersVersion0:
+		return &p.PeersHeader, nil
+	case PeersVersion1:
+		return peersV1Data{p}, nil
+	default:
+		return nil, ErrUnsupportedPeersVersion
+	}
+}
+
+// CheckServers returns an error caused by ErrTooManyServers if the servers exceed
+// MaxServers.
+func (p *Peers) CheckServers() (err error) {
+	if max := MaxServers(); len(p.Servers) > max {
+		return errors.Wrapf(ErrTooManyServers, "%d servers exceed the max %d", len(p.Servers), max)
+	}
 	return
 }
 
 // Sign generates signature.
 func (p *Peers) Sign(signer *asymmetric.PrivateKey) (err error) {
-	return p.DefaultHashSignVerifierImpl.Sign(&p.PeersHeader, signer)
+	if err = p.CheckServers(); err != nil {
+		return
+	}
+	var data verifier.MarshalHasher
+	if data, err = p.signedData(); err != nil {
+		return
+	}
+	p.resetTypedSignature()
+	if err = p.DefaultHashSignVerifierImpl.Sign(data, signer); err == nil {
+		p.isDirty = false
+	}
+	return
+}
+
+// SignTyped generates signature with private key of any key type.
+func (p *Peers) SignTyped(signer asymmetric.TypedPrivateKey) (err error) {
+	if private, ok := signer.(*asymmetric.PrivateKey); ok {
+		return p.Sign(private)
+	}
+	if err = p.CheckServers(); err != nil {
+		return
+	}
+	var data verifier.MarshalHasher
+	if data, err = p.signedData(); err != nil {
+		return
+	}
+	if err = p.SetHash(data); err != nil {
+		return
+	}
+	var sig []byte
+	if sig, err = signer.SignBytes(p.DataHash[:]); err != nil {
+		return
+	}
+	p.Signee = nil
+	p.Signature = nil
+	p.SigneeKeyType = signer.KeyType()
+	p.TypedSignee = signer.TypedPubKey().Serialize()
+	p.TypedSignature = sig
+	p.isDirty = false
+	return
+}
+
+// GetSignee returns the signee public key of any key type.
+func (p *Peers) GetSignee() (signee asymmetric.TypedPublicKey, err error) {
+	if p.SigneeKeyType == asymmetric.Secp256k1 {
+		if p.Signee == nil {
+			return nil, errors.WithStack(verifier.ErrSignatureNotMatch)
+		}
+		return p.Signee, nil
+	}
+	return asymmetric.ParseTypedPublicKey(p.SigneeKeyType, p.TypedSignee)
+}
+
+func (p *Peers) resetTypedSignature() {
+	p.SigneeKeyType = asymmetric.Secp256k1
+	p.TypedSignee = nil
+	p.TypedSignature = nil
+}
+
+// SignWith generates signature with signer, the private key of signer is never exposed.
+func (p *Peers) SignWith(signer verifier.Signer) (err error) {
+	if err = p.CheckServers(); err != nil {
+		return
+	}
+	var data verifier.MarshalHasher
+	if data, err = p.signedData(); err != nil {
+		return
+	}
+	p.resetTypedSignature()
+	if err = p.DefaultHashSignVerifierImpl.SignWith(data, signer); err == nil {
+		p.isDirty = false
+	}
+	return
 }
 
-// Verify verify signature.
+// Verify verify signature, the algorithm is chosen by the signee key type and the
+// signed content by the version. Peers modified by membership changes is rejected
+// until signed again. The signed content is always hashed again, only the signature
+// check of a verified hash and signature is skipped by the verify cache.
 func (p *Peers) Verify() (err error) {
-	return p.DefaultHashSignVerifierImpl.Verify(&p.PeersHeader)
+	if p.isDirty {
+		return ErrPeersNotSigned
+	}
+	var data verifier.MarshalHasher
+	if data, err = p.signedData(); err != nil {
+		return
+	}
+	if err = p.VerifyHash(data); err != nil {
+		return
+	}
+	var key string
+	if key, err = p.verifyCacheKey(); err != nil {
+		return
+	}
+	if _, ok := peersVerifyCache.Get(key); ok {
+		return
+	}
+	if err = p.verifySignature(); err != nil {
+		return
+	}
+	if !asymmetric.BypassSignature {
+		peersVerifyCache.Add(key, nil)
+	}
+	return
+}
+
+// verifySignature verifies the signature over DataHash by the declared key type of
+// signee, the signature fields of the other key types must be empty.
+func (p *Peers) verifySignature() (err error) {
+	if p.SigneeKeyType == asymmetric.Secp256k1 {
+		if len(p.TypedSignee) > 0 || len(p.TypedSignature) > 0 {
+			return errors.Wrapf(ErrSignatureAlgorithm, "%s signature has typed signee", p.SigneeKeyType)
+		}
+		return p.DefaultHashSignVerifierImpl.VerifySignature()
+	}
+	if p.Signee != nil || p.Signature != nil {
+		return errors.Wrapf(ErrSignatureAlgorithm, "%s signature has secp256k1 signee", p.SigneeKeyType)
+	}
+	var signee asymmetric.TypedPublicKey
+	if signee, err = p.GetSignee(); err != nil {
+		return
+	}
+	if !signee.VerifyBytes(p.DataHash[:], p.TypedSignature) {
+		err = errors.WithStack(verifier.ErrSignatureNotMatch)
+	}
+	return
+}
+
+// verifyCacheKey returns the key of p in the verify cache, it covers DataHash and
+// all the signature fields, so any change of them misses the cache.
+func (p *Peers) verifyCacheKey() (key string, err error) {
+	var enc []byte
+	if enc, err = p.DefaultHashSignVerifierImpl.MarshalHash(); err != nil {
+		return
+	}
+	enc = marshalhash.AppendByte(enc, byte(p.SigneeKeyType))
+	enc = marshalhash.AppendBytes(enc, p.TypedSignee)
+	enc = marshalhash.AppendBytes(enc, p.TypedSignature)
+	h := hash.THashH(enc)
+	return string(h[:]), nil
+}
+
+// VerifyLeader verifies the peers is signed by the leader. The public key of the
+// leader is looked up by the node key resolver instead of trusting the signee
+// carried by the peers, so a node can not forge the peers of a leader. It fails
+// closed: an error is returned if the leader public key is unknown, valid is false
+// if the signee is not the leader or the signature does not match. The error is
+// caused by ErrSignatureAlgorithm if the declared algorithm is not the key type of
+// the leader.
+func (p *Peers) VerifyLeader() (valid bool, err error) {
+	if p.Version > PeersVersion {
+		return false, ErrUnsupportedPeersVersion
+	}
+	resolver := getNodeKeyResolver()
+	if resolver == nil {
+		return false, ErrNoNodeKeyResolver
+	}
+	var leaderKey asymmetric.TypedPublicKey
+	if leaderKey, err = resolver(p.Leader); err == nil && leaderKey == nil {
+		err = ErrNilNodePublicKey
+	}
+	if err != nil {
+		err = errors.Wrapf(err, "get public key of leader %s failed", p.Leader)
+		return
+	}
+
+	if p.SigneeKeyType != leaderKey.KeyType() {
+		err = errors.Wrapf(ErrSignatureAlgorithm, "%s signature of %s leader %s",
+			p.SigneeKeyType, leaderKey.KeyType(), p.Leader)
+		return
+	}
+	signee, signeeErr := p.GetSignee()
+	if signeeErr != nil || signee.KeyType() != leaderKey.KeyType() ||
+		!bytes.Equal(signee.Serialize(), leaderKey.Serialize()) {
+		return
+	}
+	valid = p.Verify() == nil
+	return
+}
+
+// SigningHash returns the hash of the content signed in the layout of p.Version,
+// it is the payload of all the signers of AddSignature and it does not trust
+// DataHash.
+func (p *Peers) SigningHash() (h hash.Hash, err error) {
+	var (
+		data verifier.MarshalHasher
+		enc  []byte
+	)
+	if data, err = p.signedData(); err != nil {
+		return
+	}
+	if enc, err = data.MarshalHash(); err != nil {
+		return
+	}
+	return hash.THashH(enc), nil
+}
+
+// AddSignature adds the signature of node id made by a key of keyType over
+// SigningHash, a signature of id added before is replaced so a node is counted once.
+// The signature is verified by VerifyThreshold instead, as the public key of the
+// node may be unknown yet.
+func (p *Peers) AddSignature(id NodeID, keyType asymmetric.KeyType, sig []byte) (err error) {
+	return addPeersSignature(&p.Signatures, id, keyType, sig)
+}
+
+// AddApproval adds the signature of node id made by a key of keyType over the
+// OverrideHash of p over the current peers, like AddSignature an approval of id added
+// before is replaced. The approval is verified by AcceptInto.
+func (p *Peers) AddApproval(id NodeID, keyType asymmetric.KeyType, sig []byte) (err error) {
+	return addPeersSignature(&p.Approvals, id, keyType, sig)
+}
+
+func addPeersSignature(sigs *[]PeersSignature, id NodeID, keyType asymmetric.KeyType, sig []byte) (err error) {
+	if len(sig) == 0 {
+		return ErrEmptySignature
+	}
+	sig = append([]byte(nil), sig...)
+	for i := range *sigs {
+		if (*sigs)[i].Node.IsEqual(&id) {
+			(*sigs)[i].KeyType = keyType
+			(*sigs)[i].Signature = sig
+			return
+		}
+	}
+	*sigs = append(*sigs, PeersSignature{Node: id, KeyType: keyType, Signature: sig})
+	return
+}
+
+// VerifyThreshold verifies at least t distinct voting servers signed SigningHash,
+// e.g. 3 of 5 block producers approve a membership change. Like VerifyLeader the
+// public keys are looked up by the node key resolver, the signatures of observers,
+// unknown nodes, of an unresolved key or declaring another algorithm than the key
+// type of the node are not counted and a node is counted once.
+// ErrInvalidThreshold is returned if t is not in 1 to the voting servers.
+func (p *Peers) VerifyThreshold(t int) (valid bool, err error) {
+	if p.Version > PeersVersion {
+		return false, ErrUnsupportedPeersVersion
+	}
+	voters := p.voters()
+	if t < 1 || t > len(voters) {
+		return false, errors.Wrapf(ErrInvalidThreshold, "threshold %d of %d servers", t, len(voters))
+	}
+	var h hash.Hash
+	if h, err = p.SigningHash(); err != nil {
+		return
+	}
+	var signed int
+	if signed, err = countSignatures(p.Signatures, voters, h); err != nil {
+		return
+	}
+	valid = signed >= t
+	return
+}
+
+// OverrideHash returns the hash the servers of current sign by AddApproval to
+// approve p replacing current in AcceptInto. It binds the SigningHash of p to the
+// term and the SigningHash of current, so the approval is void once current changed
+// and an approved older peers list can not be replayed.
+func (p *Peers) OverrideHash(current *Peers) (h hash.Hash, err error) {
+	var ph, ch hash.Hash
+	if ph, err = p.SigningHash(); err != nil {
+		return
+	}
+	if ch, err = current.SigningHash(); err != nil {
+		return
+	}
+	buf := make([]byte, 0, 2*hash.HashSize+8)
+	buf = append(buf, ph[:]...)
+	buf = binary.BigEndian.AppendUint64(buf, current.Term)
+	buf = append(buf, ch[:]...)
+	return hash.THashH(buf), nil
+}
+
+// countSignatures returns the count of the distinct voters with a valid signature of
+// h in sigs, the public keys are looked up by the node key resolver.
+func countSignatures(sigs []PeersSignature, voters map[NodeID]struct{}, h hash.Hash) (count int, err error) {
+	resolver := getNodeKeyResolver()
+	if resolver == nil {
+		return 0, ErrNoNodeKeyResolver
+	}
+	signed := make(map[NodeID]struct{}, len(sigs))
+	for _, s := range sigs {
+		if _, ok := voters[s.Node]; !ok {
+			continue
+		}
+		if _, ok := signed[s.Node]; ok {
+			continue
+		}
+		key, keyErr := resolver(s.Node)
+		if keyErr != nil || key == nil || key.KeyType() != s.KeyType ||
+			!key.VerifyBytes(h[:], s.Signature) {
+			continue
+		}
+		signed[s.Node] = struct{}{}
+	}
+	return len(signed), nil
 }
 
 // Find finds the index of the server with the specified key in the server list.
@@ -56,3 +546,189 @@ func (p *Peers) Find(key NodeID) (index int32, found bool) {
 
 	return
 }
+
+// IsObserver returns if the node with the specified key is an observer.
+func (p *Peers) IsObserver(key NodeID) bool {
+	for _, o := range p.Observers {
+		if key.IsEqual(&o) {
+			return true
+		}
+	}
+	return false
+}
+
+// Quorum returns the majority threshold of the voting servers, i.e. the minimum
+// distinct acks including the leader to commit. Observers never vote, duplicate
+// servers are counted once and an empty peers has quorum 0.
+func (p *Peers) Quorum() int {
+	voters := len(p.voters())
+	if voters == 0 {
+		return 0
+	}
+	return voters/2 + 1
+}
+
+// HasQuorum returns if the positive acks of voting servers reach Quorum, acks of
+// observers or unknown nodes are ignored.
+func (p *Peers) HasQuorum(acks map[NodeID]bool) bool {
+	var count int
+	for id := range p.voters() {
+		if acks[id] {
+			count++
+		}
+	}
+	quorum := p.Quorum()
+	return quorum > 0 && count >= quorum
+}
+
+func (p *Peers) voters() (voters map[NodeID]struct{}) {
+	voters = make(map[NodeID]struct{}, len(p.Servers))
+	for _, s := range p.Servers {
+		voters[s] = struct{}{}
+	}
+	return
+}
+
+// AddServer adds id to servers and bumps the term, the peers must be signed again.
+// The error is caused by ErrTooManyServers if the servers are MaxServers already.
+func (p *Peers) AddServer(id NodeID) (err error) {
+	if _, found := p.Find(id); found {
+		return ErrServerExists
+	}
+	if max := MaxServers(); len(p.Servers) >= max {
+		return errors.Wrapf(ErrTooManyServers, "can not add server to %d servers, the max is %d",
+			len(p.Servers), max)
+	}
+	p.Servers = append(p.Servers, id)
+	p.markDirty()
+	return
+}
+
+// RemoveServer removes id from servers and bumps the term, the peers must be
+// signed again. The leader can not be removed.
+func (p *Peers) RemoveServer(id NodeID) (err error) {
+	if _, found := p.Find(id); !found {
+		return ErrServerNotFound
+	}
+	if id.IsEqual(&p.Leader) {
+		return ErrRemoveLeader
+	}
+	servers := make([]NodeID, 0, len(p.Servers)-1)
+	for _, s := range p.Servers {
+		if !id.IsEqual(&s) {
+			servers = append(servers, s)
+		}
+	}
+	p.Servers = servers
+	p.markDirty()
+	return
+}
+
+// SetLeader sets the leader to id in servers and bumps the term, the peers must
+// be signed again. Setting the current leader is a no-op.
+func (p *Peers) SetLeader(id NodeID) (err error) {
+	if _, found := p.Find(id); !found {
+		return ErrServerNotFound
+	}
+	if id.IsEqual(&p.Leader) {
+		return
+	}
+	p.Leader = id
+	p.markDirty()
+	return
+}
+
+// AdvanceTerm moves the peers to the next term led by newLeader, the peers must be
+// signed again. newLeader must be in servers and may be the current leader.
+func (p *Peers) AdvanceTerm(newLeader NodeID) (err error) {
+	if p.Term == math.MaxUint64 {
+		return ErrStaleTerm
+	}
+	if _, found := p.Find(newLeader); !found {
+		return ErrServerNotFound
+	}
+	p.Leader = newLeader
+	p.markDirty()
+	return
+}
+
+// IsNewerThan returns if p is strictly newer than other: the greater term wins, and
+// for the same term the greater header hash wins so all nodes pick the same peers.
+// A nil other is older than any peers.
+func (p *Peers) IsNewerThan(other *Peers) bool {
+	if other == nil {
+		return true
+	}
+	if p.Term != other.Term {
+		return p.Term > other.Term
+	}
+	return bytes.Compare(p.headerHash(), other.headerHash()) > 0
+}
+
+// StaleTermError is the rejection of AcceptInto for peers not newer than the current
+// ones, it is caused by ErrStaleTerm so the caller can log and ignore it.
+type StaleTermError struct {
+	Current  uint64
+	Incoming uint64
+}
+
+// Error implements error.Error.
+func (e *StaleTermError) Error() string {
+	return fmt.Sprintf("peers of term %d over term %d: %v", e.Incoming, e.Current, ErrStaleTerm)
+}
+
+// Cause returns the underlying error.
+func (e *StaleTermError) Cause() error {
+	return ErrStaleTerm
+}
+
+// AcceptInto returns if p may replace current, the peers in use, e.g. when adopting
+// the peers of another node. The term of p must be strictly greater than the current
+// one, unless p is an override approved by the quorum of the current voting servers:
+// more than half of them signed the OverrideHash of p over current, see AddApproval.
+// The signatures over SigningHash of p alone never approve an override. Otherwise a
+// *StaleTermError is returned, so membership is never rolled back by an older or
+// replayed peers list. Any signed p is accepted over a nil current. The signature of
+// p itself is verified by the caller, e.g. by VerifyLeader.
+func (p *Peers) AcceptInto(current *Peers) (err error) {
+	if p.isDirty {
+		return ErrPeersNotSigned
+	}
+	if current == nil || p.Term > current.Term {
+		return
+	}
+	if len(p.Approvals) > 0 {
+		if h, hashErr := p.OverrideHash(current); hashErr == nil {
+			if signed, countErr := countSignatures(p.Approvals, current.voters(), h); countErr == nil &&
+				current.Quorum() > 0 && signed >= current.Quorum() {
+				return
+			}
+		}
+	}
+	return &StaleTermError{Current: current.Term, Incoming: p.Term}
+}
+
+// headerHash returns the hash of the content as signed, it does not trust DataHash.
+// The PeersHeader is hashed for an unknown version.
+func (p *Peers) headerHash() []byte {
+	data, err := p.signedData()
+	if err != nil {
+		data = &p.PeersHeader
+	}
+	// MarshalHash of the signed content never fails
+	enc, _ := data.MarshalHash()
+	h := hash.THashH(enc)
+	return h[:]
+}
+
+// IsDirty returns if the peers is modified by membership changes and not signed.
+func (p *Peers) IsDirty() bool {
+	return p.isDirty
+}
+
+func (p *Peers) markDirty() {
+	p.Term++
+	p.isDirty = true
+	p.Signatures = nil
+	p.Approvals = nil
+}
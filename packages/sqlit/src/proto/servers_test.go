@@ -2,11 +2,19 @@
 package proto
 
 import (
+	"encoding/hex"
+	"encoding/json"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
 	"testing"
 
+	"github.com/pkg/errors"
 	. "github.com/smartystreets/goconvey/convey"
 
 	"sqlit/src/crypto/asymmetric"
+	"sqlit/src/crypto/verifier"
 	"sqlit/src/utils"
 )
 
@@ -61,3 +69,804 @@ func TestPeers(t *testing.T) {
 		So(err, ShouldNotBeNil)
 	})
 }
+
+func TestPeersTypedSignature(t *testing.T) {
+	Convey("sign peers with ed25519 key", t, func() {
+		privKey, _, err := asymmetric.GenEd25519KeyPair()
+		So(err, ShouldBeNil)
+		p := &Peers{
+			PeersHeader: PeersHeader{
+				Term:    1,
+				Leader:  NodeID("00000bef611d346c0cbe1beaa76e7f0ed705a194fdf9ac3a248ec70e9c198bf9"),
+				Servers: []NodeID{NodeID("00000bef611d346c0cbe1beaa76e7f0ed705a194fdf9ac3a248ec70e9c198bf9")},
+			},
+		}
+		So(p.SignTyped(privKey), ShouldBeNil)
+		So(p.SigneeKeyType, ShouldEqual, asymmetric.Ed25519)
+		So(p.Signee, ShouldBeNil)
+		So(p.Verify(), ShouldBeNil)
+		signee, err := p.GetSignee()
+		So(err, ShouldBeNil)
+		So(signee.(asymmetric.Ed25519PublicKey).IsEqual(privKey.PubKey()), ShouldBeTrue)
+
+		// after encode/decode
+		buf, err := utils.EncodeMsgPack(p)
+		So(err, ShouldBeNil)
+		var peers *Peers
+		So(utils.DecodeMsgPack(buf.Bytes(), &peers), ShouldBeNil)
+		So(peers.Verify(), ShouldBeNil)
+		peers2 := peers.Clone()
+		So(peers2.Verify(), ShouldBeNil)
+
+		peers2.Term = 2
+		So(peers2.Verify(), ShouldNotBeNil)
+		peers.TypedSignature[0] ^= 0xff
+		So(peers.Verify(), ShouldNotBeNil)
+
+		// the signature fields must be of the declared algorithm
+		secpKey, _, _ := asymmetric.GenSecp256k1KeyPair()
+		mixed := p.Clone()
+		mixed.SigneeKeyType = asymmetric.Secp256k1
+		So(errors.Cause(mixed.Verify()), ShouldEqual, ErrSignatureAlgorithm)
+		mixed = p.Clone()
+		mixed.Signee = secpKey.PubKey()
+		So(errors.Cause(mixed.Verify()), ShouldEqual, ErrSignatureAlgorithm)
+
+		// back to secp256k1
+		So(p.SignTyped(secpKey), ShouldBeNil)
+		So(p.SigneeKeyType, ShouldEqual, asymmetric.Secp256k1)
+		So(p.TypedSignee, ShouldBeNil)
+		So(p.Verify(), ShouldBeNil)
+	})
+}
+
+func TestPeersVerifyLeader(t *testing.T) {
+	Convey("verify peers is signed by leader", t, func() {
+		leaderKey, _, err := asymmetric.GenSecp256k1KeyPair()
+		So(err, ShouldBeNil)
+		otherKey, _, err := asymmetric.GenSecp256k1KeyPair()
+		So(err, ShouldBeNil)
+		edLeaderKey, _, err := asymmetric.GenEd25519KeyPair()
+		So(err, ShouldBeNil)
+		var (
+			leader   = NodeID("00000bef611d346c0cbe1beaa76e7f0ed705a194fdf9ac3a248ec70e9c198bf9")
+			edLeader = NodeID("00000381d46fd6cf7742d7fb94e2422033af989c0e348b5781b3219599a3af35")
+			keys     = map[NodeID]asymmetric.TypedPublicKey{
+				leader:   leaderKey.PubKey(),
+				edLeader: edLeaderKey.TypedPubKey(),
+			}
+		)
+		newPeers := func(leader NodeID) *Peers {
+			return &Peers{PeersHeader: PeersHeader{
+				Term:    1,
+				Leader:  leader,
+				Servers: []NodeID{leader},
+			}}
+		}
+
+		SetNodeKeyResolver(nil)
+		p := newPeers(leader)
+		So(p.Sign(leaderKey), ShouldBeNil)
+		valid, err := p.VerifyLeader()
+		So(err, ShouldEqual, ErrNoNodeKeyResolver)
+		So(valid, ShouldBeFalse)
+
+		SetNodeKeyResolver(func(id NodeID) (asymmetric.TypedPublicKey, error) {
+			if key, ok := keys[id]; ok {
+				return key, nil
+			}
+			return nil, ErrNilNodePublicKey
+		})
+		defer SetNodeKeyResolver(nil)
+
+		valid, err = p.VerifyLeader()
+		So(err, ShouldBeNil)
+		So(valid, ShouldBeTrue)
+
+		// ed25519 leader
+		edPeers := newPeers(edLeader)
+		So(edPeers.SignTyped(edLeaderKey), ShouldBeNil)
+		valid, err = edPeers.VerifyLeader()
+		So(err, ShouldBeNil)
+		So(valid, ShouldBeTrue)
+
+		// self consistent peers signed by a node other than leader
+		forged := newPeers(leader)
+		So(forged.Sign(otherKey), ShouldBeNil)
+		So(forged.Verify(), ShouldBeNil)
+		valid, err = forged.VerifyLeader()
+		So(err, ShouldBeNil)
+		So(valid, ShouldBeFalse)
+
+		// tampered after signing
+		p.Servers = append(p.Servers, edLeader)
+		valid, err = p.VerifyLeader()
+		So(err, ShouldBeNil)
+		So(valid, ShouldBeFalse)
+
+		// unknown leader fails closed
+		unknown := newPeers(NodeID("0000000000000000000000000000000000000000000000000000000000000001"))
+		So(unknown.Sign(otherKey), ShouldBeNil)
+		valid, err = unknown.VerifyLeader()
+		So(err, ShouldNotBeNil)
+		So(valid, ShouldBeFalse)
+
+		// the declared algorithm must be the key type of leader
+		mismatched := edPeers.Clone()
+		mismatched.SigneeKeyType = asymmetric.Secp256k1
+		valid, err = mismatched.VerifyLeader()
+		So(errors.Cause(err), ShouldEqual, ErrSignatureAlgorithm)
+		So(valid, ShouldBeFalse)
+
+		// unsigned and unknown layout version
+		valid, err = newPeers(leader).VerifyLeader()
+		So(err, ShouldBeNil)
+		So(valid, ShouldBeFalse)
+		future := newPeers(leader)
+		So(future.Sign(leaderKey), ShouldBeNil)
+		future.Version = PeersVersion + 1
+		valid, err = future.VerifyLeader()
+		So(err, ShouldEqual, ErrUnsupportedPeersVersion)
+		So(valid, ShouldBeFalse)
+		So(future.Sign(leaderKey), ShouldEqual, ErrUnsupportedPeersVersion)
+	})
+}
+
+func TestPeersMembership(t *testing.T) {
+	Convey("membership changes require signing again", t, func() {
+		privKey, _, err := asymmetric.GenSecp256k1KeyPair()
+		So(err, ShouldBeNil)
+		var (
+			n1 = NodeID("00000bef611d346c0cbe1beaa76e7f0ed705a194fdf9ac3a248ec70e9c198bf9")
+			n2 = NodeID("00000381d46fd6cf7742d7fb94e2422033af989c0e348b5781b3219599a3af35")
+			n3 = NodeID("0000000000000000000000000000000000000000000000000000000000000001")
+		)
+		p := &Peers{
+			PeersHeader: PeersHeader{
+				Term:    1,
+				Leader:  n1,
+				Servers: []NodeID{n1, n2},
+			},
+		}
+		So(p.Sign(privKey), ShouldBeNil)
+		So(p.IsDirty(), ShouldBeFalse)
+
+		So(p.AddServer(n2), ShouldEqual, ErrServerExists)
+		So(p.RemoveServer(n3), ShouldEqual, ErrServerNotFound)
+		So(p.RemoveServer(n1), ShouldEqual, ErrRemoveLeader)
+		So(p.SetLeader(n3), ShouldEqual, ErrServerNotFound)
+		So(p.SetLeader(n1), ShouldBeNil)
+		// failed and no-op changes keep the signature valid
+		So(p.Term, ShouldEqual, 1)
+		So(p.IsDirty(), ShouldBeFalse)
+		So(p.Verify(), ShouldBeNil)
+
+		So(p.AddServer(n3), ShouldBeNil)
+		So(p.Servers, ShouldResemble, []NodeID{n1, n2, n3})
+		So(p.Term, ShouldEqual, 2)
+		So(p.IsDirty(), ShouldBeTrue)
+		So(p.Verify(), ShouldEqual, ErrPeersNotSigned)
+		clone := p.Clone()
+		So(clone.Verify(), ShouldEqual, ErrPeersNotSigned)
+
+		So(p.SetLeader(n3), ShouldBeNil)
+		So(p.RemoveServer(n1), ShouldBeNil)
+		So(p.Servers, ShouldResemble, []NodeID{n2, n3})
+		So(p.Leader, ShouldEqual, n3)
+		So(p.Term, ShouldEqual, 4)
+		So(p.Verify(), ShouldEqual, ErrPeersNotSigned)
+		// the previous servers slice is not touched
+		So(clone.Servers, ShouldResemble, []NodeID{n1, n2, n3})
+
+		So(p.Sign(privKey), ShouldBeNil)
+		So(p.IsDirty(), ShouldBeFalse)
+		So(p.Verify(), ShouldBeNil)
+
+		// the dirty flag is not encoded, the hash check still rejects the change
+		So(p.AddServer(n1), ShouldBeNil)
+		buf, err := utils.EncodeMsgPack(p)
+		So(err, ShouldBeNil)
+		var decoded *Peers
+		So(utils.DecodeMsgPack(buf.Bytes(), &decoded), ShouldBeNil)
+		So(decoded.IsDirty(), ShouldBeFalse)
+		So(decoded.Verify(), ShouldNotBeNil)
+	})
+}
+
+func TestPeersVerifyCache(t *testing.T) {
+	Convey("the verify cache never accepts tampered peers", t, func() {
+		var (
+			n1 = NodeID("00000bef611d346c0cbe1beaa76e7f0ed705a194fdf9ac3a248ec70e9c198bf9")
+			n2 = NodeID("00000381d46fd6cf7742d7fb94e2422033af989c0e348b5781b3219599a3af35")
+			n3 = NodeID("0000000000000000000000000000000000000000000000000000000000000001")
+		)
+		newPeers := func() *Peers {
+			return &Peers{
+				PeersHeader: PeersHeader{
+					Version: PeersVersion1,
+					Term:    1,
+					Leader:  n1,
+					Servers: []NodeID{n1, n2},
+				},
+				Observers: []NodeID{n3},
+			}
+		}
+		cached := func(p *Peers) bool {
+			key, err := p.verifyCacheKey()
+			So(err, ShouldBeNil)
+			return peersVerifyCache.Contains(key)
+		}
+		secpKey, _, err := asymmetric.GenSecp256k1KeyPair()
+		So(err, ShouldBeNil)
+		edKey, _, err := asymmetric.GenEd25519KeyPair()
+		So(err, ShouldBeNil)
+
+		p := newPeers()
+		So(p.Sign(secpKey), ShouldBeNil)
+		So(cached(p), ShouldBeFalse)
+		So(p.Verify(), ShouldBeNil)
+		So(cached(p), ShouldBeTrue)
+		So(p.Verify(), ShouldBeNil)
+		So(p.Clone().Verify(), ShouldBeNil)
+
+		// the signed content is hashed again on every verification
+		p.Servers[1] = n3
+		So(errors.Cause(p.Verify()), ShouldEqual, verifier.ErrHashValueNotMatch)
+		p.Servers[1] = n2
+		p.Observers[0] = n2
+		So(errors.Cause(p.Verify()), ShouldEqual, verifier.ErrHashValueNotMatch)
+		p.Observers[0] = n3
+		So(p.Verify(), ShouldBeNil)
+
+		// the signature is part of the key
+		p.Signature.S.Add(p.Signature.S, big.NewInt(1))
+		So(cached(p), ShouldBeFalse)
+		So(errors.Cause(p.Verify()), ShouldEqual, verifier.ErrSignatureNotMatch)
+		p.Signature.S.Sub(p.Signature.S, big.NewInt(1))
+		So(p.Verify(), ShouldBeNil)
+
+		// a membership change is rejected before the cache
+		So(p.AddServer(n3), ShouldBeNil)
+		So(p.Verify(), ShouldEqual, ErrPeersNotSigned)
+
+		ed := newPeers()
+		So(ed.SignTyped(edKey), ShouldBeNil)
+		So(ed.Verify(), ShouldBeNil)
+		So(cached(ed), ShouldBeTrue)
+		ed.TypedSignature[0] ^= 0xff
+		So(cached(ed), ShouldBeFalse)
+		So(errors.Cause(ed.Verify()), ShouldEqual, verifier.ErrSignatureNotMatch)
+		ed.TypedSignature[0] ^= 0xff
+		_, otherKey, err := asymmetric.GenEd25519KeyPair()
+		So(err, ShouldBeNil)
+		ed.TypedSignee = otherKey.Serialize()
+		So(errors.Cause(ed.Verify()), ShouldEqual, verifier.ErrSignatureNotMatch)
+	})
+}
+
+func TestPeersMaxServers(t *testing.T) {
+	Convey("the servers are bounded on adding and signing", t, func() {
+		defer SetMaxServers(0)
+		privKey, _, err := asymmetric.GenSecp256k1KeyPair()
+		So(err, ShouldBeNil)
+		var (
+			n1 = NodeID("0000000000000000000000000000000000000000000000000000000000000001")
+			n2 = NodeID("0000000000000000000000000000000000000000000000000000000000000002")
+			n3 = NodeID("0000000000000000000000000000000000000000000000000000000000000003")
+		)
+		So(MaxServers(), ShouldEqual, DefaultMaxServers)
+		SetMaxServers(2)
+		So(MaxServers(), ShouldEqual, 2)
+
+		p := &Peers{PeersHeader: PeersHeader{Term: 1, Leader: n1, Servers: []NodeID{n1}}}
+		So(p.AddServer(n2), ShouldBeNil)
+		So(errors.Cause(p.AddServer(n3)), ShouldEqual, ErrTooManyServers)
+		So(p.Servers, ShouldResemble, []NodeID{n1, n2})
+		So(p.Sign(privKey), ShouldBeNil)
+
+		// a bloated list is never signed
+		p.Servers = append(p.Servers, n3)
+		So(errors.Cause(p.CheckServers()), ShouldEqual, ErrTooManyServers)
+		So(errors.Cause(p.Sign(privKey)), ShouldEqual, ErrTooManyServers)
+		edKey, _, err := asymmetric.GenEd25519KeyPair()
+		So(err, ShouldBeNil)
+		So(errors.Cause(p.SignTyped(edKey)), ShouldEqual, ErrTooManyServers)
+
+		SetMaxServers(0)
+		So(MaxServers(), ShouldEqual, DefaultMaxServers)
+		So(p.Sign(privKey), ShouldBeNil)
+		So(p.Verify(), ShouldBeNil)
+	})
+}
+
+func TestPeersThresholdSignature(t *testing.T) {
+	Convey("membership changes approved by a threshold of servers", t, func() {
+		var (
+			ids     []NodeID
+			privs   []asymmetric.TypedPrivateKey
+			keys    = make(map[NodeID]asymmetric.TypedPublicKey)
+			unknown = NodeID("0000000000000000000000000000000000000000000000000000000000000009")
+		)
+		for i := 1; i <= 5; i++ {
+			var priv asymmetric.TypedPrivateKey
+			if i%2 == 0 {
+				edKey, _, err := asymmetric.GenEd25519KeyPair()
+				So(err, ShouldBeNil)
+				priv = edKey
+			} else {
+				secpKey, _, err := asymmetric.GenSecp256k1KeyPair()
+				So(err, ShouldBeNil)
+				priv = secpKey
+			}
+			id := NodeID(strings.Repeat("0", 63) + strconv.Itoa(i))
+			ids = append(ids, id)
+			privs = append(privs, priv)
+			keys[id] = priv.TypedPubKey()
+		}
+		p := &Peers{
+			PeersHeader: PeersHeader{Version: PeersVersion1, Term: 1, Leader: ids[0], Servers: ids},
+			Observers:   []NodeID{unknown},
+		}
+		sign := func(p *Peers, i int) []byte {
+			h, err := p.SigningHash()
+			So(err, ShouldBeNil)
+			sig, err := privs[i].SignBytes(h[:])
+			So(err, ShouldBeNil)
+			return sig
+		}
+
+		SetNodeKeyResolver(nil)
+		_, err := p.VerifyThreshold(3)
+		So(err, ShouldEqual, ErrNoNodeKeyResolver)
+		SetNodeKeyResolver(func(id NodeID) (asymmetric.TypedPublicKey, error) {
+			if key, ok := keys[id]; ok {
+				return key, nil
+			}
+			return nil, ErrNilNodePublicKey
+		})
+		defer SetNodeKeyResolver(nil)
+
+		for _, threshold := range []int{0, 6} {
+			_, err = p.VerifyThreshold(threshold)
+			So(errors.Cause(err), ShouldEqual, ErrInvalidThreshold)
+		}
+		So(p.AddSignature(ids[0], privs[0].KeyType(), nil), ShouldEqual, ErrEmptySignature)
+
+		// the same payload is signed by all signers, a node is counted once
+		So(p.AddSignature(ids[0], privs[0].KeyType(), sign(p, 0)), ShouldBeNil)
+		So(p.AddSignature(ids[1], privs[1].KeyType(), sign(p, 1)), ShouldBeNil)
+		So(p.AddSignature(ids[1], privs[1].KeyType(), sign(p, 1)), ShouldBeNil)
+		So(p.Signatures, ShouldHaveLength, 2)
+		valid, err := p.VerifyThreshold(3)
+		So(err, ShouldBeNil)
+		So(valid, ShouldBeFalse)
+
+		// the non voting, foreign or invalid signatures are not counted
+		So(p.AddSignature(unknown, privs[2].KeyType(), sign(p, 2)), ShouldBeNil)
+		So(p.AddSignature(ids[3], privs[2].KeyType(), sign(p, 2)), ShouldBeNil)
+		p.Signatures = append(p.Signatures, p.Signatures[0])
+		valid, err = p.VerifyThreshold(3)
+		So(err, ShouldBeNil)
+		So(valid, ShouldBeFalse)
+
+		// a signature declaring another algorithm than the node key is not counted
+		So(p.AddSignature(ids[4], asymmetric.Ed25519, sign(p, 4)), ShouldBeNil)
+		valid, err = p.VerifyThreshold(3)
+		So(err, ShouldBeNil)
+		So(valid, ShouldBeFalse)
+
+		So(p.AddSignature(ids[4], privs[4].KeyType(), sign(p, 4)), ShouldBeNil)
+		valid, err = p.VerifyThreshold(3)
+		So(err, ShouldBeNil)
+		So(valid, ShouldBeTrue)
+		valid, err = p.VerifyThreshold(4)
+		So(err, ShouldBeNil)
+		So(valid, ShouldBeFalse)
+
+		// the signatures survive encoding
+		buf, err := utils.EncodeMsgPack(p)
+		So(err, ShouldBeNil)
+		var decoded *Peers
+		So(utils.DecodeMsgPack(buf.Bytes(), &decoded), ShouldBeNil)
+		valid, err = decoded.VerifyThreshold(3)
+		So(err, ShouldBeNil)
+		So(valid, ShouldBeTrue)
+		cloned := p.Clone()
+		cloned.Signatures[0].Signature[0] ^= 0xff
+		valid, err = p.VerifyThreshold(3)
+		So(err, ShouldBeNil)
+		So(valid, ShouldBeTrue)
+
+		// tampering or membership changes invalidate the approvals
+		decoded.Observers = nil
+		valid, err = decoded.VerifyThreshold(3)
+		So(err, ShouldBeNil)
+		So(valid, ShouldBeFalse)
+		So(p.RemoveServer(ids[2]), ShouldBeNil)
+		So(p.Signatures, ShouldBeEmpty)
+	})
+}
+
+func TestPeersObservers(t *testing.T) {
+	Convey("observers are not signed and not servers", t, func() {
+		privKey, _, err := asymmetric.GenSecp256k1KeyPair()
+		So(err, ShouldBeNil)
+		var (
+			n1 = NodeID("00000bef611d346c0cbe1beaa76e7f0ed705a194fdf9ac3a248ec70e9c198bf9")
+			n2 = NodeID("00000381d46fd6cf7742d7fb94e2422033af989c0e348b5781b3219599a3af35")
+			o1 = NodeID("0000000000000000000000000000000000000000000000000000000000000001")
+		)
+		p := &Peers{
+			PeersHeader: PeersHeader{
+				Term:    1,
+				Leader:  n1,
+				Servers: []NodeID{n1, n2},
+			},
+		}
+		So(p.Sign(privKey), ShouldBeNil)
+		signature := p.Signature
+
+		p.Observers = []NodeID{o1}
+		So(p.Verify(), ShouldBeNil)
+		So(p.Sign(privKey), ShouldBeNil)
+		So(p.Signature.Serialize(), ShouldResemble, signature.Serialize())
+		So(p.IsObserver(o1), ShouldBeTrue)
+		So(p.IsObserver(n1), ShouldBeFalse)
+		_, found := p.Find(o1)
+		So(found, ShouldBeFalse)
+
+		clone := p.Clone()
+		So(clone.Observers, ShouldResemble, p.Observers)
+		clone.Observers[0] = n2
+		So(p.Observers[0], ShouldEqual, o1)
+
+		buf, err := utils.EncodeMsgPack(p)
+		So(err, ShouldBeNil)
+		var decoded *Peers
+		So(utils.DecodeMsgPack(buf.Bytes(), &decoded), ShouldBeNil)
+		So(decoded.Observers, ShouldResemble, p.Observers)
+		So(decoded.Verify(), ShouldBeNil)
+	})
+}
+
+// peersVersion0Blob is the msgpack of a secp256k1 signed PeersVersion0 peers with
+// an observer, captured before PeersVersion1 was introduced.
+const peersVersion0Blob = "8ba84461746148617368c42047bc1d07482ce6ef914d01cbfa253fcd911f06092db3f06134177188" +
+	"93c4a496a64c6561646572c420f98b199c0ec78e243aacf9fd94a105d70e7f6ea7ea1bbe0c6c341d" +
+	"61ef0b0000a94f627365727665727391c420de4ae6e7345626325ac1078aa8480dec149cd58e69e9" +
+	"4ada858f0462aa050000a75365727665727392c420f98b199c0ec78e243aacf9fd94a105d70e7f6e" +
+	"a7ea1bbe0c6c341d61ef0b0000c42035afa3999521b381578b340e9c98af332042e294fbd74277cf" +
+	"d66fd481030000a95369676e6174757265c446304402205b60fdfb40c3f5f646d679ee5e4053584c" +
+	"d34be0fc9a72ef74261ba8220fcfb70220310e0882b1b5e1e1c9f190016d5d54ba3edd92e137de33" +
+	"93fe0ec7af68fd7a37a65369676e6565c42103892b8e6a3dbc097d628fe1a895c1d0ea82e4c6cc9f" +
+	"300d47b5a75809b400b571ad5369676e65654b65795479706500a45465726d07ae54797065645369" +
+	"676e6174757265c0ab54797065645369676e6565c0a756657273696f6e00"
+
+func TestPeersVersion(t *testing.T) {
+	var (
+		n1 = NodeID("00000bef611d346c0cbe1beaa76e7f0ed705a194fdf9ac3a248ec70e9c198bf9")
+		n2 = NodeID("00000381d46fd6cf7742d7fb94e2422033af989c0e348b5781b3219599a3af35")
+		o1 = NodeID("000005aa62048f85da4ae9698ed59c14ec0d48a88a07c15a32265634e7e64ade")
+	)
+	Convey("captured version 0 peers decodes and verifies", t, func() {
+		blob, err := hex.DecodeString(peersVersion0Blob)
+		So(err, ShouldBeNil)
+		var peers *Peers
+		So(utils.DecodeMsgPack(blob, &peers), ShouldBeNil)
+		So(peers.Version, ShouldEqual, PeersVersion0)
+		So(peers.Term, ShouldEqual, 7)
+		So(peers.Leader, ShouldEqual, n1)
+		So(peers.Servers, ShouldResemble, []NodeID{n1, n2})
+		So(peers.Observers, ShouldResemble, []NodeID{o1})
+		So(peers.Verify(), ShouldBeNil)
+
+		_, leaderKey := asymmetric.PrivKeyFromBytes([]byte("peers version 0 capture key 0001"))
+		SetNodeKeyResolver(func(id NodeID) (asymmetric.TypedPublicKey, error) {
+			return leaderKey, nil
+		})
+		defer SetNodeKeyResolver(nil)
+		valid, err := peers.VerifyLeader()
+		So(err, ShouldBeNil)
+		So(valid, ShouldBeTrue)
+
+		// the observers are not signed in version 0
+		peers.Observers = nil
+		So(peers.Verify(), ShouldBeNil)
+	})
+	Convey("version 1 signs the observers in its own layout", t, func() {
+		privKey, _, err := asymmetric.GenSecp256k1KeyPair()
+		So(err, ShouldBeNil)
+		p := &Peers{
+			PeersHeader: PeersHeader{
+				Version: PeersVersion1,
+				Term:    1,
+				Leader:  n1,
+				Servers: []NodeID{n1, n2},
+			},
+			Observers: []NodeID{o1},
+		}
+		So(p.Sign(privKey), ShouldBeNil)
+		So(p.Verify(), ShouldBeNil)
+
+		buf, err := utils.EncodeMsgPack(p)
+		So(err, ShouldBeNil)
+		var decoded *Peers
+		So(utils.DecodeMsgPack(buf.Bytes(), &decoded), ShouldBeNil)
+		So(decoded.Verify(), ShouldBeNil)
+		data, err := json.Marshal(p)
+		So(err, ShouldBeNil)
+		var fromJSON Peers
+		So(json.Unmarshal(data, &fromJSON), ShouldBeNil)
+		So(fromJSON.Verify(), ShouldBeNil)
+
+		decoded.Observers = nil
+		So(decoded.Verify(), ShouldNotBeNil)
+		// a signature is only valid in the layout it is made for
+		fromJSON.Version = PeersVersion0
+		So(fromJSON.Verify(), ShouldNotBeNil)
+
+		// same header in different versions is ordered consistently
+		v0 := p.Clone()
+		v0.Version = PeersVersion0
+		So(v0.IsNewerThan(p), ShouldNotEqual, p.IsNewerThan(v0))
+	})
+}
+
+func TestPeersQuorum(t *testing.T) {
+	Convey("quorum of voting servers", t, func() {
+		var ids []NodeID
+		for i := 0; i < 7; i++ {
+			ids = append(ids, NodeID(strings.Repeat("0", 63)+strconv.Itoa(i+1)))
+		}
+		So((&Peers{}).Quorum(), ShouldEqual, 0)
+		So((&Peers{}).HasQuorum(map[NodeID]bool{ids[0]: true}), ShouldBeFalse)
+		for n, quorum := range []int{1, 2, 2, 3, 3, 4, 4} {
+			p := &Peers{PeersHeader: PeersHeader{Leader: ids[0], Servers: ids[:n+1]}}
+			So(p.Quorum(), ShouldEqual, quorum)
+
+			acks := make(map[NodeID]bool)
+			for _, id := range ids[:quorum-1] {
+				acks[id] = true
+			}
+			So(p.HasQuorum(acks), ShouldBeFalse)
+			acks[ids[quorum-1]] = true
+			So(p.HasQuorum(acks), ShouldBeTrue)
+		}
+
+		p := &Peers{
+			PeersHeader: PeersHeader{
+				Leader:  ids[0],
+				Servers: []NodeID{ids[0], ids[1], ids[0], ids[1]},
+			},
+			Observers: ids[2:],
+		}
+		// duplicate servers and observers do not inflate the count
+		So(p.Quorum(), ShouldEqual, 2)
+		acks := map[NodeID]bool{ids[0]: true, ids[2]: true, ids[3]: true, ids[4]: true}
+		So(p.HasQuorum(acks), ShouldBeFalse)
+		acks[ids[1]] = false
+		So(p.HasQuorum(acks), ShouldBeFalse)
+		acks[ids[1]] = true
+		So(p.HasQuorum(acks), ShouldBeTrue)
+		So(p.HasQuorum(nil), ShouldBeFalse)
+	})
+}
+
+func TestPeersTerm(t *testing.T) {
+	Convey("advance term and compare peers", t, func() {
+		privKey, _, err := asymmetric.GenSecp256k1KeyPair()
+		So(err, ShouldBeNil)
+		var (
+			n1 = NodeID("00000bef611d346c0cbe1beaa76e7f0ed705a194fdf9ac3a248ec70e9c198bf9")
+			n2 = NodeID("00000381d46fd6cf7742d7fb94e2422033af989c0e348b5781b3219599a3af35")
+			n3 = NodeID("0000000000000000000000000000000000000000000000000000000000000001")
+		)
+		p := &Peers{
+			PeersHeader: PeersHeader{
+				Term:    1,
+				Leader:  n1,
+				Servers: []NodeID{n1, n2},
+			},
+		}
+		So(p.Sign(privKey), ShouldBeNil)
+		old := p.Clone()
+
+		So(p.AdvanceTerm(n3), ShouldEqual, ErrServerNotFound)
+		So(p.Term, ShouldEqual, 1)
+		So(p.Verify(), ShouldBeNil)
+
+		So(p.AdvanceTerm(n2), ShouldBeNil)
+		So(p.Term, ShouldEqual, 2)
+		So(p.Leader, ShouldEqual, n2)
+		So(p.Verify(), ShouldEqual, ErrPeersNotSigned)
+		So(p.Sign(privKey), ShouldBeNil)
+		So(p.Verify(), ShouldBeNil)
+		// re-election of the same leader is a new term
+		So(p.AdvanceTerm(n2), ShouldBeNil)
+		So(p.Term, ShouldEqual, 3)
+		So(p.Sign(privKey), ShouldBeNil)
+
+		So(p.IsNewerThan(old), ShouldBeTrue)
+		So(old.IsNewerThan(p), ShouldBeFalse)
+		So(p.IsNewerThan(p), ShouldBeFalse)
+		So(p.IsNewerThan(nil), ShouldBeTrue)
+
+		// same term is ordered by the header hash
+		forked := old.Clone()
+		So(forked.SetLeader(n2), ShouldBeNil)
+		So(forked.Sign(privKey), ShouldBeNil)
+		old.Term = forked.Term
+		So(old.Sign(privKey), ShouldBeNil)
+		So(old.IsNewerThan(forked), ShouldNotEqual, forked.IsNewerThan(old))
+
+		p.Term = math.MaxUint64
+		So(p.AdvanceTerm(n1), ShouldEqual, ErrStaleTerm)
+		So(p.Term, ShouldEqual, uint64(math.MaxUint64))
+	})
+}
+
+func TestPeersClone(t *testing.T) {
+	Convey("clone shares no memory with the original", t, func() {
+		privKey, _, err := asymmetric.GenSecp256k1KeyPair()
+		So(err, ShouldBeNil)
+		var (
+			n1 = NodeID("00000bef611d346c0cbe1beaa76e7f0ed705a194fdf9ac3a248ec70e9c198bf9")
+			n2 = NodeID("00000381d46fd6cf7742d7fb94e2422033af989c0e348b5781b3219599a3af35")
+			n3 = NodeID("0000000000000000000000000000000000000000000000000000000000000001")
+		)
+		// spare capacity so an aliased append would write the original backing array
+		servers := make([]NodeID, 2, 4)
+		servers[0], servers[1] = n1, n2
+		p := &Peers{
+			PeersHeader: PeersHeader{
+				Term:    1,
+				Leader:  n1,
+				Servers: servers,
+			},
+			Observers: []NodeID{n3},
+		}
+		So(p.Sign(privKey), ShouldBeNil)
+		var (
+			signature = p.Signature.Serialize()
+			signee    = p.Signee.Serialize()
+		)
+
+		clone := p.Clone()
+		So(clone, ShouldResemble, p)
+		So(clone.Verify(), ShouldBeNil)
+
+		clone.Servers[1] = n3
+		clone.Servers = append(clone.Servers, n3)
+		clone.Observers[0] = n1
+		clone.Signature.R.SetInt64(1)
+		clone.Signee.X.SetInt64(1)
+		clone.DataHash[0]++
+		clone.Term++
+
+		So(p.Servers, ShouldResemble, []NodeID{n1, n2})
+		So(servers[:cap(servers)], ShouldResemble, []NodeID{n1, n2, "", ""})
+		So(p.Observers, ShouldResemble, []NodeID{n3})
+		So(p.Signature.Serialize(), ShouldResemble, signature)
+		So(p.Signee.Serialize(), ShouldResemble, signee)
+		So(p.Term, ShouldEqual, 1)
+		So(p.Verify(), ShouldBeNil)
+
+		// typed signature and dirty state
+		edPrivate, _, err := asymmetric.GenEd25519KeyPair()
+		So(err, ShouldBeNil)
+		So(p.SignTyped(edPrivate), ShouldBeNil)
+		typedSignature := append([]byte(nil), p.TypedSignature...)
+		clone = p.Clone()
+		clone.TypedSignature[0]++
+		clone.TypedSignee[0]++
+		So(p.TypedSignature, ShouldResemble, typedSignature)
+		So(p.Verify(), ShouldBeNil)
+		So(p.AddServer(n3), ShouldBeNil)
+		clone = p.Clone()
+		So(clone.IsDirty(), ShouldBeTrue)
+		So(p.SignTyped(edPrivate), ShouldBeNil)
+		So(clone.IsDirty(), ShouldBeTrue)
+
+		var nilPeers *Peers
+		So(nilPeers.Clone(), ShouldBeNil)
+		So((&Peers{}).Clone(), ShouldResemble, &Peers{})
+	})
+}
+
+func TestPeersAcceptInto(t *testing.T) {
+	Convey("adopted peers never roll back the term", t, func() {
+		var (
+			ids   []NodeID
+			privs []*asymmetric.PrivateKey
+			keys  = make(map[NodeID]asymmetric.TypedPublicKey)
+		)
+		for i := 1; i <= 3; i++ {
+			priv, pub, err := asymmetric.GenSecp256k1KeyPair()
+			So(err, ShouldBeNil)
+			id := NodeID(strings.Repeat("0", 63) + strconv.Itoa(i))
+			ids = append(ids, id)
+			privs = append(privs, priv)
+			keys[id] = pub
+		}
+		SetNodeKeyResolver(func(id NodeID) (asymmetric.TypedPublicKey, error) {
+			if key, ok := keys[id]; ok {
+				return key, nil
+			}
+			return nil, ErrNilNodePublicKey
+		})
+		defer SetNodeKeyResolver(nil)
+		newPeers := func(term uint64, leader NodeID) *Peers {
+			p := &Peers{PeersHeader: PeersHeader{Term: term, Leader: leader, Servers: ids}}
+			So(p.Sign(privs[0]), ShouldBeNil)
+			return p
+		}
+		current := newPeers(5, ids[0])
+
+		// higher term
+		So(newPeers(6, ids[1]).AcceptInto(current), ShouldBeNil)
+		So(newPeers(1, ids[1]).AcceptInto(nil), ShouldBeNil)
+
+		// equal and lower terms
+		for _, term := range []uint64{5, 4, 0} {
+			err := newPeers(term, ids[1]).AcceptInto(current)
+			So(errors.Cause(err), ShouldEqual, ErrStaleTerm)
+			var stale *StaleTermError
+			So(errors.As(err, &stale), ShouldBeTrue)
+			So(stale.Current, ShouldEqual, 5)
+			So(stale.Incoming, ShouldEqual, term)
+		}
+		So(current.AcceptInto(current), ShouldNotBeNil)
+
+		// unsigned changes are rejected whatever the term
+		dirty := newPeers(6, ids[0])
+		So(dirty.SetLeader(ids[1]), ShouldBeNil)
+		So(dirty.AcceptInto(current), ShouldEqual, ErrPeersNotSigned)
+
+		// a lower term list approved by the quorum over its own SigningHash is a replay
+		override := newPeers(3, ids[2])
+		for i := 0; i < 3; i++ {
+			h, err := override.SigningHash()
+			So(err, ShouldBeNil)
+			sig, err := privs[i].SignBytes(h[:])
+			So(err, ShouldBeNil)
+			So(override.AddSignature(ids[i], asymmetric.Secp256k1, sig), ShouldBeNil)
+		}
+		ok, err := override.VerifyThreshold(3)
+		So(err, ShouldBeNil)
+		So(ok, ShouldBeTrue)
+		So(errors.Cause(override.AcceptInto(current)), ShouldEqual, ErrStaleTerm)
+
+		// an override needs the quorum of the current servers over current
+		approve := func(over *Peers, i int) {
+			h, err := override.OverrideHash(over)
+			So(err, ShouldBeNil)
+			sig, err := privs[i].SignBytes(h[:])
+			So(err, ShouldBeNil)
+			So(override.AddApproval(ids[i], asymmetric.Secp256k1, sig), ShouldBeNil)
+		}
+		approve(current, 0)
+		So(errors.Cause(override.AcceptInto(current)), ShouldEqual, ErrStaleTerm)
+		approve(current, 1)
+		So(override.AcceptInto(current), ShouldBeNil)
+		// the approvals are kept apart from the threshold signatures of the same nodes
+		ok, err = override.VerifyThreshold(3)
+		So(err, ShouldBeNil)
+		So(ok, ShouldBeTrue)
+		So(override.Signatures, ShouldHaveLength, 3)
+		So(override.Approvals, ShouldHaveLength, 2)
+		So(override.Clone().Approvals, ShouldResemble, override.Approvals)
+
+		// the approval is void over any other current peers
+		So(errors.Cause(override.AcceptInto(newPeers(6, ids[0]))), ShouldEqual, ErrStaleTerm)
+		So(errors.Cause(override.AcceptInto(newPeers(5, ids[1]))), ShouldEqual, ErrStaleTerm)
+
+		// the approvals count against the current servers, not the ones of the override
+		other := &Peers{PeersHeader: PeersHeader{Term: 5, Leader: ids[0], Servers: []NodeID{ids[0],
+			"00000000000000000000000000000000000000000000000000000000000000fe",
+			"00000000000000000000000000000000000000000000000000000000000000ff"}}}
+		So(other.Sign(privs[0]), ShouldBeNil)
+		approve(other, 0)
+		approve(other, 1)
+		So(errors.Cause(override.AcceptInto(other)), ShouldEqual, ErrStaleTerm)
+	})
+}
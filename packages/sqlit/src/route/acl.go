@@ -114,6 +114,8 @@ const (
 	SQLChainRPCName = "SQLC"
 	// DBRPCName defines the sql chain db service rpc name
 	DBRPCName = "DBS"
+	// AdminRPCName defines the sqlitd admin rpc name
+	AdminRPCName = "ADM"
 )
 
 // String returns the RemoteFunc string.
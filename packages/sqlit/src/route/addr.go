@@ -0,0 +1,75 @@
+
+package route
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidNodeAddr indicates the node address is not of "host:port" form, an IPv6
+// host must be bracketed like "[::1]:4661".
+var ErrInvalidNodeAddr = errors.New("invalid node addr")
+
+// NormalizeAddr validates the node address of "host:port" form and returns it in the
+// canonical form: IP hosts are formatted by net.IP and IPv6 ones are bracketed with
+// the zone kept as it is, host names are lowercased without the trailing dots. So the
+// equivalent addresses share a cache entry. An empty address means no address and is
+// returned as it is.
+func NormalizeAddr(addr string) (normalized string, err error) {
+	if addr == "" {
+		return
+	}
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		if !strings.HasPrefix(addr, "[") && strings.Count(addr, ":") > 1 {
+			return "", fmt.Errorf("%w %q: IPv6 host must be bracketed", ErrInvalidNodeAddr, addr)
+		}
+		return "", fmt.Errorf("%w %q: %v", ErrInvalidNodeAddr, addr, err)
+	}
+	if host = canonicalHost(host); host == "" {
+		return "", fmt.Errorf("%w %q: missing host", ErrInvalidNodeAddr, addr)
+	}
+	if port == "" {
+		return "", fmt.Errorf("%w %q: missing port", ErrInvalidNodeAddr, addr)
+	}
+	if _, err = strconv.ParseUint(port, 10, 16); err != nil {
+		return "", fmt.Errorf("%w %q: invalid port %q", ErrInvalidNodeAddr, addr, port)
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+// canonicalHost returns the canonical form of host for NormalizeAddr, it is empty if
+// nothing is left.
+func canonicalHost(host string) string {
+	ip, zone, _ := strings.Cut(host, "%")
+	if parsed := net.ParseIP(ip); parsed != nil {
+		if zone != "" && parsed.To4() == nil {
+			return parsed.String() + "%" + zone
+		}
+		if zone == "" {
+			return parsed.String()
+		}
+	}
+	return strings.ToLower(strings.TrimRight(host, "."))
+}
+
+// normalizeAddrs normalizes the primary address and the alternates of a node, the
+// empty alternates are skipped like proto.MergeAddrs does.
+func normalizeAddrs(addr string, alternates []string) (primary string, others []string, err error) {
+	if primary, err = NormalizeAddr(addr); err != nil {
+		return
+	}
+	for _, alternate := range alternates {
+		var normalized string
+		if normalized, err = NormalizeAddr(alternate); err != nil {
+			return "", nil, err
+		}
+		if normalized != "" {
+			others = append(others, normalized)
+		}
+	}
+	return
+}
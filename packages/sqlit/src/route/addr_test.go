@@ -0,0 +1,102 @@
+
+package route
+
+import (
+	"errors"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"sqlit/src/conf"
+	"sqlit/src/crypto/hash"
+	"sqlit/src/proto"
+)
+
+func TestNormalizeAddr(t *testing.T) {
+	Convey("node addresses are normalized to host:port", t, func() {
+		for addr, expected := range map[string]string{
+			"":                     "",
+			"127.0.0.1:4661":       "127.0.0.1:4661",
+			"[::1]:4661":           "[::1]:4661",
+			"[0:0:0:0::1]:4661":    "[::1]:4661",
+			"[2001:DB8::1]:80":     "[2001:db8::1]:80",
+			"[::ffff:10.0.0.1]:80": "10.0.0.1:80",
+			"[fe80::1%eth0]:4661":  "[fe80::1%eth0]:4661",
+			"[FE80::1%Eth0]:4661":  "[fe80::1%Eth0]:4661",
+			"node.example.com:80":  "node.example.com:80",
+			"Node.Example.COM:80":  "node.example.com:80",
+			"node.example.com.:80": "node.example.com:80",
+			"LOCALHOST.:4661":      "localhost:4661",
+			"localhost:0":          "localhost:0",
+		} {
+			normalized, err := NormalizeAddr(addr)
+			So(err, ShouldBeNil)
+			So(normalized, ShouldEqual, expected)
+		}
+	})
+	Convey("invalid node addresses are rejected", t, func() {
+		for addr, reason := range map[string]string{
+			"::1:4661":     "IPv6 host must be bracketed",
+			"2001:db8::1":  "IPv6 host must be bracketed",
+			"127.0.0.1":    "missing port",
+			"node":         "missing port",
+			"node:":        "missing port",
+			":4661":        "missing host",
+			".:4661":       "missing host",
+			"node:http":    "invalid port",
+			"node:65536":   "invalid port",
+			"[::1]":        "missing port",
+			"[::1]:4661:1": "too many colons",
+			"[::1:4661":    "missing ']'",
+			"10.0.0.1:-1":  "invalid port",
+		} {
+			_, err := NormalizeAddr(addr)
+			So(errors.Is(err, ErrInvalidNodeAddr), ShouldBeTrue)
+			So(err.Error(), ShouldContainSubstring, reason)
+		}
+	})
+}
+
+func TestSetNodeAddrCacheNormalize(t *testing.T) {
+	defer func(saved *conf.Config) { conf.GConf = saved }(conf.GConf)
+	conf.GConf = &conf.Config{}
+
+	Convey("cached addresses are normalized and invalid ones rejected", t, func() {
+		setResolveCache(make(NodeIDAddressMap))
+		var (
+			nodeA = &proto.RawNodeID{Hash: hash.Hash([32]byte{0xcc, 0x01})}
+			nodeB = &proto.RawNodeID{Hash: hash.Hash([32]byte{0xcc, 0x02})}
+		)
+		So(SetNodeAddrCache(nodeA, "[0::1]:4661", "", "[2001:DB8::1]:4661", "a.example.com:4661"), ShouldBeNil)
+		entry, err := GetNodeAddrCacheEntry(nodeA)
+		So(err, ShouldBeNil)
+		So(entry.Addrs, ShouldResemble, []string{"[::1]:4661", "[2001:db8::1]:4661", "a.example.com:4661"})
+
+		// the equivalent addresses are one entry
+		So(SetNodeAddrCache(nodeA, "[0:0::1]:4661", "A.Example.com.:4661", "[::1]:4661", "a.example.com:4661"), ShouldBeNil)
+		entry, err = GetNodeAddrCacheEntry(nodeA)
+		So(err, ShouldBeNil)
+		So(entry.Addrs, ShouldResemble, []string{"[::1]:4661", "a.example.com:4661"})
+
+		// neither the primary nor an alternate may be invalid, the entry is kept
+		err = SetNodeAddrCache(nodeA, "::1:4661")
+		So(errors.Is(err, ErrInvalidNodeAddr), ShouldBeTrue)
+		err = SetNodeAddrCache(nodeA, "127.0.0.1:1", "a.example.com")
+		So(errors.Is(err, ErrInvalidNodeAddr), ShouldBeTrue)
+		addr, err := GetNodeAddrCache(nodeA)
+		So(err, ShouldBeNil)
+		So(addr, ShouldEqual, "[::1]:4661")
+
+		err = SetNodeAddrCacheBatch(map[*proto.RawNodeID]string{
+			nodeA: "10.0.0.1:1",
+			nodeB: "fe80::1:1",
+		})
+		So(errors.Is(err, ErrInvalidNodeAddr), ShouldBeTrue)
+		So(err.Error(), ShouldContainSubstring, string(nodeB.ToNodeID()))
+		addr, err = GetNodeAddrCache(nodeA)
+		So(err, ShouldBeNil)
+		So(addr, ShouldEqual, "10.0.0.1:1")
+		_, err = GetNodeAddrCache(nodeB)
+		So(err, ShouldEqual, ErrUnknownNodeID)
+	})
+}
@@ -0,0 +1,143 @@
+
+package route
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"sqlit/src/conf"
+	"sqlit/src/crypto/kms"
+	"sqlit/src/proto"
+	"sqlit/src/utils/log"
+)
+
+var (
+	// ErrNodeBanned indicates the node is banned by Ban and not routed to.
+	ErrNodeBanned = errors.New("node is banned")
+	// ErrBanLocalNode indicates Ban is called with the local node.
+	ErrBanLocalNode = errors.New("can not ban local node")
+	// ErrBanLeader indicates Ban is called with the leader.
+	ErrBanLeader = errors.New("can not ban leader")
+	// ErrInvalidBanDuration indicates the duration of Ban is not positive.
+	ErrInvalidBanDuration = errors.New("invalid ban duration")
+)
+
+// NodeBan is an active ban of a node.
+type NodeBan struct {
+	ID proto.NodeID
+	// Until is the expiry time of the ban
+	Until time.Time
+}
+
+var (
+	// bans holds the expiry time of the banned nodes, it is kept apart from the probe
+	// state so the probes of a banned node never lift the ban
+	bans     = make(map[proto.NodeID]time.Time)
+	bansLock sync.RWMutex
+)
+
+// Ban stops routing to node id for d, e.g. for sending invalid signatures or bad
+// peers lists: ResolveNodeAddr returns ErrNodeBanned and SelectNode skips it until
+// the ban expires or Unban. A ban of a banned node replaces its expiry. The local
+// node and the leader are never banned.
+func Ban(id proto.NodeID, d time.Duration) (err error) {
+	if d <= 0 {
+		return ErrInvalidBanDuration
+	}
+	if conf.GConf != nil && conf.GConf.ThisNodeID == id {
+		return ErrBanLocalNode
+	}
+	if isLeaderNode(id) {
+		return ErrBanLeader
+	}
+	until := time.Now().Add(d)
+	bansLock.Lock()
+	bans[id] = until
+	bansLock.Unlock()
+	log.WithFields(log.Fields{
+		"node":  id,
+		"until": until,
+	}).Warning("ban node")
+	publishNodeEvent(NodeEvent{Type: NodeBanned, ID: id})
+	return
+}
+
+// Unban lifts the ban of node id, it returns if the node was banned.
+func Unban(id proto.NodeID) (banned bool) {
+	bansLock.Lock()
+	defer bansLock.Unlock()
+	until, ok := bans[id]
+	delete(bans, id)
+	if banned = ok && time.Now().Before(until); banned {
+		log.WithField("node", id).Info("unban node")
+		publishNodeEvent(NodeEvent{Type: NodeUnbanned, ID: id})
+	}
+	return
+}
+
+// IsBanned returns if node id is banned by Ban and the ban is not expired.
+func IsBanned(id proto.NodeID) bool {
+	bansLock.RLock()
+	until, ok := bans[id]
+	bansLock.RUnlock()
+	if !ok {
+		return false
+	}
+	if time.Now().Before(until) {
+		return true
+	}
+	bansLock.Lock()
+	// the node is not banned again meanwhile
+	if bans[id] == until {
+		delete(bans, id)
+	}
+	bansLock.Unlock()
+	return false
+}
+
+// Bans returns the active bans sorted by node id, e.g. for the metrics and health
+// endpoints. The expired bans are dropped.
+func Bans() (active []NodeBan) {
+	now := time.Now()
+	bansLock.Lock()
+	defer bansLock.Unlock()
+	for id, until := range bans {
+		if !now.Before(until) {
+			delete(bans, id)
+			continue
+		}
+		active = append(active, NodeBan{ID: id, Until: until})
+	}
+	sort.Slice(active, func(i, j int) bool {
+		return active[i].ID < active[j].ID
+	})
+	return
+}
+
+// isLeaderNode returns if id is the block producer or a leader of the known nodes in
+// conf.GConf, or the leader of the local peers.
+func isLeaderNode(id proto.NodeID) bool {
+	if conf.GConf != nil {
+		if conf.GConf.BP != nil && conf.GConf.BP.NodeID == id {
+			return true
+		}
+		for _, n := range conf.GConf.KnownNodes {
+			if n.ID == id && n.Role == proto.Leader {
+				return true
+			}
+		}
+	}
+	if peers, err := kms.GetLocalPeers(); err == nil && peers.Leader == id {
+		return true
+	}
+	return false
+}
+
+// resetBans lifts all the bans.
+func resetBans() {
+	bansLock.Lock()
+	defer bansLock.Unlock()
+	bans = make(map[proto.NodeID]time.Time)
+}
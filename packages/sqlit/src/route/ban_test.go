@@ -0,0 +1,100 @@
+
+package route
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"sqlit/src/conf"
+	"sqlit/src/crypto/kms"
+	"sqlit/src/proto"
+)
+
+func TestBan(t *testing.T) {
+	defer func(saved *conf.Config) { conf.GConf = saved }(conf.GConf)
+	defer SetNodeAddrProber(getNodeAddrProber())
+	defer resetBans()
+
+	var (
+		local      = proto.NodeID("00000000000000000000000000000000000000000000000000000000000000b1")
+		leader     = proto.NodeID("00000000000000000000000000000000000000000000000000000000000000b2")
+		bp         = proto.NodeID("00000000000000000000000000000000000000000000000000000000000000b3")
+		miner1     = proto.NodeID("00000000000000000000000000000000000000000000000000000000000000b4")
+		miner2     = proto.NodeID("00000000000000000000000000000000000000000000000000000000000000b5")
+		peerLeader = proto.NodeID("00000000000000000000000000000000000000000000000000000000000000b6")
+	)
+	conf.GConf = &conf.Config{
+		ThisNodeID: local,
+		BP:         &conf.BPInfo{NodeID: bp},
+		KnownNodes: []proto.Node{
+			{ID: leader, Role: proto.Leader, Addr: "10.0.3.2:1"},
+			{ID: miner1, Role: proto.Miner, Addr: "10.0.3.4:1"},
+			{ID: miner2, Role: proto.Miner, Addr: "10.0.3.5:1"},
+		},
+	}
+
+	Convey("the local node and the leaders are not banned", t, func() {
+		resetBans()
+		So(Ban(local, time.Minute), ShouldEqual, ErrBanLocalNode)
+		So(Ban(leader, time.Minute), ShouldEqual, ErrBanLeader)
+		So(Ban(bp, time.Minute), ShouldEqual, ErrBanLeader)
+		So(Ban(miner1, 0), ShouldEqual, ErrInvalidBanDuration)
+
+		kms.SetLocalPeers(&proto.Peers{PeersHeader: proto.PeersHeader{Leader: peerLeader}})
+		defer kms.SetLocalPeers(nil)
+		So(Ban(peerLeader, time.Minute), ShouldEqual, ErrBanLeader)
+		So(Bans(), ShouldBeEmpty)
+	})
+	Convey("the banned nodes are not routed to until the ban expires", t, func() {
+		resetBans()
+		setResolveCache(make(NodeIDAddressMap))
+		for _, n := range conf.GConf.KnownNodes {
+			So(SetNodeAddrCache(n.ID.ToRawNodeID(), n.Addr), ShouldBeNil)
+		}
+		before := time.Now()
+		So(Ban(miner1, time.Minute), ShouldBeNil)
+		So(IsBanned(miner1), ShouldBeTrue)
+		So(IsBanned(miner2), ShouldBeFalse)
+
+		_, err := ResolveNodeAddr(context.Background(), *miner1.ToRawNodeID())
+		So(err, ShouldEqual, ErrNodeBanned)
+		addr, err := ResolveNodeAddr(context.Background(), *miner2.ToRawNodeID())
+		So(err, ShouldBeNil)
+		So(addr, ShouldEqual, "10.0.3.5:1")
+		for i := 0; i < 3; i++ {
+			id, err := SelectNode([]proto.NodeID{miner1, miner2})
+			So(err, ShouldBeNil)
+			So(id, ShouldEqual, miner2)
+		}
+		_, err = SelectNode([]proto.NodeID{miner1})
+		So(err, ShouldEqual, ErrNoReachableNode)
+
+		active := Bans()
+		So(active, ShouldHaveLength, 1)
+		So(active[0].ID, ShouldEqual, miner1)
+		So(active[0].Until, ShouldHappenOnOrBetween, before.Add(time.Minute), time.Now().Add(time.Minute))
+
+		// the probes of the banned node do not lift the ban
+		SetNodeAddrProber(func(ctx context.Context, addr string) error { return nil })
+		_, _ = GetNodeAddrCache(miner1.ToRawNodeID())
+		So(probeNodeAddrCache(context.Background(), conf.RouteProbeInfo{}, time.Now()), ShouldBeGreaterThan, 0)
+		So(IsBanned(miner1), ShouldBeTrue)
+
+		// an expired ban is dropped
+		bansLock.Lock()
+		bans[miner2] = time.Now().Add(-time.Second)
+		bansLock.Unlock()
+		So(IsBanned(miner2), ShouldBeFalse)
+		So(Bans(), ShouldHaveLength, 1)
+
+		So(Unban(miner1), ShouldBeTrue)
+		So(Unban(miner1), ShouldBeFalse)
+		So(IsBanned(miner1), ShouldBeFalse)
+		addr, err = ResolveNodeAddr(context.Background(), *miner1.ToRawNodeID())
+		So(err, ShouldBeNil)
+		So(addr, ShouldEqual, "10.0.3.4:1")
+	})
+}
@@ -0,0 +1,127 @@
+
+package route
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"sqlit/src/proto"
+	"sqlit/src/utils/log"
+)
+
+// cacheFileVersion is the layout version of the route cache file.
+const cacheFileVersion = 1
+
+// cacheFile is the on disk form of the route cache.
+type cacheFile struct {
+	Version int
+	Entries []cacheFileEntry
+}
+
+// cacheFileEntry is the on disk form of a cache entry, ExpireAt is absolute so the
+// time spent offline counts against the TTL.
+type cacheFileEntry struct {
+	ID         string
+	Addrs      []string
+	TTL        time.Duration `json:",omitempty"`
+	ExpireAt   time.Time     `json:",omitempty"`
+	VerifiedAt time.Time     `json:",omitempty"`
+}
+
+// SaveCache writes the node address cache with TTLs to path. The file is replaced
+// atomically so a crash never leaves a partial cache.
+func SaveCache(path string) (err error) {
+	initResolver()
+	var content = cacheFile{Version: cacheFileVersion}
+	resolver.RLock()
+	for id, addr := range resolver.cache {
+		entry := cacheFileEntry{
+			ID:    id.String(),
+			Addrs: resolver.addrs[id],
+		}
+		if len(entry.Addrs) == 0 {
+			entry.Addrs = []string{addr}
+		}
+		if meta, ok := resolver.meta[id]; ok {
+			entry.TTL, entry.ExpireAt, entry.VerifiedAt = meta.ttl, meta.expireAt, meta.verifiedAt
+		}
+		content.Entries = append(content.Entries, entry)
+	}
+	resolver.RUnlock()
+
+	var data []byte
+	if data, err = json.Marshal(&content); err != nil {
+		return errors.Wrap(err, "marshal route cache failed")
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return errors.Wrap(err, "create route cache file failed")
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(data); err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return errors.Wrap(err, "write route cache file failed")
+	}
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		return errors.Wrap(err, "replace route cache file failed")
+	}
+	return
+}
+
+// LoadCache restores the node address cache saved by SaveCache and returns the
+// loaded entry count. It never overrides the cached entries, so the config entries
+// take precedence whether they are set before or after. Expired entries are
+// dropped, a missing file is not an error and a corrupt file is logged and skipped.
+func LoadCache(path string) (loaded int, err error) {
+	initResolver()
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, errors.Wrap(err, "read route cache file failed")
+	}
+	var content cacheFile
+	if err = json.Unmarshal(data, &content); err == nil && content.Version != cacheFileVersion {
+		err = errors.Errorf("unsupported route cache version %d", content.Version)
+	}
+	if err != nil {
+		log.WithField("file", path).WithError(err).Warning("skip corrupt route cache file")
+		return 0, nil
+	}
+
+	now := time.Now()
+	resolver.Lock()
+	defer resolver.Unlock()
+	for _, entry := range content.Entries {
+		id := proto.NodeID(entry.ID)
+		var addrs []string
+		if _, normalized, addrErr := normalizeAddrs("", entry.Addrs); addrErr == nil {
+			addrs = proto.MergeAddrs("", normalized...)
+		}
+		meta := addrCacheMeta{ttl: entry.TTL, expireAt: entry.ExpireAt, verifiedAt: entry.VerifiedAt}
+		if id.Validate() != nil || len(addrs) == 0 || meta.isExpired(now) {
+			log.WithField("node", entry.ID).Debug("skip invalid or expired route cache entry")
+			continue
+		}
+		rawID := id.ToRawNodeID()
+		if _, ok := resolver.cache[*rawID]; ok {
+			continue
+		}
+		resolver.cache[*rawID] = addrs[0]
+		resolver.addrs[*rawID] = addrs
+		if meta.ttl > 0 || !meta.verifiedAt.IsZero() {
+			resolver.meta[*rawID] = meta
+		}
+		loaded++
+	}
+	return
+}
@@ -0,0 +1,90 @@
+
+package route
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"sqlit/src/conf"
+	"sqlit/src/crypto/hash"
+	"sqlit/src/proto"
+)
+
+func TestRouteCacheFile(t *testing.T) {
+	_, testFile, _, _ := runtime.Caller(0)
+	confFile := filepath.Join(filepath.Dir(testFile), "../../test/node_c/config.yaml")
+	conf.GConf, _ = conf.LoadConfig(confFile)
+
+	Convey("route cache survives restart", t, func() {
+		var (
+			cacheFile = filepath.Join(t.TempDir(), "route.cache")
+			static    = &proto.RawNodeID{Hash: hash.Hash([32]byte{0xcc, 0x01})}
+			withTTL   = &proto.RawNodeID{Hash: hash.Hash([32]byte{0xcc, 0x02})}
+			expired   = &proto.RawNodeID{Hash: hash.Hash([32]byte{0xcc, 0x03})}
+			fromConf  = &proto.RawNodeID{Hash: hash.Hash([32]byte{0xcc, 0x04})}
+		)
+		setResolveCache(make(NodeIDAddressMap))
+		So(SetNodeAddrCache(static, "a:1", "b:1"), ShouldBeNil)
+		So(SetNodeAddrCacheTTL(withTTL, "a:2", time.Hour), ShouldBeNil)
+		So(MarkNodeAddrCacheVerified(withTTL), ShouldBeNil)
+		So(SetNodeAddrCacheTTL(expired, "a:3", time.Hour), ShouldBeNil)
+		So(SetNodeAddrCache(fromConf, "old:4"), ShouldBeNil)
+		resolver.Lock()
+		resolver.meta[*expired] = addrCacheMeta{ttl: time.Hour, expireAt: time.Now().Add(-time.Second)}
+		resolver.Unlock()
+		before, err := GetNodeAddrCacheEntry(withTTL)
+		So(err, ShouldBeNil)
+		So(SaveCache(cacheFile), ShouldBeNil)
+
+		// restart with a config entry set before loading
+		setResolveCache(make(NodeIDAddressMap))
+		So(SetNodeAddrCache(fromConf, "new:4"), ShouldBeNil)
+		loaded, err := LoadCache(cacheFile)
+		So(err, ShouldBeNil)
+		So(loaded, ShouldEqual, 2)
+
+		addrs, err := GetNodeAddrsCache(static)
+		So(err, ShouldBeNil)
+		So(addrs, ShouldResemble, []string{"a:1", "b:1"})
+		entry, err := GetNodeAddrCacheEntry(withTTL)
+		So(err, ShouldBeNil)
+		So(entry.TTL, ShouldEqual, time.Hour)
+		So(entry.ExpireAt.Equal(before.ExpireAt), ShouldBeTrue)
+		So(entry.VerifiedAt.Equal(before.VerifiedAt), ShouldBeTrue)
+		_, err = GetNodeAddrCache(expired)
+		So(err, ShouldEqual, ErrUnknownNodeID)
+		addr, err := GetNodeAddrCache(fromConf)
+		So(err, ShouldBeNil)
+		So(addr, ShouldEqual, "new:4")
+
+		// loading again never overrides
+		So(SetNodeAddrCache(static, "c:1"), ShouldBeNil)
+		loaded, err = LoadCache(cacheFile)
+		So(err, ShouldBeNil)
+		So(loaded, ShouldEqual, 0)
+		addr, err = GetNodeAddrCache(static)
+		So(err, ShouldBeNil)
+		So(addr, ShouldEqual, "c:1")
+	})
+
+	Convey("missing or corrupt route cache is skipped", t, func() {
+		dir := t.TempDir()
+		setResolveCache(make(NodeIDAddressMap))
+		loaded, err := LoadCache(filepath.Join(dir, "missing"))
+		So(err, ShouldBeNil)
+		So(loaded, ShouldEqual, 0)
+
+		for i, content := range []string{"{not json", `{"Version":99}`, `{"Version":1,"Entries":[{"ID":"zz","Addrs":["a:1"]}]}`} {
+			cacheFile := filepath.Join(dir, "corrupt"+string(rune('0'+i)))
+			So(os.WriteFile(cacheFile, []byte(content), 0600), ShouldBeNil)
+			loaded, err = LoadCache(cacheFile)
+			So(err, ShouldBeNil)
+			So(loaded, ShouldEqual, 0)
+		}
+	})
+}
@@ -2,10 +2,13 @@
 package route
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"math/rand"
+	"slices"
 	"sync"
+	"time"
 
 	"sqlit/src/conf"
 	"sqlit/src/crypto/kms"
@@ -33,11 +36,44 @@ var (
 
 	// ErrNilNodeID indicates we got nil node id
 	ErrNilNodeID = errors.New("nil node id")
+
+	// ErrStaleNodeAddr indicates the cached node addr is expired and should be resolved again
+	ErrStaleNodeAddr = errors.New("stale node addr")
 )
 
+// NodeAddrCacheEntry is the cached addresses of a node.
+type NodeAddrCacheEntry struct {
+	// Addrs is the address candidates in priority order, the primary comes first
+	Addrs []string
+	// TTL is the time to live of the entry, zero means never expire
+	TTL time.Duration
+	// ExpireAt is the expiry time of the entry, zero if TTL is zero
+	ExpireAt time.Time
+	// VerifiedAt is the last time the addresses are verified, zero if never
+	VerifiedAt time.Time
+	// Unreachable is set if all the addresses are unreachable by ProbeNodeAddrCache,
+	// the unreachable addresses come last in Addrs
+	Unreachable bool
+}
+
+// addrCacheMeta holds the expiry info of a cache entry, entries without meta never expire.
+type addrCacheMeta struct {
+	ttl        time.Duration
+	expireAt   time.Time
+	verifiedAt time.Time
+}
+
+func (m addrCacheMeta) isExpired(now time.Time) bool {
+	return m.ttl > 0 && !now.Before(m.expireAt)
+}
+
 // Resolver does NodeID translation.
 type Resolver struct {
-	cache     NodeIDAddressMap
+	cache NodeIDAddressMap
+	// addrs holds the address candidates in priority order, cache holds the primary
+	addrs map[proto.RawNodeID][]string
+	// meta holds the expiry info of the cache entries set with TTL or verified
+	meta      map[proto.RawNodeID]addrCacheMeta
 	bpNodeIDs NodeIDAddressMap
 	bpNodes   IDNodeMap
 	sync.RWMutex
@@ -48,18 +84,28 @@ func initResolver() {
 	Once.Do(func() {
 		resolver = &Resolver{
 			cache:     make(NodeIDAddressMap),
+			addrs:     make(map[proto.RawNodeID][]string),
+			meta:      make(map[proto.RawNodeID]addrCacheMeta),
 			bpNodeIDs: make(NodeIDAddressMap),
 		}
 		initBPNodeIDs()
 	})
 }
 
+// InitResolver initializes the resolver from config and DNS seeds, the other
+// functions of route call it lazily.
+func InitResolver() {
+	initResolver()
+}
+
 // IsBPNodeID returns if it is Block Producer node id.
 func IsBPNodeID(id *proto.RawNodeID) bool {
 	initResolver()
 	if id == nil {
 		return false
 	}
+	resolver.RLock()
+	defer resolver.RUnlock()
 	_, ok := resolver.bpNodeIDs[*id]
 	return ok
 }
@@ -70,38 +116,226 @@ func setResolveCache(initCache NodeIDAddressMap) {
 	resolver.Lock()
 	defer resolver.Unlock()
 	resolver.cache = initCache
+	resolver.addrs = make(map[proto.RawNodeID][]string)
+	resolver.meta = make(map[proto.RawNodeID]addrCacheMeta)
+	resetNodeHealth()
 }
 
-// GetNodeAddrCache gets node addr by node id, if cache missed try RPC.
+// GetNodeAddrCache gets node addr by node id, if cache missed try RPC. The addr of
+// an expired entry is returned with ErrStaleNodeAddr.
 func GetNodeAddrCache(id *proto.RawNodeID) (addr string, err error) {
+	var entry NodeAddrCacheEntry
+	if entry, err = getNodeAddrCacheEntry(id, false); err != nil && err != ErrStaleNodeAddr {
+		return
+	}
+	return entry.Addrs[0], err
+}
+
+// GetNodeAddrsCache gets the node address candidates in priority order by node id,
+// the primary address comes first.
+func GetNodeAddrsCache(id *proto.RawNodeID) (addrs []string, err error) {
+	var entry NodeAddrCacheEntry
+	entry, err = getNodeAddrCacheEntry(id, true)
+	return entry.Addrs, err
+}
+
+// GetNodeAddrCacheEntry gets the cache entry of node id, an expired entry is
+// returned with ErrStaleNodeAddr.
+func GetNodeAddrCacheEntry(id *proto.RawNodeID) (entry NodeAddrCacheEntry, err error) {
+	return getNodeAddrCacheEntry(id, true)
+}
+
+// getNodeAddrCacheEntry reads the cache entry of node id in one critical section,
+// Addrs is the primary address only unless all is set.
+func getNodeAddrCacheEntry(id *proto.RawNodeID, all bool) (entry NodeAddrCacheEntry, err error) {
 	initResolver()
 	if id == nil {
-		return "", ErrNilNodeID
+		return entry, ErrNilNodeID
 	}
 	resolver.RLock()
 	defer resolver.RUnlock()
 	addr, ok := resolver.cache[*id]
 	if !ok {
-		return "", ErrUnknownNodeID
+		cacheMisses.inc()
+		return entry, ErrUnknownNodeID
+	}
+	if addrs, ok := resolver.addrs[*id]; all && ok {
+		entry.Addrs = append([]string(nil), addrs...)
+	} else if all {
+		entry.Addrs = proto.MergeAddrs(addr)
+	} else {
+		entry.Addrs = []string{addr}
+	}
+	entry.Addrs, entry.Unreachable = markNodeUsedLocked(*id, entry.Addrs)
+	meta := resolver.meta[*id]
+	entry.TTL, entry.ExpireAt, entry.VerifiedAt = meta.ttl, meta.expireAt, meta.verifiedAt
+	if meta.isExpired(time.Now()) {
+		cacheMisses.inc()
+		err = ErrStaleNodeAddr
+	} else {
+		cacheHits.inc()
 	}
 	return
 }
 
-// setNodeAddrCache sets node id and addr.
-func setNodeAddrCache(id *proto.RawNodeID, addr string) (err error) {
+// setNodeAddrCache sets node id and addrs which never expire.
+func setNodeAddrCache(id *proto.RawNodeID, addr string, alternates ...string) (err error) {
+	return setNodeAddrCacheTTL(id, addr, 0, alternates...)
+}
+
+// setNodeAddrCacheTTL sets node id and addrs which expire after ttl, a non
+// positive ttl never expires.
+func setNodeAddrCacheTTL(
+	id *proto.RawNodeID, addr string, ttl time.Duration, alternates ...string,
+) (err error) {
 	if id == nil {
 		return ErrNilNodeID
 	}
+	if addr, alternates, err = normalizeAddrs(addr, alternates); err != nil {
+		return
+	}
 	resolver.Lock()
 	defer resolver.Unlock()
-	resolver.cache[*id] = addr
+	resolver.setLocked(id, addr, ttl, alternates...)
 	return
 }
 
-// SetNodeAddrCache sets node id and addr.
-func SetNodeAddrCache(id *proto.RawNodeID, addr string) (err error) {
+// setLocked sets the cache entry of a non nil id, the caller must hold the lock.
+func (r *Resolver) setLocked(id *proto.RawNodeID, addr string, ttl time.Duration, alternates ...string) {
+	addrs := proto.MergeAddrs(addr, alternates...)
+	old, ok := r.cache[*id]
+	changed := !ok || old != addr || !slices.Equal(r.addrs[*id], addrs)
+	if ok && changed {
+		cacheEvictions.inc()
+	}
+	if changed {
+		publishNodeEvent(nodeAddrChangedEvent(*id, addrs))
+	}
+	r.cache[*id] = addr
+	r.addrs[*id] = addrs
+	if ttl > 0 {
+		r.meta[*id] = addrCacheMeta{ttl: ttl, expireAt: time.Now().Add(ttl)}
+	} else {
+		delete(r.meta, *id)
+	}
+}
+
+// SetNodeAddrCache sets node id and addr, alternates are the other addresses of
+// the node to try in order if addr is unreachable. The entry never expires. The
+// addresses are normalized by NormalizeAddr, an invalid one is rejected.
+func SetNodeAddrCache(id *proto.RawNodeID, addr string, alternates ...string) (err error) {
 	initResolver()
-	return setNodeAddrCache(id, addr)
+	return setNodeAddrCache(id, addr, alternates...)
+}
+
+// SetNodeAddrCacheBatch sets the node id and addr of all entries like SetNodeAddrCache
+// while holding the lock once. A partially invalid batch is not rejected as a whole:
+// the valid entries are applied and the invalid ones are reported in the returned
+// error joined from the error of each.
+func SetNodeAddrCacheBatch(entries map[*proto.RawNodeID]string) (err error) {
+	initResolver()
+	var errs []error
+	resolver.Lock()
+	defer resolver.Unlock()
+	for id, addr := range entries {
+		if id == nil {
+			errs = append(errs, fmt.Errorf("set addr %q: %w", addr, ErrNilNodeID))
+			continue
+		}
+		normalized, addrErr := NormalizeAddr(addr)
+		if addrErr != nil {
+			errs = append(errs, fmt.Errorf("set addr of %s: %w", id.ToNodeID(), addrErr))
+			continue
+		}
+		resolver.setLocked(id, normalized, 0)
+	}
+	return errors.Join(errs...)
+}
+
+// SetNodeAddrCacheTTL sets node id and addr like SetNodeAddrCache, the entry is
+// stale after ttl and evicted by SweepNodeAddrCache. A non positive ttl never expires.
+func SetNodeAddrCacheTTL(
+	id *proto.RawNodeID, addr string, ttl time.Duration, alternates ...string,
+) (err error) {
+	initResolver()
+	return setNodeAddrCacheTTL(id, addr, ttl, alternates...)
+}
+
+// DelNodeAddrCache evicts the cached addresses of node id.
+func DelNodeAddrCache(id *proto.RawNodeID) (err error) {
+	initResolver()
+	if id == nil {
+		return ErrNilNodeID
+	}
+	resolver.Lock()
+	defer resolver.Unlock()
+	if _, ok := resolver.cache[*id]; !ok {
+		return ErrUnknownNodeID
+	}
+	delete(resolver.cache, *id)
+	delete(resolver.addrs, *id)
+	delete(resolver.meta, *id)
+	cacheEvictions.inc()
+	publishNodeEvent(NodeEvent{Type: NodeEvicted, ID: id.ToNodeID()})
+	return
+}
+
+// MarkNodeAddrCacheVerified records the cached addresses of node id are verified
+// now, e.g. by a successful dial, an entry with TTL is renewed.
+func MarkNodeAddrCacheVerified(id *proto.RawNodeID) (err error) {
+	initResolver()
+	if id == nil {
+		return ErrNilNodeID
+	}
+	resolver.Lock()
+	defer resolver.Unlock()
+	if _, ok := resolver.cache[*id]; !ok {
+		return ErrUnknownNodeID
+	}
+	now := time.Now()
+	meta := resolver.meta[*id]
+	meta.verifiedAt = now
+	if meta.ttl > 0 {
+		meta.expireAt = now.Add(meta.ttl)
+	}
+	resolver.meta[*id] = meta
+	return
+}
+
+// SweepNodeAddrCache evicts the expired cache entries every interval until ctx is done.
+func SweepNodeAddrCache(ctx context.Context, interval time.Duration) {
+	initResolver()
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				if evicted := sweepNodeAddrCache(now); evicted > 0 {
+					log.WithField("evicted", evicted).Debug("evict expired node addr cache")
+				}
+			}
+		}
+	}()
+}
+
+// sweepNodeAddrCache evicts the entries expired at now and returns the evicted count.
+func sweepNodeAddrCache(now time.Time) (evicted int) {
+	resolver.Lock()
+	defer resolver.Unlock()
+	for id, meta := range resolver.meta {
+		if meta.isExpired(now) {
+			delete(resolver.cache, id)
+			delete(resolver.addrs, id)
+			delete(resolver.meta, id)
+			evicted++
+			publishNodeEvent(NodeEvent{Type: NodeEvicted, ID: id.ToNodeID()})
+		}
+	}
+	cacheEvictions.add(evicted)
+	return
 }
 
 // initBPNodeIDs initializes BlockProducer route and map from config file and DNS Seed.
@@ -110,9 +344,20 @@ func initBPNodeIDs() (bpNodeIDs NodeIDAddressMap) {
 		log.Fatal("call conf.LoadConfig to init conf first")
 	}
 
-	// clear address map before init
-	resolver.bpNodeIDs = make(NodeIDAddressMap)
-	bpNodeIDs = resolver.bpNodeIDs
+	// build the maps aside and publish them at once, so readers never see a partial map
+	bpNodeIDs = make(NodeIDAddressMap)
+	resolver.RLock()
+	bpNodes := make(IDNodeMap, len(resolver.bpNodes))
+	for id, n := range resolver.bpNodes {
+		bpNodes[id] = n
+	}
+	resolver.RUnlock()
+	defer func() {
+		resolver.Lock()
+		defer resolver.Unlock()
+		resolver.bpNodeIDs = bpNodeIDs
+		resolver.bpNodes = bpNodes
+	}()
 
 	var err error
 
@@ -122,7 +367,7 @@ func initBPNodeIDs() (bpNodeIDs NodeIDAddressMap) {
 		bpIndex = rand.Intn(conf.GConf.DNSSeed.BPCount)
 		bpDomain := fmt.Sprintf("bp%02d.%s", bpIndex, conf.GConf.DNSSeed.Domain)
 		log.Infof("Geting bp address from dns: %v", bpDomain)
-		resolver.bpNodes, err = dc.GetBPFromDNSSeed(bpDomain)
+		bpNodes, err = dc.GetBPFromDNSSeed(bpDomain)
 		if err != nil {
 			log.WithField("seed", bpDomain).WithError(err).Error(
 				"getting BP info from DNS failed")
@@ -130,36 +375,44 @@ func initBPNodeIDs() (bpNodeIDs NodeIDAddressMap) {
 		}
 	}
 
-	if resolver.bpNodes == nil {
-		resolver.bpNodes = make(IDNodeMap)
+	if bpNodes == nil {
+		bpNodes = make(IDNodeMap)
 	}
 	if conf.GConf.KnownNodes != nil {
 		for _, n := range conf.GConf.KnownNodes {
 			rawID := n.ID.ToRawNodeID()
 			if rawID != nil {
 				if n.Role == proto.Leader || n.Role == proto.Follower {
-					resolver.bpNodes[*rawID] = n
+					bpNodes[*rawID] = n
+				}
+				if err = setNodeAddrCache(rawID, n.Addr, n.Addrs...); err != nil {
+					log.WithField("node", n.ID).WithError(err).Warning("skip invalid known node addr")
 				}
-				setNodeAddrCache(rawID, n.Addr)
 			}
 		}
 	}
 
-	conf.GConf.SeedBPNodes = make([]proto.Node, 0, len(resolver.bpNodes))
-	for _, n := range resolver.bpNodes {
+	// seeds only fill the nodes missing in the static list
+	bootstrapFromSeeds(conf.GConf.DNSSeed.Seeds)
+
+	conf.GConf.SeedBPNodes = make([]proto.Node, 0, len(bpNodes))
+	for _, n := range bpNodes {
 		rawID := n.ID.ToRawNodeID()
 		if rawID != nil {
 			conf.GConf.SeedBPNodes = append(conf.GConf.SeedBPNodes, n)
-			setNodeAddrCache(rawID, n.Addr)
-			resolver.bpNodeIDs[*rawID] = n.Addr
+			_ = setNodeAddrCache(rawID, n.Addr, n.Addrs...)
+			bpNodeIDs[*rawID] = n.Addr
 		}
 	}
 
-	return resolver.bpNodeIDs
+	return
 }
 
 // GetBPs returns the known BP node id list.
 func GetBPs() (bpAddrs []proto.NodeID) {
+	initResolver()
+	resolver.RLock()
+	defer resolver.RUnlock()
 	bpAddrs = make([]proto.NodeID, 0, len(resolver.bpNodeIDs))
 	for id := range resolver.bpNodeIDs {
 		bpAddrs = append(bpAddrs, proto.NodeID(id.String()))
@@ -179,14 +432,20 @@ func InitKMS(PubKeyStoreFile string) {
 				"node": rawNodeID.String(),
 				"addr": n.Addr,
 			}).Debug("set node addr")
-			SetNodeAddrCache(rawNodeID, n.Addr)
+			SetNodeAddrCache(rawNodeID, n.Addr, n.Addrs...)
 			node := &proto.Node{
 				ID:         n.ID,
 				Addr:       n.Addr,
+				Addrs:      n.Addrs,
 				DirectAddr: n.DirectAddr,
 				PublicKey:  n.PublicKey,
 				Nonce:      n.Nonce,
 				Role:       n.Role,
+				Region:     n.Region,
+				Zone:       n.Zone,
+				Tags:       n.Tags,
+
+				Capabilities: n.Capabilities,
 			}
 			log.WithField("node", node).Debug("known node to set")
 			err := kms.SetNode(node)
@@ -2,9 +2,14 @@
 package route
 
 import (
+	"context"
+	"errors"
 	"path/filepath"
 	"runtime"
+	"strconv"
+	"sync"
 	"testing"
+	"time"
 
 	. "github.com/smartystreets/goconvey/convey"
 
@@ -47,6 +52,34 @@ func TestResolver(t *testing.T) {
 		So(err, ShouldBeNil)
 		So(addr, ShouldEqual, addr)
 
+		// multi-homed node
+		addrs, err := GetNodeAddrsCache(nodeA)
+		So(err, ShouldBeNil)
+		So(addrs, ShouldBeEmpty)
+		err = SetNodeAddrCache(nodeA, "a:1", "b:1", "a:1", "", "c:1")
+		So(err, ShouldBeNil)
+		addr, err = GetNodeAddrCache(nodeA)
+		So(err, ShouldBeNil)
+		So(addr, ShouldEqual, "a:1")
+		addrs, err = GetNodeAddrsCache(nodeA)
+		So(err, ShouldBeNil)
+		So(addrs, ShouldResemble, []string{"a:1", "b:1", "c:1"})
+		addrs[0] = "x:1"
+		addrs, err = GetNodeAddrsCache(nodeA)
+		So(err, ShouldBeNil)
+		So(addrs[0], ShouldEqual, "a:1")
+		err = SetNodeAddrCache(nodeA, "d:1")
+		So(err, ShouldBeNil)
+		addrs, err = GetNodeAddrsCache(nodeA)
+		So(err, ShouldBeNil)
+		So(addrs, ShouldResemble, []string{"d:1"})
+		_, err = GetNodeAddrsCache(nil)
+		So(err, ShouldEqual, ErrNilNodeID)
+		_, err = GetNodeAddrsCache(&proto.RawNodeID{
+			Hash: hash.Hash([32]byte{0xde, 0xad}),
+		})
+		So(err, ShouldEqual, ErrUnknownNodeID)
+
 		So(IsBPNodeID(nil), ShouldBeFalse)
 
 		So(IsBPNodeID(nodeA), ShouldBeFalse)
@@ -61,3 +94,221 @@ func TestResolver(t *testing.T) {
 		So(len(BPs), ShouldBeGreaterThanOrEqualTo, len(ips))
 	})
 }
+
+func TestNodeAddrCacheTTL(t *testing.T) {
+	_, testFile, _, _ := runtime.Caller(0)
+	confFile := filepath.Join(filepath.Dir(testFile), "../../test/node_c/config.yaml")
+	conf.GConf, _ = conf.LoadConfig(confFile)
+
+	Convey("node addr cache with ttl", t, func() {
+		setResolveCache(make(NodeIDAddressMap))
+		var (
+			nodeA = &proto.RawNodeID{Hash: hash.Hash([32]byte{0xaa, 0x01})}
+			nodeB = &proto.RawNodeID{Hash: hash.Hash([32]byte{0xbb, 0x01})}
+			nodeC = &proto.RawNodeID{Hash: hash.Hash([32]byte{0xcc, 0x01})}
+		)
+		So(SetNodeAddrCacheTTL(nil, "a:1", time.Minute), ShouldEqual, ErrNilNodeID)
+		So(SetNodeAddrCacheTTL(nodeA, "a:1", time.Minute, "a:2"), ShouldBeNil)
+		So(SetNodeAddrCacheTTL(nodeB, "b:1", 0), ShouldBeNil)
+		So(SetNodeAddrCache(nodeC, "c:1"), ShouldBeNil)
+
+		entry, err := GetNodeAddrCacheEntry(nodeA)
+		So(err, ShouldBeNil)
+		So(entry.Addrs, ShouldResemble, []string{"a:1", "a:2"})
+		So(entry.TTL, ShouldEqual, time.Minute)
+		So(entry.ExpireAt, ShouldHappenAfter, time.Now())
+		So(entry.VerifiedAt.IsZero(), ShouldBeTrue)
+		entry, err = GetNodeAddrCacheEntry(nodeC)
+		So(err, ShouldBeNil)
+		So(entry.TTL, ShouldBeZeroValue)
+		So(entry.ExpireAt.IsZero(), ShouldBeTrue)
+		_, err = GetNodeAddrCacheEntry(&proto.RawNodeID{})
+		So(err, ShouldEqual, ErrUnknownNodeID)
+
+		// nothing is expired yet
+		So(sweepNodeAddrCache(time.Now()), ShouldEqual, 0)
+		// entries without ttl never expire
+		So(sweepNodeAddrCache(time.Now().Add(24*time.Hour)), ShouldEqual, 1)
+		_, err = GetNodeAddrCache(nodeA)
+		So(err, ShouldEqual, ErrUnknownNodeID)
+		addr, err := GetNodeAddrCache(nodeB)
+		So(err, ShouldBeNil)
+		So(addr, ShouldEqual, "b:1")
+		_, err = GetNodeAddrCache(nodeC)
+		So(err, ShouldBeNil)
+
+		// expired entry is stale until swept
+		So(SetNodeAddrCacheTTL(nodeA, "a:1", time.Millisecond, "a:2"), ShouldBeNil)
+		time.Sleep(5 * time.Millisecond)
+		addr, err = GetNodeAddrCache(nodeA)
+		So(err, ShouldEqual, ErrStaleNodeAddr)
+		So(addr, ShouldEqual, "a:1")
+		addrs, err := GetNodeAddrsCache(nodeA)
+		So(err, ShouldEqual, ErrStaleNodeAddr)
+		So(addrs, ShouldResemble, []string{"a:1", "a:2"})
+		entry, err = GetNodeAddrCacheEntry(nodeA)
+		So(err, ShouldEqual, ErrStaleNodeAddr)
+		So(entry.TTL, ShouldEqual, time.Millisecond)
+
+		// verifying renews the entry
+		So(SetNodeAddrCacheTTL(nodeA, "a:1", time.Hour), ShouldBeNil)
+		before, err := GetNodeAddrCacheEntry(nodeA)
+		So(err, ShouldBeNil)
+		So(MarkNodeAddrCacheVerified(nodeA), ShouldBeNil)
+		entry, err = GetNodeAddrCacheEntry(nodeA)
+		So(err, ShouldBeNil)
+		So(entry.VerifiedAt.IsZero(), ShouldBeFalse)
+		So(entry.ExpireAt, ShouldHappenOnOrAfter, before.ExpireAt)
+		So(MarkNodeAddrCacheVerified(nodeC), ShouldBeNil)
+		entry, err = GetNodeAddrCacheEntry(nodeC)
+		So(err, ShouldBeNil)
+		So(entry.VerifiedAt.IsZero(), ShouldBeFalse)
+		So(entry.ExpireAt.IsZero(), ShouldBeTrue)
+		So(MarkNodeAddrCacheVerified(&proto.RawNodeID{}), ShouldEqual, ErrUnknownNodeID)
+		So(MarkNodeAddrCacheVerified(nil), ShouldEqual, ErrNilNodeID)
+
+		// setting without ttl never expires again
+		So(SetNodeAddrCache(nodeA, "a:1"), ShouldBeNil)
+		So(sweepNodeAddrCache(time.Now().Add(24*time.Hour)), ShouldEqual, 0)
+
+		// background sweeper
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		So(SetNodeAddrCacheTTL(nodeB, "b:1", time.Millisecond), ShouldBeNil)
+		SweepNodeAddrCache(ctx, time.Millisecond)
+		So(func() bool {
+			for i := 0; i < 1000; i++ {
+				if _, err := GetNodeAddrCache(nodeB); err == ErrUnknownNodeID {
+					return true
+				}
+				time.Sleep(time.Millisecond)
+			}
+			return false
+		}(), ShouldBeTrue)
+	})
+}
+
+func TestNodeAddrCacheConcurrency(t *testing.T) {
+	_, testFile, _, _ := runtime.Caller(0)
+	confFile := filepath.Join(filepath.Dir(testFile), "../../test/node_c/config.yaml")
+	conf.GConf, _ = conf.LoadConfig(confFile)
+
+	Convey("concurrent set and get of the same node", t, func() {
+		setResolveCache(make(NodeIDAddressMap))
+		id := &proto.RawNodeID{Hash: hash.Hash([32]byte{0xaa, 0x02})}
+		So(SetNodeAddrCache(id, "a:0"), ShouldBeNil)
+
+		var (
+			wg     sync.WaitGroup
+			rounds = 1000
+			errs   = make(chan error, 8*rounds)
+		)
+		for w := 0; w < 4; w++ {
+			wg.Add(2)
+			go func(w int) {
+				defer wg.Done()
+				for i := 0; i < rounds; i++ {
+					addr := "a:" + strconv.Itoa(w*rounds+i)
+					if i%2 == 0 {
+						errs <- SetNodeAddrCache(id, addr, addr+"0")
+					} else {
+						errs <- SetNodeAddrCacheTTL(id, addr, time.Hour)
+					}
+					if i%100 == 0 {
+						_ = MarkNodeAddrCacheVerified(id)
+						sweepNodeAddrCache(time.Now())
+					}
+				}
+			}(w)
+			go func() {
+				defer wg.Done()
+				for i := 0; i < rounds; i++ {
+					addr, err := GetNodeAddrCache(id)
+					if err == nil && addr == "" {
+						err = ErrUnknownNodeID
+					}
+					errs <- err
+					addrs, err := GetNodeAddrsCache(id)
+					if err == nil && addrs[0] == "" {
+						err = ErrUnknownNodeID
+					}
+					_ = IsBPNodeID(id)
+					_ = GetBPs()
+				}
+			}()
+		}
+		wg.Wait()
+		close(errs)
+		for err := range errs {
+			So(err, ShouldBeNil)
+		}
+	})
+}
+
+func BenchmarkGetNodeAddrCache(b *testing.B) {
+	_, testFile, _, _ := runtime.Caller(0)
+	confFile := filepath.Join(filepath.Dir(testFile), "../../test/node_c/config.yaml")
+	conf.GConf, _ = conf.LoadConfig(confFile)
+
+	setResolveCache(make(NodeIDAddressMap))
+	ids := make([]proto.RawNodeID, 64)
+	for i := range ids {
+		ids[i].Hash[0] = byte(i)
+		_ = SetNodeAddrCache(&ids[i], "a:"+strconv.Itoa(i))
+	}
+
+	// a writer keeps contending with the readers
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+				_ = SetNodeAddrCache(&ids[i%len(ids)], "b:"+strconv.Itoa(i))
+			}
+		}
+	}()
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for i := 0; pb.Next(); i++ {
+			if _, err := GetNodeAddrCache(&ids[i%len(ids)]); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestSetNodeAddrCacheBatch(t *testing.T) {
+	_, testFile, _, _ := runtime.Caller(0)
+	confFile := filepath.Join(filepath.Dir(testFile), "../../test/node_c/config.yaml")
+	conf.GConf, _ = conf.LoadConfig(confFile)
+
+	Convey("batch applies the valid entries and reports the invalid", t, func() {
+		setResolveCache(make(NodeIDAddressMap))
+		var (
+			nodeA = &proto.RawNodeID{Hash: hash.Hash([32]byte{0xbb, 0x01})}
+			nodeB = &proto.RawNodeID{Hash: hash.Hash([32]byte{0xbb, 0x02})}
+		)
+		So(SetNodeAddrCacheTTL(nodeA, "old:1", time.Hour, "old:2"), ShouldBeNil)
+		So(SetNodeAddrCacheBatch(nil), ShouldBeNil)
+
+		err := SetNodeAddrCacheBatch(map[*proto.RawNodeID]string{
+			nodeA: "a:1",
+			nodeB: "b:1",
+			nil:   "c:1",
+		})
+		So(errors.Is(err, ErrNilNodeID), ShouldBeTrue)
+		So(err.Error(), ShouldContainSubstring, "c:1")
+
+		entry, err := GetNodeAddrCacheEntry(nodeA)
+		So(err, ShouldBeNil)
+		So(entry.Addrs, ShouldResemble, []string{"a:1"})
+		So(entry.TTL, ShouldEqual, 0)
+		addr, err := GetNodeAddrCache(nodeB)
+		So(err, ShouldBeNil)
+		So(addr, ShouldEqual, "b:1")
+	})
+}
@@ -0,0 +1,126 @@
+
+package route
+
+import (
+	"sync"
+
+	"sqlit/src/crypto/kms"
+	"sqlit/src/proto"
+)
+
+// NodeEventBuffer is the count of events buffered for a subscriber.
+const NodeEventBuffer = 64
+
+// NodeEventType is the kind of a NodeEvent.
+type NodeEventType int
+
+const (
+	// NodeAddrChanged is sent when the cached addresses of a node are set or changed.
+	NodeAddrChanged NodeEventType = iota
+	// NodeEvicted is sent when the cached addresses of a node are deleted or expired.
+	NodeEvicted
+	// NodeBanned is sent when a node is banned by Ban.
+	NodeBanned
+	// NodeUnbanned is sent when the ban of a node is lifted by Unban.
+	NodeUnbanned
+	// PeersTermAdvanced is sent when the local peers list is set to a newer term.
+	PeersTermAdvanced
+)
+
+// String returns the name of the event type.
+func (t NodeEventType) String() string {
+	switch t {
+	case NodeAddrChanged:
+		return "AddrChanged"
+	case NodeEvicted:
+		return "Evicted"
+	case NodeBanned:
+		return "Banned"
+	case NodeUnbanned:
+		return "Unbanned"
+	case PeersTermAdvanced:
+		return "PeersTermAdvanced"
+	}
+	return "Unknown"
+}
+
+// NodeEvent is a change of the routing state sent to the subscribers.
+type NodeEvent struct {
+	Type NodeEventType
+	// ID is the node of the event, it is the leader for PeersTermAdvanced
+	ID proto.NodeID
+	// Addrs is the new addresses of NodeAddrChanged, the primary first
+	Addrs []string
+	// Term is the new term of PeersTermAdvanced
+	Term uint64
+	// Dropped is the count of events dropped for the subscriber before this one as
+	// its buffer was full, the subscriber should resync its state if not zero
+	Dropped uint64
+}
+
+// nodeSubscriber is the buffered channel of a subscriber and its dropped events.
+type nodeSubscriber struct {
+	ch      chan NodeEvent
+	dropped uint64
+}
+
+var (
+	// subscribers holds the channels of Subscribe by the receiving end
+	subscribers     = make(map[<-chan NodeEvent]*nodeSubscriber)
+	subscribersLock sync.Mutex
+	// peersHookOnce hooks the local peers changes of kms on the first Subscribe
+	peersHookOnce sync.Once
+)
+
+// Subscribe returns a channel receiving the node address, membership and peers term
+// changes, e.g. to maintain the connections to the nodes. The writers never block on
+// a subscriber: the events not fitting its buffer of NodeEventBuffer are dropped and
+// counted in the Dropped of the next event received. Close it by Unsubscribe.
+func Subscribe() <-chan NodeEvent {
+	peersHookOnce.Do(func() { kms.SetLocalPeersHook(notifyLocalPeers) })
+	s := &nodeSubscriber{ch: make(chan NodeEvent, NodeEventBuffer)}
+	subscribersLock.Lock()
+	defer subscribersLock.Unlock()
+	subscribers[s.ch] = s
+	return s.ch
+}
+
+// Unsubscribe stops sending the events to ch of Subscribe and closes it, the buffered
+// events are still received. Unsubscribing an unknown channel is a no-op.
+func Unsubscribe(ch <-chan NodeEvent) {
+	subscribersLock.Lock()
+	defer subscribersLock.Unlock()
+	if s, ok := subscribers[ch]; ok {
+		delete(subscribers, ch)
+		close(s.ch)
+	}
+}
+
+// publishNodeEvent sends e to all the subscribers without blocking.
+func publishNodeEvent(e NodeEvent) {
+	subscribersLock.Lock()
+	defer subscribersLock.Unlock()
+	for _, s := range subscribers {
+		sent := e
+		sent.Dropped = s.dropped
+		select {
+		case s.ch <- sent:
+			s.dropped = 0
+		default:
+			s.dropped++
+		}
+	}
+}
+
+// notifyLocalPeers is the kms.LocalPeersHook publishing the newer terms.
+func notifyLocalPeers(old, peers *proto.Peers) {
+	if peers == nil || (old != nil && peers.Term <= old.Term) {
+		return
+	}
+	publishNodeEvent(NodeEvent{Type: PeersTermAdvanced, ID: peers.Leader, Term: peers.Term})
+}
+
+// nodeAddrChangedEvent returns the NodeAddrChanged event of id, addrs is copied.
+func nodeAddrChangedEvent(id proto.RawNodeID, addrs []string) NodeEvent {
+	return NodeEvent{Type: NodeAddrChanged, ID: id.ToNodeID(), Addrs: append([]string(nil), addrs...)}
+}
@@ -0,0 +1,111 @@
+
+package route
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"sqlit/src/conf"
+	"sqlit/src/crypto/kms"
+	"sqlit/src/proto"
+)
+
+// receiveEvents returns the events buffered in ch.
+func receiveEvents(ch <-chan NodeEvent) (events []NodeEvent) {
+	for {
+		select {
+		case e, ok := <-ch:
+			if !ok {
+				return
+			}
+			events = append(events, e)
+		default:
+			return
+		}
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	defer func(saved *conf.Config) { conf.GConf = saved }(conf.GConf)
+	defer resetBans()
+	conf.GConf = &conf.Config{}
+	initResolver()
+
+	var (
+		node   = proto.NodeID("00000000000000000000000000000000000000000000000000000000000000e1")
+		leader = proto.NodeID("00000000000000000000000000000000000000000000000000000000000000e2")
+		rawID  = node.ToRawNodeID()
+	)
+
+	Convey("the address and membership changes are sent", t, func() {
+		ch := Subscribe()
+		defer Unsubscribe(ch)
+		So(SetNodeAddrCache(rawID, "10.0.5.1:1", "10.0.5.2:1"), ShouldBeNil)
+		// the unchanged addresses are not sent
+		So(SetNodeAddrCache(rawID, "10.0.5.1:1", "10.0.5.2:1"), ShouldBeNil)
+		So(SetNodeAddrCacheTTL(rawID, "10.0.5.3:1", time.Millisecond), ShouldBeNil)
+		So(sweepNodeAddrCache(time.Now().Add(time.Second)), ShouldEqual, 1)
+		So(SetNodeAddrCache(rawID, "10.0.5.1:1"), ShouldBeNil)
+		So(DelNodeAddrCache(rawID), ShouldBeNil)
+		So(Ban(node, time.Minute), ShouldBeNil)
+		So(Unban(node), ShouldBeTrue)
+		So(Unban(node), ShouldBeFalse)
+
+		events := receiveEvents(ch)
+		types := make([]NodeEventType, 0, len(events))
+		for _, e := range events {
+			So(e.ID, ShouldEqual, node)
+			So(e.Dropped, ShouldEqual, 0)
+			types = append(types, e.Type)
+		}
+		So(types, ShouldResemble, []NodeEventType{
+			NodeAddrChanged, NodeAddrChanged, NodeEvicted, NodeAddrChanged, NodeEvicted, NodeBanned, NodeUnbanned,
+		})
+		So(events[0].Addrs, ShouldResemble, []string{"10.0.5.1:1", "10.0.5.2:1"})
+		So(events[1].Addrs, ShouldResemble, []string{"10.0.5.3:1"})
+	})
+	Convey("the newer terms of the local peers are sent", t, func() {
+		ch := Subscribe()
+		defer Unsubscribe(ch)
+		defer kms.SetLocalPeers(nil)
+		peers := func(term uint64) *proto.Peers {
+			return &proto.Peers{PeersHeader: proto.PeersHeader{Term: term, Leader: leader}}
+		}
+		kms.SetLocalPeers(peers(1))
+		kms.SetLocalPeers(peers(1))
+		kms.SetLocalPeers(peers(3))
+		kms.SetLocalPeers(peers(2))
+
+		events := receiveEvents(ch)
+		So(events, ShouldHaveLength, 2)
+		So(events[0], ShouldResemble, NodeEvent{Type: PeersTermAdvanced, ID: leader, Term: 1})
+		So(events[1].Term, ShouldEqual, 3)
+	})
+	Convey("a subscriber behind drops the events instead of blocking", t, func() {
+		slow, fast := Subscribe(), Subscribe()
+		defer Unsubscribe(slow)
+		for i := 0; i < NodeEventBuffer+3; i++ {
+			So(Ban(node, time.Minute), ShouldBeNil)
+			if i%8 == 0 {
+				receiveEvents(fast)
+			}
+		}
+		So(receiveEvents(slow), ShouldHaveLength, NodeEventBuffer)
+		So(Unban(node), ShouldBeTrue)
+		events := receiveEvents(slow)
+		So(events, ShouldHaveLength, 1)
+		So(events[0].Type, ShouldEqual, NodeUnbanned)
+		So(events[0].Dropped, ShouldEqual, 3)
+
+		// the unsubscribed channel is closed after its buffered events
+		Unsubscribe(fast)
+		Unsubscribe(fast)
+		events = receiveEvents(fast)
+		So(events, ShouldNotBeEmpty)
+		_, open := <-fast
+		So(open, ShouldBeFalse)
+		So(Ban(node, time.Minute), ShouldBeNil)
+	})
+}
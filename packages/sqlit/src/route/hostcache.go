@@ -0,0 +1,314 @@
+
+package route
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"sqlit/src/conf"
+	"sqlit/src/utils/log"
+)
+
+const (
+	// DefaultHostTTL is the TTL of the resolved IPs if the lookup reports none.
+	DefaultHostTTL = time.Minute
+	// DefaultHostMinTTL is the lower bound of the TTL of the resolved IPs.
+	DefaultHostMinTTL = 5 * time.Second
+	// DefaultHostMaxTTL is the upper bound of the TTL of the resolved IPs.
+	DefaultHostMaxTTL = time.Hour
+	// DefaultHostRefreshInterval is the period of RefreshHostCache rounds.
+	DefaultHostRefreshInterval = 5 * time.Second
+	// DefaultHostIdle is how long a host is refreshed after it is last resolved.
+	DefaultHostIdle = 10 * time.Minute
+
+	// hostLookupTimeout bounds a lookup, it is shared by the resolvers of the host
+	hostLookupTimeout = 5 * time.Second
+)
+
+// ErrNoHostIP indicates the lookup of a host name returns no IP.
+var ErrNoHostIP = errors.New("no IP for host")
+
+// HostLookup resolves host to its IPs, ttl is the TTL of the DNS records or zero if
+// it is unknown.
+type HostLookup func(ctx context.Context, host string) (ips []net.IP, ttl time.Duration, err error)
+
+// HostCacheEntry is the cached IPs of a host name.
+type HostCacheEntry struct {
+	Host string
+	// IPs is the last good IPs of the host, they are served until ExpireAt
+	IPs []net.IP
+	// ResolvedAt is the time of the last successful lookup
+	ResolvedAt time.Time
+	// ExpireAt is the expiry time of IPs
+	ExpireAt time.Time
+	// LastError is the error of the last lookup, nil if it succeeded
+	LastError error
+}
+
+// hostEntry is a cached host, refreshAt is the time to look it up again ahead of
+// expireAt and refreshing is set while a lookup is in flight.
+type hostEntry struct {
+	ips        []net.IP
+	resolvedAt time.Time
+	expireAt   time.Time
+	refreshAt  time.Time
+	usedAt     time.Time
+	lastErr    error
+	refreshing *hostLookupCall
+}
+
+// hostLookupCall is an in-flight lookup shared by the resolvers of a host.
+type hostLookupCall struct {
+	done chan struct{}
+	ips  []net.IP
+	err  error
+}
+
+var (
+	hostLookup     HostLookup = lookupHostTTL
+	hostLookupLock sync.RWMutex
+
+	hostCache = struct {
+		sync.Mutex
+		info    conf.HostCacheInfo
+		entries map[string]*hostEntry
+	}{entries: make(map[string]*hostEntry)}
+)
+
+// SetHostLookup sets the lookup of the host names, the default asks the nameservers
+// for the TTL of the records and falls back to the system resolver.
+func SetHostLookup(lookup HostLookup) {
+	hostLookupLock.Lock()
+	defer hostLookupLock.Unlock()
+	hostLookup = lookup
+}
+
+func getHostLookup() HostLookup {
+	hostLookupLock.RLock()
+	defer hostLookupLock.RUnlock()
+	return hostLookup
+}
+
+// ResolveHostAddrs returns the addresses to dial for addr of "host:port" form. An IP
+// address is returned as it is, a host name is resolved to its cached IPs which are
+// refreshed in the background ahead of their expiry. A failed lookup keeps serving
+// the last good IPs until they expire, only an expired or unknown host is looked up
+// by the caller and fails with the lookup.
+func ResolveHostAddrs(ctx context.Context, addr string) (addrs []string, err error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return
+	}
+	if isIPHost(host) {
+		return []string{addr}, nil
+	}
+
+	now := time.Now()
+	hostCache.Lock()
+	e := hostCache.entries[host]
+	if e == nil {
+		e = &hostEntry{}
+		hostCache.entries[host] = e
+	}
+	e.usedAt = now
+	var ips []net.IP
+	if now.Before(e.expireAt) {
+		ips = e.ips
+		if !now.Before(e.refreshAt) && e.refreshing == nil {
+			startHostLookupLocked(host, e)
+		}
+	}
+	var call *hostLookupCall
+	if ips == nil {
+		call = e.refreshing
+		if call == nil {
+			call = startHostLookupLocked(host, e)
+		}
+	}
+	hostCache.Unlock()
+
+	if call != nil {
+		select {
+		case <-call.done:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if ips, err = call.ips, call.err; err != nil {
+			err = errors.Wrapf(err, "resolve host %s failed", host)
+			return
+		}
+	}
+	addrs = make([]string, len(ips))
+	for i, ip := range ips {
+		addrs[i] = net.JoinHostPort(ip.String(), port)
+	}
+	return
+}
+
+// isIPHost returns if host is an IP address, with an optional IPv6 zone.
+func isIPHost(host string) bool {
+	if i := strings.LastIndexByte(host, '%'); i >= 0 {
+		host = host[:i]
+	}
+	return net.ParseIP(host) != nil
+}
+
+// startHostLookupLocked looks up host in the background and updates e with the
+// result, the callers waiting for it share the result of call.
+func startHostLookupLocked(host string, e *hostEntry) (call *hostLookupCall) {
+	call = &hostLookupCall{done: make(chan struct{})}
+	e.refreshing = call
+	lookup := getHostLookup()
+	info := hostCache.info
+	go func() {
+		defer close(call.done)
+		ctx, cancel := context.WithTimeout(context.Background(), hostLookupTimeout)
+		ips, ttl, err := lookup(ctx, host)
+		cancel()
+		if err == nil && len(ips) == 0 {
+			err = ErrNoHostIP
+		}
+
+		now := time.Now()
+		hostCache.Lock()
+		defer hostCache.Unlock()
+		e.refreshing = nil
+		if err != nil {
+			e.lastErr = err
+			// retry ahead of the expiry of the last good IPs
+			e.refreshAt = now.Add(hostMinTTL(info))
+			if now.Before(e.expireAt) {
+				call.ips = e.ips
+				log.WithField("host", host).WithError(err).Warning(
+					"refresh host IPs failed, serve the last good ones")
+			} else {
+				call.err = err
+			}
+			return
+		}
+		ttl = hostTTL(info, ttl)
+		e.ips, e.lastErr = ips, nil
+		e.resolvedAt, e.expireAt = now, now.Add(ttl)
+		// refresh at three quarters of the TTL so a transient failure is retried
+		e.refreshAt = now.Add(ttl - ttl/4)
+		call.ips = ips
+	}()
+	return
+}
+
+// hostTTL bounds the ttl of a lookup by info, zero takes the default TTL.
+func hostTTL(info conf.HostCacheInfo, ttl time.Duration) time.Duration {
+	if ttl <= 0 {
+		if ttl = info.DefaultTTL; ttl <= 0 {
+			ttl = DefaultHostTTL
+		}
+	}
+	maxTTL := info.MaxTTL
+	if maxTTL <= 0 {
+		maxTTL = DefaultHostMaxTTL
+	}
+	if minTTL := hostMinTTL(info); ttl < minTTL {
+		ttl = minTTL
+	}
+	if ttl > maxTTL {
+		ttl = maxTTL
+	}
+	return ttl
+}
+
+func hostMinTTL(info conf.HostCacheInfo) time.Duration {
+	if info.MinTTL > 0 {
+		return info.MinTTL
+	}
+	return DefaultHostMinTTL
+}
+
+// HostCacheEntries returns the cached hosts sorted by name for diagnostics.
+func HostCacheEntries() (entries []HostCacheEntry) {
+	hostCache.Lock()
+	defer hostCache.Unlock()
+	entries = make([]HostCacheEntry, 0, len(hostCache.entries))
+	for host, e := range hostCache.entries {
+		entries = append(entries, HostCacheEntry{
+			Host:       host,
+			IPs:        append([]net.IP(nil), e.ips...),
+			ResolvedAt: e.resolvedAt,
+			ExpireAt:   e.expireAt,
+			LastError:  e.lastErr,
+		})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Host < entries[j].Host })
+	return
+}
+
+// RefreshHostCache sets the TTL bounds of info and refreshes the cached hosts due
+// every info.RefreshInterval until ctx is done, so the IPs are looked up before they
+// expire. A host not resolved in info.Idle is dropped once its IPs expire. The zero
+// fields of info take the defaults.
+func RefreshHostCache(ctx context.Context, info conf.HostCacheInfo) {
+	hostCache.Lock()
+	hostCache.info = info
+	hostCache.Unlock()
+	if info.RefreshInterval <= 0 {
+		info.RefreshInterval = DefaultHostRefreshInterval
+	}
+	go func() {
+		ticker := time.NewTicker(info.RefreshInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				if refreshed, dropped := refreshHostCache(info, now); refreshed > 0 || dropped > 0 {
+					log.WithFields(log.Fields{
+						"refreshed": refreshed,
+						"dropped":   dropped,
+					}).Debug("refresh host cache")
+				}
+			}
+		}
+	}()
+}
+
+// refreshHostCache starts the lookups of the hosts due at now and drops the idle
+// hosts expired.
+func refreshHostCache(info conf.HostCacheInfo, now time.Time) (refreshed, dropped int) {
+	idle := info.Idle
+	if idle <= 0 {
+		idle = DefaultHostIdle
+	}
+	hostCache.Lock()
+	defer hostCache.Unlock()
+	for host, e := range hostCache.entries {
+		if e.refreshing != nil {
+			continue
+		}
+		if now.Sub(e.usedAt) >= idle {
+			if !now.Before(e.expireAt) {
+				delete(hostCache.entries, host)
+				dropped++
+			}
+			continue
+		}
+		if !now.Before(e.refreshAt) {
+			startHostLookupLocked(host, e)
+			refreshed++
+		}
+	}
+	return
+}
+
+// resetHostCache drops all the cached hosts and the TTL bounds.
+func resetHostCache() {
+	hostCache.Lock()
+	defer hostCache.Unlock()
+	hostCache.info = conf.HostCacheInfo{}
+	hostCache.entries = make(map[string]*hostEntry)
+}
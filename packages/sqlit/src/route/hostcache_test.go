@@ -0,0 +1,211 @@
+
+package route
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"sqlit/src/conf"
+)
+
+func TestResolveHostAddrs(t *testing.T) {
+	defer SetHostLookup(getHostLookup())
+	defer resetHostCache()
+
+	var (
+		errDNS  = errors.New("dns is down")
+		lock    sync.Mutex
+		lookups int
+		ips     = []net.IP{net.ParseIP("10.0.0.1"), net.ParseIP("fd00::1")}
+		ttl     = 30 * time.Second
+		fail    error
+		release chan struct{}
+	)
+	SetHostLookup(func(ctx context.Context, host string) ([]net.IP, time.Duration, error) {
+		lock.Lock()
+		lookups++
+		wait, err := release, fail
+		lock.Unlock()
+		if wait != nil {
+			<-wait
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		return ips, ttl, nil
+	})
+	lookupCount := func() int {
+		lock.Lock()
+		defer lock.Unlock()
+		return lookups
+	}
+	entryOf := func(host string) *hostEntry {
+		hostCache.Lock()
+		defer hostCache.Unlock()
+		return hostCache.entries[host]
+	}
+	waitLookup := func(host string) {
+		hostCache.Lock()
+		call := hostCache.entries[host].refreshing
+		hostCache.Unlock()
+		if call != nil {
+			<-call.done
+		}
+	}
+
+	Convey("the IP addresses are not looked up", t, func() {
+		resetHostCache()
+		for _, addr := range []string{"10.0.0.9:4661", "[fe80::1%eth0]:4661", "[::1]:4661"} {
+			addrs, err := ResolveHostAddrs(context.Background(), addr)
+			So(err, ShouldBeNil)
+			So(addrs, ShouldResemble, []string{addr})
+		}
+		So(lookupCount(), ShouldEqual, 0)
+		_, err := ResolveHostAddrs(context.Background(), "no-port")
+		So(err, ShouldNotBeNil)
+	})
+	Convey("the host IPs are cached for the TTL of the records", t, func() {
+		resetHostCache()
+		before := time.Now()
+		for i := 0; i < 3; i++ {
+			addrs, err := ResolveHostAddrs(context.Background(), "bp.example.org:4661")
+			So(err, ShouldBeNil)
+			So(addrs, ShouldResemble, []string{"10.0.0.1:4661", "[fd00::1]:4661"})
+		}
+		So(lookupCount(), ShouldEqual, 1)
+
+		entries := HostCacheEntries()
+		So(entries, ShouldHaveLength, 1)
+		So(entries[0].Host, ShouldEqual, "bp.example.org")
+		So(entries[0].IPs, ShouldResemble, ips)
+		So(entries[0].LastError, ShouldBeNil)
+		So(entries[0].ExpireAt, ShouldHappenOnOrBetween, before.Add(ttl), time.Now().Add(ttl))
+	})
+	Convey("the TTL is bounded and defaulted", t, func() {
+		info := conf.HostCacheInfo{MinTTL: time.Minute, MaxTTL: time.Hour}
+		So(hostTTL(info, time.Second), ShouldEqual, time.Minute)
+		So(hostTTL(info, 2*time.Hour), ShouldEqual, time.Hour)
+		So(hostTTL(info, 0), ShouldEqual, DefaultHostTTL)
+		So(hostTTL(conf.HostCacheInfo{DefaultTTL: 10 * time.Minute}, 0), ShouldEqual, 10*time.Minute)
+		So(hostTTL(conf.HostCacheInfo{}, time.Millisecond), ShouldEqual, DefaultHostMinTTL)
+	})
+	Convey("a failed refresh serves the last good IPs until they expire", t, func() {
+		resetHostCache()
+		lock.Lock()
+		lookups, fail = 0, nil
+		lock.Unlock()
+		_, err := ResolveHostAddrs(context.Background(), "bp.example.org:4661")
+		So(err, ShouldBeNil)
+
+		lock.Lock()
+		fail = errDNS
+		lock.Unlock()
+		defer func() {
+			lock.Lock()
+			fail = nil
+			lock.Unlock()
+		}()
+		// the refresh is due ahead of the expiry
+		e := entryOf("bp.example.org")
+		hostCache.Lock()
+		e.refreshAt = time.Now().Add(-time.Second)
+		hostCache.Unlock()
+		addrs, err := ResolveHostAddrs(context.Background(), "bp.example.org:4661")
+		So(err, ShouldBeNil)
+		So(addrs, ShouldHaveLength, 2)
+		waitLookup("bp.example.org")
+		So(lookupCount(), ShouldEqual, 2)
+
+		addrs, err = ResolveHostAddrs(context.Background(), "bp.example.org:4661")
+		So(err, ShouldBeNil)
+		So(addrs, ShouldHaveLength, 2)
+		entries := HostCacheEntries()
+		So(entries[0].LastError, ShouldEqual, errDNS)
+		So(entries[0].IPs, ShouldResemble, ips)
+
+		// the expired IPs are not served
+		hostCache.Lock()
+		e.expireAt = time.Now().Add(-time.Second)
+		hostCache.Unlock()
+		_, err = ResolveHostAddrs(context.Background(), "bp.example.org:4661")
+		So(errors.Is(err, errDNS), ShouldBeTrue)
+	})
+	Convey("the concurrent resolvers of a host share a lookup", t, func() {
+		resetHostCache()
+		lock.Lock()
+		lookups, release = 0, make(chan struct{})
+		wait := release
+		lock.Unlock()
+		defer func() {
+			lock.Lock()
+			release = nil
+			lock.Unlock()
+		}()
+
+		var (
+			wg       sync.WaitGroup
+			resolved int32
+		)
+		for i := 0; i < 8; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if addrs, err := ResolveHostAddrs(context.Background(), "miner.example.org:4662"); err == nil && len(addrs) == 2 {
+					atomic.AddInt32(&resolved, 1)
+				}
+			}()
+		}
+		// a resolver gives up on its own ctx
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		_, err := ResolveHostAddrs(ctx, "miner.example.org:4662")
+		So(errors.Is(err, context.DeadlineExceeded), ShouldBeTrue)
+
+		close(wait)
+		wg.Wait()
+		So(atomic.LoadInt32(&resolved), ShouldEqual, 8)
+		So(lookupCount(), ShouldEqual, 1)
+	})
+	Convey("the hosts due are refreshed and the idle expired ones dropped", t, func() {
+		resetHostCache()
+		lock.Lock()
+		lookups = 0
+		lock.Unlock()
+		_, err := ResolveHostAddrs(context.Background(), "a.example.org:1")
+		So(err, ShouldBeNil)
+		_, err = ResolveHostAddrs(context.Background(), "b.example.org:1")
+		So(err, ShouldBeNil)
+		So(lookupCount(), ShouldEqual, 2)
+
+		now := time.Now()
+		info := conf.HostCacheInfo{Idle: time.Minute}
+		refreshed, dropped := refreshHostCache(info, now)
+		So(refreshed, ShouldEqual, 0)
+		So(dropped, ShouldEqual, 0)
+
+		refreshed, dropped = refreshHostCache(info, now.Add(ttl))
+		So(refreshed, ShouldEqual, 2)
+		So(dropped, ShouldEqual, 0)
+		waitLookup("a.example.org")
+		waitLookup("b.example.org")
+		So(lookupCount(), ShouldEqual, 4)
+
+		// b is not used for the idle time, it is dropped once expired
+		hostCache.Lock()
+		hostCache.entries["b.example.org"].usedAt = now.Add(-time.Hour)
+		hostCache.entries["b.example.org"].expireAt = now
+		hostCache.Unlock()
+		refreshed, dropped = refreshHostCache(info, now.Add(time.Second))
+		So(refreshed, ShouldEqual, 0)
+		So(dropped, ShouldEqual, 1)
+		So(entryOf("b.example.org"), ShouldBeNil)
+		So(entryOf("a.example.org"), ShouldNotBeNil)
+	})
+}
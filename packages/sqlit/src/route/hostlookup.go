@@ -0,0 +1,206 @@
+
+package route
+
+import (
+	"context"
+	"math/rand"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// dnsQueryTimeout bounds a query of the TTL to a nameserver.
+const dnsQueryTimeout = 2 * time.Second
+
+var (
+	// resolvConfPath is the config of the nameservers asked for the TTL and dnsPort is
+	// their port, they are replaced in unit test
+	resolvConfPath = "/etc/resolv.conf"
+	dnsPort        = "53"
+
+	// systemLookupIP is the system resolver, it is replaced in unit test
+	systemLookupIP = func(ctx context.Context, host string) ([]net.IP, error) {
+		return net.DefaultResolver.LookupIP(ctx, "ip", host)
+	}
+)
+
+// resolvConf is the part of resolv.conf used to ask the TTL.
+type resolvConf struct {
+	nameservers []string
+	ndots       int
+}
+
+// readResolvConf reads the nameservers and the ndots option of path, a missing file
+// has no nameserver.
+func readResolvConf(path string) (rc resolvConf) {
+	rc.ndots = 1
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		switch fields[0] {
+		case "nameserver":
+			if isIPHost(fields[1]) {
+				rc.nameservers = append(rc.nameservers, fields[1])
+			}
+		case "options":
+			for _, opt := range fields[1:] {
+				if v, ok := strings.CutPrefix(opt, "ndots:"); ok {
+					if n, convErr := strconv.Atoi(v); convErr == nil && n >= 0 {
+						rc.ndots = n
+					}
+				}
+			}
+		}
+	}
+	return
+}
+
+// lookupHostTTL is the default HostLookup. The IPs are resolved by the system
+// resolver, e.g. by /etc/hosts and the search domains, as the dials did before the
+// cache. The TTL of a fully qualified host, with a trailing dot or ndots dots of
+// resolv.conf, is the least TTL of its A and AAAA records asked to the nameservers of
+// resolv.conf, it is zero if they do not answer.
+func lookupHostTTL(ctx context.Context, host string) (ips []net.IP, ttl time.Duration, err error) {
+	if ips, err = systemLookupIP(ctx, host); err != nil {
+		return
+	}
+	rc := readResolvConf(resolvConfPath)
+	if len(rc.nameservers) == 0 {
+		return
+	}
+	if !strings.HasSuffix(host, ".") && strings.Count(host, ".") < rc.ndots {
+		return
+	}
+	ttl, _ = queryHostTTL(ctx, rc.nameservers, host)
+	return
+}
+
+// queryHostTTL returns the least TTL of the A and AAAA records of host answered by the
+// first nameserver of servers having them.
+func queryHostTTL(ctx context.Context, servers []string, host string) (ttl time.Duration, err error) {
+	for _, server := range servers {
+		var found bool
+		for _, qtype := range []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA} {
+			var (
+				t  time.Duration
+				ok bool
+			)
+			if t, ok, err = queryTTL(ctx, server, host, qtype); err != nil {
+				found = false
+				break
+			}
+			if ok && (!found || t < ttl) {
+				ttl, found = t, true
+			}
+		}
+		if found {
+			return ttl, nil
+		}
+	}
+	if err == nil {
+		err = ErrNoHostIP
+	}
+	return 0, err
+}
+
+// queryTTL asks server for the records of type qtype of host, ok is set if any is
+// answered and ttl is the least TTL of them and the CNAME records leading to them.
+func queryTTL(ctx context.Context, server, host string, qtype dnsmessage.Type) (ttl time.Duration, ok bool, err error) {
+	if !strings.HasSuffix(host, ".") {
+		host += "."
+	}
+	name, err := dnsmessage.NewName(host)
+	if err != nil {
+		return
+	}
+	id := uint16(rand.Uint32())
+	query := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: id, RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: name, Type: qtype, Class: dnsmessage.ClassINET}},
+	}
+	req, err := query.Pack()
+	if err != nil {
+		return
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", net.JoinHostPort(server, dnsPort))
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	deadline := time.Now().Add(dnsQueryTimeout)
+	if d, has := ctx.Deadline(); has && d.Before(deadline) {
+		deadline = d
+	}
+	if err = conn.SetDeadline(deadline); err != nil {
+		return
+	}
+	if _, err = conn.Write(req); err != nil {
+		return
+	}
+
+	buf := make([]byte, 1500)
+	for {
+		var n int
+		if n, err = conn.Read(buf); err != nil {
+			return
+		}
+		var (
+			p      dnsmessage.Parser
+			header dnsmessage.Header
+		)
+		if header, err = p.Start(buf[:n]); err != nil || header.ID != id || !header.Response {
+			// not the answer of the query
+			continue
+		}
+		if header.RCode != dnsmessage.RCodeSuccess {
+			err = errors.Errorf("query %s of %s: %s", qtype, host, header.RCode)
+			return
+		}
+		if err = p.SkipAllQuestions(); err != nil {
+			return
+		}
+		var cnameTTL time.Duration
+		for {
+			var rh dnsmessage.ResourceHeader
+			if rh, err = p.AnswerHeader(); err == dnsmessage.ErrSectionDone {
+				err = nil
+				break
+			} else if err != nil {
+				return
+			}
+			t := time.Duration(rh.TTL) * time.Second
+			switch {
+			case rh.Class != dnsmessage.ClassINET:
+			case rh.Type == qtype:
+				if !ok || t < ttl {
+					ttl = t
+				}
+				ok = true
+			case rh.Type == dnsmessage.TypeCNAME:
+				if cnameTTL == 0 || t < cnameTTL {
+					cnameTTL = t
+				}
+			}
+			if err = p.SkipAnswer(); err != nil {
+				return
+			}
+		}
+		if ok && cnameTTL > 0 && cnameTTL < ttl {
+			ttl = cnameTTL
+		}
+		return
+	}
+}
@@ -0,0 +1,127 @@
+
+package route
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// serveTestDNS answers the A and AAAA queries on conn by records, a name without
+// records is answered NXDOMAIN.
+func serveTestDNS(conn net.PacketConn, records map[string][]dnsmessage.Resource) {
+	buf := make([]byte, 1500)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		var p dnsmessage.Parser
+		header, err := p.Start(buf[:n])
+		if err != nil {
+			continue
+		}
+		q, err := p.Question()
+		if err != nil {
+			continue
+		}
+		resp := dnsmessage.Message{
+			Header:    dnsmessage.Header{ID: header.ID, Response: true},
+			Questions: []dnsmessage.Question{q},
+		}
+		answers, ok := records[q.Name.String()]
+		if !ok {
+			resp.Header.RCode = dnsmessage.RCodeNameError
+		}
+		for _, r := range answers {
+			if r.Header.Type == q.Type || r.Header.Type == dnsmessage.TypeCNAME {
+				resp.Answers = append(resp.Answers, r)
+			}
+		}
+		if out, packErr := resp.Pack(); packErr == nil {
+			_, _ = conn.WriteTo(out, addr)
+		}
+	}
+}
+
+func TestLookupHostTTL(t *testing.T) {
+	defer func(path, port string) { resolvConfPath, dnsPort = path, port }(resolvConfPath, dnsPort)
+	defer func(saved func(context.Context, string) ([]net.IP, error)) { systemLookupIP = saved }(systemLookupIP)
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	record := func(name string, ttl uint32, body dnsmessage.ResourceBody) dnsmessage.Resource {
+		var rtype dnsmessage.Type
+		switch body.(type) {
+		case *dnsmessage.AResource:
+			rtype = dnsmessage.TypeA
+		case *dnsmessage.AAAAResource:
+			rtype = dnsmessage.TypeAAAA
+		case *dnsmessage.CNAMEResource:
+			rtype = dnsmessage.TypeCNAME
+		}
+		return dnsmessage.Resource{
+			Header: dnsmessage.ResourceHeader{Name: dnsmessage.MustNewName(name), Type: rtype, Class: dnsmessage.ClassINET, TTL: ttl},
+			Body:   body,
+		}
+	}
+	go serveTestDNS(conn, map[string][]dnsmessage.Resource{
+		"bp.example.org.": {
+			record("bp.example.org.", 300, &dnsmessage.AResource{A: [4]byte{10, 0, 0, 1}}),
+			record("bp.example.org.", 120, &dnsmessage.AAAAResource{AAAA: [16]byte{0xfd, 15: 1}}),
+		},
+		"alias.example.org.": {
+			record("alias.example.org.", 30, &dnsmessage.CNAMEResource{CNAME: dnsmessage.MustNewName("bp.example.org.")}),
+			record("bp.example.org.", 300, &dnsmessage.AResource{A: [4]byte{10, 0, 0, 1}}),
+		},
+	})
+
+	_, dnsPort, _ = net.SplitHostPort(conn.LocalAddr().String())
+	resolvConfPath = filepath.Join(t.TempDir(), "resolv.conf")
+	if err = os.WriteFile(resolvConfPath, []byte(
+		"# test\nsearch example.org\nnameserver 127.0.0.1\noptions ndots:2 timeout:1\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	systemIPs := []net.IP{net.ParseIP("10.0.0.1")}
+	systemLookupIP = func(ctx context.Context, host string) ([]net.IP, error) {
+		return systemIPs, nil
+	}
+
+	Convey("the resolv.conf is parsed", t, func() {
+		rc := readResolvConf(resolvConfPath)
+		So(rc.nameservers, ShouldResemble, []string{"127.0.0.1"})
+		So(rc.ndots, ShouldEqual, 2)
+		So(readResolvConf(filepath.Join(t.TempDir(), "missing")), ShouldResemble, resolvConf{ndots: 1})
+	})
+	Convey("the TTL is the least of the records", t, func() {
+		ips, ttl, err := lookupHostTTL(context.Background(), "bp.example.org")
+		So(err, ShouldBeNil)
+		So(ips, ShouldResemble, systemIPs)
+		So(ttl, ShouldEqual, 120*time.Second)
+
+		// the CNAME is part of the answer
+		_, ttl, err = lookupHostTTL(context.Background(), "alias.example.org.")
+		So(err, ShouldBeNil)
+		So(ttl, ShouldEqual, 30*time.Second)
+	})
+	Convey("the TTL is unknown without an answer", t, func() {
+		// the searched names are resolved by the system resolver only
+		_, ttl, err := lookupHostTTL(context.Background(), "bp.example")
+		So(err, ShouldBeNil)
+		So(ttl, ShouldEqual, 0)
+		// the names of the hosts file are not in DNS
+		ips, ttl, err := lookupHostTTL(context.Background(), "local.example.org")
+		So(err, ShouldBeNil)
+		So(ips, ShouldResemble, systemIPs)
+		So(ttl, ShouldEqual, 0)
+	})
+}
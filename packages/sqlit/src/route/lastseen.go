@@ -0,0 +1,124 @@
+
+package route
+
+import (
+	"sync"
+	"time"
+
+	"sqlit/src/conf"
+	"sqlit/src/proto"
+)
+
+const (
+	// DefaultStaleAfter is how long after the last seen time a node is degraded if
+	// conf.RouteProbeInfo.StaleAfter is not set.
+	DefaultStaleAfter = time.Minute
+	// DefaultDeadAfter is how long after the last seen time a node is dead if
+	// conf.RouteProbeInfo.DeadAfter is not set.
+	DefaultDeadAfter = 5 * time.Minute
+)
+
+// Liveness is the state of a node by the time it is last seen.
+type Liveness int
+
+const (
+	// LivenessUnknown is a node never seen
+	LivenessUnknown Liveness = iota
+	// LivenessAlive is a node seen within StaleAfter
+	LivenessAlive
+	// LivenessDegraded is a node seen within DeadAfter but not StaleAfter
+	LivenessDegraded
+	// LivenessDead is a node not seen within DeadAfter
+	LivenessDead
+)
+
+// String returns the name of the liveness.
+func (l Liveness) String() string {
+	switch l {
+	case LivenessAlive:
+		return "alive"
+	case LivenessDegraded:
+		return "degraded"
+	case LivenessDead:
+		return "dead"
+	}
+	return "unknown"
+}
+
+// MarshalText implements encoding.TextMarshaler.
+func (l Liveness) MarshalText() ([]byte, error) {
+	return []byte(l.String()), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler, an unknown name is
+// LivenessUnknown.
+func (l *Liveness) UnmarshalText(text []byte) error {
+	*l = LivenessUnknown
+	for _, candidate := range []Liveness{LivenessAlive, LivenessDegraded, LivenessDead} {
+		if candidate.String() == string(text) {
+			*l = candidate
+		}
+	}
+	return nil
+}
+
+var (
+	// lastSeen holds the last time of a successful communication with the nodes, it
+	// is kept apart from the address TTL and the probe state as a reachable address
+	// says nothing about the node behind it
+	lastSeen     = make(map[proto.NodeID]time.Time)
+	lastSeenLock sync.RWMutex
+)
+
+// MarkSeen records a successful communication with node id now, e.g. by the rpc
+// caller after each successful call.
+func MarkSeen(id proto.NodeID) {
+	now := time.Now()
+	lastSeenLock.Lock()
+	defer lastSeenLock.Unlock()
+	if now.After(lastSeen[id]) {
+		lastSeen[id] = now
+	}
+}
+
+// LastSeen returns the last time MarkSeen is called for node id, false if never.
+func LastSeen(id proto.NodeID) (seen time.Time, ok bool) {
+	lastSeenLock.RLock()
+	defer lastSeenLock.RUnlock()
+	seen, ok = lastSeen[id]
+	return
+}
+
+// NodeLiveness returns the liveness of node id by its last seen time and the
+// staleness thresholds of info, the zero fields take the defaults.
+func NodeLiveness(id proto.NodeID, info conf.RouteProbeInfo) (liveness Liveness, seen time.Time) {
+	seen, ok := LastSeen(id)
+	if !ok {
+		return LivenessUnknown, seen
+	}
+	return livenessAt(seen, info, time.Now()), seen
+}
+
+// livenessAt returns the liveness at now of a node last seen at seen.
+func livenessAt(seen time.Time, info conf.RouteProbeInfo, now time.Time) Liveness {
+	if info.StaleAfter <= 0 {
+		info.StaleAfter = DefaultStaleAfter
+	}
+	if info.DeadAfter <= 0 {
+		info.DeadAfter = DefaultDeadAfter
+	}
+	switch elapsed := now.Sub(seen); {
+	case elapsed > info.DeadAfter:
+		return LivenessDead
+	case elapsed > info.StaleAfter:
+		return LivenessDegraded
+	}
+	return LivenessAlive
+}
+
+// resetLastSeen drops the last seen time of all nodes.
+func resetLastSeen() {
+	lastSeenLock.Lock()
+	defer lastSeenLock.Unlock()
+	lastSeen = make(map[proto.NodeID]time.Time)
+}
@@ -0,0 +1,99 @@
+
+package route
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"sqlit/src/conf"
+	"sqlit/src/crypto/hash"
+	"sqlit/src/proto"
+)
+
+func TestLastSeen(t *testing.T) {
+	defer func(saved *conf.Config) { conf.GConf = saved }(conf.GConf)
+	conf.GConf = &conf.Config{}
+	defer SetNodeAddrProber(getNodeAddrProber())
+	defer resetLastSeen()
+
+	var (
+		raw  = proto.RawNodeID{Hash: hash.Hash([32]byte{0xef, 0x01})}
+		id   = proto.NodeID(raw.String())
+		info = conf.RouteProbeInfo{StaleAfter: time.Minute, DeadAfter: 3 * time.Minute}
+	)
+
+	Convey("the last seen time tells the node liveness", t, func() {
+		resetLastSeen()
+		_, ok := LastSeen(id)
+		So(ok, ShouldBeFalse)
+		liveness, seen := NodeLiveness(id, info)
+		So(liveness, ShouldEqual, LivenessUnknown)
+		So(seen.IsZero(), ShouldBeTrue)
+
+		before := time.Now()
+		MarkSeen(id)
+		seen, ok = LastSeen(id)
+		So(ok, ShouldBeTrue)
+		So(seen, ShouldHappenOnOrAfter, before)
+		liveness, _ = NodeLiveness(id, info)
+		So(liveness, ShouldEqual, LivenessAlive)
+
+		So(livenessAt(seen, info, seen.Add(time.Minute)), ShouldEqual, LivenessAlive)
+		So(livenessAt(seen, info, seen.Add(2*time.Minute)), ShouldEqual, LivenessDegraded)
+		So(livenessAt(seen, info, seen.Add(4*time.Minute)), ShouldEqual, LivenessDead)
+		// the zero thresholds take the defaults
+		So(livenessAt(seen, conf.RouteProbeInfo{}, seen.Add(DefaultStaleAfter+time.Second)),
+			ShouldEqual, LivenessDegraded)
+		So(livenessAt(seen, conf.RouteProbeInfo{}, seen.Add(DefaultDeadAfter+time.Second)),
+			ShouldEqual, LivenessDead)
+
+		out, err := json.Marshal([]Liveness{LivenessAlive, LivenessDead})
+		So(err, ShouldBeNil)
+		So(string(out), ShouldEqual, `["alive","dead"]`)
+		var decoded []Liveness
+		So(json.Unmarshal(out, &decoded), ShouldBeNil)
+		So(decoded, ShouldResemble, []Liveness{LivenessAlive, LivenessDead})
+	})
+	Convey("the last seen time is apart from the address state", t, func() {
+		resetLastSeen()
+		setResolveCache(make(NodeIDAddressMap))
+		So(SetNodeAddrCache(&raw, "10.0.0.9:1"), ShouldBeNil)
+		SetNodeAddrProber(func(ctx context.Context, addr string) error { return nil })
+		_, err := GetNodeAddrCache(&raw)
+		So(err, ShouldBeNil)
+
+		// a reachable address is not a seen node
+		now := time.Now()
+		So(probeNodeAddrCache(context.Background(), info, now), ShouldEqual, 1)
+		status, err := NodeHealth(&raw)
+		So(err, ShouldBeNil)
+		So(status.Reachable, ShouldBeTrue)
+		So(status.LastSeen.IsZero(), ShouldBeTrue)
+
+		MarkSeen(id)
+		status, err = NodeHealth(&raw)
+		So(err, ShouldBeNil)
+		So(status.LastSeen.IsZero(), ShouldBeFalse)
+
+		// the probe rounds follow the liveness of the used nodes
+		So(probeNodeAddrCache(context.Background(), info, now), ShouldEqual, 1)
+		healthLock.Lock()
+		So(health[raw].liveness, ShouldEqual, LivenessAlive)
+		healthLock.Unlock()
+		_, err = GetNodeAddrCache(&raw)
+		So(err, ShouldBeNil)
+		So(probeNodeAddrCache(context.Background(), info, now.Add(2*time.Minute)), ShouldEqual, 1)
+		healthLock.Lock()
+		So(health[raw].liveness, ShouldEqual, LivenessDegraded)
+		healthLock.Unlock()
+
+		// a removed address keeps the last seen time
+		So(DelNodeAddrCache(&raw), ShouldBeNil)
+		_, ok := LastSeen(id)
+		So(ok, ShouldBeTrue)
+	})
+}
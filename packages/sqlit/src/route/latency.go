@@ -0,0 +1,110 @@
+
+package route
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"sqlit/src/proto"
+)
+
+const (
+	// DefaultLatencyExploreRate is the chance of SelectNode ignoring the latencies if
+	// SetLatencyExploreRate is not called.
+	DefaultLatencyExploreRate = 0.1
+
+	// latencyAlpha is the weight of a new sample in the moving average
+	latencyAlpha = 0.2
+)
+
+var (
+	// latencies holds the moving average round-trip latency of the nodes
+	latencies      = make(map[proto.NodeID]time.Duration)
+	latencyExplore = DefaultLatencyExploreRate
+	latencyLock    sync.RWMutex
+)
+
+// RecordLatency feeds the round-trip latency d of a call to node id, e.g. by the
+// rpc caller after each successful call. The estimate is an exponentially weighted
+// moving average of the samples, negative samples are ignored.
+func RecordLatency(id proto.NodeID, d time.Duration) {
+	if d < 0 {
+		return
+	}
+	latencyLock.Lock()
+	defer latencyLock.Unlock()
+	if estimate, ok := latencies[id]; ok {
+		d = estimate + time.Duration(latencyAlpha*float64(d-estimate))
+	}
+	latencies[id] = d
+}
+
+// LatencyEstimates returns the current latency estimates of the nodes sampled by
+// RecordLatency, e.g. for the metrics endpoint.
+func LatencyEstimates() (estimates map[proto.NodeID]time.Duration) {
+	latencyLock.RLock()
+	defer latencyLock.RUnlock()
+	estimates = make(map[proto.NodeID]time.Duration, len(latencies))
+	for id, d := range latencies {
+		estimates[id] = d
+	}
+	return
+}
+
+// SetLatencyExploreRate sets the chance in [0, 1] of SelectNode picking among the
+// nodes of equal weight regardless of their latencies, so that the estimates of
+// the slower nodes are kept fresh. A rate out of range resets it to
+// DefaultLatencyExploreRate.
+func SetLatencyExploreRate(rate float64) {
+	if rate < 0 || rate > 1 {
+		rate = DefaultLatencyExploreRate
+	}
+	latencyLock.Lock()
+	defer latencyLock.Unlock()
+	latencyExplore = rate
+}
+
+// fastestNodes returns the nodes of the lowest latency estimate in candidates
+// order, or candidates as is when exploring. The nodes never sampled are
+// estimated by the mean of all the estimates so they are not starved, the mean
+// does not depend on the candidates so a tie is not broken by their order.
+func fastestNodes(candidates []proto.NodeID) (fastest []proto.NodeID) {
+	if len(candidates) < 2 {
+		return candidates
+	}
+	latencyLock.RLock()
+	defer latencyLock.RUnlock()
+	if latencyExplore > 0 && rand.Float64() < latencyExplore {
+		return candidates
+	}
+	sampled := false
+	for _, id := range candidates {
+		if _, ok := latencies[id]; ok {
+			sampled = true
+			break
+		}
+	}
+	if !sampled {
+		return candidates
+	}
+	var sum time.Duration
+	for _, d := range latencies {
+		sum += d
+	}
+	neutral := sum / time.Duration(len(latencies))
+	var best time.Duration
+	for _, id := range candidates {
+		d, ok := latencies[id]
+		if !ok {
+			d = neutral
+		}
+		switch {
+		case len(fastest) == 0 || d < best:
+			fastest, best = []proto.NodeID{id}, d
+		case d == best:
+			fastest = append(fastest, id)
+		}
+	}
+	return
+}
@@ -0,0 +1,84 @@
+
+package route
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"sqlit/src/conf"
+	"sqlit/src/proto"
+)
+
+// resetLatencies clears the latency estimates and the select round of the previous
+// tests.
+func resetLatencies() {
+	latencyLock.Lock()
+	defer latencyLock.Unlock()
+	latencies = make(map[proto.NodeID]time.Duration)
+	atomic.StoreUint64(&selectRound, 0)
+}
+
+func TestSelectNodeLatency(t *testing.T) {
+	defer func(saved *conf.Config) { conf.GConf = saved }(conf.GConf)
+	defer resetLatencies()
+	defer SetLatencyExploreRate(DefaultLatencyExploreRate)
+
+	var (
+		fast   = proto.NodeID("00000000000000000000000000000000000000000000000000000000000000c1")
+		slow   = proto.NodeID("00000000000000000000000000000000000000000000000000000000000000c2")
+		fresh  = proto.NodeID("00000000000000000000000000000000000000000000000000000000000000c3")
+		better = proto.NodeID("00000000000000000000000000000000000000000000000000000000000000c4")
+	)
+	conf.GConf = &conf.Config{KnownNodes: []proto.Node{
+		{ID: fast, Addr: "10.0.1.1:1"},
+		{ID: slow, Addr: "10.0.1.2:1"},
+		{ID: fresh, Addr: "10.0.1.3:1"},
+		{ID: better, Addr: "10.0.1.4:1", Weight: 1},
+	}}
+
+	Convey("the estimates are moving averages", t, func() {
+		resetLatencies()
+		RecordLatency(fast, 10*time.Millisecond)
+		RecordLatency(fast, 20*time.Millisecond)
+		RecordLatency(slow, -time.Second)
+		So(LatencyEstimates(), ShouldResemble, map[proto.NodeID]time.Duration{fast: 12 * time.Millisecond})
+	})
+	Convey("the lower latency wins among the equal weights", t, func() {
+		resetLatencies()
+		setResolveCache(make(NodeIDAddressMap))
+		SetLatencyExploreRate(0)
+		candidates := []proto.NodeID{slow, fresh, fast}
+		RecordLatency(fast, 10*time.Millisecond)
+		RecordLatency(slow, 50*time.Millisecond)
+		for i := 0; i < 3; i++ {
+			id, err := SelectNode(candidates)
+			So(err, ShouldBeNil)
+			So(id, ShouldEqual, fast)
+		}
+		// the unsampled node is estimated by the mean of all the estimates, 30ms, so
+		// it is faster than slow whatever the round is
+		for i := 0; i < 2; i++ {
+			id, err := SelectNode([]proto.NodeID{slow, fresh})
+			So(err, ShouldBeNil)
+			So(id, ShouldEqual, fresh)
+		}
+		// the weight goes first
+		RecordLatency(better, time.Second)
+		id, err := SelectNode(append(candidates, better))
+		So(err, ShouldBeNil)
+		So(id, ShouldEqual, better)
+
+		// exploring ignores the latencies
+		SetLatencyExploreRate(1)
+		picked := make(map[proto.NodeID]int)
+		for i := 0; i < 3; i++ {
+			id, err := SelectNode(candidates)
+			So(err, ShouldBeNil)
+			picked[id]++
+		}
+		So(picked, ShouldResemble, map[proto.NodeID]int{slow: 1, fresh: 1, fast: 1})
+	})
+}
@@ -0,0 +1,83 @@
+
+package route
+
+import (
+	"sqlit/src/conf"
+	"sqlit/src/crypto/kms"
+	"sqlit/src/proto"
+)
+
+// locality is the region and zone tags of a node.
+type locality struct {
+	region, zone string
+}
+
+// MatchLocality returns the candidates in region and zone in candidates order, an
+// empty region or zone matches any and so does a node without the tag, a missing
+// tag never excludes a node. The tags are of the known node in conf.GConf, else of
+// the node in kms.
+func MatchLocality(candidates []proto.NodeID, region, zone string) (matched []proto.NodeID) {
+	known := knownNodeLocalities()
+	for _, id := range candidates {
+		if l := nodeLocality(known, id); tagMatches(l.region, region) && tagMatches(l.zone, zone) {
+			matched = append(matched, id)
+		}
+	}
+	return
+}
+
+// SelectLocalNode picks a node by SelectNode preferring the locality of the local
+// node conf.GConf.ThisNodeID: the candidates of its zone first, then of its region,
+// then any. The next tier is tried if no node of a tier is reachable.
+func SelectLocalNode(candidates []proto.NodeID) (selected proto.NodeID, err error) {
+	if len(candidates) == 0 {
+		return "", ErrNoNodeCandidate
+	}
+	var local locality
+	if conf.GConf != nil {
+		local = nodeLocality(knownNodeLocalities(), conf.GConf.ThisNodeID)
+	}
+	tiers := [][]proto.NodeID{
+		MatchLocality(candidates, local.region, local.zone),
+		MatchLocality(candidates, local.region, ""),
+		candidates,
+	}
+	tried := 0
+	for _, tier := range tiers {
+		// a tier adds nodes to the previous one, or is the same
+		if len(tier) == tried {
+			continue
+		}
+		tried = len(tier)
+		if selected, err = SelectNode(tier); err != ErrNoReachableNode {
+			return
+		}
+	}
+	return
+}
+
+func tagMatches(tag, want string) bool {
+	return tag == "" || want == "" || tag == want
+}
+
+// knownNodeLocalities returns the localities of the known nodes in conf.GConf.
+func knownNodeLocalities() (localities map[proto.NodeID]locality) {
+	localities = make(map[proto.NodeID]locality)
+	if conf.GConf == nil {
+		return
+	}
+	for _, n := range conf.GConf.KnownNodes {
+		localities[n.ID] = locality{region: n.Region, zone: n.Zone}
+	}
+	return
+}
+
+func nodeLocality(known map[proto.NodeID]locality, id proto.NodeID) (l locality) {
+	if l, ok := known[id]; ok {
+		return l
+	}
+	if node, err := kms.GetNodeInfo(id); err == nil && node != nil {
+		l = locality{region: node.Region, zone: node.Zone}
+	}
+	return
+}
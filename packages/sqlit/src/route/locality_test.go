@@ -0,0 +1,90 @@
+
+package route
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"sqlit/src/conf"
+	"sqlit/src/proto"
+)
+
+func TestSelectLocalNode(t *testing.T) {
+	defer func(saved *conf.Config) { conf.GConf = saved }(conf.GConf)
+	defer SetNodeAddrProber(getNodeAddrProber())
+
+	var (
+		local    = proto.NodeID("00000000000000000000000000000000000000000000000000000000000000e0")
+		sameZone = proto.NodeID("00000000000000000000000000000000000000000000000000000000000000e1")
+		sameReg  = proto.NodeID("00000000000000000000000000000000000000000000000000000000000000e2")
+		untagged = proto.NodeID("00000000000000000000000000000000000000000000000000000000000000e3")
+		far      = proto.NodeID("00000000000000000000000000000000000000000000000000000000000000e4")
+	)
+	conf.GConf = &conf.Config{
+		ThisNodeID: local,
+		KnownNodes: []proto.Node{
+			{ID: local, Addr: "10.0.1.1:1", Region: "eu-west", Zone: "eu-west-1a"},
+			{ID: sameZone, Addr: "10.0.1.2:1", Region: "eu-west", Zone: "eu-west-1a"},
+			{ID: sameReg, Addr: "10.0.1.3:1", Region: "eu-west", Zone: "eu-west-1b"},
+			{ID: untagged, Addr: "10.0.2.1:1"},
+			{ID: far, Addr: "10.0.3.1:1", Region: "us-east", Weight: 10},
+		},
+	}
+	candidates := []proto.NodeID{far, untagged, sameReg, sameZone}
+
+	Convey("the candidates are matched by region and zone", t, func() {
+		So(MatchLocality(candidates, "eu-west", "eu-west-1a"), ShouldResemble, []proto.NodeID{untagged, sameZone})
+		So(MatchLocality(candidates, "eu-west", ""), ShouldResemble, []proto.NodeID{untagged, sameReg, sameZone})
+		So(MatchLocality(candidates, "us-east", ""), ShouldResemble, []proto.NodeID{far, untagged})
+		So(MatchLocality(candidates, "", ""), ShouldResemble, candidates)
+	})
+	Convey("the local zone is preferred over the weight", t, func() {
+		setResolveCache(make(NodeIDAddressMap))
+		picked := make(map[proto.NodeID]int)
+		for i := 0; i < 4; i++ {
+			id, err := SelectLocalNode(candidates)
+			So(err, ShouldBeNil)
+			picked[id]++
+		}
+		So(picked, ShouldResemble, map[proto.NodeID]int{untagged: 2, sameZone: 2})
+
+		_, err := SelectLocalNode(nil)
+		So(err, ShouldEqual, ErrNoNodeCandidate)
+
+		// an untagged local node prefers none
+		conf.GConf.ThisNodeID = untagged
+		defer func() { conf.GConf.ThisNodeID = local }()
+		id, err := SelectLocalNode(candidates)
+		So(err, ShouldBeNil)
+		So(id, ShouldEqual, far)
+	})
+	Convey("the next tier is tried if the local one is unreachable", t, func() {
+		setResolveCache(make(NodeIDAddressMap))
+		for _, n := range conf.GConf.KnownNodes {
+			So(SetNodeAddrCache(n.ID.ToRawNodeID(), n.Addr), ShouldBeNil)
+			_, err := GetNodeAddrCache(n.ID.ToRawNodeID())
+			So(err, ShouldBeNil)
+		}
+		reachable := map[string]bool{"10.0.1.3:1": true, "10.0.3.1:1": true}
+		SetNodeAddrProber(func(ctx context.Context, addr string) error {
+			if reachable[addr] {
+				return nil
+			}
+			return errors.New("dial failed")
+		})
+		So(probeNodeAddrCache(context.Background(), conf.RouteProbeInfo{FailureThreshold: 1}, time.Now()), ShouldEqual, 5)
+
+		id, err := SelectLocalNode(candidates)
+		So(err, ShouldBeNil)
+		So(id, ShouldEqual, sameReg)
+		id, err = SelectLocalNode([]proto.NodeID{sameZone, far})
+		So(err, ShouldBeNil)
+		So(id, ShouldEqual, far)
+		_, err = SelectLocalNode([]proto.NodeID{sameZone, untagged})
+		So(err, ShouldEqual, ErrNoReachableNode)
+	})
+}
@@ -0,0 +1,125 @@
+
+package route
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"sqlit/src/proto"
+	"sqlit/src/utils/log"
+)
+
+var (
+	// ErrNodeNotFound indicates the node is not found after looking up all the DHT
+	// peers, it is not returned for transport failures
+	ErrNodeNotFound = errors.New("node not found")
+
+	// ErrNoNodeLookup indicates no lookup is set to resolve uncached node addr
+	ErrNoNodeLookup = errors.New("no node lookup")
+)
+
+// NodeLookup looks up the node by id from the DHT, it returns ErrNodeNotFound if
+// all the DHT peers answered the node is unknown.
+type NodeLookup func(ctx context.Context, id proto.RawNodeID) (node *proto.Node, err error)
+
+var (
+	nodeLookup     NodeLookup
+	nodeLookupLock sync.RWMutex
+
+	// lookups holds the in-flight lookups, concurrent resolvers of the same id
+	// share one lookup
+	lookups     = make(map[proto.RawNodeID]*lookupCall)
+	lookupsLock sync.Mutex
+)
+
+// lookupCall is an in-flight or finished lookup.
+type lookupCall struct {
+	done chan struct{}
+	node *proto.Node
+	err  error
+}
+
+// SetNodeLookup sets the lookup used by ResolveNodeAddr on cache miss, rpc sets it
+// to find node in the block producers.
+func SetNodeLookup(lookup NodeLookup) {
+	nodeLookupLock.Lock()
+	defer nodeLookupLock.Unlock()
+	nodeLookup = lookup
+}
+
+func getNodeLookup() NodeLookup {
+	nodeLookupLock.RLock()
+	defer nodeLookupLock.RUnlock()
+	return nodeLookup
+}
+
+// ResolveNodeAddr returns the first reachable addr of id from cache, an uncached,
+// stale or unreachable entry is looked up by the NodeLookup and cached on success. A
+// cached addr is still returned if the lookup fails for transport reasons,
+// ErrNodeNotFound is returned if the node is not found in the DHT. ErrNodeBanned is
+// returned for a node banned by Ban.
+func ResolveNodeAddr(ctx context.Context, id proto.RawNodeID) (addr string, err error) {
+	if IsBanned(id.ToNodeID()) {
+		return "", ErrNodeBanned
+	}
+	var entry NodeAddrCacheEntry
+	if entry, err = GetNodeAddrCacheEntry(&id); err == nil && !entry.Unreachable {
+		return entry.Addrs[0], nil
+	} else if err != nil && err != ErrUnknownNodeID && err != ErrStaleNodeAddr {
+		return
+	}
+
+	var node *proto.Node
+	if node, err = lookupNode(ctx, id); err != nil {
+		if len(entry.Addrs) > 0 && err != ErrNodeNotFound {
+			log.WithField("target", id.String()).WithError(err).Warning(
+				"refresh stale node addr failed, use the stale one")
+			return entry.Addrs[0], nil
+		}
+		return
+	}
+	candidates := node.AddrCandidates()
+	if len(candidates) == 0 {
+		return "", ErrNodeNotFound
+	}
+	// keep the TTL of the refreshed entry
+	_ = SetNodeAddrCacheTTL(&id, candidates[0], entry.TTL, candidates[1:]...)
+	return candidates[0], nil
+}
+
+// lookupNode looks up id with the NodeLookup. The lookup runs with ctx of the caller
+// starting it, the other callers waiting for it return on their own ctx done.
+func lookupNode(ctx context.Context, id proto.RawNodeID) (node *proto.Node, err error) {
+	lookup := getNodeLookup()
+	if lookup == nil {
+		return nil, ErrNoNodeLookup
+	}
+
+	lookupsLock.Lock()
+	call, ok := lookups[id]
+	if !ok {
+		call = &lookupCall{done: make(chan struct{})}
+		lookups[id] = call
+		go func() {
+			defer func() {
+				lookupsLock.Lock()
+				delete(lookups, id)
+				lookupsLock.Unlock()
+				close(call.done)
+			}()
+			call.node, call.err = lookup(ctx, id)
+			if call.err == nil && call.node == nil {
+				call.err = ErrNodeNotFound
+			}
+		}()
+	}
+	lookupsLock.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-call.done:
+		return call.node, call.err
+	}
+}
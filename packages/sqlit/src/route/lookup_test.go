@@ -0,0 +1,118 @@
+
+package route
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"sqlit/src/conf"
+	"sqlit/src/crypto/hash"
+	"sqlit/src/proto"
+)
+
+func TestResolveNodeAddr(t *testing.T) {
+	_, testFile, _, _ := runtime.Caller(0)
+	confFile := filepath.Join(filepath.Dir(testFile), "../../test/node_c/config.yaml")
+	conf.GConf, _ = conf.LoadConfig(confFile)
+	defer SetNodeLookup(getNodeLookup())
+
+	var (
+		cached  = proto.RawNodeID{Hash: hash.Hash([32]byte{0xdd, 0x01})}
+		remote  = proto.RawNodeID{Hash: hash.Hash([32]byte{0xdd, 0x02})}
+		missing = proto.RawNodeID{Hash: hash.Hash([32]byte{0xdd, 0x03})}
+		broken  = proto.RawNodeID{Hash: hash.Hash([32]byte{0xdd, 0x04})}
+		slow    = proto.RawNodeID{Hash: hash.Hash([32]byte{0xdd, 0x05})}
+		errDial = errors.New("dial failed")
+	)
+
+	Convey("cache first and dht on miss", t, func() {
+		setResolveCache(make(NodeIDAddressMap))
+		SetNodeLookup(nil)
+		So(SetNodeAddrCache(&cached, "a:1"), ShouldBeNil)
+		addr, err := ResolveNodeAddr(context.Background(), cached)
+		So(err, ShouldBeNil)
+		So(addr, ShouldEqual, "a:1")
+		_, err = ResolveNodeAddr(context.Background(), remote)
+		So(err, ShouldEqual, ErrNoNodeLookup)
+
+		var (
+			calls   int32
+			release = make(chan struct{})
+		)
+		SetNodeLookup(func(ctx context.Context, id proto.RawNodeID) (*proto.Node, error) {
+			atomic.AddInt32(&calls, 1)
+			switch id {
+			case remote:
+				<-release
+				return &proto.Node{Addr: "b:1", Addrs: []string{"b:2"}}, nil
+			case broken:
+				return nil, errDial
+			}
+			return nil, ErrNodeNotFound
+		})
+
+		// concurrent resolvers share one lookup
+		var wg sync.WaitGroup
+		addrs := make([]string, 8)
+		errs := make([]error, 8)
+		for i := range addrs {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				addrs[i], errs[i] = ResolveNodeAddr(context.Background(), remote)
+			}(i)
+		}
+		time.Sleep(50 * time.Millisecond)
+		close(release)
+		wg.Wait()
+		for i := range addrs {
+			So(errs[i], ShouldBeNil)
+			So(addrs[i], ShouldEqual, "b:1")
+		}
+		So(atomic.LoadInt32(&calls), ShouldEqual, 1)
+		cachedAddrs, err := GetNodeAddrsCache(&remote)
+		So(err, ShouldBeNil)
+		So(cachedAddrs, ShouldResemble, []string{"b:1", "b:2"})
+		_, err = ResolveNodeAddr(context.Background(), remote)
+		So(err, ShouldBeNil)
+		So(atomic.LoadInt32(&calls), ShouldEqual, 1)
+
+		// not found is distinct from transport failure
+		_, err = ResolveNodeAddr(context.Background(), missing)
+		So(err, ShouldEqual, ErrNodeNotFound)
+		_, err = ResolveNodeAddr(context.Background(), broken)
+		So(err, ShouldEqual, errDial)
+
+		// a stale entry survives transport failure
+		So(SetNodeAddrCacheTTL(&broken, "c:1", time.Hour), ShouldBeNil)
+		sweepNodeAddrCache(time.Now())
+		resolver.Lock()
+		resolver.meta[broken] = addrCacheMeta{ttl: time.Hour, expireAt: time.Now().Add(-time.Second)}
+		resolver.Unlock()
+		addr, err = ResolveNodeAddr(context.Background(), broken)
+		So(err, ShouldBeNil)
+		So(addr, ShouldEqual, "c:1")
+	})
+
+	Convey("waiting resolver returns on its own ctx", t, func() {
+		setResolveCache(make(NodeIDAddressMap))
+		release := make(chan struct{})
+		defer close(release)
+		SetNodeLookup(func(ctx context.Context, id proto.RawNodeID) (*proto.Node, error) {
+			<-release
+			return nil, ErrNodeNotFound
+		})
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		_, err := ResolveNodeAddr(ctx, slow)
+		So(errors.Is(err, context.DeadlineExceeded), ShouldBeTrue)
+	})
+}
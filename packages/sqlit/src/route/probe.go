@@ -0,0 +1,355 @@
+
+package route
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"sqlit/src/conf"
+	"sqlit/src/proto"
+	"sqlit/src/utils/log"
+)
+
+const (
+	// DefaultProbeInterval is the probe period if conf.RouteProbeInfo.Interval is not set.
+	DefaultProbeInterval = 30 * time.Second
+	// DefaultProbeTimeout bounds a probe if conf.RouteProbeInfo.Timeout is not set.
+	DefaultProbeTimeout = 3 * time.Second
+	// DefaultProbeFailureThreshold is the consecutive failures marking an address
+	// unreachable if conf.RouteProbeInfo.FailureThreshold is not set.
+	DefaultProbeFailureThreshold = 3
+	// DefaultProbeRecentUse is how long a node is probed after its last use if
+	// conf.RouteProbeInfo.RecentUse is not set.
+	DefaultProbeRecentUse = 10 * time.Minute
+
+	// probeWorkers bounds the concurrent probes of a round
+	probeWorkers = 8
+)
+
+// NodeAddrProber checks addr is reachable, it should return once ctx is done.
+type NodeAddrProber func(ctx context.Context, addr string) error
+
+// NodeHealthStatus is the liveness of the cached addresses of a node.
+type NodeHealthStatus struct {
+	// UsedAt is the last time the cached addresses are read, zero if never
+	UsedAt time.Time
+	// ProbedAt is the last time the addresses are probed, zero if never
+	ProbedAt time.Time
+	// Unreachable is the addresses failed the probes FailureThreshold times in a row
+	Unreachable []string
+	// Reachable is false if all the cached addresses are unreachable
+	Reachable bool
+	// LastError is the error of the last failed probe
+	LastError string
+	// LastSeen is the last successful rpc with the node by MarkSeen, zero if never
+	LastSeen time.Time
+}
+
+// nodeHealth is the probe state of a cached node.
+type nodeHealth struct {
+	usedAt   time.Time
+	probedAt time.Time
+	// failures counts the consecutive probe failures of each address
+	failures    map[string]int
+	unreachable map[string]bool
+	lastErr     string
+	// liveness is the node liveness of the last probe round
+	liveness Liveness
+}
+
+var (
+	nodeAddrProber     NodeAddrProber = dialNodeAddr
+	nodeAddrProberLock sync.RWMutex
+
+	// health holds the probe state of the cached nodes, it is locked after the
+	// resolver lock if both are held
+	health     = make(map[proto.RawNodeID]*nodeHealth)
+	healthLock sync.Mutex
+)
+
+// SetNodeAddrProber sets the prober used by ProbeNodeAddrCache, the default dials
+// the address by TCP.
+func SetNodeAddrProber(prober NodeAddrProber) {
+	nodeAddrProberLock.Lock()
+	defer nodeAddrProberLock.Unlock()
+	nodeAddrProber = prober
+}
+
+func getNodeAddrProber() NodeAddrProber {
+	nodeAddrProberLock.RLock()
+	defer nodeAddrProberLock.RUnlock()
+	return nodeAddrProber
+}
+
+// dialNodeAddr is the default NodeAddrProber, a host name is dialed by its cached
+// IPs like the node connections.
+func dialNodeAddr(ctx context.Context, addr string) (err error) {
+	var (
+		dialer  net.Dialer
+		c       net.Conn
+		ipAddrs []string
+	)
+	if ipAddrs, err = ResolveHostAddrs(ctx, addr); err != nil {
+		return
+	}
+	for _, ipAddr := range ipAddrs {
+		if c, err = dialer.DialContext(ctx, "tcp", ipAddr); err == nil {
+			return c.Close()
+		}
+	}
+	return
+}
+
+// NodeHealth returns the liveness of the cached addresses of node id, the addresses
+// of a node never probed are reachable.
+func NodeHealth(id *proto.RawNodeID) (status NodeHealthStatus, err error) {
+	initResolver()
+	if id == nil {
+		return status, ErrNilNodeID
+	}
+	resolver.RLock()
+	defer resolver.RUnlock()
+	addr, ok := resolver.cache[*id]
+	if !ok {
+		return status, ErrUnknownNodeID
+	}
+	addrs := resolver.addrs[*id]
+	if len(addrs) == 0 {
+		addrs = []string{addr}
+	}
+
+	status.LastSeen, _ = LastSeen(proto.NodeID(id.String()))
+	healthLock.Lock()
+	defer healthLock.Unlock()
+	status.Reachable = true
+	h, ok := health[*id]
+	if !ok {
+		return
+	}
+	status.UsedAt, status.ProbedAt, status.LastError = h.usedAt, h.probedAt, h.lastErr
+	for _, a := range addrs {
+		if h.unreachable[a] {
+			status.Unreachable = append(status.Unreachable, a)
+		}
+	}
+	status.Reachable = len(status.Unreachable) < len(addrs)
+	return
+}
+
+// markNodeUsedLocked records the cached addresses of id are read now and returns
+// addrs with the unreachable ones moved to the end, unreachable is set if all of
+// them are. The caller must hold the resolver lock.
+func markNodeUsedLocked(id proto.RawNodeID, addrs []string) (ordered []string, unreachable bool) {
+	healthLock.Lock()
+	defer healthLock.Unlock()
+	h, ok := health[id]
+	if !ok {
+		h = &nodeHealth{}
+		health[id] = h
+	}
+	h.usedAt = time.Now()
+	if len(h.unreachable) == 0 {
+		return addrs, false
+	}
+	ordered = make([]string, 0, len(addrs))
+	for _, a := range addrs {
+		if !h.unreachable[a] {
+			ordered = append(ordered, a)
+		}
+	}
+	unreachable = len(ordered) == 0
+	for _, a := range addrs {
+		if h.unreachable[a] {
+			ordered = append(ordered, a)
+		}
+	}
+	return
+}
+
+// resetNodeHealth drops the probe state of all nodes.
+func resetNodeHealth() {
+	healthLock.Lock()
+	defer healthLock.Unlock()
+	health = make(map[proto.RawNodeID]*nodeHealth)
+}
+
+// ProbeNodeAddrCache probes the cached addresses of the nodes used in the recent
+// info.RecentUse every info.Interval until ctx is done, an address failed
+// info.FailureThreshold probes in a row is tried last by ResolveNodeAddr. The zero
+// fields of info take the defaults.
+func ProbeNodeAddrCache(ctx context.Context, info conf.RouteProbeInfo) {
+	initResolver()
+	if info.Interval <= 0 {
+		info.Interval = DefaultProbeInterval
+	}
+	go func() {
+		ticker := time.NewTicker(info.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				if probed := probeNodeAddrCache(ctx, info, now); probed > 0 {
+					log.WithField("probed", probed).Debug("probe node addr cache")
+				}
+			}
+		}
+	}()
+}
+
+// probeTarget is a node address to probe.
+type probeTarget struct {
+	id   proto.RawNodeID
+	addr string
+	err  error
+}
+
+// probeNodeAddrCache probes the addresses of the nodes used since now-info.RecentUse
+// once and returns the probed address count.
+func probeNodeAddrCache(ctx context.Context, info conf.RouteProbeInfo, now time.Time) (probed int) {
+	if info.Timeout <= 0 {
+		info.Timeout = DefaultProbeTimeout
+	}
+	if info.FailureThreshold <= 0 {
+		info.FailureThreshold = DefaultProbeFailureThreshold
+	}
+	if info.RecentUse <= 0 {
+		info.RecentUse = DefaultProbeRecentUse
+	}
+
+	// pick the addresses of the recently used nodes, and forget the uncached nodes
+	var targets []*probeTarget
+	resolver.RLock()
+	healthLock.Lock()
+	for id, h := range health {
+		addr, ok := resolver.cache[id]
+		if !ok {
+			delete(health, id)
+			continue
+		}
+		if now.Sub(h.usedAt) > info.RecentUse {
+			continue
+		}
+		addrs := resolver.addrs[id]
+		if len(addrs) == 0 {
+			addrs = []string{addr}
+		}
+		for _, a := range addrs {
+			targets = append(targets, &probeTarget{id: id, addr: a})
+		}
+	}
+	healthLock.Unlock()
+	resolver.RUnlock()
+
+	var (
+		prober = getNodeAddrProber()
+		wg     sync.WaitGroup
+		next   = make(chan *probeTarget)
+	)
+	for w := 0; w < probeWorkers && w < len(targets); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for t := range next {
+				probeCtx, cancel := context.WithTimeout(ctx, info.Timeout)
+				t.err = prober(probeCtx, t.addr)
+				cancel()
+			}
+		}()
+	}
+	for _, t := range targets {
+		next <- t
+	}
+	close(next)
+	wg.Wait()
+	if ctx.Err() != nil {
+		// the results of canceled probes say nothing about the nodes
+		return
+	}
+
+	healthLock.Lock()
+	defer healthLock.Unlock()
+	current := make(map[proto.RawNodeID]map[string]bool)
+	for _, t := range targets {
+		h, ok := health[t.id]
+		if !ok {
+			continue
+		}
+		recordProbeLocked(h, t, info.FailureThreshold, now)
+		if current[t.id] == nil {
+			current[t.id] = make(map[string]bool)
+		}
+		current[t.id][t.addr] = true
+	}
+	// forget the addresses replaced since the last round
+	for id, addrs := range current {
+		h := health[id]
+		for a := range h.failures {
+			if !addrs[a] {
+				delete(h.failures, a)
+				delete(h.unreachable, a)
+			}
+		}
+		recordLivenessLocked(id, h, info, now)
+	}
+	return len(targets)
+}
+
+// recordLivenessLocked updates the liveness of node id in h by its last seen time
+// and logs the changes, the caller must hold healthLock.
+func recordLivenessLocked(id proto.RawNodeID, h *nodeHealth, info conf.RouteProbeInfo, now time.Time) {
+	seen, ok := LastSeen(proto.NodeID(id.String()))
+	if !ok {
+		return
+	}
+	liveness := livenessAt(seen, info, now)
+	if liveness == h.liveness {
+		return
+	}
+	logger := log.WithFields(log.Fields{
+		"node":      id.String(),
+		"last_seen": seen,
+		"liveness":  liveness,
+	})
+	if liveness == LivenessAlive {
+		if h.liveness != LivenessUnknown {
+			logger.Info("node is alive again")
+		}
+	} else {
+		logger.Warning("node is not seen recently")
+	}
+	h.liveness = liveness
+}
+
+// recordProbeLocked applies the probe result of t to h, the caller must hold healthLock.
+func recordProbeLocked(h *nodeHealth, t *probeTarget, threshold int, now time.Time) {
+	if h.failures == nil {
+		h.failures = make(map[string]int)
+		h.unreachable = make(map[string]bool)
+	}
+	h.probedAt = now
+	if t.err == nil {
+		if h.unreachable[t.addr] {
+			log.WithFields(log.Fields{
+				"node": t.id.String(),
+				"addr": t.addr,
+			}).Info("node addr is reachable again")
+		}
+		delete(h.failures, t.addr)
+		delete(h.unreachable, t.addr)
+		return
+	}
+	h.lastErr = t.err.Error()
+	h.failures[t.addr]++
+	if h.failures[t.addr] >= threshold && !h.unreachable[t.addr] {
+		h.unreachable[t.addr] = true
+		log.WithFields(log.Fields{
+			"node":     t.id.String(),
+			"addr":     t.addr,
+			"failures": h.failures[t.addr],
+		}).WithError(t.err).Warning("node addr is unreachable")
+	}
+}
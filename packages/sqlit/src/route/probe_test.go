@@ -0,0 +1,147 @@
+
+package route
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"sqlit/src/conf"
+	"sqlit/src/crypto/hash"
+	"sqlit/src/proto"
+)
+
+func TestProbeNodeAddrCache(t *testing.T) {
+	defer func(saved *conf.Config) { conf.GConf = saved }(conf.GConf)
+	conf.GConf = &conf.Config{}
+	defer SetNodeAddrProber(getNodeAddrProber())
+	defer SetNodeLookup(getNodeLookup())
+
+	var (
+		multi   = proto.RawNodeID{Hash: hash.Hash([32]byte{0xee, 0x01})}
+		single  = proto.RawNodeID{Hash: hash.Hash([32]byte{0xee, 0x02})}
+		idle    = proto.RawNodeID{Hash: hash.Hash([32]byte{0xee, 0x03})}
+		errDial = errors.New("dial failed")
+		info    = conf.RouteProbeInfo{FailureThreshold: 2}
+	)
+
+	Convey("the recently used addresses are probed and the unreachable tried last", t, func() {
+		setResolveCache(make(NodeIDAddressMap))
+		So(SetNodeAddrCache(&multi, "10.0.0.1:1", "10.0.0.2:1"), ShouldBeNil)
+		So(SetNodeAddrCache(&single, "10.0.0.3:1"), ShouldBeNil)
+		So(SetNodeAddrCache(&idle, "10.0.0.4:1"), ShouldBeNil)
+
+		var (
+			lock   sync.Mutex
+			probes = make(map[string]int)
+			down   = map[string]bool{"10.0.0.1:1": true, "10.0.0.3:1": true}
+		)
+		SetNodeAddrProber(func(ctx context.Context, addr string) error {
+			lock.Lock()
+			defer lock.Unlock()
+			probes[addr]++
+			if down[addr] {
+				return errDial
+			}
+			return nil
+		})
+
+		// nothing is used yet
+		So(probeNodeAddrCache(context.Background(), info, time.Now()), ShouldEqual, 0)
+		_, err := GetNodeAddrCacheEntry(&multi)
+		So(err, ShouldBeNil)
+		_, err = GetNodeAddrCache(&single)
+		So(err, ShouldBeNil)
+
+		So(probeNodeAddrCache(context.Background(), info, time.Now()), ShouldEqual, 3)
+		status, err := NodeHealth(&multi)
+		So(err, ShouldBeNil)
+		So(status.Reachable, ShouldBeTrue)
+		So(status.Unreachable, ShouldBeEmpty)
+		So(status.LastError, ShouldEqual, errDial.Error())
+
+		So(probeNodeAddrCache(context.Background(), info, time.Now()), ShouldEqual, 3)
+		So(probes, ShouldResemble, map[string]int{"10.0.0.1:1": 2, "10.0.0.2:1": 2, "10.0.0.3:1": 2})
+		status, err = NodeHealth(&multi)
+		So(err, ShouldBeNil)
+		So(status.Reachable, ShouldBeTrue)
+		So(status.Unreachable, ShouldResemble, []string{"10.0.0.1:1"})
+		So(status.ProbedAt.IsZero(), ShouldBeFalse)
+		entry, err := GetNodeAddrCacheEntry(&multi)
+		So(err, ShouldBeNil)
+		So(entry.Addrs, ShouldResemble, []string{"10.0.0.2:1", "10.0.0.1:1"})
+		So(entry.Unreachable, ShouldBeFalse)
+		addr, err := ResolveNodeAddr(context.Background(), multi)
+		So(err, ShouldBeNil)
+		So(addr, ShouldEqual, "10.0.0.2:1")
+
+		// an unreachable node is looked up again, and kept if the lookup fails
+		status, err = NodeHealth(&single)
+		So(err, ShouldBeNil)
+		So(status.Reachable, ShouldBeFalse)
+		SetNodeLookup(func(ctx context.Context, id proto.RawNodeID) (*proto.Node, error) {
+			return nil, errDial
+		})
+		addr, err = ResolveNodeAddr(context.Background(), single)
+		So(err, ShouldBeNil)
+		So(addr, ShouldEqual, "10.0.0.3:1")
+		SetNodeLookup(func(ctx context.Context, id proto.RawNodeID) (*proto.Node, error) {
+			return &proto.Node{Addr: "10.0.0.5:1"}, nil
+		})
+		addr, err = ResolveNodeAddr(context.Background(), single)
+		So(err, ShouldBeNil)
+		So(addr, ShouldEqual, "10.0.0.5:1")
+
+		// the replaced address is forgotten and a recovered one is reachable again
+		delete(down, "10.0.0.1:1")
+		So(probeNodeAddrCache(context.Background(), info, time.Now()), ShouldEqual, 3)
+		status, err = NodeHealth(&multi)
+		So(err, ShouldBeNil)
+		So(status.Unreachable, ShouldBeEmpty)
+		status, err = NodeHealth(&single)
+		So(err, ShouldBeNil)
+		So(status.Reachable, ShouldBeTrue)
+
+		// the idle node is never probed, nor the nodes unused for long
+		So(probes["10.0.0.4:1"], ShouldBeZeroValue)
+		status, err = NodeHealth(&idle)
+		So(err, ShouldBeNil)
+		So(status.UsedAt.IsZero(), ShouldBeTrue)
+		So(status.Reachable, ShouldBeTrue)
+		So(probeNodeAddrCache(context.Background(), info, time.Now().Add(time.Hour)), ShouldEqual, 0)
+
+		// the uncached nodes are forgotten
+		So(DelNodeAddrCache(&multi), ShouldBeNil)
+		_, err = NodeHealth(&multi)
+		So(err, ShouldEqual, ErrUnknownNodeID)
+		So(probeNodeAddrCache(context.Background(), info, time.Now()), ShouldEqual, 1)
+	})
+	Convey("the probes run until ctx is done", t, func() {
+		setResolveCache(make(NodeIDAddressMap))
+		So(SetNodeAddrCache(&single, "10.0.0.3:1"), ShouldBeNil)
+		_, err := GetNodeAddrCache(&single)
+		So(err, ShouldBeNil)
+
+		probed := make(chan string, 1)
+		SetNodeAddrProber(func(ctx context.Context, addr string) error {
+			select {
+			case probed <- addr:
+			default:
+			}
+			return nil
+		})
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		ProbeNodeAddrCache(ctx, conf.RouteProbeInfo{Interval: 10 * time.Millisecond})
+		select {
+		case addr := <-probed:
+			So(addr, ShouldEqual, "10.0.0.3:1")
+		case <-time.After(5 * time.Second):
+			So("no probe", ShouldBeEmpty)
+		}
+	})
+}
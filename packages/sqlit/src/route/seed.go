@@ -0,0 +1,122 @@
+
+package route
+
+import (
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/pkg/errors"
+
+	"sqlit/src/proto"
+	"sqlit/src/utils/log"
+)
+
+// SeedNodeProber learns the nodes behind a DNS seed, host is the seed hostname and
+// addrs are the candidate addresses resolved from its A/AAAA records.
+type SeedNodeProber func(host string, addrs []string) (nodes []proto.Node, err error)
+
+var (
+	// lookupIP is replaced in unit test
+	lookupIP = net.LookupIP
+
+	seedNodeProber     SeedNodeProber = dnsSeedNodeProber
+	seedNodeProberLock sync.RWMutex
+)
+
+// SetSeedNodeProber sets the prober used to learn node ids from seed addresses, the
+// default reads the node info published in the IPv6 seed records of the host.
+func SetSeedNodeProber(prober SeedNodeProber) {
+	seedNodeProberLock.Lock()
+	defer seedNodeProberLock.Unlock()
+	seedNodeProber = prober
+}
+
+func getSeedNodeProber() SeedNodeProber {
+	seedNodeProberLock.RLock()
+	defer seedNodeProberLock.RUnlock()
+	return seedNodeProber
+}
+
+// dnsSeedNodeProber learns the node by the IPv6 seed records of host, see
+// IPv6SeedClient, the resolved addrs are kept as alternates of the published one.
+func dnsSeedNodeProber(host string, addrs []string) (nodes []proto.Node, err error) {
+	isc := IPv6SeedClient{}
+	var found IDNodeMap
+	if found, err = isc.GetBPFromDNSSeed(host); err != nil {
+		return
+	}
+	for _, n := range found {
+		n.Addrs = append(n.Addrs, addrs...)
+		nodes = append(nodes, n)
+	}
+	return
+}
+
+// resolveSeed resolves the seed of "host:port" form to the candidate addresses of
+// its A/AAAA records.
+func resolveSeed(seed string) (host string, addrs []string, err error) {
+	var port string
+	if host, port, err = net.SplitHostPort(strings.TrimSpace(seed)); err != nil {
+		err = errors.Wrapf(err, "invalid seed %q", seed)
+		return
+	}
+	var ips []net.IP
+	if ips, err = lookupIP(host); err != nil {
+		err = errors.Wrapf(err, "lookup seed %s failed", host)
+		return
+	}
+	for _, ip := range ips {
+		addrs = append(addrs, net.JoinHostPort(ip.String(), port))
+	}
+	addrs = proto.MergeAddrs("", addrs...)
+	if len(addrs) == 0 {
+		err = errors.Errorf("no address for seed %s", host)
+	}
+	return
+}
+
+// bootstrapFromSeeds learns the nodes from seeds and caches their addresses. The
+// cached entries, e.g. the static known nodes, are never overridden, and a seed
+// failed to resolve or probe is logged and skipped.
+func bootstrapFromSeeds(seeds []string) (learned []proto.Node) {
+	prober := getSeedNodeProber()
+	if prober == nil {
+		return
+	}
+	for _, seed := range seeds {
+		host, addrs, err := resolveSeed(seed)
+		if err != nil {
+			log.WithField("seed", seed).WithError(err).Warning("resolve DNS seed failed")
+			continue
+		}
+		nodes, err := prober(host, addrs)
+		if err != nil {
+			log.WithField("seed", seed).WithError(err).Warning("learn nodes from DNS seed failed")
+			continue
+		}
+		for _, n := range nodes {
+			if err = n.ID.Validate(); err != nil {
+				log.WithField("seed", seed).WithError(err).Warning("skip invalid seed node")
+				continue
+			}
+			candidates := n.AddrCandidates()
+			if len(candidates) == 0 {
+				candidates = addrs
+			}
+			rawID := n.ID.ToRawNodeID()
+			resolver.RLock()
+			_, cached := resolver.cache[*rawID]
+			resolver.RUnlock()
+			if cached {
+				continue
+			}
+			_ = setNodeAddrCache(rawID, candidates[0], candidates[1:]...)
+			learned = append(learned, n)
+		}
+	}
+	if len(seeds) > 0 {
+		log.WithField("nodes", len(learned)).Info("bootstrap from DNS seeds")
+	}
+	return
+}
@@ -0,0 +1,87 @@
+
+package route
+
+import (
+	"errors"
+	"net"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"sqlit/src/conf"
+	"sqlit/src/proto"
+)
+
+func TestBootstrapFromSeeds(t *testing.T) {
+	_, testFile, _, _ := runtime.Caller(0)
+	confFile := filepath.Join(filepath.Dir(testFile), "../../test/node_c/config.yaml")
+	conf.GConf, _ = conf.LoadConfig(confFile)
+
+	var (
+		static  = proto.NodeID("00000bef611d346c0cbe1beaa76e7f0ed705a194fdf9ac3a248ec70e9c198bf9")
+		learned = proto.NodeID("00000381d46fd6cf7742d7fb94e2422033af989c0e348b5781b3219599a3af35")
+	)
+	defer func(lookup func(string) ([]net.IP, error)) { lookupIP = lookup }(lookupIP)
+	defer SetSeedNodeProber(getSeedNodeProber())
+
+	lookupIP = func(host string) ([]net.IP, error) {
+		switch host {
+		case "seed.example":
+			return []net.IP{net.ParseIP("10.0.0.1"), net.ParseIP("fd00::1"), net.ParseIP("10.0.0.1")}, nil
+		case "bad.example":
+			return []net.IP{net.ParseIP("10.0.0.9")}, nil
+		}
+		return nil, errors.New("no such host")
+	}
+	SetSeedNodeProber(func(host string, addrs []string) ([]proto.Node, error) {
+		if host == "bad.example" {
+			return nil, errors.New("probe failed")
+		}
+		return []proto.Node{
+			{ID: static, Addr: "seed:1"},
+			{ID: learned},
+			{ID: "not-a-node-id", Addr: "seed:3"},
+		}, nil
+	})
+
+	Convey("seed candidates resolve from A/AAAA records", t, func() {
+		host, addrs, err := resolveSeed("seed.example:2120")
+		So(err, ShouldBeNil)
+		So(host, ShouldEqual, "seed.example")
+		So(addrs, ShouldResemble, []string{"10.0.0.1:2120", "[fd00::1]:2120"})
+
+		_, _, err = resolveSeed("seed.example")
+		So(err, ShouldNotBeNil)
+		_, _, err = resolveSeed("missing.example:2120")
+		So(err, ShouldNotBeNil)
+	})
+
+	Convey("seeds fill the nodes missing from the static list", t, func() {
+		setResolveCache(make(NodeIDAddressMap))
+		So(SetNodeAddrCache(static.ToRawNodeID(), "static:1"), ShouldBeNil)
+
+		nodes := bootstrapFromSeeds([]string{"missing.example:2120", "bad.example:2120", "seed", "seed.example:2120"})
+		So(nodes, ShouldHaveLength, 1)
+		So(nodes[0].ID, ShouldEqual, learned)
+
+		addr, err := GetNodeAddrCache(static.ToRawNodeID())
+		So(err, ShouldBeNil)
+		So(addr, ShouldEqual, "static:1")
+		addrs, err := GetNodeAddrsCache(learned.ToRawNodeID())
+		So(err, ShouldBeNil)
+		So(addrs, ShouldResemble, []string{"10.0.0.1:2120", "[fd00::1]:2120"})
+
+		// all seeds failed degrades to the static list
+		setResolveCache(make(NodeIDAddressMap))
+		So(SetNodeAddrCache(static.ToRawNodeID(), "static:1"), ShouldBeNil)
+		So(bootstrapFromSeeds([]string{"missing.example:2120"}), ShouldBeEmpty)
+		addr, err = GetNodeAddrCache(static.ToRawNodeID())
+		So(err, ShouldBeNil)
+		So(addr, ShouldEqual, "static:1")
+
+		SetSeedNodeProber(nil)
+		So(bootstrapFromSeeds([]string{"seed.example:2120"}), ShouldBeEmpty)
+	})
+}
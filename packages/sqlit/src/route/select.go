@@ -0,0 +1,78 @@
+
+package route
+
+import (
+	"errors"
+	"sync/atomic"
+
+	"sqlit/src/conf"
+	"sqlit/src/crypto/kms"
+	"sqlit/src/proto"
+)
+
+var (
+	// ErrNoNodeCandidate indicates SelectNode is called without candidates.
+	ErrNoNodeCandidate = errors.New("no node candidate")
+	// ErrNoReachableNode indicates all the candidates of SelectNode are unreachable.
+	ErrNoReachableNode = errors.New("no reachable node candidate")
+
+	// selectRound rotates the picks of SelectNode among the nodes of equal weight
+	selectRound uint64
+)
+
+// SelectNode picks a node of candidates by proto.Node.Weight, the highest weight
+// wins, then the lowest latency estimate of RecordLatency, and the nodes left are
+// picked round-robin in candidates order by successive calls. The latencies are
+// ignored at the chance set by SetLatencyExploreRate to sample the slower nodes
+// too. The weight is of the known node in conf.GConf, else of the
+// node in kms, else zero. A node banned by Ban or whose cached addresses are all
+// unreachable by ProbeNodeAddrCache is skipped, ErrNoReachableNode is returned if
+// all are.
+func SelectNode(candidates []proto.NodeID) (selected proto.NodeID, err error) {
+	if len(candidates) == 0 {
+		return "", ErrNoNodeCandidate
+	}
+	var (
+		weights = knownNodeWeights()
+		best    []proto.NodeID
+		top     int
+	)
+	for _, id := range candidates {
+		if IsBanned(id) {
+			continue
+		}
+		if status, healthErr := NodeHealth(id.ToRawNodeID()); healthErr == nil && !status.Reachable {
+			continue
+		}
+		w, ok := weights[id]
+		if !ok {
+			if node, kmsErr := kms.GetNodeInfo(id); kmsErr == nil {
+				w = node.Weight
+			}
+		}
+		switch {
+		case len(best) == 0 || w > top:
+			best, top = []proto.NodeID{id}, w
+		case w == top:
+			best = append(best, id)
+		}
+	}
+	if len(best) == 0 {
+		return "", ErrNoReachableNode
+	}
+	best = fastestNodes(best)
+	round := atomic.AddUint64(&selectRound, 1) - 1
+	return best[round%uint64(len(best))], nil
+}
+
+// knownNodeWeights returns the weights of the known nodes in conf.GConf.
+func knownNodeWeights() (weights map[proto.NodeID]int) {
+	weights = make(map[proto.NodeID]int)
+	if conf.GConf == nil {
+		return
+	}
+	for _, n := range conf.GConf.KnownNodes {
+		weights[n.ID] = n.Weight
+	}
+	return
+}
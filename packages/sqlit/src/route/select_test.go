@@ -0,0 +1,75 @@
+
+package route
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"sqlit/src/conf"
+	"sqlit/src/proto"
+)
+
+func TestSelectNode(t *testing.T) {
+	defer func(saved *conf.Config) { conf.GConf = saved }(conf.GConf)
+	defer SetNodeAddrProber(getNodeAddrProber())
+
+	var (
+		near1   = proto.NodeID("00000000000000000000000000000000000000000000000000000000000000d1")
+		near2   = proto.NodeID("00000000000000000000000000000000000000000000000000000000000000d2")
+		far     = proto.NodeID("00000000000000000000000000000000000000000000000000000000000000d3")
+		unknown = proto.NodeID("00000000000000000000000000000000000000000000000000000000000000d4")
+	)
+	conf.GConf = &conf.Config{KnownNodes: []proto.Node{
+		{ID: near1, Addr: "10.0.1.1:1", Weight: 10},
+		{ID: near2, Addr: "10.0.1.2:1", Weight: 10},
+		{ID: far, Addr: "10.0.2.1:1", Weight: 1},
+	}}
+
+	Convey("the highest weight is picked round-robin", t, func() {
+		setResolveCache(make(NodeIDAddressMap))
+		candidates := []proto.NodeID{far, near1, unknown, near2}
+		picked := make(map[proto.NodeID]int)
+		first, err := SelectNode(candidates)
+		So(err, ShouldBeNil)
+		picked[first]++
+		for i := 0; i < 3; i++ {
+			id, err := SelectNode(candidates)
+			So(err, ShouldBeNil)
+			picked[id]++
+		}
+		So(picked, ShouldResemble, map[proto.NodeID]int{near1: 2, near2: 2})
+
+		// the unknown nodes weigh zero
+		id, err := SelectNode([]proto.NodeID{unknown, far})
+		So(err, ShouldBeNil)
+		So(id, ShouldEqual, far)
+
+		_, err = SelectNode(nil)
+		So(err, ShouldEqual, ErrNoNodeCandidate)
+	})
+	Convey("the unreachable nodes are skipped", t, func() {
+		setResolveCache(make(NodeIDAddressMap))
+		for _, n := range conf.GConf.KnownNodes {
+			So(SetNodeAddrCache(n.ID.ToRawNodeID(), n.Addr), ShouldBeNil)
+			_, err := GetNodeAddrCache(n.ID.ToRawNodeID())
+			So(err, ShouldBeNil)
+		}
+		SetNodeAddrProber(func(ctx context.Context, addr string) error {
+			if addr == "10.0.2.1:1" {
+				return nil
+			}
+			return errors.New("dial failed")
+		})
+		So(probeNodeAddrCache(context.Background(), conf.RouteProbeInfo{FailureThreshold: 1}, time.Now()), ShouldEqual, 3)
+
+		id, err := SelectNode([]proto.NodeID{near1, near2, far})
+		So(err, ShouldBeNil)
+		So(id, ShouldEqual, far)
+		_, err = SelectNode([]proto.NodeID{near1, near2})
+		So(err, ShouldEqual, ErrNoReachableNode)
+	})
+}
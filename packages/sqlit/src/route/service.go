@@ -97,10 +97,13 @@ func (DHT *DHTService) Ping(req *proto.PingReq, resp *proto.PingResp) (err error
 	}
 
 	// Checking if ID Nonce Pubkey matched (skip in test mode)
-	if !kms.Unittest && !kms.IsIDPubNonceValid(req.Node.ID.ToRawNodeID(), &req.Node.Nonce, req.Node.PublicKey) {
-		err = fmt.Errorf("node: %s nonce public key not match", req.Node.ID)
-		log.Error(err)
-		return
+	if !kms.Unittest {
+		nodeKey, keyErr := req.Node.TypedPublicKey()
+		if keyErr != nil || !kms.IsIDTypedPubNonceValid(req.Node.ID.ToRawNodeID(), &req.Node.Nonce, nodeKey) {
+			err = fmt.Errorf("node: %s nonce public key not match", req.Node.ID)
+			log.Error(err)
+			return
+		}
 	}
 
 	// Checking MinNodeIDDifficulty (skip in test mode)
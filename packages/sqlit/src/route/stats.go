@@ -0,0 +1,60 @@
+
+package route
+
+import (
+	"sync/atomic"
+)
+
+// Counter is a read only monotonic counter, Value can be wrapped by
+// prometheus.NewCounterFunc to export it.
+type Counter interface {
+	Value() uint64
+}
+
+// counter implements Counter.
+type counter struct {
+	v uint64
+}
+
+func (c *counter) inc() {
+	atomic.AddUint64(&c.v, 1)
+}
+
+func (c *counter) add(n int) {
+	atomic.AddUint64(&c.v, uint64(n))
+}
+
+// Value implements Counter.Value.
+func (c *counter) Value() uint64 {
+	return atomic.LoadUint64(&c.v)
+}
+
+var (
+	cacheHits      = &counter{}
+	cacheMisses    = &counter{}
+	cacheEvictions = &counter{}
+
+	// CacheHits counts the node address cache reads of fresh entries
+	CacheHits Counter = cacheHits
+	// CacheMisses counts the node address cache reads of unknown or stale entries
+	CacheMisses Counter = cacheMisses
+	// Evictions counts the node address cache entries swept by expiry, deleted or
+	// replaced by other addresses
+	Evictions Counter = cacheEvictions
+)
+
+// CacheStatsSnapshot is the values of the node address cache counters at a time.
+type CacheStatsSnapshot struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+}
+
+// CacheStats returns the current values of the node address cache counters.
+func CacheStats() CacheStatsSnapshot {
+	return CacheStatsSnapshot{
+		Hits:      CacheHits.Value(),
+		Misses:    CacheMisses.Value(),
+		Evictions: Evictions.Value(),
+	}
+}
@@ -0,0 +1,61 @@
+
+package route
+
+import (
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+
+	"sqlit/src/conf"
+	"sqlit/src/crypto/hash"
+	"sqlit/src/proto"
+)
+
+func TestCacheStats(t *testing.T) {
+	_, testFile, _, _ := runtime.Caller(0)
+	confFile := filepath.Join(filepath.Dir(testFile), "../../test/node_c/config.yaml")
+	conf.GConf, _ = conf.LoadConfig(confFile)
+
+	Convey("cache counters track hits, misses and evictions", t, func() {
+		setResolveCache(make(NodeIDAddressMap))
+		var (
+			nodeA = &proto.RawNodeID{Hash: hash.Hash([32]byte{0xee, 0x01})}
+			nodeB = &proto.RawNodeID{Hash: hash.Hash([32]byte{0xee, 0x02})}
+		)
+		before := CacheStats()
+		delta := func() CacheStatsSnapshot {
+			now := CacheStats()
+			return CacheStatsSnapshot{
+				Hits:      now.Hits - before.Hits,
+				Misses:    now.Misses - before.Misses,
+				Evictions: now.Evictions - before.Evictions,
+			}
+		}
+
+		_, err := GetNodeAddrCache(nodeA)
+		So(err, ShouldEqual, ErrUnknownNodeID)
+		So(delta(), ShouldResemble, CacheStatsSnapshot{Misses: 1})
+
+		So(SetNodeAddrCache(nodeA, "a:1"), ShouldBeNil)
+		_, err = GetNodeAddrsCache(nodeA)
+		So(err, ShouldBeNil)
+		So(delta(), ShouldResemble, CacheStatsSnapshot{Hits: 1, Misses: 1})
+
+		// setting the same addrs again is not an eviction, replacing is
+		So(SetNodeAddrCache(nodeA, "a:1"), ShouldBeNil)
+		So(delta().Evictions, ShouldEqual, 0)
+		So(SetNodeAddrCache(nodeA, "a:1", "a:2"), ShouldBeNil)
+		So(delta().Evictions, ShouldEqual, 1)
+
+		// stale read is a miss, sweeping is an eviction
+		So(SetNodeAddrCacheTTL(nodeB, "b:1", time.Millisecond), ShouldBeNil)
+		time.Sleep(2 * time.Millisecond)
+		_, err = GetNodeAddrCache(nodeB)
+		So(err, ShouldEqual, ErrStaleNodeAddr)
+		So(sweepNodeAddrCache(time.Now()), ShouldEqual, 1)
+		So(delta(), ShouldResemble, CacheStatsSnapshot{Hits: 1, Misses: 2, Evictions: 2})
+	})
+}
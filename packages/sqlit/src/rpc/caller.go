@@ -93,6 +93,9 @@ func (c *Caller) CallNodeWithContext(
 			if setter, ok := client.(LastErrSetter); ok {
 				setter.SetLastErr(err)
 			}
+		} else {
+			route.RecordLatency(node, time.Since(startTime))
+			route.MarkSeen(node)
 		}
 	}
 
@@ -2,12 +2,16 @@
 package mux
 
 import (
+	"context"
 	"math/rand"
+	nrpc "net/rpc"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/pkg/errors"
 
+	"sqlit/src/consistent"
 	"sqlit/src/crypto/kms"
 	"sqlit/src/naconn"
 	"sqlit/src/proto"
@@ -58,6 +62,22 @@ func (r *Resolver) Resolve(id *proto.RawNodeID) (string, error) {
 	return GetNodeAddr(id)
 }
 
+// ResolveAll implements naconn.MultiAddrResolver, it returns the address candidates
+// of the target node in priority order.
+func (r *Resolver) ResolveAll(id *proto.RawNodeID) ([]string, error) {
+	if r.direct {
+		node, err := GetNodeInfo(id)
+		if err != nil {
+			return nil, err
+		}
+		if node.Role == proto.Miner {
+			return []string{node.DirectAddr}, nil
+		}
+		return node.AddrCandidates(), nil
+	}
+	return GetNodeAddrs(id)
+}
+
 // ResolveEx implements the node ID resolver extended method using the BP network
 // with mux-RPC protocol.
 func (r *Resolver) ResolveEx(id *proto.RawNodeID) (*proto.Node, error) {
@@ -66,22 +86,38 @@ func (r *Resolver) ResolveEx(id *proto.RawNodeID) (*proto.Node, error) {
 
 func init() {
 	naconn.RegisterResolver(&Resolver{})
+	route.SetNodeLookup(findNodeInBP)
 }
 
 // GetNodeAddr tries best to get node addr.
 func GetNodeAddr(id *proto.RawNodeID) (addr string, err error) {
-	addr, err = route.GetNodeAddrCache(id)
-	if err != nil {
-		//log.WithField("target", id.String()).WithError(err).Debug("get node addr from cache failed")
-		if err == route.ErrUnknownNodeID {
-			var node *proto.Node
-			node, err = FindNodeInBP(id)
-			if err != nil {
-				return
+	var addrs []string
+	if addrs, err = GetNodeAddrs(id); err == nil && len(addrs) > 0 {
+		addr = addrs[0]
+	}
+	return
+}
+
+// GetNodeAddrs tries best to get node address candidates in priority order. A
+// stale or unreachable cache entry is resolved again, the cached addresses are
+// still used if the block producers are unavailable.
+func GetNodeAddrs(id *proto.RawNodeID) (addrs []string, err error) {
+	var entry route.NodeAddrCacheEntry
+	entry, err = route.GetNodeAddrCacheEntry(id)
+	addrs = entry.Addrs
+	if err == route.ErrUnknownNodeID || err == route.ErrStaleNodeAddr || (err == nil && entry.Unreachable) {
+		var node *proto.Node
+		if node, err = FindNodeInBP(id); err != nil {
+			if len(entry.Addrs) > 0 {
+				log.WithField("target", id.String()).WithError(err).Warning(
+					"refresh stale node addr failed, use the stale one")
+				return entry.Addrs, nil
 			}
-			_ = route.SetNodeAddrCache(id, node.Addr)
-			addr = node.Addr
+			return
 		}
+		// keep the TTL of the refreshed entry
+		_ = route.SetNodeAddrCacheTTL(id, node.Addr, entry.TTL, node.Addrs...)
+		addrs = node.AddrCandidates()
 	}
 	return
 }
@@ -96,7 +132,7 @@ func GetNodeInfo(id *proto.RawNodeID) (nodeInfo *proto.Node, err error) {
 			if err != nil {
 				return
 			}
-			errSet := route.SetNodeAddrCache(id, nodeInfo.Addr)
+			errSet := route.SetNodeAddrCache(id, nodeInfo.Addr, nodeInfo.Addrs...)
 			if errSet != nil {
 				log.WithError(errSet).Warning("set node addr cache failed")
 			}
@@ -111,6 +147,12 @@ func GetNodeInfo(id *proto.RawNodeID) (nodeInfo *proto.Node, err error) {
 
 // FindNodeInBP find node in block producer dht service.
 func FindNodeInBP(id *proto.RawNodeID) (node *proto.Node, err error) {
+	return findNodeInBP(context.Background(), *id)
+}
+
+// findNodeInBP finds node in the dht service of block producers, it returns
+// route.ErrNodeNotFound if all the block producers answered the node is unknown.
+func findNodeInBP(ctx context.Context, id proto.RawNodeID) (node *proto.Node, err error) {
 	bps := route.GetBPs()
 	if len(bps) == 0 {
 		err = errors.New("no available BP")
@@ -120,29 +162,46 @@ func FindNodeInBP(id *proto.RawNodeID) (node *proto.Node, err error) {
 	req := &proto.FindNodeReq{
 		ID: proto.NodeID(id.String()),
 	}
-	resp := new(proto.FindNodeResp)
 	bpCount := len(bps)
 	offset := rand.Intn(bpCount)
 	method := route.DHTFindNode.String()
+	notFound := true
 
 	for i := 0; i != bpCount; i++ {
 		bp := bps[(offset+i)%bpCount]
-		err = client.CallNode(bp, method, req, resp)
+		resp := new(proto.FindNodeResp)
+		err = client.CallNodeWithContext(ctx, bp, method, req, resp)
 		if err == nil {
 			node = resp.Node
 			return
 		}
+		if !isNodeNotFound(err) {
+			notFound = false
+		}
 
 		log.WithFields(log.Fields{
 			"method": method,
 			"bp":     bp,
 		}).WithError(err).Warning("call dht rpc failed")
+		if ctx.Err() != nil {
+			break
+		}
 	}
 
+	if notFound && ctx.Err() == nil {
+		return nil, route.ErrNodeNotFound
+	}
 	err = errors.Wrapf(err, "could not find node in all block producers")
 	return
 }
 
+// isNodeNotFound returns if err is the dht answer of unknown node, other errors are
+// transport failures.
+func isNodeNotFound(err error) bool {
+	serverErr, ok := errors.Cause(err).(nrpc.ServerError)
+	return ok && strings.Contains(string(serverErr), consistent.ErrKeyNotFound.Error())
+}
+
 // PingBP Send DHT.Ping Request with Anonymous ETLS session.
 func PingBP(node *proto.Node, BPNodeID proto.NodeID) (err error) {
 	client := NewCaller()
@@ -251,6 +310,8 @@ func RegisterNodeToBP(timeout time.Duration) (err error) {
 		return
 	}
 
+	// advertise the features of this version, whatever the keystore knew before
+	localNodeInfo.Capabilities |= proto.LocalCapabilities
 	log.WithField("node", localNodeInfo).Debug("construct local node info")
 
 	pingWaitCh := make(chan proto.NodeID)
@@ -284,8 +284,7 @@ func (r *runtime) isMyTurn() (ret bool) {
 func (r *runtime) getPeers() *proto.Peers {
 	r.peersMutex.Lock()
 	defer r.peersMutex.Unlock()
-	peers := r.peers.Clone()
-	return &peers
+	return r.peers.Clone()
 }
 
 func (r *runtime) getLastBillingHeight() int32 {
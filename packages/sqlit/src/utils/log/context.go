@@ -0,0 +1,55 @@
+
+package log
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// RequestIDKey is the field of the request id set by WithRequestID.
+const RequestIDKey = "request_id"
+
+// contextKey is the key of the entry carried by a context.
+type contextKey struct{}
+
+// WithContext returns a copy of ctx carrying the entry of FromContext(ctx) with fields
+// added, the fields of the parent contexts are kept.
+func WithContext(ctx context.Context, fields Fields) context.Context {
+	return context.WithValue(ctx, contextKey{}, FromContext(ctx).WithFields(fields))
+}
+
+// FromContext returns the entry carried by ctx, an entry of the standard logger is
+// returned if ctx carries none.
+func FromContext(ctx context.Context) *Entry {
+	if ctx != nil {
+		if entry, ok := ctx.Value(contextKey{}).(*Entry); ok {
+			return entry
+		}
+	}
+	return WithFields(nil)
+}
+
+// WithRequestID returns a copy of ctx whose entry has a new request id, the request
+// id of ctx is kept if it has one.
+func WithRequestID(ctx context.Context) (context.Context, string) {
+	if id := RequestID(ctx); id != "" {
+		return ctx, id
+	}
+	id := NewRequestID()
+	return WithContext(ctx, Fields{RequestIDKey: id}), id
+}
+
+// RequestID returns the request id carried by ctx, or an empty string.
+func RequestID(ctx context.Context) string {
+	id, _ := FromContext(ctx).Data[RequestIDKey].(string)
+	return id
+}
+
+// NewRequestID returns a random 16 hex digits request id.
+func NewRequestID() string {
+	var id [8]byte
+	// crypto/rand never fails on the supported platforms
+	_, _ = rand.Read(id[:])
+	return hex.EncodeToString(id[:])
+}
@@ -0,0 +1,58 @@
+
+package log
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestContextLogger(t *testing.T) {
+	var buf bytes.Buffer
+	SetOutput(&buf)
+	defer SetOutput(os.Stderr)
+	SetLevel(InfoLevel)
+	defer SetLevel(InfoLevel)
+	defer func() { _ = SetModuleLevels(nil) }()
+
+	// no entry in the context falls back to the standard logger
+	FromContext(context.Background()).Info("plain")
+	if out := buf.String(); !strings.Contains(out, "plain") || strings.Contains(out, RequestIDKey) {
+		t.Errorf("unexpected fallback output %q", out)
+	}
+
+	ctx, id := WithRequestID(context.Background())
+	if len(id) != 16 || RequestID(ctx) != id {
+		t.Fatalf("unexpected request id %q of %q", RequestID(ctx), id)
+	}
+	ctx = WithContext(ctx, Fields{"peer": "p1"})
+	if kept, keptID := WithRequestID(ctx); kept != ctx || keptID != id {
+		t.Errorf("request id replaced by %q", keptID)
+	}
+
+	buf.Reset()
+	FromContext(ctx).WithModule("route").WithFields(Fields{"node": "n1"}).Info("set node addr")
+	out := buf.String()
+	for _, field := range []string{RequestIDKey + "=" + id, "peer=p1", "node=n1", "module=route"} {
+		if !strings.Contains(out, field) {
+			t.Errorf("missing %q in %q", field, out)
+		}
+	}
+
+	// module levels apply to the context entries
+	if err := SetModuleLevels(map[string]string{"route": "warn"}); err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	FromContext(ctx).WithModule("route").Info("dropped")
+	FromContext(ctx).WithModule("conf").Info("kept")
+	if out = buf.String(); strings.Contains(out, "dropped") || !strings.Contains(out, "kept") {
+		t.Errorf("module level not applied: %q", out)
+	}
+
+	if NewRequestID() == NewRequestID() {
+		t.Error("request ids collide")
+	}
+}
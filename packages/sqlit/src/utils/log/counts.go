@@ -0,0 +1,62 @@
+
+package log
+
+import (
+	"sync/atomic"
+
+	"github.com/sirupsen/logrus"
+)
+
+// LevelCounts is the count of the records emitted by severity, see Counts.
+type LevelCounts struct {
+	// Errors counts the records of ErrorLevel and the more severe levels
+	Errors uint64
+	// Warnings counts the records of WarnLevel
+	Warnings uint64
+}
+
+var (
+	errorCount   atomic.Uint64
+	warningCount atomic.Uint64
+)
+
+func init() {
+	AddHook(countHook{})
+}
+
+// Counts returns the count of the records emitted by the standard logger since the
+// start or ResetCounts, e.g. for an error rate metric. The records filtered by their
+// level or module level are not counted, neither are the suppressed sampled ones.
+func Counts() LevelCounts {
+	return LevelCounts{
+		Errors:   errorCount.Load(),
+		Warnings: warningCount.Load(),
+	}
+}
+
+// ResetCounts sets the counts of Counts to zero, for tests.
+func ResetCounts() {
+	errorCount.Store(0)
+	warningCount.Store(0)
+}
+
+// countHook counts the records of the levels in LevelCounts.
+type countHook struct{}
+
+// Levels implements logrus.Hook.
+func (countHook) Levels() []logrus.Level {
+	return []logrus.Level{PanicLevel, FatalLevel, ErrorLevel, WarnLevel}
+}
+
+// Fire implements logrus.Hook.
+func (countHook) Fire(entry *logrus.Entry) error {
+	if moduleFiltered(entry) {
+		return nil
+	}
+	if entry.Level == WarnLevel {
+		warningCount.Add(1)
+	} else {
+		errorCount.Add(1)
+	}
+	return nil
+}
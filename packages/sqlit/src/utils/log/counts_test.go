@@ -0,0 +1,59 @@
+
+package log
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"sync"
+	"testing"
+)
+
+func TestCounts(t *testing.T) {
+	var buf bytes.Buffer
+	SetOutput(&buf)
+	defer SetOutput(os.Stderr)
+	SetLevel(InfoLevel)
+	defer SetLevel(InfoLevel)
+	defer func() { _ = SetModuleLevels(nil) }()
+	ResetCounts()
+	defer ResetCounts()
+
+	WithError(errors.New("dial failed")).Error("setup failed")
+	WithField("node", "a").Warning("slow node")
+	Warningf("slow %s", "node")
+	Info("info is not counted")
+	if c := Counts(); c != (LevelCounts{Errors: 1, Warnings: 2}) {
+		t.Errorf("unexpected counts %+v", c)
+	}
+
+	// the records filtered by the module level are not counted
+	if err := SetModuleLevels(map[string]string{"kms": "error"}); err != nil {
+		t.Fatal(err)
+	}
+	WithModule("kms").Warning("filtered warning")
+	WithModule("kms").Error("kms error")
+	if c := Counts(); c != (LevelCounts{Errors: 2, Warnings: 2}) {
+		t.Errorf("unexpected counts %+v", c)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				Warning("concurrent warning")
+			}
+		}()
+	}
+	wg.Wait()
+	if c := Counts(); c.Warnings != 802 {
+		t.Errorf("unexpected warnings %d", c.Warnings)
+	}
+
+	ResetCounts()
+	if c := Counts(); c != (LevelCounts{}) {
+		t.Errorf("counts not reset %+v", c)
+	}
+}
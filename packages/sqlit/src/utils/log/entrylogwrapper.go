@@ -45,124 +45,124 @@ func (entry *Entry) WithTime(t time.Time) *Entry {
 
 // Debug record a new debug level log.
 func (entry *Entry) Debug(args ...interface{}) {
-	(*logrus.Entry)(entry).Debug(args...)
+	(*logrus.Entry)(entry).Debug(redactArgs(args)...)
 }
 
 // Print record a new non-level log.
 func (entry *Entry) Print(args ...interface{}) {
-	(*logrus.Entry)(entry).Print(args...)
+	(*logrus.Entry)(entry).Print(redactArgs(args)...)
 }
 
 // Info record a new info level log.
 func (entry *Entry) Info(args ...interface{}) {
-	(*logrus.Entry)(entry).Info(args...)
+	(*logrus.Entry)(entry).Info(redactArgs(args)...)
 }
 
 // Warn record a new warning level log.
 func (entry *Entry) Warn(args ...interface{}) {
-	(*logrus.Entry)(entry).Warn(args...)
+	(*logrus.Entry)(entry).Warn(redactArgs(args)...)
 }
 
 // Warning record a new warning level log.
 func (entry *Entry) Warning(args ...interface{}) {
-	(*logrus.Entry)(entry).Warning(args...)
+	(*logrus.Entry)(entry).Warning(redactArgs(args)...)
 }
 
 // Error record a new error level log.
 func (entry *Entry) Error(args ...interface{}) {
-	(*logrus.Entry)(entry).Error(args...)
+	(*logrus.Entry)(entry).Error(redactArgs(args)...)
 }
 
 // Fatal record a fatal level log.
 func (entry *Entry) Fatal(args ...interface{}) {
-	(*logrus.Entry)(entry).Fatal(args...)
+	(*logrus.Entry)(entry).Fatal(redactArgs(args)...)
 }
 
 // Panic record a panic level log.
 func (entry *Entry) Panic(args ...interface{}) {
-	(*logrus.Entry)(entry).Panic(args...)
+	(*logrus.Entry)(entry).Panic(redactArgs(args)...)
 }
 
 // Entry Printf family functions
 
 // Debugf record a debug level log.
 func (entry *Entry) Debugf(format string, args ...interface{}) {
-	(*logrus.Entry)(entry).Debugf(format, args...)
+	(*logrus.Entry)(entry).Debugf(format, redactArgs(args)...)
 }
 
 // Infof record a info level log.
 func (entry *Entry) Infof(format string, args ...interface{}) {
-	(*logrus.Entry)(entry).Infof(format, args...)
+	(*logrus.Entry)(entry).Infof(format, redactArgs(args)...)
 }
 
 // Printf record a new non-level log.
 func (entry *Entry) Printf(format string, args ...interface{}) {
-	(*logrus.Entry)(entry).Printf(format, args...)
+	(*logrus.Entry)(entry).Printf(format, redactArgs(args)...)
 }
 
 // Warnf record a warning level log.
 func (entry *Entry) Warnf(format string, args ...interface{}) {
-	(*logrus.Entry)(entry).Warnf(format, args...)
+	(*logrus.Entry)(entry).Warnf(format, redactArgs(args)...)
 }
 
 // Warningf record a warning level log.
 func (entry *Entry) Warningf(format string, args ...interface{}) {
-	(*logrus.Entry)(entry).Warningf(format, args...)
+	(*logrus.Entry)(entry).Warningf(format, redactArgs(args)...)
 }
 
 // Errorf record a error level log.
 func (entry *Entry) Errorf(format string, args ...interface{}) {
-	(*logrus.Entry)(entry).Errorf(format, args...)
+	(*logrus.Entry)(entry).Errorf(format, redactArgs(args)...)
 }
 
 // Fatalf record a fatal level log.
 func (entry *Entry) Fatalf(format string, args ...interface{}) {
-	(*logrus.Entry)(entry).Fatalf(format, args...)
+	(*logrus.Entry)(entry).Fatalf(format, redactArgs(args)...)
 }
 
 // Panicf record a panic level log.
 func (entry *Entry) Panicf(format string, args ...interface{}) {
-	(*logrus.Entry)(entry).Panicf(format, args...)
+	(*logrus.Entry)(entry).Panicf(format, redactArgs(args)...)
 }
 
 // Entry Println family functions
 
 // Debugln record a debug level log.
 func (entry *Entry) Debugln(args ...interface{}) {
-	(*logrus.Entry)(entry).Debugln(args...)
+	(*logrus.Entry)(entry).Debugln(redactArgs(args)...)
 }
 
 // Infoln record a info level log.
 func (entry *Entry) Infoln(args ...interface{}) {
-	(*logrus.Entry)(entry).Infoln(args...)
+	(*logrus.Entry)(entry).Infoln(redactArgs(args)...)
 }
 
 // Println record a non-level log.
 func (entry *Entry) Println(args ...interface{}) {
-	(*logrus.Entry)(entry).Println(args...)
+	(*logrus.Entry)(entry).Println(redactArgs(args)...)
 }
 
 // Warnln record a warning level log.
 func (entry *Entry) Warnln(args ...interface{}) {
-	(*logrus.Entry)(entry).Warnln(args...)
+	(*logrus.Entry)(entry).Warnln(redactArgs(args)...)
 }
 
 // Warningln record a warning level log.
 func (entry *Entry) Warningln(args ...interface{}) {
-	(*logrus.Entry)(entry).Warningln(args...)
+	(*logrus.Entry)(entry).Warningln(redactArgs(args)...)
 }
 
 // Errorln record a error level log.
 func (entry *Entry) Errorln(args ...interface{}) {
-	(*logrus.Entry)(entry).Errorln(args...)
+	(*logrus.Entry)(entry).Errorln(redactArgs(args)...)
 }
 
 // Fatalln record a fatal level log.
 func (entry *Entry) Fatalln(args ...interface{}) {
-	(*logrus.Entry)(entry).Fatalln(args...)
+	(*logrus.Entry)(entry).Fatalln(redactArgs(args)...)
 }
 
 // Panicln record a panic level log.
 func (entry *Entry) Panicln(args ...interface{}) {
-	(*logrus.Entry)(entry).Panicln(args...)
+	(*logrus.Entry)(entry).Panicln(redactArgs(args)...)
 }
@@ -0,0 +1,100 @@
+
+package log
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// TextFormat is the default human readable log format.
+	TextFormat = "text"
+	// JSONFormat is the log format of one JSON object per line.
+	JSONFormat = "json"
+)
+
+// JSONFormatter formats an entry as one JSON object with the stable keys level,
+// time and msg plus the entry fields. Byte slices and arrays, e.g. the raw node
+// ids and hashes, are hex encoded, fmt.Stringer values are formatted by String and
+// the values not marshalable to JSON are formatted by %+v.
+type JSONFormatter struct {
+	json logrus.JSONFormatter
+}
+
+// NewJSONFormatter returns a new JSONFormatter.
+func NewJSONFormatter() *JSONFormatter {
+	return &JSONFormatter{json: logrus.JSONFormatter{TimestampFormat: time.RFC3339Nano}}
+}
+
+// Format implements logrus.Formatter.Format.
+func (f *JSONFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	data := make(logrus.Fields, len(entry.Data))
+	for k, v := range entry.Data {
+		data[k] = jsonValue(v)
+	}
+	copied := *entry
+	copied.Data = data
+	return f.json.Format(&copied)
+}
+
+// SetFormat sets the standard logger formatter by the format name, an empty name
+// keeps the text format.
+func SetFormat(format string) (err error) {
+	switch format {
+	case "", TextFormat:
+		SetFormatter(&logrus.TextFormatter{})
+	case JSONFormat:
+		SetFormatter(NewJSONFormatter())
+	default:
+		err = fmt.Errorf("unknown log format %q, must be %s or %s", format, TextFormat, JSONFormat)
+	}
+	return
+}
+
+var byteType = reflect.TypeOf(byte(0))
+
+func jsonValue(v interface{}) interface{} {
+	switch t := v.(type) {
+	case nil:
+		return nil
+	case error:
+		return t.Error()
+	case []byte:
+		return hex.EncodeToString(t)
+	case fmt.Stringer:
+		rv := reflect.ValueOf(v)
+		if rv.Kind() == reflect.Ptr && rv.IsNil() {
+			return nil
+		}
+		return t.String()
+	case string, bool, int, int8, int16, int32, int64,
+		uint, uint8, uint16, uint32, uint64, float32, float64:
+		return v
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Array:
+		if rv.Type().Elem() == byteType {
+			b := make([]byte, rv.Len())
+			reflect.Copy(reflect.ValueOf(b), rv)
+			return hex.EncodeToString(b)
+		}
+		fallthrough
+	case reflect.Slice:
+		values := make([]interface{}, rv.Len())
+		for i := range values {
+			values[i] = jsonValue(rv.Index(i).Interface())
+		}
+		return values
+	}
+	if _, err := json.Marshal(v); err != nil {
+		return fmt.Sprintf("%+v", v)
+	}
+	return v
+}
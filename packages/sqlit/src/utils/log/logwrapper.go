@@ -189,14 +189,20 @@ func SetFormatter(formatter logrus.Formatter) {
 	logrus.SetFormatter(formatter)
 }
 
-// SetLevel sets the standard logger level.
+// SetLevel sets the standard logger level, the modules with their own level set by
+// SetModuleLevels are not affected.
 func SetLevel(level logrus.Level) {
-	logrus.SetLevel(level)
+	levelLock.Lock()
+	defer levelLock.Unlock()
+	baseLevel = level
+	applyLevelLocked()
 }
 
 // GetLevel returns the standard logger level.
 func GetLevel() logrus.Level {
-	return logrus.GetLevel()
+	levelLock.RLock()
+	defer levelLock.RUnlock()
+	return baseLevel
 }
 
 // ParseLevel parse the level string and returns the logger level.
@@ -249,123 +255,123 @@ func WithTime(t time.Time) *Entry {
 
 // Debug logs a message at level Debug on the standard logger.
 func Debug(args ...interface{}) {
-	logrus.Debug(args...)
+	logrus.Debug(redactArgs(args)...)
 }
 
 // Print logs a message at level Info on the standard logger.
 func Print(args ...interface{}) {
-	logrus.Print(args...)
+	logrus.Print(redactArgs(args)...)
 }
 
 // Info logs a message at level Info on the standard logger.
 func Info(args ...interface{}) {
-	logrus.Info(args...)
+	logrus.Info(redactArgs(args)...)
 }
 
 // Warn logs a message at level Warn on the standard logger.
 func Warn(args ...interface{}) {
-	logrus.Warn(args...)
+	logrus.Warn(redactArgs(args)...)
 }
 
 // Warning logs a message at level Warn on the standard logger.
 func Warning(args ...interface{}) {
-	logrus.Warning(args...)
+	logrus.Warning(redactArgs(args)...)
 }
 
 // Error logs a message at level Error on the standard logger.
 func Error(args ...interface{}) {
 	//std.WithField("Func", getFuncPath(2)).Error(args...)
-	logrus.Error(args...)
+	logrus.Error(redactArgs(args)...)
 }
 
 // Fatal logs a message at level Fatal on the standard logger.
 func Fatal(args ...interface{}) {
 	//std.WithField("Func", getFuncPath(2)).Fatal(args...)
-	logrus.Fatal(args...)
+	logrus.Fatal(redactArgs(args)...)
 }
 
 // Panic logs a message at level Panic on the standard logger.
 func Panic(args ...interface{}) {
 	//std.WithField("Func", getFuncPath(2)).Panic(args...)
-	logrus.Panic(args...)
+	logrus.Panic(redactArgs(args)...)
 }
 
 // Debugf logs a message at level Debug on the standard logger.
 func Debugf(format string, args ...interface{}) {
-	logrus.Debugf(format, args...)
+	logrus.Debugf(format, redactArgs(args)...)
 }
 
 // Printf logs a message at level Info on the standard logger.
 func Printf(format string, args ...interface{}) {
-	logrus.Printf(format, args...)
+	logrus.Printf(format, redactArgs(args)...)
 }
 
 // Infof logs a message at level Info on the standard logger.
 func Infof(format string, args ...interface{}) {
-	logrus.Infof(format, args...)
+	logrus.Infof(format, redactArgs(args)...)
 }
 
 // Warnf logs a message at level Warn on the standard logger.
 func Warnf(format string, args ...interface{}) {
-	logrus.Warnf(format, args...)
+	logrus.Warnf(format, redactArgs(args)...)
 }
 
 // Warningf logs a message at level Warn on the standard logger.
 func Warningf(format string, args ...interface{}) {
-	logrus.Warningf(format, args...)
+	logrus.Warningf(format, redactArgs(args)...)
 }
 
 // Errorf logs a message at level Error on the standard logger.
 func Errorf(format string, args ...interface{}) {
-	logrus.Errorf(format, args...)
+	logrus.Errorf(format, redactArgs(args)...)
 }
 
 // Fatalf logs a message at level Fatal on the standard logger.
 func Fatalf(format string, args ...interface{}) {
-	logrus.Fatalf(format, args...)
+	logrus.Fatalf(format, redactArgs(args)...)
 }
 
 // Panicf logs a message at level Panic on the standard logger.
 func Panicf(format string, args ...interface{}) {
-	logrus.Panicf(format, args...)
+	logrus.Panicf(format, redactArgs(args)...)
 }
 
 // Debugln logs a message at level Debug on the standard logger.
 func Debugln(args ...interface{}) {
-	logrus.Debugln(args...)
+	logrus.Debugln(redactArgs(args)...)
 }
 
 // Println logs a message at level Info on the standard logger.
 func Println(args ...interface{}) {
-	logrus.Println(args...)
+	logrus.Println(redactArgs(args)...)
 }
 
 // Infoln logs a message at level Info on the standard logger.
 func Infoln(args ...interface{}) {
-	logrus.Infoln(args...)
+	logrus.Infoln(redactArgs(args)...)
 }
 
 // Warnln logs a message at level Warn on the standard logger.
 func Warnln(args ...interface{}) {
-	logrus.Warnln(args...)
+	logrus.Warnln(redactArgs(args)...)
 }
 
 // Warningln logs a message at level Warn on the standard logger.
 func Warningln(args ...interface{}) {
-	logrus.Warningln(args...)
+	logrus.Warningln(redactArgs(args)...)
 }
 
 // Errorln logs a message at level Error on the standard logger.
 func Errorln(args ...interface{}) {
-	logrus.Errorln(args...)
+	logrus.Errorln(redactArgs(args)...)
 }
 
 // Fatalln logs a message at level Fatal on the standard logger.
 func Fatalln(args ...interface{}) {
-	logrus.Fatalln(args...)
+	logrus.Fatalln(redactArgs(args)...)
 }
 
 // Panicln logs a message at level Panic on the standard logger.
 func Panicln(args ...interface{}) {
-	logrus.Panicln(args...)
+	logrus.Panicln(redactArgs(args)...)
 }
@@ -1,7 +1,10 @@
 package log
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
+	"strings"
 	"testing"
 	"time"
 
@@ -184,3 +187,62 @@ func TestFatalLog(t *testing.T) {
 
 	logrus.StandardLogger().ExitFunc = nil
 }
+
+type testStringer [4]byte
+
+func (s testStringer) String() string { return "stringer" }
+
+func TestJSONFormat(t *testing.T) {
+	var buf bytes.Buffer
+	defer SetOutput(logrus.StandardLogger().Out)
+	SetOutput(&buf)
+	if err := SetFormat(JSONFormat); err != nil {
+		t.Fatal(err)
+	}
+	defer SetFormat(TextFormat)
+	SetLevel(InfoLevel)
+
+	WithFields(Fields{
+		"raw":      [4]byte{0xde, 0xad, 0xbe, 0xef},
+		"bytes":    []byte{0x01, 0x02},
+		"stringer": testStringer{},
+		"list":     [][2]byte{{0xab, 0xcd}},
+		"chan":     make(chan int),
+		"count":    3,
+	}).WithError(errors.New("boom")).Info("json line")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("expect one line, got %q", buf.String())
+	}
+	var out map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &out); err != nil {
+		t.Fatalf("unparseable line %q: %v", lines[0], err)
+	}
+	for k, v := range map[string]interface{}{
+		"level":    "info",
+		"msg":      "json line",
+		"raw":      "deadbeef",
+		"bytes":    "0102",
+		"stringer": "stringer",
+		"count":    float64(3),
+		"error":    "boom",
+	} {
+		if out[k] != v {
+			t.Errorf("key %s: expect %v, got %v", k, v, out[k])
+		}
+	}
+	if list, ok := out["list"].([]interface{}); !ok || len(list) != 1 || list[0] != "abcd" {
+		t.Errorf("unexpected list %v", out["list"])
+	}
+	if _, ok := out["time"].(string); !ok {
+		t.Errorf("missing time in %q", lines[0])
+	}
+	if _, ok := out["chan"].(string); !ok {
+		t.Errorf("unmarshalable value not formatted %v", out["chan"])
+	}
+
+	if err := SetFormat("xml"); err == nil {
+		t.Error("expect unknown format error")
+	}
+}
@@ -0,0 +1,163 @@
+
+package log
+
+import (
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ModuleKey is the field naming the module of the entries created by WithModule.
+const ModuleKey = "module"
+
+var (
+	levelLock    sync.RWMutex
+	baseLevel    = logrus.GetLevel()
+	moduleLevels map[string]logrus.Level
+
+	// discardLogger takes over the entries filtered by their module level
+	discardLogger = &logrus.Logger{
+		Out:       &NilWriter{},
+		Formatter: &NilFormatter{},
+		Hooks:     make(logrus.LevelHooks),
+		Level:     DebugLevel,
+		ExitFunc:  logrus.StandardLogger().ExitFunc,
+	}
+)
+
+func init() {
+	AddHook(moduleLevelHook{})
+}
+
+// WithModule creates an entry from the standard logger which is filtered by the
+// module level set by SetModuleLevels, the global level applies if module has none.
+func WithModule(module string) *Entry {
+	return WithField(ModuleKey, module)
+}
+
+// WithModule adds the module field to entry, see WithModule.
+func (entry *Entry) WithModule(module string) *Entry {
+	return entry.WithField(ModuleKey, module)
+}
+
+// SetModuleLevels sets the log levels of the modules by level names, e.g.
+// {"route": "debug", "kms": "warn"}. The previous module levels are replaced, nil
+// clears them.
+func SetModuleLevels(levels map[string]string) (err error) {
+	parsed := make(map[string]logrus.Level, len(levels))
+	for module, name := range levels {
+		if parsed[module], err = ParseLevel(name); err != nil {
+			return
+		}
+	}
+	levelLock.Lock()
+	defer levelLock.Unlock()
+	moduleLevels = parsed
+	applyLevelLocked()
+	return
+}
+
+// GetModuleLevel returns the log level of module.
+func GetModuleLevel(module string) logrus.Level {
+	levelLock.RLock()
+	defer levelLock.RUnlock()
+	if level, ok := moduleLevels[module]; ok {
+		return level
+	}
+	return baseLevel
+}
+
+// StepLevel moves the global level by steps, a positive step is more verbose, and
+// returns the new level. A step does not go past WarnLevel or DebugLevel, so the
+// warnings and errors are never silenced by the steps.
+func StepLevel(steps int) logrus.Level {
+	levelLock.Lock()
+	defer levelLock.Unlock()
+	baseLevel = stepLevel(baseLevel, steps)
+	applyLevelLocked()
+	return baseLevel
+}
+
+// StepModuleLevel moves the level of module by steps as StepLevel does, a module
+// without its own level steps from the global level. The other levels are kept.
+func StepModuleLevel(module string, steps int) logrus.Level {
+	levelLock.Lock()
+	defer levelLock.Unlock()
+	level, ok := moduleLevels[module]
+	if !ok {
+		level = baseLevel
+	}
+	level = stepLevel(level, steps)
+	levels := make(map[string]logrus.Level, len(moduleLevels)+1)
+	for m, l := range moduleLevels {
+		levels[m] = l
+	}
+	levels[module] = level
+	moduleLevels = levels
+	applyLevelLocked()
+	return level
+}
+
+// stepLevel moves level by steps without going past WarnLevel and DebugLevel, a
+// level beyond them is kept by the steps towards them.
+func stepLevel(level logrus.Level, steps int) logrus.Level {
+	stepped := int(level) + steps
+	switch {
+	case steps < 0 && stepped < int(WarnLevel):
+		if level < WarnLevel {
+			return level
+		}
+		return WarnLevel
+	case steps > 0 && stepped > int(DebugLevel):
+		if level > DebugLevel {
+			return level
+		}
+		return DebugLevel
+	}
+	return logrus.Level(stepped)
+}
+
+// applyLevelLocked sets the standard logger to the most verbose level of the global
+// and module levels, moduleLevelHook filters the entries above their own level.
+func applyLevelLocked() {
+	level := baseLevel
+	for _, l := range moduleLevels {
+		if l > level {
+			level = l
+		}
+	}
+	logrus.SetLevel(level)
+}
+
+// moduleLevelHook drops the entries above the level of their module.
+type moduleLevelHook struct{}
+
+// Levels implements logrus.Hook.Levels.
+func (moduleLevelHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire implements logrus.Hook.Fire.
+func (moduleLevelHook) Fire(entry *logrus.Entry) error {
+	if moduleFiltered(entry) {
+		entry.Logger = discardLogger
+	}
+	return nil
+}
+
+// moduleFiltered returns if entry is above the level of its module.
+func moduleFiltered(entry *logrus.Entry) bool {
+	levelLock.RLock()
+	defer levelLock.RUnlock()
+	if len(moduleLevels) == 0 {
+		// the standard logger is at the global level already
+		return false
+	}
+	level := baseLevel
+	if module, ok := entry.Data[ModuleKey].(string); ok {
+		if l, ok := moduleLevels[module]; ok {
+			level = l
+		}
+	}
+	return entry.Level > level
+}
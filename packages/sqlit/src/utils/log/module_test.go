@@ -0,0 +1,131 @@
+
+package log
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+)
+
+func TestModuleLevels(t *testing.T) {
+	var buf bytes.Buffer
+	SetOutput(&buf)
+	defer SetOutput(os.Stderr)
+	SetLevel(InfoLevel)
+	defer SetLevel(InfoLevel)
+	defer func() { _ = SetModuleLevels(nil) }()
+
+	if err := SetModuleLevels(map[string]string{"route": "debug", "kms": "warn"}); err != nil {
+		t.Fatal(err)
+	}
+	if GetLevel() != InfoLevel {
+		t.Errorf("global level changed: %v", GetLevel())
+	}
+	if GetModuleLevel("route") != DebugLevel || GetModuleLevel("conf") != InfoLevel {
+		t.Errorf("unexpected module levels %v %v", GetModuleLevel("route"), GetModuleLevel("conf"))
+	}
+
+	WithModule("route").Debug("route debug")
+	WithModule("kms").Info("kms info")
+	WithModule("kms").Warning("kms warning")
+	WithModule("conf").Debug("conf debug")
+	WithModule("conf").Info("conf info")
+	Debug("global debug")
+	Info("global info")
+
+	out := buf.String()
+	for _, line := range []string{"route debug", "kms warning", "conf info", "global info"} {
+		if !strings.Contains(out, line) {
+			t.Errorf("missing %q in %q", line, out)
+		}
+	}
+	for _, line := range []string{"kms info", "conf debug", "global debug"} {
+		if strings.Contains(out, line) {
+			t.Errorf("unexpected %q in %q", line, out)
+		}
+	}
+
+	if err := SetModuleLevels(map[string]string{"route": "loud"}); err == nil {
+		t.Error("expect invalid level error")
+	}
+	if GetModuleLevel("route") != DebugLevel {
+		t.Error("invalid levels should keep the previous ones")
+	}
+
+	// cleared module levels fall back to the global level
+	buf.Reset()
+	if err := SetModuleLevels(nil); err != nil {
+		t.Fatal(err)
+	}
+	WithModule("route").Debug("route debug")
+	if buf.Len() != 0 {
+		t.Errorf("unexpected output %q", buf.String())
+	}
+}
+
+func TestStepLevel(t *testing.T) {
+	var buf bytes.Buffer
+	SetOutput(&buf)
+	defer SetOutput(os.Stderr)
+	SetLevel(InfoLevel)
+	defer SetLevel(InfoLevel)
+	defer func() { _ = SetModuleLevels(nil) }()
+
+	for _, c := range []struct {
+		steps int
+		level logrus.Level
+	}{{1, DebugLevel}, {1, DebugLevel}, {-1, InfoLevel}, {-2, WarnLevel}, {-1, WarnLevel}, {1, InfoLevel}} {
+		if level := StepLevel(c.steps); level != c.level || GetLevel() != c.level {
+			t.Errorf("step %d: expect %v, got %v", c.steps, c.level, level)
+		}
+	}
+	SetLevel(ErrorLevel)
+	if level := StepLevel(-1); level != ErrorLevel {
+		t.Errorf("a lower level should be kept, got %v", level)
+	}
+	SetLevel(InfoLevel)
+
+	if err := SetModuleLevels(map[string]string{"kms": "warn"}); err != nil {
+		t.Fatal(err)
+	}
+	if level := StepModuleLevel("route", 1); level != DebugLevel {
+		t.Errorf("route should step from the global level, got %v", level)
+	}
+	if GetModuleLevel("kms") != WarnLevel || GetLevel() != InfoLevel {
+		t.Errorf("unexpected levels %v %v", GetModuleLevel("kms"), GetLevel())
+	}
+	WithModule("route").Debug("route debug")
+	WithModule("conf").Debug("conf debug")
+	if out := buf.String(); !strings.Contains(out, "route debug") || strings.Contains(out, "conf debug") {
+		t.Errorf("unexpected output %q", out)
+	}
+
+	// the steps are safe with the concurrent logging
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					WithModule("route").Debug("route debug")
+					Info("global info")
+				}
+			}
+		}()
+	}
+	for i := 0; i < 100; i++ {
+		StepLevel(1 - 2*(i%2))
+		StepModuleLevel("route", 2*(i%2)-1)
+	}
+	close(stop)
+	wg.Wait()
+}
@@ -0,0 +1,360 @@
+
+package log
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// Redacted replaces the sensitive values without a Redactor.
+	Redacted = "[redacted]"
+	// RedactTag is the struct tag of the fields always redacted, e.g. `log:"redact"`.
+	RedactTag = "log"
+
+	// maxRedactDepth bounds the nesting rendered by Redact.
+	maxRedactDepth = 16
+)
+
+// Redactor renders a sensitive value for the log, e.g. as a fingerprint.
+type Redactor func(v interface{}) string
+
+// typeSensitivity is the sensitive content reachable from a type.
+type typeSensitivity struct {
+	secret bool
+	public bool
+}
+
+var (
+	redactLock      sync.RWMutex
+	secretTypes     = make(map[reflect.Type]Redactor)
+	publicTypes     = make(map[reflect.Type]Redactor)
+	sensitivityByTy sync.Map // map[reflect.Type]typeSensitivity
+
+	redactPublic atomic.Bool
+	unsafeDebug  atomic.Bool
+)
+
+func init() {
+	AddHook(redactHook{})
+}
+
+// RegisterSensitive registers the type of sample as a secret, e.g. a private key,
+// it is always rendered by redactor, a nil redactor renders Redacted.
+func RegisterSensitive(sample interface{}, redactor Redactor) {
+	registerType(secretTypes, sample, redactor)
+}
+
+// RegisterPublic registers the type of sample as a public identity, e.g. a public
+// key, it is rendered by redactor only if SetRedactPublic is enabled.
+func RegisterPublic(sample interface{}, redactor Redactor) {
+	registerType(publicTypes, sample, redactor)
+}
+
+func registerType(types map[reflect.Type]Redactor, sample interface{}, redactor Redactor) {
+	if redactor == nil {
+		redactor = func(interface{}) string { return Redacted }
+	}
+	redactLock.Lock()
+	defer redactLock.Unlock()
+	types[reflect.TypeOf(sample)] = redactor
+	// the containing types are computed again
+	sensitivityByTy.Range(func(key, _ interface{}) bool {
+		sensitivityByTy.Delete(key)
+		return true
+	})
+}
+
+// SetRedactPublic sets if the types of RegisterPublic are redacted too.
+func SetRedactPublic(enabled bool) {
+	redactPublic.Store(enabled)
+}
+
+// SetUnsafeDebug disables all the redaction, the secrets are logged as they are. It
+// is the escape hatch for debugging only, never enable it in production.
+func SetUnsafeDebug(enabled bool) {
+	unsafeDebug.Store(enabled)
+}
+
+// UnsafeDebug returns if the redaction is disabled by SetUnsafeDebug.
+func UnsafeDebug() bool {
+	return unsafeDebug.Load()
+}
+
+// Redact wraps v so the registered sensitive values and the fields tagged
+// `log:"redact"` in it are redacted however it is formatted, including %#v. Other
+// values are formatted as they are. The printf arguments and the fields of the log
+// entries are redacted without it.
+func Redact(v interface{}) interface{} {
+	return redacted{v: v}
+}
+
+// redacted is the value wrapped by Redact.
+type redacted struct {
+	v interface{}
+}
+
+// Format implements fmt.Formatter.
+func (r redacted) Format(s fmt.State, verb rune) {
+	if UnsafeDebug() || !isSensitive(reflect.TypeOf(r.v)) {
+		fmt.Fprintf(s, fmt.FormatString(s, verb), r.v)
+		return
+	}
+	mode := 'v'
+	if verb == 'v' && s.Flag('#') {
+		mode = '#'
+	} else if verb == 'v' && s.Flag('+') {
+		mode = '+'
+	}
+	_, _ = s.Write([]byte(renderRedacted(reflect.ValueOf(r.v), mode, 0)))
+}
+
+// String implements fmt.Stringer for the formatters not using fmt verbs.
+func (r redacted) String() string {
+	return fmt.Sprint(r)
+}
+
+// redactArgs wraps the sensitive arguments of the printf family by Redact.
+func redactArgs(args []interface{}) []interface{} {
+	if UnsafeDebug() {
+		return args
+	}
+	var wrapped []interface{}
+	for i, arg := range args {
+		if !isSensitive(reflect.TypeOf(arg)) {
+			continue
+		}
+		if wrapped == nil {
+			wrapped = append([]interface{}(nil), args...)
+		}
+		wrapped[i] = Redact(arg)
+	}
+	if wrapped == nil {
+		return args
+	}
+	return wrapped
+}
+
+// redactHook redacts the sensitive entry fields.
+type redactHook struct{}
+
+// Levels implements logrus.Hook.Levels.
+func (redactHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire implements logrus.Hook.Fire.
+func (redactHook) Fire(entry *logrus.Entry) error {
+	if UnsafeDebug() {
+		return nil
+	}
+	for key, value := range entry.Data {
+		if isSensitive(reflect.TypeOf(value)) {
+			entry.Data[key] = Redact(value)
+		}
+	}
+	return nil
+}
+
+// redactorOf returns the redactor of the type t registered and enabled.
+func redactorOf(t reflect.Type) (redactor Redactor, ok bool) {
+	redactLock.RLock()
+	defer redactLock.RUnlock()
+	if redactor, ok = secretTypes[t]; ok {
+		return
+	}
+	if redactPublic.Load() {
+		redactor, ok = publicTypes[t]
+	}
+	return
+}
+
+// isSensitive returns if a value of type t may hold a value to redact. The values
+// behind the interface fields are not known by the type, they are redacted only in
+// a sensitive value or passed as they are.
+func isSensitive(t reflect.Type) bool {
+	if t == nil {
+		return false
+	}
+	var s typeSensitivity
+	if cached, ok := sensitivityByTy.Load(t); ok {
+		s = cached.(typeSensitivity)
+	} else {
+		// only the complete results are cached, not the ones cut by a cycle
+		s = sensitivityOf(t, make(map[reflect.Type]bool))
+		sensitivityByTy.Store(t, s)
+	}
+	return s.secret || (s.public && redactPublic.Load())
+}
+
+func sensitivityOf(t reflect.Type, visiting map[reflect.Type]bool) (s typeSensitivity) {
+	if cached, ok := sensitivityByTy.Load(t); ok {
+		return cached.(typeSensitivity)
+	}
+	if visiting[t] {
+		// a recursive type is as sensitive as its other parts
+		return
+	}
+	visiting[t] = true
+	redactLock.RLock()
+	_, s.secret = secretTypes[t]
+	_, s.public = publicTypes[t]
+	redactLock.RUnlock()
+
+	merge := func(other typeSensitivity) {
+		s.secret = s.secret || other.secret
+		s.public = s.public || other.public
+	}
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Array:
+		merge(sensitivityOf(t.Elem(), visiting))
+	case reflect.Map:
+		merge(sensitivityOf(t.Key(), visiting))
+		merge(sensitivityOf(t.Elem(), visiting))
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.Tag.Get(RedactTag) == "redact" {
+				s.secret = true
+				continue
+			}
+			merge(sensitivityOf(f.Type, visiting))
+		}
+	}
+	delete(visiting, t)
+	return
+}
+
+// renderRedacted renders rv like fmt does for the verbs %v, %+v and %#v by mode
+// 'v', '+' and '#', the sensitive values are redacted.
+func renderRedacted(rv reflect.Value, mode rune, depth int) string {
+	if !rv.IsValid() {
+		return "<nil>"
+	}
+	t := rv.Type()
+	if redactor, ok := redactorOf(t); ok {
+		if isNil(rv) {
+			return "<nil>"
+		}
+		if !rv.CanInterface() {
+			return Redacted
+		}
+		return redactor(rv.Interface())
+	}
+	if t.Kind() != reflect.Interface && !isSensitive(t) {
+		return fmt.Sprintf("%"+modeFlag(mode)+"v", rv)
+	}
+	if depth >= maxRedactDepth {
+		return "..."
+	}
+
+	var b strings.Builder
+	switch t.Kind() {
+	case reflect.Interface:
+		if rv.IsNil() {
+			return "<nil>"
+		}
+		return renderRedacted(rv.Elem(), mode, depth+1)
+	case reflect.Ptr:
+		if rv.IsNil() {
+			if mode == '#' {
+				return fmt.Sprintf("(%s)(nil)", t)
+			}
+			return "<nil>"
+		}
+		b.WriteString("&")
+		b.WriteString(renderRedacted(rv.Elem(), mode, depth+1))
+	case reflect.Struct:
+		if mode == '#' {
+			b.WriteString(t.String())
+		}
+		b.WriteString("{")
+		for i := 0; i < t.NumField(); i++ {
+			if i > 0 {
+				b.WriteString(separator(mode))
+			}
+			f := t.Field(i)
+			if mode != 'v' {
+				b.WriteString(f.Name)
+				b.WriteString(":")
+			}
+			if f.Tag.Get(RedactTag) == "redact" {
+				b.WriteString(Redacted)
+			} else {
+				b.WriteString(renderRedacted(rv.Field(i), mode, depth+1))
+			}
+		}
+		b.WriteString("}")
+	case reflect.Slice, reflect.Array:
+		if mode == '#' {
+			if t.Kind() == reflect.Slice && rv.IsNil() {
+				return fmt.Sprintf("%s(nil)", t)
+			}
+			b.WriteString(t.String())
+			b.WriteString("{")
+		} else {
+			b.WriteString("[")
+		}
+		for i := 0; i < rv.Len(); i++ {
+			if i > 0 {
+				b.WriteString(separator(mode))
+			}
+			b.WriteString(renderRedacted(rv.Index(i), mode, depth+1))
+		}
+		if mode == '#' {
+			b.WriteString("}")
+		} else {
+			b.WriteString("]")
+		}
+	case reflect.Map:
+		entries := make([]string, 0, rv.Len())
+		for iter := rv.MapRange(); iter.Next(); {
+			entries = append(entries, renderRedacted(iter.Key(), mode, depth+1)+":"+
+				renderRedacted(iter.Value(), mode, depth+1))
+		}
+		sort.Strings(entries)
+		if mode == '#' {
+			b.WriteString(t.String())
+			b.WriteString("{")
+		} else {
+			b.WriteString("map[")
+		}
+		b.WriteString(strings.Join(entries, separator(mode)))
+		if mode == '#' {
+			b.WriteString("}")
+		} else {
+			b.WriteString("]")
+		}
+	default:
+		return fmt.Sprintf("%"+modeFlag(mode)+"v", rv)
+	}
+	return b.String()
+}
+
+func isNil(rv reflect.Value) bool {
+	switch rv.Kind() {
+	case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice, reflect.Func, reflect.Chan:
+		return rv.IsNil()
+	}
+	return false
+}
+
+func modeFlag(mode rune) string {
+	if mode == 'v' {
+		return ""
+	}
+	return string(mode)
+}
+
+func separator(mode rune) string {
+	if mode == '#' {
+		return ", "
+	}
+	return " "
+}
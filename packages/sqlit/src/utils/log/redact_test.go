@@ -0,0 +1,132 @@
+
+package log
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+type testSecret struct {
+	material string
+}
+
+type testPublic struct {
+	ID string
+}
+
+type testHolder struct {
+	Name     string
+	Secret   *testSecret
+	Public   testPublic
+	Password string `log:"redact"`
+	Secrets  []*testSecret
+	ByName   map[string]*testSecret
+	Next     *testHolder
+	Any      interface{}
+}
+
+type testPlain struct {
+	Name  string
+	Count int
+	next  *testPlain
+}
+
+func init() {
+	RegisterSensitive((*testSecret)(nil), nil)
+	RegisterPublic(testPublic{}, func(v interface{}) string {
+		id := v.(testPublic).ID
+		if len(id) > 2 {
+			id = id[:2]
+		}
+		return "fp:" + id
+	})
+}
+
+func newTestHolder() *testHolder {
+	secret := &testSecret{material: "s3cr3t"}
+	return &testHolder{
+		Name:     "holder",
+		Secret:   secret,
+		Public:   testPublic{ID: "abcdef"},
+		Password: "hunter2",
+		Secrets:  []*testSecret{secret, nil},
+		ByName:   map[string]*testSecret{"b": secret, "a": secret},
+		Next:     &testHolder{Name: "next", Any: secret},
+	}
+}
+
+func TestRedact(t *testing.T) {
+	defer SetRedactPublic(false)
+	holder := newTestHolder()
+	for _, format := range []string{"%v", "%+v", "%#v", "%s"} {
+		out := fmt.Sprintf(format, Redact(holder))
+		for _, leaked := range []string{"s3cr3t", "hunter2"} {
+			if strings.Contains(out, leaked) {
+				t.Errorf("%s of the holder leaks %q: %s", format, leaked, out)
+			}
+		}
+		if !strings.Contains(out, Redacted) || !strings.Contains(out, "holder") {
+			t.Errorf("%s of the holder is not redacted: %s", format, out)
+		}
+		if !strings.Contains(out, "abcdef") {
+			t.Errorf("%s of the holder redacts the public value by default: %s", format, out)
+		}
+	}
+	if out := fmt.Sprintf("%+v", Redact(holder)); !strings.Contains(out, "Password:"+Redacted) ||
+		!strings.Contains(out, "ByName:map[a:"+Redacted+" b:"+Redacted+"]") {
+		t.Errorf("unexpected %%+v rendering: %s", out)
+	}
+	if out := fmt.Sprintf("%#v", Redact(holder)); !strings.Contains(out, "log.testHolder{Name:\"holder\", ") ||
+		!strings.Contains(out, "Secrets:[]*log.testSecret{"+Redacted+", <nil>}") {
+		t.Errorf("unexpected %%#v rendering: %s", out)
+	}
+
+	SetRedactPublic(true)
+	if out := fmt.Sprintf("%v", Redact(holder)); strings.Contains(out, "abcdef") || !strings.Contains(out, "fp:ab") {
+		t.Errorf("the public value is not redacted: %s", out)
+	}
+
+	// the values without sensitive content are formatted as they are
+	plain := &testPlain{Name: "plain", Count: 2, next: &testPlain{}}
+	for _, format := range []string{"%v", "%+v", "%#v", "%q", "%5d"} {
+		if got, expected := fmt.Sprintf(format, Redact(plain)), fmt.Sprintf(format, plain); got != expected {
+			t.Errorf("%s of the plain value: %s, expected %s", format, got, expected)
+		}
+		if got, expected := fmt.Sprintf(format, Redact(plain.Count)), fmt.Sprintf(format, plain.Count); got != expected {
+			t.Errorf("%s of the plain int: %s, expected %s", format, got, expected)
+		}
+	}
+	if got := fmt.Sprint(Redact(nil)); got != fmt.Sprint(nil) {
+		t.Errorf("nil is rendered as %s", got)
+	}
+}
+
+func TestRedactLog(t *testing.T) {
+	var buf bytes.Buffer
+	SetOutput(&buf)
+	defer SetOutput(os.Stderr)
+	defer SetUnsafeDebug(false)
+	holder := newTestHolder()
+
+	Infof("dump %#v", holder)
+	WithField("holder", holder).Info("field")
+	WithFields(Fields{"secret": holder.Secret}).Infof("entry %v", holder.Secret)
+	if strings.Contains(buf.String(), "s3cr3t") || strings.Contains(buf.String(), "hunter2") {
+		t.Errorf("the log leaks the secrets: %s", buf.String())
+	}
+	if strings.Count(buf.String(), Redacted) < 4 {
+		t.Errorf("the log is not redacted: %s", buf.String())
+	}
+
+	// the escape hatch logs everything
+	buf.Reset()
+	SetUnsafeDebug(true)
+	Infof("dump %+v", holder)
+	WithField("password", holder).Info("field")
+	if strings.Count(buf.String(), "hunter2") != 2 {
+		t.Errorf("the unsafe debug log is redacted: %s", buf.String())
+	}
+}
@@ -0,0 +1,263 @@
+
+package log
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// backupTimeFormat is the timestamp of the rotated file names, it sorts by time.
+	backupTimeFormat = "2006-01-02T15-04-05.000000000"
+	// compressSuffix is the suffix of the gzipped rotated files.
+	compressSuffix = ".gz"
+)
+
+// RotateConfig defines the log file rotation of a RotatingWriter.
+type RotateConfig struct {
+	// Filename is the log file path, the rotated files are kept beside it as
+	// <name>-<timestamp><ext>
+	Filename string
+	// MaxSize is the file size in bytes triggering a rotation, 0 for no size limit
+	MaxSize int64
+	// MaxAge is the time since the file is opened triggering a rotation, 0 for no
+	// age limit
+	MaxAge time.Duration
+	// MaxBackups is the number of the rotated files kept, 0 keeps all of them
+	MaxBackups int
+	// Compress gzips the rotated files
+	Compress bool
+}
+
+// rotateFS is the file system used by RotatingWriter, it is replaced by tests.
+type rotateFS interface {
+	// OpenAppend opens name for appending and returns its current size
+	OpenAppend(name string) (w io.WriteCloser, size int64, err error)
+	Open(name string) (io.ReadCloser, error)
+	Create(name string) (io.WriteCloser, error)
+	Rename(oldName, newName string) error
+	Remove(name string) error
+	Glob(pattern string) ([]string, error)
+}
+
+type osFS struct{}
+
+func (osFS) OpenAppend(name string) (w io.WriteCloser, size int64, err error) {
+	if err = os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return
+	}
+	var f *os.File
+	if f, err = os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644); err != nil {
+		return
+	}
+	var info os.FileInfo
+	if info, err = f.Stat(); err != nil {
+		_ = f.Close()
+		return
+	}
+	return f, info.Size(), nil
+}
+
+func (osFS) Open(name string) (io.ReadCloser, error)           { return os.Open(name) }
+func (osFS) Create(name string) (io.WriteCloser, error)        { return os.Create(name) }
+func (osFS) Rename(oldName, newName string) error              { return os.Rename(oldName, newName) }
+func (osFS) Remove(name string) error                          { return os.Remove(name) }
+func (osFS) Glob(pattern string) (matches []string, err error) { return filepath.Glob(pattern) }
+
+// RotatingWriter is an io.Writer appending to a log file which is rotated by size
+// and age, it can be passed to SetOutput. Writes are serialized with the rotation
+// so no line is split or dropped during the swap. The rotated files are compressed
+// and pruned in the background, Close waits for it.
+type RotatingWriter struct {
+	config RotateConfig
+	fs     rotateFS
+	now    func() time.Time
+
+	mu       sync.Mutex
+	file     io.WriteCloser
+	size     int64
+	openedAt time.Time
+
+	millCh chan struct{}
+	millWg sync.WaitGroup
+}
+
+// NewRotatingWriter returns a new RotatingWriter of config, the file is opened on
+// the first write.
+func NewRotatingWriter(config RotateConfig) *RotatingWriter {
+	return newRotatingWriter(config, osFS{}, time.Now)
+}
+
+func newRotatingWriter(config RotateConfig, fs rotateFS, now func() time.Time) (w *RotatingWriter) {
+	w = &RotatingWriter{
+		config: config,
+		fs:     fs,
+		now:    now,
+		millCh: make(chan struct{}, 1),
+	}
+	w.millWg.Add(1)
+	go w.mill(w.millCh)
+	return
+}
+
+// Write implements io.Writer.Write, the file is rotated before p if p would exceed
+// MaxSize or the file is older than MaxAge.
+func (w *RotatingWriter) Write(p []byte) (n int, err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		if err = w.open(); err != nil {
+			return
+		}
+	}
+	if w.size > 0 && w.config.MaxSize > 0 && w.size+int64(len(p)) > w.config.MaxSize ||
+		w.config.MaxAge > 0 && w.now().Sub(w.openedAt) >= w.config.MaxAge {
+		if err = w.rotate(); err != nil {
+			return
+		}
+	}
+	n, err = w.file.Write(p)
+	w.size += int64(n)
+	return
+}
+
+// Rotate closes the current file and starts a new one.
+func (w *RotatingWriter) Rotate() (err error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.file == nil {
+		if err = w.open(); err != nil {
+			return
+		}
+	}
+	return w.rotate()
+}
+
+// Close closes the current file and waits for the background compression.
+func (w *RotatingWriter) Close() (err error) {
+	w.mu.Lock()
+	if w.millCh != nil {
+		close(w.millCh)
+		w.millCh = nil
+	}
+	if w.file != nil {
+		err = w.file.Close()
+		w.file = nil
+	}
+	w.mu.Unlock()
+	w.millWg.Wait()
+	return
+}
+
+func (w *RotatingWriter) open() (err error) {
+	if w.file, w.size, err = w.fs.OpenAppend(w.config.Filename); err != nil {
+		w.file = nil
+		return
+	}
+	w.openedAt = w.now()
+	return
+}
+
+func (w *RotatingWriter) rotate() (err error) {
+	if err = w.file.Close(); err != nil {
+		return
+	}
+	w.file = nil
+	if err = w.fs.Rename(w.config.Filename, w.backupName(w.now())); err != nil {
+		return
+	}
+	if err = w.open(); err != nil {
+		return
+	}
+	if w.millCh != nil {
+		select {
+		case w.millCh <- struct{}{}:
+		default:
+		}
+	}
+	return
+}
+
+func (w *RotatingWriter) backupName(t time.Time) string {
+	ext := filepath.Ext(w.config.Filename)
+	prefix := strings.TrimSuffix(w.config.Filename, ext)
+	return prefix + "-" + t.UTC().Format(backupTimeFormat) + ext
+}
+
+// backups returns the rotated files from the oldest to the newest.
+func (w *RotatingWriter) backups() (names []string, err error) {
+	ext := filepath.Ext(w.config.Filename)
+	prefix := strings.TrimSuffix(w.config.Filename, ext)
+	if names, err = w.fs.Glob(prefix + "-*" + ext + "*"); err != nil {
+		return
+	}
+	sort.Strings(names)
+	return
+}
+
+func (w *RotatingWriter) mill(millCh <-chan struct{}) {
+	defer w.millWg.Done()
+	for range millCh {
+		if err := w.compressAndPrune(); err != nil {
+			// the log output is this writer itself, report to stderr
+			_, _ = os.Stderr.WriteString("rotate log file failed: " + err.Error() + "\n")
+		}
+	}
+}
+
+func (w *RotatingWriter) compressAndPrune() (err error) {
+	var names []string
+	if names, err = w.backups(); err != nil {
+		return
+	}
+	if w.config.Compress {
+		for i, name := range names {
+			if strings.HasSuffix(name, compressSuffix) {
+				continue
+			}
+			if err = w.compress(name); err != nil {
+				return
+			}
+			names[i] = name + compressSuffix
+		}
+	}
+	if w.config.MaxBackups > 0 && len(names) > w.config.MaxBackups {
+		for _, name := range names[:len(names)-w.config.MaxBackups] {
+			if err = w.fs.Remove(name); err != nil {
+				return
+			}
+		}
+	}
+	return
+}
+
+func (w *RotatingWriter) compress(name string) (err error) {
+	src, err := w.fs.Open(name)
+	if err != nil {
+		return
+	}
+	defer func() { _ = src.Close() }()
+	dst, err := w.fs.Create(name + compressSuffix)
+	if err != nil {
+		return
+	}
+	gz := gzip.NewWriter(dst)
+	if _, err = io.Copy(gz, src); err == nil {
+		err = gz.Close()
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = w.fs.Remove(name + compressSuffix)
+		return
+	}
+	return w.fs.Remove(name)
+}
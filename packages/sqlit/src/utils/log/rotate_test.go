@@ -0,0 +1,264 @@
+
+package log
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memFS is an in memory rotateFS.
+type memFS struct {
+	sync.Mutex
+	files map[string]*bytes.Buffer
+}
+
+type memFile struct {
+	fs  *memFS
+	buf *bytes.Buffer
+}
+
+func (f *memFile) Write(p []byte) (int, error) {
+	f.fs.Lock()
+	defer f.fs.Unlock()
+	return f.buf.Write(p)
+}
+
+func (f *memFile) Close() error { return nil }
+
+func newMemFS() *memFS { return &memFS{files: make(map[string]*bytes.Buffer)} }
+
+func (m *memFS) OpenAppend(name string) (io.WriteCloser, int64, error) {
+	m.Lock()
+	defer m.Unlock()
+	buf, ok := m.files[name]
+	if !ok {
+		buf = &bytes.Buffer{}
+		m.files[name] = buf
+	}
+	return &memFile{fs: m, buf: buf}, int64(buf.Len()), nil
+}
+
+func (m *memFS) Open(name string) (io.ReadCloser, error) {
+	m.Lock()
+	defer m.Unlock()
+	buf, ok := m.files[name]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return io.NopCloser(bytes.NewReader(buf.Bytes())), nil
+}
+
+func (m *memFS) Create(name string) (io.WriteCloser, error) {
+	m.Lock()
+	defer m.Unlock()
+	buf := &bytes.Buffer{}
+	m.files[name] = buf
+	return &memFile{fs: m, buf: buf}, nil
+}
+
+func (m *memFS) Rename(oldName, newName string) error {
+	m.Lock()
+	defer m.Unlock()
+	buf, ok := m.files[oldName]
+	if !ok {
+		return os.ErrNotExist
+	}
+	delete(m.files, oldName)
+	m.files[newName] = buf
+	return nil
+}
+
+func (m *memFS) Remove(name string) error {
+	m.Lock()
+	defer m.Unlock()
+	delete(m.files, name)
+	return nil
+}
+
+func (m *memFS) Glob(pattern string) (matches []string, err error) {
+	m.Lock()
+	defer m.Unlock()
+	for name := range m.files {
+		if ok, _ := path.Match(pattern, name); ok {
+			matches = append(matches, name)
+		}
+	}
+	return
+}
+
+func (m *memFS) names() (names []string) {
+	m.Lock()
+	defer m.Unlock()
+	for name := range m.files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return
+}
+
+func (m *memFS) content(name string) string {
+	m.Lock()
+	defer m.Unlock()
+	return m.files[name].String()
+}
+
+type fakeClock struct {
+	sync.Mutex
+	t time.Time
+}
+
+func (c *fakeClock) now() time.Time {
+	c.Lock()
+	defer c.Unlock()
+	c.t = c.t.Add(time.Millisecond)
+	return c.t
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.Lock()
+	defer c.Unlock()
+	c.t = c.t.Add(d)
+}
+
+func TestRotatingWriterSize(t *testing.T) {
+	fs, clock := newMemFS(), &fakeClock{t: time.Unix(0, 0)}
+	w := newRotatingWriter(RotateConfig{Filename: "/log/node.log", MaxSize: 10}, fs, clock.now)
+	for _, line := range []string{"12345\n", "67890\n", "abcde\n"} {
+		if _, err := w.Write([]byte(line)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	names := fs.names()
+	if len(names) != 3 || names[2] != "/log/node.log" {
+		t.Fatalf("unexpected files %v", names)
+	}
+	if got := fs.content(names[0]) + fs.content(names[1]) + fs.content(names[2]); got != "12345\n67890\nabcde\n" {
+		t.Errorf("unexpected content %q", got)
+	}
+	if !strings.HasPrefix(names[0], "/log/node-") || !strings.HasSuffix(names[0], ".log") {
+		t.Errorf("unexpected backup name %s", names[0])
+	}
+}
+
+func TestRotatingWriterAgeBackupsCompress(t *testing.T) {
+	fs, clock := newMemFS(), &fakeClock{t: time.Unix(0, 0)}
+	w := newRotatingWriter(RotateConfig{
+		Filename:   "/log/node.log",
+		MaxAge:     time.Hour,
+		MaxBackups: 2,
+		Compress:   true,
+	}, fs, clock.now)
+	for i := 0; i < 4; i++ {
+		if _, err := fmt.Fprintf(w, "line %d\n", i); err != nil {
+			t.Fatal(err)
+		}
+		clock.advance(time.Hour)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	names := fs.names()
+	if len(names) != 3 {
+		t.Fatalf("expect 2 backups and the current file, got %v", names)
+	}
+	for i, name := range names[:2] {
+		if !strings.HasSuffix(name, ".log"+compressSuffix) {
+			t.Fatalf("backup not compressed %s", name)
+		}
+		r, err := gzip.NewReader(strings.NewReader(fs.content(name)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := fmt.Sprintf("line %d\n", i+1); string(out) != want {
+			t.Errorf("backup %s: expect %q, got %q", name, want, out)
+		}
+	}
+	if got := fs.content("/log/node.log"); got != "line 3\n" {
+		t.Errorf("unexpected current content %q", got)
+	}
+}
+
+func TestRotatingWriterConcurrent(t *testing.T) {
+	fs, clock := newMemFS(), &fakeClock{t: time.Unix(0, 0)}
+	w := newRotatingWriter(RotateConfig{Filename: "/log/node.log", MaxSize: 256}, fs, clock.now)
+	var (
+		wg      sync.WaitGroup
+		writers = 8
+		lines   = 100
+	)
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < lines; j++ {
+				if _, err := fmt.Fprintf(w, "writer %d line %03d\n", i, j); err != nil {
+					t.Error(err)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	var all []string
+	for _, name := range fs.names() {
+		content := fs.content(name)
+		if int64(len(content)) > 256 {
+			t.Errorf("file %s exceeds max size: %d", name, len(content))
+		}
+		all = append(all, strings.Split(strings.TrimSuffix(content, "\n"), "\n")...)
+	}
+	if len(all) != writers*lines {
+		t.Fatalf("expect %d lines, got %d", writers*lines, len(all))
+	}
+	for _, line := range all {
+		if !strings.HasPrefix(line, "writer ") || len(line) != len("writer 0 line 000") {
+			t.Fatalf("broken line %q", line)
+		}
+	}
+}
+
+func TestRotatingWriterOutput(t *testing.T) {
+	dir := t.TempDir()
+	w := NewRotatingWriter(RotateConfig{Filename: path.Join(dir, "sub", "node.log"), MaxSize: 1 << 20})
+	SetOutput(w)
+	defer SetOutput(os.Stderr)
+	SetLevel(InfoLevel)
+	Info("to rotating file")
+	if err := w.Rotate(); err != nil {
+		t.Fatal(err)
+	}
+	Info("after rotation")
+	SetOutput(os.Stderr)
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	current, err := os.ReadFile(path.Join(dir, "sub", "node.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(current), "after rotation") || strings.Contains(string(current), "to rotating file") {
+		t.Errorf("unexpected current file %q", current)
+	}
+	backups, err := w.backups()
+	if err != nil || len(backups) != 1 {
+		t.Fatalf("expect one backup, got %v %v", backups, err)
+	}
+}
@@ -0,0 +1,212 @@
+
+package log
+
+import (
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// SampleKeyKey is the field naming the sample key of the suppressed summary.
+	SampleKeyKey = "sample_key"
+	// SuppressedKey is the field counting the messages in the suppressed summary.
+	SuppressedKey = "suppressed"
+)
+
+var (
+	// SampleWindow is the window of the sampled log events, the suppressed summary of
+	// a key is logged when its window ends
+	SampleWindow = time.Second
+	// SampleEvery logs 1 of every SampleEvery events of a key within a window, the
+	// first one is always logged
+	SampleEvery uint64 = 100
+
+	sampleNow  = time.Now
+	sampleLock sync.Mutex
+	samples    = make(map[string]*sampleState)
+	suppressed = make(map[string]uint64)
+)
+
+// sampleState is the events of a sample key in the current window.
+type sampleState struct {
+	start      time.Time
+	count      uint64
+	suppressed uint64
+	level      logrus.Level
+	module     string
+	timer      *time.Timer
+}
+
+// SampledEntry is an entry rate limited by its sample key, see Sampled.
+type SampledEntry struct {
+	key   string
+	entry *Entry
+}
+
+// Sampled creates a sampled entry from the standard logger. The events of the same
+// key are rate limited to 1 of every SampleEvery within SampleWindow, the first
+// one is logged and a "suppressed M messages" summary follows the window.
+func Sampled(key string) *SampledEntry {
+	return &SampledEntry{key: key, entry: WithFields(nil)}
+}
+
+// Sampled creates a sampled entry of entry, see Sampled.
+func (entry *Entry) Sampled(key string) *SampledEntry {
+	return &SampledEntry{key: key, entry: entry}
+}
+
+// SuppressedCount returns the total number of the suppressed messages of key.
+func SuppressedCount(key string) uint64 {
+	sampleLock.Lock()
+	defer sampleLock.Unlock()
+	return suppressed[key]
+}
+
+// FlushSampled ends the windows of all sample keys and logs their suppressed summary.
+func FlushSampled() {
+	sampleLock.Lock()
+	defer sampleLock.Unlock()
+	for key, st := range samples {
+		flushSampleLocked(key, st)
+	}
+}
+
+// WithField adds a single field to the sampled entry.
+func (s *SampledEntry) WithField(key string, value interface{}) *SampledEntry {
+	return &SampledEntry{key: s.key, entry: s.entry.WithField(key, value)}
+}
+
+// WithFields adds a map of fields to the sampled entry.
+func (s *SampledEntry) WithFields(fields Fields) *SampledEntry {
+	return &SampledEntry{key: s.key, entry: s.entry.WithFields(fields)}
+}
+
+// WithError adds an error as single field to the sampled entry.
+func (s *SampledEntry) WithError(err error) *SampledEntry {
+	return &SampledEntry{key: s.key, entry: s.entry.WithError(err)}
+}
+
+// Debug records a sampled debug level log.
+func (s *SampledEntry) Debug(args ...interface{}) {
+	if s.sample(DebugLevel) {
+		s.entry.Debug(args...)
+	}
+}
+
+// Debugf records a sampled debug level log.
+func (s *SampledEntry) Debugf(format string, args ...interface{}) {
+	if s.sample(DebugLevel) {
+		s.entry.Debugf(format, args...)
+	}
+}
+
+// Info records a sampled info level log.
+func (s *SampledEntry) Info(args ...interface{}) {
+	if s.sample(InfoLevel) {
+		s.entry.Info(args...)
+	}
+}
+
+// Infof records a sampled info level log.
+func (s *SampledEntry) Infof(format string, args ...interface{}) {
+	if s.sample(InfoLevel) {
+		s.entry.Infof(format, args...)
+	}
+}
+
+// Warning records a sampled warning level log.
+func (s *SampledEntry) Warning(args ...interface{}) {
+	if s.sample(WarnLevel) {
+		s.entry.Warning(args...)
+	}
+}
+
+// Warningf records a sampled warning level log.
+func (s *SampledEntry) Warningf(format string, args ...interface{}) {
+	if s.sample(WarnLevel) {
+		s.entry.Warningf(format, args...)
+	}
+}
+
+// Error records a sampled error level log.
+func (s *SampledEntry) Error(args ...interface{}) {
+	if s.sample(ErrorLevel) {
+		s.entry.Error(args...)
+	}
+}
+
+// Errorf records a sampled error level log.
+func (s *SampledEntry) Errorf(format string, args ...interface{}) {
+	if s.sample(ErrorLevel) {
+		s.entry.Errorf(format, args...)
+	}
+}
+
+// sample returns if the event of level is logged, the events filtered by the module
+// or global level are not counted.
+func (s *SampledEntry) sample(level logrus.Level) bool {
+	module, hasModule := s.entry.Data[ModuleKey].(string)
+	enabled := GetLevel()
+	if hasModule {
+		enabled = GetModuleLevel(module)
+	}
+	if level > enabled {
+		return false
+	}
+
+	every := SampleEvery
+	if every == 0 {
+		every = 1
+	}
+	sampleLock.Lock()
+	defer sampleLock.Unlock()
+	now := sampleNow()
+	st := samples[s.key]
+	if st != nil && now.Sub(st.start) >= SampleWindow {
+		flushSampleLocked(s.key, st)
+		st = nil
+	}
+	if st == nil {
+		st = &sampleState{start: now}
+		samples[s.key] = st
+	}
+	st.count++
+	if (st.count-1)%every == 0 {
+		return true
+	}
+	st.suppressed++
+	suppressed[s.key]++
+	st.level = level
+	if hasModule {
+		st.module = module
+	}
+	if st.timer == nil {
+		key := s.key
+		st.timer = time.AfterFunc(SampleWindow-now.Sub(st.start), func() {
+			sampleLock.Lock()
+			defer sampleLock.Unlock()
+			if samples[key] == st {
+				flushSampleLocked(key, st)
+			}
+		})
+	}
+	return false
+}
+
+// flushSampleLocked ends the window of key and logs its suppressed summary.
+func flushSampleLocked(key string, st *sampleState) {
+	delete(samples, key)
+	if st.timer != nil {
+		st.timer.Stop()
+	}
+	if st.suppressed == 0 {
+		return
+	}
+	entry := WithFields(Fields{SampleKeyKey: key, SuppressedKey: st.suppressed})
+	if st.module != "" {
+		entry = entry.WithField(ModuleKey, st.module)
+	}
+	(*logrus.Entry)(entry).Logf(st.level, "suppressed %d messages", st.suppressed)
+}
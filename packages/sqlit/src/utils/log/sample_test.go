@@ -0,0 +1,79 @@
+
+package log
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSampled(t *testing.T) {
+	var buf bytes.Buffer
+	SetOutput(&buf)
+	defer SetOutput(os.Stderr)
+	SetLevel(DebugLevel)
+	defer SetLevel(InfoLevel)
+
+	now := time.Unix(0, 0)
+	defer func(every uint64, window time.Duration) {
+		SampleEvery, SampleWindow, sampleNow = every, window, time.Now
+	}(SampleEvery, SampleWindow)
+	SampleEvery, SampleWindow = 10, time.Hour
+	sampleNow = func() time.Time { return now }
+	FlushSampled()
+	buf.Reset()
+	before := SuppressedCount("hot loop")
+
+	for i := 0; i < 25; i++ {
+		Sampled("hot loop").WithField("i", i).Debugf("event %d", i)
+	}
+	// 0, 10 and 20 are logged
+	if got := strings.Count(buf.String(), "msg=\"event "); got != 3 {
+		t.Fatalf("expect 3 logged events, got %d: %q", got, buf.String())
+	}
+	if !strings.Contains(buf.String(), "event 0") || !strings.Contains(buf.String(), "event 20") {
+		t.Errorf("unexpected sampled events %q", buf.String())
+	}
+	if got := SuppressedCount("hot loop") - before; got != 22 {
+		t.Errorf("expect 22 suppressed, got %d", got)
+	}
+	if got := SuppressedCount("other"); got != 0 {
+		t.Errorf("expect 0 suppressed of other key, got %d", got)
+	}
+
+	// the next window logs the summary of the previous one first
+	buf.Reset()
+	now = now.Add(time.Hour)
+	Sampled("hot loop").Debug("next window")
+	out := buf.String()
+	summary := strings.Index(out, "suppressed 22 messages")
+	if summary < 0 || summary > strings.Index(out, "next window") {
+		t.Errorf("expect summary before the next window event, got %q", out)
+	}
+	if !strings.Contains(out, "sample_key=\"hot loop\"") {
+		t.Errorf("missing sample key in %q", out)
+	}
+
+	// filtered events are not counted
+	SetLevel(InfoLevel)
+	before = SuppressedCount("filtered")
+	for i := 0; i < 5; i++ {
+		Sampled("filtered").Debug("filtered")
+	}
+	if got := SuppressedCount("filtered") - before; got != 0 {
+		t.Errorf("expect filtered events not counted, got %d", got)
+	}
+
+	// module entries keep the module in the summary
+	buf.Reset()
+	for i := 0; i < 3; i++ {
+		WithModule("route").Sampled("module loop").Info("module event")
+	}
+	FlushSampled()
+	if !strings.Contains(buf.String(), "suppressed 2 messages") ||
+		!strings.Contains(buf.String(), "module=route sample_key=\"module loop\"") {
+		t.Errorf("unexpected module summary %q", buf.String())
+	}
+}
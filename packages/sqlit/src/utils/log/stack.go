@@ -0,0 +1,110 @@
+
+package log
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+
+	pkgerrors "github.com/pkg/errors"
+	"github.com/sirupsen/logrus"
+)
+
+// ErrorStackKey is the field of the stack trace where the error of an entry
+// originates, see SetErrorStackLevel.
+const ErrorStackKey = "error_stack"
+
+var (
+	errorStackLock  sync.RWMutex
+	errorStackLevel = DebugLevel
+)
+
+// stackTracer is implemented by the errors of github.com/pkg/errors carrying the
+// stack of their creation.
+type stackTracer interface {
+	StackTrace() pkgerrors.StackTrace
+}
+
+func init() {
+	AddHook(errorStackHook{})
+}
+
+// SetErrorStackLevel sets the least verbose log level rendering the stack traces of
+// the logged errors, DebugLevel by default so that the production logs stay terse.
+// The stack is of the innermost error carrying one in the error chain, errors
+// without stack are logged as is.
+func SetErrorStackLevel(level logrus.Level) {
+	errorStackLock.Lock()
+	defer errorStackLock.Unlock()
+	errorStackLevel = level
+}
+
+func getErrorStackLevel() logrus.Level {
+	errorStackLock.RLock()
+	defer errorStackLock.RUnlock()
+	return errorStackLevel
+}
+
+// errorStackHook adds the stack trace of the entry error to ErrorStackKey.
+type errorStackHook struct{}
+
+// Levels implements logrus.Hook.
+func (errorStackHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire implements logrus.Hook.
+func (errorStackHook) Fire(entry *logrus.Entry) error {
+	err, ok := entry.Data[logrus.ErrorKey].(error)
+	if !ok {
+		return nil
+	}
+	// the level of the entry module, or the global level without module
+	module, _ := entry.Data[ModuleKey].(string)
+	if GetModuleLevel(module) < getErrorStackLevel() {
+		return nil
+	}
+	if stack := errorStack(err); len(stack) > 0 {
+		entry.Data[ErrorStackKey] = stack
+	}
+	return nil
+}
+
+// errorStack returns the frames of the innermost stack in the chain of err, formatted
+// like the stack of CallerHook.
+func errorStack(err error) (frames []string) {
+	var tracer stackTracer
+	for err != nil {
+		if t, ok := err.(stackTracer); ok {
+			tracer = t
+		}
+		if c, ok := err.(interface{ Cause() error }); ok {
+			err = c.Cause()
+		} else {
+			err = errors.Unwrap(err)
+		}
+	}
+	if tracer == nil {
+		return
+	}
+	trace := tracer.StackTrace()
+	pcs := make([]uintptr, len(trace))
+	for i, f := range trace {
+		pcs[i] = uintptr(f)
+	}
+	callers := runtime.CallersFrames(pcs)
+	for i := 0; ; i++ {
+		f, more := callers.Next()
+		if f.Line > 0 {
+			frames = append(frames, fmt.Sprintf("#%d %s@%s:%d",
+				i, strings.TrimPrefix(f.Function, "sqlit/"), filepath.Base(f.File), f.Line))
+		}
+		if !more {
+			break
+		}
+	}
+	return
+}
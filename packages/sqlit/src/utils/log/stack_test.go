@@ -0,0 +1,80 @@
+
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	pkgerrors "github.com/pkg/errors"
+)
+
+func newStackError() error {
+	return pkgerrors.New("origin")
+}
+
+func TestErrorStack(t *testing.T) {
+	var buf bytes.Buffer
+	SetOutput(&buf)
+	defer SetOutput(os.Stderr)
+	defer func() { _ = SetFormat(TextFormat) }()
+	SetLevel(InfoLevel)
+	defer SetLevel(InfoLevel)
+	defer SetErrorStackLevel(DebugLevel)
+
+	wrapped := pkgerrors.Wrap(newStackError(), "init peers failed")
+
+	// terse unless the log level is verbose enough
+	WithError(wrapped).Error("terse")
+	if out := buf.String(); strings.Contains(out, ErrorStackKey) {
+		t.Errorf("unexpected stack in %q", out)
+	}
+
+	SetLevel(DebugLevel)
+	buf.Reset()
+	WithError(wrapped).Error("text")
+	out := buf.String()
+	if !strings.Contains(out, ErrorStackKey+"=") || !strings.Contains(out, "log.newStackError@stack_test.go") {
+		t.Errorf("missing origin stack in %q", out)
+	}
+	buf.Reset()
+	WithError(errors.New("plain")).Error("plain")
+	if out := buf.String(); strings.Contains(out, ErrorStackKey) || !strings.Contains(out, "error=plain") {
+		t.Errorf("unexpected plain error entry %q", out)
+	}
+
+	if err := SetFormat(JSONFormat); err != nil {
+		t.Fatal(err)
+	}
+	buf.Reset()
+	WithError(wrapped).Error("json")
+	var entry struct {
+		Error string   `json:"error"`
+		Stack []string `json:"error_stack"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatal(err, buf.String())
+	}
+	if entry.Error != "init peers failed: origin" || len(entry.Stack) == 0 ||
+		!strings.Contains(entry.Stack[0], "log.newStackError@stack_test.go") {
+		t.Errorf("unexpected json entry %+v", entry)
+	}
+
+	// the stack level is tunable, e.g. to keep the stacks at info level
+	SetLevel(InfoLevel)
+	SetErrorStackLevel(InfoLevel)
+	buf.Reset()
+	WithModule("route").WithError(wrapped).Warning("warned")
+	if !strings.Contains(buf.String(), ErrorStackKey) {
+		t.Errorf("missing stack in %q", buf.String())
+	}
+	SetErrorStackLevel(DebugLevel)
+	buf.Reset()
+	WithModule("route").WithError(wrapped).Warning("warned")
+	if strings.Contains(buf.String(), ErrorStackKey) {
+		t.Errorf("unexpected stack in %q", buf.String())
+	}
+}
@@ -0,0 +1,228 @@
+
+package log
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// syslogDialTimeout bounds the connecting so an unreachable endpoint never blocks
+	// the node for long.
+	syslogDialTimeout = time.Second
+	// syslogWriteTimeout bounds the writing of a record to a stream endpoint.
+	syslogWriteTimeout = time.Second
+)
+
+var (
+	// SyslogRetryInterval is the time the syslog endpoint is not dialed again after a
+	// failure, the records go to the fallback output meanwhile
+	SyslogRetryInterval = 5 * time.Second
+
+	syslogFacilities = map[string]int{
+		"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5,
+		"lpr": 6, "news": 7, "uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+		"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+		"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+	}
+
+	syslogSeverities = map[logrus.Level]int{
+		logrus.PanicLevel: 0, // emerg
+		logrus.FatalLevel: 2, // crit
+		logrus.ErrorLevel: 3, // err
+		logrus.WarnLevel:  4, // warning
+		logrus.InfoLevel:  6, // info
+		logrus.DebugLevel: 7, // debug
+		logrus.TraceLevel: 7, // debug
+	}
+)
+
+// SyslogConfig defines the syslog endpoint of a SyslogHook.
+type SyslogConfig struct {
+	// Network is "udp", "tcp", "unix" or "unixgram"
+	Network string
+	// Address is the endpoint address, e.g. "127.0.0.1:514" or "/dev/log"
+	Address string
+	// Facility is the syslog facility name, default is "daemon"
+	Facility string
+	// Tag is the APP-NAME of the records, default is the program name
+	Tag string
+	// Exclusive sends the log to syslog instead of the local output, the local output
+	// is only written when syslog is unreachable
+	Exclusive bool
+}
+
+// SyslogHook sends the log entries to a syslog endpoint as RFC 5424 records, the
+// message is formatted by the standard logger formatter. The stream endpoints are
+// framed by octet counting of RFC 6587. A failed write redials once, then the
+// endpoint is skipped for SyslogRetryInterval and the record goes to the fallback
+// output if any.
+type SyslogHook struct {
+	config   SyslogConfig
+	facility int
+	hostname string
+	tag      string
+	fallback io.Writer
+	dial     func(network, address string) (net.Conn, error)
+	now      func() time.Time
+
+	mu      sync.Mutex
+	conn    net.Conn
+	retryAt time.Time
+}
+
+// NewSyslogHook returns a new SyslogHook of config, the endpoint is dialed on the
+// first entry. fallback is written when the endpoint is unreachable, nil drops the
+// records.
+func NewSyslogHook(config SyslogConfig, fallback io.Writer) (hook *SyslogHook, err error) {
+	switch config.Network {
+	case "udp", "udp4", "udp6", "tcp", "tcp4", "tcp6", "unix", "unixgram":
+	default:
+		err = fmt.Errorf("unknown syslog network %q", config.Network)
+		return
+	}
+	if config.Address == "" {
+		err = fmt.Errorf("empty syslog address")
+		return
+	}
+	if config.Facility == "" {
+		config.Facility = "daemon"
+	}
+	facility, ok := syslogFacilities[config.Facility]
+	if !ok {
+		err = fmt.Errorf("unknown syslog facility %q", config.Facility)
+		return
+	}
+	hostname, _ := os.Hostname()
+	if hostname == "" {
+		hostname = "-"
+	}
+	tag := config.Tag
+	if tag == "" {
+		tag = filepath.Base(os.Args[0])
+	}
+	hook = &SyslogHook{
+		config:   config,
+		facility: facility,
+		hostname: hostname,
+		tag:      tag,
+		fallback: fallback,
+		dial: func(network, address string) (net.Conn, error) {
+			return net.DialTimeout(network, address, syslogDialTimeout)
+		},
+		now: time.Now,
+	}
+	return
+}
+
+// EnableSyslog adds a SyslogHook of config to the standard logger. An exclusive
+// config replaces the current output, which becomes the fallback.
+func EnableSyslog(config SyslogConfig) (hook *SyslogHook, err error) {
+	var fallback io.Writer
+	if config.Exclusive {
+		fallback = logrus.StandardLogger().Out
+	}
+	if hook, err = NewSyslogHook(config, fallback); err != nil {
+		return
+	}
+	if config.Exclusive {
+		SetOutput(&NilWriter{})
+	}
+	AddHook(hook)
+	return
+}
+
+// Levels implements logrus.Hook.Levels.
+func (h *SyslogHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire implements logrus.Hook.Fire.
+func (h *SyslogHook) Fire(entry *logrus.Entry) (err error) {
+	if _, discarded := entry.Logger.Formatter.(*NilFormatter); discarded {
+		// filtered by the module or package level
+		return
+	}
+	msg, err := entry.Logger.Formatter.Format(entry)
+	if err != nil {
+		return
+	}
+	h.write(entry.Level, entry.Time, msg)
+	return
+}
+
+// Close closes the connection to the endpoint.
+func (h *SyslogHook) Close() (err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.conn != nil {
+		err = h.conn.Close()
+		h.conn = nil
+	}
+	return
+}
+
+func (h *SyslogHook) write(level logrus.Level, t time.Time, msg []byte) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	record := h.record(level, t, msg)
+	for attempt := 0; attempt < 2; attempt++ {
+		if h.conn == nil && !h.connectLocked() {
+			break
+		}
+		if err := h.sendLocked(record); err == nil {
+			return
+		}
+		_ = h.conn.Close()
+		h.conn = nil
+	}
+	h.retryAt = h.now().Add(SyslogRetryInterval)
+	if h.fallback != nil {
+		_, _ = h.fallback.Write(msg)
+	}
+}
+
+func (h *SyslogHook) connectLocked() bool {
+	if h.now().Before(h.retryAt) {
+		return false
+	}
+	conn, err := h.dial(h.config.Network, h.config.Address)
+	if err != nil {
+		return false
+	}
+	h.conn = conn
+	return true
+}
+
+func (h *SyslogHook) sendLocked(record []byte) (err error) {
+	if h.isStream() {
+		_ = h.conn.SetWriteDeadline(time.Now().Add(syslogWriteTimeout))
+		record = append([]byte(strconv.Itoa(len(record))+" "), record...)
+	}
+	_, err = h.conn.Write(record)
+	return
+}
+
+func (h *SyslogHook) isStream() bool {
+	return strings.HasPrefix(h.config.Network, "tcp") || h.config.Network == "unix"
+}
+
+// record formats msg as a RFC 5424 record without structured data.
+func (h *SyslogHook) record(level logrus.Level, t time.Time, msg []byte) []byte {
+	severity, ok := syslogSeverities[level]
+	if !ok {
+		severity = 7
+	}
+	header := fmt.Sprintf("<%d>1 %s %s %s %d - - ",
+		h.facility*8+severity, t.UTC().Format(time.RFC3339Nano), h.hostname, h.tag, os.Getpid())
+	return append([]byte(header), []byte(strings.TrimRight(string(msg), "\n"))...)
+}
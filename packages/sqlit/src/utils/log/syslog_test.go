@@ -0,0 +1,203 @@
+
+package log
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func newSyslogTestLogger(hook *SyslogHook) *logrus.Logger {
+	logger := logrus.New()
+	logger.Out = io.Discard
+	logger.Formatter = &logrus.TextFormatter{DisableTimestamp: true}
+	logger.AddHook(hook)
+	return logger
+}
+
+// readFrame reads an octet counted frame of RFC 6587.
+func readFrame(r *bufio.Reader) (frame string, err error) {
+	size, err := r.ReadString(' ')
+	if err != nil {
+		return
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(size))
+	if err != nil {
+		return
+	}
+	buf := make([]byte, n)
+	if _, err = io.ReadFull(r, buf); err != nil {
+		return
+	}
+	return string(buf), nil
+}
+
+func TestSyslogTCP(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	frames := make(chan string, 4)
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func(conn net.Conn) {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				for {
+					frame, err := readFrame(r)
+					if err != nil {
+						return
+					}
+					frames <- frame
+				}
+			}(conn)
+		}
+	}()
+
+	hook, err := NewSyslogHook(SyslogConfig{
+		Network: "tcp", Address: ln.Addr().String(), Facility: "local0", Tag: "sqlitd",
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hook.Close()
+	logger := newSyslogTestLogger(hook)
+	logger.WithField("node", "abc").Warning("first line")
+	logger.Error("second\nline")
+
+	for i, want := range []string{
+		`<132>1 `, // local0 * 8 + warning
+		`<131>1 `, // local0 * 8 + err
+	} {
+		select {
+		case frame := <-frames:
+			if !strings.HasPrefix(frame, want) {
+				t.Errorf("frame %d: unexpected priority %q", i, frame)
+			}
+			if !strings.Contains(frame, " sqlitd ") {
+				t.Errorf("frame %d: missing tag %q", i, frame)
+			}
+			if i == 0 && !strings.HasSuffix(frame, `level=warning msg="first line" node=abc`) {
+				t.Errorf("frame %d: unexpected message %q", i, frame)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("frame %d not received", i)
+		}
+	}
+}
+
+func TestSyslogUDP(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	hook, err := NewSyslogHook(SyslogConfig{Network: "udp", Address: pc.LocalAddr().String()}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hook.Close()
+	newSyslogTestLogger(hook).Info("datagram")
+
+	buf := make([]byte, 4096)
+	_ = pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// a datagram is a single record without the octet count
+	if frame := string(buf[:n]); !strings.HasPrefix(frame, "<30>1 ") || !strings.HasSuffix(frame, "msg=datagram") {
+		t.Errorf("unexpected datagram %q", frame)
+	}
+}
+
+func TestSyslogFallback(t *testing.T) {
+	// a closed port refuses the connection
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	var fallback bytes.Buffer
+	hook, err := NewSyslogHook(SyslogConfig{Network: "tcp", Address: addr}, &fallback)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(0, 0)
+	hook.now = func() time.Time { return now }
+	dials := 0
+	dial := hook.dial
+	hook.dial = func(network, address string) (net.Conn, error) {
+		dials++
+		return dial(network, address)
+	}
+	logger := newSyslogTestLogger(hook)
+
+	start := time.Now()
+	logger.Info("unreachable")
+	logger.Info("still unreachable")
+	if time.Since(start) > 3*time.Second {
+		t.Error("unreachable syslog blocks the logging")
+	}
+	if got := fallback.String(); !strings.Contains(got, "msg=unreachable") ||
+		!strings.Contains(got, `msg="still unreachable"`) {
+		t.Errorf("expect fallback output, got %q", got)
+	}
+	if dials != 1 {
+		t.Errorf("expect no redial within the retry interval, got %d dials", dials)
+	}
+
+	// the endpoint is dialed again after the retry interval
+	ln, err = net.Listen("tcp", addr)
+	if err != nil {
+		t.Skipf("relisten %s failed: %v", addr, err)
+	}
+	defer ln.Close()
+	accepted := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		if frame, err := readFrame(bufio.NewReader(conn)); err == nil {
+			accepted <- frame
+		}
+	}()
+	now = now.Add(SyslogRetryInterval)
+	fallback.Reset()
+	logger.Info("reconnected")
+	select {
+	case frame := <-accepted:
+		if !strings.HasSuffix(frame, "msg=reconnected") {
+			t.Errorf("unexpected frame %q", frame)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("frame not received after reconnect")
+	}
+	if fallback.Len() != 0 {
+		t.Errorf("unexpected fallback output %q", fallback.String())
+	}
+	hook.Close()
+
+	if _, err = NewSyslogHook(SyslogConfig{Network: "tcp", Address: addr, Facility: "nope"}, nil); err == nil {
+		t.Error("expect unknown facility error")
+	}
+	if _, err = NewSyslogHook(SyslogConfig{Network: "smoke", Address: addr}, nil); err == nil {
+		t.Error("expect unknown network error")
+	}
+}